@@ -1,10 +1,12 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"tuna/internal/compiler"
 	"tuna/internal/formatter"
@@ -26,6 +28,10 @@ func main() {
 		launchCmd(os.Args[2:])
 	case "format":
 		formatCmd(os.Args[2:])
+	case "migrate":
+		migrateCmd(os.Args[2:])
+	case "schema":
+		schemaCmd(os.Args[2:])
 	default:
 		usage()
 		os.Exit(1)
@@ -36,6 +42,7 @@ func buildCmd(args []string) {
 	fs := flag.NewFlagSet("build", flag.ExitOnError)
 	out := fs.String("o", "", "出力ファイルのベース名（入力ファイルと同じフォルダに生成）")
 	backend := fs.String("backend", string(compiler.BackendGC), "バックエンド（gc|host）")
+	target := fs.String("target", string(compiler.TargetTuna), "実行環境（tuna|wasi）。wasi は wasmtime などの WASI ランタイムで動く .wasm を出力する")
 	_ = fs.Parse(args)
 	if fs.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "入力ファイルが必要です")
@@ -52,6 +59,10 @@ func buildCmd(args []string) {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	if err := comp.SetTarget(compiler.Target(*target)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	res, err := comp.Compile(entry)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -79,6 +90,10 @@ func buildCmd(args []string) {
 func runCmd(args []string) {
 	fs := flag.NewFlagSet("run", flag.ExitOnError)
 	backend := fs.String("backend", string(compiler.BackendGC), "バックエンド（gc|host）")
+	target := fs.String("target", string(compiler.TargetTuna), "実行環境（tuna|wasi）")
+	workers := fs.Int("workers", 1, "HTTPハンドラーを並行実行するWASMインスタンス数")
+	sqlTrace := fs.Bool("sql-trace", false, "SQLブロックのクエリをパラメーター・行数・所要時間とともに標準エラー出力に記録する")
+	sqlSlow := fs.Duration("sql-slow", 0, "この時間以上かかったクエリを警告する（例: 100ms）")
 	_ = fs.Parse(args)
 	if fs.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "入力ファイルが必要です")
@@ -92,26 +107,168 @@ func runCmd(args []string) {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	if err := comp.SetTarget(compiler.Target(*target)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	res, err := comp.Compile(entry)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 	runner := runtime.NewRunner()
-	out, err := runner.RunWithArgs(res.Wasm, scriptArgs)
-	if err != nil {
+	if err := runner.SetWorkers(*workers); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	fmt.Print(out)
+	if err := runner.SetSQLTrace(*sqlTrace, *sqlSlow); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	runner.SetStdout(os.Stdout)
+	_, err = runner.RunWithArgs(res.Wasm, scriptArgs)
+	exitOnRunError(err)
 }
 
 func usage() {
 	fmt.Fprintln(os.Stderr, "使い方:")
-	fmt.Fprintln(os.Stderr, "  tuna build [--backend gc|host] <entry.tuna> [-o <name>]")
-	fmt.Fprintln(os.Stderr, "  tuna run [--backend gc|host] <entry.tuna> [args...]")
-	fmt.Fprintln(os.Stderr, "  tuna launch <entry.wasm> [args...]")
+	fmt.Fprintln(os.Stderr, "  tuna build [--backend gc|host] [--target tuna|wasi] <entry.tuna> [-o <name>]")
+	fmt.Fprintln(os.Stderr, "  tuna run [--backend gc|host] [--target tuna|wasi] [--workers N] [--sql-trace] [--sql-slow D] <entry.tuna> [args...]")
+	fmt.Fprintln(os.Stderr, "  tuna launch [--workers N] [--sql-trace] [--sql-slow D] <entry.wasm> [args...]")
 	fmt.Fprintln(os.Stderr, "  tuna format <file.tuna> [--write]")
+	fmt.Fprintln(os.Stderr, "  tuna migrate status|up|diff --db <file.db> <entry.tuna>")
+	fmt.Fprintln(os.Stderr, "  tuna schema <module.tuna> <TypeName>")
+	fmt.Fprintln(os.Stderr, "  tuna schema import <schema.json> [--name <TypeName>]")
+}
+
+func migrateCmd(args []string) {
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+	action := args[0]
+	switch action {
+	case "status", "up", "diff":
+	default:
+		fmt.Fprintf(os.Stderr, "不明な migrate サブコマンドです: %s\n", action)
+		usage()
+		os.Exit(1)
+	}
+	fs := flag.NewFlagSet("migrate "+action, flag.ExitOnError)
+	dbPath := fs.String("db", "", "対象のデータベースファイル")
+	_ = fs.Parse(args[1:])
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "入力ファイルが必要です")
+		os.Exit(1)
+	}
+	if *dbPath == "" {
+		fmt.Fprintln(os.Stderr, "--db でデータベースファイルを指定してください")
+		os.Exit(1)
+	}
+	comp := compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	res, err := comp.Compile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	migrator, err := runtime.OpenMigrator(*dbPath, res.Schema)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer migrator.Close()
+
+	switch action {
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(statuses) == 0 {
+			fmt.Println("マイグレーションはありません")
+			return
+		}
+		for _, st := range statuses {
+			if st.Applied {
+				fmt.Printf("適用済み  %s  (%s)\n", st.Name, st.AppliedAt)
+			} else {
+				fmt.Printf("未適用    %s\n", st.Name)
+			}
+		}
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(applied) == 0 {
+			fmt.Println("適用するマイグレーションはありません")
+			return
+		}
+		for _, name := range applied {
+			fmt.Printf("適用しました  %s\n", name)
+		}
+	case "diff":
+		diff, err := migrator.Diff()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if diff == "" {
+			fmt.Println("-- 差分はありません")
+			return
+		}
+		fmt.Println(diff)
+	}
+}
+
+func schemaCmd(args []string) {
+	if len(args) > 0 && args[0] == "import" {
+		schemaImportCmd(args[1:])
+		return
+	}
+	if len(args) != 2 {
+		usage()
+		os.Exit(1)
+	}
+	out, err := compiler.New().JSONSchema(args[0], args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Print(out)
+}
+
+func schemaImportCmd(args []string) {
+	fs := flag.NewFlagSet("schema import", flag.ExitOnError)
+	name := fs.String("name", "", "ルートの型エイリアス名（既定はスキーマの title、無ければファイル名）")
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "入力ファイルが必要です")
+		os.Exit(1)
+	}
+	file := fs.Arg(0)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	typeName := *name
+	if typeName == "" {
+		base := filepath.Base(file)
+		typeName = strings.TrimSuffix(strings.TrimSuffix(base, filepath.Ext(base)), ".schema")
+	}
+	mod, err := compiler.ImportJSONSchema(data, typeName, *name != "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+		os.Exit(1)
+	}
+	fmt.Print(formatter.New().FormatModule(mod))
 }
 
 func parseBackend(name string) compiler.Backend {
@@ -127,6 +284,9 @@ func parseBackend(name string) compiler.Backend {
 
 func launchCmd(args []string) {
 	fs := flag.NewFlagSet("launch", flag.ExitOnError)
+	workers := fs.Int("workers", 1, "HTTPハンドラーを並行実行するWASMインスタンス数")
+	sqlTrace := fs.Bool("sql-trace", false, "SQLブロックのクエリをパラメーター・行数・所要時間とともに標準エラー出力に記録する")
+	sqlSlow := fs.Duration("sql-slow", 0, "この時間以上かかったクエリを警告する（例: 100ms）")
 	_ = fs.Parse(args)
 	if fs.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "入力ファイルが必要です")
@@ -140,12 +300,30 @@ func launchCmd(args []string) {
 		os.Exit(1)
 	}
 	runner := runtime.NewRunner()
-	out, err := runner.RunWithArgs(wasm, scriptArgs)
-	if err != nil {
+	if err := runner.SetWorkers(*workers); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	fmt.Print(out)
+	if err := runner.SetSQLTrace(*sqlTrace, *sqlSlow); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	runner.SetStdout(os.Stdout)
+	_, err = runner.RunWithArgs(wasm, scriptArgs)
+	exitOnRunError(err)
+}
+
+// exitOnRunError は実行エラーを表示して終了する。proc_exit の終了コードはそのまま返す。
+func exitOnRunError(err error) {
+	if err == nil {
+		return
+	}
+	var exitErr *runtime.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
 }
 
 func formatCmd(args []string) {
@@ -19,6 +19,7 @@ type ImportDecl struct {
 	DefaultName string
 	Items       []ImportItem
 	From        string
+	AsDir       bool // true if imported with "as dir" (embedded directory bundle)
 	Span        Span
 }
 
@@ -65,23 +66,56 @@ func (d *ExternFuncDecl) GetSpan() Span { return d.Span }
 
 // TableDecl represents a table definition: table tableName { column definitions }
 type TableDecl struct {
-	Name    string
-	Columns []TableColumn
-	Span    Span
+	Name        string
+	Connection  string // create_table conn.tableName のときの接続名（空ならデフォルト接続）
+	Columns     []TableColumn
+	Constraints []TableConstraint // UNIQUE (a, b) / FOREIGN KEY ... などのテーブル制約
+	Span        Span
 }
 
 func (*TableDecl) declNode()       {}
 func (d *TableDecl) GetSpan() Span { return d.Span }
 
-// TypeAliasDecl represents a type alias: type Name = TypeExpr
+// IndexDecl represents an index definition: create_index [unique] name on table (columns)
+type IndexDecl struct {
+	Name       string
+	Table      string
+	Connection string // on conn.table のときの接続名（空ならデフォルト接続）
+	Unique     bool
+	Columns    []string
+	Span       Span
+}
+
+func (*IndexDecl) declNode()       {}
+func (d *IndexDecl) GetSpan() Span { return d.Span }
+
+// MigrationDecl represents a schema migration: migration "name" { SQL }
+type MigrationDecl struct {
+	Name string
+	SQL  string
+	Span Span
+}
+
+func (*MigrationDecl) declNode()       {}
+func (d *MigrationDecl) GetSpan() Span { return d.Span }
+
+// TypeAliasDecl represents a type alias: type Name = TypeExpr [where { ... }]
 type TypeAliasDecl struct {
 	Name       string
 	Export     bool
 	TypeParams []string
 	Type       TypeExpr
+	Where      []TypeRefinement // where { min_length: 1, ... } の制約（decode 時に検査する）
 	Span       Span
 }
 
+// TypeRefinement is one constraint in a type alias where clause.
+type TypeRefinement struct {
+	Name  string
+	Value Expr
+	Span  Span
+}
+
 func (*TypeAliasDecl) declNode()       {}
 func (d *TypeAliasDecl) GetSpan() Span { return d.Span }
 
@@ -92,6 +126,16 @@ type TableColumn struct {
 	Constraints string // PRIMARY KEY, NOT NULL, DEFAULT, etc.
 }
 
+// TableConstraint represents a table constraint in a create_table block.
+type TableConstraint struct {
+	Kind       string   // "primary_key", "unique", "foreign_key", "check"
+	Name       string   // CONSTRAINT name（無ければ空）
+	Columns    []string // 対象の列（CHECK では空）
+	RefTable   string   // FOREIGN KEY の参照先テーブル
+	RefColumns []string // FOREIGN KEY の参照先の列（省略時は空）
+	SQL        string   // CREATE TABLE に書く定義そのもの
+}
+
 type Param struct {
 	Name string
 	Type TypeExpr
@@ -451,6 +495,17 @@ type BlockExpr struct {
 func (*BlockExpr) exprNode()       {}
 func (e *BlockExpr) GetSpan() Span { return e.Span }
 
+// TransactionExpr represents a transaction block: transaction { ... }
+// The block runs inside a database transaction (a savepoint when nested) and evaluates to
+// the value of its last expression statement, or the error that left the block.
+type TransactionExpr struct {
+	Body *BlockStmt
+	Span Span
+}
+
+func (*TransactionExpr) exprNode()       {}
+func (e *TransactionExpr) GetSpan() Span { return e.Span }
+
 type ArrowFunc struct {
 	Params []Param
 	Ret    TypeExpr
@@ -471,15 +526,20 @@ const (
 	SQLQueryFetchOptional                     // fetch_optional { } - returns 0 or 1 row
 	SQLQueryFetchOne                          // fetch_one { } - returns exactly 1 row
 	SQLQueryFetch                             // fetch { } - returns iterator (same as fetch_all for now)
+	SQLQueryFetchIter                         // fetch_iter { } - rows read lazily by for ... of
 )
 
 // SQLExpr represents a raw SQL block: sql { SELECT * FROM ... }
 // Parameters can be embedded using {expr} syntax, which are replaced with ? placeholders
 type SQLExpr struct {
-	Kind   SQLQueryKind // The kind of query (execute, fetch_optional, fetch_one, fetch, fetch_all)
-	Query  string       // SQL query text with ? placeholders
-	Params []Expr       // Parameter expressions extracted from {expr}
-	Span   Span
+	Kind           SQLQueryKind // The kind of query (execute, fetch_optional, fetch_one, fetch, fetch_all)
+	Query          string       // SQL query text with ? placeholders
+	Params         []Expr       // Parameter expressions extracted from {expr}
+	QueryPositions []Position   // Query の各バイトに対応するソース上の位置（SQL 検証エラーの位置）
+	ParamOffsets   []int        // Params を置き換えた ? の Query 内オフセット
+	Conn           Expr         // execute(conn) { ... } の接続（nil ならデフォルト接続）
+	RowType        TypeExpr     // fetch_all<T> { ... } の行の型（nil なら列定義から推論）
+	Span           Span
 }
 
 func (*SQLExpr) exprNode()       {}
@@ -612,6 +672,7 @@ type TypeProp struct {
 	Key       string
 	KeyQuoted bool
 	Type      TypeExpr
+	Default   Expr // key: T = value のデフォルト値（decode でフィールドが無いときに使う）
 	Span      Span
 }
 
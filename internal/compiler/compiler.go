@@ -14,8 +14,9 @@ import (
 )
 
 type Result struct {
-	Wat  string
-	Wasm []byte
+	Wat    string
+	Wasm   []byte
+	Schema string // create_table と migration の JSON（スキーマがなければ空）
 }
 
 type Backend string
@@ -25,9 +26,22 @@ const (
 	BackendHost Backend = "host"
 )
 
+// Target は生成した .wasm を動かす環境。
+type Target string
+
+const (
+	// TargetTuna は tuna run / launch の Runner で動かす（server・host などの独自 import を使う）。
+	TargetTuna Target = "tuna"
+	// TargetWASI は wasi_snapshot_preview1 の import だけを使い、wasmtime などの WASI ランタイムでそのまま動かす。
+	TargetWASI Target = "wasi"
+)
+
+const wasiImportModule = "wasi_snapshot_preview1"
+
 type Compiler struct {
 	Modules    map[string]*ast.Module
 	backend    Backend
+	target     Target
 	libDir     string
 	libModules map[string]string
 	moduleWAT  map[string]string
@@ -37,6 +51,7 @@ func New() *Compiler {
 	return &Compiler{
 		Modules: map[string]*ast.Module{},
 		backend: BackendGC,
+		target:  TargetTuna,
 	}
 }
 
@@ -50,23 +65,69 @@ func (c *Compiler) SetBackend(backend Backend) error {
 	}
 }
 
+func (c *Compiler) SetTarget(target Target) error {
+	switch target {
+	case TargetTuna, TargetWASI:
+		c.target = target
+		return nil
+	default:
+		return fmt.Errorf("unsupported target: %s", target)
+	}
+}
+
 func (c *Compiler) Compile(entry string) (*Result, error) {
-	abs, err := filepath.Abs(entry)
+	if c.target == TargetWASI && c.backend != BackendGC {
+		return nil, fmt.Errorf("target wasi requires the gc backend")
+	}
+	abs, checker, err := c.check(entry)
 	if err != nil {
 		return nil, err
 	}
-	if err := c.ensureLibIndex(abs); err != nil {
+	gen := NewGenerator(checker)
+	gen.SetModuleWATs(c.moduleWAT)
+	gen.SetBackend(c.backend)
+	gen.SetTarget(c.target)
+	wat, err := gen.Generate(abs)
+	if err != nil {
 		return nil, err
 	}
-	if err := c.loadBuiltinModule("prelude"); err != nil {
+	if c.target == TargetWASI {
+		if err := checkWASIImports(wat); err != nil {
+			return nil, err
+		}
+	}
+	wasm, err := gen.WatToWasm(wat)
+	if err != nil {
 		return nil, err
 	}
+	result := &Result{Wat: wat, Wasm: wasm}
+	if gen.hasSchema() {
+		result.Schema = gen.SchemaJSON()
+	}
+	return result, nil
+}
+
+// check はエントリーと依存モジュールを読み込んで型検査し、エントリーの絶対パスとチェッカーを返す。
+func (c *Compiler) check(entry string) (string, *types.Checker, error) {
+	abs, err := filepath.Abs(entry)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := c.ensureLibIndex(abs); err != nil {
+		return "", nil, err
+	}
+	if err := c.loadBuiltinModule("prelude"); err != nil {
+		return "", nil, err
+	}
 	if err := c.loadRecursive(abs); err != nil {
-		return nil, err
+		return "", nil, err
+	}
+	if err := c.loadMigrationDir(abs); err != nil {
+		return "", nil, err
 	}
 	if c.needsSqliteModule() {
 		if err := c.loadBuiltinModule("sqlite"); err != nil {
-			return nil, err
+			return "", nil, err
 		}
 	}
 	checker := types.NewChecker()
@@ -74,20 +135,43 @@ func (c *Compiler) Compile(entry string) (*Result, error) {
 		checker.AddModule(mod)
 	}
 	if !checker.Check() {
-		return nil, checker.Errors[0]
+		return "", nil, checker.Errors[0]
 	}
-	gen := NewGenerator(checker)
-	gen.SetModuleWATs(c.moduleWAT)
-	gen.SetBackend(c.backend)
-	wat, err := gen.Generate(abs)
-	if err != nil {
-		return nil, err
+	return abs, checker, nil
+}
+
+// loadMigrationDir adds migrations/*.sql next to the entry file to the entry module.
+// The file name without the extension becomes the migration name.
+func (c *Compiler) loadMigrationDir(entryAbs string) error {
+	mod := c.Modules[entryAbs]
+	if mod == nil {
+		return nil
 	}
-	wasm, err := gen.WatToWasm(wat)
+	dir := filepath.Join(filepath.Dir(entryAbs), "migrations")
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return nil, err
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
 	}
-	return &Result{Wat: wat, Wasm: wasm}, nil
+	for _, entry := range entries {
+		if entry.IsDir() || strings.ToLower(filepath.Ext(entry.Name())) != ".sql" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		mod.Decls = append(mod.Decls, &ast.MigrationDecl{
+			Name: name,
+			SQL:  strings.TrimSpace(string(src)),
+			Span: ast.Span{Start: ast.Position{Line: 1, Col: 1}, End: ast.Position{Line: 1, Col: 1}},
+		})
+	}
+	return nil
 }
 
 func (c *Compiler) ensureLibIndex(entryAbs string) error {
@@ -123,11 +207,19 @@ func (c *Compiler) loadBuiltinModule(name string) error {
 	if _, ok := c.Modules[name]; ok {
 		return nil
 	}
+	if c.target == TargetWASI && !wasiModuleAvailable(name) {
+		return fmt.Errorf("module %s is not available for target wasi", name)
+	}
 	if c.moduleNeedsHostBridge(name) {
 		if err := c.loadBuiltinModule("interop"); err != nil {
 			return err
 		}
 	}
+	for _, dep := range builtinModuleDeps(name) {
+		if err := c.loadBuiltinModule(dep); err != nil {
+			return err
+		}
+	}
 	if c.libModules == nil {
 		c.libModules = map[string]string{}
 	}
@@ -219,6 +311,17 @@ func (c *Compiler) loadRecursive(path string) error {
 	dir := filepath.Dir(path)
 	for i := range mod.Imports {
 		imp := &mod.Imports[i]
+		if imp.AsDir {
+			resolved, err := resolveDirImport(dir, imp.From)
+			if err != nil {
+				return err
+			}
+			imp.From = resolved
+			if err := c.loadDirModule(resolved); err != nil {
+				return err
+			}
+			continue
+		}
 		resolved, err := c.resolveImport(dir, imp.From)
 		if err != nil {
 			return err
@@ -310,7 +413,8 @@ func moduleNeedsSqlite(mod *ast.Module) bool {
 		return false
 	}
 	for _, decl := range mod.Decls {
-		if _, ok := decl.(*ast.TableDecl); ok {
+		switch decl.(type) {
+		case *ast.TableDecl, *ast.MigrationDecl, *ast.IndexDecl:
 			return true
 		}
 		if declNeedsSqlite(decl) {
@@ -432,6 +536,8 @@ func exprNeedsSqlite(expr ast.Expr) bool {
 			return exprNeedsSqlite(e.Expr)
 		}
 		return blockNeedsSqlite(e.Body)
+	case *ast.TransactionExpr:
+		return true
 	case *ast.JSXElement:
 		return jsxElementNeedsSqlite(e)
 	case *ast.JSXFragment:
@@ -508,6 +614,79 @@ func (c *Compiler) loadTextModule(path string) error {
 	return nil
 }
 
+// loadDirModule は `import assets from "./public/" as dir` 用に、ディレクトリ内の全ファイルを
+// `default: Map<string>`（相対パス -> ファイル内容）としてコンパイル時に埋め込む。
+// go:embed と同様に `.` / `_` で始まるファイル・ディレクトリは除外する。
+func (c *Compiler) loadDirModule(path string) error {
+	if _, ok := c.Modules[path]; ok {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("import as dir: %s is not a directory", path)
+	}
+	var entries []ast.ObjectEntry
+	err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == path {
+			return nil
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, ast.ObjectEntry{
+			Kind:      ast.ObjectProp,
+			Key:       filepath.ToSlash(rel),
+			KeyQuoted: true,
+			Value:     &ast.StringLit{Value: string(content)},
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	mod := &ast.Module{
+		Path: path,
+		Decls: []ast.Decl{
+			&ast.ConstDecl{
+				Name:   "default",
+				Export: true,
+				Type:   &ast.GenericType{Name: "Map", Args: []ast.TypeExpr{&ast.NamedType{Name: "string"}}},
+				Init:   &ast.ObjectLit{Entries: entries},
+			},
+		},
+	}
+	c.Modules[path] = mod
+	return nil
+}
+
+func resolveDirImport(baseDir, spec string) (string, error) {
+	if strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") {
+		return filepath.Clean(filepath.Join(baseDir, spec)), nil
+	}
+	return "", fmt.Errorf("unsupported import: %s (import as dir requires a relative path)", spec)
+}
+
 func (c *Compiler) resolveImport(baseDir, spec string) (string, error) {
 	if c.isBuiltinModuleName(spec) {
 		if err := c.loadBuiltinModule(spec); err != nil {
@@ -526,6 +705,10 @@ func (c *Compiler) resolveImport(baseDir, spec string) (string, error) {
 }
 
 func (c *Compiler) moduleNeedsHostBridge(name string) bool {
+	if c.target == TargetWASI {
+		// lib/<name>.wasi.wat は WASI の関数だけで実装している
+		return false
+	}
 	switch name {
 	case "server", "runtime", "sqlite":
 		return true
@@ -536,11 +719,24 @@ func (c *Compiler) moduleNeedsHostBridge(name string) bool {
 	}
 }
 
+// builtinModuleDeps は name の WAT が呼び出す他の組み込みモジュールを返す。
+func builtinModuleDeps(name string) []string {
+	switch name {
+	case "csv":
+		return []string{"json"}
+	default:
+		return nil
+	}
+}
+
 func (c *Compiler) loadBuiltinModuleWAT(name string) (string, error) {
 	if c.libDir == "" {
 		return "", nil
 	}
 	candidates := make([]string, 0, 2)
+	if c.target == TargetWASI {
+		candidates = append(candidates, filepath.Join(c.libDir, name+".wasi.wat"))
+	}
 	if c.backend == BackendHost {
 		candidates = append(candidates, filepath.Join(c.libDir, name+".host.wat"))
 	}
@@ -557,6 +753,29 @@ func (c *Compiler) loadBuiltinModuleWAT(name string) (string, error) {
 	return "", nil
 }
 
+// wasiModuleAvailable は --target=wasi で使える組み込みモジュールかどうかを返す。
+// http・runtime・sqlite は Runner のホスト関数が無いと動かない。
+func wasiModuleAvailable(name string) bool {
+	switch name {
+	case "http", "runtime", "sqlite", "interop":
+		return false
+	default:
+		return true
+	}
+}
+
+var watImportPattern = regexp.MustCompile(`\(import\s+"([^"]*)"\s+"([^"]*)"`)
+
+// checkWASIImports は生成した WAT が wasi_snapshot_preview1 以外を import していないか検査する。
+func checkWASIImports(wat string) error {
+	for _, match := range watImportPattern.FindAllStringSubmatch(wat, -1) {
+		if match[1] != wasiImportModule {
+			return fmt.Errorf("target wasi does not support import %s.%s", match[1], match[2])
+		}
+	}
+	return nil
+}
+
 func moduleDefinedInWAT(moduleName, src string) map[string]bool {
 	defined := map[string]bool{}
 	if strings.TrimSpace(src) == "" {
@@ -734,6 +953,8 @@ func exprCallsDisallowed(expr ast.Expr, disallowed map[string]bool) bool {
 		if e.Else != nil && exprCallsDisallowed(e.Else, disallowed) {
 			return true
 		}
+	case *ast.TransactionExpr:
+		return blockCallsDisallowed(e.Body, disallowed)
 	case *ast.SwitchExpr:
 		if exprCallsDisallowed(e.Value, disallowed) {
 			return true
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"tuna/internal/compiler"
+	"tuna/internal/formatter"
 	tunaruntime "tuna/internal/runtime"
 )
 
@@ -16,6 +17,9 @@ func writeFiles(t *testing.T, dir string, files map[string]string) {
 	t.Helper()
 	for name, content := range files {
 		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
 		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
 			t.Fatal(err)
 		}
@@ -697,6 +701,33 @@ export function main(): void {
 	}
 }
 
+func TestDirectoryEmbedImport(t *testing.T) {
+	out := compileAndRun(t, map[string]string{
+		"public/style.css":     `body { color: red; }`,
+		"public/js/app.js":     `console.log("hi")`,
+		"public/.gitignore":    `*`,
+		"public/_draft/a.html": `<p>draft</p>`,
+		"main.ts": `import assets from "./public/" as dir
+import { log } from "prelude"
+import { stringify } from "json"
+export function main(): void {
+  log(stringify(assets))
+}
+`,
+	}, "main.ts")
+	want := `{"js/app.js":"console.log(\"hi\")","style.css":"body { color: red; }"}` + "\n"
+	if out != want {
+		t.Fatalf("output mismatch: %q", out)
+	}
+}
+
+func TestDirectoryEmbedImportRequiresDefaultName(t *testing.T) {
+	compileExpectErrorContains(t, `import { x } from "./public/" as dir
+export function main(): void {
+}
+`, "requires a single default import")
+}
+
 func TestSemicolonIsSyntaxError(t *testing.T) {
 	compileExpectError(t, `import { log } from "prelude";
 export function main(): void {
@@ -814,6 +845,21 @@ export function main(): void {
 `, "argument type mismatch: expect i64, found string")
 }
 
+func TestDecodeCSVTargetType(t *testing.T) {
+	compileExpectErrorContains(t, `import { decode_csv } from "csv"
+type Row = { name: string, tags: string[] }
+export function main(): void {
+  const rows = decode_csv<Row>("name,tags\n")
+}
+`, "decode_csv target type must be an object type with string, number or boolean properties")
+
+	compileExpectErrorContains(t, `import { decode_csv } from "csv"
+export function main(): void {
+  const rows = decode_csv("a\n")
+}
+`, "decode_csv expects 1 type argument")
+}
+
 func TestSQLCreateAndSelect(t *testing.T) {
 	out := compileAndRun(t, map[string]string{
 		"main.ts": `import { log } from "prelude"
@@ -846,6 +892,156 @@ export function main(): void {
 	}
 }
 
+func TestSQLTypedRowsFromCreateTable(t *testing.T) {
+	out := compileAndRun(t, map[string]string{
+		"main.ts": `import { log } from "prelude"
+import { stringify } from "json"
+
+create_table measurements {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  label TEXT NOT NULL,
+  score REAL,
+  note TEXT,
+  rank INTEGER
+}
+
+export function main(): void | error {
+  execute {
+    INSERT INTO measurements (label, score, note, rank) VALUES ('a', 1.5, '', 3), ('b', NULL, NULL, NULL)
+  }?
+  const rows: measurements[] = fetch_all {
+    SELECT * FROM measurements ORDER BY id
+  }?
+  log(stringify(rows))
+
+  const typed: { id: i64, label: string, score: f64 | null, note: string | null }[] = fetch_all {
+    SELECT m.id, m.label, score, note FROM measurements AS m ORDER BY m.id
+  }?
+  const first = typed[0]?
+  log(stringify(first.id + 1))
+
+  const counted: { total: i64, last: i64, ranked: i64 | null, label_len: string } = fetch_one {
+    SELECT COUNT(*) AS total, last_insert_rowid() AS last, CAST(MAX(rank) AS INTEGER) AS ranked, length(MIN(label)) AS label_len FROM measurements
+  }?
+  log(stringify(counted))
+}
+`,
+	}, "main.ts")
+	want := "[{\"id\":1,\"label\":\"a\",\"score\":1.5,\"note\":\"\",\"rank\":3},{\"id\":2,\"label\":\"b\",\"score\":null,\"note\":null,\"rank\":null}]\n" +
+		"2\n" +
+		"{\"total\":2,\"last\":2,\"ranked\":3,\"label_len\":\"1\"}\n"
+	if out != want {
+		t.Fatalf("output mismatch: got %q, want %q", out, want)
+	}
+}
+
+func TestSQLTypedRowsRejectLossyValues(t *testing.T) {
+	out := compileAndRun(t, map[string]string{
+		"main.ts": `import { log } from "prelude"
+import { stringify } from "json"
+
+create_table items {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  rank INTEGER NOT NULL,
+  label TEXT
+}
+
+export function main(): void | error {
+  execute {
+    INSERT INTO items (rank, label) VALUES (2.5, NULL)
+  }?
+  const ranked = fetch_one {
+    SELECT rank FROM items
+  }
+  switch (ranked) {
+    case e as error: log(e.message)
+    case row as { rank: i64 }: log(stringify(row.rank))
+  }
+  const labeled = fetch_one<{ label: string }> {
+    SELECT label FROM items
+  }
+  switch (labeled) {
+    case e as error: log(e.message)
+    case row as { label: string }: log(row.label)
+  }
+  const summed = fetch_one {
+    SELECT SUM(id) AS total, label || 'x' AS joined FROM items WHERE id > 1
+  }?
+  log(stringify(summed))
+}
+`,
+	}, "main.ts")
+	want := "sql column rank: 2.5 is not an integer\n" +
+		"sql column label is NULL\n" +
+		"{\"total\":\"\",\"joined\":\"\"}\n"
+	if out != want {
+		t.Fatalf("output mismatch: got %q, want %q", out, want)
+	}
+}
+
+func TestSQLTypedRowsUnaliasedCallColumns(t *testing.T) {
+	out := compileAndRun(t, map[string]string{
+		"main.ts": `import { log } from "prelude"
+import { stringify } from "json"
+
+create_table items {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  label TEXT NOT NULL
+}
+
+export function main(): void | error {
+  execute {
+    INSERT INTO items (label) VALUES ('a')
+  }?
+  const row: { "count(*)": i64, "last_insert_rowid()": i64 } = fetch_one {
+    SELECT count(*), last_insert_rowid() FROM items
+  }?
+  log(stringify(row))
+  const mixed: { "cast(coalesce(id, 0) as integer)": i64 | null, "cast(id as real)": f64 } = fetch_one {
+    SELECT CAST(coalesce(id, 0) AS INTEGER), CAST(id AS REAL) FROM items
+  }?
+  log(stringify(mixed))
+}
+`,
+	}, "main.ts")
+	want := "{\"count(*)\":1,\"last_insert_rowid()\":1}\n" +
+		"{\"cast(coalesce(id, 0) as integer)\":1,\"cast(id as real)\":1}\n"
+	if out != want {
+		t.Fatalf("output mismatch: got %q, want %q", out, want)
+	}
+}
+
+func TestSQLTypedRowsRejectBareCallName(t *testing.T) {
+	compileExpectError(t, `import { log } from "prelude"
+create_table items {
+  id INTEGER PRIMARY KEY AUTOINCREMENT
+}
+export function main(): void | error {
+  const row = fetch_one {
+    SELECT count(*) FROM items
+  }?
+  log(row.count + 1)
+}
+`)
+}
+
+func TestSQLTypedRowsRejectStringComparison(t *testing.T) {
+	compileExpectError(t, `import { log } from "prelude"
+create_table todos {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  completed INTEGER NOT NULL
+}
+export function main(): void | error {
+  const row = fetch_one {
+    SELECT completed FROM todos
+  }?
+  if (row.completed == "1") {
+    log("done")
+  }
+}
+`)
+}
+
 func TestSQLWithUpdate(t *testing.T) {
 	out := compileAndRun(t, map[string]string{
 		"main.ts": `import { log } from "prelude"
@@ -877,3 +1073,202 @@ export function main(): void {
 		t.Fatalf("output mismatch: got %q, want %q", out, want)
 	}
 }
+
+func TestJSONSchemaExportAndImport(t *testing.T) {
+	ensureLibDirEnv(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"api.tuna": `export type Status = "active" | "banned"
+
+export type Address = { city: string, zip: string | undefined }
+
+export type User = {
+  id: i64,
+  status: Status,
+  address: Address | null,
+  point: [f64, f64],
+  scores: Map<f64>,
+  nickname: string | undefined
+}
+`,
+	})
+	schema, err := compiler.New().JSONSchema(filepath.Join(dir, "api.tuna"), "User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"$schema": "https://json-schema.org/draft/2020-12/schema"`,
+		`"title": "User"`,
+		`"$ref": "#/$defs/Status"`,
+		`"const": "banned"`,
+		`"additionalProperties": {` + "\n" + `        "type": "number"`,
+		`"prefixItems": [`,
+		`"required": [` + "\n" + `    "address",` + "\n" + `    "id",` + "\n" + `    "point",` + "\n" + `    "scores",` + "\n" + `    "status"` + "\n" + `  ]`,
+	} {
+		if !strings.Contains(schema, want) {
+			t.Fatalf("schema is missing %q:\n%s", want, schema)
+		}
+	}
+	if _, err := compiler.New().JSONSchema(filepath.Join(dir, "api.tuna"), "Missing"); err == nil {
+		t.Fatalf("expected an error for a missing type")
+	}
+
+	mod, err := compiler.ImportJSONSchema([]byte(schema), "ignored", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := formatter.New().FormatModule(mod)
+	for _, want := range []string{
+		`export type Status = "active" | "banned"`,
+		`zip: string | undefined`,
+		`address: Address | null`,
+		`point: [f64, f64,]`,
+		`scores: Map<f64,>`,
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("imported module is missing %q:\n%s", want, src)
+		}
+	}
+	writeFiles(t, dir, map[string]string{"imported.tuna": src})
+	again, err := compiler.New().JSONSchema(filepath.Join(dir, "imported.tuna"), "User")
+	if err != nil {
+		t.Fatalf("imported types do not compile: %v\n%s", err, src)
+	}
+	if again != schema {
+		t.Fatalf("schema changed after a round trip:\n%s\n---\n%s", schema, again)
+	}
+}
+
+func TestJSONSchemaRefinementsAndDefaults(t *testing.T) {
+	ensureLibDirEnv(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"api.tuna": `export type Name = string where { min_length: 1, pattern: "^[a-z]+$" }
+
+export type User = {
+  name: Name,
+  age: i64,
+  role: "admin" | "member" = "member"
+}
+`,
+	})
+	schema, err := compiler.New().JSONSchema(filepath.Join(dir, "api.tuna"), "User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"minLength": 1`,
+		`"pattern": "^[a-z]+$"`,
+		`"default": "member"`,
+		`"required": [` + "\n" + `    "age",` + "\n" + `    "name"` + "\n" + `  ]`,
+	} {
+		if !strings.Contains(schema, want) {
+			t.Fatalf("schema is missing %q:\n%s", want, schema)
+		}
+	}
+
+	// 内側の制約は名前付きの型エイリアスになり、扱えない pattern は取り込まない
+	mod, err := compiler.ImportJSONSchema([]byte(`{
+  "title": "Item",
+  "type": "object",
+  "properties": {
+    "code": { "type": "string", "maxLength": 8, "pattern": "^[A-Z]{3}$" },
+    "qty": { "type": "integer", "minimum": 1, "default": 1 }
+  },
+  "required": ["code"]
+}`), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := formatter.New().FormatModule(mod)
+	for _, want := range []string{
+		`export type ItemCode = string where { max_length: 8, }`,
+		`export type ItemQty = i64 where { min: 1, }`,
+		`qty: ItemQty = 1`,
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("imported module is missing %q:\n%s", want, src)
+		}
+	}
+	writeFiles(t, dir, map[string]string{"item.tuna": src})
+	if _, err := compiler.New().JSONSchema(filepath.Join(dir, "item.tuna"), "Item"); err != nil {
+		t.Fatalf("imported types do not compile: %v\n%s", err, src)
+	}
+}
+
+func TestTargetWASIImportsOnlyWASI(t *testing.T) {
+	ensureLibDirEnv(t)
+	dir := t.TempDir()
+	entryPath := filepath.Join(dir, "main.tuna")
+	src := `import { log } from "prelude"
+import { get_args, get_env } from "server"
+import { read_text } from "file"
+
+export function main(): void | error {
+  log(get_args())
+  log(get_env("HOME"))
+  const text: string = read_text("a.txt")?
+  log(text)
+  return undefined
+}
+`
+	if err := os.WriteFile(entryPath, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	comp := compiler.New()
+	if err := comp.SetTarget(compiler.TargetWASI); err != nil {
+		t.Fatal(err)
+	}
+	res, err := comp.Compile(entryPath)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	for _, line := range strings.Split(res.Wat, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "(import ") && !strings.HasPrefix(line, `(import "wasi_snapshot_preview1"`) {
+			t.Fatalf("unexpected import for target wasi: %s", line)
+		}
+	}
+	for _, name := range []string{"args_get", "environ_get", "path_open", "fd_read", "proc_exit"} {
+		if !strings.Contains(res.Wat, `(import "wasi_snapshot_preview1" "`+name+`"`) {
+			t.Fatalf("expected wasi import %s", name)
+		}
+	}
+}
+
+func TestTargetWASIRejectsHostModules(t *testing.T) {
+	ensureLibDirEnv(t)
+	dir := t.TempDir()
+	entryPath := filepath.Join(dir, "main.tuna")
+	src := `import { log } from "prelude"
+import { run_formatter } from "runtime"
+
+export function main(): void {
+  log("x")
+}
+`
+	if err := os.WriteFile(entryPath, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	comp := compiler.New()
+	if err := comp.SetTarget(compiler.TargetWASI); err != nil {
+		t.Fatal(err)
+	}
+	_, err := comp.Compile(entryPath)
+	if err == nil || !strings.Contains(err.Error(), "module runtime is not available for target wasi") {
+		t.Fatalf("expected runtime module error, got %v", err)
+	}
+
+	comp = compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		t.Fatal(err)
+	}
+	if err := comp.SetTarget(compiler.TargetWASI); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := comp.Compile(entryPath); err == nil || !strings.Contains(err.Error(), "target wasi requires the gc backend") {
+		t.Fatalf("expected backend error, got %v", err)
+	}
+}
@@ -31,11 +31,14 @@ type Generator struct {
 	moduleWAT     map[string]string
 	moduleWATDefs map[string]map[string]bool
 	backend       Backend
+	target        Target
 
 	lambdaFuncs map[*ast.ArrowFunc]*lambdaInfo
 	lambdaOrder []*lambdaInfo
 
-	tableDefs []*ast.TableDecl
+	tableDefs  []*ast.TableDecl
+	migrations []*ast.MigrationDecl
+	indexDefs  []*ast.IndexDecl
 
 	lambdaTraceContext map[*ast.ArrowFunc]traceContext
 
@@ -66,6 +69,7 @@ func NewGenerator(checker *types.Checker) *Generator {
 	return &Generator{
 		checker:            checker,
 		backend:            BackendGC,
+		target:             TargetTuna,
 		lambdaFuncs:        map[*ast.ArrowFunc]*lambdaInfo{},
 		lambdaTraceContext: map[*ast.ArrowFunc]traceContext{},
 		httpHandlerFuncs:   map[*types.Symbol]bool{},
@@ -90,6 +94,10 @@ func (g *Generator) SetBackend(backend Backend) {
 	g.backend = backend
 }
 
+func (g *Generator) SetTarget(target Target) {
+	g.target = target
+}
+
 func (g *Generator) Generate(entry string) (string, error) {
 	g.initModules()
 	g.assignSymbols(entry)
@@ -100,7 +108,7 @@ func (g *Generator) Generate(entry string) (string, error) {
 	w := &watBuilder{}
 	w.line("(module")
 	w.indent++
-	g.emitImports(w)
+	g.emitImports(w, entry)
 	g.emitModuleWATs(w)
 	g.emitMemory(w)
 	g.emitGlobals(w)
@@ -138,10 +146,9 @@ func (g *Generator) collectStrings() {
 	g.internString("error")
 	// add_route のメソッド省略時に使うワイルドカード。
 	g.internString("*")
-	// Generate and intern table definitions JSON if any tables exist
-	if len(g.tableDefs) > 0 {
-		jsonStr := g.generateTableDefsJSON()
-		g.internString(jsonStr)
+	// Generate and intern table definitions JSON if any tables or migrations exist
+	if g.hasSchema() {
+		g.internString(g.SchemaJSON())
 	}
 }
 
@@ -182,6 +189,12 @@ type decodeSchema struct {
 	Props   []decodeSchemaKV `json:"props,omitempty"`
 	Index   *decodeSchema    `json:"index,omitempty"`
 	Union   []*decodeSchema  `json:"union,omitempty"`
+	// type ... where { ... } の制約
+	MinLength *int64   `json:"min_length,omitempty"`
+	MaxLength *int64   `json:"max_length,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
 }
 
 type decodeSchemaLit struct {
@@ -190,8 +203,9 @@ type decodeSchemaLit struct {
 }
 
 type decodeSchemaKV struct {
-	Name string        `json:"name"`
-	Type *decodeSchema `json:"type"`
+	Name    string          `json:"name"`
+	Type    *decodeSchema   `json:"type"`
+	Default json.RawMessage `json:"default,omitempty"` // フィールドが無いときの値
 }
 
 func decodeSchemaString(t *types.Type) string {
@@ -222,6 +236,19 @@ func decodeSchemaFromType(t *types.Type) (*decodeSchema, error) {
 		return s, nil
 	}
 
+	if r := t.Refine; r != nil {
+		base := *t
+		base.Refine = nil
+		s, err := decodeSchemaFromType(&base)
+		if err != nil {
+			return nil, err
+		}
+		s.MinLength, s.MaxLength = r.MinLength, r.MaxLength
+		s.Min, s.Max = r.Min, r.Max
+		s.Pattern = r.Pattern
+		return s, nil
+	}
+
 	switch t.Kind {
 	case types.KindI64:
 		return &decodeSchema{Kind: "i64"}, nil
@@ -260,7 +287,15 @@ func decodeSchemaFromType(t *types.Type) (*decodeSchema, error) {
 			if err != nil {
 				return nil, err
 			}
-			props = append(props, decodeSchemaKV{Name: p.Name, Type: s})
+			kv := decodeSchemaKV{Name: p.Name, Type: s}
+			if p.HasDefault {
+				def, err := json.Marshal(p.Default)
+				if err != nil {
+					return nil, err
+				}
+				kv.Default = def
+			}
+			props = append(props, kv)
 		}
 		var index *decodeSchema
 		if t.Index != nil {
@@ -305,37 +340,84 @@ func schemaKindForTypeKind(kind types.Kind) string {
 	}
 }
 
-// generateTableDefsJSON generates a JSON string representing all table definitions
-func (g *Generator) generateTableDefsJSON() string {
-	var b strings.Builder
-	b.WriteString("[")
-	for i, td := range g.tableDefs {
-		if i > 0 {
-			b.WriteString(",")
-		}
-		b.WriteString("{\"name\":\"")
-		b.WriteString(td.Name)
-		b.WriteString("\",\"columns\":[")
-		for j, col := range td.Columns {
-			if j > 0 {
-				b.WriteString(",")
-			}
-			b.WriteString("{\"name\":\"")
-			b.WriteString(col.Name)
-			b.WriteString("\",\"type\":\"")
-			b.WriteString(col.Type)
-			b.WriteString("\"")
-			if col.Constraints != "" {
-				b.WriteString(",\"constraints\":\"")
-				b.WriteString(col.Constraints)
-				b.WriteString("\"")
+type schemaColumnJSON struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Constraints string `json:"constraints,omitempty"`
+}
+
+type schemaConstraintJSON struct {
+	Kind       string   `json:"kind"`
+	Name       string   `json:"name,omitempty"`
+	Columns    []string `json:"columns,omitempty"`
+	RefTable   string   `json:"ref_table,omitempty"`
+	RefColumns []string `json:"ref_columns,omitempty"`
+	SQL        string   `json:"sql"`
+}
+
+type schemaIndexJSON struct {
+	Name    string   `json:"name"`
+	Unique  bool     `json:"unique,omitempty"`
+	Columns []string `json:"columns"`
+}
+
+type schemaTableJSON struct {
+	Name        string                 `json:"name"`
+	Connection  string                 `json:"connection,omitempty"`
+	Columns     []schemaColumnJSON     `json:"columns"`
+	Constraints []schemaConstraintJSON `json:"constraints,omitempty"`
+	Indexes     []schemaIndexJSON      `json:"indexes,omitempty"`
+}
+
+type schemaMigrationJSON struct {
+	Name string `json:"name"`
+	SQL  string `json:"sql"`
+}
+
+// hasSchema reports whether the program declares tables or migrations.
+func (g *Generator) hasSchema() bool {
+	return len(g.tableDefs) > 0 || len(g.migrations) > 0
+}
+
+// SchemaJSON returns the table definitions and migrations passed to $sqlite.register_tables.
+// Migrations are sorted by name, which is the order they are applied in.
+func (g *Generator) SchemaJSON() string {
+	schema := struct {
+		Tables     []schemaTableJSON     `json:"tables"`
+		Migrations []schemaMigrationJSON `json:"migrations"`
+	}{
+		Tables:     []schemaTableJSON{},
+		Migrations: []schemaMigrationJSON{},
+	}
+	for _, td := range g.tableDefs {
+		table := schemaTableJSON{Name: td.Name, Connection: td.Connection, Columns: []schemaColumnJSON{}}
+		for _, col := range td.Columns {
+			table.Columns = append(table.Columns, schemaColumnJSON{Name: col.Name, Type: col.Type, Constraints: col.Constraints})
+		}
+		for _, con := range td.Constraints {
+			table.Constraints = append(table.Constraints, schemaConstraintJSON{
+				Kind: con.Kind, Name: con.Name, Columns: con.Columns,
+				RefTable: con.RefTable, RefColumns: con.RefColumns, SQL: con.SQL,
+			})
+		}
+		// create_index はテーブルの定義に含めて、テーブルと同じ接続で作る
+		for _, idx := range g.indexDefs {
+			if strings.EqualFold(idx.Table, td.Name) && idx.Connection == td.Connection {
+				table.Indexes = append(table.Indexes, schemaIndexJSON{Name: idx.Name, Unique: idx.Unique, Columns: idx.Columns})
 			}
-			b.WriteString("}")
 		}
-		b.WriteString("]}")
+		schema.Tables = append(schema.Tables, table)
 	}
-	b.WriteString("]")
-	return b.String()
+	migrations := append([]*ast.MigrationDecl(nil), g.migrations...)
+	sort.SliceStable(migrations, func(i, j int) bool { return migrations[i].Name < migrations[j].Name })
+	for _, m := range migrations {
+		schema.Migrations = append(schema.Migrations, schemaMigrationJSON{Name: m.Name, SQL: m.SQL})
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(schema)
+	return strings.TrimSuffix(buf.String(), "\n")
 }
 
 func (g *Generator) collectStringsDecl(decl ast.Decl) {
@@ -357,6 +439,10 @@ func (g *Generator) collectStringsDecl(decl ast.Decl) {
 	case *ast.TableDecl:
 		// Store table definition for later use
 		g.tableDefs = append(g.tableDefs, d)
+	case *ast.MigrationDecl:
+		g.migrations = append(g.migrations, d)
+	case *ast.IndexDecl:
+		g.indexDefs = append(g.indexDefs, d)
 	}
 }
 
@@ -456,6 +542,12 @@ func (g *Generator) collectStringsExpr(expr ast.Expr) {
 		}
 	case *ast.SQLExpr:
 		g.internString(e.Query)
+		if desc := g.sqlRowDescriptor(e); desc != "" {
+			g.internString(desc)
+		}
+		if e.Conn != nil {
+			g.collectStringsExpr(e.Conn)
+		}
 		// Also collect strings from parameter expressions
 		for _, param := range e.Params {
 			g.collectStringsExpr(param)
@@ -490,12 +582,18 @@ func (g *Generator) collectStringsExpr(expr ast.Expr) {
 			g.collectStringsExpr(arg)
 		}
 		if ident, ok := e.Callee.(*ast.IdentExpr); ok && len(e.TypeArgs) == 1 {
-			if sym := resolveSymbolAlias(g.checker.IdentSymbols[ident]); sym != nil && (sym.Name == "decode" || sym.Name == "parse") && g.symModulePath[sym] == "json" {
+			if sym := resolveSymbolAlias(g.checker.IdentSymbols[ident]); sym != nil && (sym.Name == "decode" || sym.Name == "decode_all" || sym.Name == "parse") && g.symModulePath[sym] == "json" {
 				targetType := g.checker.TypeExprTypes[e.TypeArgs[0]]
 				if targetType != nil {
 					g.internString(decodeSchemaString(targetType))
 				}
 			}
+			if sym := resolveSymbolAlias(g.checker.IdentSymbols[ident]); sym != nil && sym.Name == "decode_csv" && g.symModulePath[sym] == "csv" {
+				targetType := g.checker.TypeExprTypes[e.TypeArgs[0]]
+				if targetType != nil {
+					g.internString(decodeSchemaString(types.NewArray(targetType)))
+				}
+			}
 		}
 	case *ast.MemberExpr:
 		g.collectStringsExpr(e.Object)
@@ -544,6 +642,8 @@ func (g *Generator) collectStringsExpr(expr ast.Expr) {
 		for _, stmt := range e.Stmts {
 			g.collectStringsStmt(stmt)
 		}
+	case *ast.TransactionExpr:
+		g.collectStringsBlock(e.Body)
 	case *ast.ArrowFunc:
 		g.internString(g.lambdaValueExportName(e))
 		if e.Body != nil {
@@ -733,6 +833,9 @@ func (g *Generator) collectTraceExpr(ctx traceContext, expr ast.Expr) {
 			g.collectTraceExpr(ctx, part)
 		}
 	case *ast.SQLExpr:
+		if e.Conn != nil {
+			g.collectTraceExpr(ctx, e.Conn)
+		}
 		for _, param := range e.Params {
 			g.collectTraceExpr(ctx, param)
 		}
@@ -773,6 +876,8 @@ func (g *Generator) collectTraceExpr(ctx traceContext, expr ast.Expr) {
 		for _, stmt := range e.Stmts {
 			g.collectTraceStmt(ctx, stmt)
 		}
+	case *ast.TransactionExpr:
+		g.collectTraceBlock(ctx, e.Body)
 	case *ast.ArrowFunc:
 		lambdaCtx := traceContext{
 			modulePath: ctx.modulePath,
@@ -948,6 +1053,8 @@ func (g *Generator) collectFunctionNamesExpr(expr ast.Expr) {
 		for _, stmt := range e.Stmts {
 			g.collectFunctionNamesStmt(stmt)
 		}
+	case *ast.TransactionExpr:
+		g.collectFunctionNamesBlock(e.Body)
 	case *ast.ArrowFunc:
 		if e.Body != nil {
 			g.collectFunctionNamesBlock(e.Body)
@@ -1022,7 +1129,15 @@ func (g *Generator) assignSymbols(entry string) {
 	}
 }
 
-func (g *Generator) emitImports(w *watBuilder) {
+func (g *Generator) emitImports(w *watBuilder, entry string) {
+	if mainSym := g.findExportedMain(entry); mainSym != nil && mainSym.Type.Ret.Kind != types.KindVoid {
+		// main が error を返したときに終了コード 1 で終わるために使う。
+		if g.target == TargetWASI {
+			w.line(fmt.Sprintf("(import \"%s\" \"proc_exit\" (func $__exit (param i32)))", wasiImportModule))
+		} else {
+			w.line("(import \"server\" \"exit\" (func $__exit (param i64)))")
+		}
+	}
 	for _, mod := range g.modules {
 		moduleName := mod.AST.Path
 		if !isBuiltinModulePath(moduleName) {
@@ -1257,7 +1372,7 @@ func (g *Generator) moduleHostImports(mod *types.ModuleInfo) []importInfo {
 		if definedInWAT != nil && definedInWAT[ext.Name] {
 			continue
 		}
-		if mod.AST.Path == "json" && ext.Name == "decode" {
+		if mod.AST.Path == "json" && (ext.Name == "decode" || ext.Name == "decode_all") {
 			sig := externFuncImportSig(mod.AST.Path, ext.Name, types.NewFunc([]*types.Type{types.JSON(), types.String()}, types.JSON()))
 			imports = append(imports, importInfo{
 				name: ext.Name,
@@ -1372,6 +1487,7 @@ func (g *Generator) emitFunctions(w *watBuilder, entry string) {
 	g.emitLambdaFuncs(w)
 	g.emitFunctionValueWrappers(w)
 	g.emitFunctionValueDispatcher(w)
+	g.emitHostFunctionValueCaller(w)
 	g.emitStart(w, entryAbs)
 }
 
@@ -1408,9 +1524,9 @@ func (g *Generator) emitInit(w *watBuilder) {
 		}
 	}
 
-	// Register table definitions with the runtime
-	if len(g.tableDefs) > 0 {
-		jsonStr := g.generateTableDefsJSON()
+	// Register table definitions and migrations with the runtime
+	if g.hasSchema() {
+		jsonStr := g.SchemaJSON()
 		datum := g.stringData[g.stringIDs[jsonStr]]
 		emitter.emit(fmt.Sprintf("(i32.const %d)", datum.offset))
 		emitter.emit(fmt.Sprintf("(i32.const %d)", datum.length))
@@ -1457,6 +1573,16 @@ func (g *Generator) emitStart(w *watBuilder, entryAbs string) {
 		} else {
 			w.line(fmt.Sprintf("(call %s)", g.funcImplName(mainSym)))
 			w.line("(global.set $__main_result)")
+			// error ならメッセージと stacktrace を fd 2 に書いて終了コード 1 で終わる。
+			w.line("(if (call $prelude._report_main_result (global.get $__main_result))")
+			w.indent++
+			if g.target == TargetWASI {
+				w.line("(then (call $__exit (i32.const 1)))")
+			} else {
+				w.line("(then (call $__exit (i64.const 1)))")
+			}
+			w.indent--
+			w.line(")")
 		}
 	}
 	w.indent--
@@ -1834,6 +1960,28 @@ func (g *Generator) emitFunctionValueDispatcher(w *watBuilder) {
 	w.line(")")
 }
 
+// emitHostFunctionValueCaller はホストから関数値を呼び出すためのエクスポートを生成する。
+// response_stream のようにハンドラー終了後にホスト側から関数値を実行する用途で使う。
+func (g *Generator) emitHostFunctionValueCaller(w *watBuilder) {
+	if g.backend != BackendHost || !g.hasModuleWAT("interop") {
+		return
+	}
+	w.line("(func $__call_fn_host (param $fn externref) (param $args externref) (result externref)")
+	w.indent++
+	w.line("(call $__ensure_init)")
+	w.line("(call $interop.to_host")
+	w.indent++
+	w.line("(call $__call_fn_dispatch")
+	w.indent++
+	w.line("(call $interop.to_gc (local.get $fn))")
+	w.line("(call $interop.to_gc (local.get $args))))")
+	w.indent--
+	w.indent--
+	w.indent--
+	w.line(")")
+	w.line("(export \"__call_fn_host\" (func $__call_fn_host))")
+}
+
 func (g *Generator) emitFunctionValueWrapper(w *watBuilder, wrapperName, exportName, targetName string, fnType *types.Type) {
 	if fnType == nil || fnType.Kind != types.KindFunc {
 		return
@@ -2091,6 +2239,12 @@ type funcEmitter struct {
 	body   []string
 	indent int
 	scopes []map[string]string
+	// txTypes は囲んでいる transaction ブロックの型（内側が末尾）。? はここへ分岐する。
+	txTypes []*types.Type
+	// cursors は開いている fetch_iter のカーソルを持つローカル（内側が末尾）。
+	// return や ? でループを抜けるときに閉じる。txCursors は各 transaction ブロックに入ったときの len(cursors)。
+	cursors   []string
+	txCursors []int
 }
 
 func newFuncEmitter(g *Generator, ret *types.Type, trace traceContext) *funcEmitter {
@@ -2209,6 +2363,7 @@ func (f *funcEmitter) emitStmt(stmt ast.Stmt) {
 			f.emit("(call $prelude.val_undefined)")
 			f.emitCoerce(types.Undefined(), f.ret)
 		}
+		f.emitCloseCursors(0)
 		f.emit("return")
 	case *ast.IfStmt:
 		f.emitIfCond(s.Cond)
@@ -2311,6 +2466,10 @@ func (f *funcEmitter) emitObjectDestructure(s *ast.ObjectDestructureStmt) {
 }
 
 func (f *funcEmitter) emitForOf(s *ast.ForOfStmt) {
+	if e, ok := s.Iter.(*ast.SQLExpr); ok && e.Kind == ast.SQLQueryFetchIter {
+		f.emitForOfCursor(s, e)
+		return
+	}
 	iterType := f.g.checker.ExprTypes[s.Iter]
 	elem := elemType(iterType)
 	arrLocal := f.addLocalRaw(f.g.refType())
@@ -2355,6 +2514,68 @@ func (f *funcEmitter) emitForOf(s *ast.ForOfStmt) {
 	f.emit(")")
 }
 
+// emitForOfCursor は for (... of fetch_iter { ... }) を出力する。行は1つずつカーソルから読み、
+// ループを抜けたとき（最後まで読んだとき、return / ? で抜けたとき）にカーソルを閉じる。
+// カーソルを開くときと読むときのエラーは ? と同じく伝播する。
+func (f *funcEmitter) emitForOfCursor(s *ast.ForOfStmt, e *ast.SQLExpr) {
+	elem := elemType(f.g.checker.ExprTypes[e])
+	errType := types.ResultErrorType()
+	stepType := types.NewUnion([]*types.Type{elem, types.Null(), errType})
+	cursorLocal := f.addLocalRaw(f.g.refType())
+	valLocal := f.addLocalRaw(f.g.refType())
+
+	f.emitSQLArgs(e)
+	f.emit("(call $sqlite.sql_cursor_open)")
+	f.emit(fmt.Sprintf("(local.set %s)", cursorLocal))
+	f.emitTypeGuard(cursorLocal, errType)
+	f.emit("(if")
+	f.indent++
+	f.emit("(then")
+	f.indent++
+	f.emit(fmt.Sprintf("(local.get %s)", cursorLocal))
+	f.emitPropagate(errType)
+	f.indent--
+	f.emit(")")
+	f.indent--
+	f.emit(")")
+
+	f.cursors = append(f.cursors, cursorLocal)
+	f.emit("(block $for_end")
+	f.indent++
+	f.emit("(loop $for_loop")
+	f.indent++
+	f.emit(fmt.Sprintf("(call $sqlite.sql_cursor_next (local.get %s))", cursorLocal))
+	f.emit(fmt.Sprintf("(local.set %s)", valLocal))
+	// 読み込みのエラーはカーソルを閉じて伝播する（emitPropagate が f.cursors を閉じる）
+	f.emitTypeGuard(valLocal, errType)
+	f.emit("(if")
+	f.indent++
+	f.emit("(then")
+	f.indent++
+	f.emit(fmt.Sprintf("(local.get %s)", valLocal))
+	f.emitPropagate(stepType)
+	f.indent--
+	f.emit(")")
+	f.indent--
+	f.emit(")")
+	// null は最後の行の後
+	f.emitTypeGuard(valLocal, types.Null())
+	f.emit("br_if $for_end")
+
+	f.pushScope()
+	f.emitForOfBinding(s.Var, valLocal, elem)
+	f.emitBlock(s.Body)
+	f.popScope()
+
+	f.emit("br $for_loop")
+	f.indent--
+	f.emit(")")
+	f.indent--
+	f.emit(")")
+	f.cursors = f.cursors[:len(f.cursors)-1]
+	f.emit(fmt.Sprintf("(call $sqlite.sql_cursor_close (local.get %s))", cursorLocal))
+}
+
 func (f *funcEmitter) emitForOfBinding(binding ast.ForOfVar, valLocal string, elemType *types.Type) {
 	switch b := binding.(type) {
 	case *ast.ForOfIdentVar:
@@ -2537,6 +2758,8 @@ func (f *funcEmitter) emitExpr(expr ast.Expr, t *types.Type) {
 		f.emit("(call $prelude.arr_get_result)")
 	case *ast.TryExpr:
 		f.emitTryExpr(e, t)
+	case *ast.TransactionExpr:
+		f.emitTransactionExpr(e, t)
 	case *ast.ArrayLit:
 		f.emitArrayLit(e, t)
 	case *ast.ObjectLit:
@@ -2702,8 +2925,7 @@ func (f *funcEmitter) emitTryExpr(e *ast.TryExpr, t *types.Type) {
 	f.emit("(then")
 	f.indent++
 	f.emit(fmt.Sprintf("(local.get %s)", valueLocal))
-	f.emitCoerce(resultType, f.ret)
-	f.emit("return")
+	f.emitPropagate(resultType)
 	if wasmType(successType) == "i64" {
 		f.emit("(i64.const 0)")
 	} else if wasmType(successType) == "f64" {
@@ -2723,6 +2945,125 @@ func (f *funcEmitter) emitTryExpr(e *ast.TryExpr, t *types.Type) {
 	f.emit(")")
 	f.indent--
 	f.emit(")")
+	if t != nil && t.Kind == types.KindVoid {
+		// void | error の ? は値を残さない（式文では drop されない）
+		f.emit("drop")
+	}
+}
+
+// emitPropagate はスタック上の error（型は valueType）を ? と同じく伝播する。
+// transaction ブロック内ではブロックを抜けてロールバックさせ、それ以外は関数から返す。
+// どちらの場合も、抜ける範囲で開いている fetch_iter のカーソルを閉じる。
+func (f *funcEmitter) emitPropagate(valueType *types.Type) {
+	if n := len(f.txTypes); n > 0 {
+		f.emitCoerce(valueType, f.txTypes[n-1])
+		f.emitCloseCursors(f.txCursors[n-1])
+		f.emit("(br $tx_end)")
+		return
+	}
+	f.emitCoerce(valueType, f.ret)
+	f.emitCloseCursors(0)
+	f.emit("return")
+}
+
+// emitCloseCursors は f.cursors[from:] のカーソルを内側から閉じる。
+func (f *funcEmitter) emitCloseCursors(from int) {
+	for i := len(f.cursors) - 1; i >= from; i-- {
+		f.emit(fmt.Sprintf("(call $sqlite.sql_cursor_close (local.get %s))", f.cursors[i]))
+	}
+}
+
+// emitTransactionExpr は transaction { ... } を出力する。開始に成功したらブロックを実行し、
+// 値が error ならロールバック、それ以外ならコミットする。コミットの失敗はブロックの値を error に置き換える。
+func (f *funcEmitter) emitTransactionExpr(e *ast.TransactionExpr, t *types.Type) {
+	_, errType := splitResultMembers(t)
+	if errType == nil {
+		return
+	}
+	resultLocal := f.addLocalRaw(wasmType(t))
+	statusLocal := f.addLocalRaw(f.g.refType())
+	statusType := types.NewUnion([]*types.Type{types.Undefined(), errType})
+
+	f.emit("(call $sqlite.sql_tx_begin)")
+	f.emit(fmt.Sprintf("(local.set %s)", statusLocal))
+	f.emitTypeGuard(statusLocal, errType)
+	f.emit("(if")
+	f.indent++
+	f.emit("(then")
+	f.indent++
+	f.emit(fmt.Sprintf("(local.get %s)", statusLocal))
+	f.emitCoerce(statusType, t)
+	f.emit(fmt.Sprintf("(local.set %s)", resultLocal))
+	f.indent--
+	f.emit(")")
+	f.emit("(else")
+	f.indent++
+	f.emit(fmt.Sprintf("(block $tx_end (result %s)", wasmType(t)))
+	f.indent++
+	f.txTypes = append(f.txTypes, t)
+	f.txCursors = append(f.txCursors, len(f.cursors))
+	f.pushScope()
+	// 最後の式文がブロックの値になる
+	stmts := e.Body.Stmts
+	var valueExpr ast.Expr
+	if n := len(stmts); n > 0 {
+		if es, ok := stmts[n-1].(*ast.ExprStmt); ok {
+			if exprType := f.g.checker.ExprTypes[es.Expr]; exprType != nil && exprType.Kind != types.KindVoid {
+				valueExpr = es.Expr
+				stmts = stmts[:n-1]
+			}
+		}
+	}
+	for _, stmt := range stmts {
+		f.emitStmt(stmt)
+	}
+	if valueExpr != nil {
+		exprType := f.g.checker.ExprTypes[valueExpr]
+		f.emitExpr(valueExpr, exprType)
+		f.emitCoerce(exprType, t)
+	} else {
+		f.emit("(call $prelude.val_undefined)")
+		f.emitCoerce(types.Undefined(), t)
+	}
+	f.popScope()
+	f.txTypes = f.txTypes[:len(f.txTypes)-1]
+	f.txCursors = f.txCursors[:len(f.txCursors)-1]
+	f.indent--
+	f.emit(")")
+	f.emit(fmt.Sprintf("(local.set %s)", resultLocal))
+	f.emitTypeGuard(resultLocal, errType)
+	f.emit("(if")
+	f.indent++
+	f.emit("(then")
+	f.indent++
+	f.emit("(call $sqlite.sql_tx_rollback)")
+	f.indent--
+	f.emit(")")
+	f.emit("(else")
+	f.indent++
+	f.emit("(call $sqlite.sql_tx_commit)")
+	f.emit(fmt.Sprintf("(local.set %s)", statusLocal))
+	f.emitTypeGuard(statusLocal, errType)
+	f.emit("(if")
+	f.indent++
+	f.emit("(then")
+	f.indent++
+	f.emit(fmt.Sprintf("(local.get %s)", statusLocal))
+	f.emitCoerce(statusType, t)
+	f.emit(fmt.Sprintf("(local.set %s)", resultLocal))
+	f.indent--
+	f.emit(")")
+	f.indent--
+	f.emit(")")
+	f.indent--
+	f.emit(")")
+	f.indent--
+	f.emit(")")
+	f.indent--
+	f.emit(")")
+	f.indent--
+	f.emit(")")
+	f.emit(fmt.Sprintf("(local.get %s)", resultLocal))
 }
 
 func (f *funcEmitter) emitBlockExpr(e *ast.BlockExpr, t *types.Type) {
@@ -3281,11 +3622,11 @@ func (f *funcEmitter) resolveFunctionExpr(expr ast.Expr) (string, *types.Type) {
 
 func (f *funcEmitter) emitBuiltinCall(module, name string, call *ast.CallExpr, t *types.Type) {
 	switch name {
-	case "log":
+	case "log", "log_error":
 		arg := call.Args[0]
 		f.emitExpr(arg, f.g.checker.ExprTypes[arg])
 		f.emitBoxIfPrimitive(f.g.checker.ExprTypes[arg])
-		f.emit(fmt.Sprintf("(call $%s.log)", module))
+		f.emit(fmt.Sprintf("(call $%s.%s)", module, name))
 	case "stringify":
 		arg := call.Args[0]
 		f.emitExpr(arg, f.g.checker.ExprTypes[arg])
@@ -3307,7 +3648,7 @@ func (f *funcEmitter) emitBuiltinCall(module, name string, call *ast.CallExpr, t
 		}
 		f.emit(fmt.Sprintf("(global.get %s)", f.g.stringGlobal(schemaStr)))
 		f.emit(fmt.Sprintf("(call $%s.parse)", module))
-	case "decode":
+	case "decode", "decode_all":
 		arg := call.Args[0]
 		f.emitExpr(arg, f.g.checker.ExprTypes[arg])
 		var schemaStr string
@@ -3317,7 +3658,19 @@ func (f *funcEmitter) emitBuiltinCall(module, name string, call *ast.CallExpr, t
 			}
 		}
 		f.emit(fmt.Sprintf("(global.get %s)", f.g.stringGlobal(schemaStr)))
-		f.emit(fmt.Sprintf("(call $%s.decode)", module))
+		f.emit(fmt.Sprintf("(call $%s.%s)", module, name))
+	case "decode_csv":
+		// 行の型 T ではなく T[] の schema を渡す（$csv.decode_csv が $json.decode にそのまま渡す）
+		arg := call.Args[0]
+		f.emitExpr(arg, f.g.checker.ExprTypes[arg])
+		var schemaStr string
+		if len(call.TypeArgs) > 0 {
+			if targetType := f.g.checker.TypeExprTypes[call.TypeArgs[0]]; targetType != nil {
+				schemaStr = decodeSchemaString(types.NewArray(targetType))
+			}
+		}
+		f.emit(fmt.Sprintf("(global.get %s)", f.g.stringGlobal(schemaStr)))
+		f.emit(fmt.Sprintf("(call $%s.decode_csv)", module))
 	case "to_string":
 		arg := call.Args[0]
 		f.emitExpr(arg, f.g.checker.ExprTypes[arg])
@@ -3462,6 +3815,9 @@ func (f *funcEmitter) emitSqlQuery(call *ast.CallExpr, module string) {
 	// Emit params array
 	f.emitExpr(paramsArg, paramsType)
 
+	// 動的なクエリは列の型が分からないため、すべての列を文字列として返す
+	f.emitStringData("")
+	f.emit("(call $prelude.val_undefined)")
 	f.emit(fmt.Sprintf("(call $%s.sql_query)", module))
 }
 
@@ -4132,11 +4488,36 @@ func (f *funcEmitter) emitObjectLit(lit *ast.ObjectLit, t *types.Type) {
 }
 
 func (f *funcEmitter) emitSQLExpr(e *ast.SQLExpr, t *types.Type) {
+	if !f.emitSQLArgs(e) {
+		return
+	}
+
+	switch e.Kind {
+	case ast.SQLQueryExecute:
+		// execute returns nothing
+		f.emit("(call $sqlite.sql_execute)")
+	case ast.SQLQueryFetchOne:
+		// fetch_one returns a single row object
+		f.emit("(call $sqlite.sql_fetch_one)")
+	case ast.SQLQueryFetchOptional:
+		// fetch_optional returns a single row object or null
+		f.emit("(call $sqlite.sql_fetch_optional)")
+	case ast.SQLQueryFetch, ast.SQLQueryFetchAll:
+		// fetch and fetch_all return { columns: [], rows: [] }
+		f.emit("(call $sqlite.sql_query)")
+	default:
+		// Default behavior (same as fetch_all)
+		f.emit("(call $sqlite.sql_query)")
+	}
+}
+
+// emitSQLArgs は SQL ブロックのクエリ (ptr, len)、パラメーターの配列、行の型（execute 以外）、接続を積む。
+func (f *funcEmitter) emitSQLArgs(e *ast.SQLExpr) bool {
 	// SQL query is stored as a string in memory
 	f.g.internString(e.Query)
 	datum := f.g.stringDataByValue(e.Query)
 	if datum == nil {
-		return
+		return false
 	}
 
 	// Build params array if needed
@@ -4168,23 +4549,88 @@ func (f *funcEmitter) emitSQLExpr(e *ast.SQLExpr, t *types.Type) {
 	f.emit(fmt.Sprintf("(i32.const %d)", datum.length))
 	f.emit(fmt.Sprintf("(local.get %s)", paramsLocal))
 
-	switch e.Kind {
-	case ast.SQLQueryExecute:
-		// execute returns nothing
-		f.emit("(call $sqlite.sql_execute)")
-	case ast.SQLQueryFetchOne:
-		// fetch_one returns a single row object
-		f.emit("(call $sqlite.sql_fetch_one)")
-	case ast.SQLQueryFetchOptional:
-		// fetch_optional returns a single row object or null
-		f.emit("(call $sqlite.sql_fetch_optional)")
-	case ast.SQLQueryFetch, ast.SQLQueryFetchAll:
-		// fetch and fetch_all return { columns: [], rows: [] }
-		f.emit("(call $sqlite.sql_query)")
-	default:
-		// Default behavior (same as fetch_all)
-		f.emit("(call $sqlite.sql_query)")
+	if e.Kind != ast.SQLQueryExecute {
+		// 行の型をランタイムに渡し、SQLite の値を i64 / f64 / string / null に変換させる
+		f.emitStringData(f.g.sqlRowDescriptor(e))
+	}
+
+	// 接続（execute(db) の db）。省略時は undefined でデフォルト接続を使う
+	if e.Conn != nil {
+		connType := f.g.checker.ExprTypes[e.Conn]
+		f.emitExpr(e.Conn, connType)
+	} else {
+		f.emit("(call $prelude.val_undefined)")
+	}
+	return true
+}
+
+// emitStringData は収集済みの文字列データの (ptr, len) を積む。
+func (f *funcEmitter) emitStringData(value string) {
+	if value == "" {
+		f.emit("(i32.const 0)")
+		f.emit("(i32.const 0)")
+		return
+	}
+	datum := f.g.stringDataByValue(value)
+	f.emit(fmt.Sprintf("(i32.const %d)", datum.offset))
+	f.emit(fmt.Sprintf("(i32.const %d)", datum.length))
+}
+
+// sqlRowDescriptor はランタイムに渡す行の型。fetch_all<T> なら decode<T> と同じスキーマ（JSON）、
+// それ以外は create_table から推論した列の型の記述子。
+func (g *Generator) sqlRowDescriptor(e *ast.SQLExpr) string {
+	if target, ok := g.checker.SQLTargetTypes[e]; ok {
+		return decodeSchemaString(target)
+	}
+	return sqlColumnTypesDescriptor(g.checker.SQLRowTypes[e], g.checker.SQLUntypedCols[e])
+}
+
+// sqlColumnTypesDescriptor は行の型を `4:name:i64,5:label:string?` 形式（`?` は null を許す列）にする。
+// 列名は `,` や `:` を含む式（`coalesce(a, b)` など）もあるので、先頭に列名のバイト長を付ける。
+// 空文字列のときランタイムは従来どおりすべての列を文字列として返す。
+func sqlColumnTypesDescriptor(rowType *types.Type, untyped map[string]bool) string {
+	if rowType == nil || rowType.Kind != types.KindObject {
+		return ""
+	}
+	parts := make([]string, 0, len(rowType.Props))
+	for _, prop := range rowType.Props {
+		if untyped[prop.Name] {
+			// 型の分からない式の列は記述子に含めず、ランタイムが文字列化する（NULL は空文字列）
+			continue
+		}
+		t := prop.Type
+		nullable := false
+		if t.Kind == types.KindUnion {
+			var base *types.Type
+			for _, member := range t.Union {
+				if member.Kind == types.KindNull {
+					nullable = true
+				} else {
+					base = member
+				}
+			}
+			if base == nil {
+				continue
+			}
+			t = base
+		}
+		var kind string
+		switch t.Kind {
+		case types.KindI64:
+			kind = "i64"
+		case types.KindF64:
+			kind = "f64"
+		case types.KindString:
+			kind = "string"
+		default:
+			continue
+		}
+		if nullable {
+			kind += "?"
+		}
+		parts = append(parts, strconv.Itoa(len(prop.Name))+":"+prop.Name+":"+kind)
 	}
+	return strings.Join(parts, ",")
 }
 
 func (f *funcEmitter) emitSetGlobal(sym *types.Symbol) {
@@ -4398,10 +4844,13 @@ func elemType(t *types.Type) *types.Type {
 
 var intrinsicFuncNames = map[string]bool{
 	"log":               true,
+	"log_error":         true,
 	"stringify":         true,
 	"toJSON":            true,
 	"parse":             true,
 	"decode":            true,
+	"decode_all":        true,
+	"decode_csv":        true,
 	"to_string":         true,
 	"range":             true,
 	"length":            true,
@@ -4445,11 +4894,13 @@ var noImportIntrinsicNames = map[string]bool{
 }
 
 var intrinsicValueDenied = map[string]bool{
-	"add_route": true,
-	"parse":     true,
-	"decode":    true,
-	"range":     true,
-	"sqlQuery":  true,
+	"add_route":  true,
+	"parse":      true,
+	"decode":     true,
+	"decode_all": true,
+	"decode_csv": true,
+	"range":      true,
+	"sqlQuery":   true,
 }
 
 func isBuiltinModulePath(path string) bool {
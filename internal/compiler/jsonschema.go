@@ -0,0 +1,761 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"tuna/internal/ast"
+	"tuna/internal/types"
+)
+
+// tuna schema は型エイリアスと JSON Schema（draft 2020-12）を相互に変換する。
+// 書き出しは decode<T> と同じ解決済みの型から行い、同じモジュールの別の型エイリアスを
+// 参照している部分は $defs に分けて $ref で参照する。
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchema は JSON Schema のうち tuna schema が扱うキーワード（出力順）。
+type jsonSchema struct {
+	Schema               string          `json:"$schema,omitempty"`
+	Ref                  string          `json:"$ref,omitempty"`
+	Title                string          `json:"title,omitempty"`
+	Type                 interface{}     `json:"type,omitempty"` // string または []string
+	Default              json.RawMessage `json:"default,omitempty"`
+	Const                json.RawMessage `json:"const,omitempty"`
+	Enum                 []interface{}   `json:"enum,omitempty"`
+	MinLength            *int64          `json:"minLength,omitempty"`
+	MaxLength            *int64          `json:"maxLength,omitempty"`
+	Pattern              string          `json:"pattern,omitempty"`
+	Minimum              *float64        `json:"minimum,omitempty"`
+	Maximum              *float64        `json:"maximum,omitempty"`
+	PrefixItems          []*jsonSchema   `json:"prefixItems,omitempty"`
+	Items                interface{}     `json:"items,omitempty"` // *jsonSchema または false
+	MinItems             *int            `json:"minItems,omitempty"`
+	MaxItems             *int            `json:"maxItems,omitempty"`
+	Properties           jsonSchemaProps `json:"properties,omitempty"`
+	Required             []string        `json:"required,omitempty"`
+	AdditionalProperties interface{}     `json:"additionalProperties,omitempty"` // *jsonSchema または bool
+	OneOf                []*jsonSchema   `json:"oneOf,omitempty"`
+	AnyOf                []*jsonSchema   `json:"anyOf,omitempty"`
+	Defs                 jsonSchemaProps `json:"$defs,omitempty"`
+	Definitions          jsonSchemaProps `json:"definitions,omitempty"`
+}
+
+// jsonSchemaProps は宣言順を保つ properties / $defs。
+type jsonSchemaProps []jsonSchemaProp
+
+type jsonSchemaProp struct {
+	Name   string
+	Schema *jsonSchema
+}
+
+func (p jsonSchemaProps) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, prop := range p {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		key, err := json.Marshal(prop.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(prop.Schema)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(value)
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+func (p *jsonSchemaProps) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("object expected")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, _ := tok.(string)
+		var schema jsonSchema
+		if err := dec.Decode(&schema); err != nil {
+			return err
+		}
+		*p = append(*p, jsonSchemaProp{Name: name, Schema: &schema})
+	}
+	return nil
+}
+
+// UnmarshalJSON は true / false のスキーマ（任意の値 / 値なし）も受け付ける。
+func (s *jsonSchema) UnmarshalJSON(data []byte) error {
+	switch strings.TrimSpace(string(data)) {
+	case "true":
+		*s = jsonSchema{}
+		return nil
+	case "false":
+		return fmt.Errorf("schema 'false' is not supported")
+	}
+	type plain jsonSchema
+	var raw struct {
+		plain
+		Items                json.RawMessage `json:"items,omitempty"`
+		AdditionalProperties json.RawMessage `json:"additionalProperties,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = jsonSchema(raw.plain)
+	s.Items = nil
+	if len(raw.Items) > 0 && string(raw.Items) != "false" {
+		var items jsonSchema
+		if err := json.Unmarshal(raw.Items, &items); err != nil {
+			return err
+		}
+		s.Items = &items
+	}
+	s.AdditionalProperties = nil
+	if len(raw.AdditionalProperties) > 0 && string(raw.AdditionalProperties) != "false" && string(raw.AdditionalProperties) != "true" {
+		var additional jsonSchema
+		if err := json.Unmarshal(raw.AdditionalProperties, &additional); err != nil {
+			return err
+		}
+		s.AdditionalProperties = &additional
+	}
+	return nil
+}
+
+// JSONSchema は entry モジュールがエクスポートする型エイリアス typeName の JSON Schema を返す。
+func (c *Compiler) JSONSchema(entry string, typeName string) (string, error) {
+	abs, checker, err := c.check(entry)
+	if err != nil {
+		return "", err
+	}
+	mod := checker.Modules[abs]
+	if mod == nil {
+		return "", fmt.Errorf("module %s not found", entry)
+	}
+	sym := mod.Exports[typeName]
+	if sym == nil || sym.Kind != types.SymType {
+		return "", fmt.Errorf("exported type '%s' not found in %s", typeName, entry)
+	}
+	alias := mod.TypeAliases[typeName]
+	if alias == nil || len(alias.Params) > 0 {
+		return "", fmt.Errorf("type '%s' has type parameters and cannot be exported as JSON Schema", typeName)
+	}
+
+	w := &schemaWriter{names: map[*types.Type]string{}, done: map[string]bool{}}
+	// 同じモジュールの型パラメーターの無い型エイリアスを $defs の候補にする（宣言順）
+	for _, decl := range mod.AST.Decls {
+		d, ok := decl.(*ast.TypeAliasDecl)
+		if !ok || d.Name == typeName || len(d.TypeParams) > 0 {
+			continue
+		}
+		if a := mod.TypeAliases[d.Name]; a != nil && a.Template != nil && definableType(a.Template) {
+			if _, exists := w.names[a.Template]; !exists {
+				w.names[a.Template] = d.Name
+			}
+		}
+	}
+	root, err := w.schema(alias.Template)
+	if err != nil {
+		return "", fmt.Errorf("type '%s': %w", typeName, err)
+	}
+	root.Schema = jsonSchemaDraft
+	root.Title = typeName
+	root.Defs = w.defs
+	b, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// definableType は $defs に分ける型（オブジェクト・ユニオン・タプル、where の制約付きの型）なら true を返す。
+// 制約の無いプリミティブの別名は参照先に展開する。
+func definableType(t *types.Type) bool {
+	if t.Refine != nil {
+		return true
+	}
+	switch t.Kind {
+	case types.KindObject, types.KindUnion, types.KindTuple:
+		return true
+	}
+	return false
+}
+
+type schemaWriter struct {
+	names map[*types.Type]string // 型エイリアスの型 -> 名前
+	defs  jsonSchemaProps
+	done  map[string]bool
+}
+
+func (w *schemaWriter) schema(t *types.Type) (*jsonSchema, error) {
+	if name, ok := w.names[t]; ok {
+		if !w.done[name] {
+			w.done[name] = true
+			index := len(w.defs)
+			w.defs = append(w.defs, jsonSchemaProp{Name: name})
+			def, err := w.inline(t)
+			if err != nil {
+				return nil, fmt.Errorf("type '%s': %w", name, err)
+			}
+			w.defs[index].Schema = def
+		}
+		return &jsonSchema{Ref: "#/$defs/" + name}, nil
+	}
+	return w.inline(t)
+}
+
+func (w *schemaWriter) inline(t *types.Type) (*jsonSchema, error) {
+	if t == nil {
+		return nil, fmt.Errorf("unresolved type")
+	}
+	if t.Literal {
+		value, err := json.Marshal(t.LiteralValue)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Const: value}, nil
+	}
+	if r := t.Refine; r != nil {
+		base := *t
+		base.Refine = nil
+		s, err := w.inline(&base)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == types.KindArray {
+			s.MinItems, s.MaxItems = intPtr(r.MinLength), intPtr(r.MaxLength)
+		} else {
+			s.MinLength, s.MaxLength = r.MinLength, r.MaxLength
+		}
+		s.Minimum, s.Maximum = r.Min, r.Max
+		s.Pattern = r.Pattern
+		return s, nil
+	}
+	switch t.Kind {
+	case types.KindI64, types.KindI32:
+		return &jsonSchema{Type: "integer"}, nil
+	case types.KindF64:
+		return &jsonSchema{Type: "number"}, nil
+	case types.KindBool:
+		return &jsonSchema{Type: "boolean"}, nil
+	case types.KindString:
+		return &jsonSchema{Type: "string"}, nil
+	case types.KindNull:
+		return &jsonSchema{Type: "null"}, nil
+	case types.KindJSON:
+		// 任意の JSON 値
+		return &jsonSchema{}, nil
+	case types.KindArray:
+		elem, err := w.schema(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "array", Items: elem}, nil
+	case types.KindTuple:
+		s := &jsonSchema{Type: "array", Items: false}
+		for _, e := range t.Tuple {
+			elem, err := w.schema(e)
+			if err != nil {
+				return nil, err
+			}
+			s.PrefixItems = append(s.PrefixItems, elem)
+		}
+		n := len(t.Tuple)
+		s.MinItems, s.MaxItems = &n, &n
+		return s, nil
+	case types.KindObject:
+		s := &jsonSchema{Type: "object"}
+		for _, p := range t.Props {
+			// T | undefined のプロパティは省略できる（decode<T> と同じ）
+			propType, optional := withoutUndefined(p.Type)
+			prop, err := w.schema(propType)
+			if err != nil {
+				return nil, fmt.Errorf("property '%s': %w", p.Name, err)
+			}
+			// key: T = value のプロパティは省略でき、decode で value になる
+			if p.HasDefault {
+				if prop.Default, err = json.Marshal(p.Default); err != nil {
+					return nil, err
+				}
+			}
+			s.Properties = append(s.Properties, jsonSchemaProp{Name: p.Name, Schema: prop})
+			if !optional && !p.HasDefault {
+				s.Required = append(s.Required, p.Name)
+			}
+		}
+		if t.Index != nil {
+			index, err := w.schema(t.Index)
+			if err != nil {
+				return nil, err
+			}
+			s.AdditionalProperties = index
+		}
+		return s, nil
+	case types.KindUnion:
+		members, _ := withoutUndefined(t)
+		if members.Kind != types.KindUnion {
+			return w.schema(members)
+		}
+		s := &jsonSchema{}
+		for _, m := range members.Union {
+			member, err := w.schema(m)
+			if err != nil {
+				return nil, err
+			}
+			s.OneOf = append(s.OneOf, member)
+		}
+		return s, nil
+	case types.KindUndefined:
+		return nil, fmt.Errorf("undefined has no JSON representation")
+	}
+	return nil, fmt.Errorf("%s cannot be represented in JSON Schema", typeKindName(t.Kind))
+}
+
+func intPtr(n *int64) *int {
+	if n == nil {
+		return nil
+	}
+	v := int(*n)
+	return &v
+}
+
+// withoutUndefined は t から undefined を除いた型と、undefined を含んでいたかを返す。
+func withoutUndefined(t *types.Type) (*types.Type, bool) {
+	if t == nil || t.Kind != types.KindUnion {
+		return t, false
+	}
+	var members []*types.Type
+	found := false
+	for _, m := range t.Union {
+		if m.Kind == types.KindUndefined {
+			found = true
+			continue
+		}
+		members = append(members, m)
+	}
+	if !found {
+		return t, false
+	}
+	if len(members) == 1 {
+		return members[0], true
+	}
+	return types.NewUnion(members), true
+}
+
+func typeKindName(kind types.Kind) string {
+	switch kind {
+	case types.KindFunc:
+		return "function type"
+	case types.KindVoid:
+		return "void"
+	case types.KindTypeParam:
+		return "type parameter"
+	}
+	return "type"
+}
+
+// ImportJSONSchema は JSON Schema から型エイリアスを生成する。ルートのスキーマは title（無いか override なら name）、
+// $defs / definitions のスキーマはそれぞれの名前の型エイリアスになり、参照より先に宣言される。
+// minLength などの制約は where になり、プロパティなどの内側にあるものは名前を付けた型エイリアスに分ける。
+// decoder が扱えない pattern は取り込まない。
+func ImportJSONSchema(data []byte, name string, override bool) (*ast.Module, error) {
+	var root jsonSchema
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: %w", err)
+	}
+	r := &schemaReader{defs: map[string]*jsonSchema{}, aliases: map[string]string{}, state: map[string]int{}, used: map[string]bool{}, mod: &ast.Module{}}
+	for _, defs := range []jsonSchemaProps{root.Defs, root.Definitions} {
+		for _, def := range defs {
+			r.defs[def.Name] = def.Schema
+			r.order = append(r.order, def.Name)
+		}
+	}
+	rootName := typeAliasName(root.Title)
+	if rootName == "" || override {
+		rootName = typeAliasName(name)
+	}
+	if rootName == "" || builtinTypeNames[rootName] {
+		return nil, fmt.Errorf("cannot determine a type name: set \"title\" in the schema")
+	}
+	// 名前は定義の順に割り当て、ルートと衝突した定義には別名を付ける
+	r.used[rootName] = true
+	for _, def := range r.order {
+		r.aliases[def] = r.uniqueName(typeAliasName(def))
+	}
+	for _, def := range r.order {
+		if err := r.define(def); err != nil {
+			return nil, err
+		}
+	}
+	rootType, where, err := r.typeExprWhere(&root, rootName)
+	if err != nil {
+		return nil, err
+	}
+	r.mod.Decls = append(r.mod.Decls, &ast.TypeAliasDecl{Name: rootName, Export: true, Type: rootType, Where: where})
+	return r.mod, nil
+}
+
+type schemaReader struct {
+	defs    map[string]*jsonSchema
+	order   []string
+	aliases map[string]string // $defs の名前 -> 型エイリアス名
+	state   map[string]int    // 1: 変換中, 2: 宣言済み
+	used    map[string]bool   // 使用済みの型エイリアス名
+	mod     *ast.Module
+}
+
+// uniqueName は base（空なら Type）をまだ使っていない型エイリアス名にして予約する。
+func (r *schemaReader) uniqueName(base string) string {
+	name := base
+	for i := 2; name == "" || r.used[name] || builtinTypeNames[name]; i++ {
+		if base == "" {
+			base = "Type"
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	r.used[name] = true
+	return name
+}
+
+func (r *schemaReader) define(name string) error {
+	switch r.state[name] {
+	case 1:
+		return fmt.Errorf("recursive schema '%s' is not supported", name)
+	case 2:
+		return nil
+	}
+	r.state[name] = 1
+	expr, where, err := r.typeExprWhere(r.defs[name], r.aliases[name])
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	r.state[name] = 2
+	r.mod.Decls = append(r.mod.Decls, &ast.TypeAliasDecl{Name: r.aliases[name], Export: true, Type: expr, Where: where})
+	return nil
+}
+
+// typeExpr は s の型を返す。制約があれば hint から名前を付けた where 付きの型エイリアスを宣言して参照する。
+func (r *schemaReader) typeExpr(s *jsonSchema, hint string) (ast.TypeExpr, error) {
+	expr, where, err := r.typeExprWhere(s, hint)
+	if err != nil || len(where) == 0 {
+		return expr, err
+	}
+	name := r.uniqueName(hint)
+	r.mod.Decls = append(r.mod.Decls, &ast.TypeAliasDecl{Name: name, Export: true, Type: expr, Where: where})
+	return &ast.NamedType{Name: name}, nil
+}
+
+// schemaRefinements は kind の型に付けられる s の制約を where にして返す。
+func schemaRefinements(s *jsonSchema, kind string) []ast.TypeRefinement {
+	var where []ast.TypeRefinement
+	add := func(name string, value ast.Expr) {
+		where = append(where, ast.TypeRefinement{Name: name, Value: value})
+	}
+	switch kind {
+	case "string":
+		if s.MinLength != nil {
+			add("min_length", &ast.IntLit{Value: *s.MinLength})
+		}
+		if s.MaxLength != nil {
+			add("max_length", &ast.IntLit{Value: *s.MaxLength})
+		}
+		if s.Pattern != "" && types.ValidatePattern(s.Pattern) == nil {
+			add("pattern", &ast.StringLit{Value: s.Pattern})
+		}
+	case "integer", "number":
+		if s.Minimum != nil {
+			add("min", jsonValueExpr(*s.Minimum))
+		}
+		if s.Maximum != nil {
+			add("max", jsonValueExpr(*s.Maximum))
+		}
+	case "array":
+		if len(s.PrefixItems) > 0 {
+			break
+		}
+		if s.MinItems != nil {
+			add("min_length", &ast.IntLit{Value: int64(*s.MinItems)})
+		}
+		if s.MaxItems != nil {
+			add("max_length", &ast.IntLit{Value: int64(*s.MaxItems)})
+		}
+	}
+	return where
+}
+
+// typeExprWhere は s の型と、型エイリアスの where にする制約（型が1種類のときだけ）を返す。
+func (r *schemaReader) typeExprWhere(s *jsonSchema, hint string) (ast.TypeExpr, []ast.TypeRefinement, error) {
+	if s.Ref != "" {
+		name := ""
+		for _, prefix := range []string{"#/$defs/", "#/definitions/"} {
+			if strings.HasPrefix(s.Ref, prefix) {
+				name = strings.ReplaceAll(strings.ReplaceAll(strings.TrimPrefix(s.Ref, prefix), "~1", "/"), "~0", "~")
+			}
+		}
+		if _, ok := r.defs[name]; !ok {
+			return nil, nil, fmt.Errorf("unsupported $ref '%s'", s.Ref)
+		}
+		if err := r.define(name); err != nil {
+			return nil, nil, err
+		}
+		return &ast.NamedType{Name: r.aliases[name]}, nil, nil
+	}
+	if len(s.Const) > 0 {
+		var value interface{}
+		if err := json.Unmarshal(s.Const, &value); err != nil {
+			return nil, nil, err
+		}
+		expr, err := literalTypeExpr(value)
+		return expr, nil, err
+	}
+	if len(s.Enum) > 0 {
+		var members []ast.TypeExpr
+		for _, value := range s.Enum {
+			member, err := literalTypeExpr(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			members = append(members, member)
+		}
+		return unionTypeExpr(members), nil, nil
+	}
+	if alts := append(append([]*jsonSchema(nil), s.OneOf...), s.AnyOf...); len(alts) > 0 {
+		var members []ast.TypeExpr
+		for i, alt := range alts {
+			member, err := r.typeExpr(alt, fmt.Sprintf("%s%d", hint, i+1))
+			if err != nil {
+				return nil, nil, err
+			}
+			members = append(members, member)
+		}
+		return unionTypeExpr(members), nil, nil
+	}
+
+	var kinds []string
+	switch t := s.Type.(type) {
+	case string:
+		kinds = []string{t}
+	case []interface{}:
+		for _, k := range t {
+			if ks, ok := k.(string); ok {
+				kinds = append(kinds, ks)
+			}
+		}
+	}
+	if len(kinds) == 0 {
+		switch {
+		case len(s.Properties) > 0 || s.AdditionalProperties != nil:
+			kinds = []string{"object"}
+		case s.Items != nil || len(s.PrefixItems) > 0:
+			kinds = []string{"array"}
+		default:
+			// 制約の無いスキーマは任意の JSON 値
+			return &ast.NamedType{Name: "json"}, nil, nil
+		}
+	}
+	var members []ast.TypeExpr
+	for _, kind := range kinds {
+		member, err := r.kindTypeExpr(s, kind, hint)
+		if err != nil {
+			return nil, nil, err
+		}
+		members = append(members, member)
+	}
+	if len(kinds) == 1 {
+		return members[0], schemaRefinements(s, kinds[0]), nil
+	}
+	return unionTypeExpr(members), nil, nil
+}
+
+func (r *schemaReader) kindTypeExpr(s *jsonSchema, kind string, hint string) (ast.TypeExpr, error) {
+	switch kind {
+	case "integer":
+		return &ast.NamedType{Name: "i64"}, nil
+	case "number":
+		return &ast.NamedType{Name: "f64"}, nil
+	case "boolean":
+		return &ast.NamedType{Name: "boolean"}, nil
+	case "string":
+		return &ast.NamedType{Name: "string"}, nil
+	case "null":
+		return &ast.NamedType{Name: "null"}, nil
+	case "array":
+		if len(s.PrefixItems) > 0 {
+			var elems []ast.TypeExpr
+			for i, item := range s.PrefixItems {
+				elem, err := r.typeExpr(item, fmt.Sprintf("%s%d", hint, i+1))
+				if err != nil {
+					return nil, err
+				}
+				elems = append(elems, elem)
+			}
+			return &ast.TupleType{Elems: elems}, nil
+		}
+		if items, ok := s.Items.(*jsonSchema); ok {
+			elem, err := r.typeExpr(items, hint+"Item")
+			if err != nil {
+				return nil, err
+			}
+			return &ast.ArrayType{Elem: elem}, nil
+		}
+		return &ast.ArrayType{Elem: &ast.NamedType{Name: "json"}}, nil
+	case "object":
+		if len(s.Properties) == 0 {
+			value := ast.TypeExpr(&ast.NamedType{Name: "json"})
+			if additional, ok := s.AdditionalProperties.(*jsonSchema); ok {
+				var err error
+				if value, err = r.typeExpr(additional, hint+"Value"); err != nil {
+					return nil, err
+				}
+			}
+			return &ast.GenericType{Name: "Map", Args: []ast.TypeExpr{value}}, nil
+		}
+		required := map[string]bool{}
+		for _, name := range s.Required {
+			required[name] = true
+		}
+		obj := &ast.ObjectType{}
+		for _, prop := range s.Properties {
+			propType, err := r.typeExpr(prop.Schema, hint+exportedName(typeAliasName(prop.Name)))
+			if err != nil {
+				return nil, fmt.Errorf("property '%s': %w", prop.Name, err)
+			}
+			// default のあるプロパティは key: T = value にする
+			var defaultValue ast.Expr
+			if len(prop.Schema.Default) > 0 {
+				var value interface{}
+				if err := json.Unmarshal(prop.Schema.Default, &value); err != nil {
+					return nil, fmt.Errorf("property '%s': %w", prop.Name, err)
+				}
+				defaultValue = jsonValueExpr(value)
+			} else if !required[prop.Name] {
+				propType = unionTypeExpr([]ast.TypeExpr{propType, &ast.NamedType{Name: "undefined"}})
+			}
+			obj.Props = append(obj.Props, ast.TypeProp{Key: prop.Name, KeyQuoted: !isIdentName(prop.Name), Type: propType, Default: defaultValue})
+		}
+		return obj, nil
+	}
+	return nil, fmt.Errorf("unsupported type '%s'", kind)
+}
+
+// jsonValueExpr は JSON 値を定数式（デフォルト値や制約の値）にする。
+func jsonValueExpr(value interface{}) ast.Expr {
+	switch v := value.(type) {
+	case string:
+		return &ast.StringLit{Value: v}
+	case bool:
+		return &ast.BoolLit{Value: v}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return &ast.IntLit{Value: int64(v)}
+		}
+		return &ast.FloatLit{Value: v}
+	case []interface{}:
+		arr := &ast.ArrayLit{}
+		for _, elem := range v {
+			arr.Entries = append(arr.Entries, ast.ArrayEntry{Kind: ast.ArrayValue, Value: jsonValueExpr(elem)})
+		}
+		return arr
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		obj := &ast.ObjectLit{}
+		for _, key := range keys {
+			obj.Entries = append(obj.Entries, ast.ObjectEntry{Kind: ast.ObjectProp, Key: key, KeyQuoted: !isIdentName(key), Value: jsonValueExpr(v[key])})
+		}
+		return obj
+	}
+	return &ast.NullLit{}
+}
+
+// exportedName は name の先頭を大文字にする（内側の型エイリアス名の組み立て用）。
+func exportedName(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func literalTypeExpr(value interface{}) (ast.TypeExpr, error) {
+	switch v := value.(type) {
+	case string:
+		return &ast.LiteralType{Value: &ast.StringLit{Value: v}}, nil
+	case bool:
+		return &ast.LiteralType{Value: &ast.BoolLit{Value: v}}, nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return &ast.LiteralType{Value: &ast.IntLit{Value: int64(v)}}, nil
+		}
+		return &ast.LiteralType{Value: &ast.FloatLit{Value: v}}, nil
+	case nil:
+		return &ast.NamedType{Name: "null"}, nil
+	}
+	return nil, fmt.Errorf("unsupported const value %v", value)
+}
+
+// unionTypeExpr は members のユニオン（1つならそのまま）を返す。入れ子のユニオンは平坦にする。
+func unionTypeExpr(members []ast.TypeExpr) ast.TypeExpr {
+	var flat []ast.TypeExpr
+	for _, m := range members {
+		if u, ok := m.(*ast.UnionType); ok {
+			flat = append(flat, u.Types...)
+		} else {
+			flat = append(flat, m)
+		}
+	}
+	if len(flat) == 1 {
+		return flat[0]
+	}
+	return &ast.UnionType{Types: flat}
+}
+
+// typeAliasName は name を型エイリアス名に使える識別子にする（使えない文字は _ に置き換える）。
+func typeAliasName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return strings.Trim(b.String(), "_")
+}
+
+func isIdentName(name string) bool {
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// builtinTypeNames は型エイリアス名に使えない組み込みの型名。
+var builtinTypeNames = map[string]bool{
+	"i64": true, "f64": true, "boolean": true, "string": true, "null": true,
+	"undefined": true, "json": true, "void": true, "Map": true, "Array": true,
+}
@@ -216,6 +216,8 @@ func annotateExpr(expr ast.Expr, checker *ttypes.Checker) {
 		for _, s := range e.Stmts {
 			annotateStmt(s, checker)
 		}
+	case *ast.TransactionExpr:
+		annotateBlock(e.Body, checker)
 	case *ast.ArrowFunc:
 		// Use checker-inferred function signature
 		if sig, ok := checker.ExprTypes[e]; ok && sig != nil && sig.Kind == ttypes.KindFunc {
@@ -235,6 +237,9 @@ func annotateExpr(expr ast.Expr, checker *ttypes.Checker) {
 			annotateExpr(e.Expr, checker)
 		}
 	case *ast.SQLExpr:
+		if e.Conn != nil {
+			annotateExpr(e.Conn, checker)
+		}
 		for _, p := range e.Params {
 			annotateExpr(p, checker)
 		}
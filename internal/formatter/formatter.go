@@ -165,6 +165,9 @@ func (f *Formatter) formatImport(imp ast.ImportDecl) {
 			f.buf.WriteString(" from \"")
 			f.buf.WriteString(imp.From)
 			f.buf.WriteString("\"")
+			if imp.AsDir {
+				f.buf.WriteString(" as dir")
+			}
 			f.writeInlineCommentsForLine(imp.Span.Start.Line)
 			f.buf.WriteString("\n")
 			return
@@ -203,6 +206,10 @@ func (f *Formatter) formatDecl(decl ast.Decl) {
 		f.formatTypeAliasDecl(d)
 	case *ast.TableDecl:
 		f.formatTableDecl(d)
+	case *ast.MigrationDecl:
+		f.formatMigrationDecl(d)
+	case *ast.IndexDecl:
+		f.formatIndexDecl(d)
 	}
 }
 
@@ -297,6 +304,10 @@ func (f *Formatter) formatExternFuncDecl(d *ast.ExternFuncDecl) {
 func (f *Formatter) formatTableDecl(d *ast.TableDecl) {
 	f.writeIndent()
 	f.buf.WriteString("create_table ")
+	if d.Connection != "" {
+		f.buf.WriteString(d.Connection)
+		f.buf.WriteString(".")
+	}
 	f.buf.WriteString(d.Name)
 	f.buf.WriteString(" {\n")
 	f.indent++
@@ -312,6 +323,67 @@ func (f *Formatter) formatTableDecl(d *ast.TableDecl) {
 		f.buf.WriteString(",")
 		f.buf.WriteString("\n")
 	}
+	for _, con := range d.Constraints {
+		f.writeIndent()
+		f.buf.WriteString(con.SQL)
+		f.buf.WriteString(",\n")
+	}
+	f.indent--
+	f.writeIndent()
+	f.buf.WriteString("}\n")
+}
+
+func (f *Formatter) formatIndexDecl(d *ast.IndexDecl) {
+	f.writeIndent()
+	f.buf.WriteString("create_index ")
+	if d.Unique {
+		f.buf.WriteString("unique ")
+	}
+	f.buf.WriteString(d.Name)
+	f.buf.WriteString(" on ")
+	if d.Connection != "" {
+		f.buf.WriteString(d.Connection)
+		f.buf.WriteString(".")
+	}
+	f.buf.WriteString(d.Table)
+	f.buf.WriteString(" (")
+	f.buf.WriteString(strings.Join(d.Columns, ", "))
+	f.buf.WriteString(")")
+	f.writeInlineCommentsForLine(d.Span.Start.Line)
+	f.buf.WriteString("\n")
+}
+
+func (f *Formatter) formatMigrationDecl(d *ast.MigrationDecl) {
+	f.writeIndent()
+	f.buf.WriteString("migration \"")
+	f.buf.WriteString(escapeString(d.Name))
+	f.buf.WriteString("\" {\n")
+	f.indent++
+	// 2行目以降は共通の字下げを取り除いてから現在のインデントで書き直す
+	lines := strings.Split(d.SQL, "\n")
+	common := -1
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if common < 0 || n < common {
+			common = n
+		}
+	}
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if i > 0 && common > 0 && len(line) >= common {
+			line = line[common:]
+		}
+		if line == "" {
+			f.buf.WriteString("\n")
+			continue
+		}
+		f.writeIndent()
+		f.buf.WriteString(line)
+		f.buf.WriteString("\n")
+	}
 	f.indent--
 	f.writeIndent()
 	f.buf.WriteString("}\n")
@@ -337,6 +409,18 @@ func (f *Formatter) formatTypeAliasDecl(d *ast.TypeAliasDecl) {
 	}
 	f.buf.WriteString(" = ")
 	f.formatType(d.Type)
+	if len(d.Where) > 0 {
+		f.buf.WriteString(" where { ")
+		for i, r := range d.Where {
+			if i > 0 {
+				f.buf.WriteString(", ")
+			}
+			f.buf.WriteString(r.Name)
+			f.buf.WriteString(": ")
+			f.formatExpr(r.Value)
+		}
+		f.buf.WriteString(", }")
+	}
 	f.writeInlineCommentsForLine(d.Span.Start.Line)
 	f.buf.WriteString("\n")
 }
@@ -583,6 +667,9 @@ func (f *Formatter) formatExpr(expr ast.Expr) {
 		f.formatBlockExpr(e)
 	case *ast.ArrowFunc:
 		f.formatArrowFunc(e)
+	case *ast.TransactionExpr:
+		f.buf.WriteString("transaction ")
+		f.formatBlockStmt(e.Body)
 	case *ast.SQLExpr:
 		f.formatSQLExpr(e)
 	case *ast.JSXElement:
@@ -1054,6 +1141,18 @@ func (f *Formatter) formatSQLExpr(e *ast.SQLExpr) {
 		f.buf.WriteString("fetch")
 	case ast.SQLQueryFetchAll:
 		f.buf.WriteString("fetch_all")
+	case ast.SQLQueryFetchIter:
+		f.buf.WriteString("fetch_iter")
+	}
+	if e.RowType != nil {
+		f.buf.WriteString("<")
+		f.formatType(e.RowType)
+		f.buf.WriteString(">")
+	}
+	if e.Conn != nil {
+		f.buf.WriteString("(")
+		f.buf.WriteString(f.exprToString(e.Conn))
+		f.buf.WriteString(")")
 	}
 	f.buf.WriteString(" {\n")
 	f.indent++
@@ -1186,6 +1285,10 @@ func (f *Formatter) formatType(t ast.TypeExpr) {
 			f.formatObjectKey(prop.Key, prop.KeyQuoted)
 			f.buf.WriteString(": ")
 			f.formatType(prop.Type)
+			if prop.Default != nil {
+				f.buf.WriteString(" = ")
+				f.formatExpr(prop.Default)
+			}
 		}
 		if len(ty.Props) > 0 {
 			f.buf.WriteString(",")
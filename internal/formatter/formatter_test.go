@@ -124,3 +124,139 @@ function main(payload: json): Task | error {
 		t.Fatalf("formatted output should preserve call type arguments\n%s", out)
 	}
 }
+
+func TestFormatMigrationDecl(t *testing.T) {
+	src := `migration   "002_add_due_date"   {
+        ALTER TABLE todos ADD COLUMN due_date TEXT;
+        UPDATE todos
+          SET due_date = '{none}'
+}
+const migration: string = "x"
+`
+	want := `migration "002_add_due_date" {
+  ALTER TABLE todos ADD COLUMN due_date TEXT;
+  UPDATE todos
+    SET due_date = '{none}'
+}
+`
+	out, err := New().Format("sample.tuna", src)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	if !strings.HasPrefix(out, want) {
+		t.Fatalf("unexpected format output:\n%s", out)
+	}
+	again, err := New().Format("sample.tuna", out)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	if again != out {
+		t.Fatalf("format is not idempotent:\n%s\n---\n%s", out, again)
+	}
+}
+
+func TestFormatNamedConnections(t *testing.T) {
+	src := `create_table archive.logs {
+  id INTEGER PRIMARY KEY,
+}
+
+function main(archive: Db): void {
+  const rows = fetch_all( archive ) {
+    SELECT id FROM logs
+  }
+  const first = fetch_one< Log >( archive ) {
+    SELECT id FROM logs
+  }
+  for (const log of fetch_iter( archive ) { SELECT id FROM logs }) {
+  }
+}
+`
+	out, err := New().Format("sample.tuna", src)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	for _, want := range []string{"create_table archive.logs {", "fetch_all(archive) {", "fetch_one<Log>(archive) {", "for (const log of fetch_iter(archive) {"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("formatted output is missing %q\n%s", want, out)
+		}
+	}
+}
+
+func TestFormatTableConstraintsAndIndexes(t *testing.T) {
+	src := `create_table posts {
+  id INTEGER PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  FOREIGN KEY (user_id)   REFERENCES users (id),
+  UNIQUE(user_id, id)
+}
+create_index   unique posts_by_user on archive.posts ( user_id,id )
+`
+	out, err := New().Format("sample.tuna", src)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	for _, want := range []string{
+		"  FOREIGN KEY (user_id) REFERENCES users (id),\n",
+		"  UNIQUE(user_id, id),\n}",
+		"create_index unique posts_by_user on archive.posts (user_id, id)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("formatted output is missing %q\n%s", want, out)
+		}
+	}
+}
+
+func TestFormatTransactionBlock(t *testing.T) {
+	src := `function main(): i64 | error {
+  const n = transaction {
+      execute {
+        DELETE FROM logs
+      }?
+    1
+  }
+  return n
+}
+`
+	want := `function main(): i64 | error {
+  const n = transaction {
+    execute {
+      DELETE FROM logs
+    }?
+    1
+  }
+  return n
+}
+`
+	out, err := New().Format("sample.tuna", src)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	if out != want {
+		t.Fatalf("unexpected format output:\n%s", out)
+	}
+}
+
+func TestFormatTypeRefinementsAndDefaults(t *testing.T) {
+	src := `type Name = string   where {min_length:1,max_length: 20, pattern: "^[a-z]+$"}
+type User = { name: Name, role: "admin" | "member"="member", tags: string[] = [] }
+`
+	out, err := New().Format("sample.tuna", src)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	for _, want := range []string{
+		`type Name = string where { min_length: 1, max_length: 20, pattern: "^[a-z]+$", }`,
+		`role: "admin" | "member" = "member", tags: string[] = [],`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("formatted output is missing %q\n%s", want, out)
+		}
+	}
+	again, err := New().Format("sample.tuna", out)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	if again != out {
+		t.Fatalf("format is not idempotent:\n%s\n---\n%s", out, again)
+	}
+}
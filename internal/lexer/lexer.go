@@ -54,50 +54,22 @@ func (l *Lexer) Next() Token {
 	if isIdentStart(ch) {
 		text := l.readIdent()
 		if kind, ok := keywords[text]; ok {
-			// Special handling for execute keyword: check for execute { ... } block
-			if kind == TokenExecute {
+			// Special handling for SQL keywords: check for fetch_all[<T>][(conn)] { ... } blocks
+			if blockKind, ok := sqlBlockKinds[kind]; ok {
+				savedPos, savedLine, savedCol, savedComments := l.pos, l.line, l.col, len(l.comments)
 				l.skipSpace()
-				if !l.eof() && l.peek() == '{' {
-					l.advance() // consume '{'
-					sqlContent, params := l.readSQLBlock()
-					return Token{Kind: TokenExecuteBlock, Text: sqlContent, Pos: startPos, SQLParams: params}
-				}
-			}
-			// Special handling for fetch_optional keyword: check for fetch_optional { ... } block
-			if kind == TokenFetchOptional {
-				l.skipSpace()
-				if !l.eof() && l.peek() == '{' {
-					l.advance() // consume '{'
-					sqlContent, params := l.readSQLBlock()
-					return Token{Kind: TokenFetchOptionalBlock, Text: sqlContent, Pos: startPos, SQLParams: params}
-				}
-			}
-			// Special handling for fetch_one keyword: check for fetch_one { ... } block
-			if kind == TokenFetchOne {
-				l.skipSpace()
-				if !l.eof() && l.peek() == '{' {
-					l.advance() // consume '{'
-					sqlContent, params := l.readSQLBlock()
-					return Token{Kind: TokenFetchOneBlock, Text: sqlContent, Pos: startPos, SQLParams: params}
+				rowType, ok := l.readSQLType()
+				var conn string
+				if ok {
+					conn, ok = l.readSQLConn()
 				}
-			}
-			// Special handling for fetch keyword: check for fetch { ... } block
-			if kind == TokenFetch {
-				l.skipSpace()
-				if !l.eof() && l.peek() == '{' {
+				if ok && !l.eof() && l.peek() == '{' {
 					l.advance() // consume '{'
-					sqlContent, params := l.readSQLBlock()
-					return Token{Kind: TokenFetchBlock, Text: sqlContent, Pos: startPos, SQLParams: params}
-				}
-			}
-			// Special handling for fetch_all keyword: check for fetch_all { ... } block
-			if kind == TokenFetchAll {
-				l.skipSpace()
-				if !l.eof() && l.peek() == '{' {
-					l.advance() // consume '{'
-					sqlContent, params := l.readSQLBlock()
-					return Token{Kind: TokenFetchAllBlock, Text: sqlContent, Pos: startPos, SQLParams: params}
+					sqlContent, params, positions, paramOffsets := l.readSQLBlock()
+					return Token{Kind: blockKind, Text: sqlContent, Pos: startPos, SQLParams: params, SQLPositions: positions, SQLParamOffsets: paramOffsets, SQLConn: conn, SQLType: rowType}
 				}
+				l.pos, l.line, l.col = savedPos, savedLine, savedCol
+				l.comments = l.comments[:savedComments]
 			}
 			// Special handling for create_table keyword: check for create_table name { ... } block
 			if kind == TokenTable {
@@ -105,6 +77,11 @@ func (l *Lexer) Next() Token {
 				// Read the table name
 				if !l.eof() && isIdentStart(l.peek()) {
 					tableName := l.readIdent()
+					// create_table conn.name { ... }
+					if !l.eof() && l.peek() == '.' && l.pos+1 < len(l.src) && isIdentStart(rune(l.src[l.pos+1])) {
+						l.advance()
+						tableName += "." + l.readIdent()
+					}
 					l.skipSpace()
 					if !l.eof() && l.peek() == '{' {
 						l.advance() // consume '{'
@@ -115,6 +92,12 @@ func (l *Lexer) Next() Token {
 			}
 			return Token{Kind: kind, Text: text, Pos: startPos}
 		}
+		// migration "name" { ... } は文脈依存のキーワードとして扱う
+		if text == "migration" {
+			if tok, ok := l.tryMigrationBlock(startPos); ok {
+				return tok
+			}
+		}
 		return Token{Kind: TokenIdent, Text: text, Pos: startPos}
 	}
 	if isDigit(ch) {
@@ -462,9 +445,24 @@ func (l *Lexer) readTemplateLiteral() (string, []string) {
 
 // readSQLBlock reads raw SQL content until matching closing brace
 // It extracts parameter expressions from {expr} and replaces them with ?
-func (l *Lexer) readSQLBlock() (string, []string) {
+// Token.SQLPositions / SQLParamOffsets にクエリ文字列の各バイトのソース位置と、
+// {expr} から置き換えた ? の位置を記録する（SQL の検証エラーをブロック内の位置で報告するため）。
+func (l *Lexer) readSQLBlock() (string, []string, []Position, []int) {
 	var b strings.Builder
 	var params []string
+	var positions []Position
+	var paramOffsets []int
+	write := func(r rune, pos Position) {
+		n, _ := b.WriteRune(r)
+		for i := 0; i < n; i++ {
+			positions = append(positions, pos)
+		}
+	}
+	// emit は現在の文字をクエリに書き出して1文字進める。
+	emit := func() {
+		write(l.peek(), Position{Line: l.line, Col: l.col})
+		l.advance()
+	}
 	depth := 1
 	for !l.eof() && depth > 0 {
 		ch := l.peek()
@@ -473,60 +471,63 @@ func (l *Lexer) readSQLBlock() (string, []string) {
 			// Check if this is a parameter expression {expr}
 			// We need to distinguish between SQL's use of {} and our parameter syntax
 			// Parameters start with { followed by an identifier or expression
+			bracePos := Position{Line: l.line, Col: l.col}
 			l.advance() // consume '{'
 			l.skipSpace()
 			if !l.eof() && (isIdentStart(l.peek()) || l.peek() == '(' || l.peek() == '"' || l.peek() == '\'' || isDigit(l.peek())) {
 				// This is a parameter expression
 				paramExpr := l.readParamExpr()
 				params = append(params, paramExpr)
-				b.WriteRune('?') // Replace with placeholder
+				paramOffsets = append(paramOffsets, b.Len())
+				write('?', bracePos) // Replace with placeholder
 			} else {
 				// Not a parameter, just regular SQL brace
 				depth++
-				b.WriteRune('{')
+				write('{', bracePos)
 			}
 		case '}':
 			depth--
 			if depth > 0 {
-				b.WriteRune(ch)
+				emit()
+			} else {
+				l.advance()
 			}
-			l.advance()
 		case '\'', '"':
 			// Handle string literals inside SQL
 			quote := ch
-			b.WriteRune(ch)
-			l.advance()
+			emit()
 			for !l.eof() {
 				c := l.peek()
-				b.WriteRune(c)
-				l.advance()
+				emit()
 				if c == quote {
 					break
 				}
 				if c == '\\' && !l.eof() {
-					c2 := l.peek()
-					b.WriteRune(c2)
-					l.advance()
+					emit()
 				}
 			}
 		case '-':
 			// Handle SQL single-line comments
-			b.WriteRune(ch)
-			l.advance()
+			emit()
 			if !l.eof() && l.peek() == '-' {
-				b.WriteRune(l.peek())
-				l.advance()
+				emit()
 				for !l.eof() && l.peek() != '\n' {
-					b.WriteRune(l.peek())
-					l.advance()
+					emit()
 				}
 			}
 		default:
-			b.WriteRune(ch)
-			l.advance()
+			emit()
 		}
 	}
-	return strings.TrimSpace(b.String()), params
+	query := b.String()
+	trimmed := strings.TrimLeftFunc(query, unicode.IsSpace)
+	lead := len(query) - len(trimmed)
+	trimmed = strings.TrimRightFunc(trimmed, unicode.IsSpace)
+	positions = positions[lead : lead+len(trimmed)]
+	for i := range paramOffsets {
+		paramOffsets[i] -= lead
+	}
+	return trimmed, params, positions, paramOffsets
 }
 
 // readParamExpr reads a parameter expression from inside {expr}
@@ -604,6 +605,151 @@ func (l *Lexer) readTableBlock() string {
 	return strings.TrimSpace(b.String())
 }
 
+// sqlBlockKinds maps SQL query keywords to their block tokens.
+var sqlBlockKinds = map[TokenKind]TokenKind{
+	TokenExecute:       TokenExecuteBlock,
+	TokenFetchOptional: TokenFetchOptionalBlock,
+	TokenFetchOne:      TokenFetchOneBlock,
+	TokenFetch:         TokenFetchBlock,
+	TokenFetchAll:      TokenFetchAllBlock,
+	TokenFetchIter:     TokenFetchIterBlock,
+}
+
+// readSQLConn reads the optional `(conn)` between an SQL keyword and its block.
+// It returns the expression source ("" when absent). If the parentheses are not
+// followed by '{', the lexer state is restored and ok is false.
+func (l *Lexer) readSQLConn() (string, bool) {
+	if l.eof() || l.peek() != '(' {
+		return "", true
+	}
+	savedPos, savedLine, savedCol, savedComments := l.pos, l.line, l.col, len(l.comments)
+	l.advance() // consume '('
+	start := l.pos
+	depth := 1
+	for !l.eof() && depth > 0 {
+		switch ch := l.peek(); ch {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '"', '\'':
+			l.readString(ch)
+			continue
+		}
+		if depth == 0 {
+			break
+		}
+		l.advance()
+	}
+	conn := strings.TrimSpace(l.src[start:l.pos])
+	if !l.eof() {
+		l.advance() // consume ')'
+	}
+	l.skipSpace()
+	if depth == 0 && conn != "" && !l.eof() && l.peek() == '{' {
+		return conn, true
+	}
+	l.pos, l.line, l.col = savedPos, savedLine, savedCol
+	l.comments = l.comments[:savedComments]
+	return "", false
+}
+
+// readSQLType reads the optional `<T>` row type after an SQL keyword and skips the following spaces.
+// ok is false if a '<' is not closed.
+func (l *Lexer) readSQLType() (string, bool) {
+	if l.eof() || l.peek() != '<' {
+		return "", true
+	}
+	l.advance() // consume '<'
+	start := l.pos
+	depth := 1
+	for !l.eof() {
+		ch := l.peek()
+		if ch == '<' {
+			depth++
+		} else if ch == '>' {
+			depth--
+			if depth == 0 {
+				break
+			}
+		} else if ch == '\n' || ch == ';' {
+			return "", false
+		}
+		l.advance()
+	}
+	if l.eof() {
+		return "", false
+	}
+	rowType := strings.TrimSpace(l.src[start:l.pos])
+	l.advance() // consume '>'
+	l.skipSpace()
+	return rowType, rowType != ""
+}
+
+// tryMigrationBlock reads `"name" { sql }` after the migration identifier.
+// If the input does not have that shape, the lexer state is restored and ok is false.
+func (l *Lexer) tryMigrationBlock(startPos Position) (Token, bool) {
+	savedPos, savedLine, savedCol, savedComments := l.pos, l.line, l.col, len(l.comments)
+	l.skipSpace()
+	if !l.eof() && (l.peek() == '"' || l.peek() == '\'') {
+		name := l.readString(l.peek())
+		l.skipSpace()
+		if !l.eof() && l.peek() == '{' {
+			l.advance() // consume '{'
+			content := l.readRawSQLBlock()
+			return Token{Kind: TokenMigrationBlock, Text: name + "\x00" + content, Pos: startPos}, true
+		}
+	}
+	l.pos, l.line, l.col = savedPos, savedLine, savedCol
+	l.comments = l.comments[:savedComments]
+	return Token{}, false
+}
+
+// readRawSQLBlock reads SQL up to the matching '}' without parameter substitution.
+// Braces inside string literals and comments are kept as is.
+func (l *Lexer) readRawSQLBlock() string {
+	var b strings.Builder
+	emit := func() {
+		b.WriteRune(l.peek())
+		l.advance()
+	}
+	depth := 1
+	for !l.eof() && depth > 0 {
+		ch := l.peek()
+		switch ch {
+		case '{':
+			depth++
+			emit()
+		case '}':
+			depth--
+			if depth > 0 {
+				emit()
+			} else {
+				l.advance()
+			}
+		case '\'', '"', '`':
+			emit()
+			for !l.eof() {
+				c := l.peek()
+				emit()
+				if c == ch {
+					break
+				}
+			}
+		case '-':
+			emit()
+			if !l.eof() && l.peek() == '-' {
+				for !l.eof() && l.peek() != '\n' {
+					emit()
+				}
+			}
+		default:
+			emit()
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
 func (l *Lexer) match(s string) bool {
 	if strings.HasPrefix(l.src[l.pos:], s) {
 		for range s {
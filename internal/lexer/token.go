@@ -52,12 +52,13 @@ const (
 	TokenGTE
 	TokenAmp
 	TokenPipe
-	TokenQuestion   // "?"
-	TokenSwitch     // "switch" keyword
-	TokenCase       // "case" keyword
-	TokenDefault    // "default" keyword
-	TokenTable      // "create_table" keyword
-	TokenTableBlock // raw create_table definition block content
+	TokenQuestion       // "?"
+	TokenSwitch         // "switch" keyword
+	TokenCase           // "case" keyword
+	TokenDefault        // "default" keyword
+	TokenTable          // "create_table" keyword
+	TokenTableBlock     // raw create_table definition block content
+	TokenMigrationBlock // migration "name" { ... } block (name and SQL separated by \x00)
 	// SQL query keywords (sqlx-style)
 	TokenExecute            // "execute" keyword
 	TokenExecuteBlock       // raw execute block content
@@ -69,6 +70,8 @@ const (
 	TokenFetchBlock         // raw fetch block content
 	TokenFetchAll           // "fetch_all" keyword
 	TokenFetchAllBlock      // raw fetch_all block content
+	TokenFetchIter          // "fetch_iter" keyword
+	TokenFetchIterBlock     // raw fetch_iter block content
 	TokenType               // "type" keyword
 	TokenAs                 // "as" keyword
 	// JSX tokens
@@ -105,6 +108,11 @@ type Token struct {
 	Text      string
 	Pos       Position
 	SQLParams []string // Embedded parameter expressions extracted from {expr} (SQL/template)
+	// SQL ブロックのみ: Text の各バイトに対応するソース上の位置と、{expr} を置き換えた ? の Text 内オフセット
+	SQLPositions    []Position
+	SQLParamOffsets []int
+	SQLConn         string // execute(conn) { ... } の接続式（省略時は空）
+	SQLType         string // fetch_all<T> { ... } の行の型（省略時は空）
 }
 
 func (k TokenKind) String() string {
@@ -215,6 +223,8 @@ func (k TokenKind) String() string {
 		return "create_table"
 	case TokenTableBlock:
 		return "create_table_block"
+	case TokenMigrationBlock:
+		return "migration_block"
 	case TokenExecute:
 		return "execute"
 	case TokenExecuteBlock:
@@ -235,6 +245,10 @@ func (k TokenKind) String() string {
 		return "fetch_all"
 	case TokenFetchAllBlock:
 		return "fetch_all_block"
+	case TokenFetchIter:
+		return "fetch_iter"
+	case TokenFetchIterBlock:
+		return "fetch_iter_block"
 	case TokenType:
 		return "type"
 	case TokenAs:
@@ -282,5 +296,6 @@ var keywords = map[string]TokenKind{
 	"fetch_one":      TokenFetchOne,
 	"fetch":          TokenFetch,
 	"fetch_all":      TokenFetchAll,
+	"fetch_iter":     TokenFetchIter,
 	"as":             TokenAs,
 }
@@ -7,6 +7,7 @@ import (
 
 	"tuna/internal/ast"
 	"tuna/internal/lexer"
+	"tuna/internal/sqlparser"
 )
 
 type Parser struct {
@@ -68,9 +69,20 @@ func (p *Parser) parseImport() ast.ImportDecl {
 	}
 	p.expect(lexer.TokenFrom)
 	modTok := p.expect(lexer.TokenString)
+	asDir := false
+	if p.curr.Kind == lexer.TokenAs {
+		p.next()
+		if p.curr.Kind != lexer.TokenIdent || p.curr.Text != "dir" {
+			p.err(fmt.Sprintf("unsupported import kind: %s (expected dir)", p.curr.Text))
+		} else if defaultName == "" || len(items) > 0 {
+			p.err("import ... as dir requires a single default import")
+		}
+		p.expect(lexer.TokenIdent)
+		asDir = true
+	}
 	p.consumeForbiddenSemicolon()
 	end := p.curr.Pos
-	return ast.ImportDecl{DefaultName: defaultName, Items: items, From: modTok.Text, Span: spanFrom(start, end)}
+	return ast.ImportDecl{DefaultName: defaultName, Items: items, From: modTok.Text, AsDir: asDir, Span: spanFrom(start, end)}
 }
 
 func (p *Parser) parseImportItems() []ast.ImportItem {
@@ -112,6 +124,16 @@ func (p *Parser) parseDecl() ast.Decl {
 		return p.parseTypeAliasDecl(export)
 	case lexer.TokenTableBlock:
 		return p.parseTableDecl()
+	case lexer.TokenMigrationBlock:
+		return p.parseMigrationDecl()
+	case lexer.TokenIdent:
+		// create_index は文脈依存のキーワードとして扱う
+		if p.curr.Text == "create_index" {
+			return p.parseIndexDecl()
+		}
+		p.err("top-level declaration required")
+		p.sync()
+		return nil
 	default:
 		p.err("top-level declaration required")
 		p.sync()
@@ -178,11 +200,81 @@ func (p *Parser) parseTableDecl() ast.Decl {
 		tableContent = parts[1]
 	}
 
-	// Parse columns from content
-	columns := parseTableColumns(tableContent)
+	// create_table conn.name は名前付き接続のテーブル
+	connection := ""
+	if dot := strings.Index(tableName, "."); dot >= 0 {
+		connection, tableName = tableName[:dot], tableName[dot+1:]
+	}
+
+	// Parse columns and table constraints from content
+	columns, constraints, err := parseTableColumns(tableContent)
+	if err != nil {
+		p.err(fmt.Sprintf("invalid create_table %s: %v", tableName, err))
+	}
 
 	end := p.curr.Pos
-	return &ast.TableDecl{Name: tableName, Columns: columns, Span: spanFrom(start, end)}
+	return &ast.TableDecl{Name: tableName, Connection: connection, Columns: columns, Constraints: constraints, Span: spanFrom(start, end)}
+}
+
+// parseIndexDecl parses `create_index [unique] name on [conn.]table (col, ...)`.
+func (p *Parser) parseIndexDecl() ast.Decl {
+	start := p.curr.Pos
+	p.next() // consume create_index
+	decl := &ast.IndexDecl{}
+	nameTok := p.expect(lexer.TokenIdent)
+	decl.Name = nameTok.Text
+	// create_index unique on ... は unique という名前のインデックス
+	if strings.EqualFold(nameTok.Text, "unique") && p.curr.Kind == lexer.TokenIdent && p.curr.Text != "on" {
+		decl.Unique = true
+		decl.Name = p.expect(lexer.TokenIdent).Text
+	}
+	if p.curr.Kind != lexer.TokenIdent || p.curr.Text != "on" {
+		p.err("'on' expected")
+		p.sync()
+		return nil
+	}
+	p.next()
+	decl.Table = p.expect(lexer.TokenIdent).Text
+	if p.curr.Kind == lexer.TokenDot {
+		p.next()
+		decl.Connection = decl.Table
+		decl.Table = p.expect(lexer.TokenIdent).Text
+	}
+	p.expect(lexer.TokenLParen)
+	for p.curr.Kind == lexer.TokenIdent {
+		decl.Columns = append(decl.Columns, p.curr.Text)
+		p.next()
+		if p.curr.Kind != lexer.TokenComma {
+			break
+		}
+		p.next()
+	}
+	if len(decl.Columns) == 0 {
+		p.err("index column expected")
+	}
+	p.expect(lexer.TokenRParen)
+	p.consumeForbiddenSemicolon()
+	decl.Span = spanFrom(start, p.curr.Pos)
+	return decl
+}
+
+func (p *Parser) parseMigrationDecl() ast.Decl {
+	start := p.curr.Pos
+	tok := p.curr
+	p.next() // consume MigrationBlock token
+
+	parts := strings.SplitN(tok.Text, "\x00", 2)
+	name := parts[0]
+	sql := ""
+	if len(parts) > 1 {
+		sql = parts[1]
+	}
+	if name == "" {
+		p.errs = append(p.errs, fmt.Errorf("%s:%d:%d: migration name must not be empty", p.path, start.Line, start.Col))
+	}
+
+	end := p.curr.Pos
+	return &ast.MigrationDecl{Name: name, SQL: sql, Span: spanFrom(start, end)}
 }
 
 func (p *Parser) parseTypeAliasDecl(export bool) ast.Decl {
@@ -195,42 +287,58 @@ func (p *Parser) parseTypeAliasDecl(export bool) ast.Decl {
 	}
 	p.expect(lexer.TokenEq)
 	typeExpr := p.parseType()
+	var where []ast.TypeRefinement
+	// where は文脈依存のキーワードとして扱う
+	if p.curr.Kind == lexer.TokenIdent && p.curr.Text == "where" {
+		p.next()
+		where = p.parseTypeRefinements()
+	}
 	p.consumeForbiddenSemicolon()
 	end := p.curr.Pos
-	return &ast.TypeAliasDecl{Name: nameTok.Text, Export: export, TypeParams: typeParams, Type: typeExpr, Span: spanFrom(start, end)}
+	return &ast.TypeAliasDecl{Name: nameTok.Text, Export: export, TypeParams: typeParams, Type: typeExpr, Where: where, Span: spanFrom(start, end)}
 }
 
-// parseTableColumns parses column definitions from table block content
-func parseTableColumns(content string) []ast.TableColumn {
-	var columns []ast.TableColumn
-	// Split by comma, handling parentheses for constraints
-	var current strings.Builder
-	parenDepth := 0
-	for _, ch := range content {
-		if ch == '(' {
-			parenDepth++
-			current.WriteRune(ch)
-		} else if ch == ')' {
-			parenDepth--
-			current.WriteRune(ch)
-		} else if ch == ',' && parenDepth == 0 {
-			col := parseColumnDef(strings.TrimSpace(current.String()))
-			if col.Name != "" {
-				columns = append(columns, col)
-			}
-			current.Reset()
-		} else {
-			current.WriteRune(ch)
+// parseTypeRefinements parses the { name: value, ... } block after where.
+func (p *Parser) parseTypeRefinements() []ast.TypeRefinement {
+	p.expect(lexer.TokenLBrace)
+	var refinements []ast.TypeRefinement
+	for p.curr.Kind != lexer.TokenRBrace && p.curr.Kind != lexer.TokenEOF {
+		nameTok := p.expect(lexer.TokenIdent)
+		p.expect(lexer.TokenColon)
+		value := p.parseExpr(0)
+		refinements = append(refinements, ast.TypeRefinement{Name: nameTok.Text, Value: value, Span: spanFromPos(posFromLex(nameTok.Pos), value.GetSpan().End)})
+		if p.curr.Kind != lexer.TokenComma {
+			break
 		}
+		p.next()
+	}
+	p.expect(lexer.TokenRBrace)
+	return refinements
+}
+
+// parseTableColumns parses column definitions and table constraints from table block content
+func parseTableColumns(content string) ([]ast.TableColumn, []ast.TableConstraint, error) {
+	defs, err := sqlparser.SplitDefinitions(content)
+	if err != nil {
+		return nil, nil, err
 	}
-	// Last column
-	if current.Len() > 0 {
-		col := parseColumnDef(strings.TrimSpace(current.String()))
+	var columns []ast.TableColumn
+	var constraints []ast.TableConstraint
+	for _, def := range defs {
+		con, err := parseTableConstraint(def)
+		if err != nil {
+			return nil, nil, err
+		}
+		if con != nil {
+			constraints = append(constraints, *con)
+			continue
+		}
+		col := parseColumnDef(def)
 		if col.Name != "" {
 			columns = append(columns, col)
 		}
 	}
-	return columns
+	return columns, constraints, nil
 }
 
 // parseColumnDef parses a single column definition
@@ -252,6 +360,37 @@ func parseColumnDef(def string) ast.TableColumn {
 	return ast.TableColumn{Name: name, Type: typ, Constraints: constraints}
 }
 
+// parseTableConstraint parses a table constraint such as `UNIQUE (a, b)`,
+// `FOREIGN KEY (user_id) REFERENCES users (id)` or `CONSTRAINT name CHECK (...)`.
+// It returns nil for column definitions.
+func parseTableConstraint(def string) (*ast.TableConstraint, error) {
+	parsed, err := sqlparser.ParseTableConstraint(def)
+	if err != nil || parsed == nil {
+		return nil, err
+	}
+	con := &ast.TableConstraint{
+		Kind:    strings.ToLower(strings.ReplaceAll(parsed.Kind, " ", "_")),
+		Columns: sqlIdentNames(parsed.Columns),
+		SQL:     strings.Join(strings.Fields(def), " "),
+	}
+	if parsed.Name != nil {
+		con.Name = parsed.Name.Name
+	}
+	if parsed.RefTable != nil {
+		con.RefTable = parsed.RefTable.Name
+		con.RefColumns = sqlIdentNames(parsed.RefColumns)
+	}
+	return con, nil
+}
+
+func sqlIdentNames(idents []*sqlparser.Ident) []string {
+	var names []string
+	for _, ident := range idents {
+		names = append(names, ident.Name)
+	}
+	return names
+}
+
 func (p *Parser) parseStmt() ast.Stmt {
 	switch p.curr.Kind {
 	case lexer.TokenConst:
@@ -671,6 +810,11 @@ func (p *Parser) parsePrimary() ast.Expr {
 	case lexer.TokenIdent:
 		tok := p.curr
 		p.next()
+		// transaction { ... } は文脈キーワード（transaction という名前の変数はそのまま使える）
+		if tok.Text == "transaction" && p.curr.Kind == lexer.TokenLBrace {
+			body := p.parseBlock()
+			return &ast.TransactionExpr{Body: body, Span: spanFromPos(posFromLex(tok.Pos), body.Span.End)}
+		}
 		return &ast.IdentExpr{Name: tok.Text, Span: spanFrom(tok.Pos, tok.Pos)}
 	case lexer.TokenInt:
 		tok := p.curr
@@ -717,60 +861,17 @@ func (p *Parser) parsePrimary() ast.Expr {
 		}
 		return &ast.TemplateLit{Segments: segments, Exprs: exprs, Span: spanFrom(tok.Pos, tok.Pos)}
 	case lexer.TokenExecuteBlock:
-		tok := p.curr
-		p.next()
-		var params []ast.Expr
-		for _, paramStr := range tok.SQLParams {
-			paramLexer := lexer.New(paramStr)
-			paramParser := &Parser{lex: paramLexer, curr: paramLexer.Next(), path: p.path}
-			paramExpr := paramParser.parseExpr(0)
-			params = append(params, paramExpr)
-		}
-		return &ast.SQLExpr{Kind: ast.SQLQueryExecute, Query: tok.Text, Params: params, Span: spanFrom(tok.Pos, tok.Pos)}
+		return p.parseSQLBlock(ast.SQLQueryExecute)
 	case lexer.TokenFetchOptionalBlock:
-		tok := p.curr
-		p.next()
-		var params []ast.Expr
-		for _, paramStr := range tok.SQLParams {
-			paramLexer := lexer.New(paramStr)
-			paramParser := &Parser{lex: paramLexer, curr: paramLexer.Next(), path: p.path}
-			paramExpr := paramParser.parseExpr(0)
-			params = append(params, paramExpr)
-		}
-		return &ast.SQLExpr{Kind: ast.SQLQueryFetchOptional, Query: tok.Text, Params: params, Span: spanFrom(tok.Pos, tok.Pos)}
+		return p.parseSQLBlock(ast.SQLQueryFetchOptional)
 	case lexer.TokenFetchOneBlock:
-		tok := p.curr
-		p.next()
-		var params []ast.Expr
-		for _, paramStr := range tok.SQLParams {
-			paramLexer := lexer.New(paramStr)
-			paramParser := &Parser{lex: paramLexer, curr: paramLexer.Next(), path: p.path}
-			paramExpr := paramParser.parseExpr(0)
-			params = append(params, paramExpr)
-		}
-		return &ast.SQLExpr{Kind: ast.SQLQueryFetchOne, Query: tok.Text, Params: params, Span: spanFrom(tok.Pos, tok.Pos)}
+		return p.parseSQLBlock(ast.SQLQueryFetchOne)
 	case lexer.TokenFetchBlock:
-		tok := p.curr
-		p.next()
-		var params []ast.Expr
-		for _, paramStr := range tok.SQLParams {
-			paramLexer := lexer.New(paramStr)
-			paramParser := &Parser{lex: paramLexer, curr: paramLexer.Next(), path: p.path}
-			paramExpr := paramParser.parseExpr(0)
-			params = append(params, paramExpr)
-		}
-		return &ast.SQLExpr{Kind: ast.SQLQueryFetch, Query: tok.Text, Params: params, Span: spanFrom(tok.Pos, tok.Pos)}
+		return p.parseSQLBlock(ast.SQLQueryFetch)
 	case lexer.TokenFetchAllBlock:
-		tok := p.curr
-		p.next()
-		var params []ast.Expr
-		for _, paramStr := range tok.SQLParams {
-			paramLexer := lexer.New(paramStr)
-			paramParser := &Parser{lex: paramLexer, curr: paramLexer.Next(), path: p.path}
-			paramExpr := paramParser.parseExpr(0)
-			params = append(params, paramExpr)
-		}
-		return &ast.SQLExpr{Kind: ast.SQLQueryFetchAll, Query: tok.Text, Params: params, Span: spanFrom(tok.Pos, tok.Pos)}
+		return p.parseSQLBlock(ast.SQLQueryFetchAll)
+	case lexer.TokenFetchIterBlock:
+		return p.parseSQLBlock(ast.SQLQueryFetchIter)
 	case lexer.TokenLParen:
 		p.next()
 		expr := p.parseExpr(0)
@@ -1123,7 +1224,7 @@ func (p *Parser) parseTypePrimary() ast.TypeExpr {
 					lexer.TokenTrue, lexer.TokenFalse, lexer.TokenNull, lexer.TokenUndefined, lexer.TokenFunction,
 					lexer.TokenSwitch, lexer.TokenCase, lexer.TokenDefault,
 					lexer.TokenTable, lexer.TokenExecute, lexer.TokenFetchOptional, lexer.TokenFetchOne,
-					lexer.TokenFetch, lexer.TokenFetchAll, lexer.TokenAs:
+					lexer.TokenFetch, lexer.TokenFetchAll, lexer.TokenFetchIter, lexer.TokenAs:
 					// ok
 				default:
 					p.err("type key must be identifier or string literal")
@@ -1134,7 +1235,14 @@ func (p *Parser) parseTypePrimary() ast.TypeExpr {
 				keyQuoted := keyTok.Kind == lexer.TokenString
 				p.expect(lexer.TokenColon)
 				typeExpr := p.parseType()
-				props = append(props, ast.TypeProp{Key: key, KeyQuoted: keyQuoted, Type: typeExpr, Span: spanFromPos(posFromLex(keyTok.Pos), typeExpr.GetSpan().End)})
+				end := typeExpr.GetSpan().End
+				var defaultExpr ast.Expr
+				if p.curr.Kind == lexer.TokenEq {
+					p.next()
+					defaultExpr = p.parseExpr(0)
+					end = defaultExpr.GetSpan().End
+				}
+				props = append(props, ast.TypeProp{Key: key, KeyQuoted: keyQuoted, Type: typeExpr, Default: defaultExpr, Span: spanFromPos(posFromLex(keyTok.Pos), end)})
 				if p.curr.Kind != lexer.TokenComma {
 					break
 				}
@@ -1375,6 +1483,45 @@ func (p *Parser) sync() {
 	}
 }
 
+// parseSQLBlock converts an execute / fetch block token into an SQLExpr.
+func (p *Parser) parseSQLBlock(kind ast.SQLQueryKind) ast.Expr {
+	tok := p.curr
+	p.next()
+	var params []ast.Expr
+	for _, paramStr := range tok.SQLParams {
+		paramLexer := lexer.New(paramStr)
+		paramParser := &Parser{lex: paramLexer, curr: paramLexer.Next(), path: p.path}
+		paramExpr := paramParser.parseExpr(0)
+		params = append(params, paramExpr)
+	}
+	var conn ast.Expr
+	if tok.SQLConn != "" {
+		connLexer := lexer.New(tok.SQLConn)
+		connParser := &Parser{lex: connLexer, curr: connLexer.Next(), path: p.path}
+		conn = connParser.parseExpr(0)
+	}
+	var rowType ast.TypeExpr
+	if tok.SQLType != "" {
+		typeLexer := lexer.New(tok.SQLType)
+		typeParser := &Parser{lex: typeLexer, curr: typeLexer.Next(), path: p.path}
+		rowType = typeParser.parseType()
+	}
+	positions := make([]ast.Position, len(tok.SQLPositions))
+	for i, pos := range tok.SQLPositions {
+		positions[i] = posFromLex(pos)
+	}
+	return &ast.SQLExpr{
+		Kind:           kind,
+		Query:          tok.Text,
+		Params:         params,
+		QueryPositions: positions,
+		ParamOffsets:   tok.SQLParamOffsets,
+		Conn:           conn,
+		RowType:        rowType,
+		Span:           spanFrom(tok.Pos, tok.Pos),
+	}
+}
+
 func spanFrom(start lexer.Position, end lexer.Position) ast.Span {
 	return ast.Span{Start: ast.Position{Line: start.Line, Col: start.Col}, End: ast.Position{Line: end.Line, Col: end.Col}}
 }
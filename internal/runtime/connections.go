@@ -0,0 +1,231 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// namedConn は db_connect で開いた名前付きのデータベース接続。
+// デフォルト接続と違い、リクエストごとのトランザクションには参加せず自動コミットで実行する。
+type namedConn struct {
+	name    string
+	db      *sql.DB
+	stmts   *stmtCache
+	writeMu sync.Mutex
+}
+
+// connRegistry は名前付き接続を名前で引けるようにする。親と全ワーカーで1つのレジストリを共有する。
+type connRegistry struct {
+	mu    sync.Mutex
+	conns map[string]*namedConn
+}
+
+func newConnRegistry() *connRegistry {
+	return &connRegistry{conns: make(map[string]*namedConn)}
+}
+
+func (reg *connRegistry) get(name string) (*namedConn, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	c, ok := reg.conns[name]
+	return c, ok
+}
+
+// put は接続を登録し、同じ名前の以前の接続を閉じる。
+func (reg *connRegistry) put(c *namedConn) {
+	reg.mu.Lock()
+	prev := reg.conns[c.name]
+	reg.conns[c.name] = c
+	reg.mu.Unlock()
+	if prev != nil {
+		prev.db.Close()
+	}
+}
+
+// openSQLite は SQLite ファイルを開く。:memory: は接続ごとに別DBになるため1接続に固定する。
+func openSQLite(filename string, workers int) (*sql.DB, error) {
+	// 外部キー制約は接続ごとの設定なので、DSN で全接続に foreign_keys を有効にする
+	pragmas := "_pragma=foreign_keys(1)"
+	if filename != ":memory:" {
+		// ストリームの関数値は --workers 1 でもハンドラーと並行に動くので、ロック待ちは常に busy_timeout で待つ
+		pragmas += "&_pragma=busy_timeout(5000)"
+		if workers > 1 {
+			// 複数ワーカーからの読み込みと直列化された書き込みを両立させるため、全接続で WAL を有効にする
+			pragmas += "&_pragma=journal_mode(WAL)"
+		}
+	}
+	sep := "?"
+	if strings.Contains(filename, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", filename+sep+pragmas)
+	if err != nil {
+		return nil, fmt.Errorf("db open error: %w", err)
+	}
+	if filename == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+// tablesFor returns the create_table definitions routed to a connection ("" is the default connection).
+func tablesFor(tables []TableDef, connection string) []TableDef {
+	var out []TableDef
+	for _, t := range tables {
+		if t.Connection == connection {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// sqlConnect は db_connect(name, filename) の実装。inMemory が 0 以外なら filename を無視して
+// :memory: を開く（GC バックエンド）。
+func (r *Runtime) sqlConnect(nameHandle *Value, filenameHandle *Value, inMemory int32) (*Value, error) {
+	if r.isWorker {
+		return nil, errors.New("db_connect cannot be called from a request handler when --workers > 1")
+	}
+	nameVal, err := r.getValue(nameHandle)
+	if err != nil {
+		return nil, err
+	}
+	filenameVal, err := r.getValue(filenameHandle)
+	if err != nil {
+		return nil, err
+	}
+	if nameVal.Kind != KindString || filenameVal.Kind != KindString {
+		return nil, errors.New("db_connect expects (string, string)")
+	}
+	name := nameVal.Str
+	if name == "" {
+		return nil, errors.New("db_connect: connection name must not be empty")
+	}
+	filename := filenameVal.Str
+	if inMemory != 0 {
+		filename = ":memory:"
+	}
+
+	db, err := openSQLite(filename, r.workers)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("db open error: %w", err)
+	}
+	conn := &namedConn{name: name, db: db, stmts: newStmtCache()}
+	if tables := tablesFor(r.tableDefs, name); len(tables) > 0 {
+		conn.writeMu.Lock()
+		_, err := migrateSchema(db, tables, nil)
+		conn.writeMu.Unlock()
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	r.conns.put(conn)
+
+	props := map[string]*Value{
+		"name": r.newValue(Value{Kind: KindString, Str: name}),
+	}
+	return r.newValue(Value{Kind: KindObject, Obj: &Object{Order: sortedKeys(props), Props: props}}), nil
+}
+
+// resolveConn は SQL ブロックに渡された Db ハンドルを接続に解決する。
+// undefined / null はデフォルト接続を表し、nil を返す。
+func (r *Runtime) resolveConn(handle *Value) (*namedConn, error) {
+	if handle == nil {
+		return nil, nil
+	}
+	val, err := r.getValue(handle)
+	if err != nil {
+		return nil, err
+	}
+	switch val.Kind {
+	case KindUndefined, KindNull:
+		return nil, nil
+	case KindObject:
+		nameHandle, ok := val.Obj.Props["name"]
+		if !ok {
+			break
+		}
+		nameVal, err := r.getValue(nameHandle)
+		if err != nil || nameVal.Kind != KindString {
+			break
+		}
+		conn, ok := r.conns.get(nameVal.Str)
+		if !ok {
+			return nil, fmt.Errorf("database connection '%s' is not open", nameVal.Str)
+		}
+		return conn, nil
+	}
+	return nil, errors.New("invalid database connection")
+}
+
+// connQuery は名前付き接続（nil ならデフォルト接続）でクエリを実行する。
+// static なクエリは prepare 済みの文を使い回す（sql_stmt.go）。
+// fetch_iter のカーソルが接続を pin している間は、その接続で直接実行する（sql_cursor.go）。
+func (r *Runtime) connQuery(conn *namedConn, query string, static bool, args ...interface{}) (*sql.Rows, error) {
+	if !static || r.bypassStmtCache(conn) {
+		if conn == nil {
+			return r.dbQuery(query, args...)
+		}
+		if exec, ok := r.pinnedExecutor(conn); ok {
+			return exec.Query(query, args...)
+		}
+		return conn.db.Query(query, args...)
+	}
+	stmt, err := r.connStmt(conn, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Query(args...)
+}
+
+// connExec は名前付き接続（nil ならデフォルト接続）で書き込みを実行する。
+func (r *Runtime) connExec(conn *namedConn, query string, static bool, args ...interface{}) (sql.Result, error) {
+	if !static || r.bypassStmtCache(conn) {
+		if conn == nil {
+			return r.dbExec(query, args...)
+		}
+		conn.writeMu.Lock()
+		defer conn.writeMu.Unlock()
+		if exec, ok := r.pinnedExecutor(conn); ok {
+			return exec.Exec(query, args...)
+		}
+		return conn.db.Exec(query, args...)
+	}
+	stmt, err := r.connStmt(conn, query)
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		defer r.lockWrite()()
+	} else {
+		conn.writeMu.Lock()
+		defer conn.writeMu.Unlock()
+	}
+	return stmt.Exec(args...)
+}
+
+// bypassStmtCache は prepare 済みの文を使えないとき（接続が pin されていて、
+// トランザクション外のとき）に true を返す。文は pin した接続とは別の接続に束縛されうる。
+func (r *Runtime) bypassStmtCache(conn *namedConn) bool {
+	if _, ok := r.pins[conn]; !ok {
+		return false
+	}
+	return conn != nil || r.currentTx == nil
+}
+
+func (r *Runtime) connStmt(conn *namedConn, query string) (*sql.Stmt, error) {
+	if conn == nil {
+		return r.defaultStmt(query)
+	}
+	return conn.stmts.prepare(conn.db, query)
+}
@@ -0,0 +1,146 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDBConnectRoutesTablesAndQueries(t *testing.T) {
+	dir := t.TempDir()
+	mainPath := filepath.Join(dir, "main.db")
+	archivePath := filepath.Join(dir, "archive.db")
+	refPath := filepath.Join(dir, "ref.db")
+
+	ref, err := sql.Open("sqlite", refPath)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	if _, err := ref.Exec("CREATE TABLE countries (code TEXT NOT NULL, name TEXT NOT NULL); INSERT INTO countries VALUES ('jp', 'Japan')"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	ref.Close()
+
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { log } from "prelude"
+import { db_open, db_connect, type Db } from "sqlite"
+
+create_table todos {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title TEXT NOT NULL
+}
+
+create_table archive.todos_archive {
+  id INTEGER PRIMARY KEY,
+  title TEXT NOT NULL
+}
+
+create_table ref.countries {
+  code TEXT NOT NULL,
+  name TEXT NOT NULL
+}
+
+function archive_all(archive: Db): void | error {
+  const rows = fetch_all {
+    SELECT id, title FROM todos
+  }?
+  for (const row of rows) {
+    execute(archive) {
+      INSERT INTO todos_archive (id, title) VALUES ({row.id}, {row.title})
+    }?
+  }
+  execute {
+    DELETE FROM todos
+  }?
+}
+
+export function main(): void | error {
+  db_open("` + filepath.ToSlash(mainPath) + `")?
+  const archive = db_connect("archive", "` + filepath.ToSlash(archivePath) + `")?
+  const refdb = db_connect("ref", "file:` + filepath.ToSlash(refPath) + `?mode=ro")?
+  execute {
+    INSERT INTO todos (title) VALUES ('write docs')
+  }?
+  archive_all(archive)?
+  const archived = fetch_one(archive) {
+    SELECT title FROM todos_archive
+  }?
+  log("archived:" + archived.title)
+  const country = fetch_one(refdb) {
+    SELECT name FROM countries WHERE code = 'jp'
+  }?
+  log("ref:" + country.name)
+  const denied = execute(refdb) {
+    INSERT INTO countries VALUES ('fr', 'France')
+  }
+  switch (denied) {
+    case e as error: log("readonly")
+    case ok as undefined: log("written")
+  }
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	res := compileHostProgram(t, entry)
+	out, err := NewRunner().Run(res.Wasm)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := strings.TrimSpace(out); got != "archived:write docs\nref:Japan\nreadonly" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	mainDB, err := sql.Open("sqlite", mainPath)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer mainDB.Close()
+	if exists, _ := tableExists(mainDB, "todos_archive"); exists {
+		t.Fatalf("todos_archive must not be created in the default database")
+	}
+	archiveDB, err := sql.Open("sqlite", archivePath)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer archiveDB.Close()
+	if exists, _ := tableExists(archiveDB, "todos"); exists {
+		t.Fatalf("todos must not be created in the archive database")
+	}
+	var count int
+	if err := archiveDB.QueryRow("SELECT COUNT(*) FROM todos_archive").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("expected 1 archived row, got %d (%v)", count, err)
+	}
+}
+
+// ストリームは --workers 1 でもハンドラーと並行に動くので、ファイルDBは常に busy_timeout で待つ。
+func TestOpenSQLiteSetsBusyTimeout(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		db, err := openSQLite(filepath.Join(t.TempDir(), "app.db"), workers)
+		if err != nil {
+			t.Fatalf("open failed: %v", err)
+		}
+		var timeout int
+		var mode string
+		if err := db.QueryRow("PRAGMA busy_timeout").Scan(&timeout); err != nil {
+			t.Fatalf("busy_timeout query failed: %v", err)
+		}
+		if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+			t.Fatalf("journal_mode query failed: %v", err)
+		}
+		db.Close()
+		wantMode := "delete"
+		if workers > 1 {
+			wantMode = "wal"
+		}
+		if timeout != 5000 || mode != wantMode {
+			t.Fatalf("workers=%d: busy_timeout=%d journal_mode=%s", workers, timeout, mode)
+		}
+	}
+}
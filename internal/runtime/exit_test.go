@@ -0,0 +1,127 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tuna/internal/compiler"
+)
+
+func runWithStderr(t *testing.T, src string, target compiler.Target, args []string) (string, string, error) {
+	t.Helper()
+	wasm := compileForTarget(t, src, target)
+	var stderr bytes.Buffer
+	runner := NewRunner()
+	runner.SetStderr(&stderr)
+	out, err := runner.RunWithArgs(wasm, args)
+	return out, stderr.String(), err
+}
+
+func compileForTarget(t *testing.T, src string, target compiler.Target) []byte {
+	t.Helper()
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	comp := compiler.New()
+	if err := comp.SetTarget(target); err != nil {
+		t.Fatal(err)
+	}
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	return res.Wasm
+}
+
+func TestExitAndStderr(t *testing.T) {
+	src := `
+import { log, log_error, eprint } from "prelude"
+import { exit, get_args } from "server"
+import { length } from "array"
+
+export function main(): void {
+  log("out")
+  eprint("warn: ")
+  log_error(42)
+  if (length(get_args()) > 0) {
+    exit(3)
+  }
+  log("not reached")
+}
+`
+	for _, target := range []compiler.Target{compiler.TargetTuna, compiler.TargetWASI} {
+		out, stderr, err := runWithStderr(t, src, target, []string{"x"})
+		var exitErr *ExitError
+		if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+			t.Fatalf("%s: expected exit status 3, got %v", target, err)
+		}
+		if out != "out\n" {
+			t.Fatalf("%s: unexpected stdout: %q", target, out)
+		}
+		if stderr != "warn: 42\n" {
+			t.Fatalf("%s: unexpected stderr: %q", target, stderr)
+		}
+
+		out, _, err = runWithStderr(t, src, target, nil)
+		if err != nil {
+			t.Fatalf("%s: run failed: %v", target, err)
+		}
+		if out != "out\nnot reached\n" {
+			t.Fatalf("%s: unexpected stdout: %q", target, out)
+		}
+	}
+}
+
+func TestExitZeroIsSuccess(t *testing.T) {
+	src := `
+import { log } from "prelude"
+import { exit } from "server"
+
+export function main(): void {
+  log("bye")
+  exit(0)
+  log("not reached")
+}
+`
+	out, _, err := runWithStderr(t, src, compiler.TargetTuna, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if out != "bye\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestMainErrorWritesStacktraceToStderr(t *testing.T) {
+	src := `
+function fail(): void | error {
+  return error("boom")
+}
+
+export function main(): void | error {
+  return fail()
+}
+`
+	for _, target := range []compiler.Target{compiler.TargetTuna, compiler.TargetWASI} {
+		_, stderr, err := runWithStderr(t, src, target, nil)
+		var exitErr *ExitError
+		if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+			t.Fatalf("%s: expected exit status 1, got %v", target, err)
+		}
+		lines := strings.Split(strings.TrimSuffix(stderr, "\n"), "\n")
+		if len(lines) != 3 || lines[0] != "error: boom" ||
+			!strings.HasPrefix(lines[1], "    at ") || !strings.HasSuffix(lines[1], ":fail:3:10") ||
+			!strings.HasSuffix(lines[2], ":main:7:10") {
+			t.Fatalf("%s: unexpected stderr: %q", target, stderr)
+		}
+	}
+}
@@ -47,3 +47,80 @@ export function main(): void {
 		t.Fatalf("unexpected html output: %q", rt.htmlOutput.String())
 	}
 }
+
+func TestHTTPListenWithWritesHTMLToFD3(t *testing.T) {
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { create_server, add_route, listen_with, response_html, type Request, type Response } from "http"
+
+function handle_root(req: Request): Response {
+  return response_html(<div>Hello listen_with</div>)
+}
+
+export function main(): void {
+  const server = create_server()
+  add_route(server, "/", handle_root)
+  listen_with(server, { addr: ":8080", read_timeout_ms: 0, write_timeout_ms: 0, max_body_bytes: 0, shutdown_grace_ms: 0 })
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	comp := compiler.New()
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	runner := NewRunner()
+	rt, err := runner.runWithArgs(res.Wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if !strings.Contains(rt.htmlOutput.String(), "Hello listen_with") {
+		t.Fatalf("unexpected html output: %q", rt.htmlOutput.String())
+	}
+}
+
+func TestHTTPSSEWritesBufferedFramesToFD3(t *testing.T) {
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { create_server, add_route, listen, sse, send_event, type Request, type Response, type Writer } from "http"
+
+function events(w: Writer): undefined | error {
+  send_event(w, "greet", "hello\nworld")?
+  return undefined
+}
+
+function handle_root(req: Request): Response {
+  return sse(req, events)
+}
+
+export function main(): void {
+  const server = create_server()
+  add_route(server, "/", handle_root)
+  listen(server, ":8080")
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	comp := compiler.New()
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	runner := NewRunner()
+	rt, err := runner.runWithArgs(res.Wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got, want := rt.htmlOutput.String(), "event: greet\ndata: hello\ndata: world\n\n"; got != want {
+		t.Fatalf("unexpected html output: %q, want %q", got, want)
+	}
+}
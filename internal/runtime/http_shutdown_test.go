@@ -0,0 +1,275 @@
+//go:build cgo && unix
+// +build cgo,unix
+
+package runtime
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"tuna/internal/compiler"
+)
+
+func TestListenWithShutsDownGracefullyOnSIGTERM(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	src := fmt.Sprintf(`
+import { create_server, add_route, listen_with, response_text, type Request, type Response } from "http"
+
+function handle_root(req: Request): Response {
+  return response_text("alive")
+}
+
+export function main(): void {
+  const server = create_server()
+  add_route(server, "/", handle_root)
+  listen_with(server, {
+    addr: %q,
+    read_timeout_ms: 1000,
+    write_timeout_ms: 1000,
+    max_body_bytes: 16,
+    shutdown_grace_ms: 1000,
+  })
+}
+`, addr)
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	comp := compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		t.Fatalf("set backend failed: %v", err)
+	}
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	type result struct {
+		rt  *Runtime
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rt, err := NewRunner().runWithArgs(res.Wasm, nil)
+		done <- result{rt: rt, err: err}
+	}()
+
+	var body string
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get("http://" + addr + "/")
+		if err == nil {
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			body = string(data)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if body != "alive" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+
+	form := url.Values{"text": {strings.Repeat("x", 64)}}
+	resp, err := http.PostForm("http://"+addr+"/", form)
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for oversized body, got %d", resp.StatusCode)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("failed to send SIGTERM: %v", err)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("expected clean shutdown, got %v", r.err)
+		}
+		if r.rt.db != nil {
+			t.Fatal("expected database to be closed after shutdown")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+// notifyReader は最初の Read が呼ばれたことを started で知らせる。
+type notifyReader struct {
+	r       io.Reader
+	once    sync.Once
+	started chan struct{}
+}
+
+func (n *notifyReader) Read(p []byte) (int, error) {
+	n.once.Do(func() { close(n.started) })
+	return n.r.Read(p)
+}
+
+// 猶予を過ぎても終わらないハンドラーがあっても、DB はハンドラーが終わってから閉じる。
+func TestShutdownWaitsForInFlightHandlerBeforeClosingDB(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "app.db")
+	entry := filepath.Join(dir, "main.tuna")
+	src := fmt.Sprintf(`
+import { create_server, add_route, listen_with, response_text, type Request, type Response } from "http"
+import { db_open } from "sqlite"
+import { read_stdin } from "io"
+
+create_table hits {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  note TEXT NOT NULL
+}
+
+function record(): string | error {
+  const note = read_stdin()?
+  execute {
+    INSERT INTO hits (note) VALUES ({note})
+  }?
+  return note
+}
+
+function handle_slow(req: Request): Response {
+  const text = switch (record()) {
+    case err as error: "error: " + err.message
+    case note as string: note
+  }
+  return response_text(text)
+}
+
+export function main(): void | error {
+  db_open(%q)?
+  const server = create_server()
+  add_route(server, "/slow", handle_slow)
+  listen_with(server, {
+    addr: %q,
+    read_timeout_ms: 0,
+    write_timeout_ms: 0,
+    max_body_bytes: 0,
+    shutdown_grace_ms: 50,
+  })
+}
+`, filepath.ToSlash(dbPath), addr)
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	comp := compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		t.Fatalf("set backend failed: %v", err)
+	}
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	stdinR, stdinW := io.Pipe()
+	defer stdinW.Close()
+	stdin := &notifyReader{r: stdinR, started: make(chan struct{})}
+	runner := NewRunner()
+	runner.SetStdin(stdin)
+	type result struct {
+		rt  *Runtime
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rt, err := runner.runWithArgs(res.Wasm, nil)
+		done <- result{rt: rt, err: err}
+	}()
+
+	bodies := make(chan string, 1)
+	go func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			resp, err := http.Get("http://" + addr + "/slow")
+			if err == nil {
+				data, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				bodies <- string(data)
+				return
+			}
+			if time.Now().After(deadline) {
+				bodies <- "request failed: " + err.Error()
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-stdin.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not start")
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("failed to send SIGTERM: %v", err)
+	}
+	// 猶予（50ms）が切れるまで待ってからハンドラーを進める
+	time.Sleep(300 * time.Millisecond)
+	if _, err := io.WriteString(stdinW, "late"); err != nil {
+		t.Fatalf("failed to write stdin: %v", err)
+	}
+	stdinW.Close()
+
+	select {
+	case body := <-bodies:
+		if body != "late" {
+			t.Fatalf("in-flight handler lost the database: %q", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight handler did not finish")
+	}
+	select {
+	case r := <-done:
+		if r.err == nil || !strings.Contains(r.err.Error(), "http server shutdown") {
+			t.Fatalf("expected the grace timeout to be reported, got %v", r.err)
+		}
+		if r.rt.db != nil {
+			t.Fatal("expected database to be closed after shutdown")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer db.Close()
+	var note string
+	if err := db.QueryRow("SELECT note FROM hits").Scan(&note); err != nil || note != "late" {
+		t.Fatalf("expected the handler's insert to be committed, got %q (%v)", note, err)
+	}
+}
@@ -0,0 +1,934 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"tuna/internal/sqlparser"
+)
+
+// migrationsTable は適用済みマイグレーションを記録するテーブル。
+const migrationsTable = "_tuna_migrations"
+
+// Migration is a versioned schema change declared with migration "name" { ... }
+// or placed in migrations/<name>.sql.
+type Migration struct {
+	Name string `json:"name"`
+	SQL  string `json:"sql"`
+}
+
+// MigrationStatus reports whether a migration has been applied to a database.
+type MigrationStatus struct {
+	Name      string
+	Applied   bool
+	AppliedAt string
+}
+
+type schemaDefs struct {
+	Tables     []TableDef  `json:"tables"`
+	Migrations []Migration `json:"migrations"`
+}
+
+// parseSchemaDefs parses the JSON emitted by the compiler. Older modules pass
+// only the array of table definitions.
+func parseSchemaDefs(data []byte) (schemaDefs, error) {
+	var schema schemaDefs
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &schema.Tables); err != nil {
+			return schemaDefs{}, fmt.Errorf("failed to parse table definitions: %w", err)
+		}
+		return schema, nil
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return schemaDefs{}, fmt.Errorf("failed to parse table definitions: %w", err)
+	}
+	sort.SliceStable(schema.Migrations, func(i, j int) bool {
+		return schema.Migrations[i].Name < schema.Migrations[j].Name
+	})
+	return schema, nil
+}
+
+// migrateSchema applies pending migrations in name order and then creates
+// missing tables and validates existing ones, all inside one transaction.
+// On a database that has none of the declared tables yet, create_table already
+// describes the latest schema, so the tables are created first and only the
+// statements of each migration that create_table does not cover (data changes,
+// triggers, views, ...) are executed; see freshMigrationStatements.
+// It returns the names of the migrations that executed at least one statement.
+//
+// Rebuilding a table (CREATE - INSERT - DROP - RENAME) must not cascade deletes
+// or rewrite the REFERENCES of other tables, so, following the SQLite procedure,
+// foreign keys are disabled on a dedicated connection while migrating and
+// checked with PRAGMA foreign_key_check before the commit.
+func migrateSchema(db *sql.DB, tables []TableDef, migrations []Migration) ([]string, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin migration: %w", err)
+	}
+	defer conn.Close()
+	// PRAGMA foreign_keys はトランザクション中には変更できない
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, fmt.Errorf("failed to begin migration: %w", err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin migration: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if len(migrations) > 0 {
+		if err := ensureMigrationsTable(tx); err != nil {
+			return nil, err
+		}
+	}
+	applied, err := appliedMigrations(tx)
+	if err != nil {
+		return nil, err
+	}
+	fresh := len(tables) > 0
+	for _, tableDef := range tables {
+		exists, err := tableExists(tx, tableDef.Name)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			fresh = false
+			break
+		}
+	}
+
+	syncTables := func() error {
+		for _, tableDef := range tables {
+			exists, err := tableExists(tx, tableDef.Name)
+			if err != nil {
+				return err
+			}
+			if exists {
+				if err := validateTableStructure(tx, tableDef); err != nil {
+					return err
+				}
+			} else if err := createTable(tx, tableDef); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if fresh {
+		if err := syncTables(); err != nil {
+			return nil, err
+		}
+	}
+
+	var ran []string
+	for _, m := range migrations {
+		if _, ok := applied[m.Name]; ok {
+			continue
+		}
+		stmts := []string{m.SQL}
+		if fresh {
+			stmts, err = freshMigrationStatements(m.SQL, tables)
+			if err != nil {
+				return nil, fmt.Errorf("migration '%s' failed: %w", m.Name, err)
+			}
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				return nil, fmt.Errorf("migration '%s' failed: %w", m.Name, err)
+			}
+		}
+		if len(stmts) > 0 {
+			ran = append(ran, m.Name)
+		}
+		if _, err := tx.Exec("INSERT INTO "+migrationsTable+" (name) VALUES (?)", m.Name); err != nil {
+			return nil, fmt.Errorf("failed to record migration '%s': %w", m.Name, err)
+		}
+	}
+
+	if !fresh {
+		if err := syncTables(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkForeignKeys(tx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit migration: %w", err)
+	}
+	committed = true
+	return ran, nil
+}
+
+// freshMigrationStatements returns the statements of a migration that still have to
+// run on a fresh database whose tables were just created from create_table.
+// Skipped are CREATE / ALTER / DROP TABLE of create_table tables, CREATE / DROP INDEX
+// of indexes declared with create_index, and the temporary table of a rebuild (a table
+// the migration renames to a create_table table) together with the data copied into it.
+// Tables that only migrations create are kept, so a fresh database ends up with the
+// same tables and rows as an upgraded one.
+func freshMigrationStatements(src string, tables []TableDef) ([]string, error) {
+	stmts, err := sqlparser.SplitStatements(src)
+	if err != nil {
+		return nil, err
+	}
+	declaredTables := map[string]bool{}
+	declaredIndexes := map[string]bool{}
+	for _, tableDef := range tables {
+		declaredTables[strings.ToLower(tableDef.Name)] = true
+		for _, idx := range tableDef.Indexes {
+			declaredIndexes[strings.ToLower(idx.Name)] = true
+		}
+	}
+	ddls := make([]ddlInfo, len(stmts))
+	rebuildTables := map[string]bool{}
+	for i, stmt := range stmts {
+		toks, err := sqlparser.Tokenize(stmt)
+		if err != nil {
+			return nil, err
+		}
+		ddls[i] = parseDDL(toks)
+		if d := ddls[i]; d.Verb == "ALTER" && d.Object == "TABLE" && declaredTables[d.RenameTo] && !declaredTables[d.Name] {
+			rebuildTables[d.Name] = true
+		}
+	}
+	var pending []string
+	for i, stmt := range stmts {
+		d := ddls[i]
+		switch d.Verb {
+		case "":
+			continue
+		case "CREATE", "ALTER", "DROP":
+			if d.Object == "TABLE" && (declaredTables[d.Name] || rebuildTables[d.Name]) {
+				continue
+			}
+			if d.Object == "INDEX" && declaredIndexes[d.Name] {
+				continue
+			}
+		case "INSERT", "REPLACE", "UPDATE", "DELETE", "WITH":
+			parsed, err := sqlparser.Parse(stmt)
+			if err != nil {
+				return nil, err
+			}
+			if rebuildTables[strings.ToLower(dmlTarget(parsed))] {
+				continue
+			}
+		}
+		pending = append(pending, stmt)
+	}
+	return pending, nil
+}
+
+// ddlInfo は文の先頭のキーワードと、CREATE / ALTER / DROP の対象。名前は小文字にしてある。
+type ddlInfo struct {
+	Verb     string // 先頭のトークン（大文字、空の文なら空）
+	Object   string // TABLE / INDEX / TRIGGER など（大文字）
+	Name     string
+	RenameTo string // ALTER TABLE ... RENAME TO の新しい名前
+}
+
+// parseDDL は `CREATE [TEMP | UNIQUE] object [IF [NOT] EXISTS] [schema.]name ...` や
+// `ALTER TABLE name RENAME TO new_name` の種類と名前を読む。
+func parseDDL(toks []sqlparser.Token) ddlInfo {
+	pos := 0
+	tok := func() sqlparser.Token {
+		if pos < len(toks) {
+			return toks[pos]
+		}
+		return toks[len(toks)-1] // EOF
+	}
+	word := func() string {
+		if tok().Kind == sqlparser.TokenIdent {
+			return strings.ToUpper(tok().Text)
+		}
+		return ""
+	}
+	// name は [schema.]name を読み、name を返す
+	name := func() string {
+		if tok().Kind != sqlparser.TokenIdent && tok().Kind != sqlparser.TokenQuotedIdent {
+			return ""
+		}
+		n := tok().Value
+		pos++
+		if tok().Kind == sqlparser.TokenOp && tok().Text == "." {
+			pos++
+			n = tok().Value
+			pos++
+		}
+		return strings.ToLower(n)
+	}
+	d := ddlInfo{Verb: strings.ToUpper(toks[0].Text)}
+	if d.Verb != "CREATE" && d.Verb != "ALTER" && d.Verb != "DROP" {
+		return d
+	}
+	pos++
+	switch word() {
+	case "TEMP", "TEMPORARY", "UNIQUE", "VIRTUAL":
+		pos++
+	}
+	d.Object = word()
+	pos++
+	if word() == "IF" {
+		pos++
+		if word() == "NOT" {
+			pos++
+		}
+		if word() == "EXISTS" {
+			pos++
+		}
+	}
+	d.Name = name()
+	if d.Verb == "ALTER" && word() == "RENAME" {
+		pos++
+		if word() == "TO" {
+			pos++
+			d.RenameTo = name()
+		}
+	}
+	return d
+}
+
+// dmlTarget は INSERT / UPDATE / DELETE 文の対象テーブル名を返す（それ以外は空）。
+func dmlTarget(stmts []sqlparser.Stmt) string {
+	if len(stmts) != 1 {
+		return ""
+	}
+	switch st := stmts[0].(type) {
+	case *sqlparser.InsertStmt:
+		return st.Table.Name
+	case *sqlparser.UpdateStmt:
+		return st.Table.Name
+	case *sqlparser.DeleteStmt:
+		return st.Table.Name
+	}
+	return ""
+}
+
+// checkForeignKeys reports the first row that violates a foreign key.
+func checkForeignKeys(exec dbExecutor) error {
+	rows, err := exec.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return rows.Err()
+	}
+	var table, parent string
+	var rowid sql.NullInt64
+	var fkid int
+	if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	return fmt.Errorf("foreign key violation after migration: row %d of table '%s' references a missing row in '%s'", rowid.Int64, table, parent)
+}
+
+func ensureMigrationsTable(exec dbExecutor) error {
+	_, err := exec.Exec("CREATE TABLE IF NOT EXISTS " + migrationsTable + " (name TEXT PRIMARY KEY, applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", migrationsTable, err)
+	}
+	return nil
+}
+
+// appliedMigrations returns applied migration names mapped to their applied_at.
+func appliedMigrations(exec dbExecutor) (map[string]string, error) {
+	applied := map[string]string{}
+	exists, err := tableExists(exec, migrationsTable)
+	if err != nil || !exists {
+		return applied, err
+	}
+	rows, err := exec.Query("SELECT name, applied_at FROM " + migrationsTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", migrationsTable, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, appliedAt string
+		if err := rows.Scan(&name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", migrationsTable, err)
+		}
+		applied[name] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// tableExists checks if a table exists in the database
+func tableExists(exec dbExecutor, tableName string) (bool, error) {
+	var count int
+	err := exec.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", tableName).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check table existence: %w", err)
+	}
+	return count > 0, nil
+}
+
+type liveColumn struct {
+	Name string
+	Type string
+	PK   int // PRIMARY KEY 内での位置（1始まり、主キーでなければ 0）
+}
+
+// tableColumns returns the columns of an existing table in declaration order.
+func tableColumns(exec dbExecutor, tableName string) ([]liveColumn, error) {
+	rows, err := exec.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteSQLIdent(tableName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get table info: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []liveColumn
+	for rows.Next() {
+		var cid int
+		var name, colType string
+		var notNull, pk int
+		var dfltValue interface{}
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return nil, fmt.Errorf("failed to scan table info: %w", err)
+		}
+		columns = append(columns, liveColumn{Name: name, Type: strings.ToUpper(colType), PK: pk})
+	}
+	return columns, rows.Err()
+}
+
+// validateTableStructure validates that an existing table matches the definition
+func validateTableStructure(exec dbExecutor, tableDef TableDef) error {
+	columns, err := tableColumns(exec, tableDef.Name)
+	if err != nil {
+		return err
+	}
+	existingColumns := make(map[string]string) // name -> type
+	for _, col := range columns {
+		existingColumns[strings.ToLower(col.Name)] = col.Type
+	}
+
+	// Check that all defined columns exist with correct types
+	const hint = "（migration を追加するか、tuna migrate diff で ALTER 文を生成してください）"
+	for _, col := range tableDef.Columns {
+		colName := strings.ToLower(col.Name)
+		existingType, exists := existingColumns[colName]
+		if !exists {
+			return fmt.Errorf("table '%s' is missing column '%s'%s", tableDef.Name, col.Name, hint)
+		}
+		expectedType := strings.ToUpper(col.Type)
+		if existingType != expectedType {
+			return fmt.Errorf("table '%s' column '%s' has type '%s' but expected '%s'%s", tableDef.Name, col.Name, existingType, expectedType, hint)
+		}
+	}
+
+	missing, err := missingConstraints(exec, tableDef, columns)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("table '%s' is missing constraint '%s'%s", tableDef.Name, missing[0], hint)
+	}
+	indexes, err := tableIndexes(exec, tableDef.Name)
+	if err != nil {
+		return err
+	}
+	for _, idx := range tableDef.Indexes {
+		live, ok := indexes[strings.ToLower(idx.Name)]
+		if !ok {
+			return fmt.Errorf("table '%s' is missing index '%s'%s", tableDef.Name, idx.Name, hint)
+		}
+		if !indexMatches(live, idx) {
+			return fmt.Errorf("index '%s' on table '%s' does not match create_index%s", idx.Name, tableDef.Name, hint)
+		}
+	}
+
+	return nil
+}
+
+type liveIndex struct {
+	Name    string
+	Unique  bool
+	Origin  string // "c"（CREATE INDEX）/ "u"（UNIQUE 制約）/ "pk"（PRIMARY KEY）
+	Columns []string
+}
+
+// tableIndexes returns the indexes of a table keyed by lower-cased name (PRAGMA index_list / index_info).
+func tableIndexes(exec dbExecutor, tableName string) (map[string]liveIndex, error) {
+	rows, err := exec.Query(fmt.Sprintf("PRAGMA index_list(%s)", quoteSQLIdent(tableName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index list: %w", err)
+	}
+	var indexes []liveIndex
+	for rows.Next() {
+		var seq, unique, partial int
+		var name, origin string
+		if err := rows.Scan(&seq, &name, &unique, &origin, &partial); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan index list: %w", err)
+		}
+		indexes = append(indexes, liveIndex{Name: name, Unique: unique != 0, Origin: origin})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]liveIndex, len(indexes))
+	for _, idx := range indexes {
+		info, err := exec.Query(fmt.Sprintf("PRAGMA index_info(%s)", quoteSQLIdent(idx.Name)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get index info: %w", err)
+		}
+		for info.Next() {
+			var seqno, cid int
+			var name sql.NullString
+			if err := info.Scan(&seqno, &cid, &name); err != nil {
+				info.Close()
+				return nil, fmt.Errorf("failed to scan index info: %w", err)
+			}
+			idx.Columns = append(idx.Columns, name.String)
+		}
+		info.Close()
+		byName[strings.ToLower(idx.Name)] = idx
+	}
+	return byName, nil
+}
+
+type liveForeignKey struct {
+	Table string
+	From  []string
+	To    []string // 参照先の列を省略したときは空文字列
+}
+
+// tableForeignKeys returns the foreign keys of a table (PRAGMA foreign_key_list).
+func tableForeignKeys(exec dbExecutor, tableName string) ([]liveForeignKey, error) {
+	rows, err := exec.Query(fmt.Sprintf("PRAGMA foreign_key_list(%s)", quoteSQLIdent(tableName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get foreign key list: %w", err)
+	}
+	defer rows.Close()
+	var keys []liveForeignKey
+	byID := map[int]int{}
+	for rows.Next() {
+		var id, seq int
+		var table, from string
+		var to sql.NullString
+		var onUpdate, onDelete, match string
+		if err := rows.Scan(&id, &seq, &table, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+			return nil, fmt.Errorf("failed to scan foreign key list: %w", err)
+		}
+		i, ok := byID[id]
+		if !ok {
+			i = len(keys)
+			byID[id] = i
+			keys = append(keys, liveForeignKey{Table: table})
+		}
+		keys[i].From = append(keys[i].From, from)
+		keys[i].To = append(keys[i].To, to.String)
+	}
+	return keys, rows.Err()
+}
+
+// declaredForeignKeys returns the FOREIGN KEY table constraints together with
+// column-level `REFERENCES table (column)` clauses.
+func declaredForeignKeys(tableDef TableDef) []ConstraintDef {
+	var keys []ConstraintDef
+	for _, col := range tableDef.Columns {
+		upper := strings.ToUpper(col.Constraints)
+		idx := strings.Index(upper, "REFERENCES ")
+		if idx < 0 {
+			continue
+		}
+		target := strings.TrimSpace(col.Constraints[idx+len("REFERENCES "):])
+		end := strings.IndexAny(target, " \t\n(")
+		if end < 0 {
+			end = len(target)
+		}
+		key := ConstraintDef{Kind: "foreign_key", Columns: []string{col.Name}, RefTable: strings.Trim(target[:end], `"`)}
+		if rest := strings.TrimSpace(target[end:]); strings.HasPrefix(rest, "(") {
+			if close := strings.Index(rest, ")"); close > 0 {
+				key.RefColumns = []string{strings.Trim(strings.TrimSpace(rest[1:close]), `"`)}
+			}
+		}
+		key.SQL = col.Name + " REFERENCES " + target
+		keys = append(keys, key)
+	}
+	for _, con := range tableDef.Constraints {
+		if con.Kind == "foreign_key" {
+			keys = append(keys, con)
+		}
+	}
+	return keys
+}
+
+// missingConstraints returns the SQL of the PRIMARY KEY / UNIQUE / FOREIGN KEY
+// constraints that the live table does not have. CHECK constraints cannot be
+// read back through PRAGMA and are not compared.
+func missingConstraints(exec dbExecutor, tableDef TableDef, columns []liveColumn) ([]string, error) {
+	var missing []string
+	var uniques []ConstraintDef
+	for _, con := range tableDef.Constraints {
+		switch con.Kind {
+		case "primary_key":
+			pk := make([]string, len(columns))
+			n := 0
+			for _, col := range columns {
+				if col.PK > 0 && col.PK <= len(pk) {
+					pk[col.PK-1] = col.Name
+					n++
+				}
+			}
+			if !sameColumns(pk[:n], con.Columns) {
+				missing = append(missing, con.SQL)
+			}
+		case "unique":
+			uniques = append(uniques, con)
+		}
+	}
+	if len(uniques) > 0 {
+		indexes, err := tableIndexes(exec, tableDef.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, con := range uniques {
+			found := false
+			for _, idx := range indexes {
+				if idx.Unique && idx.Origin == "u" && sameColumns(idx.Columns, con.Columns) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				missing = append(missing, con.SQL)
+			}
+		}
+	}
+	declared := declaredForeignKeys(tableDef)
+	if len(declared) > 0 {
+		keys, err := tableForeignKeys(exec, tableDef.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, con := range declared {
+			found := false
+			for _, key := range keys {
+				if strings.EqualFold(key.Table, con.RefTable) && sameColumns(key.From, con.Columns) &&
+					(len(con.RefColumns) == 0 || sameColumns(key.To, con.RefColumns)) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				missing = append(missing, con.SQL)
+			}
+		}
+	}
+	return missing, nil
+}
+
+func indexMatches(live liveIndex, idx IndexDef) bool {
+	return live.Unique == idx.Unique && sameColumns(live.Columns, idx.Columns)
+}
+
+// sameColumns compares column lists in order, ignoring case.
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// createTableSQL builds the CREATE TABLE statement for a definition.
+// Table constraints follow the columns as SQLite requires.
+func createTableSQL(name string, tableDef TableDef) string {
+	var b strings.Builder
+	b.WriteString("CREATE TABLE ")
+	b.WriteString(name)
+	b.WriteString(" (")
+	for i, col := range tableDef.Columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(columnDefSQL(col))
+	}
+	for _, con := range tableDef.Constraints {
+		b.WriteString(", ")
+		b.WriteString(con.SQL)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// createIndexSQL builds the CREATE INDEX statement for a create_index declaration.
+func createIndexSQL(table string, idx IndexDef) string {
+	unique := ""
+	if idx.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)", unique, idx.Name, table, strings.Join(idx.Columns, ", "))
+}
+
+func columnDefSQL(col ColumnDef) string {
+	s := col.Name + " " + col.Type
+	if col.Constraints != "" {
+		s += " " + col.Constraints
+	}
+	return s
+}
+
+// createTable creates a new table based on the definition
+func createTable(exec dbExecutor, tableDef TableDef) error {
+	if _, err := exec.Exec(createTableSQL(tableDef.Name, tableDef)); err != nil {
+		return fmt.Errorf("failed to create table '%s': %w", tableDef.Name, err)
+	}
+	for _, idx := range tableDef.Indexes {
+		if _, err := exec.Exec(createIndexSQL(tableDef.Name, idx)); err != nil {
+			return fmt.Errorf("failed to create index '%s': %w", idx.Name, err)
+		}
+	}
+	return nil
+}
+
+// canAddColumn reports whether ALTER TABLE ... ADD COLUMN accepts the column.
+// SQLite rejects PRIMARY KEY / UNIQUE columns, NOT NULL without a default and
+// non-constant defaults.
+func canAddColumn(col ColumnDef) bool {
+	c := strings.ToUpper(col.Constraints)
+	if strings.Contains(c, "PRIMARY KEY") || strings.Contains(c, "UNIQUE") {
+		return false
+	}
+	idx := strings.Index(c, "DEFAULT")
+	if strings.Contains(c, "NOT NULL") && idx < 0 {
+		return false
+	}
+	if idx >= 0 {
+		rest := strings.TrimSpace(c[idx+len("DEFAULT"):])
+		if strings.HasPrefix(rest, "(") || strings.HasPrefix(rest, "CURRENT_") {
+			return false
+		}
+	}
+	return true
+}
+
+// schemaDiff generates SQL that brings the live database in line with the
+// create_table definitions. It returns an empty string when nothing differs.
+func schemaDiff(exec dbExecutor, tables []TableDef) (string, error) {
+	var b strings.Builder
+	for _, tableDef := range tables {
+		exists, err := tableExists(exec, tableDef.Name)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			fmt.Fprintf(&b, "-- table '%s' がありません\n", tableDef.Name)
+			b.WriteString(createTableSQL(tableDef.Name, tableDef))
+			b.WriteString(";\n")
+			for _, idx := range tableDef.Indexes {
+				b.WriteString(createIndexSQL(tableDef.Name, idx))
+				b.WriteString(";\n")
+			}
+			b.WriteString("\n")
+			continue
+		}
+		live, err := tableColumns(exec, tableDef.Name)
+		if err != nil {
+			return "", err
+		}
+		liveByName := map[string]liveColumn{}
+		for _, col := range live {
+			liveByName[strings.ToLower(col.Name)] = col
+		}
+		defined := map[string]bool{}
+		var adds []ColumnDef
+		rebuild := ""
+		for _, col := range tableDef.Columns {
+			defined[strings.ToLower(col.Name)] = true
+			existing, ok := liveByName[strings.ToLower(col.Name)]
+			if !ok {
+				if canAddColumn(col) {
+					adds = append(adds, col)
+				} else if rebuild == "" {
+					rebuild = fmt.Sprintf("column '%s' は ADD COLUMN で追加できない制約を持っています", col.Name)
+				}
+				continue
+			}
+			if existing.Type != strings.ToUpper(col.Type) && rebuild == "" {
+				rebuild = fmt.Sprintf("column '%s' の型が '%s' から '%s' に変わります", col.Name, existing.Type, strings.ToUpper(col.Type))
+			}
+		}
+		var extras []string
+		for _, col := range live {
+			if !defined[strings.ToLower(col.Name)] {
+				extras = append(extras, col.Name)
+			}
+		}
+		// テーブル制約は ALTER TABLE で追加できないので作り直す
+		missing, err := missingConstraints(exec, tableDef, live)
+		if err != nil {
+			return "", err
+		}
+		if len(missing) > 0 && rebuild == "" {
+			rebuild = fmt.Sprintf("制約 '%s' がありません", missing[0])
+		}
+
+		if rebuild != "" {
+			writeRebuildSQL(&b, tableDef, live, rebuild)
+			continue
+		}
+		liveIndexes, err := tableIndexes(exec, tableDef.Name)
+		if err != nil {
+			return "", err
+		}
+		var indexSQL []string
+		for _, idx := range tableDef.Indexes {
+			existing, ok := liveIndexes[strings.ToLower(idx.Name)]
+			if ok && indexMatches(existing, idx) {
+				continue
+			}
+			if ok {
+				indexSQL = append(indexSQL, "DROP INDEX "+idx.Name+";")
+			}
+			indexSQL = append(indexSQL, createIndexSQL(tableDef.Name, idx)+";")
+		}
+		if len(adds) == 0 && len(extras) == 0 && len(indexSQL) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "-- table '%s'\n", tableDef.Name)
+		for _, col := range adds {
+			fmt.Fprintf(&b, "ALTER TABLE %s ADD COLUMN %s;\n", tableDef.Name, columnDefSQL(col))
+		}
+		for _, name := range extras {
+			fmt.Fprintf(&b, "-- create_table にない列です。データが失われるためコメントにしています:\n-- ALTER TABLE %s DROP COLUMN %s;\n", tableDef.Name, name)
+		}
+		for _, stmt := range indexSQL {
+			b.WriteString(stmt)
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// writeRebuildSQL writes the create-copy-drop-rename sequence SQLite needs for
+// changes that ALTER TABLE cannot express. The script is meant to be saved as a
+// migration, which runs with foreign keys disabled (see migrateSchema).
+func writeRebuildSQL(b *strings.Builder, tableDef TableDef, live []liveColumn, reason string) {
+	tmp := "_tuna_new_" + tableDef.Name
+	defined := map[string]bool{}
+	for _, col := range tableDef.Columns {
+		defined[strings.ToLower(col.Name)] = true
+	}
+	var common []string
+	for _, col := range live {
+		if defined[strings.ToLower(col.Name)] {
+			common = append(common, col.Name)
+		}
+	}
+	fmt.Fprintf(b, "-- table '%s' を作り直します: %s\n", tableDef.Name, reason)
+	b.WriteString(createTableSQL(tmp, tableDef))
+	b.WriteString(";\n")
+	if len(common) > 0 {
+		cols := strings.Join(common, ", ")
+		fmt.Fprintf(b, "INSERT INTO %s (%s) SELECT %s FROM %s;\n", tmp, cols, cols, tableDef.Name)
+	}
+	fmt.Fprintf(b, "DROP TABLE %s;\n", tableDef.Name)
+	fmt.Fprintf(b, "ALTER TABLE %s RENAME TO %s;\n", tmp, tableDef.Name)
+	// DROP TABLE で消えたインデックスを作り直す
+	for _, idx := range tableDef.Indexes {
+		b.WriteString(createIndexSQL(tableDef.Name, idx))
+		b.WriteString(";\n")
+	}
+	b.WriteString("\n")
+}
+
+// Migrator runs migrations against a database file outside of a program run.
+// It backs the tuna migrate command.
+type Migrator struct {
+	db     *sql.DB
+	schema schemaDefs
+}
+
+// OpenMigrator opens the database at dbPath with the schema JSON produced by the compiler.
+func OpenMigrator(dbPath string, schemaJSON string) (*Migrator, error) {
+	var schema schemaDefs
+	if strings.TrimSpace(schemaJSON) != "" {
+		parsed, err := parseSchemaDefs([]byte(schemaJSON))
+		if err != nil {
+			return nil, err
+		}
+		// migrate コマンドはデフォルト接続（db_open）のテーブルだけを扱う
+		parsed.Tables = tablesFor(parsed.Tables, "")
+		schema = parsed
+	}
+	if dbPath == "" {
+		return nil, errors.New("database file is required")
+	}
+	db, err := openSQLite(dbPath, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, schema: schema}, nil
+}
+
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+// Status lists every declared migration in the order it is applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(m.db)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(m.schema.Migrations))
+	for _, mig := range m.schema.Migrations {
+		appliedAt, ok := applied[mig.Name]
+		statuses = append(statuses, MigrationStatus{Name: mig.Name, Applied: ok, AppliedAt: appliedAt})
+	}
+	return statuses, nil
+}
+
+// Up applies pending migrations exactly as db_open does and returns the names
+// of the migrations that were executed.
+func (m *Migrator) Up() ([]string, error) {
+	return migrateSchema(m.db, m.schema.Tables, m.schema.Migrations)
+}
+
+// Diff returns SQL that turns the live database into the create_table schema.
+// Pending migrations are listed first because applying them may remove the difference.
+func (m *Migrator) Diff() (string, error) {
+	diff, err := schemaDiff(m.db, m.schema.Tables)
+	if err != nil || diff == "" {
+		return diff, err
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		return "", err
+	}
+	var pending []string
+	for _, st := range statuses {
+		if !st.Applied {
+			pending = append(pending, st.Name)
+		}
+	}
+	if len(pending) > 0 {
+		diff = fmt.Sprintf("-- 未適用のマイグレーションがあります: %s（先に tuna migrate up を実行してください）\n\n%s", strings.Join(pending, ", "), diff)
+	}
+	return diff, nil
+}
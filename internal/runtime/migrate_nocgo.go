@@ -0,0 +1,34 @@
+//go:build !cgo
+// +build !cgo
+
+package runtime
+
+import "fmt"
+
+type MigrationStatus struct {
+	Name      string
+	Applied   bool
+	AppliedAt string
+}
+
+type Migrator struct{}
+
+func OpenMigrator(dbPath string, schemaJSON string) (*Migrator, error) {
+	return nil, fmt.Errorf("CGO が無効です（wasmtime-go が必要です）")
+}
+
+func (m *Migrator) Close() error {
+	return nil
+}
+
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	return nil, fmt.Errorf("CGO が無効です（wasmtime-go が必要です）")
+}
+
+func (m *Migrator) Up() ([]string, error) {
+	return nil, fmt.Errorf("CGO が無効です（wasmtime-go が必要です）")
+}
+
+func (m *Migrator) Diff() (string, error) {
+	return "", fmt.Errorf("CGO が無効です（wasmtime-go が必要です）")
+}
@@ -0,0 +1,358 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tuna/internal/compiler"
+)
+
+func compileHostProgram(t *testing.T, entry string) *compiler.Result {
+	t.Helper()
+	comp := compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		t.Fatalf("set backend failed: %v", err)
+	}
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	return res
+}
+
+func TestMigrationsAppliedAtDBOpen(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE todos (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL); INSERT INTO todos (title) VALUES ('old')"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	db.Close()
+
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { log } from "prelude"
+import { db_open } from "sqlite"
+
+create_table todos {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title TEXT NOT NULL,
+  due_date TEXT,
+  priority INTEGER NOT NULL DEFAULT 0
+}
+
+migration "002_add_due_date" {
+  ALTER TABLE todos ADD COLUMN due_date TEXT;
+  UPDATE todos SET due_date = '2026-01-01';
+}
+
+export function main(): void | error {
+  db_open("` + filepath.ToSlash(dbPath) + `")?
+  const row = fetch_one {
+    SELECT title, due_date, priority FROM todos
+  }?
+  switch (row.due_date) {
+    case d as string: log(row.title + ":" + d)
+    case n as null: log("null")
+  }
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "migrations"), 0755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "migrations", "003_priority.sql"), []byte("ALTER TABLE todos ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;\n"), 0644); err != nil {
+		t.Fatalf("failed to write migration: %v", err)
+	}
+	res := compileHostProgram(t, entry)
+
+	migrator, err := OpenMigrator(dbPath, res.Schema)
+	if err != nil {
+		t.Fatalf("open migrator failed: %v", err)
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if len(statuses) != 2 || statuses[0].Name != "002_add_due_date" || statuses[1].Name != "003_priority" || statuses[0].Applied || statuses[1].Applied {
+		t.Fatalf("unexpected status before run: %+v", statuses)
+	}
+	migrator.Close()
+
+	out, err := NewRunner().Run(res.Wasm)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if strings.TrimSpace(out) != "old:2026-01-01" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	migrator, err = OpenMigrator(dbPath, res.Schema)
+	if err != nil {
+		t.Fatalf("open migrator failed: %v", err)
+	}
+	defer migrator.Close()
+	statuses, err = migrator.Status()
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	for _, st := range statuses {
+		if !st.Applied || st.AppliedAt == "" {
+			t.Fatalf("expected %s to be applied: %+v", st.Name, statuses)
+		}
+	}
+	applied, err := migrator.Up()
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("expected no pending migrations, got %v (%v)", applied, err)
+	}
+	diff, err := migrator.Diff()
+	if err != nil || diff != "" {
+		t.Fatalf("expected no diff, got %q (%v)", diff, err)
+	}
+}
+
+func TestMigrationsOnFreshDatabaseAreRecordedOnly(t *testing.T) {
+	schema := `{"tables":[{"name":"notes","columns":[{"name":"id","type":"INTEGER","constraints":"PRIMARY KEY"},{"name":"body","type":"TEXT"}]}],` +
+		`"migrations":[{"name":"001_add_body","sql":"ALTER TABLE notes ADD COLUMN body TEXT"}]}`
+	migrator, err := OpenMigrator(":memory:", schema)
+	if err != nil {
+		t.Fatalf("open migrator failed: %v", err)
+	}
+	defer migrator.Close()
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("migrations must not run on a fresh database, ran %v", applied)
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if len(statuses) != 1 || !statuses[0].Applied {
+		t.Fatalf("expected migration to be recorded: %+v", statuses)
+	}
+}
+
+func TestDataMigrationsRunOnFreshDatabase(t *testing.T) {
+	schema := `{"tables":[{"name":"notes","columns":[{"name":"id","type":"INTEGER","constraints":"PRIMARY KEY"},{"name":"body","type":"TEXT","constraints":"NOT NULL"},{"name":"tag","type":"TEXT"}],` +
+		`"indexes":[{"name":"notes_by_tag","columns":["tag"]}]}],"migrations":[` +
+		`{"name":"001_seed","sql":"ALTER TABLE notes ADD COLUMN body TEXT; INSERT INTO notes (id, body) VALUES (1, 'seed; with semicolon')"},` +
+		`{"name":"002_rebuild","sql":"CREATE TABLE notes_new (id INTEGER PRIMARY KEY, body TEXT NOT NULL, tag TEXT); INSERT INTO notes_new SELECT id, body, NULL FROM notes; DROP TABLE notes; ALTER TABLE notes_new RENAME TO notes; CREATE INDEX notes_by_tag ON notes (tag)"},` +
+		`{"name":"003_trigger","sql":"CREATE TRIGGER notes_tag AFTER UPDATE ON notes BEGIN UPDATE notes SET tag = CASE WHEN NEW.tag IS NULL THEN 'x' ELSE NEW.tag END WHERE id = NEW.id; END; UPDATE notes SET body = body || '!'"}]}`
+	migrator, err := OpenMigrator(":memory:", schema)
+	if err != nil {
+		t.Fatalf("open migrator failed: %v", err)
+	}
+	defer migrator.Close()
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if strings.Join(applied, ",") != "001_seed,003_trigger" {
+		t.Fatalf("unexpected migrations ran: %v", applied)
+	}
+	var body, tag string
+	if err := migrator.db.QueryRow("SELECT body, tag FROM notes WHERE id = 1").Scan(&body, &tag); err != nil {
+		t.Fatalf("seed row missing: %v", err)
+	}
+	if body != "seed; with semicolon!" || tag != "x" {
+		t.Fatalf("unexpected row: body=%q tag=%q", body, tag)
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	for _, st := range statuses {
+		if !st.Applied {
+			t.Fatalf("expected %s to be recorded: %+v", st.Name, statuses)
+		}
+	}
+}
+
+// マイグレーションだけが作るテーブルは、新しいDBでも作られてデータが入る。
+func TestMigrationOnlyTablesOnFreshDatabase(t *testing.T) {
+	schema := `{"tables":[{"name":"notes","columns":[{"name":"id","type":"INTEGER","constraints":"PRIMARY KEY"}]}],"migrations":[` +
+		`{"name":"001_settings","sql":"CREATE TABLE settings (key TEXT PRIMARY KEY, value TEXT); INSERT INTO settings (key, value) VALUES ('theme', 'dark')"},` +
+		`{"name":"002_note","sql":"ALTER TABLE main.settings ADD COLUMN note TEXT; UPDATE settings SET note = 'x'; CREATE TABLE scratch (id INTEGER); DROP TABLE IF EXISTS scratch"}]}`
+	migrator, err := OpenMigrator(":memory:", schema)
+	if err != nil {
+		t.Fatalf("open migrator failed: %v", err)
+	}
+	defer migrator.Close()
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if strings.Join(applied, ",") != "001_settings,002_note" {
+		t.Fatalf("unexpected migrations ran: %v", applied)
+	}
+	var value, note string
+	if err := migrator.db.QueryRow("SELECT value, note FROM settings WHERE key = 'theme'").Scan(&value, &note); err != nil {
+		t.Fatalf("settings row missing: %v", err)
+	}
+	if value != "dark" || note != "x" {
+		t.Fatalf("unexpected row: value=%q note=%q", value, note)
+	}
+	exists, err := tableExists(migrator.db, "scratch")
+	if err != nil {
+		t.Fatalf("table check failed: %v", err)
+	}
+	if exists {
+		t.Fatalf("scratch should have been dropped")
+	}
+}
+
+// 予約語のテーブル名でも既存テーブルの検証と差分の生成ができる。
+func TestExistingTableWithReservedName(t *testing.T) {
+	schema := `{"tables":[{"name":"order","columns":[{"name":"id","type":"INTEGER","constraints":"PRIMARY KEY"}]}],"migrations":[]}`
+	migrator, err := OpenMigrator(":memory:", schema)
+	if err != nil {
+		t.Fatalf("open migrator failed: %v", err)
+	}
+	defer migrator.Close()
+	if _, err := migrator.db.Exec(`CREATE TABLE "order" (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	diff, err := migrator.Diff()
+	if err != nil {
+		t.Fatalf("diff failed: %v", err)
+	}
+	if diff != "" {
+		t.Fatalf("expected no diff, got %q", diff)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	schema := `{"tables":[{"name":"notes","columns":[{"name":"id","type":"INTEGER"}]}],` +
+		`"migrations":[{"name":"001_ok","sql":"CREATE TABLE extra (id INTEGER)"},{"name":"002_broken","sql":"ALTER TABLE missing ADD COLUMN x TEXT"}]}`
+	migrator, err := OpenMigrator(":memory:", schema)
+	if err != nil {
+		t.Fatalf("open migrator failed: %v", err)
+	}
+	defer migrator.Close()
+	if _, err := migrator.db.Exec("CREATE TABLE notes (id INTEGER)"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	_, err = migrator.Up()
+	if err == nil || !strings.Contains(err.Error(), "migration '002_broken' failed") {
+		t.Fatalf("expected migration failure, got %v", err)
+	}
+	exists, err := tableExists(migrator.db, "extra")
+	if err != nil {
+		t.Fatalf("table check failed: %v", err)
+	}
+	if exists {
+		t.Fatalf("001_ok should have been rolled back")
+	}
+	statuses, _ := migrator.Status()
+	if len(statuses) != 2 || statuses[0].Applied {
+		t.Fatalf("expected nothing recorded: %+v", statuses)
+	}
+}
+
+func TestMigrateDiffGeneratesAlterScript(t *testing.T) {
+	schema := `{"tables":[` +
+		`{"name":"users","columns":[{"name":"id","type":"INTEGER","constraints":"PRIMARY KEY"},{"name":"email","type":"TEXT"},{"name":"age","type":"INTEGER","constraints":"DEFAULT 0"}]},` +
+		`{"name":"posts","columns":[{"name":"id","type":"INTEGER","constraints":"PRIMARY KEY"},{"name":"score","type":"REAL"}]},` +
+		`{"name":"tags","columns":[{"name":"name","type":"TEXT","constraints":"NOT NULL"}]}` +
+		`],"migrations":[]}`
+	migrator, err := OpenMigrator(":memory:", schema)
+	if err != nil {
+		t.Fatalf("open migrator failed: %v", err)
+	}
+	defer migrator.Close()
+	if _, err := migrator.db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, nickname TEXT); CREATE TABLE posts (id INTEGER PRIMARY KEY, score TEXT)"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	diff, err := migrator.Diff()
+	if err != nil {
+		t.Fatalf("diff failed: %v", err)
+	}
+	for _, want := range []string{
+		"ALTER TABLE users ADD COLUMN age INTEGER DEFAULT 0;",
+		"-- ALTER TABLE users DROP COLUMN nickname;",
+		"CREATE TABLE _tuna_new_posts (id INTEGER PRIMARY KEY, score REAL);",
+		"INSERT INTO _tuna_new_posts (id, score) SELECT id, score FROM posts;",
+		"ALTER TABLE _tuna_new_posts RENAME TO posts;",
+		"CREATE TABLE tags (name TEXT NOT NULL);",
+	} {
+		if !strings.Contains(diff, want) {
+			t.Fatalf("diff is missing %q:\n%s", want, diff)
+		}
+	}
+	if _, err := migrator.db.Exec(diff); err != nil {
+		t.Fatalf("generated script failed: %v\n%s", err, diff)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("schema should validate after the script: %v", err)
+	}
+}
+
+func TestTableConstraintsAndIndexesAreValidated(t *testing.T) {
+	schema := `{"tables":[` +
+		`{"name":"users","columns":[{"name":"id","type":"INTEGER","constraints":"PRIMARY KEY"},{"name":"email","type":"TEXT"}],` +
+		`"constraints":[{"kind":"unique","columns":["email"],"sql":"UNIQUE (email)"}]},` +
+		`{"name":"posts","columns":[{"name":"id","type":"INTEGER","constraints":"PRIMARY KEY"},{"name":"user_id","type":"INTEGER"}],` +
+		`"constraints":[{"kind":"foreign_key","columns":["user_id"],"ref_table":"users","ref_columns":["id"],"sql":"FOREIGN KEY (user_id) REFERENCES users (id)"}],` +
+		`"indexes":[{"name":"posts_by_user","columns":["user_id"]}]}` +
+		`],"migrations":[]}`
+	migrator, err := OpenMigrator(":memory:", schema)
+	if err != nil {
+		t.Fatalf("open migrator failed: %v", err)
+	}
+	defer migrator.Close()
+	if _, err := migrator.db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT); CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id)); INSERT INTO users VALUES (1, 'a'); INSERT INTO posts VALUES (1, 1)"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := migrator.Up(); err == nil || !strings.Contains(err.Error(), "table 'users' is missing constraint 'UNIQUE (email)'") {
+		t.Fatalf("expected missing UNIQUE constraint, got %v", err)
+	}
+
+	diff, err := migrator.Diff()
+	if err != nil {
+		t.Fatalf("diff failed: %v", err)
+	}
+	for _, want := range []string{
+		"CREATE TABLE _tuna_new_users (id INTEGER PRIMARY KEY, email TEXT, UNIQUE (email));",
+		"CREATE INDEX posts_by_user ON posts (user_id);",
+	} {
+		if !strings.Contains(diff, want) {
+			t.Fatalf("diff is missing %q:\n%s", want, diff)
+		}
+	}
+	// users を作り直しても posts の外部キーと行は残る
+	migrator.schema.Migrations = []Migration{{Name: "001_constraints", SQL: diff}}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("schema should validate after the script: %v\n%s", err, diff)
+	}
+	var count int
+	if err := migrator.db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("posts rows were lost: %d %v", count, err)
+	}
+	if _, err := migrator.db.Exec("INSERT INTO posts VALUES (2, 99)"); err == nil || !strings.Contains(err.Error(), "FOREIGN KEY") {
+		t.Fatalf("foreign keys must be enforced on every connection, got %v", err)
+	}
+
+	if _, err := migrator.db.Exec("DROP INDEX posts_by_user"); err != nil {
+		t.Fatalf("drop index failed: %v", err)
+	}
+	if _, err := migrator.Up(); err == nil || !strings.Contains(err.Error(), "table 'posts' is missing index 'posts_by_user'") {
+		t.Fatalf("expected missing index, got %v", err)
+	}
+}
@@ -0,0 +1,398 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sqlite モジュールのクエリビルダー（select(...).where(...)...）で組み立てた Query を実行する。
+// テーブル名・列名・演算子は create_table の定義と許可リストで検証してから SQL に埋め込み、
+// 値と LIMIT / OFFSET はすべて ? パラメーターとして渡す。
+
+const (
+	selectAll = iota
+	selectOne
+	selectOptional
+)
+
+var queryCompareOps = map[string]bool{
+	"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "LIKE": true,
+}
+
+// builtQuery は Query の値から組み立てた SQL。
+type builtQuery struct {
+	table  TableDef
+	sql    string
+	params []interface{}
+}
+
+func (r *Runtime) sqlSelect(queryHandle *Value, mode int32) (*Value, error) {
+	q, err := r.buildSelectQuery(queryHandle)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := r.queryConn(q)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := -1
+	if mode != selectAll {
+		limit = 1
+	}
+	// 識別子は許可リストで検証済みなので、組み立てた SQL も prepare してキャッシュする
+	rowHandles, err := r.querySQLRows(conn, q.sql, true, q.params, tableColumnTypes(q.table), limit)
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case selectOne:
+		if len(rowHandles) == 0 {
+			return nil, errors.New("query_one: no row found")
+		}
+		return rowHandles[0], nil
+	case selectOptional:
+		if len(rowHandles) == 0 {
+			return nil, nil
+		}
+		return rowHandles[0], nil
+	}
+	return r.newValue(Value{Kind: KindArray, Arr: &Array{Elems: rowHandles}}), nil
+}
+
+// queryPage は query_page のキーセットページング。key 列で並べ、after より後の行を size+1 行まで読む
+// （1行多く読んで次のページがあるかを判定する）。
+type queryPage struct {
+	key      string
+	after    interface{}
+	hasAfter bool
+	size     int64
+}
+
+// queryConn はテーブルが create_table name.table で定義されていればその接続を返す（nil ならデフォルト接続）。
+func (r *Runtime) queryConn(q *builtQuery) (*namedConn, error) {
+	if q.table.Connection != "" {
+		conn, ok := r.conns.get(q.table.Connection)
+		if !ok {
+			return nil, fmt.Errorf("database connection '%s' is not open", q.table.Connection)
+		}
+		return conn, nil
+	}
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+	return nil, nil
+}
+
+func (r *Runtime) buildSelectQuery(queryHandle *Value) (*builtQuery, error) {
+	return r.buildQuery(queryHandle, nil)
+}
+
+func (r *Runtime) buildQuery(queryHandle *Value, page *queryPage) (*builtQuery, error) {
+	props, err := r.objectProps(queryHandle)
+	if err != nil {
+		return nil, err
+	}
+	tableName, err := r.propString(props, "table")
+	if err != nil {
+		return nil, err
+	}
+	table, ok := r.lookupTableDef(tableName)
+	if !ok {
+		return nil, fmt.Errorf("query: table '%s' is not defined", tableName)
+	}
+	columnOf := func(name string) (string, error) {
+		for _, col := range table.Columns {
+			if strings.EqualFold(col.Name, name) {
+				return col.Name, nil
+			}
+		}
+		return "", fmt.Errorf("query: column '%s' does not exist in table '%s'", name, table.Name)
+	}
+
+	q := &builtQuery{table: table}
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	for i, col := range table.Columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(quoteSQLIdent(col.Name))
+	}
+	b.WriteString(" FROM ")
+	b.WriteString(quoteSQLIdent(table.Name))
+
+	conditions, err := r.propElems(props, "conditions")
+	if err != nil {
+		return nil, err
+	}
+	for i, condHandle := range conditions {
+		cond, err := r.objectProps(condHandle)
+		if err != nil {
+			return nil, err
+		}
+		name, err := r.propString(cond, "column")
+		if err != nil {
+			return nil, err
+		}
+		column, err := columnOf(name)
+		if err != nil {
+			return nil, err
+		}
+		op, err := r.propString(cond, "op")
+		if err != nil {
+			return nil, err
+		}
+		if !queryCompareOps[op] {
+			return nil, fmt.Errorf("query: unsupported operator '%s'", op)
+		}
+		valueHandle, ok := cond["value"]
+		if !ok {
+			return nil, errors.New("query: condition has no value")
+		}
+		value, err := r.sqlParam(valueHandle)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			b.WriteString(" WHERE ")
+		} else {
+			b.WriteString(" AND ")
+		}
+		fmt.Fprintf(&b, "%s %s ?", quoteSQLIdent(column), op)
+		q.params = append(q.params, value)
+	}
+
+	orders, err := r.propElems(props, "orders")
+	if err != nil {
+		return nil, err
+	}
+	if page != nil {
+		return r.finishPageQuery(q, &b, props, columnOf, len(conditions) > 0, orders, page)
+	}
+	for i, orderHandle := range orders {
+		order, err := r.objectProps(orderHandle)
+		if err != nil {
+			return nil, err
+		}
+		name, err := r.propString(order, "column")
+		if err != nil {
+			return nil, err
+		}
+		column, err := columnOf(name)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := r.propString(order, "order")
+		if err != nil {
+			return nil, err
+		}
+		if dir != "asc" && dir != "desc" {
+			return nil, fmt.Errorf("query: unsupported sort order '%s'", dir)
+		}
+		if i == 0 {
+			b.WriteString(" ORDER BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s %s", quoteSQLIdent(column), strings.ToUpper(dir))
+	}
+
+	limit, err := r.propI64(props, "limit")
+	if err != nil {
+		return nil, err
+	}
+	offset, err := r.propI64(props, "offset")
+	if err != nil {
+		return nil, err
+	}
+	if limit >= 0 || offset > 0 {
+		// SQLite の LIMIT -1 は上限なし
+		b.WriteString(" LIMIT ? OFFSET ?")
+		q.params = append(q.params, limit, offset)
+	}
+	q.sql = b.String()
+	return q, nil
+}
+
+// finishPageQuery は query_page の条件・ORDER BY・LIMIT を追加する。
+// order_by は key 列に対するもの（降順にするため）だけを許し、limit / offset とは併用できない。
+func (r *Runtime) finishPageQuery(q *builtQuery, b *strings.Builder, props map[string]*Value, columnOf func(string) (string, error), hasWhere bool, orders []*Value, page *queryPage) (*builtQuery, error) {
+	key, err := columnOf(page.key)
+	if err != nil {
+		return nil, err
+	}
+	page.key = key
+	if page.size <= 0 {
+		return nil, errors.New("query_page: size must be positive")
+	}
+	dir := "asc"
+	for _, orderHandle := range orders {
+		order, err := r.objectProps(orderHandle)
+		if err != nil {
+			return nil, err
+		}
+		name, err := r.propString(order, "column")
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(name, key) {
+			return nil, fmt.Errorf("query_page: order_by must use the key column '%s'", key)
+		}
+		if dir, err = r.propString(order, "order"); err != nil {
+			return nil, err
+		}
+		if dir != "asc" && dir != "desc" {
+			return nil, fmt.Errorf("query: unsupported sort order '%s'", dir)
+		}
+	}
+	limit, err := r.propI64(props, "limit")
+	if err != nil {
+		return nil, err
+	}
+	offset, err := r.propI64(props, "offset")
+	if err != nil {
+		return nil, err
+	}
+	if limit >= 0 || offset > 0 {
+		return nil, errors.New("query_page: limit / offset cannot be combined with query_page")
+	}
+
+	if page.hasAfter {
+		if hasWhere {
+			b.WriteString(" AND ")
+		} else {
+			b.WriteString(" WHERE ")
+		}
+		op := ">"
+		if dir == "desc" {
+			op = "<"
+		}
+		fmt.Fprintf(b, "%s %s ?", quoteSQLIdent(key), op)
+		q.params = append(q.params, page.after)
+	}
+	fmt.Fprintf(b, " ORDER BY %s %s LIMIT ?", quoteSQLIdent(key), strings.ToUpper(dir))
+	q.params = append(q.params, page.size+1)
+	q.sql = b.String()
+	return q, nil
+}
+
+// sqlSelectPage は query_page を実行し、{ rows, next_cursor } を返す。
+// next_cursor は次のページがあるときに返した最後の行の key 列の値で、無ければ null。
+func (r *Runtime) sqlSelectPage(queryHandle *Value, keyHandle *Value, afterHandle *Value, size int64) (*Value, error) {
+	keyVal, err := r.getValue(keyHandle)
+	if err != nil || keyVal.Kind != KindString {
+		return nil, errors.New("query_page: key must be a string")
+	}
+	page := &queryPage{key: keyVal.Str, size: size}
+	afterVal, err := r.getValue(afterHandle)
+	if err != nil {
+		return nil, err
+	}
+	if afterVal.Kind != KindNull && afterVal.Kind != KindUndefined {
+		if page.after, err = r.sqlParam(afterHandle); err != nil {
+			return nil, err
+		}
+		page.hasAfter = true
+	}
+	q, err := r.buildQuery(queryHandle, page)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := r.queryConn(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rowHandles, err := r.querySQLRows(conn, q.sql, true, q.params, tableColumnTypes(q.table), -1)
+	if err != nil {
+		return nil, err
+	}
+	next := nullValue
+	if int64(len(rowHandles)) > size {
+		rowHandles = rowHandles[:size]
+		last, err := r.objectProps(rowHandles[size-1])
+		if err != nil {
+			return nil, err
+		}
+		next = last[page.key]
+	}
+	props := map[string]*Value{
+		"rows":        r.newValue(Value{Kind: KindArray, Arr: &Array{Elems: rowHandles}}),
+		"next_cursor": next,
+	}
+	return r.newValue(Value{Kind: KindObject, Obj: &Object{Order: sortedKeys(props), Props: props}}), nil
+}
+
+// lookupTableDef は create_table で定義したテーブルを大文字小文字を区別せずに探す。
+func (r *Runtime) lookupTableDef(name string) (TableDef, bool) {
+	for _, t := range r.tableDefs {
+		if strings.EqualFold(t.Name, name) {
+			return t, true
+		}
+	}
+	return TableDef{}, false
+}
+
+// tableColumnTypes はテーブル定義の列の型（types.sqliteAffinityType と同じ規則）を返す。
+func tableColumnTypes(table TableDef) map[string]sqlColumnType {
+	types := make(map[string]sqlColumnType, len(table.Columns))
+	for _, col := range table.Columns {
+		upper := strings.ToUpper(col.Type)
+		kind := KindString
+		switch {
+		case strings.Contains(upper, "INT"):
+			kind = KindI64
+		case strings.Contains(upper, "CHAR"), strings.Contains(upper, "CLOB"), strings.Contains(upper, "TEXT"), strings.Contains(upper, "BLOB"):
+		case strings.Contains(upper, "REAL"), strings.Contains(upper, "FLOA"), strings.Contains(upper, "DOUB"):
+			kind = KindF64
+		}
+		constraints := strings.ToUpper(col.Constraints)
+		nullable := !strings.Contains(constraints, "NOT NULL") && !strings.Contains(constraints, "PRIMARY KEY")
+		types[strings.ToLower(col.Name)] = sqlColumnType{kind: kind, nullable: nullable, field: col.Name}
+	}
+	return types
+}
+
+func quoteSQLIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (r *Runtime) objectProps(handle *Value) (map[string]*Value, error) {
+	val, err := r.getValue(handle)
+	if err != nil {
+		return nil, err
+	}
+	if val.Kind != KindObject {
+		return nil, errors.New("query: object expected")
+	}
+	return val.Obj.Props, nil
+}
+
+func (r *Runtime) propString(props map[string]*Value, name string) (string, error) {
+	val, err := r.getValue(props[name])
+	if err != nil || val.Kind != KindString {
+		return "", fmt.Errorf("query: %s must be a string", name)
+	}
+	return val.Str, nil
+}
+
+func (r *Runtime) propI64(props map[string]*Value, name string) (int64, error) {
+	val, err := r.getValue(props[name])
+	if err != nil || val.Kind != KindI64 {
+		return 0, fmt.Errorf("query: %s must be an i64", name)
+	}
+	return val.I64, nil
+}
+
+func (r *Runtime) propElems(props map[string]*Value, name string) ([]*Value, error) {
+	val, err := r.getValue(props[name])
+	if err != nil || val.Kind != KindArray {
+		return nil, fmt.Errorf("query: %s must be an array", name)
+	}
+	return val.Arr.Elems, nil
+}
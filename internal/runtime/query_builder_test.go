@@ -0,0 +1,63 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildSelectQueryWhitelistsIdentifiers(t *testing.T) {
+	r := NewRuntime()
+	r.tableDefs = []TableDef{{
+		Name: "todos",
+		Columns: []ColumnDef{
+			{Name: "id", Type: "INTEGER", Constraints: "PRIMARY KEY"},
+			{Name: "title", Type: "TEXT", Constraints: "NOT NULL"},
+		},
+	}}
+	str := func(s string) *Value { return r.newValue(Value{Kind: KindString, Str: s}) }
+	i64 := func(n int64) *Value { return r.newValue(Value{Kind: KindI64, I64: n}) }
+	obj := func(props map[string]*Value) *Value {
+		return r.newValue(Value{Kind: KindObject, Obj: &Object{Order: sortedKeys(props), Props: props}})
+	}
+	arr := func(elems ...*Value) *Value { return r.newValue(Value{Kind: KindArray, Arr: &Array{Elems: elems}}) }
+	query := func(table string, column string, op string, order string) *Value {
+		return obj(map[string]*Value{
+			"table":      str(table),
+			"conditions": arr(obj(map[string]*Value{"column": str(column), "op": str(op), "value": str("x")})),
+			"orders":     arr(obj(map[string]*Value{"column": str(column), "order": str(order)})),
+			"limit":      i64(10),
+			"offset":     i64(0),
+			"row":        nullValue,
+		})
+	}
+
+	q, err := r.buildSelectQuery(query("TODOS", "Title", "LIKE", "desc"))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	want := `SELECT "id", "title" FROM "todos" WHERE "title" LIKE ? ORDER BY "title" DESC LIMIT ? OFFSET ?`
+	if q.sql != want {
+		t.Fatalf("unexpected SQL:\n got: %s\nwant: %s", q.sql, want)
+	}
+	if !reflect.DeepEqual(q.params, []interface{}{"x", int64(10), int64(0)}) {
+		t.Fatalf("unexpected params: %v", q.params)
+	}
+
+	for _, tc := range []struct {
+		query *Value
+		want  string
+	}{
+		{query("users", "id", "=", "asc"), "table 'users' is not defined"},
+		{query("todos", "id; DROP TABLE todos", "=", "asc"), "column 'id; DROP TABLE todos' does not exist"},
+		{query("todos", "id", "= 1 OR 1 =", "asc"), "unsupported operator"},
+		{query("todos", "id", "=", "random()"), "unsupported sort order"},
+	} {
+		if _, err := r.buildSelectQuery(tc.query); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("expected error containing %q, got %v", tc.want, err)
+		}
+	}
+}
@@ -7,20 +7,134 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bytecodealliance/wasmtime-go/v41"
 )
 
 type Runner struct {
-	engine *wasmtime.Engine
+	engine  *wasmtime.Engine
+	workers int
+	// sqlTrace は --sql-trace / --sql-slow の設定（nil なら記録しない）。
+	sqlTrace *sqlTracer
+	// isolated なら WASI に環境変数・標準入力・ディレクトリを渡さない（run_sandbox 用）。
+	isolated bool
+	// stdout / stderr / html は fd=1 / fd=2 / fd=3 の書き込み先。
+	// nil なら stdout と html は Output() 用に溜め、stderr は os.Stderr に書く。
+	stdout io.Writer
+	stderr io.Writer
+	html   io.Writer
+	// stdin は fd=0 の読み込み元（nil なら os.Stdin、isolated なら空）。
+	stdin io.Reader
+	// limits は1回の実行に課す上限（run_sandbox 用）。
+	limits Limits
+}
+
+// Limits は1回の実行に課す上限。0 の項目は制限しない。
+type Limits struct {
+	MaxFuel        uint64        // wasmtime の fuel（おおよそ実行する命令数）
+	Timeout        time.Duration // 実行時間（epoch 割り込みで止める）
+	MaxMemoryBytes int64         // 線形メモリと GC ヒープそれぞれの大きさ
+	MaxOutputBytes int64         // fd=1 / fd=2 / fd=3 に書く合計バイト数
+}
+
+// LimitError は Limits のどれかを超えて実行を止めたことを表す。
+// Limit は "fuel" / "timeout" / "memory" / "output" のいずれかで、Max はその上限（timeout はミリ秒）。
+type LimitError struct {
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case "timeout":
+		return fmt.Sprintf("timeout exceeded (%dms)", e.Max)
+	case "fuel":
+		return fmt.Sprintf("fuel limit exceeded (%d)", e.Max)
+	default:
+		return fmt.Sprintf("%s limit exceeded (%d bytes)", e.Limit, e.Max)
+	}
+}
+
+// ExitError は server.exit や WASI の proc_exit で 0 以外の終了コードが指定されたことを表す。
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
 }
 
 func NewRunner() *Runner {
+	return &Runner{engine: newEngine(Limits{}), workers: 1}
+}
+
+func newEngine(limits Limits) *wasmtime.Engine {
 	config := wasmtime.NewConfig()
 	// Enable Wasm GC-related proposals so GC-enabled modules can run.
 	config.SetWasmFunctionReferences(true)
 	config.SetWasmGC(true)
-	return &Runner{engine: wasmtime.NewEngineWithConfig(config)}
+	config.SetConsumeFuel(limits.MaxFuel > 0)
+	config.SetEpochInterruption(limits.Timeout > 0)
+	return wasmtime.NewEngineWithConfig(config)
+}
+
+// SetWorkers は HTTP ハンドラーを並行実行する WASM インスタンス数を設定する。
+func (r *Runner) SetWorkers(n int) error {
+	if n < 1 {
+		return fmt.Errorf("workers must be at least 1: %d", n)
+	}
+	r.workers = n
+	return nil
+}
+
+// SetSQLTrace は SQL ブロックのクエリログを設定する。all なら全クエリを、
+// そうでなければ slow 以上かかったクエリだけを標準エラー出力に記録する。
+func (r *Runner) SetSQLTrace(all bool, slow time.Duration) error {
+	if slow < 0 {
+		return fmt.Errorf("sql-slow must not be negative: %s", slow)
+	}
+	if !all && slow == 0 {
+		r.sqlTrace = nil
+		return nil
+	}
+	r.sqlTrace = &sqlTracer{out: os.Stderr, all: all, slow: slow}
+	return nil
+}
+
+// SetLimits は実行の上限を設定する。fuel と epoch 割り込みはエンジンの設定なのでエンジンを作り直す。
+func (r *Runner) SetLimits(limits Limits) error {
+	if limits.Timeout < 0 || limits.MaxMemoryBytes < 0 || limits.MaxOutputBytes < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	r.limits = limits
+	r.engine = newEngine(limits)
+	return nil
+}
+
+// SetStdout は fd=1 の書き込み先を設定する。fd_write のたびにそのまま書き込み、Output() には溜めない。
+func (r *Runner) SetStdout(w io.Writer) {
+	r.stdout = w
+}
+
+// SetStderr は fd=2（log_error / eprint / main の error）の書き込み先を設定する。
+func (r *Runner) SetStderr(w io.Writer) {
+	r.stderr = w
+}
+
+// SetHTMLOutput は fd=3（HTML 出力）の書き込み先を設定する。
+func (r *Runner) SetHTMLOutput(w io.Writer) {
+	r.html = w
+}
+
+// SetStdin は fd=0（io.read_stdin / io.read_line）の読み込み元を設定する。
+func (r *Runner) SetStdin(rd io.Reader) {
+	r.stdin = rd
 }
 
 func (r *Runner) Run(wasm []byte) (string, error) {
@@ -54,16 +168,65 @@ func (r *Runner) runWithArgs(wasm []byte, args []string) (rt *Runtime, err error
 
 	rt = NewRuntime()
 	rt.SetArgs(args)
-	if err := defineWASIFDWrite(linker, store, rt); err != nil {
+	if err := r.applyLimits(store); err != nil {
 		return rt, err
 	}
-	if err := rt.Define(linker, store); err != nil {
-		return rt, err
+	var timedOut atomic.Bool
+	if r.limits.Timeout > 0 {
+		timer := time.AfterFunc(r.limits.Timeout, func() {
+			timedOut.Store(true)
+			r.engine.IncrementEpoch()
+		})
+		defer timer.Stop()
+	}
+	rt.maxOutputBytes = r.limits.MaxOutputBytes
+	rt.workers = r.workers
+	rt.sqlTrace = r.sqlTrace
+	// 親とワーカーが同じ Writer に並行して書くので、1回の実行で1つのロックを共有する。
+	outMu := &sync.Mutex{}
+	rt.stdout = lockWriter(outMu, r.stdout)
+	rt.html = lockWriter(outMu, r.html)
+	if r.stderr != nil {
+		rt.stderr = lockWriter(outMu, r.stderr)
+	}
+	if r.stdin != nil {
+		rt.stdin = r.stdin
+	} else if r.isolated {
+		rt.stdin = strings.NewReader("")
 	}
 	module, err := wasmtime.NewModule(r.engine, wasm)
 	if err != nil {
 		return rt, err
 	}
+	if importsWASI(module) {
+		// --target=wasi のモジュールは wasmtime-go の WASI をそのまま使う。
+		// os.Stdout / os.Stderr はそのまま引き継ぎ、それ以外は一時ファイルに書かせて終了後に移す。
+		stdout, stderr, err := r.defineWASI(linker, store, args)
+		if err != nil {
+			return rt, err
+		}
+		defer func() {
+			if stdout != "" {
+				if data, readErr := os.ReadFile(stdout); readErr == nil {
+					_ = rt.appendOutputChunk(string(data))
+				}
+				os.Remove(stdout)
+			}
+			if stderr != "" {
+				if data, readErr := os.ReadFile(stderr); readErr == nil {
+					_ = rt.appendErrorChunk(string(data))
+				}
+				os.Remove(stderr)
+			}
+		}()
+	} else if err := defineWASIFDWrite(linker, store, rt); err != nil {
+		return rt, err
+	} else if err := defineWASIFDRead(linker, store, rt); err != nil {
+		return rt, err
+	}
+	if err := rt.Define(linker, store); err != nil {
+		return rt, err
+	}
 	instance, err := linker.Instantiate(store, module)
 	if err != nil {
 		return rt, err
@@ -78,26 +241,226 @@ func (r *Runner) runWithArgs(wasm []byte, args []string) (rt *Runtime, err error
 		return rt, errors.New("error")
 	}
 	if _, err := start.Call(store); err != nil {
+		rt.abortTxBlocks()
+		rt.closeCursors()
+		if limitErr := r.limitError(rt, store, err, timedOut.Load()); limitErr != nil {
+			return rt, limitErr
+		}
+		if rt.exited {
+			if rt.exitCode != 0 {
+				return rt, &ExitError{Code: rt.exitCode}
+			}
+			return rt, nil
+		}
+		var wasmErr *wasmtime.Error
+		if errors.As(err, &wasmErr) {
+			if status, ok := wasmErr.ExitStatus(); ok {
+				if status != 0 {
+					return rt, &ExitError{Code: int(status)}
+				}
+				return rt, nil
+			}
+		}
 		return rt, err
 	}
 
 	// _start終了時は1回強制GCして短命な参照を回収する。
 	rt.maybeStoreGC(true)
+	if len(rt.httpServers) > 0 {
+		if err := r.startWorkers(module, rt); err != nil {
+			return rt, err
+		}
+	}
 	if err := rt.StartPendingServer(); err != nil {
 		return rt, err
 	}
 	return rt, nil
 }
 
+// applyLimits は store に fuel・epoch の期限・メモリの上限を設定する。
+func (r *Runner) applyLimits(store *wasmtime.Store) error {
+	if r.limits.MaxFuel > 0 {
+		if err := store.SetFuel(r.limits.MaxFuel); err != nil {
+			return err
+		}
+	}
+	if r.limits.Timeout > 0 {
+		store.SetEpochDeadline(1)
+	}
+	if r.limits.MaxMemoryBytes > 0 {
+		store.Limiter(r.limits.MaxMemoryBytes, -1, -1, -1, -1)
+	}
+	return nil
+}
+
+// limitError は _start の失敗が Limits によるものならその LimitError を返す（そうでなければ nil）。
+// wasmtime-go の TrapCode は C API の値とずれているので、fuel の残りと timedOut で判定する。
+func (r *Runner) limitError(rt *Runtime, store *wasmtime.Store, err error, timedOut bool) *LimitError {
+	if rt.limitErr != nil {
+		return rt.limitErr
+	}
+	var trap *wasmtime.Trap
+	if errors.As(err, &trap) {
+		if r.limits.MaxFuel > 0 {
+			if fuel, fuelErr := store.GetFuel(); fuelErr == nil && fuel == 0 {
+				return &LimitError{Limit: "fuel", Max: int64(r.limits.MaxFuel)}
+			}
+		}
+		if timedOut {
+			return &LimitError{Limit: "timeout", Max: r.limits.Timeout.Milliseconds()}
+		}
+		// prelude._alloc は memory.grow が失敗すると unreachable で止まる
+		if frames := trap.Frames(); r.limits.MaxMemoryBytes > 0 && len(frames) > 0 {
+			if name := frames[0].FuncName(); name != nil && *name == "prelude._alloc" {
+				return &LimitError{Limit: "memory", Max: r.limits.MaxMemoryBytes}
+			}
+		}
+	}
+	// GC ヒープの確保の失敗はトラップではなく、メッセージでしか区別できない
+	if r.limits.MaxMemoryBytes > 0 && strings.Contains(err.Error(), "GC heap out of memory") {
+		return &LimitError{Limit: "memory", Max: r.limits.MaxMemoryBytes}
+	}
+	return nil
+}
+
+// importsWASI は fd_write / fd_read 以外の WASI 関数を import しているか（--target=wasi でビルドしたか）を返す。
+func importsWASI(module *wasmtime.Module) bool {
+	for _, imp := range module.Imports() {
+		if imp.Module() != "wasi_snapshot_preview1" {
+			continue
+		}
+		if name := imp.Name(); name != nil && *name != "fd_write" && *name != "fd_read" {
+			return true
+		}
+	}
+	return false
+}
+
+// defineWASI は wasmtime-go の WASI（wasi_snapshot_preview1）をリンクし、stdout / stderr を書く一時ファイルのパスを返す。
+// 書き込み先が os.Stdout / os.Stderr（stderr は未指定も）ならそのまま引き継ぎ、パスは空文字列になる。
+// 引数・環境変数・標準入力・カレントディレクトリ（"." として preopen）を渡す。isolated なら引数だけ。
+func (r *Runner) defineWASI(linker *wasmtime.Linker, store *wasmtime.Store, args []string) (string, string, error) {
+	if err := linker.DefineWasi(); err != nil {
+		return "", "", err
+	}
+	var stdout, stderr string
+	cleanup := func() {
+		if stdout != "" {
+			os.Remove(stdout)
+		}
+		if stderr != "" {
+			os.Remove(stderr)
+		}
+	}
+	var err error
+	if r.stdout != io.Writer(os.Stdout) {
+		if stdout, err = createTempPath("tuna-stdout-"); err != nil {
+			return "", "", err
+		}
+	}
+	if r.stderr != nil && r.stderr != io.Writer(os.Stderr) {
+		if stderr, err = createTempPath("tuna-stderr-"); err != nil {
+			cleanup()
+			return "", "", err
+		}
+	}
+	config := wasmtime.NewWasiConfig()
+	config.SetArgv(append([]string{"tuna"}, args...))
+	if stdout != "" {
+		if err := config.SetStdoutFile(stdout); err != nil {
+			cleanup()
+			return "", "", err
+		}
+	} else {
+		config.InheritStdout()
+	}
+	if stderr != "" {
+		if err := config.SetStderrFile(stderr); err != nil {
+			cleanup()
+			return "", "", err
+		}
+	} else {
+		config.InheritStderr()
+	}
+	if r.stdin != nil && r.stdin != io.Reader(os.Stdin) {
+		// WASI の stdin にはファイルしか渡せないので、読み込み元を最後まで一時ファイルに移す。
+		stdin, err := createTempPath("tuna-stdin-")
+		if err != nil {
+			cleanup()
+			return "", "", err
+		}
+		defer os.Remove(stdin)
+		if err := copyToFile(stdin, r.stdin); err != nil {
+			cleanup()
+			return "", "", err
+		}
+		if err := config.SetStdinFile(stdin); err != nil {
+			cleanup()
+			return "", "", err
+		}
+	} else if r.stdin != nil || !r.isolated {
+		config.InheritStdin()
+	}
+	if !r.isolated {
+		config.InheritEnv()
+		if err := config.PreopenDir(".", ".", wasmtime.DIR_READ|wasmtime.DIR_WRITE, wasmtime.FILE_READ|wasmtime.FILE_WRITE); err != nil {
+			cleanup()
+			return "", "", err
+		}
+	}
+	store.SetWasi(config)
+	return stdout, stderr, nil
+}
+
+func createTempPath(pattern string) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return f.Name(), nil
+}
+
+func copyToFile(path string, src io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// lockedWriter は mu で書き込みを直列化する Writer。
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func lockWriter(mu *sync.Mutex, w io.Writer) io.Writer {
+	if w == nil {
+		return nil
+	}
+	return &lockedWriter{mu: mu, w: w}
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
 func defineWASIFDWrite(linker *wasmtime.Linker, store *wasmtime.Store, rt *Runtime) error {
-	return linker.DefineFunc(store, "wasi_snapshot_preview1", "fd_write", func(caller *wasmtime.Caller, fd int32, iovs int32, iovsLen int32, nwritten int32) int32 {
+	return linker.DefineFunc(store, "wasi_snapshot_preview1", "fd_write", func(caller *wasmtime.Caller, fd int32, iovs int32, iovsLen int32, nwritten int32) (int32, *wasmtime.Trap) {
 		ext := caller.GetExport("memory")
 		if ext == nil {
-			return 21
+			return 21, nil
 		}
 		memory := ext.Memory()
 		if memory == nil {
-			return 21
+			return 21, nil
 		}
 		data := memory.UnsafeData(caller)
 		total := 0
@@ -105,32 +468,98 @@ func defineWASIFDWrite(linker *wasmtime.Linker, store *wasmtime.Store, rt *Runti
 		for i := int32(0); i < iovsLen; i++ {
 			base := int(iovs + i*8)
 			if base < 0 || base+8 > len(data) {
-				return 21
+				return 21, nil
 			}
 			ptr := int(binary.LittleEndian.Uint32(data[base : base+4]))
 			length := int(binary.LittleEndian.Uint32(data[base+4 : base+8]))
 			if ptr < 0 || ptr+length > len(data) {
-				return 21
+				return 21, nil
 			}
 			chunk := string(data[ptr : ptr+length])
+			var err error
 			switch fd {
 			case 1:
-				if err := rt.appendOutputChunk(chunk); err != nil {
-					return 1
-				}
+				err = rt.appendOutputChunk(chunk)
+			case 2:
+				err = rt.appendErrorChunk(chunk)
 			case 3:
-				if err := rt.appendHTMLChunk(chunk); err != nil {
-					return 1
+				err = rt.appendHTMLChunk(chunk)
+			}
+			if err != nil {
+				// 出力の上限を超えたら実行を止める
+				if rt.limitErr != nil {
+					return 0, wasmtime.NewTrap(err.Error())
 				}
+				return 1, nil
 			}
 			total += length
 		}
 
 		nw := int(nwritten)
 		if nw < 0 || nw+4 > len(data) {
-			return 21
+			return 21, nil
 		}
 		binary.LittleEndian.PutUint32(data[nw:nw+4], uint32(total))
+		return 0, nil
+	})
+}
+
+// defineWASIFDRead は fd=0 の fd_read を rt.stdin からの読み込みとして定義する。
+// 読み込み元の終わりでは 0 バイトを返す。
+func defineWASIFDRead(linker *wasmtime.Linker, store *wasmtime.Store, rt *Runtime) error {
+	return linker.DefineFunc(store, "wasi_snapshot_preview1", "fd_read", func(caller *wasmtime.Caller, fd int32, iovs int32, iovsLen int32, nread int32) int32 {
+		if fd != 0 {
+			return 8
+		}
+		ext := caller.GetExport("memory")
+		if ext == nil {
+			return 21
+		}
+		memory := ext.Memory()
+		if memory == nil {
+			return 21
+		}
+		data := memory.UnsafeData(caller)
+		total := 0
+
+		for i := int32(0); i < iovsLen; i++ {
+			base := int(iovs + i*8)
+			if base < 0 || base+8 > len(data) {
+				return 21
+			}
+			ptr := int(binary.LittleEndian.Uint32(data[base : base+4]))
+			length := int(binary.LittleEndian.Uint32(data[base+4 : base+8]))
+			if ptr < 0 || ptr+length > len(data) {
+				return 21
+			}
+			if length == 0 {
+				continue
+			}
+			if rt.stdin == nil {
+				break
+			}
+			n, err := rt.stdin.Read(data[ptr : ptr+length])
+			total += n
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				if total > 0 {
+					break
+				}
+				return 29
+			}
+			// 読めた分だけ返し、足りなければ次の fd_read で続きを読む
+			if n < length {
+				break
+			}
+		}
+
+		nr := int(nread)
+		if nr < 0 || nr+4 > len(data) {
+			return 21
+		}
+		binary.LittleEndian.PutUint32(data[nr:nr+4], uint32(total))
 		return 0
 	})
 }
@@ -3,14 +3,62 @@
 
 package runtime
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"time"
+)
 
 type Runner struct{}
 
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+type Limits struct {
+	MaxFuel        uint64
+	Timeout        time.Duration
+	MaxMemoryBytes int64
+	MaxOutputBytes int64
+}
+
+type LimitError struct {
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded (%d)", e.Limit, e.Max)
+}
+
 func NewRunner() *Runner {
 	return &Runner{}
 }
 
+func (r *Runner) SetWorkers(n int) error {
+	return nil
+}
+
+func (r *Runner) SetSQLTrace(all bool, slow time.Duration) error {
+	return nil
+}
+
+func (r *Runner) SetLimits(limits Limits) error {
+	return nil
+}
+
+func (r *Runner) SetStdout(w io.Writer) {}
+
+func (r *Runner) SetStderr(w io.Writer) {}
+
+func (r *Runner) SetHTMLOutput(w io.Writer) {}
+
+func (r *Runner) SetStdin(rd io.Reader) {}
+
 func (r *Runner) Run(wasm []byte) (string, error) {
 	return "", fmt.Errorf("CGO が無効です（wasmtime-go が必要です）")
 }
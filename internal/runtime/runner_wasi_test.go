@@ -73,3 +73,52 @@ func TestRunnerCapturesWASIFD3AsHTML(t *testing.T) {
 		t.Fatalf("unexpected html output: got %q, want %q", rt.htmlOutput.String(), "<h1>ok</h1>")
 	}
 }
+
+type chunkRecorder struct {
+	chunks []string
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	c.chunks = append(c.chunks, string(p))
+	return len(p), nil
+}
+
+func TestRunnerStreamsFDWriteToWriters(t *testing.T) {
+	wasm, err := wasmtime.Wat2Wasm(`
+	(module
+	  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+	  (memory 1)
+	  (export "memory" (memory 0))
+	  (data (i32.const 16) "one\0atwo\0a<p>")
+	  (func $write (param $fd i32) (param $ptr i32) (param $len i32)
+	    (i32.store (i32.const 0) (local.get $ptr))
+	    (i32.store (i32.const 4) (local.get $len))
+	    (call $fd_write (local.get $fd) (i32.const 0) (i32.const 1) (i32.const 8))
+	    drop)
+	  (func (export "_start")
+	    (call $write (i32.const 1) (i32.const 16) (i32.const 4))
+	    (call $write (i32.const 3) (i32.const 24) (i32.const 3))
+	    (call $write (i32.const 1) (i32.const 20) (i32.const 4))))
+	`)
+	if err != nil {
+		t.Fatalf("wat2wasm failed: %v", err)
+	}
+
+	var stdout, html chunkRecorder
+	runner := NewRunner()
+	runner.SetStdout(&stdout)
+	runner.SetHTMLOutput(&html)
+	rt, err := runner.runWithArgs(wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(stdout.chunks) != 2 || stdout.chunks[0] != "one\n" || stdout.chunks[1] != "two\n" {
+		t.Fatalf("unexpected stdout chunks: %q", stdout.chunks)
+	}
+	if len(html.chunks) != 1 || html.chunks[0] != "<p>" {
+		t.Fatalf("unexpected html chunks: %q", html.chunks)
+	}
+	if rt.Output() != "" || rt.htmlOutput.String() != "" {
+		t.Fatalf("streamed output should not be buffered: %q %q", rt.Output(), rt.htmlOutput.String())
+	}
+}
@@ -5,17 +5,20 @@ package runtime
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	goruntime "runtime"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 	"unicode/utf8"
 
@@ -23,6 +26,7 @@ import (
 	_ "modernc.org/sqlite"
 	"tuna/internal/compiler"
 	"tuna/internal/formatter"
+	"tuna/internal/sqlparser"
 )
 
 type Kind int
@@ -59,8 +63,11 @@ type Array struct {
 
 // TableDef represents a table definition for validation
 type TableDef struct {
-	Name    string      `json:"name"`
-	Columns []ColumnDef `json:"columns"`
+	Name        string          `json:"name"`
+	Connection  string          `json:"connection,omitempty"` // db_connect の接続名（空ならデフォルト接続）
+	Columns     []ColumnDef     `json:"columns"`
+	Constraints []ConstraintDef `json:"constraints,omitempty"`
+	Indexes     []IndexDef      `json:"indexes,omitempty"`
 }
 
 // ColumnDef represents a column definition
@@ -70,12 +77,31 @@ type ColumnDef struct {
 	Constraints string `json:"constraints"`
 }
 
+// ConstraintDef represents a table constraint (UNIQUE / PRIMARY KEY / FOREIGN KEY / CHECK)
+type ConstraintDef struct {
+	Kind       string   `json:"kind"` // "primary_key", "unique", "foreign_key", "check"
+	Name       string   `json:"name,omitempty"`
+	Columns    []string `json:"columns,omitempty"`
+	RefTable   string   `json:"ref_table,omitempty"`
+	RefColumns []string `json:"ref_columns,omitempty"`
+	SQL        string   `json:"sql"`
+}
+
+// IndexDef represents an index declared with create_index
+type IndexDef struct {
+	Name    string   `json:"name"`
+	Unique  bool     `json:"unique,omitempty"`
+	Columns []string `json:"columns"`
+}
+
 const (
 	routeMethodAny = "*"
 
 	gcRequestInterval    uint64 = 100
 	gcHeapThresholdBytes uint64 = 64 << 20 // 64 MiB
 	gcMaxInterval               = time.Minute
+
+	defaultShutdownGrace = 10 * time.Second
 )
 
 // HTTPServer represents an HTTP server instance
@@ -96,16 +122,30 @@ type HTTPResponse struct {
 	ContentType string
 	StatusCode  int
 	RedirectURL string
+	// StreamFunc は response_stream / sse の関数値（エクスポート名）。空でなければストリーミング応答。
+	StreamFunc string
 }
 
 type Runtime struct {
-	output          bytes.Buffer
-	htmlOutput      bytes.Buffer
-	db              *sql.DB
-	handlerMu       sync.Mutex
-	currentTx       *sql.Tx
+	output     bytes.Buffer
+	htmlOutput bytes.Buffer
+	db         *sql.DB
+	handlerMu  sync.Mutex
+	currentTx  *sql.Tx
+	txBlocks   []string // 開いている transaction ブロック（transaction.go）
+	// stmts はデフォルト接続の prepare 済みの文（親と全ワーカーで共有）。
+	// txStmts は txStmtsOf のトランザクションに束縛した文（sql_stmt.go）。
+	stmts     *stmtCache
+	txStmts   map[string]*sql.Stmt
+	txStmtsOf *sql.Tx
+	// staticLimit はインスタンス生成時のメモリサイズで、これより下はデータセグメント（静的な文字列）。
+	staticLimit int
+	sqlTexts    map[[2]int32]string
+	// sqlTrace は --sql-trace / --sql-slow のクエリログ（nil なら記録しない）。
+	sqlTrace        *sqlTracer
 	args            []string
-	tableDefs       []TableDef // Table definitions for validation
+	tableDefs       []TableDef  // Table definitions for validation
+	migrations      []Migration // migration 宣言（名前順）
 	httpServers     map[int64]*HTTPServer
 	httpMu          sync.Mutex
 	store           *wasmtime.Store
@@ -116,6 +156,45 @@ type Runtime struct {
 	gcReqCount    uint64
 	gcLastHeap    uint64
 	gcLastAt      time.Time
+	// workers は HTTP ハンドラーを並行実行するインスタンス数（--workers）。
+	workers int
+	// pool はハンドラーを並行実行するワーカー群（workers > 1 のときのみ）。
+	pool *workerPool
+	// isWorker はプールが生成した追加インスタンスであることを示す（DBは親と共有）。
+	isWorker bool
+	// streamPool はストリーミングレスポンス用のインスタンス群（HTTP サーバーを起動したときのみ）。
+	streamPool *streamPool
+	// streamsCtx はストリーミングレスポンス全体の親コンテキスト。シャットダウンの猶予が切れるとキャンセルされる。
+	streamsCtx context.Context
+	// writeMu は同じDBを共有する全インスタンスでSQLite書き込みを直列化する。
+	writeMu     *sync.Mutex
+	writeLocked bool // 現在のトランザクションが writeMu を保持している
+	// streams は実行中のストリーミングレスポンス（Writer ハンドル -> ストリーム）。
+	streams      map[int64]*httpStream
+	nextStreamID int64
+	// websockets は接続中の WebSocket（親と全ワーカーで共有）。
+	websockets *websocketRegistry
+	// conns は db_connect で開いた名前付き接続（親と全ワーカーで共有）。
+	conns *connRegistry
+	// cursors は開いている fetch_iter のカーソル、pins はカーソルが pin した接続（sql_cursor.go）。
+	cursors      map[int64]*sqlCursor
+	nextCursorID int64
+	pins         map[*namedConn]*pinnedConn
+	// stderr は fd=2（log_error / eprint / main の error）の書き込み先。
+	stderr io.Writer
+	// exited は server.exit が呼ばれたことを示し、exitCode はその終了コード。
+	exited   bool
+	exitCode int
+	// stdout / html は fd=1 / fd=3 の書き込み先（nil なら output / htmlOutput に溜める）。
+	stdout io.Writer
+	html   io.Writer
+	// stdin は fd=0（io.read_stdin / io.read_line）の読み込み元（nil なら常に入力の終わり）。
+	stdin io.Reader
+	// maxOutputBytes は fd=1 / fd=2 / fd=3 に書ける合計（0 なら無制限）、outputBytes はこれまでの合計。
+	// 超えたときの LimitError を limitErr に残す。
+	maxOutputBytes int64
+	outputBytes    int64
+	limitErr       *LimitError
 }
 
 var (
@@ -163,8 +242,17 @@ var (
 // 5. HTTPリクエスト到着時、handlerFunc.Call()はクリアなスタックで実行可能
 // ============================================================================
 type pendingHTTPServer struct {
-	server *HTTPServer
-	port   string
+	server  *HTTPServer
+	port    string
+	options serverOptions
+}
+
+// serverOptions は listen_with で指定されたサーバー設定（0 は既定値）。
+type serverOptions struct {
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	maxBodyBytes  int64
+	shutdownGrace time.Duration
 }
 
 func NewRuntime() *Runtime {
@@ -174,6 +262,11 @@ func NewRuntime() *Runtime {
 		internedStrings: make(map[uint64]*Value),
 		gcLastHeap:      currentHeapAlloc(),
 		gcLastAt:        now,
+		writeMu:         &sync.Mutex{},
+		websockets:      newWebsocketRegistry(),
+		conns:           newConnRegistry(),
+		stderr:          os.Stderr,
+		stdin:           os.Stdin,
 	}
 	return r
 }
@@ -182,6 +275,9 @@ func NewRuntime() *Runtime {
 func (r *Runtime) SetWasmContext(store *wasmtime.Store, instance *wasmtime.Instance) {
 	r.store = store
 	r.instance = instance
+	if ext := instance.GetExport(store, "memory"); ext != nil && ext.Memory() != nil {
+		r.staticLimit = int(ext.Memory().DataSize(store))
+	}
 }
 
 func currentHeapAlloc() uint64 {
@@ -236,6 +332,13 @@ func (r *Runtime) appendOutputChunk(chunk string) error {
 	if chunk == "" {
 		return nil
 	}
+	if err := r.countOutput(len(chunk)); err != nil {
+		return err
+	}
+	if r.stdout != nil {
+		_, err := io.WriteString(r.stdout, chunk)
+		return err
+	}
 	r.output.WriteString(chunk)
 	return nil
 }
@@ -244,10 +347,41 @@ func (r *Runtime) appendHTMLChunk(chunk string) error {
 	if chunk == "" {
 		return nil
 	}
+	if err := r.countOutput(len(chunk)); err != nil {
+		return err
+	}
+	if r.html != nil {
+		_, err := io.WriteString(r.html, chunk)
+		return err
+	}
 	r.htmlOutput.WriteString(chunk)
 	return nil
 }
 
+func (r *Runtime) appendErrorChunk(chunk string) error {
+	if chunk == "" {
+		return nil
+	}
+	if err := r.countOutput(len(chunk)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(r.stderr, chunk)
+	return err
+}
+
+// countOutput は出力の合計が maxOutputBytes を超えたら LimitError を返す。
+func (r *Runtime) countOutput(n int) error {
+	if r.maxOutputBytes <= 0 {
+		return nil
+	}
+	r.outputBytes += int64(n)
+	if r.outputBytes > r.maxOutputBytes {
+		r.limitErr = &LimitError{Limit: "output", Max: r.maxOutputBytes}
+		return r.limitErr
+	}
+	return nil
+}
+
 func (r *Runtime) SetArgs(args []string) {
 	r.args = args
 }
@@ -271,7 +405,13 @@ func (r *Runtime) Define(linker *wasmtime.Linker, store *wasmtime.Store) error {
 	}
 	if err := defineRuntime("run_sandbox", func(sourceHandle *Value) *Value {
 		value, err := r.run_sandbox(sourceHandle)
-		return r.resultValue(value, err)
+		return r.sandboxResult(value, err)
+	}); err != nil {
+		return err
+	}
+	if err := defineRuntime("run_sandbox_with", func(sourceHandle *Value, optionsHandle *Value) *Value {
+		value, err := r.run_sandbox_with(sourceHandle, optionsHandle)
+		return r.sandboxResult(value, err)
 	}); err != nil {
 		return err
 	}
@@ -285,26 +425,76 @@ func (r *Runtime) Define(linker *wasmtime.Linker, store *wasmtime.Store) error {
 	}); err != nil {
 		return err
 	}
-	if err := defineServer("sql_query", func(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value) *Value {
-		value, err := r.sqlQuery(caller, ptr, length, paramsHandle)
+	if err := defineServer("sql_query", func(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, typesPtr int32, typesLen int32, connHandle *Value) *Value {
+		value, err := r.sqlQuery(caller, ptr, length, paramsHandle, typesPtr, typesLen, connHandle)
+		return r.resultValue(value, err)
+	}); err != nil {
+		return err
+	}
+	if err := defineServer("sql_fetch_one", func(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, typesPtr int32, typesLen int32, connHandle *Value) *Value {
+		value, err := r.sqlFetchOne(caller, ptr, length, paramsHandle, typesPtr, typesLen, connHandle)
+		return r.resultValue(value, err)
+	}); err != nil {
+		return err
+	}
+	if err := defineServer("sql_fetch_optional", func(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, typesPtr int32, typesLen int32, connHandle *Value) *Value {
+		value, err := r.sqlFetchOptional(caller, ptr, length, paramsHandle, typesPtr, typesLen, connHandle)
+		return r.resultValue(value, err)
+	}); err != nil {
+		return err
+	}
+	if err := defineServer("sql_execute", func(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, connHandle *Value) *Value {
+		return r.resultError(r.sqlExecute(caller, ptr, length, paramsHandle, connHandle))
+	}); err != nil {
+		return err
+	}
+	if err := defineServer("sql_connect", func(nameHandle *Value, filenameHandle *Value, inMemory int32) *Value {
+		value, err := r.sqlConnect(nameHandle, filenameHandle, inMemory)
+		return r.resultValue(value, err)
+	}); err != nil {
+		return err
+	}
+	if err := defineServer("sql_select", func(queryHandle *Value, mode int32) *Value {
+		value, err := r.sqlSelect(queryHandle, mode)
+		return r.resultValue(value, err)
+	}); err != nil {
+		return err
+	}
+	if err := defineServer("sql_cursor_open", func(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, typesPtr int32, typesLen int32, connHandle *Value) *Value {
+		value, err := r.sqlCursorOpen(caller, ptr, length, paramsHandle, typesPtr, typesLen, connHandle)
 		return r.resultValue(value, err)
 	}); err != nil {
 		return err
 	}
-	if err := defineServer("sql_fetch_one", func(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value) *Value {
-		value, err := r.sqlFetchOne(caller, ptr, length, paramsHandle)
+	if err := defineServer("sql_cursor_next", func(cursorHandle *Value) *Value {
+		value, err := r.sqlCursorNext(cursorHandle)
 		return r.resultValue(value, err)
 	}); err != nil {
 		return err
 	}
-	if err := defineServer("sql_fetch_optional", func(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value) *Value {
-		value, err := r.sqlFetchOptional(caller, ptr, length, paramsHandle)
+	if err := defineServer("sql_cursor_close", func(cursorHandle *Value) {
+		r.sqlCursorClose(cursorHandle)
+	}); err != nil {
+		return err
+	}
+	if err := defineServer("sql_select_page", func(queryHandle *Value, keyHandle *Value, afterHandle *Value, size int64) *Value {
+		value, err := r.sqlSelectPage(queryHandle, keyHandle, afterHandle, size)
 		return r.resultValue(value, err)
 	}); err != nil {
 		return err
 	}
-	if err := defineServer("sql_execute", func(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value) *Value {
-		return r.resultError(r.sqlExecute(caller, ptr, length, paramsHandle))
+	if err := defineServer("sql_tx_begin", func() *Value {
+		return r.resultError(r.sqlTxBegin())
+	}); err != nil {
+		return err
+	}
+	if err := defineServer("sql_tx_commit", func() *Value {
+		return r.resultError(r.sqlTxCommit())
+	}); err != nil {
+		return err
+	}
+	if err := defineServer("sql_tx_rollback", func() {
+		must0(r.sqlTxRollback())
 	}); err != nil {
 		return err
 	}
@@ -323,6 +513,14 @@ func (r *Runtime) Define(linker *wasmtime.Linker, store *wasmtime.Store) error {
 	}); err != nil {
 		return err
 	}
+	// exit は終了コードを記録してトラップで実行を打ち切る。Runner がトラップを終了コードに変換する。
+	if err := defineServer("exit", func(code int64) *wasmtime.Trap {
+		r.exited = true
+		r.exitCode = int(code)
+		return wasmtime.NewTrap(fmt.Sprintf("exit %d", code))
+	}); err != nil {
+		return err
+	}
 
 	// Host bridge functions (externref-based)
 	if err := defineHost("val_from_i64", func(v int64) *Value {
@@ -472,6 +670,16 @@ func (r *Runtime) Define(linker *wasmtime.Linker, store *wasmtime.Store) error {
 	}); err != nil {
 		return err
 	}
+	if err := defineHost("http_listen_with", func(serverHandle *Value, optionsHandle *Value) {
+		must0(r.httpListenWith(serverHandle, optionsHandle))
+	}); err != nil {
+		return err
+	}
+	if err := defineHost("http_serve_static", func(serverHandle *Value, prefixHandle *Value, sourceHandle *Value) {
+		must0(r.httpServeStatic(serverHandle, prefixHandle, sourceHandle))
+	}); err != nil {
+		return err
+	}
 	if err := defineHost("http_response_text", func(caller *wasmtime.Caller, textPtr int32, textLen int32) *Value {
 		return must(r.httpResponseText(caller, textPtr, textLen))
 	}); err != nil {
@@ -507,6 +715,31 @@ func (r *Runtime) Define(linker *wasmtime.Linker, store *wasmtime.Store) error {
 	}); err != nil {
 		return err
 	}
+	if err := defineHost("http_response_stream", func(fnHandle *Value, isSSE int32) *Value {
+		return must(r.httpResponseStream(fnHandle, isSSE))
+	}); err != nil {
+		return err
+	}
+	if err := defineHost("http_stream_write", func(writerHandle *Value, chunkHandle *Value) *Value {
+		return r.resultError(r.httpStreamWrite(writerHandle, chunkHandle))
+	}); err != nil {
+		return err
+	}
+	if err := defineHost("http_stream_flush", func(writerHandle *Value) *Value {
+		return r.resultError(r.httpStreamFlush(writerHandle))
+	}); err != nil {
+		return err
+	}
+	if err := defineHost("http_add_websocket", func(serverHandle *Value, pathHandle *Value, handlersHandle *Value) {
+		must0(r.httpAddWebsocket(serverHandle, pathHandle, handlersHandle))
+	}); err != nil {
+		return err
+	}
+	if err := defineHost("http_websocket_send", func(connHandle *Value, textHandle *Value) *Value {
+		return r.resultError(r.httpWebsocketSend(connHandle, textHandle))
+	}); err != nil {
+		return err
+	}
 	if err := defineHost("http_get_path", func(reqHandle *Value) *Value {
 		return must(r.httpGetPath(reqHandle))
 	}); err != nil {
@@ -729,7 +962,81 @@ func (r *Runtime) sandboxResultValue(stdout string, htmlOut string) *Value {
 	})
 }
 
+// defaultSandboxLimits は run_sandbox と、run_sandbox_with で 0 を指定した項目の上限。
+var defaultSandboxLimits = Limits{
+	MaxFuel:        2_000_000_000,
+	Timeout:        5 * time.Second,
+	MaxMemoryBytes: 256 << 20,
+	MaxOutputBytes: 1 << 20,
+}
+
+// sandboxResult は run_sandbox の結果を返す。エラーには limit（超えた上限の名前、それ以外は undefined）を付ける。
+func (r *Runtime) sandboxResult(value *Value, err error) *Value {
+	if err == nil {
+		return value
+	}
+	errValue := r.decodeError(err.Error())
+	limit := undefinedValue
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		limit = r.newValue(Value{Kind: KindString, Str: limitErr.Limit})
+	}
+	errValue.Obj.Props["limit"] = limit
+	errValue.Obj.Order = sortedKeys(errValue.Obj.Props)
+	return errValue
+}
+
 func (r *Runtime) run_sandbox(sourceHandle *Value) (*Value, error) {
+	return r.runSandbox(sourceHandle, defaultSandboxLimits)
+}
+
+// run_sandbox_with は options の上限で run_sandbox を実行する。0 の項目は既定値を使う。
+func (r *Runtime) run_sandbox_with(sourceHandle *Value, optionsHandle *Value) (*Value, error) {
+	optionsVal, err := r.getValue(optionsHandle)
+	if err != nil {
+		return nil, err
+	}
+	if optionsVal.Kind != KindObject {
+		return nil, errors.New("sandbox options must be object")
+	}
+	option := func(key string, fallback int64) (int64, error) {
+		v, ok := optionsVal.Obj.Props[key]
+		if !ok || v.Kind != KindI64 {
+			return 0, fmt.Errorf("sandbox options: %s must be i64", key)
+		}
+		if v.I64 < 0 {
+			return 0, fmt.Errorf("sandbox options: %s must not be negative", key)
+		}
+		if v.I64 == 0 {
+			return fallback, nil
+		}
+		return v.I64, nil
+	}
+	maxFuel, err := option("max_fuel", int64(defaultSandboxLimits.MaxFuel))
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := option("timeout_ms", defaultSandboxLimits.Timeout.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	maxMemory, err := option("max_memory_bytes", defaultSandboxLimits.MaxMemoryBytes)
+	if err != nil {
+		return nil, err
+	}
+	maxOutput, err := option("max_output_bytes", defaultSandboxLimits.MaxOutputBytes)
+	if err != nil {
+		return nil, err
+	}
+	return r.runSandbox(sourceHandle, Limits{
+		MaxFuel:        uint64(maxFuel),
+		Timeout:        time.Duration(timeout) * time.Millisecond,
+		MaxMemoryBytes: maxMemory,
+		MaxOutputBytes: maxOutput,
+	})
+}
+
+func (r *Runtime) runSandbox(sourceHandle *Value, limits Limits) (*Value, error) {
 	sourceValue, err := r.getValue(sourceHandle)
 	if err != nil {
 		return nil, err
@@ -759,6 +1066,10 @@ func (r *Runtime) run_sandbox(sourceHandle *Value) (*Value, error) {
 	}
 
 	runner := NewRunner()
+	runner.isolated = true
+	if err := runner.SetLimits(limits); err != nil {
+		return nil, err
+	}
 	rt, err := runner.runWithArgs(res.Wasm, nil)
 	if err != nil {
 		return nil, err
@@ -1078,16 +1389,16 @@ func (r *Runtime) sqlExec(caller *wasmtime.Caller, ptr int32, length int32) (*Va
 	query := string(data[start:end])
 
 	// Determine if it's a SELECT query or a modification query
-	trimmed := strings.TrimSpace(strings.ToUpper(query))
-	isSelect := strings.HasPrefix(trimmed, "SELECT")
-
-	if isSelect {
-		return r.execSelectQuery(query)
+	if returnsRows, writes := classifySQL(query); returnsRows {
+		return r.execSelectQuery(query, writes)
 	}
 	return r.execModifyQuery(query)
 }
 
-func (r *Runtime) execSelectQuery(query string) (*Value, error) {
+func (r *Runtime) execSelectQuery(query string, writes bool) (*Value, error) {
+	if writes {
+		defer r.lockWrite()()
+	}
 	rows, err := r.dbQuery(query)
 	if err != nil {
 		return nil, fmt.Errorf("sql query error: %w", err)
@@ -1119,20 +1430,9 @@ func (r *Runtime) execSelectQuery(query string) (*Value, error) {
 			return nil, fmt.Errorf("sql scan error: %w", err)
 		}
 		// Create row object with column names as keys
-		rowObj := r.newValue(Value{Kind: KindObject, Obj: &Object{Order: []string{}, Props: map[string]*Value{}}})
-		for i, v := range values {
-			var str string
-			if v == nil {
-				str = ""
-			} else {
-				str = fmt.Sprintf("%v", v)
-			}
-			colName := strings.ToLower(cols[i])
-			keyHandle := r.newValue(Value{Kind: KindString, Str: colName})
-			valueHandle := r.newValue(Value{Kind: KindString, Str: str})
-			if err := r.objSet(rowObj, keyHandle, valueHandle); err != nil {
-				return nil, err
-			}
+		rowObj, err := r.sqlRowObject(cols, values, nil)
+		if err != nil {
+			return nil, err
 		}
 		rowHandles = append(rowHandles, rowObj)
 	}
@@ -1224,11 +1524,12 @@ func (r *Runtime) registerTables(caller *wasmtime.Caller, ptr int32, length int3
 	}
 	jsonData := string(data[start:end])
 
-	var tableDefs []TableDef
-	if err := json.Unmarshal([]byte(jsonData), &tableDefs); err != nil {
-		return fmt.Errorf("failed to parse table definitions: %w", err)
+	schema, err := parseSchemaDefs([]byte(jsonData))
+	if err != nil {
+		return err
 	}
-	r.tableDefs = tableDefs
+	r.tableDefs = schema.Tables
+	r.migrations = schema.Migrations
 	if r.db != nil {
 		if err := r.initAndValidateTables(); err != nil {
 			return err
@@ -1237,49 +1538,45 @@ func (r *Runtime) registerTables(caller *wasmtime.Caller, ptr int32, length int3
 	return nil
 }
 
-// initAndValidateTables creates or validates tables based on registered definitions
+// initAndValidateTables applies pending migrations, then creates or validates tables
+// based on registered definitions
 func (r *Runtime) initAndValidateTables() error {
-	if r.db == nil || len(r.tableDefs) == 0 {
+	tables := tablesFor(r.tableDefs, "")
+	if r.db == nil || (len(tables) == 0 && len(r.migrations) == 0) {
 		return nil
 	}
-
-	for _, tableDef := range r.tableDefs {
-		// Check if table exists
-		exists, err := r.tableExists(tableDef.Name)
-		if err != nil {
-			return err
-		}
-
-		if exists {
-			// Validate table structure
-			if err := r.validateTableStructure(tableDef); err != nil {
-				return err
-			}
-		} else {
-			// Create table
-			if err := r.createTable(tableDef); err != nil {
-				return err
-			}
-		}
+	if r.isWorker {
+		// ワーカーは親と同じDBを共有しており、マイグレーションは親が済ませている
+		return nil
 	}
-	return nil
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	_, err := migrateSchema(r.db, tables, r.migrations)
+	return err
 }
 
 func (r *Runtime) openDB(filename string) error {
+	if r.isWorker {
+		return errors.New("db_open cannot be called from a request handler when --workers > 1")
+	}
 	if r.currentTx != nil {
 		r.currentTx.Rollback()
 		r.currentTx = nil
+		r.txBlocks = nil
+		r.releaseTxWriteLock()
 	}
+	r.closeCursors()
 	if r.db != nil {
 		r.db.Close()
 		r.db = nil
 	}
 
-	db, err := sql.Open("sqlite", filename)
+	db, err := openSQLite(filename, r.workers)
 	if err != nil {
-		return fmt.Errorf("db open error: %w", err)
+		return err
 	}
 	r.db = db
+	r.stmts = newStmtCache()
 
 	if err := r.initAndValidateTables(); err != nil {
 		r.db.Close()
@@ -1297,83 +1594,6 @@ func (r *Runtime) ensureDefaultDB() error {
 	return r.openDB(":memory:")
 }
 
-// tableExists checks if a table exists in the database
-func (r *Runtime) tableExists(tableName string) (bool, error) {
-	var count int
-	exec := r.currentExecutor()
-	if exec == nil {
-		return false, errors.New("database not initialized")
-	}
-	err := exec.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", tableName).Scan(&count)
-	if err != nil {
-		return false, fmt.Errorf("failed to check table existence: %w", err)
-	}
-	return count > 0, nil
-}
-
-// validateTableStructure validates that an existing table matches the definition
-func (r *Runtime) validateTableStructure(tableDef TableDef) error {
-	rows, err := r.dbQuery(fmt.Sprintf("PRAGMA table_info(%s)", tableDef.Name))
-	if err != nil {
-		return fmt.Errorf("failed to get table info: %w", err)
-	}
-	defer rows.Close()
-
-	existingColumns := make(map[string]string) // name -> type
-	for rows.Next() {
-		var cid int
-		var name, colType string
-		var notNull, pk int
-		var dfltValue interface{}
-		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
-			return fmt.Errorf("failed to scan table info: %w", err)
-		}
-		existingColumns[strings.ToLower(name)] = strings.ToUpper(colType)
-	}
-
-	// Check that all defined columns exist with correct types
-	for _, col := range tableDef.Columns {
-		colName := strings.ToLower(col.Name)
-		existingType, exists := existingColumns[colName]
-		if !exists {
-			return fmt.Errorf("table '%s' is missing column '%s'", tableDef.Name, col.Name)
-		}
-		expectedType := strings.ToUpper(col.Type)
-		if existingType != expectedType {
-			return fmt.Errorf("table '%s' column '%s' has type '%s' but expected '%s'", tableDef.Name, col.Name, existingType, expectedType)
-		}
-	}
-
-	return nil
-}
-
-// createTable creates a new table based on the definition
-func (r *Runtime) createTable(tableDef TableDef) error {
-	var b strings.Builder
-	b.WriteString("CREATE TABLE ")
-	b.WriteString(tableDef.Name)
-	b.WriteString(" (")
-	for i, col := range tableDef.Columns {
-		if i > 0 {
-			b.WriteString(", ")
-		}
-		b.WriteString(col.Name)
-		b.WriteString(" ")
-		b.WriteString(col.Type)
-		if col.Constraints != "" {
-			b.WriteString(" ")
-			b.WriteString(col.Constraints)
-		}
-	}
-	b.WriteString(")")
-
-	_, err := r.dbExec(b.String())
-	if err != nil {
-		return fmt.Errorf("failed to create table '%s': %w", tableDef.Name, err)
-	}
-	return nil
-}
-
 // get_args returns the command line arguments as a string array
 func (r *Runtime) get_args() (*Value, error) {
 	argHandles := make([]*Value, len(r.args))
@@ -1384,70 +1604,84 @@ func (r *Runtime) get_args() (*Value, error) {
 }
 
 // sqlQuery executes a SQL query with parameters and returns the result
-func (r *Runtime) sqlQuery(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value) (*Value, error) {
-	if r.db == nil {
-		return nil, errors.New("database not initialized")
+func (r *Runtime) sqlQuery(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, typesPtr int32, typesLen int32, connHandle *Value) (*Value, error) {
+	conn, err := r.resolveConn(connHandle)
+	if err != nil {
+		return nil, err
 	}
-
-	ext := caller.GetExport("memory")
-	if ext == nil {
-		return nil, errors.New("memory not found")
+	if conn == nil && r.db == nil {
+		return nil, errors.New("database not initialized")
 	}
-	memory := ext.Memory()
-	if memory == nil {
-		return nil, errors.New("memory not found")
+	columnTypes, err := readSQLColumnTypes(caller, typesPtr, typesLen)
+	if err != nil {
+		return nil, err
 	}
-	data := memory.UnsafeData(caller)
-	start := int(ptr)
-	end := start + int(length)
-	if start < 0 || end > len(data) {
-		return nil, errors.New("sql string out of bounds")
+	query, static, err := r.readSQLText(caller, ptr, length)
+	if err != nil {
+		return nil, err
 	}
-	query := string(data[start:end])
 
 	// Extract parameters from the array handle
-	var params []interface{}
-	paramsVal, err := r.getValue(paramsHandle)
+	params, err := r.extractSQLParams(paramsHandle)
 	if err != nil {
-		return nil, fmt.Errorf("invalid params handle: %w", err)
-	}
-	if paramsVal.Kind == KindArray {
-		for _, elemHandle := range paramsVal.Arr.Elems {
-			elemVal, err := r.getValue(elemHandle)
-			if err != nil {
-				return nil, fmt.Errorf("invalid param element: %w", err)
-			}
-			switch elemVal.Kind {
-			case KindString:
-				params = append(params, elemVal.Str)
-			case KindI64:
-				params = append(params, elemVal.I64)
-			case KindF64:
-				params = append(params, elemVal.F64)
-			case KindBool:
-				if elemVal.Bool {
-					params = append(params, 1)
-				} else {
-					params = append(params, 0)
-				}
-			default:
-				return nil, errors.New("unsupported parameter type")
-			}
-		}
+		return nil, err
 	}
 
 	// Determine if it's a SELECT query or a modification query
-	trimmed := strings.TrimSpace(strings.ToUpper(query))
-	isSelect := strings.HasPrefix(trimmed, "SELECT")
+	if returnsRows, _ := classifySQL(query); returnsRows {
+		rowHandles, err := r.querySQLRows(conn, query, static, params, columnTypes, -1)
+		if err != nil {
+			return nil, err
+		}
+		// Return the rows array directly (no columns wrapper)
+		return r.newValue(Value{Kind: KindArray, Arr: &Array{Elems: rowHandles}}), nil
+	}
+	if err := r.execSQL(conn, query, static, params); err != nil {
+		return nil, err
+	}
+	// Return empty array for non-SELECT queries
+	return r.newValue(Value{Kind: KindArray, Arr: &Array{Elems: []*Value{}}}), nil
+}
+
+// classifySQL は文の種類から、行を返すか（SELECT と RETURNING 付きの書き込み）と、書き込むかを判定する。
+// 解析できない文や複数の文は、コメントを除いた先頭のキーワードが SELECT かどうかで判定する。
+func classifySQL(query string) (returnsRows bool, writes bool) {
+	stmts, err := sqlparser.Parse(query)
+	if err != nil || len(stmts) != 1 {
+		toks, err := sqlparser.Tokenize(query)
+		return err == nil && strings.EqualFold(toks[0].Text, "SELECT"), false
+	}
+	switch stmt := stmts[0].(type) {
+	case *sqlparser.SelectStmt:
+		return true, false
+	case *sqlparser.InsertStmt:
+		return len(stmt.Returning) > 0, true
+	case *sqlparser.UpdateStmt:
+		return len(stmt.Returning) > 0, true
+	case *sqlparser.DeleteStmt:
+		return len(stmt.Returning) > 0, true
+	}
+	return false, true
+}
+
+// querySQLRows は SELECT（または RETURNING 付きの書き込み）を実行し、行をオブジェクトとして
+// 最大 limit 行（負なら全行）読む。
+func (r *Runtime) querySQLRows(conn *namedConn, query string, static bool, params []interface{}, columnTypes map[string]sqlColumnType, limit int) (rowHandles []*Value, err error) {
+	started := time.Now()
+	defer func() {
+		r.traceSQL(query, params, started, int64(len(rowHandles)), err)
+	}()
 
-	if isSelect {
-		return r.execSelectQueryWithParams(query, params)
+	if _, writes := classifySQL(query); writes {
+		if conn == nil {
+			defer r.lockWrite()()
+		} else {
+			conn.writeMu.Lock()
+			defer conn.writeMu.Unlock()
+		}
 	}
-	return r.execModifyQueryWithParams(query, params)
-}
 
-func (r *Runtime) execSelectQueryWithParams(query string, params []interface{}) (*Value, error) {
-	rows, err := r.dbQuery(query, params...)
+	rows, err := r.connQuery(conn, query, static, params...)
 	if err != nil {
 		return nil, fmt.Errorf("sql query error: %w", err)
 	}
@@ -1458,33 +1692,20 @@ func (r *Runtime) execSelectQueryWithParams(query string, params []interface{})
 		return nil, fmt.Errorf("sql columns error: %w", err)
 	}
 
-	// Read all rows as objects
-	var rowHandles []*Value
 	values := make([]interface{}, len(cols))
 	valuePtrs := make([]interface{}, len(cols))
 	for i := range values {
 		valuePtrs[i] = &values[i]
 	}
 
-	for rows.Next() {
+	for (limit < 0 || len(rowHandles) < limit) && rows.Next() {
 		if err := rows.Scan(valuePtrs...); err != nil {
 			return nil, fmt.Errorf("sql scan error: %w", err)
 		}
 		// Create row object with column names as keys
-		rowObj := r.newValue(Value{Kind: KindObject, Obj: &Object{Order: []string{}, Props: map[string]*Value{}}})
-		for i, v := range values {
-			var str string
-			if v == nil {
-				str = ""
-			} else {
-				str = fmt.Sprintf("%v", v)
-			}
-			colName := strings.ToLower(cols[i])
-			keyHandle := r.newValue(Value{Kind: KindString, Str: colName})
-			valueHandle := r.newValue(Value{Kind: KindString, Str: str})
-			if err := r.objSet(rowObj, keyHandle, valueHandle); err != nil {
-				return nil, err
-			}
+		rowObj, err := r.sqlRowObject(cols, values, columnTypes)
+		if err != nil {
+			return nil, err
 		}
 		rowHandles = append(rowHandles, rowObj)
 	}
@@ -1492,203 +1713,89 @@ func (r *Runtime) execSelectQueryWithParams(query string, params []interface{})
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("sql rows error: %w", err)
 	}
-
-	// Return the rows array directly (no columns wrapper)
-	rowsArr := r.newValue(Value{Kind: KindArray, Arr: &Array{Elems: rowHandles}})
-	return rowsArr, nil
+	return rowHandles, nil
 }
 
-func (r *Runtime) execModifyQueryWithParams(query string, params []interface{}) (*Value, error) {
-	result, err := r.dbExec(query, params...)
+// execSQL は書き込みクエリを実行する。
+func (r *Runtime) execSQL(conn *namedConn, query string, static bool, params []interface{}) (err error) {
+	started := time.Now()
+	var affected int64
+	defer func() {
+		r.traceSQL(query, params, started, affected, err)
+	}()
+
+	result, err := r.connExec(conn, query, static, params...)
 	if err != nil {
-		return nil, fmt.Errorf("sql exec error: %w", err)
+		return fmt.Errorf("sql exec error: %w", err)
 	}
-
-	_ = result // rowsAffected not used in array return
-
-	// Return empty array for non-SELECT queries
-	rowsArr := r.newValue(Value{Kind: KindArray, Arr: &Array{Elems: []*Value{}}})
-	return rowsArr, nil
+	affected, _ = result.RowsAffected()
+	return nil
 }
 
 // sqlFetchOne executes a SQL query and returns exactly one row as an object
 // If no row is found, it returns an error
-func (r *Runtime) sqlFetchOne(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value) (*Value, error) {
-	if r.db == nil {
-		return nil, errors.New("database not initialized")
+func (r *Runtime) sqlFetchOne(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, typesPtr int32, typesLen int32, connHandle *Value) (*Value, error) {
+	row, err := r.sqlFetchFirst(caller, ptr, length, paramsHandle, typesPtr, typesLen, connHandle)
+	if err != nil {
+		return nil, err
 	}
-
-	ext := caller.GetExport("memory")
-	if ext == nil {
-		return nil, errors.New("memory not found")
+	if row == nil {
+		return nil, errors.New("fetch_one: no row found")
 	}
-	memory := ext.Memory()
-	if memory == nil {
-		return nil, errors.New("memory not found")
-	}
-	data := memory.UnsafeData(caller)
-	start := int(ptr)
-	end := start + int(length)
-	if start < 0 || end > len(data) {
-		return nil, errors.New("sql string out of bounds")
-	}
-	query := string(data[start:end])
-
-	// Extract parameters from the array handle
-	params, err := r.extractSQLParams(paramsHandle)
-	if err != nil {
-		return nil, err
-	}
-
-	rows, err := r.dbQuery(query, params...)
-	if err != nil {
-		return nil, fmt.Errorf("sql query error: %w", err)
-	}
-	defer rows.Close()
-
-	cols, err := rows.Columns()
-	if err != nil {
-		return nil, fmt.Errorf("sql columns error: %w", err)
-	}
-
-	values := make([]interface{}, len(cols))
-	valuePtrs := make([]interface{}, len(cols))
-	for i := range values {
-		valuePtrs[i] = &values[i]
-	}
-
-	if !rows.Next() {
-		return nil, errors.New("fetch_one: no row found")
-	}
-
-	if err := rows.Scan(valuePtrs...); err != nil {
-		return nil, fmt.Errorf("sql scan error: %w", err)
-	}
-
-	// Create row object with column names as keys
-	rowObj := r.newValue(Value{Kind: KindObject, Obj: &Object{Order: []string{}, Props: map[string]*Value{}}})
-	for i, v := range values {
-		var str string
-		if v == nil {
-			str = ""
-		} else {
-			str = fmt.Sprintf("%v", v)
-		}
-		colName := strings.ToLower(cols[i])
-		keyHandle := r.newValue(Value{Kind: KindString, Str: colName})
-		valueHandle := r.newValue(Value{Kind: KindString, Str: str})
-		if err := r.objSet(rowObj, keyHandle, valueHandle); err != nil {
-			return nil, err
-		}
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("sql rows error: %w", err)
-	}
-
-	return rowObj, nil
+	return row, nil
 }
 
 // sqlFetchOptional executes a SQL query and returns 0 or 1 row as an object
 // If no row is found, it returns a null/empty object
-func (r *Runtime) sqlFetchOptional(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value) (*Value, error) {
-	if r.db == nil {
-		return nil, errors.New("database not initialized")
-	}
+func (r *Runtime) sqlFetchOptional(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, typesPtr int32, typesLen int32, connHandle *Value) (*Value, error) {
+	// If no row found, return null (handle 0)
+	return r.sqlFetchFirst(caller, ptr, length, paramsHandle, typesPtr, typesLen, connHandle)
+}
 
-	ext := caller.GetExport("memory")
-	if ext == nil {
-		return nil, errors.New("memory not found")
-	}
-	memory := ext.Memory()
-	if memory == nil {
-		return nil, errors.New("memory not found")
+// sqlFetchFirst は fetch_one / fetch_optional の共通部分で、最初の行（なければ nil）を返す。
+func (r *Runtime) sqlFetchFirst(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, typesPtr int32, typesLen int32, connHandle *Value) (*Value, error) {
+	conn, err := r.resolveConn(connHandle)
+	if err != nil {
+		return nil, err
 	}
-	data := memory.UnsafeData(caller)
-	start := int(ptr)
-	end := start + int(length)
-	if start < 0 || end > len(data) {
-		return nil, errors.New("sql string out of bounds")
+	if conn == nil && r.db == nil {
+		return nil, errors.New("database not initialized")
 	}
-	query := string(data[start:end])
-
-	// Extract parameters from the array handle
-	params, err := r.extractSQLParams(paramsHandle)
+	columnTypes, err := readSQLColumnTypes(caller, typesPtr, typesLen)
 	if err != nil {
 		return nil, err
 	}
-
-	rows, err := r.dbQuery(query, params...)
+	query, static, err := r.readSQLText(caller, ptr, length)
 	if err != nil {
-		return nil, fmt.Errorf("sql query error: %w", err)
+		return nil, err
 	}
-	defer rows.Close()
 
-	cols, err := rows.Columns()
+	// Extract parameters from the array handle
+	params, err := r.extractSQLParams(paramsHandle)
 	if err != nil {
-		return nil, fmt.Errorf("sql columns error: %w", err)
-	}
-
-	values := make([]interface{}, len(cols))
-	valuePtrs := make([]interface{}, len(cols))
-	for i := range values {
-		valuePtrs[i] = &values[i]
-	}
-
-	// If no row found, return null (handle 0)
-	if !rows.Next() {
-		return nil, nil
-	}
-
-	if err := rows.Scan(valuePtrs...); err != nil {
-		return nil, fmt.Errorf("sql scan error: %w", err)
-	}
-
-	// Create row object with column names as keys
-	rowObj := r.newValue(Value{Kind: KindObject, Obj: &Object{Order: []string{}, Props: map[string]*Value{}}})
-	for i, v := range values {
-		var str string
-		if v == nil {
-			str = ""
-		} else {
-			str = fmt.Sprintf("%v", v)
-		}
-		colName := strings.ToLower(cols[i])
-		keyHandle := r.newValue(Value{Kind: KindString, Str: colName})
-		valueHandle := r.newValue(Value{Kind: KindString, Str: str})
-		if err := r.objSet(rowObj, keyHandle, valueHandle); err != nil {
-			return nil, err
-		}
+		return nil, err
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("sql rows error: %w", err)
+	rowHandles, err := r.querySQLRows(conn, query, static, params, columnTypes, 1)
+	if err != nil || len(rowHandles) == 0 {
+		return nil, err
 	}
-
-	return rowObj, nil
+	return rowHandles[0], nil
 }
 
 // sqlExecute executes a SQL query (INSERT, UPDATE, DELETE) without returning results
-func (r *Runtime) sqlExecute(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value) error {
-	if r.db == nil {
-		return errors.New("database not initialized")
-	}
-
-	ext := caller.GetExport("memory")
-	if ext == nil {
-		return errors.New("memory not found")
+func (r *Runtime) sqlExecute(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, connHandle *Value) error {
+	conn, err := r.resolveConn(connHandle)
+	if err != nil {
+		return err
 	}
-	memory := ext.Memory()
-	if memory == nil {
-		return errors.New("memory not found")
+	if conn == nil && r.db == nil {
+		return errors.New("database not initialized")
 	}
-	data := memory.UnsafeData(caller)
-	start := int(ptr)
-	end := start + int(length)
-	if start < 0 || end > len(data) {
-		return errors.New("sql string out of bounds")
+	query, static, err := r.readSQLText(caller, ptr, length)
+	if err != nil {
+		return err
 	}
-	query := string(data[start:end])
 
 	// Extract parameters from the array handle
 	params, err := r.extractSQLParams(paramsHandle)
@@ -1696,12 +1803,7 @@ func (r *Runtime) sqlExecute(caller *wasmtime.Caller, ptr int32, length int32, p
 		return err
 	}
 
-	_, err = r.dbExec(query, params...)
-	if err != nil {
-		return fmt.Errorf("sql exec error: %w", err)
-	}
-
-	return nil
+	return r.execSQL(conn, query, static, params)
 }
 
 // extractSQLParams extracts SQL parameters from an array handle
@@ -1713,31 +1815,38 @@ func (r *Runtime) extractSQLParams(paramsHandle *Value) ([]interface{}, error) {
 	}
 	if paramsVal.Kind == KindArray {
 		for _, elemHandle := range paramsVal.Arr.Elems {
-			elemVal, err := r.getValue(elemHandle)
+			param, err := r.sqlParam(elemHandle)
 			if err != nil {
-				return nil, fmt.Errorf("invalid param element: %w", err)
-			}
-			switch elemVal.Kind {
-			case KindString:
-				params = append(params, elemVal.Str)
-			case KindI64:
-				params = append(params, elemVal.I64)
-			case KindF64:
-				params = append(params, elemVal.F64)
-			case KindBool:
-				if elemVal.Bool {
-					params = append(params, 1)
-				} else {
-					params = append(params, 0)
-				}
-			default:
-				return nil, errors.New("unsupported parameter type")
+				return nil, err
 			}
+			params = append(params, param)
 		}
 	}
 	return params, nil
 }
 
+// sqlParam converts a value to a database/sql parameter (booleans become 1 / 0)
+func (r *Runtime) sqlParam(handle *Value) (interface{}, error) {
+	val, err := r.getValue(handle)
+	if err != nil {
+		return nil, fmt.Errorf("invalid param element: %w", err)
+	}
+	switch val.Kind {
+	case KindString:
+		return val.Str, nil
+	case KindI64:
+		return val.I64, nil
+	case KindF64:
+		return val.F64, nil
+	case KindBool:
+		if val.Bool {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return nil, errors.New("unsupported parameter type")
+}
+
 type dbExecutor interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	Query(query string, args ...interface{}) (*sql.Rows, error)
@@ -1746,8 +1855,15 @@ type dbExecutor interface {
 
 func (r *Runtime) currentExecutor() dbExecutor {
 	if r.currentTx != nil {
+		r.lockTxUpfront()
 		return r.currentTx
 	}
+	if exec, ok := r.pinnedExecutor(nil); ok {
+		return exec
+	}
+	if r.db == nil {
+		return nil
+	}
 	return r.db
 }
 
@@ -1756,9 +1872,52 @@ func (r *Runtime) dbExec(query string, args ...interface{}) (sql.Result, error)
 	if exec == nil {
 		return nil, errors.New("database not initialized")
 	}
+	defer r.lockWrite()()
 	return exec.Exec(query, args...)
 }
 
+// lockWrite はデフォルト接続への書き込みの前に writeMu を取得し、解放する関数を返す。
+// トランザクション中はコミット/ロールバックまで保持するので、返す関数は何もしない。
+func (r *Runtime) lockWrite() func() {
+	if r.currentTx == nil {
+		r.writeMu.Lock()
+		return r.writeMu.Unlock
+	}
+	r.acquireTxWriteLock()
+	return func() {}
+}
+
+// acquireTxWriteLock は現在のトランザクションで最初の書き込みが行われたときに
+// writeMu を取得し、コミット/ロールバックまで保持する。
+// 同じDBを共有するワーカー間で書き込みトランザクションが同時に走らないようにする。
+func (r *Runtime) acquireTxWriteLock() {
+	if r.writeLocked {
+		return
+	}
+	r.writeMu.Lock()
+	r.writeLocked = true
+}
+
+// lockTxUpfront は他のインスタンス（ワーカーやストリーム用のインスタンス）と DB を共有しているとき、
+// トランザクションの最初の文（読み込みを含む）より前に writeMu を取得する。
+// WAL では読み込みで始めたトランザクションが、その後に他のインスタンスがコミットすると
+// 書き込みに昇格できず SQLITE_BUSY_SNAPSHOT になり、busy_timeout でも待てないため。
+// WAL でない場合も、読み込みのロックを持ったまま writeMu を待つと、writeMu を持って
+// コミットを待つ側とデッドロックする。
+func (r *Runtime) lockTxUpfront() {
+	if r.workers > 1 || r.streamPool != nil || r.isWorker {
+		r.acquireTxWriteLock()
+	}
+}
+
+func (r *Runtime) releaseTxWriteLock() {
+	if !r.writeLocked {
+		return
+	}
+	r.writeLocked = false
+	r.writeMu.Unlock()
+}
+
 func (r *Runtime) dbQuery(query string, args ...interface{}) (*sql.Rows, error) {
 	exec := r.currentExecutor()
 	if exec == nil {
@@ -1871,6 +2030,72 @@ func (r *Runtime) httpListen(caller *wasmtime.Caller, serverHandle *Value, portH
 	return nil
 }
 
+// httpListenWith は listen_with 用。options オブジェクトから待ち受けアドレスと設定を読み取る。
+func (r *Runtime) httpListenWith(serverHandle *Value, optionsHandle *Value) error {
+	serverVal, err := r.getValue(serverHandle)
+	if err != nil || serverVal.Kind != KindI64 {
+		return errors.New("invalid server handle")
+	}
+
+	r.httpMu.Lock()
+	server, ok := r.httpServers[serverVal.I64]
+	r.httpMu.Unlock()
+
+	if !ok {
+		return errors.New("invalid server handle")
+	}
+
+	optionsVal, err := r.getValue(optionsHandle)
+	if err != nil {
+		return err
+	}
+	if optionsVal.Kind != KindObject {
+		return errors.New("server options must be object")
+	}
+	addrVal, ok := optionsVal.Obj.Props["addr"]
+	if !ok || addrVal.Kind != KindString {
+		return errors.New("server options: addr must be string")
+	}
+	millis := func(key string) (int64, error) {
+		v, ok := optionsVal.Obj.Props[key]
+		if !ok || v.Kind != KindI64 {
+			return 0, fmt.Errorf("server options: %s must be i64", key)
+		}
+		if v.I64 < 0 {
+			return 0, fmt.Errorf("server options: %s must not be negative", key)
+		}
+		return v.I64, nil
+	}
+	readTimeout, err := millis("read_timeout_ms")
+	if err != nil {
+		return err
+	}
+	writeTimeout, err := millis("write_timeout_ms")
+	if err != nil {
+		return err
+	}
+	maxBodyBytes, err := millis("max_body_bytes")
+	if err != nil {
+		return err
+	}
+	shutdownGrace, err := millis("shutdown_grace_ms")
+	if err != nil {
+		return err
+	}
+
+	r.pendingServer = &pendingHTTPServer{
+		server: server,
+		port:   addrVal.Str,
+		options: serverOptions{
+			readTimeout:   time.Duration(readTimeout) * time.Millisecond,
+			writeTimeout:  time.Duration(writeTimeout) * time.Millisecond,
+			maxBodyBytes:  maxBodyBytes,
+			shutdownGrace: time.Duration(shutdownGrace) * time.Millisecond,
+		},
+	}
+	return nil
+}
+
 func (r *Runtime) buildRequestObject(path string, method string, query map[string]string, form map[string]string) (*Value, error) {
 	reqObj := r.newValue(Value{Kind: KindObject, Obj: &Object{Order: []string{}, Props: map[string]*Value{}}})
 	pathHandle := r.newValue(Value{Kind: KindString, Str: path})
@@ -2024,9 +2249,6 @@ func splitRouteSegments(path string) []string {
 }
 
 func (r *Runtime) invokeRouteHandler(server *HTTPServer, path string, method string, query map[string]string, form map[string]string) (*HTTPResponse, error) {
-	r.handlerMu.Lock()
-	defer r.handlerMu.Unlock()
-
 	normalizedMethod := strings.ToUpper(method)
 
 	r.httpMu.Lock()
@@ -2044,7 +2266,24 @@ func (r *Runtime) invokeRouteHandler(server *HTTPServer, path string, method str
 		mergedQuery[key] = value
 	}
 
-	reqObj, err := r.buildRequestObject(path, method, mergedQuery, form)
+	handlerVal, err := r.getValue(handlerHandle)
+	if err != nil {
+		return nil, err
+	}
+	if handlerVal.Kind != KindString {
+		return nil, fmt.Errorf("handler is not a string, kind=%d", handlerVal.Kind)
+	}
+
+	// ワーカーを1つ借りてハンドラーを実行する。プールが無い場合は自身を handlerMu で排他して使う。
+	worker := r.acquireWorker()
+	defer r.releaseWorker(worker)
+	return worker.callRouteHandler(handlerVal.Str, path, method, mergedQuery, form)
+}
+
+// callRouteHandler は自身の store/instance 上でハンドラーを1回実行する。
+// 呼び出し側はこの Runtime を排他的に確保していること。
+func (r *Runtime) callRouteHandler(handlerName string, path string, method string, query map[string]string, form map[string]string) (*HTTPResponse, error) {
+	reqObj, err := r.buildRequestObject(path, method, query, form)
 	if err != nil {
 		return nil, err
 	}
@@ -2062,22 +2301,19 @@ func (r *Runtime) invokeRouteHandler(server *HTTPServer, path string, method str
 	r.currentTx = tx
 	committed := false
 	defer func() {
+		// トラップで抜けた場合も、開いたままのブロックとカーソル（pin した接続）を片付ける
+		r.abortTxBlocks()
+		r.closeCursors()
 		if !committed {
 			_ = tx.Rollback()
 		}
 		r.currentTx = nil
+		r.releaseTxWriteLock()
 	}()
 
-	handlerVal, err := r.getValue(handlerHandle)
-	if err != nil {
-		return nil, err
-	}
-	if handlerVal.Kind != KindString {
-		return nil, fmt.Errorf("handler is not a string, kind=%d", handlerVal.Kind)
-	}
-	handlerFunc := r.instance.GetFunc(r.store, handlerVal.Str)
+	handlerFunc := r.instance.GetFunc(r.store, handlerName)
 	if handlerFunc == nil {
-		return nil, fmt.Errorf("handler function not found: %s", handlerVal.Str)
+		return nil, fmt.Errorf("handler function not found: %s", handlerName)
 	}
 
 	result, err := handlerFunc.Call(r.store, reqObj)
@@ -2135,6 +2371,27 @@ func (r *Runtime) invokeRouteHandler(server *HTTPServer, path string, method str
 		}
 	}
 
+	streamFunc := ""
+	if contentType == streamContentTypeMarker {
+		streamFnKey := r.newValue(Value{Kind: KindString, Str: "streamFn"})
+		fnHandle, err := r.objGet(resHandle, streamFnKey)
+		if err != nil {
+			return nil, err
+		}
+		fnVal, err := r.getValue(fnHandle)
+		if err != nil || fnVal.Kind != KindString {
+			return nil, errors.New("stream response has no function")
+		}
+		streamFunc = fnVal.Str
+		contentType = streamTextContentType
+		streamCTKey := r.newValue(Value{Kind: KindString, Str: "streamContentType"})
+		if ctHandle, err := r.objGet(resHandle, streamCTKey); err == nil {
+			if ctVal, err := r.getValue(ctHandle); err == nil && ctVal.Kind == KindString {
+				contentType = ctVal.Str
+			}
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("transaction commit error: %w", err)
 	}
@@ -2149,9 +2406,40 @@ func (r *Runtime) invokeRouteHandler(server *HTTPServer, path string, method str
 		ContentType: contentType,
 		StatusCode:  http.StatusOK,
 		RedirectURL: redirectURL,
+		StreamFunc:  streamFunc,
 	}, nil
 }
 
+// callFunctionValue は関数値（エクスポート名）を args で1回呼び出す。関数が error を返した場合は
+// そのメッセージを Go の error として返す。トランザクションは張らないため SQL は1文ごとに自動コミットになる。
+// 呼び出し側はこの Runtime を排他的に確保していること。
+func (r *Runtime) callFunctionValue(fnName string, args ...*Value) error {
+	if r.instance == nil || r.store == nil {
+		return errors.New("no instance")
+	}
+	caller := r.instance.GetFunc(r.store, "__call_fn_host")
+	if caller == nil {
+		return errors.New("__call_fn_host not found")
+	}
+	fn := r.newValue(Value{Kind: KindString, Str: fnName})
+	argsArr := r.newValue(Value{Kind: KindArray, Arr: &Array{Elems: args}})
+	result, err := caller.Call(r.store, fn, argsArr)
+	if err != nil {
+		r.abortTxBlocks()
+		r.closeCursors()
+		return err
+	}
+	if resHandle, ok := result.(*Value); ok && resHandle != nil {
+		if msg, isErr, err := r.resultErrorMessage(resHandle); err != nil {
+			return err
+		} else if isErr {
+			return errors.New(msg)
+		}
+	}
+	r.maybeStoreGC(false)
+	return nil
+}
+
 // StartPendingServer starts the HTTP server if one was registered via http_listen
 //
 // この関数はWASM実行が完全に終了した後にrunner.goから呼び出される。
@@ -2159,6 +2447,9 @@ func (r *Runtime) invokeRouteHandler(server *HTTPServer, path string, method str
 // コールスタックがクリアな状態であることが保証される。
 //
 // 重要: WASM実行中にこの関数を呼び出すと、スタックオーバーフローが発生する可能性がある。
+//
+// SIGTERM / SIGINT を受け取ると新規接続の受け付けを止め、処理中のハンドラーを
+// shutdown_grace_ms まで待ってから DB を閉じて nil を返す（CLI は exit 0 で終了する）。
 func (r *Runtime) StartPendingServer() error {
 	if r.pendingServer == nil {
 		return nil
@@ -2166,8 +2457,70 @@ func (r *Runtime) StartPendingServer() error {
 
 	server := r.pendingServer.server
 	port := r.pendingServer.port
+	options := r.pendingServer.options
+
+	server.mux.HandleFunc("/", r.routeHandler(server, options))
+
+	httpServer := &http.Server{
+		Addr:         port,
+		Handler:      server.mux,
+		ReadTimeout:  options.readTimeout,
+		WriteTimeout: options.writeTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	streamsCtx, cancelStreams := context.WithCancel(context.Background())
+	defer cancelStreams()
+	r.streamsCtx = streamsCtx
+
+	// Flush accumulated output to stdout before blocking on ListenAndServe
+	fmt.Print(r.output.String())
+	r.output.Reset()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	grace := options.shutdownGrace
+	if grace <= 0 {
+		grace = defaultShutdownGrace
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	// Shutdown は新規接続を止め、処理中のハンドラーが返るまで猶予の間だけ待つ。
+	shutdownErr := httpServer.Shutdown(shutdownCtx)
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// 猶予内に終わらなかったストリームは打ち切り、残りのハンドラーは終わるまで待ってから DB を閉じる。
+	// WASM の実行は途中で止められないため、ハンドラーが DB を使っている間に閉じないようにする。
+	cancelStreams()
+	r.drainWorkers()
+	if err := r.closeDB(); err != nil {
+		return err
+	}
+	if shutdownErr != nil {
+		return fmt.Errorf("http server shutdown: %w", shutdownErr)
+	}
+	return nil
+}
+
+// routeHandler は add_route で登録したルートへリクエストを振り分ける http.HandlerFunc を返す。
+func (r *Runtime) routeHandler(server *HTTPServer, options serverOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if options.maxBodyBytes > 0 {
+			req.Body = http.MaxBytesReader(w, req.Body, options.maxBodyBytes)
+		}
 
-	server.mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		query := make(map[string]string)
 		for key, values := range req.URL.Query() {
 			if len(values) > 0 {
@@ -2177,7 +2530,13 @@ func (r *Runtime) StartPendingServer() error {
 
 		form := make(map[string]string)
 		if req.Method == "POST" {
-			_ = req.ParseForm()
+			if err := req.ParseForm(); err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					http.Error(w, "Payload Too Large", http.StatusRequestEntityTooLarge)
+					return
+				}
+			}
 			for key, values := range req.PostForm {
 				if len(values) > 0 {
 					form[key] = values[0]
@@ -2199,16 +2558,37 @@ func (r *Runtime) StartPendingServer() error {
 			http.Redirect(w, req, response.RedirectURL, http.StatusFound)
 			return
 		}
+		if response.StreamFunc != "" {
+			r.serveStream(w, req, response)
+			return
+		}
 
 		w.Header().Set("Content-Type", response.ContentType)
 		w.WriteHeader(response.StatusCode)
 		_, _ = w.Write([]byte(response.Body))
-	})
+	}
+}
 
-	// Flush accumulated output to stdout before blocking on ListenAndServe
-	fmt.Print(r.output.String())
-	r.output.Reset()
-	return http.ListenAndServe(port, server.mux)
+// closeDB はシャットダウン時に共有DBを閉じる。ワーカーは同じ *sql.DB を参照しているため親からのみ呼ぶ。
+func (r *Runtime) closeDB() error {
+	if r.db == nil {
+		return nil
+	}
+	err := r.db.Close()
+	r.db = nil
+	if r.pool != nil {
+		for _, w := range r.pool.workers {
+			w.db = nil
+		}
+	}
+	if r.streamPool != nil {
+		r.streamPool.mu.Lock()
+		for _, w := range r.streamPool.workers {
+			w.db = nil
+		}
+		r.streamPool.mu.Unlock()
+	}
+	return err
 }
 
 // httpResponseText creates a text response (from raw memory)
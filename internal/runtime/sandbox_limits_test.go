@@ -0,0 +1,109 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"tuna/internal/compiler"
+)
+
+func TestRunnerLimits(t *testing.T) {
+	loop := `
+import { range, reduce } from "array"
+
+export function main(): void {
+  reduce(range(0, 20000), function (acc: i64, i: i64): i64 {
+    return acc + reduce(range(0, 20000), function (a: i64, b: i64): i64 { return a + b }, 0)
+  }, 0)
+}
+`
+	alloc := `
+import { log } from "prelude"
+import { range, length } from "array"
+
+export function main(): void {
+  log(length(range(0, 50000000)))
+}
+`
+	chatty := `
+import { log } from "prelude"
+import { range, map } from "array"
+
+export function main(): void {
+  map(range(0, 1000), function (i: i64): i64 {
+    log("line")
+    return i
+  })
+}
+`
+	cases := []struct {
+		name   string
+		src    string
+		limits Limits
+		want   string
+	}{
+		{"fuel", loop, Limits{MaxFuel: 1000000}, "fuel"},
+		{"timeout", loop, Limits{Timeout: 100 * time.Millisecond}, "timeout"},
+		{"memory", alloc, Limits{MaxMemoryBytes: 16 << 20}, "memory"},
+		{"output", chatty, Limits{MaxOutputBytes: 100}, "output"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			wasm := compileForTarget(t, tc.src, compiler.TargetTuna)
+			runner := NewRunner()
+			if err := runner.SetLimits(tc.limits); err != nil {
+				t.Fatal(err)
+			}
+			out, err := runner.RunWithArgs(wasm, nil)
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != tc.want {
+				t.Fatalf("expected %s limit error, got %v", tc.want, err)
+			}
+			if tc.want == "output" && len(out) > 100 {
+				t.Fatalf("output exceeds the limit: %d bytes", len(out))
+			}
+		})
+	}
+}
+
+func TestRunSandboxWithReportsLimit(t *testing.T) {
+	src := `
+import { log } from "prelude"
+import { run_sandbox_with, type SandboxResult, type SandboxError } from "runtime"
+
+function show(result: SandboxResult | SandboxError): void {
+  switch (result) {
+    case e as SandboxError: {
+      log(e.limit)
+      log(e.message)
+    }
+    case v as SandboxResult: log("ok: " + v.stdout)
+  }
+}
+
+const hello: string = "import { log } from \"prelude\"\nexport function main(): void { log(\"hi\") }"
+
+export function main(): void {
+  show(run_sandbox_with(hello, { max_fuel: 0, timeout_ms: 0, max_memory_bytes: 0, max_output_bytes: 0 }))
+  show(run_sandbox_with(hello, { max_fuel: 100, timeout_ms: 0, max_memory_bytes: 0, max_output_bytes: 0 }))
+  show(run_sandbox_with(hello, { max_fuel: 0, timeout_ms: 0, max_memory_bytes: 0, max_output_bytes: 2 }))
+  show(run_sandbox_with("export function main(): void { x }", { max_fuel: 0, timeout_ms: 0, max_memory_bytes: 0, max_output_bytes: 0 }))
+}
+`
+	wasm := compileForTarget(t, src, compiler.TargetTuna)
+	out, err := NewRunner().RunWithArgs(wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	want := "ok: hi\n\n" +
+		"fuel\nfuel limit exceeded (100)\n" +
+		"output\noutput limit exceeded (2 bytes)\n" +
+		"undefined\n1:32: undefined: x\n"
+	if out != want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
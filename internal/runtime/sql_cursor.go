@@ -0,0 +1,242 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go/v41"
+)
+
+// for (... of fetch_iter { ... }) のカーソル。行は sql_cursor_next のたびに *sql.Rows から1行ずつ読み、
+// ループを抜けるとき（最後まで読んだとき、return / ? で抜けたとき）にジェネレーターが sql_cursor_close を呼ぶ。
+// トラップで抜けた場合は closeCursors が残りを閉じる。
+//
+// :memory: のデフォルト接続は1接続に固定されているため、トランザクション外でカーソルを開いている間は
+// 接続を pin し、ループ本体のクエリやトランザクションも同じ接続で実行する（読み込み中の接続を待ち続けないため）。
+
+type sqlCursor struct {
+	rows        *sql.Rows
+	cols        []string
+	values      []interface{}
+	valuePtrs   []interface{}
+	columnTypes map[string]sqlColumnType
+	conn        *namedConn // nil ならデフォルト接続
+	pinned      bool       // 開くときに conn を pin した
+	// --sql-trace 用（閉じるときに読んだ行数とともに記録する）
+	query   string
+	params  []interface{}
+	started time.Time
+	count   int64
+}
+
+// pinnedConn はカーソルが開いている間 pin した接続。refs は pin しているカーソルの数。
+type pinnedConn struct {
+	conn *sql.Conn
+	refs int
+}
+
+// connExecutor は pin した *sql.Conn を dbExecutor として使う。
+type connExecutor struct {
+	conn *sql.Conn
+}
+
+func (c connExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(context.Background(), query, args...)
+}
+
+func (c connExecutor) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(context.Background(), query, args...)
+}
+
+func (c connExecutor) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(context.Background(), query, args...)
+}
+
+// pinnedExecutor は conn（nil ならデフォルト接続）が pin されていればその接続を返す。
+func (r *Runtime) pinnedExecutor(conn *namedConn) (dbExecutor, bool) {
+	p, ok := r.pins[conn]
+	if !ok {
+		return nil, false
+	}
+	return connExecutor{conn: p.conn}, true
+}
+
+func (r *Runtime) pin(conn *namedConn) (*sql.Conn, error) {
+	if p, ok := r.pins[conn]; ok {
+		p.refs++
+		return p.conn, nil
+	}
+	db := r.db
+	if conn != nil {
+		db = conn.db
+	}
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if r.pins == nil {
+		r.pins = make(map[*namedConn]*pinnedConn)
+	}
+	r.pins[conn] = &pinnedConn{conn: c, refs: 1}
+	return c, nil
+}
+
+func (r *Runtime) unpin(conn *namedConn) {
+	p, ok := r.pins[conn]
+	if !ok {
+		return
+	}
+	p.refs--
+	if p.refs > 0 {
+		return
+	}
+	delete(r.pins, conn)
+	// pin した接続で始めたトランザクションは、カーソルより先に（ブロックを抜けるときに）終わっている
+	p.conn.Close()
+}
+
+func (r *Runtime) sqlCursorOpen(caller *wasmtime.Caller, ptr int32, length int32, paramsHandle *Value, typesPtr int32, typesLen int32, connHandle *Value) (*Value, error) {
+	conn, err := r.resolveConn(connHandle)
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil && r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+	columnTypes, err := readSQLColumnTypes(caller, typesPtr, typesLen)
+	if err != nil {
+		return nil, err
+	}
+	query, _, err := r.readSQLText(caller, ptr, length)
+	if err != nil {
+		return nil, err
+	}
+	params, err := r.extractSQLParams(paramsHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := &sqlCursor{conn: conn, columnTypes: columnTypes, query: query, params: params, started: time.Now()}
+	var rows *sql.Rows
+	if conn == nil && r.currentTx != nil {
+		r.lockTxUpfront()
+		rows, err = r.currentTx.Query(query, params...)
+	} else {
+		var c *sql.Conn
+		c, err = r.pin(conn)
+		if err != nil {
+			return nil, fmt.Errorf("sql query error: %w", err)
+		}
+		cur.pinned = true
+		rows, err = c.QueryContext(context.Background(), query, params...)
+	}
+	if err != nil {
+		if cur.pinned {
+			r.unpin(conn)
+		}
+		r.traceSQL(query, params, cur.started, 0, err)
+		return nil, fmt.Errorf("sql query error: %w", err)
+	}
+	cur.rows = rows
+	cur.cols, err = rows.Columns()
+	if err != nil {
+		r.finishCursor(cur, err)
+		return nil, fmt.Errorf("sql columns error: %w", err)
+	}
+	cur.values = make([]interface{}, len(cur.cols))
+	cur.valuePtrs = make([]interface{}, len(cur.cols))
+	for i := range cur.values {
+		cur.valuePtrs[i] = &cur.values[i]
+	}
+
+	if r.cursors == nil {
+		r.cursors = make(map[int64]*sqlCursor)
+	}
+	r.nextCursorID++
+	id := r.nextCursorID
+	r.cursors[id] = cur
+	return r.newValue(Value{Kind: KindI64, I64: id}), nil
+}
+
+// sqlCursorNext は次の行を返す。最後の行の後は nil（null）を返す。
+func (r *Runtime) sqlCursorNext(cursorHandle *Value) (*Value, error) {
+	_, cur, err := r.lookupCursor(cursorHandle)
+	if err != nil {
+		return nil, err
+	}
+	if cur.rows == nil {
+		return nil, nil
+	}
+	if !cur.rows.Next() {
+		if err := cur.rows.Err(); err != nil {
+			return nil, fmt.Errorf("sql rows error: %w", err)
+		}
+		// 読み終えた時点で接続を解放する（sql_cursor_close は後で呼ばれる）
+		r.finishCursor(cur, nil)
+		return nil, nil
+	}
+	if err := cur.rows.Scan(cur.valuePtrs...); err != nil {
+		return nil, fmt.Errorf("sql scan error: %w", err)
+	}
+	cur.count++
+	row, err := r.sqlRowObject(cur.cols, cur.values, cur.columnTypes)
+	if err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func (r *Runtime) sqlCursorClose(cursorHandle *Value) {
+	id, cur, err := r.lookupCursor(cursorHandle)
+	if err != nil {
+		return
+	}
+	delete(r.cursors, id)
+	r.finishCursor(cur, nil)
+}
+
+func (r *Runtime) lookupCursor(cursorHandle *Value) (int64, *sqlCursor, error) {
+	val, err := r.getValue(cursorHandle)
+	if err != nil {
+		return 0, nil, err
+	}
+	if val.Kind != KindI64 {
+		return 0, nil, errors.New("invalid sql cursor")
+	}
+	cur, ok := r.cursors[val.I64]
+	if !ok {
+		return 0, nil, errors.New("sql cursor is closed")
+	}
+	return val.I64, cur, nil
+}
+
+// finishCursor は行を閉じて pin を外し、クエリを記録する。2回目以降は何もしない。
+func (r *Runtime) finishCursor(cur *sqlCursor, err error) {
+	if cur.rows == nil {
+		return
+	}
+	if closeErr := cur.rows.Close(); err == nil {
+		err = closeErr
+	}
+	cur.rows = nil
+	if cur.pinned {
+		r.unpin(cur.conn)
+		cur.pinned = false
+	}
+	r.traceSQL(cur.query, cur.params, cur.started, cur.count, err)
+}
+
+// closeCursors はトラップなどで閉じられなかったカーソルをすべて閉じる。
+// pin した接続のトランザクションを先に終わらせるため、abortTxBlocks の後に呼ぶ。
+func (r *Runtime) closeCursors() {
+	for id, cur := range r.cursors {
+		delete(r.cursors, id)
+		r.finishCursor(cur, nil)
+	}
+}
@@ -0,0 +1,140 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFetchIterClosesCursorsOnEarlyExitAndTrap(t *testing.T) {
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { log, to_string } from "prelude"
+
+create_table notes {
+  id INTEGER PRIMARY KEY,
+  body TEXT NOT NULL
+}
+
+create_table copies {
+  id INTEGER PRIMARY KEY
+}
+
+function first_two(): undefined | error {
+  for (const note of fetch_iter { SELECT id, body FROM notes ORDER BY id }) {
+    transaction {
+      execute { INSERT INTO copies (id) VALUES ({note.id}) }?
+    }?
+    if (note.id == 2) {
+      return undefined
+    }
+  }
+  return undefined
+}
+
+function divide(): undefined | error {
+  for (const note of fetch_iter { SELECT id FROM notes }) {
+    const copied = fetch_one { SELECT COUNT(*) AS n FROM copies }?
+    log(to_string(10 / (copied.n - 2)))
+  }
+  return undefined
+}
+
+export function main(): void | error {
+  execute { INSERT INTO notes (id, body) VALUES (1, 'a'), (2, 'b'), (3, 'c') }?
+  first_two()?
+  divide()?
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	res := compileHostProgram(t, entry)
+	rt, err := NewRunner().runWithArgs(res.Wasm, nil)
+	if err == nil {
+		t.Fatalf("expected a trap")
+	}
+	if len(rt.cursors) != 0 || len(rt.pins) != 0 {
+		t.Fatalf("cursors were not closed after the trap: %d cursors, %d pins", len(rt.cursors), len(rt.pins))
+	}
+
+	// :memory: は1接続なので、カーソルが接続を保持したままならここで待ち続ける
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var copies int
+	if err := rt.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM copies").Scan(&copies); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if copies != 2 {
+		t.Fatalf("expected 2 copied rows, got %d", copies)
+	}
+}
+
+// ハンドラーがトラップしても、名前付き接続のカーソルと pin した接続が残らない。
+func TestRouteHandlerTrapClosesCursors(t *testing.T) {
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { log, to_string } from "prelude"
+import { create_server, add_route, response_text, type Request, type Response } from "http"
+import { db_connect } from "sqlite"
+
+create_table archive.entries {
+  id INTEGER PRIMARY KEY
+}
+
+function handle_divide(req: Request): Response | error {
+  const archive = { name: "archive" }
+  for (const entry of fetch_iter(archive) { SELECT id FROM entries ORDER BY id }) {
+    log(to_string(10 / (entry.id - 1)))
+  }
+  return response_text("ok")
+}
+
+export function main(): void | error {
+  const archive = db_connect("archive", ":memory:")?
+  execute(archive) { INSERT INTO entries (id) VALUES (1), (2) }?
+  const server = create_server()
+  add_route(server, "get", "/divide", handle_divide)
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	res := compileHostProgram(t, entry)
+	rt, err := NewRunner().runWithArgs(res.Wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var server *HTTPServer
+	for _, s := range rt.httpServers {
+		server = s
+	}
+	if _, err := rt.invokeRouteHandler(server, "/divide", "GET", map[string]string{}, map[string]string{}); err == nil {
+		t.Fatalf("expected a trap")
+	}
+	if len(rt.cursors) != 0 || len(rt.pins) != 0 {
+		t.Fatalf("cursors were not closed after the trap: %d cursors, %d pins", len(rt.cursors), len(rt.pins))
+	}
+
+	// :memory: は1接続なので、カーソルが接続を保持したままならここで待ち続ける
+	conn, ok := rt.conns.get("archive")
+	if !ok {
+		t.Fatal("archive connection is not open")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var entries int
+	if err := conn.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM entries").Scan(&entries); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if entries != 2 {
+		t.Fatalf("expected 2 entries, got %d", entries)
+	}
+}
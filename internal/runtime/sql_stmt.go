@@ -0,0 +1,183 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go/v41"
+)
+
+// SQL ブロックのクエリはジェネレーターがデータセグメントに埋め込んだ静的な文字列なので、
+// 接続ごとに一度だけ prepare して使い回す。トランザクション中は tx.Stmt で
+// トランザクションに束縛した文を使い、コミット/ロールバックで自動的に閉じられる。
+
+// stmtCache は1つの *sql.DB に対する prepare 済みの文（クエリ文字列 -> 文）。
+// デフォルト接続のキャッシュは親と全ワーカーで共有する。
+type stmtCache struct {
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+func newStmtCache() *stmtCache {
+	return &stmtCache{stmts: make(map[string]*sql.Stmt)}
+}
+
+func (c *stmtCache) get(query string) *sql.Stmt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stmts[query]
+}
+
+func (c *stmtCache) prepare(db *sql.DB, query string) (*sql.Stmt, error) {
+	if stmt := c.get(query); stmt != nil {
+		return stmt, nil
+	}
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev, ok := c.stmts[query]; ok {
+		// 別のワーカーが先に prepare した
+		stmt.Close()
+		return prev, nil
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+// readSQLText は線形メモリから SQL 文字列を読む。static はデータセグメント内の文字列
+// （インスタンス生成時のメモリサイズより下にある文字列）であることを表し、
+// 静的な文字列は (ptr, len) ごとにキャッシュして再読み込みしない。
+func (r *Runtime) readSQLText(caller *wasmtime.Caller, ptr int32, length int32) (query string, static bool, err error) {
+	start := int(ptr)
+	end := start + int(length)
+	static = start >= 0 && end <= r.staticLimit
+	key := [2]int32{ptr, length}
+	if static {
+		if q, ok := r.sqlTexts[key]; ok {
+			return q, true, nil
+		}
+	}
+	ext := caller.GetExport("memory")
+	if ext == nil {
+		return "", false, errors.New("memory not found")
+	}
+	memory := ext.Memory()
+	if memory == nil {
+		return "", false, errors.New("memory not found")
+	}
+	data := memory.UnsafeData(caller)
+	if start < 0 || end > len(data) {
+		return "", false, errors.New("sql string out of bounds")
+	}
+	query = string(data[start:end])
+	if static {
+		if r.sqlTexts == nil {
+			r.sqlTexts = make(map[[2]int32]string)
+		}
+		r.sqlTexts[key] = query
+	}
+	return query, static, nil
+}
+
+// defaultStmt はデフォルト接続で query の prepare 済みの文を返す。
+// トランザクション中はそのトランザクションに束縛した文を返す。
+func (r *Runtime) defaultStmt(query string) (*sql.Stmt, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+	if r.stmts == nil {
+		r.stmts = newStmtCache()
+	}
+	if r.currentTx == nil {
+		return r.stmts.prepare(r.db, query)
+	}
+	r.lockTxUpfront()
+	if r.txStmtsOf != r.currentTx {
+		r.txStmts = make(map[string]*sql.Stmt)
+		r.txStmtsOf = r.currentTx
+	}
+	if stmt, ok := r.txStmts[query]; ok {
+		return stmt, nil
+	}
+	var stmt *sql.Stmt
+	if base := r.stmts.get(query); base != nil {
+		stmt = r.currentTx.Stmt(base)
+	} else {
+		// :memory: は1接続に固定されており、トランザクション中は r.db で prepare できない
+		var err error
+		stmt, err = r.currentTx.Prepare(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.txStmts[query] = stmt
+	return stmt, nil
+}
+
+// sqlTracer は --sql-trace / --sql-slow のクエリログ。親と全ワーカーで共有する。
+type sqlTracer struct {
+	mu   sync.Mutex
+	out  io.Writer
+	all  bool          // 全クエリを記録する（--sql-trace）
+	slow time.Duration // これ以上かかったクエリを警告する（0 なら警告しない）
+}
+
+// traceSQL は実行したクエリをパラメーター、行数（SELECT は読んだ行数、書き込みは影響した行数）、
+// 所要時間とともに記録する。
+func (r *Runtime) traceSQL(query string, params []interface{}, started time.Time, rows int64, err error) {
+	t := r.sqlTrace
+	if t == nil {
+		return
+	}
+	elapsed := time.Since(started)
+	slow := t.slow > 0 && elapsed >= t.slow
+	if !t.all && !slow {
+		return
+	}
+	var b strings.Builder
+	if slow {
+		fmt.Fprintf(&b, "sql: slow query (%s >= %s): ", formatSQLDuration(elapsed), t.slow)
+	} else {
+		fmt.Fprintf(&b, "sql: %s: ", formatSQLDuration(elapsed))
+	}
+	b.WriteString(strings.Join(strings.Fields(query), " "))
+	if len(params) > 0 {
+		b.WriteString(" params=[")
+		for i, p := range params {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if s, ok := p.(string); ok {
+				b.WriteString(strconv.Quote(s))
+			} else {
+				fmt.Fprint(&b, p)
+			}
+		}
+		b.WriteString("]")
+	}
+	if err != nil {
+		fmt.Fprintf(&b, " error=%q", err.Error())
+	} else {
+		fmt.Fprintf(&b, " rows=%d", rows)
+	}
+	b.WriteString("\n")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.out, b.String())
+}
+
+func formatSQLDuration(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64) + "ms"
+}
@@ -0,0 +1,94 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLBlocksReusePreparedStatements(t *testing.T) {
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { log } from "prelude"
+
+create_table items {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL
+}
+
+function add(name: string): void | error {
+  execute {
+    INSERT INTO items (name) VALUES ({name})
+  }?
+}
+
+export function main(): void | error {
+  add("a")?
+  add("b")?
+  const added = transaction {
+    add("c")?
+    add("d")?
+  }
+  added?
+  const rows = fetch_all {
+    SELECT name FROM items WHERE id > {2}
+  }?
+  for (const row of rows) {
+    log(row.name)
+  }
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	res := compileHostProgram(t, entry)
+	var trace bytes.Buffer
+	runner := NewRunner()
+	runner.sqlTrace = &sqlTracer{out: &trace, all: true}
+	rt, err := runner.runWithArgs(res.Wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := strings.TrimSpace(rt.Output()); got != "c\nd" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+	// INSERT はトランザクション外で1回だけ prepare され、トランザクション内ではそれを束縛して使う
+	if got := len(rt.stmts.stmts); got != 2 {
+		t.Fatalf("expected 2 prepared statements, got %d", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 traced queries, got %q", trace.String())
+	}
+	if !strings.HasSuffix(lines[2], `: INSERT INTO items (name) VALUES (?) params=["c"] rows=1`) {
+		t.Fatalf("unexpected trace line: %q", lines[2])
+	}
+	if !strings.HasSuffix(lines[4], `: SELECT name FROM items WHERE id > ? params=[2] rows=2`) {
+		t.Fatalf("unexpected trace line: %q", lines[4])
+	}
+}
+
+func TestSQLTraceWarnsSlowQueriesOnly(t *testing.T) {
+	var out bytes.Buffer
+	r := NewRuntime()
+	r.sqlTrace = &sqlTracer{out: &out, slow: time.Millisecond}
+	r.traceSQL("SELECT 1", nil, time.Now().Add(-time.Second), 1, nil)
+	if got := out.String(); !strings.HasPrefix(got, "sql: slow query (") || !strings.HasSuffix(got, "): SELECT 1 rows=1\n") {
+		t.Fatalf("unexpected slow query warning: %q", got)
+	}
+
+	out.Reset()
+	r.sqlTrace.slow = time.Hour
+	r.traceSQL("SELECT 1", nil, time.Now(), 1, nil)
+	if out.Len() != 0 {
+		t.Fatalf("fast query must not be logged without --sql-trace: %q", out.String())
+	}
+}
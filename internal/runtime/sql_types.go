@@ -0,0 +1,223 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bytecodealliance/wasmtime-go/v41"
+)
+
+// sqlColumnType はコンパイラが推論した列の型（`4:name:i64?` の1要素）。
+type sqlColumnType struct {
+	kind     Kind
+	nullable bool
+	// field は fetch_all<T> の T のフィールド名（列名は小文字なので宣言どおりの名前に戻す）。
+	// 空でなければ、その列が結果に無いときエラーにする。
+	field string
+}
+
+// sqlRowSchema は fetch_all<T> で渡される decode<T> のスキーマのうち、行の変換に使う部分。
+type sqlRowSchema struct {
+	Kind  string `json:"kind"`
+	Props []struct {
+		Name string       `json:"name"`
+		Type sqlRowSchema `json:"type"`
+	} `json:"props"`
+	Union []sqlRowSchema `json:"union"`
+}
+
+// parseSQLColumnTypes はコンパイラが渡す `4:name:i64,5:label:string?` 形式（列名の前にそのバイト長）の記述子、
+// または fetch_all<T> の decode スキーマ（JSON）を解析する。
+// 記述子に無い列は従来どおり文字列（NULL は空文字列）として返す。
+func parseSQLColumnTypes(desc string) map[string]sqlColumnType {
+	types := map[string]sqlColumnType{}
+	if desc == "" {
+		return types
+	}
+	if strings.HasPrefix(desc, "{") {
+		var schema sqlRowSchema
+		if err := json.Unmarshal([]byte(desc), &schema); err != nil {
+			return types
+		}
+		for _, prop := range schema.Props {
+			col := sqlColumnType{field: prop.Name}
+			t := prop.Type
+			if t.Kind == "union" {
+				for _, member := range t.Union {
+					if member.Kind == "null" {
+						col.nullable = true
+					} else {
+						t = member
+					}
+				}
+			}
+			switch t.Kind {
+			case "i64":
+				col.kind = KindI64
+			case "f64":
+				col.kind = KindF64
+			case "boolean":
+				col.kind = KindBool
+			default:
+				col.kind = KindString
+			}
+			types[strings.ToLower(prop.Name)] = col
+		}
+		return types
+	}
+	for desc != "" {
+		size, rest, ok := strings.Cut(desc, ":")
+		if !ok {
+			break
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 0 || n >= len(rest) || rest[n] != ':' {
+			break
+		}
+		name := rest[:n]
+		kind, next, _ := strings.Cut(rest[n+1:], ",")
+		desc = next
+		col := sqlColumnType{nullable: strings.HasSuffix(kind, "?")}
+		switch strings.TrimSuffix(kind, "?") {
+		case "i64":
+			col.kind = KindI64
+		case "f64":
+			col.kind = KindF64
+		default:
+			col.kind = KindString
+		}
+		types[name] = col
+	}
+	return types
+}
+
+func readSQLColumnTypes(caller *wasmtime.Caller, ptr int32, length int32) (map[string]sqlColumnType, error) {
+	if length == 0 {
+		return parseSQLColumnTypes(""), nil
+	}
+	ext := caller.GetExport("memory")
+	if ext == nil || ext.Memory() == nil {
+		return nil, errors.New("memory not found")
+	}
+	data := ext.Memory().UnsafeData(caller)
+	start := int(ptr)
+	end := start + int(length)
+	if start < 0 || end > len(data) {
+		return nil, errors.New("sql column types out of bounds")
+	}
+	return parseSQLColumnTypes(string(data[start:end])), nil
+}
+
+// sqlRowObject は1行分の値を列名をキーにしたオブジェクトにする。
+func (r *Runtime) sqlRowObject(cols []string, values []interface{}, types map[string]sqlColumnType) (*Value, error) {
+	rowObj := r.newValue(Value{Kind: KindObject, Obj: &Object{Order: []string{}, Props: map[string]*Value{}}})
+	seen := map[string]bool{}
+	for i, v := range values {
+		colName := strings.ToLower(cols[i])
+		value, err := sqlColumnValue(colName, v, types)
+		if err != nil {
+			return nil, err
+		}
+		key := colName
+		if col, ok := types[colName]; ok && col.field != "" {
+			key = col.field
+		}
+		seen[colName] = true
+		keyHandle := r.newValue(Value{Kind: KindString, Str: key})
+		if err := r.objSet(rowObj, keyHandle, r.newValue(value)); err != nil {
+			return nil, err
+		}
+	}
+	for name, col := range types {
+		if col.field != "" && !seen[name] {
+			return nil, fmt.Errorf("sql column %s is missing for field %s", name, col.field)
+		}
+	}
+	return rowObj, nil
+}
+
+func sqlColumnValue(name string, v interface{}, types map[string]sqlColumnType) (Value, error) {
+	col, ok := types[name]
+	if !ok {
+		return Value{Kind: KindString, Str: sqlValueString(v)}, nil
+	}
+	if v == nil {
+		if col.nullable {
+			return Value{Kind: KindNull}, nil
+		}
+		return Value{}, fmt.Errorf("sql column %s is NULL", name)
+	}
+	switch col.kind {
+	case KindI64:
+		switch n := v.(type) {
+		case int64:
+			return Value{Kind: KindI64, I64: n}, nil
+		case float64:
+			// 切り捨てると値が変わるので、整数でない REAL や i64 に収まらない値はエラーにする
+			if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+				return Value{}, fmt.Errorf("sql column %s: %v is not an integer", name, n)
+			}
+			return Value{Kind: KindI64, I64: int64(n)}, nil
+		case bool:
+			if n {
+				return Value{Kind: KindI64, I64: 1}, nil
+			}
+			return Value{Kind: KindI64, I64: 0}, nil
+		}
+		parsed, err := strconv.ParseInt(strings.TrimSpace(sqlValueString(v)), 10, 64)
+		if err != nil {
+			return Value{}, fmt.Errorf("sql column %s: %q is not an integer", name, sqlValueString(v))
+		}
+		return Value{Kind: KindI64, I64: parsed}, nil
+	case KindBool:
+		switch n := v.(type) {
+		case bool:
+			return Value{Kind: KindBool, Bool: n}, nil
+		case int64:
+			if n == 0 || n == 1 {
+				return Value{Kind: KindBool, Bool: n == 1}, nil
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(sqlValueString(v))) {
+		case "1", "true":
+			return Value{Kind: KindBool, Bool: true}, nil
+		case "0", "false":
+			return Value{Kind: KindBool, Bool: false}, nil
+		}
+		return Value{}, fmt.Errorf("sql column %s: %q is not a boolean (0 or 1)", name, sqlValueString(v))
+	case KindF64:
+		switch n := v.(type) {
+		case float64:
+			return Value{Kind: KindF64, F64: n}, nil
+		case int64:
+			return Value{Kind: KindF64, F64: float64(n)}, nil
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(sqlValueString(v)), 64)
+		if err != nil {
+			return Value{}, fmt.Errorf("sql column %s: %q is not a number", name, sqlValueString(v))
+		}
+		return Value{Kind: KindF64, F64: parsed}, nil
+	default:
+		return Value{Kind: KindString, Str: sqlValueString(v)}, nil
+	}
+}
+
+func sqlValueString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSQLColumnValueRejectsLossyValues(t *testing.T) {
+	types := map[string]sqlColumnType{
+		"n":     {kind: KindI64},
+		"label": {kind: KindString},
+		"note":  {kind: KindString, nullable: true},
+	}
+	if v, err := sqlColumnValue("n", 3.0, types); err != nil || v.Kind != KindI64 || v.I64 != 3 {
+		t.Fatalf("integral REAL: got %+v, %v", v, err)
+	}
+	if v, err := sqlColumnValue("note", nil, types); err != nil || v.Kind != KindNull {
+		t.Fatalf("nullable NULL: got %+v, %v", v, err)
+	}
+	cases := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"n", 2.5, "2.5 is not an integer"},
+		{"n", 1e19, "is not an integer"},
+		{"label", nil, "sql column label is NULL"},
+	}
+	for _, tc := range cases {
+		_, err := sqlColumnValue(tc.name, tc.value, types)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s=%v: got %v, want error containing %q", tc.name, tc.value, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// httpServeStatic は serve_static 用。prefix 以下のリクエストを静的ファイルとして配信するハンドラーを
+// サーバーの mux に登録する。source はディレクトリパス（string）か、`import ... as dir` で
+// 埋め込んだバンドル（相対パス -> 内容 のオブジェクト）。
+func (r *Runtime) httpServeStatic(serverHandle *Value, prefixHandle *Value, sourceHandle *Value) error {
+	r.httpMu.Lock()
+	defer r.httpMu.Unlock()
+
+	serverVal, err := r.getValue(serverHandle)
+	if err != nil || serverVal.Kind != KindI64 {
+		return errors.New("invalid server handle")
+	}
+	server, ok := r.httpServers[serverVal.I64]
+	if !ok {
+		return errors.New("invalid server handle")
+	}
+
+	prefixVal, err := r.getValue(prefixHandle)
+	if err != nil {
+		return err
+	}
+	if prefixVal.Kind != KindString {
+		return errors.New("serve_static: prefix must be string")
+	}
+	prefix := "/" + strings.Trim(prefixVal.Str, "/")
+	if prefix == "/" {
+		return errors.New("serve_static: prefix must not be /")
+	}
+
+	sourceVal, err := r.getValue(sourceHandle)
+	if err != nil {
+		return err
+	}
+	var handler http.Handler
+	switch sourceVal.Kind {
+	case KindString:
+		handler = staticDirHandler(http.Dir(sourceVal.Str))
+	case KindObject:
+		files := make(map[string]string, len(sourceVal.Obj.Props))
+		for name, v := range sourceVal.Obj.Props {
+			if v.Kind != KindString {
+				return fmt.Errorf("serve_static: embedded file %s is not string", name)
+			}
+			files[name] = v.Str
+		}
+		// 埋め込みバンドルには更新時刻が無いため、登録時刻を Last-Modified として使う。
+		handler = staticBundleHandler(files, time.Now())
+	default:
+		return errors.New("serve_static: source must be a directory path or an embedded bundle")
+	}
+
+	server.mux.Handle(prefix+"/", http.StripPrefix(prefix, handler))
+	return nil
+}
+
+func staticDirHandler(root http.FileSystem) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !allowStaticMethod(w, req) {
+			return
+		}
+		f, err := root.Open(path.Clean("/" + req.URL.Path))
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, req)
+			return
+		}
+		// 更新時刻とサイズから弱いETagを作る（内容を読まずに済む）。
+		w.Header().Set("ETag", fmt.Sprintf(`W/"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+		http.ServeContent(w, req, info.Name(), info.ModTime(), f)
+	})
+}
+
+func staticBundleHandler(files map[string]string, modTime time.Time) http.Handler {
+	etags := make(map[string]string, len(files))
+	for name, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		etags[name] = `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !allowStaticMethod(w, req) {
+			return
+		}
+		name := strings.TrimPrefix(path.Clean("/"+req.URL.Path), "/")
+		content, ok := files[name]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("ETag", etags[name])
+		http.ServeContent(w, req, path.Base(name), modTime, strings.NewReader(content))
+	})
+}
+
+func allowStaticMethod(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	return false
+}
@@ -0,0 +1,110 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tuna/internal/compiler"
+)
+
+func TestServeStaticFromDirectoryAndEmbeddedBundle(t *testing.T) {
+	dir := t.TempDir()
+	public := filepath.Join(dir, "public")
+	if err := os.MkdirAll(filepath.Join(public, "css"), 0755); err != nil {
+		t.Fatalf("failed to create public dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(public, "css", "style.css"), []byte("body { color: red; }"), 0644); err != nil {
+		t.Fatalf("failed to write css: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(public, ".hidden"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("failed to write hidden file: %v", err)
+	}
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { create_server, serve_static } from "http"
+import assets from "./public/" as dir
+
+export function main(): void {
+  const server = create_server()
+  serve_static(server, "/files", "` + filepath.ToSlash(public) + `")
+  serve_static(server, "/assets", assets)
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	comp := compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		t.Fatalf("set backend failed: %v", err)
+	}
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	rt, err := NewRunner().runWithArgs(res.Wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var server *HTTPServer
+	for _, s := range rt.httpServers {
+		server = s
+	}
+	if server == nil {
+		t.Fatal("server not found")
+	}
+
+	serve := func(method, target string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		server.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, prefix := range []string{"/files", "/assets"} {
+		rec := serve("GET", prefix+"/css/style.css", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", prefix, rec.Code)
+		}
+		if got := rec.Body.String(); got != "body { color: red; }" {
+			t.Fatalf("%s: unexpected body %q", prefix, got)
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+			t.Fatalf("%s: unexpected content type %q", prefix, ct)
+		}
+		etag := rec.Header().Get("ETag")
+		if etag == "" || rec.Header().Get("Last-Modified") == "" {
+			t.Fatalf("%s: expected ETag and Last-Modified, got %v", prefix, rec.Header())
+		}
+
+		if rec := serve("GET", prefix+"/css/style.css", map[string]string{"If-None-Match": etag}); rec.Code != http.StatusNotModified {
+			t.Fatalf("%s: expected 304 for matching ETag, got %d", prefix, rec.Code)
+		}
+
+		rec = serve("GET", prefix+"/css/style.css", map[string]string{"Range": "bytes=0-3"})
+		if rec.Code != http.StatusPartialContent || rec.Body.String() != "body" {
+			t.Fatalf("%s: unexpected range response %d %q", prefix, rec.Code, rec.Body.String())
+		}
+
+		if rec := serve("GET", prefix+"/missing.css", nil); rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", prefix, rec.Code)
+		}
+		if rec := serve("POST", prefix+"/css/style.css", nil); rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s: expected 405, got %d", prefix, rec.Code)
+		}
+	}
+
+	if rec := serve("GET", "/assets/.hidden", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected dotfiles to be excluded from the bundle, got %d", rec.Code)
+	}
+}
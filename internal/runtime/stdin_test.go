@@ -0,0 +1,99 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"strings"
+	"testing"
+
+	"tuna/internal/compiler"
+)
+
+func TestReadLineAndReadStdin(t *testing.T) {
+	src := `
+import { log } from "prelude"
+import { read_line, read_stdin } from "io"
+
+function show(line: string | undefined): void {
+  switch (line) {
+    case line as undefined: log("<eof>")
+    case line as string: log("[" + line + "]")
+  }
+}
+
+export function main(): error | void {
+  show(read_line()?)
+  show(read_line()?)
+  show(read_line()?)
+  log("rest=" + read_stdin()?)
+  show(read_line()?)
+}
+`
+	input := "first\r\nsecond\n\n" + strings.Repeat("x", 5000) + "\nlast"
+	want := "[first]\n[second]\n[]\nrest=" + strings.Repeat("x", 5000) + "\nlast\n<eof>\n"
+	for _, target := range []compiler.Target{compiler.TargetTuna, compiler.TargetWASI} {
+		wasm := compileForTarget(t, src, target)
+		runner := NewRunner()
+		runner.SetStdin(strings.NewReader(input))
+		out, err := runner.RunWithArgs(wasm, nil)
+		if err != nil {
+			t.Fatalf("%s: run failed: %v", target, err)
+		}
+		if out != want {
+			t.Fatalf("%s: unexpected output: %q", target, out)
+		}
+	}
+}
+
+func TestReadStdinRejectsInvalidUTF8(t *testing.T) {
+	src := `
+import { log } from "prelude"
+import { read_stdin } from "io"
+
+export function main(): void {
+  const text = read_stdin()
+  switch (text) {
+    case text as error: log(text.message)
+    case text as string: log(text)
+  }
+}
+`
+	for _, target := range []compiler.Target{compiler.TargetTuna, compiler.TargetWASI} {
+		wasm := compileForTarget(t, src, target)
+		runner := NewRunner()
+		runner.SetStdin(strings.NewReader("ok\xff"))
+		out, err := runner.RunWithArgs(wasm, nil)
+		if err != nil {
+			t.Fatalf("%s: run failed: %v", target, err)
+		}
+		if out != "read_stdin expects UTF-8 text\n" {
+			t.Fatalf("%s: unexpected output: %q", target, out)
+		}
+	}
+}
+
+func TestSandboxStdinIsEmpty(t *testing.T) {
+	src := `
+import { log } from "prelude"
+import { read_line } from "io"
+
+export function main(): error | void {
+  const line = read_line()?
+  switch (line) {
+    case line as undefined: log("eof")
+    case line as string: log(line)
+  }
+}
+`
+	wasm := compileForTarget(t, src, compiler.TargetTuna)
+	runner := NewRunner()
+	runner.isolated = true
+	rt, err := runner.runWithArgs(wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if rt.Output() != "eof\n" {
+		t.Fatalf("unexpected output: %q", rt.Output())
+	}
+}
@@ -0,0 +1,226 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+const (
+	// streamContentTypeMarker は response_stream / sse が返す Response の contentType。
+	// redirect と同様に StartPendingServer 側で特別扱いする。
+	streamContentTypeMarker = "stream"
+	streamChunkBuffer       = 64
+	sseContentType          = "text/event-stream"
+	streamTextContentType   = "text/plain; charset=utf-8"
+)
+
+var errClientDisconnected = errors.New("client disconnected")
+
+type streamChunk struct {
+	data  []byte
+	flush bool
+}
+
+// httpStream はストリーミングレスポンス1本分の状態。
+// WASM 側の write / flush はチャネルにチャンクを積むだけで、実際の書き込みは pump が行う。
+type httpStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	chunks chan streamChunk
+}
+
+func newHTTPStream(parent context.Context) *httpStream {
+	ctx, cancel := context.WithCancel(parent)
+	return &httpStream{
+		ctx:    ctx,
+		cancel: cancel,
+		chunks: make(chan streamChunk, streamChunkBuffer),
+	}
+}
+
+func (s *httpStream) send(chunk streamChunk) error {
+	// 切断済みならバッファに空きがあっても書き込ませない。
+	if s.ctx.Err() != nil {
+		return errClientDisconnected
+	}
+	select {
+	case s.chunks <- chunk:
+		return nil
+	case <-s.ctx.Done():
+		return errClientDisconnected
+	}
+}
+
+// pump はチャンクをレスポンスへ書き出す。書き込みに失敗したらクライアント切断とみなし、
+// 以降の write / flush がエラーを返すようにコンテキストをキャンセルする。
+func (s *httpStream) pump(w http.ResponseWriter, flusher http.Flusher) {
+	failed := false
+	for chunk := range s.chunks {
+		if failed {
+			continue
+		}
+		if len(chunk.data) > 0 {
+			if _, err := w.Write(chunk.data); err != nil {
+				failed = true
+				s.cancel()
+				continue
+			}
+		}
+		if chunk.flush && flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// close は関数値の実行終了後に呼ぶ。残りのチャンクは pump が書き出してから終了する。
+func (s *httpStream) close() {
+	close(s.chunks)
+}
+
+// httpResponseStream は response_stream / sse 用。fn は関数値（エクスポート名の文字列）で、
+// ハンドラーのトランザクションが終わった後に serveStream から呼び出される。
+func (r *Runtime) httpResponseStream(fnHandle *Value, isSSE int32) (*Value, error) {
+	fnVal, err := r.getValue(fnHandle)
+	if err != nil {
+		return nil, err
+	}
+	if fnVal.Kind != KindString {
+		return nil, errors.New("response_stream: fn must be a function")
+	}
+	contentType := streamTextContentType
+	if isSSE != 0 {
+		contentType = sseContentType
+	}
+	resObj := r.newValue(Value{Kind: KindObject, Obj: &Object{Order: []string{}, Props: map[string]*Value{}}})
+	props := []struct{ key, value string }{
+		{"body", ""},
+		{"contentType", streamContentTypeMarker},
+		{"streamFn", fnVal.Str},
+		{"streamContentType", contentType},
+	}
+	for _, p := range props {
+		key := r.newValue(Value{Kind: KindString, Str: p.key})
+		if err := r.objSet(resObj, key, r.newValue(Value{Kind: KindString, Str: p.value})); err != nil {
+			return nil, err
+		}
+	}
+	return resObj, nil
+}
+
+func (r *Runtime) lookupStream(writerHandle *Value) (*httpStream, error) {
+	writerVal, err := r.getValue(writerHandle)
+	if err != nil {
+		return nil, err
+	}
+	if writerVal.Kind != KindI64 {
+		return nil, errors.New("invalid writer handle")
+	}
+	r.httpMu.Lock()
+	stream, ok := r.streams[writerVal.I64]
+	r.httpMu.Unlock()
+	if !ok {
+		return nil, errors.New("writer is closed")
+	}
+	return stream, nil
+}
+
+func (r *Runtime) httpStreamWrite(writerHandle *Value, chunkHandle *Value) error {
+	stream, err := r.lookupStream(writerHandle)
+	if err != nil {
+		return err
+	}
+	chunkVal, err := r.getValue(chunkHandle)
+	if err != nil {
+		return err
+	}
+	if chunkVal.Kind != KindString {
+		return errors.New("write: chunk must be string")
+	}
+	return stream.send(streamChunk{data: []byte(chunkVal.Str)})
+}
+
+func (r *Runtime) httpStreamFlush(writerHandle *Value) error {
+	stream, err := r.lookupStream(writerHandle)
+	if err != nil {
+		return err
+	}
+	return stream.send(streamChunk{flush: true})
+}
+
+// serveStream はストリーミングレスポンスを送出する。
+//
+// ハンドラー本体は invokeRouteHandler で既に完了しコミット済みなので、関数値はストリーム専用のインスタンス
+// （streamPool）で実行し、ストリーム中も他のリクエストを処理できるようにする。
+// ストリーム中はトランザクションを持たないため、SQL は1文ずつ自動コミットになる。
+func (r *Runtime) serveStream(w http.ResponseWriter, req *http.Request, response *HTTPResponse) {
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", response.ContentType)
+	if response.ContentType == sseContentType {
+		w.Header().Set("Cache-Control", "no-cache")
+		// リバースプロキシ(nginx)でのバッファリングを抑止する。
+		w.Header().Set("X-Accel-Buffering", "no")
+	}
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	stream := newHTTPStream(req.Context())
+	defer stream.cancel()
+	if r.streamsCtx != nil {
+		// シャットダウンの猶予が切れたら、クライアント切断と同じく write / flush をエラーにする
+		defer context.AfterFunc(r.streamsCtx, stream.cancel)()
+	}
+	pumpDone := make(chan struct{})
+	go func() {
+		stream.pump(w, flusher)
+		close(pumpDone)
+	}()
+
+	worker, err := r.acquireStreamWorker()
+	if err == nil {
+		err = worker.callStreamFunc(response.StreamFunc, stream)
+		r.releaseStreamWorker(worker)
+	}
+	stream.close()
+	<-pumpDone
+	if flusher != nil && stream.ctx.Err() == nil {
+		flusher.Flush()
+	}
+	if err != nil && !errors.Is(err, errClientDisconnected) {
+		fmt.Fprintf(os.Stderr, "HTTP stream error: %v\n", err)
+	}
+}
+
+// callStreamFunc は Writer を登録して関数値を1回実行する。
+// 呼び出し側はこの Runtime を排他的に確保していること。
+func (r *Runtime) callStreamFunc(fnName string, stream *httpStream) error {
+	r.httpMu.Lock()
+	if r.streams == nil {
+		r.streams = make(map[int64]*httpStream)
+	}
+	r.nextStreamID++
+	id := r.nextStreamID
+	r.streams[id] = stream
+	r.httpMu.Unlock()
+	defer func() {
+		r.httpMu.Lock()
+		delete(r.streams, id)
+		r.httpMu.Unlock()
+	}()
+
+	writer := r.newValue(Value{Kind: KindI64, I64: id})
+	if err := r.callFunctionValue(fnName, writer); err != nil {
+		if stream.ctx.Err() != nil {
+			return errClientDisconnected
+		}
+		return err
+	}
+	return nil
+}
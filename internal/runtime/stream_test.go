@@ -0,0 +1,247 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tuna/internal/compiler"
+)
+
+func TestStreamingResponseAndSSE(t *testing.T) {
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { create_server, add_route, response_stream, response_text, sse, write, flush, send_event, type Request, type Response, type Writer } from "http"
+
+function report(w: Writer): undefined | error {
+  for (const i of ["1", "2", "3"]) {
+    write(w, "line " + i + "\n")?
+    flush(w)?
+  }
+  return undefined
+}
+
+function events(w: Writer): undefined | error {
+  send_event(w, "tick", "a\nb")?
+  send_event(w, "", "done")?
+  return undefined
+}
+
+function forever(w: Writer): undefined | error {
+  write(w, "` + strings.Repeat("x", 4096) + `")?
+  flush(w)?
+  return forever(w)
+}
+
+function handle_report(req: Request): Response {
+  return response_stream(report)
+}
+
+function handle_events(req: Request): Response {
+  return sse(req, events)
+}
+
+function handle_forever(req: Request): Response {
+  return response_stream(forever)
+}
+
+function handle_ping(req: Request): Response {
+  return response_text("pong")
+}
+
+export function main(): void {
+  const server = create_server()
+  add_route(server, "/report", handle_report)
+  add_route(server, "/events", handle_events)
+  add_route(server, "/forever", handle_forever)
+  add_route(server, "/ping", handle_ping)
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	comp := compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		t.Fatalf("set backend failed: %v", err)
+	}
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	rt, err := NewRunner().runWithArgs(res.Wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var server *HTTPServer
+	for _, s := range rt.httpServers {
+		server = s
+	}
+	ts := httptest.NewServer(rt.routeHandler(server, serverOptions{}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/report")
+	if err != nil {
+		t.Fatalf("GET /report failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", got)
+	}
+	if string(body) != "line 1\nline 2\nline 3\n" {
+		t.Fatalf("unexpected stream body %q", body)
+	}
+
+	resp, err = http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+		t.Fatalf("unexpected cache control %q", got)
+	}
+	if want := "event: tick\ndata: a\ndata: b\n\ndata: done\n\n"; string(body) != want {
+		t.Fatalf("unexpected sse body %q, want %q", body, want)
+	}
+
+	// 切断すると write がエラーを返し、無限ループのストリームも終了してワーカーが返却される。
+	resp, err = http.Get(ts.URL + "/forever")
+	if err != nil {
+		t.Fatalf("GET /forever failed: %v", err)
+	}
+	if _, err := bufio.NewReader(resp.Body).ReadString('x'); err != nil {
+		t.Fatalf("failed to read first chunk: %v", err)
+	}
+	resp.Body.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !streamPoolIdle(rt) {
+		if time.Now().After(deadline) {
+			t.Fatal("stream did not stop after client disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err = http.Get(ts.URL + "/report")
+	if err != nil {
+		t.Fatalf("GET /report after disconnect failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(string(body), "line 1") {
+		t.Fatalf("unexpected body after disconnect %q", body)
+	}
+}
+
+// streamPoolIdle は実行中のストリームが無く、生成済みのストリーム用インスタンスがすべて返却されているか。
+func streamPoolIdle(rt *Runtime) bool {
+	rt.streamPool.mu.Lock()
+	defer rt.streamPool.mu.Unlock()
+	return len(rt.streamPool.idle) == len(rt.streamPool.workers)
+}
+
+// ストリームはハンドラー用のワーカーとは別のインスタンスで実行されるので、
+// workers=1 でもストリーム中に他のリクエストを処理できる。
+func TestStreamDoesNotBlockOtherRequests(t *testing.T) {
+	src := `
+import { create_server, add_route, response_stream, response_text, write, flush, type Request, type Response, type Writer } from "http"
+
+function forever(w: Writer): undefined | error {
+  write(w, "` + strings.Repeat("x", 4096) + `")?
+  flush(w)?
+  return forever(w)
+}
+
+function handle_forever(req: Request): Response {
+  return response_stream(forever)
+}
+
+function handle_ping(req: Request): Response {
+  return response_text("pong")
+}
+
+export function main(): void {
+  const server = create_server()
+  add_route(server, "/forever", handle_forever)
+  add_route(server, "/ping", handle_ping)
+}
+`
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	comp := compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		t.Fatalf("set backend failed: %v", err)
+	}
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	rt, err := NewRunner().runWithArgs(res.Wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if rt.pool != nil {
+		t.Fatalf("expected a single handler worker")
+	}
+	var server *HTTPServer
+	for _, s := range rt.httpServers {
+		server = s
+	}
+	ts := httptest.NewServer(rt.routeHandler(server, serverOptions{}))
+	defer ts.Close()
+
+	stream, err := http.Get(ts.URL + "/forever")
+	if err != nil {
+		t.Fatalf("GET /forever failed: %v", err)
+	}
+	if _, err := bufio.NewReader(stream.Body).ReadString('x'); err != nil {
+		t.Fatalf("failed to read first chunk: %v", err)
+	}
+
+	done := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(ts.URL + "/ping")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		done <- string(body)
+	}()
+	select {
+	case got := <-done:
+		if got != "pong" {
+			t.Fatalf("unexpected /ping body while streaming: %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("/ping was blocked by an open stream")
+	}
+
+	stream.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !streamPoolIdle(rt) {
+		if time.Now().After(deadline) {
+			t.Fatal("stream did not stop after client disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
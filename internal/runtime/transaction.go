@@ -0,0 +1,108 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// transaction { ... } ブロックはデフォルト接続のトランザクションとして実行する。
+// トランザクション外（main や CLI スクリプト）では BEGIN し、リクエストのトランザクションや
+// 外側のブロックの中ではセーブポイントを作る。txBlocks は開いているブロックごとのセーブポイント名で、
+// 空文字はそのブロック自身が currentTx を開始したことを表す。
+
+func (r *Runtime) sqlTxBegin() error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+	if r.currentTx == nil {
+		var tx *sql.Tx
+		var err error
+		if p, ok := r.pins[nil]; ok {
+			// fetch_iter のカーソルが接続を pin している
+			tx, err = p.conn.BeginTx(context.Background(), nil)
+		} else {
+			tx, err = r.db.Begin()
+		}
+		if err != nil {
+			return fmt.Errorf("transaction begin error: %w", err)
+		}
+		r.currentTx = tx
+		r.txBlocks = append(r.txBlocks, "")
+		return nil
+	}
+	name := fmt.Sprintf("tuna_tx_%d", len(r.txBlocks)+1)
+	if _, err := r.currentTx.Exec("SAVEPOINT " + name); err != nil {
+		return fmt.Errorf("transaction begin error: %w", err)
+	}
+	r.txBlocks = append(r.txBlocks, name)
+	return nil
+}
+
+func (r *Runtime) sqlTxCommit() error {
+	name, ok := r.popTxBlock()
+	if !ok {
+		return errors.New("no transaction is active")
+	}
+	if name == "" {
+		err := r.currentTx.Commit()
+		r.currentTx = nil
+		r.releaseTxWriteLock()
+		if err != nil {
+			return fmt.Errorf("transaction commit error: %w", err)
+		}
+		return nil
+	}
+	if _, err := r.currentTx.Exec("RELEASE SAVEPOINT " + name); err != nil {
+		return fmt.Errorf("transaction commit error: %w", err)
+	}
+	return nil
+}
+
+func (r *Runtime) sqlTxRollback() error {
+	name, ok := r.popTxBlock()
+	if !ok {
+		// db_open などでトランザクションが既に破棄されている
+		return nil
+	}
+	if name == "" {
+		err := r.currentTx.Rollback()
+		r.currentTx = nil
+		r.releaseTxWriteLock()
+		return err
+	}
+	if _, err := r.currentTx.Exec("ROLLBACK TO SAVEPOINT " + name); err != nil {
+		return err
+	}
+	_, err := r.currentTx.Exec("RELEASE SAVEPOINT " + name)
+	return err
+}
+
+func (r *Runtime) popTxBlock() (string, bool) {
+	n := len(r.txBlocks)
+	if n == 0 || r.currentTx == nil {
+		r.txBlocks = nil
+		return "", false
+	}
+	name := r.txBlocks[n-1]
+	r.txBlocks = r.txBlocks[:n-1]
+	return name, true
+}
+
+// abortTxBlocks はトラップなどでブロックを抜けられなかったときに、開いたままのブロックを破棄する。
+// ブロックが開始したトランザクションはロールバックし、セーブポイントは外側のトランザクションに任せる。
+func (r *Runtime) abortTxBlocks() {
+	if len(r.txBlocks) == 0 {
+		return
+	}
+	if r.txBlocks[0] == "" && r.currentTx != nil {
+		_ = r.currentTx.Rollback()
+		r.currentTx = nil
+		r.releaseTxWriteLock()
+	}
+	r.txBlocks = nil
+}
@@ -0,0 +1,70 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransactionBlockRollsBackOnTrap(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "app.db")
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { log, to_string } from "prelude"
+import { db_open } from "sqlite"
+
+create_table notes {
+  body TEXT NOT NULL
+}
+
+export function main(): void | error {
+  db_open("` + filepath.ToSlash(dbPath) + `")?
+  const kept = transaction {
+    execute {
+      INSERT INTO notes (body) VALUES ('kept')
+    }?
+  }
+  kept?
+  const zero = fetch_one {
+    SELECT COUNT(*) AS n FROM notes
+  }?
+  const result = transaction {
+    execute {
+      INSERT INTO notes (body) VALUES ('lost')
+    }?
+    10 / (zero.n - 1)
+  }
+  log(to_string(result?))
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	res := compileHostProgram(t, entry)
+	rt, err := NewRunner().runWithArgs(res.Wasm, nil)
+	if err == nil {
+		t.Fatalf("expected a trap")
+	}
+	if rt.currentTx != nil || len(rt.txBlocks) != 0 || rt.writeLocked {
+		t.Fatalf("transaction state was not reset after the trap")
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer db.Close()
+	var body string
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*), MIN(body) FROM notes").Scan(&count, &body); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if count != 1 || body != "kept" {
+		t.Fatalf("expected only the committed row, got %d rows (%s)", count, body)
+	}
+}
@@ -0,0 +1,400 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// RFC 6455 のハンドシェイクで Sec-WebSocket-Key に連結する固定GUID。
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseUnsupported   = 1003
+	wsCloseInvalidData   = 1007
+	wsCloseTooBig        = 1009
+	wsCloseInternalError = 1011
+
+	// wsMaxMessageBytes を超えるメッセージを受け取ると 1009 で切断する。
+	wsMaxMessageBytes = 1 << 20
+)
+
+var errWebSocketClosed = errors.New("websocket connection closed")
+
+// websocketHandlers は add_websocket に渡されたハンドラー（関数値のエクスポート名）。
+type websocketHandlers struct {
+	onOpen    string
+	onMessage string
+	onClose   string
+}
+
+// websocketRegistry は接続中の WebSocket を Conn ハンドルで引けるようにする。
+// ワーカーはそれぞれ別の Runtime なので、親と全ワーカーで1つのレジストリを共有する。
+type websocketRegistry struct {
+	mu     sync.Mutex
+	nextID int64
+	conns  map[int64]*websocketConn
+}
+
+func newWebsocketRegistry() *websocketRegistry {
+	return &websocketRegistry{conns: make(map[int64]*websocketConn)}
+}
+
+func (reg *websocketRegistry) add(c *websocketConn) int64 {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.nextID++
+	reg.conns[reg.nextID] = c
+	return reg.nextID
+}
+
+func (reg *websocketRegistry) remove(id int64) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.conns, id)
+}
+
+func (reg *websocketRegistry) get(id int64) (*websocketConn, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	c, ok := reg.conns[id]
+	return c, ok
+}
+
+// websocketConn は Hijack したコネクション1本分。読み込みはハンドラーの goroutine だけが行い、
+// 書き込みは send（任意のワーカー）と制御フレームの応答が競合するため writeMu で直列化する。
+type websocketConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errWebSocketClosed
+	}
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		c.closed = true
+		return err
+	}
+	if opcode == wsOpClose {
+		c.closed = true
+	}
+	return nil
+}
+
+func (c *websocketConn) writeClose(code int, reason string) {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	_ = c.writeFrame(wsOpClose, append(payload, reason...))
+}
+
+// wsCloseError は相手に送るクローズコードを伴う読み込みエラー。
+type wsCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket close %d: %s", e.code, e.reason)
+}
+
+// readMessage は次のテキストメッセージを返す。ping には pong で応答し、断片化されたメッセージは連結する。
+// 相手からのクローズは io.EOF、プロトコル違反は *wsCloseError を返す。
+func (c *websocketConn) readMessage() (string, error) {
+	var message []byte
+	fragmented := false
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+			return "", err
+		}
+		fin := head[0]&0x80 != 0
+		if head[0]&0x70 != 0 {
+			return "", &wsCloseError{wsCloseProtocolError, "reserved bits set"}
+		}
+		opcode := head[0] & 0x0F
+		if head[1]&0x80 == 0 {
+			return "", &wsCloseError{wsCloseProtocolError, "client frames must be masked"}
+		}
+		length := uint64(head[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return "", err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return "", err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		isControl := opcode&0x08 != 0
+		if isControl && (length > 125 || !fin) {
+			return "", &wsCloseError{wsCloseProtocolError, "invalid control frame"}
+		}
+		if uint64(len(message))+length > wsMaxMessageBytes {
+			return "", &wsCloseError{wsCloseTooBig, "message too big"}
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return "", err
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return "", err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return "", err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.writeClose(code, "")
+			return "", io.EOF
+		case wsOpText:
+			if fragmented {
+				return "", &wsCloseError{wsCloseProtocolError, "expected continuation frame"}
+			}
+		case wsOpContinuation:
+			if !fragmented {
+				return "", &wsCloseError{wsCloseProtocolError, "unexpected continuation frame"}
+			}
+		case wsOpBinary:
+			return "", &wsCloseError{wsCloseUnsupported, "binary messages are not supported"}
+		default:
+			return "", &wsCloseError{wsCloseProtocolError, "unknown opcode"}
+		}
+
+		message = append(message, payload...)
+		if !fin {
+			fragmented = true
+			continue
+		}
+		if !utf8.Valid(message) {
+			return "", &wsCloseError{wsCloseInvalidData, "invalid utf-8"}
+		}
+		return string(message), nil
+	}
+}
+
+// httpAddWebsocket は add_websocket 用。handlers は { on_open, on_message, on_close } の関数値オブジェクト。
+func (r *Runtime) httpAddWebsocket(serverHandle *Value, pathHandle *Value, handlersHandle *Value) error {
+	r.httpMu.Lock()
+	defer r.httpMu.Unlock()
+
+	serverVal, err := r.getValue(serverHandle)
+	if err != nil || serverVal.Kind != KindI64 {
+		return errors.New("invalid server handle")
+	}
+	server, ok := r.httpServers[serverVal.I64]
+	if !ok {
+		return errors.New("invalid server handle")
+	}
+	pathVal, err := r.getValue(pathHandle)
+	if err != nil {
+		return err
+	}
+	if pathVal.Kind != KindString || !strings.HasPrefix(pathVal.Str, "/") {
+		return errors.New("add_websocket: path must start with /")
+	}
+	handlersVal, err := r.getValue(handlersHandle)
+	if err != nil {
+		return err
+	}
+	if handlersVal.Kind != KindObject {
+		return errors.New("add_websocket: handlers must be object")
+	}
+	fnName := func(key string) (string, error) {
+		v, ok := handlersVal.Obj.Props[key]
+		if !ok || v.Kind != KindString {
+			return "", fmt.Errorf("add_websocket: %s must be a function", key)
+		}
+		return v.Str, nil
+	}
+	var handlers websocketHandlers
+	if handlers.onOpen, err = fnName("on_open"); err != nil {
+		return err
+	}
+	if handlers.onMessage, err = fnName("on_message"); err != nil {
+		return err
+	}
+	if handlers.onClose, err = fnName("on_close"); err != nil {
+		return err
+	}
+
+	server.mux.HandleFunc(pathVal.Str, func(w http.ResponseWriter, req *http.Request) {
+		r.serveWebsocket(w, req, handlers)
+	})
+	return nil
+}
+
+func headerContainsToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// serveWebsocket はハンドシェイクを行い、接続が閉じるまでメッセージを読み続ける。
+// 各イベントのハンドラーはその都度ワーカーを借りて実行し、待機中はワーカーを保持しない。
+func (r *Runtime) serveWebsocket(w http.ResponseWriter, req *http.Request, handlers websocketHandlers) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet ||
+		!headerContainsToken(req.Header, "Connection", "upgrade") ||
+		!headerContainsToken(req.Header, "Upgrade", "websocket") ||
+		key == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Upgrade Required", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "websocket hijack error: %v\n", err)
+		return
+	}
+	defer netConn.Close()
+	// listen_with のタイムアウトは Hijack 後のコネクションには適用しない。
+	_ = netConn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n"
+	if _, err := rw.WriteString(response); err != nil {
+		return
+	}
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	conn := &websocketConn{conn: netConn, reader: rw.Reader}
+	id := r.websockets.add(conn)
+	defer r.websockets.remove(id)
+
+	dispatch := func(fnName string, texts ...string) error {
+		worker := r.acquireWorker()
+		defer r.releaseWorker(worker)
+		args := []*Value{worker.newValue(Value{Kind: KindI64, I64: id})}
+		for _, text := range texts {
+			args = append(args, worker.newValue(Value{Kind: KindString, Str: text}))
+		}
+		return worker.callFunctionValue(fnName, args...)
+	}
+
+	if err := dispatch(handlers.onOpen); err != nil {
+		fmt.Fprintf(os.Stderr, "websocket on_open error: %v\n", err)
+		conn.writeClose(wsCloseInternalError, "")
+		return
+	}
+	for {
+		text, err := conn.readMessage()
+		if err != nil {
+			var closeErr *wsCloseError
+			if errors.As(err, &closeErr) {
+				conn.writeClose(closeErr.code, closeErr.reason)
+			}
+			break
+		}
+		if err := dispatch(handlers.onMessage, text); err != nil {
+			fmt.Fprintf(os.Stderr, "websocket on_message error: %v\n", err)
+			conn.writeClose(wsCloseInternalError, "")
+			break
+		}
+	}
+	if err := dispatch(handlers.onClose); err != nil {
+		fmt.Fprintf(os.Stderr, "websocket on_close error: %v\n", err)
+	}
+}
+
+// httpWebsocketSend は send 用。閉じた接続への送信は error を返す。
+func (r *Runtime) httpWebsocketSend(connHandle *Value, textHandle *Value) error {
+	connVal, err := r.getValue(connHandle)
+	if err != nil {
+		return err
+	}
+	if connVal.Kind != KindI64 {
+		return errors.New("invalid websocket connection")
+	}
+	textVal, err := r.getValue(textHandle)
+	if err != nil {
+		return err
+	}
+	if textVal.Kind != KindString {
+		return errors.New("send: text must be string")
+	}
+	conn, ok := r.websockets.get(connVal.I64)
+	if !ok {
+		return errWebSocketClosed
+	}
+	return conn.writeFrame(wsOpText, []byte(textVal.Str))
+}
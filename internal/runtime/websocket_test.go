@@ -0,0 +1,203 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tuna/internal/compiler"
+)
+
+// wsTestClient は RFC 6455 の最小限のクライアント（テキストフレームのみ）。
+type wsTestClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebsocket(t *testing.T, serverURL string, path string) *wsTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("handshake write failed: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("handshake read failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected Sec-WebSocket-Accept %q", got)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &wsTestClient{conn: conn, reader: reader}
+}
+
+func (c *wsTestClient) writeFrame(t *testing.T, opcode byte, payload []byte) {
+	t.Helper()
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatalf("frame write failed: %v", err)
+	}
+}
+
+func (c *wsTestClient) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		t.Fatalf("frame read failed: %v", err)
+	}
+	length := int(head[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			t.Fatalf("frame read failed: %v", err)
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		t.Fatalf("frame read failed: %v", err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+func TestWebsocketEchoAndLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { create_server, add_websocket, send, type Conn } from "http"
+
+create_table ws_events {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  kind TEXT NOT NULL
+}
+
+function on_open(conn: Conn): undefined | error {
+  send(conn, "welcome")?
+  return undefined
+}
+
+function on_message(conn: Conn, text: string): undefined | error {
+  execute {
+    INSERT INTO ws_events (kind) VALUES ({text})
+  }?
+  send(conn, "echo: " + text)?
+  return undefined
+}
+
+function on_close(conn: Conn): undefined | error {
+  execute {
+    INSERT INTO ws_events (kind) VALUES ('closed')
+  }?
+  return undefined
+}
+
+export function main(): void {
+  const server = create_server()
+  add_websocket(server, "/ws", { on_open: on_open, on_message: on_message, on_close: on_close })
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	comp := compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		t.Fatalf("set backend failed: %v", err)
+	}
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	rt, err := NewRunner().runWithArgs(res.Wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var server *HTTPServer
+	for _, s := range rt.httpServers {
+		server = s
+	}
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+
+	client := dialWebsocket(t, ts.URL, "/ws")
+	if op, payload := client.readFrame(t); op != wsOpText || string(payload) != "welcome" {
+		t.Fatalf("unexpected open frame %d %q", op, payload)
+	}
+
+	client.writeFrame(t, wsOpText, []byte("hello"))
+	if op, payload := client.readFrame(t); op != wsOpText || string(payload) != "echo: hello" {
+		t.Fatalf("unexpected echo frame %d %q", op, payload)
+	}
+
+	client.writeFrame(t, wsOpPing, []byte("p"))
+	if op, payload := client.readFrame(t); op != wsOpPong || string(payload) != "p" {
+		t.Fatalf("unexpected pong frame %d %q", op, payload)
+	}
+
+	client.writeFrame(t, wsOpClose, []byte{0x03, 0xE8})
+	if op, payload := client.readFrame(t); op != wsOpClose || binary.BigEndian.Uint16(payload) != wsCloseNormal {
+		t.Fatalf("unexpected close frame %d %v", op, payload)
+	}
+	client.conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var kinds []string
+		rows, err := rt.db.Query("SELECT kind FROM ws_events ORDER BY id")
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		for rows.Next() {
+			var kind string
+			_ = rows.Scan(&kind)
+			kinds = append(kinds, kind)
+		}
+		rows.Close()
+		if strings.Join(kinds, ",") == "hello,closed" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected events %v", kinds)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err := http.Get(ts.URL + "/ws")
+	if err != nil {
+		t.Fatalf("plain GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for non-upgrade request, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,215 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/bytecodealliance/wasmtime-go/v41"
+)
+
+// workerPool は HTTP ハンドラーを並行実行するための WASM インスタンス群。
+//
+// 各ワーカーは独自の Store / Instance / トランザクション / GC 状態を持つ Runtime で、
+// *sql.DB と prepare 済みの文、書き込みロック(writeMu)、WebSocket の接続一覧だけを親 Runtime と共有する。
+// ワーカーは `_start` を実行しないため、main で変更したグローバル状態は共有されない
+// （トップレベル const はハンドラー初回呼び出し時の __ensure_init で各インスタンスごとに初期化される）。
+type workerPool struct {
+	idle    chan *Runtime
+	workers []*Runtime
+}
+
+func newWorkerPool(workers []*Runtime) *workerPool {
+	p := &workerPool{
+		idle:    make(chan *Runtime, len(workers)),
+		workers: workers,
+	}
+	for _, w := range workers {
+		p.idle <- w
+	}
+	return p
+}
+
+func (p *workerPool) acquire() *Runtime {
+	return <-p.idle
+}
+
+func (p *workerPool) release(w *Runtime) {
+	p.idle <- w
+}
+
+func (r *Runtime) acquireWorker() *Runtime {
+	if r.pool == nil {
+		r.handlerMu.Lock()
+		return r
+	}
+	return r.pool.acquire()
+}
+
+func (r *Runtime) releaseWorker(w *Runtime) {
+	if r.pool == nil {
+		r.handlerMu.Unlock()
+		return
+	}
+	r.pool.release(w)
+}
+
+// streamPool はストリーミングレスポンスの関数値を実行する専用インスタンス群。
+//
+// ストリームはクライアントが切断するまで続くことがあるので、ハンドラー用のワーカー（workers=1 なら handlerMu）を
+// 占有しないよう、必要になった時点で追加インスタンスを生成し、ストリーム終了後は次のストリームに再利用する。
+type streamPool struct {
+	mu        sync.Mutex
+	done      sync.Cond // active が 0 になったことを close に知らせる
+	idle      []*Runtime
+	workers   []*Runtime
+	active    int
+	closed    bool
+	newWorker func() (*Runtime, error)
+}
+
+var errServerShuttingDown = errors.New("server is shutting down")
+
+func (p *streamPool) acquire() (*Runtime, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errServerShuttingDown
+	}
+	p.active++
+	if n := len(p.idle); n > 0 {
+		w := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return w, nil
+	}
+	p.mu.Unlock()
+	w, err := p.newWorker()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.active--
+		p.done.Broadcast()
+		return nil, err
+	}
+	p.workers = append(p.workers, w)
+	return w, nil
+}
+
+func (p *streamPool) release(w *Runtime) {
+	p.mu.Lock()
+	p.idle = append(p.idle, w)
+	p.active--
+	p.done.Broadcast()
+	p.mu.Unlock()
+}
+
+// close は以降のストリームを断り、実行中のストリームがすべて終わるまで待つ。
+func (p *streamPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for p.active > 0 {
+		p.done.Wait()
+	}
+}
+
+// acquireStreamWorker はストリームの関数値を実行するインスタンスを確保する。
+// streamPool が無い（Runner を通さずに起動した）場合はハンドラー用のワーカーを使う。
+func (r *Runtime) acquireStreamWorker() (*Runtime, error) {
+	if r.streamPool == nil {
+		return r.acquireWorker(), nil
+	}
+	return r.streamPool.acquire()
+}
+
+func (r *Runtime) releaseStreamWorker(w *Runtime) {
+	if r.streamPool == nil {
+		r.releaseWorker(w)
+		return
+	}
+	r.streamPool.release(w)
+}
+
+// drainWorkers はシャットダウン時、DB を閉じる前に呼ぶ。実行中のストリームとハンドラー
+// （WebSocket のイベントを含む）の終了を待ち、すべてのワーカーを確保したまま返す。
+// 以降のハンドラーはワーカーを得られないので、閉じた DB に触れることはない。
+func (r *Runtime) drainWorkers() {
+	if r.streamPool != nil {
+		r.streamPool.close()
+	}
+	if r.pool == nil {
+		r.handlerMu.Lock()
+		return
+	}
+	for range r.pool.workers {
+		r.pool.acquire()
+	}
+}
+
+// startWorkers は _start 完了後の親 Runtime に対し、workers-1 個の追加インスタンスを生成して
+// プールを構成する。親自身もワーカーの1つとして使われる。
+// ストリーミングレスポンス用のインスタンスは workers の値にかかわらず streamPool が必要に応じて生成する。
+func (r *Runner) startWorkers(module *wasmtime.Module, parent *Runtime) error {
+	parent.streamPool = &streamPool{
+		newWorker: func() (*Runtime, error) { return r.newWorker(module, parent) },
+	}
+	parent.streamPool.done.L = &parent.streamPool.mu
+	if r.workers <= 1 {
+		return nil
+	}
+	workers := []*Runtime{parent}
+	for i := 1; i < r.workers; i++ {
+		w, err := r.newWorker(module, parent)
+		if err != nil {
+			return err
+		}
+		workers = append(workers, w)
+	}
+	parent.pool = newWorkerPool(workers)
+	return nil
+}
+
+func (r *Runner) newWorker(module *wasmtime.Module, parent *Runtime) (*Runtime, error) {
+	store := wasmtime.NewStore(r.engine)
+	linker := wasmtime.NewLinker(r.engine)
+	if err := r.applyLimits(store); err != nil {
+		return nil, err
+	}
+
+	w := NewRuntime()
+	w.SetArgs(parent.args)
+	w.isWorker = true
+	w.workers = parent.workers
+	w.db = parent.db
+	w.stmts = parent.stmts
+	w.sqlTrace = parent.sqlTrace
+	w.writeMu = parent.writeMu
+	w.tableDefs = parent.tableDefs
+	w.websockets = parent.websockets
+	w.conns = parent.conns
+	w.stdout = parent.stdout
+	w.stderr = parent.stderr
+	w.html = parent.html
+	w.stdin = parent.stdin
+	if err := defineWASIFDWrite(linker, store, w); err != nil {
+		return nil, err
+	}
+	if err := defineWASIFDRead(linker, store, w); err != nil {
+		return nil, err
+	}
+	if err := w.Define(linker, store); err != nil {
+		return nil, err
+	}
+	instance, err := linker.Instantiate(store, module)
+	if err != nil {
+		return nil, err
+	}
+	w.SetWasmContext(store, instance)
+	if w.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+	return w, nil
+}
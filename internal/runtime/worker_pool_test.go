@@ -0,0 +1,213 @@
+//go:build cgo
+// +build cgo
+
+package runtime
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"tuna/internal/compiler"
+)
+
+func TestWorkerPoolHandlesConcurrentRequests(t *testing.T) {
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	src := `
+import { create_server, add_route, response_text, type Request, type Response } from "http"
+
+create_table hits {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  path TEXT NOT NULL
+}
+
+function handle_hit(req: Request): Response | error {
+  execute {
+    INSERT INTO hits (path) VALUES ({req.path})
+  }?
+  return response_text("ok")
+}
+
+export function main(): void {
+  const server = create_server()
+  add_route(server, "get", "/hit", handle_hit)
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	comp := compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		t.Fatalf("set backend failed: %v", err)
+	}
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	runner := NewRunner()
+	if err := runner.SetWorkers(4); err != nil {
+		t.Fatalf("set workers failed: %v", err)
+	}
+	rt, err := runner.runWithArgs(res.Wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if rt.pool == nil || len(rt.pool.workers) != 4 {
+		t.Fatalf("expected a pool of 4 workers, got %+v", rt.pool)
+	}
+	for _, w := range rt.pool.workers[1:] {
+		if w.store == rt.store || w.instance == rt.instance {
+			t.Fatal("expected each worker to own its store and instance")
+		}
+		if w.db != rt.db {
+			t.Fatal("expected workers to share the database")
+		}
+	}
+
+	var server *HTTPServer
+	for _, s := range rt.httpServers {
+		server = s
+	}
+
+	const requests = 32
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := rt.invokeRouteHandler(server, "/hit", "GET", map[string]string{}, map[string]string{})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if resp.Body != "ok" {
+				t.Errorf("unexpected body: %q", resp.Body)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("invokeRouteHandler failed: %v", err)
+	}
+
+	var count int
+	if err := rt.db.QueryRow("SELECT COUNT(*) FROM hits").Scan(&count); err != nil {
+		t.Fatalf("count query failed: %v", err)
+	}
+	if count != requests {
+		t.Fatalf("expected %d rows, got %d", requests, count)
+	}
+}
+
+// ファイルDB（WAL）で読み込んでから書き込むハンドラーを並行に実行しても、
+// SQLITE_BUSY_SNAPSHOT で失敗せず、更新も失われない。
+func TestWorkerPoolReadThenWriteOnFileDB(t *testing.T) {
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "main.tuna")
+	dbPath := filepath.Join(dir, "app.sqlite3")
+	src := `
+import { create_server, add_route, response_text, type Request, type Response } from "http"
+import { db_open } from "sqlite"
+
+create_table counters {
+  id INTEGER PRIMARY KEY,
+  value INTEGER NOT NULL
+}
+
+function handle_incr(req: Request): Response | error {
+  const row = fetch_one {
+    SELECT value FROM counters WHERE id = 1
+  }?
+  execute {
+    UPDATE counters SET value = {row.value + 1} WHERE id = 1
+  }?
+  return response_text("ok")
+}
+
+export function main(): void | error {
+  db_open("` + filepath.ToSlash(dbPath) + `")?
+  execute {
+    INSERT INTO counters (id, value) VALUES (1, 0)
+  }?
+  const server = create_server()
+  add_route(server, "get", "/incr", handle_incr)
+}
+`
+	if err := os.WriteFile(entry, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	comp := compiler.New()
+	if err := comp.SetBackend(compiler.BackendHost); err != nil {
+		t.Fatalf("set backend failed: %v", err)
+	}
+	res, err := comp.Compile(entry)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	runner := NewRunner()
+	if err := runner.SetWorkers(8); err != nil {
+		t.Fatalf("set workers failed: %v", err)
+	}
+	// CPU が1つでも読み込みと書き込みの間に他のワーカーが割り込むよう、SELECT の記録で少し待つ
+	runner.sqlTrace = &sqlTracer{out: yieldAfterSelect{}, all: true}
+	rt, err := runner.runWithArgs(res.Wasm, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	defer rt.db.Close()
+
+	var server *HTTPServer
+	for _, s := range rt.httpServers {
+		server = s
+	}
+
+	const requests = 200
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := rt.invokeRouteHandler(server, "/incr", "GET", map[string]string{}, map[string]string{})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if resp.Body != "ok" {
+				t.Errorf("unexpected body: %q", resp.Body)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("invokeRouteHandler failed: %v", err)
+	}
+
+	var value int
+	if err := rt.db.QueryRow("SELECT value FROM counters WHERE id = 1").Scan(&value); err != nil {
+		t.Fatalf("select failed: %v", err)
+	}
+	if value != requests {
+		t.Fatalf("expected value %d, got %d", requests, value)
+	}
+}
+
+type yieldAfterSelect struct{}
+
+func (yieldAfterSelect) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("SELECT")) {
+		time.Sleep(time.Millisecond)
+	}
+	return len(p), nil
+}
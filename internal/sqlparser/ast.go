@@ -0,0 +1,357 @@
+package sqlparser
+
+// Span is a byte range [Pos, End) in the query text.
+type Span struct {
+	Pos int
+	End int
+}
+
+func (s Span) GetSpan() Span { return s }
+
+type Node interface {
+	GetSpan() Span
+}
+
+// Ident is an identifier. Name is unquoted; Quote is the opening quote character
+// ('"', '[', '`', or '\” for string aliases), or 0 for a bare identifier.
+type Ident struct {
+	Name  string
+	Quote byte
+	Span
+}
+
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// OtherStmt is a statement the parser does not analyze (CREATE, DROP, PRAGMA, BEGIN, ...).
+type OtherStmt struct {
+	Keyword string
+	Span
+}
+
+// With is a WITH clause.
+type With struct {
+	Recursive bool
+	CTEs      []*CTE
+	Span
+}
+
+// CTE is one common table expression: name(columns) AS (select).
+type CTE struct {
+	Name    *Ident
+	Columns []*Ident
+	Select  *SelectStmt
+	Span
+}
+
+// SelectStmt is a (possibly compound) SELECT or VALUES statement.
+type SelectStmt struct {
+	With    *With
+	Cores   []*SelectCore // UNION / INTERSECT / EXCEPT で連結された SELECT
+	OrderBy []*OrderingTerm
+	Limit   Expr
+	Offset  Expr
+	Span
+}
+
+// SelectCore is one SELECT ... or VALUES ... part of a SelectStmt.
+type SelectCore struct {
+	Compound string // 前の SELECT との連結演算子（"UNION ALL" など）。先頭は空
+	Distinct bool
+	Columns  []*ResultColumn
+	From     []*TableRef
+	Where    Expr
+	GroupBy  []Expr
+	Having   Expr
+	Values   []*ValuesRow // VALUES (...), (...) の場合のみ
+	Span
+}
+
+type ValuesRow struct {
+	Exprs []Expr
+	Span
+}
+
+// ResultColumn is `*`, `table.*` or `expr [AS alias]`.
+type ResultColumn struct {
+	Star  bool
+	Table *Ident // table.* の table
+	Expr  Expr
+	Alias *Ident
+	Span
+}
+
+// TableRef is one item in a FROM clause. Every item except the first has Join set.
+type TableRef struct {
+	Join     *Join
+	Schema   *Ident
+	Name     *Ident      // テーブル名、またはテーブル値関数名
+	Args     []Expr      // テーブル値関数の引数（json_each(...) など）
+	IsFunc   bool        // テーブル値関数なら true
+	Subquery *SelectStmt // (SELECT ...) の場合
+	Group    []*TableRef // (a JOIN b ...) の場合
+	Alias    *Ident
+	Span
+}
+
+// Join is the join operator and constraint placed in front of a TableRef.
+type Join struct {
+	Op      string // ",", "JOIN", "LEFT JOIN", "RIGHT JOIN", "FULL JOIN", "CROSS JOIN", "INNER JOIN"
+	Natural bool
+	On      Expr
+	Using   []*Ident
+	Span
+}
+
+type OrderingTerm struct {
+	Expr Expr
+	Desc bool
+	Span
+}
+
+// TableConstraint is a table constraint inside CREATE TABLE (...).
+type TableConstraint struct {
+	Name       *Ident   // CONSTRAINT name（無ければ nil）
+	Kind       string   // "PRIMARY KEY", "UNIQUE", "CHECK", "FOREIGN KEY"
+	Columns    []*Ident // PRIMARY KEY / UNIQUE / FOREIGN KEY の列
+	Check      Expr     // CHECK (expr)
+	RefTable   *Ident   // FOREIGN KEY の参照先テーブル
+	RefColumns []*Ident // FOREIGN KEY の参照先の列（省略時は nil）
+	Span
+}
+
+// InsertStmt is INSERT / REPLACE.
+type InsertStmt struct {
+	With          *With
+	Or            string // REPLACE, IGNORE, ...（INSERT OR xxx / REPLACE INTO）
+	Schema        *Ident
+	Table         *Ident
+	Alias         *Ident
+	Columns       []*Ident
+	Select        *SelectStmt // VALUES も SelectStmt として保持する
+	DefaultValues bool
+	Upserts       []*Upsert
+	Returning     []*ResultColumn
+	Span
+}
+
+// Upsert is ON CONFLICT (...) DO NOTHING / DO UPDATE SET ...
+type Upsert struct {
+	Target      []Expr
+	TargetWhere Expr
+	DoNothing   bool
+	Set         []*Assignment
+	Where       Expr
+	Span
+}
+
+type Assignment struct {
+	Columns []*Ident
+	Value   Expr
+	Span
+}
+
+// UpdateStmt is UPDATE.
+type UpdateStmt struct {
+	With      *With
+	Or        string
+	Schema    *Ident
+	Table     *Ident
+	Alias     *Ident
+	Set       []*Assignment
+	From      []*TableRef
+	Where     Expr
+	Returning []*ResultColumn
+	OrderBy   []*OrderingTerm
+	Limit     Expr
+	Offset    Expr
+	Span
+}
+
+// DeleteStmt is DELETE.
+type DeleteStmt struct {
+	With      *With
+	Schema    *Ident
+	Table     *Ident
+	Alias     *Ident
+	Where     Expr
+	Returning []*ResultColumn
+	OrderBy   []*OrderingTerm
+	Limit     Expr
+	Offset    Expr
+	Span
+}
+
+func (*OtherStmt) stmtNode()  {}
+func (*SelectStmt) stmtNode() {}
+func (*InsertStmt) stmtNode() {}
+func (*UpdateStmt) stmtNode() {}
+func (*DeleteStmt) stmtNode() {}
+
+type Expr interface {
+	Node
+	exprNode()
+}
+
+type LiteralKind int
+
+const (
+	LiteralNumber LiteralKind = iota
+	LiteralString
+	LiteralBlob
+	LiteralNull
+	LiteralBool
+	LiteralCurrent // CURRENT_TIME / CURRENT_DATE / CURRENT_TIMESTAMP
+)
+
+type Literal struct {
+	Kind  LiteralKind
+	Value string
+	Span
+}
+
+// Param is a bind parameter (`?`, `?1`, `:name`, ...).
+type Param struct {
+	Text string
+	Span
+}
+
+// ColumnRef is `column`, `table.column` or `schema.table.column`.
+type ColumnRef struct {
+	Schema *Ident
+	Table  *Ident
+	Column *Ident
+	Span
+}
+
+type UnaryExpr struct {
+	Op string // "-", "+", "~", "NOT"
+	X  Expr
+	Span
+}
+
+type BinaryExpr struct {
+	Op string // 大文字の演算子（"AND", "=", "IS NOT", "LIKE" など）
+	X  Expr
+	Y  Expr
+	Span
+}
+
+// PostfixExpr is `x ISNULL`, `x NOTNULL` or `x NOT NULL`.
+type PostfixExpr struct {
+	Op string
+	X  Expr
+	Span
+}
+
+type LikeExpr struct {
+	Op      string // LIKE, GLOB, REGEXP, MATCH
+	Not     bool
+	X       Expr
+	Pattern Expr
+	Escape  Expr
+	Span
+}
+
+type BetweenExpr struct {
+	Not bool
+	X   Expr
+	Lo  Expr
+	Hi  Expr
+	Span
+}
+
+// InExpr is `x IN (list)`, `x IN (select)` or `x IN table`.
+type InExpr struct {
+	Not    bool
+	X      Expr
+	List   []Expr
+	Select *SelectStmt
+	Table  *Ident
+	Span
+}
+
+type CallExpr struct {
+	Name     *Ident
+	Distinct bool
+	Star     bool // COUNT(*)
+	Args     []Expr
+	Filter   Expr
+	Over     *WindowSpec
+	Span
+}
+
+// WindowSpec is an OVER clause. Frame specifications are skipped.
+type WindowSpec struct {
+	Name        *Ident
+	PartitionBy []Expr
+	OrderBy     []*OrderingTerm
+	Span
+}
+
+type CastExpr struct {
+	X    Expr
+	Type string
+	Span
+}
+
+type CaseExpr struct {
+	Operand Expr
+	Whens   []*When
+	Else    Expr
+	Span
+}
+
+type When struct {
+	Cond   Expr
+	Result Expr
+	Span
+}
+
+type ExistsExpr struct {
+	Not    bool
+	Select *SelectStmt
+	Span
+}
+
+type SubqueryExpr struct {
+	Select *SelectStmt
+	Span
+}
+
+// ParenExpr is `(x)` or a row value `(x, y)`.
+type ParenExpr struct {
+	List []Expr
+	Span
+}
+
+type CollateExpr struct {
+	X         Expr
+	Collation string
+	Span
+}
+
+// RaiseExpr is RAISE(...) inside triggers.
+type RaiseExpr struct {
+	Span
+}
+
+func (*Literal) exprNode()      {}
+func (*Param) exprNode()        {}
+func (*ColumnRef) exprNode()    {}
+func (*UnaryExpr) exprNode()    {}
+func (*BinaryExpr) exprNode()   {}
+func (*PostfixExpr) exprNode()  {}
+func (*LikeExpr) exprNode()     {}
+func (*BetweenExpr) exprNode()  {}
+func (*InExpr) exprNode()       {}
+func (*CallExpr) exprNode()     {}
+func (*CastExpr) exprNode()     {}
+func (*CaseExpr) exprNode()     {}
+func (*ExistsExpr) exprNode()   {}
+func (*SubqueryExpr) exprNode() {}
+func (*ParenExpr) exprNode()    {}
+func (*CollateExpr) exprNode()  {}
+func (*RaiseExpr) exprNode()    {}
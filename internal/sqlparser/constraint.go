@@ -0,0 +1,156 @@
+package sqlparser
+
+// ParseTableConstraint parses one table constraint of CREATE TABLE, such as
+// `UNIQUE (a, b)`, `CONSTRAINT name CHECK (x IN (1, 2))` or
+// `FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE`.
+// It returns nil without an error when src is a column definition.
+// The returned error is always an *Error.
+func ParseTableConstraint(src string) (con *TableConstraint, err error) {
+	toks, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	if !p.isTableConstraintStart() {
+		return nil, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			perr, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			con, err = nil, perr
+		}
+	}()
+	con = p.parseTableConstraint()
+	if p.cur().Kind != TokenEOF {
+		p.fail("near %q: syntax error", p.cur().Text)
+	}
+	return con, nil
+}
+
+// isTableConstraintStart はテーブル制約の先頭か。`unique TEXT` のような列定義と区別するため、
+// UNIQUE / CHECK は直後の `(`、PRIMARY / FOREIGN は直後の KEY も見る。
+func (p *parser) isTableConstraintStart() bool {
+	switch {
+	case p.isKeyword("CONSTRAINT"):
+		return true
+	case p.isKeyword("PRIMARY") || p.isKeyword("FOREIGN"):
+		return p.isKeywordAt(1, "KEY")
+	case p.isKeyword("UNIQUE") || p.isKeyword("CHECK"):
+		next := p.peek(1)
+		return next.Kind == TokenOp && next.Text == "("
+	}
+	return false
+}
+
+func (p *parser) parseTableConstraint() *TableConstraint {
+	con := &TableConstraint{Span: Span{Pos: p.cur().Pos}}
+	if p.acceptKeyword("CONSTRAINT") {
+		con.Name = p.parseIdent("constraint name")
+	}
+	switch {
+	case p.isKeyword("PRIMARY") || p.isKeyword("UNIQUE"):
+		if p.acceptKeyword("PRIMARY") {
+			p.expectKeyword("KEY")
+			con.Kind = "PRIMARY KEY"
+		} else {
+			p.next()
+			con.Kind = "UNIQUE"
+		}
+		p.expectOp("(")
+		con.Columns = p.parseIndexedColumns()
+		p.expectOp(")")
+		p.parseConflictClause()
+	case p.acceptKeyword("CHECK"):
+		con.Kind = "CHECK"
+		p.expectOp("(")
+		con.Check = p.parseExpr()
+		p.expectOp(")")
+	case p.acceptKeyword("FOREIGN"):
+		p.expectKeyword("KEY")
+		con.Kind = "FOREIGN KEY"
+		p.expectOp("(")
+		con.Columns = p.parseIdentList("column name")
+		p.expectOp(")")
+		p.expectKeyword("REFERENCES")
+		con.RefTable = p.parseIdent("table name")
+		if p.acceptOp("(") {
+			con.RefColumns = p.parseIdentList("column name")
+			p.expectOp(")")
+		}
+		p.parseForeignKeyClause()
+	default:
+		p.fail("near %q: syntax error, expected table constraint", p.cur().Text)
+	}
+	con.Span.End = p.prevEnd()
+	return con
+}
+
+// parseIndexedColumns は PRIMARY KEY / UNIQUE の `a COLLATE NOCASE DESC, b` を読み、列名を返す。
+func (p *parser) parseIndexedColumns() []*Ident {
+	var cols []*Ident
+	for {
+		col := p.parseIdent("column name")
+		if p.acceptKeyword("COLLATE") {
+			p.parseIdent("collation name")
+		}
+		if !p.acceptKeyword("ASC") {
+			p.acceptKeyword("DESC")
+		}
+		cols = append(cols, col)
+		if !p.acceptOp(",") {
+			return cols
+		}
+	}
+}
+
+// parseConflictClause は `ON CONFLICT ROLLBACK | ABORT | FAIL | IGNORE | REPLACE` を読み飛ばす。
+func (p *parser) parseConflictClause() {
+	if !p.acceptKeyword("ON") {
+		return
+	}
+	p.expectKeyword("CONFLICT")
+	for _, kw := range []string{"ROLLBACK", "ABORT", "FAIL", "IGNORE", "REPLACE"} {
+		if p.acceptKeyword(kw) {
+			return
+		}
+	}
+	p.fail("near %q: syntax error, expected conflict resolution", p.cur().Text)
+}
+
+// parseForeignKeyClause は REFERENCES の後ろの ON DELETE / ON UPDATE / MATCH / DEFERRABLE を読み飛ばす。
+func (p *parser) parseForeignKeyClause() {
+	for {
+		switch {
+		case p.acceptKeyword("ON"):
+			if !p.acceptKeyword("DELETE") {
+				p.expectKeyword("UPDATE")
+			}
+			switch {
+			case p.acceptKeyword("SET"):
+				if !p.acceptKeyword("NULL") {
+					p.expectKeyword("DEFAULT")
+				}
+			case p.acceptKeyword("CASCADE") || p.acceptKeyword("RESTRICT"):
+			case p.acceptKeyword("NO"):
+				p.expectKeyword("ACTION")
+			default:
+				p.fail("near %q: syntax error, expected foreign key action", p.cur().Text)
+			}
+		case p.acceptKeyword("MATCH"):
+			p.parseIdent("match name")
+		case p.isKeyword("DEFERRABLE") || (p.isKeyword("NOT") && p.isKeywordAt(1, "DEFERRABLE")):
+			p.acceptKeyword("NOT")
+			p.next()
+			if p.acceptKeyword("INITIALLY") {
+				if !p.acceptKeyword("DEFERRED") {
+					p.expectKeyword("IMMEDIATE")
+				}
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,1115 @@
+// Package sqlparser parses the SQLite dialect used inside execute / fetch blocks
+// so that the type checker can validate table and column references at compile time.
+package sqlparser
+
+import (
+	"strings"
+)
+
+// Parse parses every statement in src. Statements other than SELECT / VALUES / WITH /
+// INSERT / REPLACE / UPDATE / DELETE are returned as OtherStmt without being analyzed.
+// The returned error is always an *Error.
+func Parse(src string) (stmts []Stmt, err error) {
+	toks, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	defer func() {
+		if r := recover(); r != nil {
+			perr, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			stmts, err = nil, perr
+		}
+	}()
+	for p.cur().Kind != TokenEOF {
+		if p.acceptOp(";") {
+			continue
+		}
+		stmts = append(stmts, p.parseStmt())
+		if p.cur().Kind != TokenEOF && !p.isOp(";") {
+			p.fail("near %q: syntax error", p.cur().Text)
+		}
+	}
+	return stmts, nil
+}
+
+type parser struct {
+	toks []Token
+	pos  int
+}
+
+func (p *parser) cur() Token { return p.toks[p.pos] }
+
+func (p *parser) peek(n int) Token {
+	if p.pos+n >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.pos+n]
+}
+
+func (p *parser) next() Token {
+	tok := p.toks[p.pos]
+	if p.pos < len(p.toks)-1 {
+		p.pos++
+	}
+	return tok
+}
+
+// prevEnd は直前に読んだトークンの終端。
+func (p *parser) prevEnd() int {
+	if p.pos == 0 {
+		return 0
+	}
+	return p.toks[p.pos-1].End
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	tok := p.cur()
+	if tok.Kind == TokenEOF {
+		panic(errorAt(tok.Pos, tok.End, "incomplete input"))
+	}
+	panic(errorAt(tok.Pos, tok.End, format, args...))
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	tok := p.cur()
+	return tok.Kind == TokenIdent && strings.EqualFold(tok.Text, kw)
+}
+
+func (p *parser) isKeywordAt(n int, kw string) bool {
+	tok := p.peek(n)
+	return tok.Kind == TokenIdent && strings.EqualFold(tok.Text, kw)
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) Token {
+	if !p.isKeyword(kw) {
+		p.fail("near %q: syntax error, expected %s", p.cur().Text, kw)
+	}
+	return p.next()
+}
+
+func (p *parser) isOp(op string) bool {
+	tok := p.cur()
+	return tok.Kind == TokenOp && tok.Text == op
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if p.isOp(op) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectOp(op string) Token {
+	if !p.isOp(op) {
+		p.fail("near %q: syntax error, expected %q", p.cur().Text, op)
+	}
+	return p.next()
+}
+
+// reserved はエイリアスや識別子として解釈しないキーワード。
+var reserved = map[string]bool{
+	"ALL": true, "AND": true, "AS": true, "BETWEEN": true, "BY": true, "CASE": true,
+	"CAST": true, "COLLATE": true, "CROSS": true, "DEFAULT": true, "DELETE": true,
+	"DISTINCT": true, "ELSE": true, "END": true, "ESCAPE": true, "EXCEPT": true,
+	"EXISTS": true, "FILTER": true, "FROM": true, "FULL": true, "GLOB": true, "GROUP": true,
+	"HAVING": true, "IN": true, "INDEXED": true, "INNER": true, "INSERT": true, "INTERSECT": true,
+	"INTO": true, "IS": true, "ISNULL": true, "JOIN": true, "LEFT": true, "LIKE": true, "LIMIT": true,
+	"MATCH": true, "NATURAL": true, "NOT": true, "NOTNULL": true, "NULL": true,
+	"ON": true, "OR": true, "ORDER": true, "OUTER": true, "OVER": true, "REGEXP": true,
+	"RETURNING": true, "RIGHT": true, "SELECT": true, "SET": true, "THEN": true, "UNION": true,
+	"UPDATE": true, "USING": true, "VALUES": true, "WHEN": true, "WHERE": true, "WINDOW": true,
+	"WITH": true,
+}
+
+func isReserved(tok Token) bool {
+	return tok.Kind == TokenIdent && reserved[strings.ToUpper(tok.Text)]
+}
+
+// parseIdent は識別子を読む。what はエラーメッセージ用（"table name" など）。
+func (p *parser) parseIdent(what string) *Ident {
+	tok := p.cur()
+	switch {
+	case tok.Kind == TokenQuotedIdent:
+		p.next()
+		return &Ident{Name: tok.Value, Quote: tok.Text[0], Span: Span{tok.Pos, tok.End}}
+	case tok.Kind == TokenIdent && !isReserved(tok):
+		p.next()
+		return &Ident{Name: tok.Value, Span: Span{tok.Pos, tok.End}}
+	case tok.Kind == TokenString && what == "alias":
+		// SQLite は文字列リテラルのエイリアスも受け付ける
+		p.next()
+		return &Ident{Name: tok.Value, Quote: '\'', Span: Span{tok.Pos, tok.End}}
+	case tok.Kind == TokenParam:
+		panic(errorAt(tok.Pos, tok.End, "parameters can only be used as values, not as %s", what))
+	}
+	p.fail("near %q: syntax error, expected %s", tok.Text, what)
+	return nil
+}
+
+func (p *parser) isIdentStart() bool {
+	tok := p.cur()
+	return tok.Kind == TokenQuotedIdent || (tok.Kind == TokenIdent && !isReserved(tok))
+}
+
+func (p *parser) parseStmt() Stmt {
+	start := p.cur().Pos
+	var with *With
+	if p.isKeyword("WITH") {
+		with = p.parseWith()
+	}
+	tok := p.cur()
+	switch {
+	case p.isKeyword("SELECT") || p.isKeyword("VALUES"):
+		sel := p.parseSelectBody(with, start)
+		return sel
+	case p.isKeyword("INSERT") || p.isKeyword("REPLACE"):
+		return p.parseInsert(with, start)
+	case p.isKeyword("UPDATE"):
+		return p.parseUpdate(with, start)
+	case p.isKeyword("DELETE"):
+		return p.parseDelete(with, start)
+	case with != nil:
+		p.fail("near %q: syntax error", tok.Text)
+	}
+	// 解析対象外の文は末尾まで読み飛ばす（CREATE TRIGGER などは本体に ; を含むため）
+	for p.cur().Kind != TokenEOF {
+		p.next()
+	}
+	return &OtherStmt{Keyword: strings.ToUpper(tok.Text), Span: Span{start, p.prevEnd()}}
+}
+
+func (p *parser) parseWith() *With {
+	start := p.expectKeyword("WITH").Pos
+	with := &With{Recursive: p.acceptKeyword("RECURSIVE")}
+	for {
+		cteStart := p.cur().Pos
+		cte := &CTE{Name: p.parseIdent("table name")}
+		if p.acceptOp("(") {
+			cte.Columns = p.parseIdentList("column name")
+			p.expectOp(")")
+		}
+		p.expectKeyword("AS")
+		if p.acceptKeyword("NOT") {
+			p.expectKeyword("MATERIALIZED")
+		} else {
+			p.acceptKeyword("MATERIALIZED")
+		}
+		p.expectOp("(")
+		cte.Select = p.parseSelect()
+		p.expectOp(")")
+		cte.Span = Span{cteStart, p.prevEnd()}
+		with.CTEs = append(with.CTEs, cte)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	with.Span = Span{start, p.prevEnd()}
+	return with
+}
+
+func (p *parser) parseIdentList(what string) []*Ident {
+	var idents []*Ident
+	for {
+		idents = append(idents, p.parseIdent(what))
+		if !p.acceptOp(",") {
+			return idents
+		}
+	}
+}
+
+// parseSelect parses [WITH ...] SELECT ...
+func (p *parser) parseSelect() *SelectStmt {
+	start := p.cur().Pos
+	var with *With
+	if p.isKeyword("WITH") {
+		with = p.parseWith()
+	}
+	return p.parseSelectBody(with, start)
+}
+
+func (p *parser) parseSelectBody(with *With, start int) *SelectStmt {
+	sel := &SelectStmt{With: with}
+	compound := ""
+	for {
+		core := p.parseSelectCore()
+		core.Compound = compound
+		sel.Cores = append(sel.Cores, core)
+		switch {
+		case p.isKeyword("UNION"):
+			p.next()
+			compound = "UNION"
+			if p.acceptKeyword("ALL") {
+				compound = "UNION ALL"
+			}
+		case p.isKeyword("INTERSECT"), p.isKeyword("EXCEPT"):
+			compound = strings.ToUpper(p.next().Text)
+		default:
+			compound = ""
+		}
+		if compound == "" {
+			break
+		}
+	}
+	if p.isKeyword("ORDER") {
+		sel.OrderBy = p.parseOrderBy()
+	}
+	if p.isKeyword("LIMIT") {
+		sel.Limit, sel.Offset = p.parseLimit()
+	}
+	sel.Span = Span{start, p.prevEnd()}
+	return sel
+}
+
+func (p *parser) parseOrderBy() []*OrderingTerm {
+	p.expectKeyword("ORDER")
+	p.expectKeyword("BY")
+	var terms []*OrderingTerm
+	for {
+		start := p.cur().Pos
+		term := &OrderingTerm{Expr: p.parseExpr()}
+		if p.acceptKeyword("DESC") {
+			term.Desc = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+		if p.acceptKeyword("NULLS") {
+			if !p.acceptKeyword("FIRST") {
+				p.expectKeyword("LAST")
+			}
+		}
+		term.Span = Span{start, p.prevEnd()}
+		terms = append(terms, term)
+		if !p.acceptOp(",") {
+			return terms
+		}
+	}
+}
+
+func (p *parser) parseLimit() (limit Expr, offset Expr) {
+	p.expectKeyword("LIMIT")
+	limit = p.parseExpr()
+	if p.acceptKeyword("OFFSET") {
+		offset = p.parseExpr()
+	} else if p.acceptOp(",") {
+		// LIMIT offset, count
+		offset, limit = limit, p.parseExpr()
+	}
+	return limit, offset
+}
+
+func (p *parser) parseSelectCore() *SelectCore {
+	start := p.cur().Pos
+	core := &SelectCore{}
+	if p.acceptKeyword("VALUES") {
+		for {
+			rowStart := p.expectOp("(").Pos
+			row := &ValuesRow{Exprs: p.parseExprList()}
+			p.expectOp(")")
+			row.Span = Span{rowStart, p.prevEnd()}
+			core.Values = append(core.Values, row)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		core.Span = Span{start, p.prevEnd()}
+		return core
+	}
+	p.expectKeyword("SELECT")
+	if p.acceptKeyword("DISTINCT") {
+		core.Distinct = true
+	} else {
+		p.acceptKeyword("ALL")
+	}
+	core.Columns = p.parseResultColumns()
+	if p.acceptKeyword("FROM") {
+		core.From = p.parseFrom()
+	}
+	if p.acceptKeyword("WHERE") {
+		core.Where = p.parseExpr()
+	}
+	if p.isKeyword("GROUP") {
+		p.next()
+		p.expectKeyword("BY")
+		core.GroupBy = p.parseExprList()
+	}
+	if p.acceptKeyword("HAVING") {
+		core.Having = p.parseExpr()
+	}
+	if p.acceptKeyword("WINDOW") {
+		for {
+			p.parseIdent("window name")
+			p.expectKeyword("AS")
+			p.parseWindowDef()
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	core.Span = Span{start, p.prevEnd()}
+	return core
+}
+
+func (p *parser) parseResultColumns() []*ResultColumn {
+	var cols []*ResultColumn
+	for {
+		cols = append(cols, p.parseResultColumn())
+		if !p.acceptOp(",") {
+			return cols
+		}
+	}
+}
+
+func (p *parser) parseResultColumn() *ResultColumn {
+	start := p.cur().Pos
+	if p.acceptOp("*") {
+		return &ResultColumn{Star: true, Span: Span{start, p.prevEnd()}}
+	}
+	if p.isIdentStart() && p.peek(1).Kind == TokenOp && p.peek(1).Text == "." &&
+		p.peek(2).Kind == TokenOp && p.peek(2).Text == "*" {
+		table := p.parseIdent("table name")
+		p.next()
+		p.next()
+		return &ResultColumn{Star: true, Table: table, Span: Span{start, p.prevEnd()}}
+	}
+	col := &ResultColumn{Expr: p.parseExpr()}
+	if p.acceptKeyword("AS") {
+		col.Alias = p.parseIdent("alias")
+	} else if p.isIdentStart() || p.cur().Kind == TokenString {
+		col.Alias = p.parseIdent("alias")
+	}
+	col.Span = Span{start, p.prevEnd()}
+	return col
+}
+
+// parseFrom parses a FROM clause into a flat list of table references.
+func (p *parser) parseFrom() []*TableRef {
+	refs := []*TableRef{p.parseTableRef(nil)}
+	for {
+		join := p.parseJoinOp()
+		if join == nil {
+			return refs
+		}
+		ref := p.parseTableRef(join)
+		if p.acceptKeyword("ON") {
+			join.On = p.parseExpr()
+		} else if p.acceptKeyword("USING") {
+			p.expectOp("(")
+			join.Using = p.parseIdentList("column name")
+			p.expectOp(")")
+		}
+		join.Span.End = p.prevEnd()
+		ref.Span.End = p.prevEnd()
+		refs = append(refs, ref)
+	}
+}
+
+func (p *parser) parseJoinOp() *Join {
+	start := p.cur().Pos
+	startIdx := p.pos
+	if p.acceptOp(",") {
+		return &Join{Op: ",", Span: Span{start, p.prevEnd()}}
+	}
+	join := &Join{}
+	if p.acceptKeyword("NATURAL") {
+		join.Natural = true
+	}
+	switch {
+	case p.acceptKeyword("LEFT"):
+		join.Op = "LEFT JOIN"
+		p.acceptKeyword("OUTER")
+	case p.acceptKeyword("RIGHT"):
+		join.Op = "RIGHT JOIN"
+		p.acceptKeyword("OUTER")
+	case p.acceptKeyword("FULL"):
+		join.Op = "FULL JOIN"
+		p.acceptKeyword("OUTER")
+	case p.acceptKeyword("INNER"):
+		join.Op = "INNER JOIN"
+	case p.acceptKeyword("CROSS"):
+		join.Op = "CROSS JOIN"
+	default:
+		join.Op = "JOIN"
+	}
+	if !p.isKeyword("JOIN") {
+		if p.pos != startIdx {
+			p.fail("near %q: syntax error, expected JOIN", p.cur().Text)
+		}
+		return nil
+	}
+	p.next()
+	join.Span = Span{start, p.prevEnd()}
+	return join
+}
+
+func (p *parser) parseTableRef(join *Join) *TableRef {
+	start := p.cur().Pos
+	if join != nil {
+		start = join.Pos
+	}
+	ref := &TableRef{Join: join}
+	if p.acceptOp("(") {
+		if p.isKeyword("SELECT") || p.isKeyword("WITH") || p.isKeyword("VALUES") {
+			ref.Subquery = p.parseSelect()
+		} else {
+			ref.Group = p.parseFrom()
+		}
+		p.expectOp(")")
+	} else {
+		name := p.parseIdent("table name")
+		if p.acceptOp(".") {
+			ref.Schema = name
+			name = p.parseIdent("table name")
+		}
+		ref.Name = name
+		if p.acceptOp("(") {
+			ref.IsFunc = true
+			if !p.isOp(")") {
+				ref.Args = p.parseExprList()
+			}
+			p.expectOp(")")
+		}
+	}
+	if p.acceptKeyword("AS") {
+		ref.Alias = p.parseIdent("alias")
+	} else if p.isIdentStart() {
+		ref.Alias = p.parseIdent("alias")
+	}
+	if p.acceptKeyword("INDEXED") {
+		p.expectKeyword("BY")
+		p.parseIdent("index name")
+	} else if p.isKeyword("NOT") && p.isKeywordAt(1, "INDEXED") {
+		p.next()
+		p.next()
+	}
+	ref.Span = Span{start, p.prevEnd()}
+	return ref
+}
+
+func (p *parser) parseQualifiedTable() (schema, table, alias *Ident) {
+	table = p.parseIdent("table name")
+	if p.acceptOp(".") {
+		schema = table
+		table = p.parseIdent("table name")
+	}
+	if p.acceptKeyword("AS") {
+		alias = p.parseIdent("alias")
+	}
+	return schema, table, alias
+}
+
+func (p *parser) parseConflictOr() string {
+	if !p.acceptKeyword("OR") {
+		return ""
+	}
+	tok := p.cur()
+	switch strings.ToUpper(tok.Text) {
+	case "ROLLBACK", "ABORT", "REPLACE", "FAIL", "IGNORE":
+		p.next()
+		return strings.ToUpper(tok.Text)
+	}
+	p.fail("near %q: syntax error", tok.Text)
+	return ""
+}
+
+func (p *parser) parseInsert(with *With, start int) *InsertStmt {
+	stmt := &InsertStmt{With: with}
+	if p.acceptKeyword("REPLACE") {
+		stmt.Or = "REPLACE"
+	} else {
+		p.expectKeyword("INSERT")
+		stmt.Or = p.parseConflictOr()
+	}
+	p.expectKeyword("INTO")
+	stmt.Schema, stmt.Table, stmt.Alias = p.parseQualifiedTable()
+	if p.acceptOp("(") {
+		stmt.Columns = p.parseIdentList("column name")
+		p.expectOp(")")
+	}
+	switch {
+	case p.isKeyword("DEFAULT"):
+		p.next()
+		p.expectKeyword("VALUES")
+		stmt.DefaultValues = true
+	case p.isKeyword("VALUES"), p.isKeyword("SELECT"), p.isKeyword("WITH"):
+		stmt.Select = p.parseSelect()
+	default:
+		p.fail("near %q: syntax error, expected VALUES or SELECT", p.cur().Text)
+	}
+	for p.isKeyword("ON") && p.isKeywordAt(1, "CONFLICT") {
+		stmt.Upserts = append(stmt.Upserts, p.parseUpsert())
+	}
+	if p.acceptKeyword("RETURNING") {
+		stmt.Returning = p.parseResultColumns()
+	}
+	stmt.Span = Span{start, p.prevEnd()}
+	return stmt
+}
+
+func (p *parser) parseUpsert() *Upsert {
+	start := p.cur().Pos
+	p.next()
+	p.next()
+	up := &Upsert{}
+	if p.acceptOp("(") {
+		for {
+			up.Target = append(up.Target, p.parseExpr())
+			if p.acceptKeyword("COLLATE") {
+				p.parseIdent("collation name")
+			}
+			if !p.acceptKeyword("ASC") {
+				p.acceptKeyword("DESC")
+			}
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		p.expectOp(")")
+		if p.acceptKeyword("WHERE") {
+			up.TargetWhere = p.parseExpr()
+		}
+	}
+	p.expectKeyword("DO")
+	if p.acceptKeyword("NOTHING") {
+		up.DoNothing = true
+	} else {
+		p.expectKeyword("UPDATE")
+		p.expectKeyword("SET")
+		up.Set = p.parseAssignments()
+		if p.acceptKeyword("WHERE") {
+			up.Where = p.parseExpr()
+		}
+	}
+	up.Span = Span{start, p.prevEnd()}
+	return up
+}
+
+func (p *parser) parseAssignments() []*Assignment {
+	var list []*Assignment
+	for {
+		start := p.cur().Pos
+		a := &Assignment{}
+		if p.acceptOp("(") {
+			a.Columns = p.parseIdentList("column name")
+			p.expectOp(")")
+		} else {
+			a.Columns = []*Ident{p.parseIdent("column name")}
+		}
+		p.expectOp("=")
+		a.Value = p.parseExpr()
+		a.Span = Span{start, p.prevEnd()}
+		list = append(list, a)
+		if !p.acceptOp(",") {
+			return list
+		}
+	}
+}
+
+func (p *parser) parseUpdate(with *With, start int) *UpdateStmt {
+	p.expectKeyword("UPDATE")
+	stmt := &UpdateStmt{With: with, Or: p.parseConflictOr()}
+	stmt.Schema, stmt.Table, stmt.Alias = p.parseQualifiedTable()
+	p.expectKeyword("SET")
+	stmt.Set = p.parseAssignments()
+	if p.acceptKeyword("FROM") {
+		stmt.From = p.parseFrom()
+	}
+	if p.acceptKeyword("WHERE") {
+		stmt.Where = p.parseExpr()
+	}
+	if p.acceptKeyword("RETURNING") {
+		stmt.Returning = p.parseResultColumns()
+	}
+	if p.isKeyword("ORDER") {
+		stmt.OrderBy = p.parseOrderBy()
+	}
+	if p.isKeyword("LIMIT") {
+		stmt.Limit, stmt.Offset = p.parseLimit()
+	}
+	stmt.Span = Span{start, p.prevEnd()}
+	return stmt
+}
+
+func (p *parser) parseDelete(with *With, start int) *DeleteStmt {
+	p.expectKeyword("DELETE")
+	p.expectKeyword("FROM")
+	stmt := &DeleteStmt{With: with}
+	stmt.Schema, stmt.Table, stmt.Alias = p.parseQualifiedTable()
+	if p.acceptKeyword("WHERE") {
+		stmt.Where = p.parseExpr()
+	}
+	if p.acceptKeyword("RETURNING") {
+		stmt.Returning = p.parseResultColumns()
+	}
+	if p.isKeyword("ORDER") {
+		stmt.OrderBy = p.parseOrderBy()
+	}
+	if p.isKeyword("LIMIT") {
+		stmt.Limit, stmt.Offset = p.parseLimit()
+	}
+	stmt.Span = Span{start, p.prevEnd()}
+	return stmt
+}
+
+func (p *parser) parseExprList() []Expr {
+	var list []Expr
+	for {
+		list = append(list, p.parseExpr())
+		if !p.acceptOp(",") {
+			return list
+		}
+	}
+}
+
+// Expressions, from lowest to highest precedence:
+//
+//	OR
+//	AND
+//	NOT
+//	= == != <> IS [NOT] IN LIKE GLOB MATCH REGEXP BETWEEN ISNULL NOTNULL
+//	< <= > >=
+//	& | << >>
+//	+ -
+//	* / %
+//	|| -> ->>
+//	unary - + ~, COLLATE
+func (p *parser) parseExpr() Expr {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() Expr {
+	x := p.parseAnd()
+	for p.isKeyword("OR") {
+		p.next()
+		y := p.parseAnd()
+		x = &BinaryExpr{Op: "OR", X: x, Y: y, Span: Span{x.GetSpan().Pos, p.prevEnd()}}
+	}
+	return x
+}
+
+func (p *parser) parseAnd() Expr {
+	x := p.parseNot()
+	for p.isKeyword("AND") {
+		p.next()
+		y := p.parseNot()
+		x = &BinaryExpr{Op: "AND", X: x, Y: y, Span: Span{x.GetSpan().Pos, p.prevEnd()}}
+	}
+	return x
+}
+
+func (p *parser) parseNot() Expr {
+	if p.isKeyword("NOT") && !p.isKeywordAt(1, "EXISTS") {
+		start := p.next().Pos
+		x := p.parseNot()
+		return &UnaryExpr{Op: "NOT", X: x, Span: Span{start, p.prevEnd()}}
+	}
+	return p.parseEquality()
+}
+
+func (p *parser) parseEquality() Expr {
+	x := p.parseComparison()
+	for {
+		start := x.GetSpan().Pos
+		switch {
+		case p.isOp("=") || p.isOp("==") || p.isOp("!=") || p.isOp("<>"):
+			op := p.next().Text
+			y := p.parseComparison()
+			x = &BinaryExpr{Op: op, X: x, Y: y, Span: Span{start, p.prevEnd()}}
+		case p.isKeyword("IS"):
+			p.next()
+			op := "IS"
+			if p.acceptKeyword("NOT") {
+				op = "IS NOT"
+			}
+			if p.acceptKeyword("DISTINCT") {
+				p.expectKeyword("FROM")
+				if op == "IS" {
+					op = "IS DISTINCT FROM"
+				} else {
+					op = "IS NOT DISTINCT FROM"
+				}
+			}
+			y := p.parseComparison()
+			x = &BinaryExpr{Op: op, X: x, Y: y, Span: Span{start, p.prevEnd()}}
+		case p.isKeyword("ISNULL") || p.isKeyword("NOTNULL"):
+			op := strings.ToUpper(p.next().Text)
+			x = &PostfixExpr{Op: op, X: x, Span: Span{start, p.prevEnd()}}
+		case p.isKeyword("NOT") && p.isKeywordAt(1, "NULL"):
+			p.next()
+			p.next()
+			x = &PostfixExpr{Op: "NOT NULL", X: x, Span: Span{start, p.prevEnd()}}
+		default:
+			not := p.isKeyword("NOT")
+			n := 0
+			if not {
+				n = 1
+			}
+			switch {
+			case p.isKeywordAt(n, "IN"):
+				p.pos += n + 1
+				x = p.parseInRest(x, not)
+			case p.isKeywordAt(n, "BETWEEN"):
+				p.pos += n + 1
+				lo := p.parseComparison()
+				p.expectKeyword("AND")
+				hi := p.parseComparison()
+				x = &BetweenExpr{Not: not, X: x, Lo: lo, Hi: hi, Span: Span{start, p.prevEnd()}}
+			case p.isKeywordAt(n, "LIKE") || p.isKeywordAt(n, "GLOB") || p.isKeywordAt(n, "REGEXP") || p.isKeywordAt(n, "MATCH"):
+				p.pos += n
+				op := strings.ToUpper(p.next().Text)
+				pattern := p.parseComparison()
+				like := &LikeExpr{Op: op, Not: not, X: x, Pattern: pattern}
+				if p.acceptKeyword("ESCAPE") {
+					like.Escape = p.parseComparison()
+				}
+				like.Span = Span{start, p.prevEnd()}
+				x = like
+			default:
+				return x
+			}
+		}
+	}
+}
+
+func (p *parser) parseInRest(x Expr, not bool) Expr {
+	start := x.GetSpan().Pos
+	in := &InExpr{Not: not, X: x}
+	if p.acceptOp("(") {
+		switch {
+		case p.isOp(")"):
+		case p.isKeyword("SELECT") || p.isKeyword("WITH") || p.isKeyword("VALUES"):
+			in.Select = p.parseSelect()
+		default:
+			in.List = p.parseExprList()
+		}
+		p.expectOp(")")
+	} else {
+		table := p.parseIdent("table name")
+		if p.acceptOp(".") {
+			table = p.parseIdent("table name")
+		}
+		in.Table = table
+		if p.acceptOp("(") {
+			if !p.isOp(")") {
+				in.List = p.parseExprList()
+			}
+			p.expectOp(")")
+		}
+	}
+	in.Span = Span{start, p.prevEnd()}
+	return in
+}
+
+func (p *parser) parseBinaryLevel(ops []string, next func() Expr) Expr {
+	x := next()
+	for {
+		matched := ""
+		for _, op := range ops {
+			if p.isOp(op) {
+				matched = op
+				break
+			}
+		}
+		if matched == "" {
+			return x
+		}
+		p.next()
+		y := next()
+		x = &BinaryExpr{Op: matched, X: x, Y: y, Span: Span{x.GetSpan().Pos, p.prevEnd()}}
+	}
+}
+
+func (p *parser) parseComparison() Expr {
+	return p.parseBinaryLevel([]string{"<=", ">=", "<", ">"}, p.parseBitwise)
+}
+
+func (p *parser) parseBitwise() Expr {
+	return p.parseBinaryLevel([]string{"<<", ">>", "&", "|"}, p.parseAdditive)
+}
+
+func (p *parser) parseAdditive() Expr {
+	return p.parseBinaryLevel([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *parser) parseMultiplicative() Expr {
+	return p.parseBinaryLevel([]string{"*", "/", "%"}, p.parseConcat)
+}
+
+func (p *parser) parseConcat() Expr {
+	return p.parseBinaryLevel([]string{"||", "->>", "->"}, p.parseUnary)
+}
+
+func (p *parser) parseUnary() Expr {
+	if p.isOp("-") || p.isOp("+") || p.isOp("~") {
+		tok := p.next()
+		x := p.parseUnary()
+		return &UnaryExpr{Op: tok.Text, X: x, Span: Span{tok.Pos, p.prevEnd()}}
+	}
+	x := p.parsePrimary()
+	for p.isKeyword("COLLATE") {
+		p.next()
+		name := p.parseIdent("collation name")
+		x = &CollateExpr{X: x, Collation: name.Name, Span: Span{x.GetSpan().Pos, p.prevEnd()}}
+	}
+	return x
+}
+
+func (p *parser) parsePrimary() Expr {
+	tok := p.cur()
+	span := Span{tok.Pos, tok.End}
+	switch tok.Kind {
+	case TokenNumber:
+		p.next()
+		return &Literal{Kind: LiteralNumber, Value: tok.Text, Span: span}
+	case TokenString:
+		p.next()
+		return &Literal{Kind: LiteralString, Value: tok.Value, Span: span}
+	case TokenBlob:
+		p.next()
+		return &Literal{Kind: LiteralBlob, Value: tok.Value, Span: span}
+	case TokenParam:
+		p.next()
+		return &Param{Text: tok.Text, Span: span}
+	case TokenOp:
+		if tok.Text == "(" {
+			p.next()
+			if p.isKeyword("SELECT") || p.isKeyword("WITH") || p.isKeyword("VALUES") {
+				sel := p.parseSelect()
+				p.expectOp(")")
+				return &SubqueryExpr{Select: sel, Span: Span{tok.Pos, p.prevEnd()}}
+			}
+			list := p.parseExprList()
+			p.expectOp(")")
+			return &ParenExpr{List: list, Span: Span{tok.Pos, p.prevEnd()}}
+		}
+	case TokenQuotedIdent:
+		return p.parseColumnRef()
+	case TokenIdent:
+		upper := strings.ToUpper(tok.Text)
+		switch upper {
+		case "NULL":
+			p.next()
+			return &Literal{Kind: LiteralNull, Value: upper, Span: span}
+		case "TRUE", "FALSE":
+			if !p.isCallStart() {
+				p.next()
+				return &Literal{Kind: LiteralBool, Value: upper, Span: span}
+			}
+		case "CURRENT_TIME", "CURRENT_DATE", "CURRENT_TIMESTAMP":
+			p.next()
+			return &Literal{Kind: LiteralCurrent, Value: upper, Span: span}
+		case "CAST":
+			return p.parseCast()
+		case "CASE":
+			return p.parseCase()
+		case "EXISTS", "NOT":
+			if upper == "NOT" && !p.isKeywordAt(1, "EXISTS") {
+				break
+			}
+			not := p.acceptKeyword("NOT")
+			p.expectKeyword("EXISTS")
+			p.expectOp("(")
+			sel := p.parseSelect()
+			p.expectOp(")")
+			return &ExistsExpr{Not: not, Select: sel, Span: Span{tok.Pos, p.prevEnd()}}
+		case "RAISE":
+			if p.isCallStart() {
+				p.next()
+				p.skipParens()
+				return &RaiseExpr{Span: Span{tok.Pos, p.prevEnd()}}
+			}
+		}
+		if p.isCallStart() {
+			return p.parseCall()
+		}
+		if !isReserved(tok) {
+			return p.parseColumnRef()
+		}
+	}
+	p.fail("near %q: syntax error", tok.Text)
+	return nil
+}
+
+// isCallStart は現在のトークンが関数呼び出し `name(` の先頭かどうか。
+// 予約語でも replace(...) や like(...) のように関数名として使える。
+func (p *parser) isCallStart() bool {
+	next := p.peek(1)
+	return p.cur().Kind == TokenIdent && next.Kind == TokenOp && next.Text == "("
+}
+
+func (p *parser) skipParens() {
+	p.expectOp("(")
+	depth := 1
+	for depth > 0 {
+		tok := p.next()
+		switch {
+		case tok.Kind == TokenEOF:
+			p.fail("incomplete input")
+		case tok.Kind == TokenOp && tok.Text == "(":
+			depth++
+		case tok.Kind == TokenOp && tok.Text == ")":
+			depth--
+		}
+	}
+}
+
+func (p *parser) parseColumnRef() Expr {
+	start := p.cur().Pos
+	ref := &ColumnRef{Column: p.parseIdent("column name")}
+	if p.acceptOp(".") {
+		ref.Table, ref.Column = ref.Column, p.parseIdent("column name")
+		if p.acceptOp(".") {
+			ref.Schema, ref.Table, ref.Column = ref.Table, ref.Column, p.parseIdent("column name")
+		}
+	}
+	ref.Span = Span{start, p.prevEnd()}
+	return ref
+}
+
+func (p *parser) parseCall() Expr {
+	tok := p.next()
+	call := &CallExpr{Name: &Ident{Name: tok.Value, Span: Span{tok.Pos, tok.End}}}
+	p.expectOp("(")
+	switch {
+	case p.acceptOp("*"):
+		call.Star = true
+	case p.isOp(")"):
+	default:
+		if p.acceptKeyword("DISTINCT") {
+			call.Distinct = true
+		} else {
+			p.acceptKeyword("ALL")
+		}
+		call.Args = p.parseExprList()
+		if p.isKeyword("ORDER") {
+			// 集約関数の ORDER BY（group_concat(x ORDER BY y)）
+			p.parseOrderBy()
+		}
+	}
+	p.expectOp(")")
+	if p.isKeyword("FILTER") {
+		p.next()
+		p.expectOp("(")
+		p.expectKeyword("WHERE")
+		call.Filter = p.parseExpr()
+		p.expectOp(")")
+	}
+	if p.isKeyword("OVER") {
+		overStart := p.next().Pos
+		if p.isOp("(") {
+			call.Over = p.parseWindowDef()
+			call.Over.Pos = overStart
+		} else {
+			call.Over = &WindowSpec{Name: p.parseIdent("window name"), Span: Span{overStart, p.prevEnd()}}
+		}
+	}
+	call.Span = Span{tok.Pos, p.prevEnd()}
+	return call
+}
+
+func (p *parser) parseWindowDef() *WindowSpec {
+	start := p.expectOp("(").Pos
+	win := &WindowSpec{}
+	if p.isIdentStart() && !p.isKeyword("PARTITION") && !p.isKeyword("ORDER") &&
+		!p.isKeyword("RANGE") && !p.isKeyword("ROWS") && !p.isKeyword("GROUPS") {
+		win.Name = p.parseIdent("window name")
+	}
+	if p.acceptKeyword("PARTITION") {
+		p.expectKeyword("BY")
+		win.PartitionBy = p.parseExprList()
+	}
+	if p.isKeyword("ORDER") {
+		win.OrderBy = p.parseOrderBy()
+	}
+	// フレーム指定（ROWS BETWEEN ... など）は列参照を含まないので読み飛ばす
+	depth := 0
+	for !(depth == 0 && p.isOp(")")) {
+		tok := p.next()
+		switch {
+		case tok.Kind == TokenEOF:
+			p.fail("incomplete input")
+		case tok.Kind == TokenOp && tok.Text == "(":
+			depth++
+		case tok.Kind == TokenOp && tok.Text == ")":
+			depth--
+		}
+	}
+	p.expectOp(")")
+	win.Span = Span{start, p.prevEnd()}
+	return win
+}
+
+func (p *parser) parseCast() Expr {
+	start := p.expectKeyword("CAST").Pos
+	p.expectOp("(")
+	x := p.parseExpr()
+	p.expectKeyword("AS")
+	typeName := p.parseTypeName()
+	p.expectOp(")")
+	return &CastExpr{X: x, Type: typeName, Span: Span{start, p.prevEnd()}}
+}
+
+// parseTypeName は `VARCHAR(255)` や `DOUBLE PRECISION` のような型名を読む。
+func (p *parser) parseTypeName() string {
+	var words []string
+	for p.cur().Kind == TokenIdent || p.cur().Kind == TokenQuotedIdent {
+		words = append(words, p.next().Value)
+	}
+	if len(words) == 0 {
+		p.fail("near %q: syntax error, expected type name", p.cur().Text)
+	}
+	if p.acceptOp("(") {
+		p.acceptOp("+")
+		p.acceptOp("-")
+		if p.cur().Kind != TokenNumber {
+			p.fail("near %q: syntax error, expected number", p.cur().Text)
+		}
+		p.next()
+		if p.acceptOp(",") {
+			p.acceptOp("+")
+			p.acceptOp("-")
+			if p.cur().Kind != TokenNumber {
+				p.fail("near %q: syntax error, expected number", p.cur().Text)
+			}
+			p.next()
+		}
+		p.expectOp(")")
+	}
+	return strings.Join(words, " ")
+}
+
+func (p *parser) parseCase() Expr {
+	start := p.expectKeyword("CASE").Pos
+	c := &CaseExpr{}
+	if !p.isKeyword("WHEN") {
+		c.Operand = p.parseExpr()
+	}
+	for p.isKeyword("WHEN") {
+		whenStart := p.next().Pos
+		cond := p.parseExpr()
+		p.expectKeyword("THEN")
+		result := p.parseExpr()
+		c.Whens = append(c.Whens, &When{Cond: cond, Result: result, Span: Span{whenStart, p.prevEnd()}})
+	}
+	if len(c.Whens) == 0 {
+		p.fail("near %q: syntax error, expected WHEN", p.cur().Text)
+	}
+	if p.acceptKeyword("ELSE") {
+		c.Else = p.parseExpr()
+	}
+	p.expectKeyword("END")
+	c.Span = Span{start, p.prevEnd()}
+	return c
+}
@@ -0,0 +1,318 @@
+package sqlparser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func mustParseSelect(t *testing.T, src string) *SelectStmt {
+	t.Helper()
+	stmts, err := Parse(src)
+	if err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	if len(stmts) != 1 {
+		t.Fatalf("parse %q: got %d statements, want 1", src, len(stmts))
+	}
+	sel, ok := stmts[0].(*SelectStmt)
+	if !ok {
+		t.Fatalf("parse %q: got %T, want *SelectStmt", src, stmts[0])
+	}
+	return sel
+}
+
+// sexpr は式の木を括弧付きの文字列にして、結合の仕方を比べられるようにする。
+func sexpr(e Expr) string {
+	switch e := e.(type) {
+	case *Literal:
+		return e.Value
+	case *ColumnRef:
+		if e.Table != nil {
+			return e.Table.Name + "." + e.Column.Name
+		}
+		return e.Column.Name
+	case *UnaryExpr:
+		return fmt.Sprintf("(%s %s)", e.Op, sexpr(e.X))
+	case *BinaryExpr:
+		return fmt.Sprintf("(%s %s %s)", e.Op, sexpr(e.X), sexpr(e.Y))
+	case *PostfixExpr:
+		return fmt.Sprintf("(%s %s)", e.Op, sexpr(e.X))
+	case *BetweenExpr:
+		return fmt.Sprintf("(BETWEEN %s %s %s)", sexpr(e.X), sexpr(e.Lo), sexpr(e.Hi))
+	case *LikeExpr:
+		return fmt.Sprintf("(%s %s %s)", e.Op, sexpr(e.X), sexpr(e.Pattern))
+	case *InExpr:
+		parts := make([]string, len(e.List))
+		for i, x := range e.List {
+			parts[i] = sexpr(x)
+		}
+		if e.Select != nil {
+			return fmt.Sprintf("(IN %s select)", sexpr(e.X))
+		}
+		return fmt.Sprintf("(IN %s [%s])", sexpr(e.X), strings.Join(parts, " "))
+	case *CallExpr:
+		if e.Star {
+			return e.Name.Name + "(*)"
+		}
+		parts := make([]string, len(e.Args))
+		for i, x := range e.Args {
+			parts[i] = sexpr(x)
+		}
+		return e.Name.Name + "(" + strings.Join(parts, " ") + ")"
+	case *ParenExpr:
+		parts := make([]string, len(e.List))
+		for i, x := range e.List {
+			parts[i] = sexpr(x)
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	case *CollateExpr:
+		return fmt.Sprintf("(COLLATE %s %s)", sexpr(e.X), e.Collation)
+	}
+	return fmt.Sprintf("<%T>", e)
+}
+
+func TestParseExprPrecedence(t *testing.T) {
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"1 + 2 * 3", "(+ 1 (* 2 3))"},
+		{"1 - 2 - 3", "(- (- 1 2) 3)"},
+		{"a OR b AND c", "(OR a (AND b c))"},
+		{"NOT a = b", "(NOT (= a b))"},
+		{"a = b < c", "(= a (< b c))"},
+		{"a || b * c", "(* (|| a b) c)"},
+		{"-a * b", "(* (- a) b)"},
+		{"a IS NOT NULL AND b", "(AND (IS NOT a NULL) b)"},
+		{"a NOT NULL OR b ISNULL", "(OR (NOT NULL a) (ISNULL b))"},
+		{"x BETWEEN 1 AND 2 AND y", "(AND (BETWEEN x 1 2) y)"},
+		{"name LIKE 'a%' OR x IN (1, 2 + 3)", "(OR (LIKE name a%) (IN x [1 (+ 2 3)]))"},
+		{"(a + b) * c", "(* [(+ a b)] c)"},
+		{"name COLLATE NOCASE = 'x'", "(= (COLLATE name NOCASE) x)"},
+		{"count(*) + max(t.id, 1)", "(+ count(*) max(t.id 1))"},
+	}
+	for _, tc := range cases {
+		sel := mustParseSelect(t, "SELECT "+tc.expr)
+		got := sexpr(sel.Cores[0].Columns[0].Expr)
+		if got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseJoins(t *testing.T) {
+	sel := mustParseSelect(t, `SELECT * FROM a
+  JOIN b ON a.id = b.a_id
+  LEFT OUTER JOIN c AS cc USING (id, name)
+  NATURAL JOIN d, e`)
+	from := sel.Cores[0].From
+	if len(from) != 5 {
+		t.Fatalf("got %d table refs, want 5", len(from))
+	}
+	if from[0].Join != nil || from[0].Name.Name != "a" {
+		t.Fatalf("first table ref: %+v", from[0])
+	}
+	if j := from[1].Join; j.Op != "JOIN" || sexpr(j.On) != "(= a.id b.a_id)" {
+		t.Fatalf("JOIN b: op=%q on=%s", j.Op, sexpr(j.On))
+	}
+	if j := from[2].Join; j.Op != "LEFT JOIN" || len(j.Using) != 2 || j.Using[1].Name != "name" || from[2].Alias.Name != "cc" {
+		t.Fatalf("LEFT JOIN c: %+v alias=%+v", j, from[2].Alias)
+	}
+	if j := from[3].Join; !j.Natural || j.Op != "JOIN" {
+		t.Fatalf("NATURAL JOIN d: %+v", j)
+	}
+	if j := from[4].Join; j.Op != "," || from[4].Name.Name != "e" {
+		t.Fatalf("comma join e: %+v", j)
+	}
+}
+
+func TestParseSubqueries(t *testing.T) {
+	sel := mustParseSelect(t, `SELECT (SELECT max(id) FROM t2) AS top, x
+FROM (SELECT id AS x FROM t1 WHERE id IN (SELECT id FROM t3)) AS sub
+WHERE EXISTS (SELECT 1 FROM t4 WHERE t4.id = sub.x)`)
+	core := sel.Cores[0]
+	sub, ok := core.Columns[0].Expr.(*SubqueryExpr)
+	if !ok || core.Columns[0].Alias.Name != "top" {
+		t.Fatalf("scalar subquery column: %T alias=%+v", core.Columns[0].Expr, core.Columns[0].Alias)
+	}
+	if got := sexpr(sub.Select.Cores[0].Columns[0].Expr); got != "max(id)" {
+		t.Fatalf("scalar subquery: got %s", got)
+	}
+	from := core.From[0]
+	if from.Subquery == nil || from.Alias.Name != "sub" {
+		t.Fatalf("FROM subquery: %+v", from)
+	}
+	in, ok := from.Subquery.Cores[0].Where.(*InExpr)
+	if !ok || in.Select == nil {
+		t.Fatalf("IN subquery: %T", from.Subquery.Cores[0].Where)
+	}
+	exists, ok := core.Where.(*ExistsExpr)
+	if !ok || exists.Not {
+		t.Fatalf("EXISTS: %T", core.Where)
+	}
+	if got := sexpr(exists.Select.Cores[0].Where); got != "(= t4.id sub.x)" {
+		t.Fatalf("EXISTS WHERE: got %s", got)
+	}
+}
+
+func TestParseQuotedIdentifiers(t *testing.T) {
+	const src = `SELECT "my col", [other col] AS "x""y", ` + "`t`.`id`" + ` FROM "my table" AS 'm'`
+	sel := mustParseSelect(t, src)
+	cols := sel.Cores[0].Columns
+	cases := []struct {
+		ident *Ident
+		name  string
+		quote byte
+	}{
+		{cols[0].Expr.(*ColumnRef).Column, "my col", '"'},
+		{cols[1].Expr.(*ColumnRef).Column, "other col", '['},
+		{cols[1].Alias, `x"y`, '"'},
+		{cols[2].Expr.(*ColumnRef).Table, "t", '`'},
+		{cols[2].Expr.(*ColumnRef).Column, "id", '`'},
+		{sel.Cores[0].From[0].Name, "my table", '"'},
+		{sel.Cores[0].From[0].Alias, "m", '\''},
+	}
+	for _, tc := range cases {
+		if tc.ident.Name != tc.name || tc.ident.Quote != tc.quote {
+			t.Errorf("got %q (quote %q), want %q (quote %q)", tc.ident.Name, tc.ident.Quote, tc.name, tc.quote)
+		}
+		if got := src[tc.ident.Pos:tc.ident.End]; unquote(got, tc.ident.Quote) != tc.name && got != tc.name {
+			t.Errorf("span of %q covers %q", tc.name, got)
+		}
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	cases := []struct {
+		src  string
+		text string // エラー位置のテキスト
+		msg  string
+	}{
+		{"SELECT a FROM t WHERE", "", "incomplete input"},
+		{"SELECT a FROM t WHERE x = = 1", "=", "syntax error"},
+		{"SELECT a b c FROM t", "c", "syntax error"},
+		{"SELECT (a + b FROM t", "FROM", ""},
+		{"SELECT 'abc FROM t", "'abc FROM t", ""},
+		{"SELECT a FROM t /* open", "/* open", "unterminated comment"},
+	}
+	for _, tc := range cases {
+		_, err := Parse(tc.src)
+		if err == nil {
+			t.Errorf("%q: expected error", tc.src)
+			continue
+		}
+		perr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%q: got %T, want *Error", tc.src, err)
+			continue
+		}
+		if perr.Pos < 0 || perr.End > len(tc.src) || perr.Pos > perr.End {
+			t.Errorf("%q: bad span [%d, %d)", tc.src, perr.Pos, perr.End)
+			continue
+		}
+		if got := tc.src[perr.Pos:perr.End]; got != tc.text {
+			t.Errorf("%q: error at %q, want %q (%s)", tc.src, got, tc.text, perr.Msg)
+		}
+		if !strings.Contains(perr.Msg, tc.msg) {
+			t.Errorf("%q: message %q does not contain %q", tc.src, perr.Msg, tc.msg)
+		}
+	}
+}
+
+func TestSplitDefinitions(t *testing.T) {
+	defs, err := SplitDefinitions(`
+  id INTEGER PRIMARY KEY,
+  label TEXT DEFAULT 'a, b',
+  "x,y" TEXT, -- comment, with comma
+  CHECK (label IN ('a', 'b') AND (id > 0)),
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"id INTEGER PRIMARY KEY",
+		"label TEXT DEFAULT 'a, b'",
+		`"x,y" TEXT`,
+		"CHECK (label IN ('a', 'b') AND (id > 0))",
+	}
+	if strings.Join(defs, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", defs, want)
+	}
+	if _, err := SplitDefinitions("id INTEGER, CHECK (id > (0)"); err == nil {
+		t.Fatal("expected an error for unbalanced parentheses")
+	}
+}
+
+func TestParseTableConstraint(t *testing.T) {
+	for _, col := range []string{"id INTEGER PRIMARY KEY", "unique TEXT", "primary INTEGER", "check_flag INTEGER CHECK (check_flag IN (0, 1))"} {
+		con, err := ParseTableConstraint(col)
+		if err != nil || con != nil {
+			t.Errorf("%q: column definition parsed as constraint %+v (%v)", col, con, err)
+		}
+	}
+
+	con, err := ParseTableConstraint(`CONSTRAINT "ok" CHECK (x IN (1, 2) OR (y BETWEEN 1 AND 3))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if con.Kind != "CHECK" || con.Name.Name != "ok" || sexpr(con.Check) != "(OR (IN x [1 2]) [(BETWEEN y 1 3)])" {
+		t.Fatalf("unexpected check: %+v %s", con, sexpr(con.Check))
+	}
+
+	con, err = ParseTableConstraint("PRIMARY KEY (a COLLATE NOCASE, [b] DESC) ON CONFLICT IGNORE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if con.Kind != "PRIMARY KEY" || len(con.Columns) != 2 || con.Columns[0].Name != "a" || con.Columns[1].Name != "b" {
+		t.Fatalf("unexpected primary key: %+v", con)
+	}
+
+	con, err = ParseTableConstraint("FOREIGN KEY (a, b) REFERENCES parent (x, y) ON UPDATE NO ACTION ON DELETE CASCADE MATCH SIMPLE NOT DEFERRABLE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if con.Kind != "FOREIGN KEY" || len(con.Columns) != 2 || con.RefTable.Name != "parent" || len(con.RefColumns) != 2 || con.RefColumns[1].Name != "y" {
+		t.Fatalf("unexpected foreign key: %+v", con)
+	}
+
+	con, err = ParseTableConstraint("FOREIGN KEY (a) REFERENCES parent")
+	if err != nil || con.RefColumns != nil {
+		t.Fatalf("foreign key without referenced columns: %+v (%v)", con, err)
+	}
+
+	for _, src := range []string{"UNIQUE (a, b", "UNIQUE (a + b)", "FOREIGN KEY (a) parent (id)", "CHECK (a) extra", "CONSTRAINT c NOT NULL"} {
+		if _, err := ParseTableConstraint(src); err == nil {
+			t.Errorf("%q: expected error", src)
+		} else if _, ok := err.(*Error); !ok {
+			t.Errorf("%q: got %T, want *Error", src, err)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts, err := SplitStatements(`
+ALTER TABLE t ADD COLUMN c TEXT;
+INSERT INTO t (c) VALUES ('a;b'); ;
+CREATE TRIGGER tr AFTER INSERT ON t BEGIN
+  UPDATE t SET c = CASE WHEN NEW.c IS NULL THEN 'x' ELSE NEW.c END WHERE rowid = NEW.rowid;
+  DELETE FROM log;
+END;
+-- comment;
+UPDATE t SET c = 'y'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 4 {
+		t.Fatalf("got %d statements: %q", len(stmts), stmts)
+	}
+	if stmts[1] != "INSERT INTO t (c) VALUES ('a;b')" {
+		t.Fatalf("unexpected insert: %q", stmts[1])
+	}
+	if !strings.HasPrefix(stmts[2], "CREATE TRIGGER tr") || !strings.HasSuffix(stmts[2], "DELETE FROM log;\nEND") {
+		t.Fatalf("trigger body was split: %q", stmts[2])
+	}
+	if stmts[3] != "UPDATE t SET c = 'y'" {
+		t.Fatalf("unexpected last statement: %q", stmts[3])
+	}
+}
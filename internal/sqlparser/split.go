@@ -0,0 +1,114 @@
+package sqlparser
+
+import "strings"
+
+// SplitDefinitions splits the body of CREATE TABLE (...) into column definitions and
+// table constraints at top-level commas. Commas inside parentheses, string literals
+// and quoted identifiers do not split. The returned error is always an *Error.
+func SplitDefinitions(src string) ([]string, error) {
+	toks, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	var defs []string
+	start, end, depth := -1, -1, 0
+	for _, tok := range toks {
+		if tok.Kind == TokenEOF {
+			break
+		}
+		if tok.Kind == TokenOp {
+			switch tok.Text {
+			case "(":
+				depth++
+			case ")":
+				if depth == 0 {
+					return nil, errorAt(tok.Pos, tok.End, "near %q: syntax error", tok.Text)
+				}
+				depth--
+			case ",":
+				if depth == 0 {
+					if start < 0 {
+						return nil, errorAt(tok.Pos, tok.End, "near %q: syntax error", tok.Text)
+					}
+					defs = append(defs, src[start:end])
+					start = -1
+					continue
+				}
+			}
+		}
+		if start < 0 {
+			start = tok.Pos
+		}
+		end = tok.End
+	}
+	if depth > 0 {
+		return nil, errorAt(len(src), len(src), "incomplete input")
+	}
+	if start >= 0 {
+		defs = append(defs, src[start:end])
+	}
+	return defs, nil
+}
+
+// SplitStatements splits src into statements at top-level semicolons.
+// The body of CREATE TRIGGER ... BEGIN ... END stays in one statement even though it
+// contains semicolons. Empty statements are dropped. The returned error is always an *Error.
+func SplitStatements(src string) ([]string, error) {
+	toks, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	var stmts []string
+	var words []string // 文の先頭のキーワード（CREATE TRIGGER の判定用）
+	start, end, depth := -1, -1, 0
+	for _, tok := range toks {
+		if tok.Kind == TokenEOF {
+			break
+		}
+		if tok.Kind == TokenOp && tok.Text == ";" && depth == 0 {
+			if start >= 0 {
+				stmts = append(stmts, src[start:end])
+			}
+			start, words = -1, nil
+			continue
+		}
+		if start < 0 {
+			start = tok.Pos
+		}
+		end = tok.End
+		if tok.Kind != TokenIdent {
+			continue
+		}
+		word := strings.ToUpper(tok.Text)
+		if len(words) < 4 {
+			words = append(words, word)
+		}
+		if !isCreateTrigger(words) {
+			continue
+		}
+		// トリガー本体の BEGIN ... END と、その中の CASE ... END の対応を数える
+		switch word {
+		case "BEGIN", "CASE":
+			depth++
+		case "END":
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+	if start >= 0 {
+		stmts = append(stmts, src[start:end])
+	}
+	return stmts, nil
+}
+
+// isCreateTrigger は文の先頭が CREATE [TEMP | TEMPORARY] TRIGGER か。
+func isCreateTrigger(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	if words[1] == "TEMP" || words[1] == "TEMPORARY" {
+		return len(words) >= 3 && words[2] == "TRIGGER"
+	}
+	return words[1] == "TRIGGER"
+}
@@ -0,0 +1,217 @@
+package sqlparser
+
+import (
+	"fmt"
+	"strings"
+)
+
+type TokenKind int
+
+const (
+	TokenEOF         TokenKind = iota
+	TokenIdent                 // bare identifier or keyword
+	TokenQuotedIdent           // "name", [name], `name`
+	TokenString                // 'text'
+	TokenNumber                // 123, 1.5, 1e10, 0x1F
+	TokenBlob                  // X'CAFE'
+	TokenParam                 // ?, ?1, :name, @name, $name
+	TokenOp                    // punctuation and operators
+)
+
+// Token is a SQL token. Pos and End are byte offsets into the query text.
+type Token struct {
+	Kind  TokenKind
+	Text  string // raw source text
+	Value string // unquoted value for identifiers and strings
+	Pos   int
+	End   int
+}
+
+// Error is a syntax or validation error located inside the query text.
+type Error struct {
+	Pos int
+	End int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func errorAt(pos, end int, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, End: end, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Tokenize splits a SQLite query into tokens. Comments and whitespace are dropped.
+func Tokenize(src string) ([]Token, error) {
+	var toks []Token
+	i := 0
+	for i < len(src) {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f':
+			i++
+		case ch == '-' && i+1 < len(src) && src[i+1] == '-':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case ch == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, errorAt(i, len(src), "unterminated comment")
+			}
+			i += end + 4
+		case (ch == 'x' || ch == 'X') && i+1 < len(src) && src[i+1] == '\'':
+			end, err := scanQuoted(src, i+1, '\'')
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, Token{Kind: TokenBlob, Text: src[i:end], Value: unquote(src[i+1:end], '\''), Pos: i, End: end})
+			i = end
+		case isIdentStart(ch):
+			start := i
+			for i < len(src) && isIdentPart(src[i]) {
+				i++
+			}
+			toks = append(toks, Token{Kind: TokenIdent, Text: src[start:i], Value: src[start:i], Pos: start, End: i})
+		case isDigit(ch) || (ch == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			start := i
+			i = scanNumber(src, i)
+			if i < len(src) && isIdentStart(src[i]) {
+				return nil, errorAt(start, i+1, "unrecognized token: %q", src[start:i+1])
+			}
+			toks = append(toks, Token{Kind: TokenNumber, Text: src[start:i], Value: src[start:i], Pos: start, End: i})
+		case ch == '\'':
+			end, err := scanQuoted(src, i, '\'')
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, Token{Kind: TokenString, Text: src[i:end], Value: unquote(src[i:end], '\''), Pos: i, End: end})
+			i = end
+		case ch == '"' || ch == '`':
+			end, err := scanQuoted(src, i, ch)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, Token{Kind: TokenQuotedIdent, Text: src[i:end], Value: unquote(src[i:end], ch), Pos: i, End: end})
+			i = end
+		case ch == '[':
+			end := strings.IndexByte(src[i:], ']')
+			if end < 0 {
+				return nil, errorAt(i, len(src), "unterminated identifier")
+			}
+			toks = append(toks, Token{Kind: TokenQuotedIdent, Text: src[i : i+end+1], Value: src[i+1 : i+end], Pos: i, End: i + end + 1})
+			i += end + 1
+		case ch == '?':
+			start := i
+			i++
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			toks = append(toks, Token{Kind: TokenParam, Text: src[start:i], Pos: start, End: i})
+		case (ch == ':' || ch == '@' || ch == '$') && i+1 < len(src) && isIdentPart(src[i+1]):
+			start := i
+			i++
+			for i < len(src) && isIdentPart(src[i]) {
+				i++
+			}
+			toks = append(toks, Token{Kind: TokenParam, Text: src[start:i], Pos: start, End: i})
+		default:
+			op := scanOperator(src[i:])
+			if op == "" {
+				return nil, errorAt(i, i+1, "unrecognized token: %q", string(ch))
+			}
+			toks = append(toks, Token{Kind: TokenOp, Text: op, Value: op, Pos: i, End: i + len(op)})
+			i += len(op)
+		}
+	}
+	toks = append(toks, Token{Kind: TokenEOF, Pos: len(src), End: len(src)})
+	return toks, nil
+}
+
+// scanQuoted returns the offset just past the closing quote. A doubled quote is an escaped quote.
+func scanQuoted(src string, start int, quote byte) (int, error) {
+	i := start + 1
+	for i < len(src) {
+		if src[i] == quote {
+			if i+1 < len(src) && src[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1, nil
+		}
+		i++
+	}
+	if quote == '\'' {
+		return 0, errorAt(start, len(src), "unterminated string literal")
+	}
+	return 0, errorAt(start, len(src), "unterminated identifier")
+}
+
+func unquote(s string, quote byte) string {
+	inner := s[1 : len(s)-1]
+	q := string(quote)
+	return strings.ReplaceAll(inner, q+q, q)
+}
+
+func scanNumber(src string, i int) int {
+	if src[i] == '0' && i+1 < len(src) && (src[i+1] == 'x' || src[i+1] == 'X') {
+		i += 2
+		for i < len(src) && isHexDigit(src[i]) {
+			i++
+		}
+		return i
+	}
+	for i < len(src) && (isDigit(src[i]) || src[i] == '_') {
+		i++
+	}
+	if i < len(src) && src[i] == '.' {
+		i++
+		for i < len(src) && isDigit(src[i]) {
+			i++
+		}
+	}
+	if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+		j := i + 1
+		if j < len(src) && (src[j] == '+' || src[j] == '-') {
+			j++
+		}
+		if j < len(src) && isDigit(src[j]) {
+			i = j
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+var operators = []string{
+	"->>", "||", "->", "<<", ">>", "<=", ">=", "==", "!=", "<>",
+	"(", ")", ",", ".", ";", "+", "-", "*", "/", "%", "<", ">", "=", "&", "|", "~",
+}
+
+func scanOperator(s string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch >= 0x80
+}
+
+func isIdentPart(ch byte) bool {
+	return isIdentStart(ch) || isDigit(ch) || ch == '$'
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
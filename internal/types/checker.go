@@ -8,6 +8,7 @@ import (
 	"unicode"
 
 	"tuna/internal/ast"
+	"tuna/internal/sqlparser"
 )
 
 type SymbolKind int
@@ -61,8 +62,18 @@ func newTypeAlias(params []string, template *Type, placeholders map[string]*Type
 
 // TableInfo stores information about a table definition
 type TableInfo struct {
-	Name    string
-	Columns map[string]*ColumnInfo // column name -> column info
+	Name       string
+	Connection string                 // 名前付き接続（空ならデフォルト接続）
+	Columns    map[string]*ColumnInfo // column name -> column info
+}
+
+// RowType は SELECT でテーブルの全列を取得したときの行の型を返す（テーブル名の型エイリアスと同じ）。
+func (t *TableInfo) RowType() *Type {
+	props := make([]Prop, 0, len(t.Columns))
+	for _, col := range t.Columns {
+		props = append(props, Prop{Name: col.Name, Type: col.RowType()})
+	}
+	return NewObject(props)
 }
 
 // ColumnInfo stores information about a column in a table
@@ -72,31 +83,72 @@ type ColumnInfo struct {
 	Constraints string
 }
 
-type sqlColumnRef struct {
-	Table  string
-	Column string
+// RowType は SELECT で取得したときの列の型を返す。
+// INTEGER → i64、REAL → f64、TEXT / BLOB / NUMERIC → string で、NOT NULL / PRIMARY KEY が無ければ `| null` が付く。
+func (col *ColumnInfo) RowType() *Type {
+	t := sqliteAffinityType(col.Type)
+	if col.Nullable() {
+		return NewUnion([]*Type{t, Null()})
+	}
+	return t
+}
+
+// Nullable は NOT NULL / PRIMARY KEY 制約の無い列なら true を返す。
+func (col *ColumnInfo) Nullable() bool {
+	upper := strings.ToUpper(col.Constraints)
+	return !strings.Contains(upper, "NOT NULL") && !strings.Contains(upper, "PRIMARY KEY")
+}
+
+// sqliteAffinityType は SQLite の型アフィニティ規則で宣言型を TunaScript の型に対応付ける。
+// NUMERIC アフィニティ（DATE, BOOLEAN など）は整数と実数のどちらも入り得るため string として扱う。
+func sqliteAffinityType(declType string) *Type {
+	upper := strings.ToUpper(declType)
+	switch {
+	case strings.Contains(upper, "INT"):
+		return I64()
+	case strings.Contains(upper, "CHAR"), strings.Contains(upper, "CLOB"), strings.Contains(upper, "TEXT"):
+		return String()
+	case upper == "", strings.Contains(upper, "BLOB"):
+		return String()
+	case strings.Contains(upper, "REAL"), strings.Contains(upper, "FLOA"), strings.Contains(upper, "DOUB"):
+		return F64()
+	default:
+		return String()
+	}
 }
 
 type Checker struct {
-	Modules       map[string]*ModuleInfo
-	ExprTypes     map[ast.Expr]*Type
-	IdentSymbols  map[*ast.IdentExpr]*Symbol
-	TypeExprTypes map[ast.TypeExpr]*Type
-	Tables        map[string]*TableInfo // table name -> table info
-	Errors        []error
-	JSXComponents map[*ast.JSXElement]*JSXComponentInfo
-	symbolModule  map[*Symbol]*ModuleInfo
+	Modules        map[string]*ModuleInfo
+	ExprTypes      map[ast.Expr]*Type
+	IdentSymbols   map[*ast.IdentExpr]*Symbol
+	TypeExprTypes  map[ast.TypeExpr]*Type
+	Tables         map[string]*TableInfo            // table name -> table info
+	SQLRowTypes    map[*ast.SQLExpr]*Type           // SQL式ごとの行の型（ランタイムでの値の変換に使う）
+	SQLTargetTypes map[*ast.SQLExpr]*Type           // fetch_all<T> { ... } の T（decode<T> と同じスキーマで値を変換する）
+	SQLUntypedCols map[*ast.SQLExpr]map[string]bool // 型の分からない式の列（string として返し、NULL は空文字列にする）
+	Migrations     map[string]*ast.MigrationDecl    // migration name -> declaration
+	Indexes        map[string]*ast.IndexDecl        // create_index name (lower case) -> declaration
+	sqlIters       map[*ast.SQLExpr]bool            // for ... of の反復対象になっている fetch_iter
+	Errors         []error
+	JSXComponents  map[*ast.JSXElement]*JSXComponentInfo
+	symbolModule   map[*Symbol]*ModuleInfo
 }
 
 func NewChecker() *Checker {
 	return &Checker{
-		Modules:       map[string]*ModuleInfo{},
-		ExprTypes:     map[ast.Expr]*Type{},
-		IdentSymbols:  map[*ast.IdentExpr]*Symbol{},
-		TypeExprTypes: map[ast.TypeExpr]*Type{},
-		Tables:        map[string]*TableInfo{},
-		JSXComponents: map[*ast.JSXElement]*JSXComponentInfo{},
-		symbolModule:  map[*Symbol]*ModuleInfo{},
+		Modules:        map[string]*ModuleInfo{},
+		ExprTypes:      map[ast.Expr]*Type{},
+		IdentSymbols:   map[*ast.IdentExpr]*Symbol{},
+		TypeExprTypes:  map[ast.TypeExpr]*Type{},
+		Tables:         map[string]*TableInfo{},
+		SQLRowTypes:    map[*ast.SQLExpr]*Type{},
+		SQLTargetTypes: map[*ast.SQLExpr]*Type{},
+		SQLUntypedCols: map[*ast.SQLExpr]map[string]bool{},
+		Migrations:     map[string]*ast.MigrationDecl{},
+		Indexes:        map[string]*ast.IndexDecl{},
+		sqlIters:       map[*ast.SQLExpr]bool{},
+		JSXComponents:  map[*ast.JSXElement]*JSXComponentInfo{},
+		symbolModule:   map[*Symbol]*ModuleInfo{},
 	}
 }
 
@@ -241,10 +293,16 @@ func (c *Checker) collectTop(mod *ModuleInfo) {
 			}
 			c.symbolModule[sym] = mod
 		case *ast.TableDecl:
+			// 同じ名前のテーブルを別の接続に定義すると、SQL ブロックからどちらを指すか決められない
+			if prev, exists := c.Tables[d.Name]; exists && prev.Connection != d.Connection {
+				c.errorf(d.Span, "table '%s' is already defined for another connection", d.Name)
+				continue
+			}
 			// Collect table definition
 			tableInfo := &TableInfo{
-				Name:    d.Name,
-				Columns: map[string]*ColumnInfo{},
+				Name:       d.Name,
+				Connection: d.Connection,
+				Columns:    map[string]*ColumnInfo{},
 			}
 			for _, col := range d.Columns {
 				tableInfo.Columns[col.Name] = &ColumnInfo{
@@ -256,13 +314,28 @@ func (c *Checker) collectTop(mod *ModuleInfo) {
 			c.Tables[d.Name] = tableInfo
 
 			// Generate type alias for table row type
-			// All columns are treated as string type (SQLite returns text)
-			var props []Prop
-			for _, col := range d.Columns {
-				props = append(props, Prop{Name: col.Name, Type: String()})
+			// 列の型は宣言型のアフィニティと NOT NULL / PRIMARY KEY 制約から決める
+			mod.TypeAliases[d.Name] = newTypeAlias(nil, tableInfo.RowType(), nil)
+		case *ast.MigrationDecl:
+			// マイグレーションは名前順に適用されるので、名前の重複は許さない
+			if _, exists := c.Migrations[d.Name]; exists {
+				c.errorf(d.Span, "migration '%s' is already defined", d.Name)
+				continue
 			}
-			rowType := NewObject(props)
-			mod.TypeAliases[d.Name] = newTypeAlias(nil, rowType, nil)
+			c.Migrations[d.Name] = d
+			if strings.TrimSpace(d.SQL) == "" {
+				c.errorf(d.Span, "migration '%s' has no SQL", d.Name)
+			} else if _, err := sqlparser.Parse(d.SQL); err != nil {
+				c.errorf(d.Span, "invalid SQL in migration '%s': %s", d.Name, err)
+			}
+		case *ast.IndexDecl:
+			// SQLite のインデックス名はデータベース内で一意で、大文字小文字を区別しない
+			key := strings.ToLower(d.Name)
+			if _, exists := c.Indexes[key]; exists {
+				c.errorf(d.Span, "index '%s' is already defined", d.Name)
+				continue
+			}
+			c.Indexes[key] = d
 		}
 	}
 }
@@ -277,6 +350,9 @@ func (c *Checker) buildTypeAlias(decl *ast.TypeAliasDecl, mod *ModuleInfo) *Type
 		paramPlaceholders[name] = placeholder
 	}
 	template := c.resolveTypeRec(decl.Type, mod, typeParams)
+	if len(decl.Where) > 0 {
+		template = withRefine(template, c.resolveRefinement(decl, template))
+	}
 	return newTypeAlias(params, template, paramPlaceholders)
 }
 
@@ -333,6 +409,10 @@ func (c *Checker) checkModule(mod *ModuleInfo) {
 			c.checkFuncDecl(env, d)
 		case *ast.ExternFuncDecl:
 			// Extern functions have no body and are type-checked by signature only.
+		case *ast.TableDecl:
+			c.checkTableConstraints(d)
+		case *ast.IndexDecl:
+			c.checkIndexDecl(d)
 		}
 	}
 }
@@ -645,7 +725,7 @@ func (c *Checker) checkStmtInfer(env *Env, stmt ast.Stmt, info *returnInfo) {
 			c.checkBlockInfer(env, s.Else, info)
 		}
 	case *ast.ForOfStmt:
-		iterType := c.checkExpr(env, s.Iter, nil)
+		iterType := c.checkForOfIter(env, s.Iter)
 		if iterType == nil {
 			return
 		}
@@ -837,6 +917,10 @@ func (c *Checker) checkStmt(env *Env, stmt ast.Stmt, retType *Type) {
 	case *ast.ExprStmt:
 		c.checkExpr(env, s.Expr, nil)
 	case *ast.ReturnStmt:
+		if env.inTransaction {
+			c.errorf(s.Span, "return is not allowed inside a transaction block")
+			return
+		}
 		if s.Value == nil {
 			if !allowsImplicitVoidReturn(retType) {
 				c.errorf(s.Span, "return required")
@@ -854,7 +938,7 @@ func (c *Checker) checkStmt(env *Env, stmt ast.Stmt, retType *Type) {
 			c.checkBlock(env, s.Else, retType)
 		}
 	case *ast.ForOfStmt:
-		iterType := c.checkExpr(env, s.Iter, nil)
+		iterType := c.checkForOfIter(env, s.Iter)
 		if iterType == nil {
 			return
 		}
@@ -880,6 +964,15 @@ func (c *Checker) checkStmt(env *Env, stmt ast.Stmt, retType *Type) {
 	}
 }
 
+// checkForOfIter は for ... of の反復対象を検査する。fetch_iter { ... } はここでだけ許され、
+// 行の型の配列として扱う（実際には行を1つずつ読む）。
+func (c *Checker) checkForOfIter(env *Env, iter ast.Expr) *Type {
+	if e, ok := iter.(*ast.SQLExpr); ok && e.Kind == ast.SQLQueryFetchIter {
+		c.sqlIters[e] = true
+	}
+	return c.checkExpr(env, iter, nil)
+}
+
 func (c *Checker) checkIfCond(env *Env, cond ast.Expr) *Env {
 	if asExpr, ok := cond.(*ast.AsExpr); ok {
 		targetType := c.checkExpr(env, asExpr, nil)
@@ -1434,7 +1527,7 @@ func (c *Checker) checkExpr(env *Env, expr ast.Expr, expected *Type) *Type {
 			c.errorf(e.Span, "? expects (T | error) expression")
 			return nil
 		}
-		if env.retType == nil || !errType.AssignableTo(env.retType) {
+		if env.retType == nil || !errorPropagatesTo(errType, env.retType) {
 			c.errorf(e.Span, "? requires function return type to include error")
 			return nil
 		}
@@ -1480,7 +1573,36 @@ func (c *Checker) checkExpr(env *Env, expr ast.Expr, expected *Type) *Type {
 		}
 		c.ExprTypes[expr] = Void()
 		return Void()
+	case *ast.TransactionExpr:
+		// ブロック内の ? は関数ではなくブロックを抜けてロールバックするため、戻り値型を error として扱う
+		txEnv := env.child()
+		txEnv.retType = resultErrorType()
+		txEnv.inTransaction = true
+		valType := Void()
+		for i, stmt := range e.Body.Stmts {
+			if es, ok := stmt.(*ast.ExprStmt); ok && i == len(e.Body.Stmts)-1 {
+				valType = c.checkExpr(txEnv, es.Expr, nil)
+				if valType == nil {
+					return nil
+				}
+				continue
+			}
+			c.checkStmt(txEnv, stmt, txEnv.retType)
+		}
+		if valType.Kind == KindVoid {
+			valType = Undefined()
+		}
+		result := NewUnion([]*Type{valType, resultErrorType()})
+		c.ExprTypes[expr] = result
+		return result
 	case *ast.SQLExpr:
+		// execute(db) { ... } の接続は db_connect が返した Db
+		if e.Conn != nil {
+			connType := c.checkExpr(env, e.Conn, nil)
+			if connType != nil && !connType.AssignableTo(sqlConnType()) {
+				c.errorf(e.Conn.GetSpan(), "SQL connection must be a Db returned by db_connect")
+			}
+		}
 		// Check parameter expressions
 		for _, param := range e.Params {
 			paramType := c.checkExpr(env, param, nil)
@@ -1495,11 +1617,13 @@ func (c *Checker) checkExpr(env *Env, expr ast.Expr, expected *Type) *Type {
 				c.errorf(param.GetSpan(), "SQL parameter must be a primitive type (string, i64, f64, or bool)")
 			}
 		}
-		// Validate SQL query against table definitions
-		c.validateSQLQuery(e)
-
-		// Determine row type based on SELECT columns
-		rowType := c.inferSQLRowType(e)
+		// Validate SQL query against table definitions and determine the row type
+		rowType := c.checkSQLQuery(e)
+		if e.RowType != nil {
+			if target := c.checkSQLTargetType(env, e); target != nil {
+				rowType = target
+			}
+		}
 
 		// Return type depends on the query kind.
 		// SQL 実行時エラーは error 値として返すため、常に (... | error) になる。
@@ -1520,6 +1644,18 @@ func (c *Checker) checkExpr(env *Env, expr ast.Expr, expected *Type) *Type {
 		case ast.SQLQueryFetch, ast.SQLQueryFetchAll:
 			// fetch and fetch_all return RowType[] directly on success
 			successType = NewArray(rowType)
+		case ast.SQLQueryFetchIter:
+			// fetch_iter は for ... of の反復対象としてだけ使え、行の読み込みのエラーは ? と同じく伝播する
+			if !c.sqlIters[e] {
+				c.errorf(e.Span, "fetch_iter can only be used as the iterable of for ... of")
+				return nil
+			}
+			if env.retType == nil || !resultErrorType().AssignableTo(env.retType) {
+				c.errorf(e.Span, "fetch_iter requires function return type to include error")
+				return nil
+			}
+			c.ExprTypes[expr] = NewArray(rowType)
+			return c.ExprTypes[expr]
 		default:
 			// Default: same as fetch_all
 			successType = NewArray(rowType)
@@ -1836,6 +1972,9 @@ func (c *Checker) checkCallWithSymbol(env *Env, sym *Symbol, call *ast.CallExpr,
 		c.errorf(call.Span, "argument count mismatch")
 		return nil
 	}
+	if c.isQueryBuilderSymbol(sym, "select") && len(call.TypeArgs) == 0 {
+		return c.checkQuerySelect(env, sig, call)
+	}
 	bindings := map[*Type]*Type{}
 	explicitTypeArgs := len(call.TypeArgs) > 0
 	if explicitTypeArgs {
@@ -1862,6 +2001,10 @@ func (c *Checker) checkCallWithSymbol(env *Env, sym *Symbol, call *ast.CallExpr,
 					c.errorf(call.Span, "%s target type not supported", sym.Name)
 					return nil
 				}
+				if sym.Name == "decode_csv" && !isCSVRowType(argType) {
+					c.errorf(call.Span, "decode_csv target type must be an object type with string, number or boolean properties")
+					return nil
+				}
 			}
 		}
 	} else if c.isDecodeLikeSymbol(sym) && len(sig.TypeParams) > 0 {
@@ -1927,6 +2070,11 @@ func (c *Checker) checkCallWithSymbol(env *Env, sym *Symbol, call *ast.CallExpr,
 	}
 	retType := c.substituteTypeParams(sig.Ret, bindings)
 	c.ExprTypes[call] = retType
+	if c.isQueryBuilderSymbol(sym, "select") {
+		c.checkQuerySelectType(call, retType)
+	} else if c.isQueryBuilderSymbol(sym, "where") || c.isQueryBuilderSymbol(sym, "order_by") || c.isQueryBuilderSymbol(sym, "query_page") {
+		c.checkQueryColumn(call)
+	}
 	return retType
 }
 
@@ -2128,6 +2276,12 @@ func (c *Checker) substituteTypeParams(typ *Type, bindings map[*Type]*Type) *Typ
 		}
 		return typ
 	}
+	if typ.Refine != nil {
+		// where の制約はジェネリックな型エイリアスを具体化しても残す
+		base := *typ
+		base.Refine = nil
+		return withRefine(c.substituteTypeParams(&base, bindings), typ.Refine)
+	}
 	switch typ.Kind {
 	case KindArray:
 		return NewArray(c.substituteTypeParams(typ.Elem, bindings))
@@ -2146,7 +2300,8 @@ func (c *Checker) substituteTypeParams(typ *Type, bindings map[*Type]*Type) *Typ
 	case KindObject:
 		var props []Prop
 		for _, prop := range typ.Props {
-			props = append(props, Prop{Name: prop.Name, Type: c.substituteTypeParams(prop.Type, bindings)})
+			prop.Type = c.substituteTypeParams(prop.Type, bindings)
+			props = append(props, prop)
 		}
 		if typ.Index != nil {
 			return NewObjectWithIndex(props, c.substituteTypeParams(typ.Index, bindings))
@@ -2286,6 +2441,38 @@ func isDecodableType(t *Type, stack map[*Type]bool) bool {
 	}
 }
 
+// isCSVRowType は decode_csv の行の型（プロパティが CSV の1列で表せるオブジェクト型）かどうかを返す。
+func isCSVRowType(t *Type) bool {
+	if t == nil || t.Kind != KindObject || t.Index != nil {
+		return false
+	}
+	for _, p := range t.Props {
+		if !isCSVCellType(p.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+func isCSVCellType(t *Type) bool {
+	if t == nil {
+		return false
+	}
+	switch t.Kind {
+	case KindI64, KindF64, KindBool, KindString, KindNull, KindUndefined:
+		return true
+	case KindUnion:
+		for _, m := range t.Union {
+			if !isCSVCellType(m) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *Checker) checkArrayLit(env *Env, lit *ast.ArrayLit, expected *Type) *Type {
 	hasSpread := false
 	for _, entry := range lit.Entries {
@@ -2456,7 +2643,8 @@ func (c *Checker) checkObjectLit(env *Env, lit *ast.ObjectLit, expected *Type) *
 		}
 	}
 	objType := NewObject(list)
-	if expected != nil {
+	// 型パラメーターを含む期待型は呼び出し側の推論に任せる
+	if expected != nil && !typeContainsTypeParam(expected) {
 		if !objType.AssignableTo(expected) {
 			c.errorf(lit.Span, "object type mismatch")
 			return nil
@@ -2641,7 +2829,19 @@ func (c *Checker) resolveTypeRec(expr ast.TypeExpr, mod *ModuleInfo, typeParams
 			if propType == nil {
 				return nil
 			}
-			props = append(props, Prop{Name: p.Key, Type: propType})
+			prop := Prop{Name: p.Key, Type: propType}
+			if p.Default != nil {
+				value, ok := constJSONValue(p.Default)
+				if !ok {
+					c.errorf(p.Default.GetSpan(), "default value of %s must be a constant JSON value", p.Key)
+				} else if !valueMatchesType(value, propType) {
+					c.errorf(p.Default.GetSpan(), "default value of %s does not match type %s", p.Key, typeNameForError(propType))
+				} else {
+					prop.HasDefault = true
+					prop.Default = value
+				}
+			}
+			props = append(props, prop)
 		}
 		return c.recordType(expr, NewObject(props))
 	case *ast.FuncType:
@@ -2823,375 +3023,15 @@ func (c *Checker) errorf(span ast.Span, format string, args ...interface{}) {
 	c.Errors = append(c.Errors, fmt.Errorf("%d:%d: %s", span.Start.Line, span.Start.Col, msg))
 }
 
-// validateSQLQuery validates SQL query against table definitions
-func (c *Checker) validateSQLQuery(e *ast.SQLExpr) {
-	query := strings.ToUpper(strings.TrimSpace(e.Query))
-
-	// Skip validation for CREATE TABLE statements
-	if strings.Contains(query, "CREATE TABLE") {
-		return
-	}
-
-	// Extract table name and validate
-	tableName, columns := c.parseSQLQueryInfo(e.Query)
-	if tableName == "" {
-		return // Can't determine table name, skip validation
-	}
-
-	// Validate column references
-	for _, col := range columns {
-		if col.Column == "*" {
-			continue // SELECT * is always valid
-		}
-		actualTable := col.Table
-		if actualTable == "" {
-			actualTable = tableName
-		}
-		tableInfo, exists := c.Tables[actualTable]
-		if !exists {
-			// If no table definitions exist at all, skip validation (for backward compatibility)
-			if len(c.Tables) == 0 {
-				continue
-			}
-			c.errorf(e.Span, "table '%s' is not defined", actualTable)
-			continue
-		}
-		if _, exists := tableInfo.Columns[col.Column]; !exists {
-			c.errorf(e.Span, "column '%s' does not exist in table '%s'", col.Column, actualTable)
-		}
-	}
-}
-
-// parseSQLQueryInfo extracts table name and referenced columns from SQL query
-func (c *Checker) parseSQLQueryInfo(query string) (tableName string, columns []sqlColumnRef) {
-	upper := strings.ToUpper(query)
-	words := strings.Fields(query)
-	upperWords := strings.Fields(upper)
-
-	_, tableAliases := parseSQLTableReferences(words, upperWords)
-
-	// Handle SELECT
-	if strings.HasPrefix(upper, "SELECT") {
-		// Find FROM clause
-		fromIdx := -1
-		for i, w := range upperWords {
-			if w == "FROM" {
-				fromIdx = i
-				break
-			}
-		}
-		if fromIdx > 0 && fromIdx+1 < len(words) {
-			if tableName == "" {
-				tableName = strings.ToLower(words[fromIdx+1])
-			}
-			// Extract column names (between SELECT and FROM)
-			i := 1
-			for i < fromIdx {
-				col := strings.Trim(words[i], ",")
-				upperCol := strings.ToUpper(col)
-
-				// Skip AS keyword and the alias that follows
-				if upperCol == "AS" {
-					i += 2 // skip AS and alias
-					continue
-				}
-
-				// Skip SQL functions like COUNT(*), SUM(), etc.
-				if strings.Contains(col, "(") {
-					// Skip until we find a word not part of the function
-					for i < fromIdx && !strings.HasSuffix(words[i], ")") {
-						i++
-					}
-					i++
-					continue
-				}
-
-				if col != "" {
-					refTable := ""
-					// Handle table.column format
-					if dotIdx := strings.Index(col, "."); dotIdx >= 0 {
-						prefix := strings.ToLower(strings.Trim(col[:dotIdx], ","))
-						col = col[dotIdx+1:]
-						if actual, ok := tableAliases[prefix]; ok {
-							refTable = actual
-						} else {
-							refTable = prefix
-						}
-					}
-					columns = append(columns, sqlColumnRef{Table: refTable, Column: strings.ToLower(col)})
-				}
-				i++
-			}
-		}
-		return
-	}
-
-	// Handle INSERT
-	if strings.HasPrefix(upper, "INSERT") {
-		// INSERT INTO table (columns) VALUES ...
-		intoIdx := -1
-		for i, w := range upperWords {
-			if w == "INTO" {
-				intoIdx = i
-				break
-			}
-		}
-		if intoIdx >= 0 && intoIdx+1 < len(words) {
-			tableName = strings.ToLower(words[intoIdx+1])
-			// Extract columns between ( and )
-			parenStart := strings.Index(query, "(")
-			parenEnd := strings.Index(query, ")")
-			if parenStart >= 0 && parenEnd > parenStart {
-				colPart := query[parenStart+1 : parenEnd]
-				for _, col := range strings.Split(colPart, ",") {
-					col = strings.TrimSpace(col)
-					if col != "" {
-						columns = append(columns, sqlColumnRef{Column: strings.ToLower(col)})
-					}
-				}
-			}
-		}
-		return
-	}
-
-	// Handle UPDATE
-	if strings.HasPrefix(upper, "UPDATE") {
-		if len(words) >= 2 {
-			tableName = strings.ToLower(words[1])
-			// Extract columns from SET clause
-			setIdx := -1
-			for i, w := range upperWords {
-				if w == "SET" {
-					setIdx = i
-					break
-				}
-			}
-			if setIdx >= 0 {
-				// Find WHERE clause or end
-				whereIdx := len(words)
-				for i, w := range upperWords {
-					if w == "WHERE" {
-						whereIdx = i
-						break
-					}
-				}
-				// Extract column names from SET ... WHERE
-				setPart := strings.Join(words[setIdx+1:whereIdx], " ")
-				for _, assign := range strings.Split(setPart, ",") {
-					assign = strings.TrimSpace(assign)
-					if eqIdx := strings.Index(assign, "="); eqIdx >= 0 {
-						col := strings.TrimSpace(assign[:eqIdx])
-						columns = append(columns, sqlColumnRef{Column: strings.ToLower(col)})
-					}
-				}
-			}
-			// Also check WHERE clause for column references
-			whereIdx := -1
-			for i, w := range upperWords {
-				if w == "WHERE" {
-					whereIdx = i
-					break
-				}
-			}
-			if whereIdx >= 0 && whereIdx+1 < len(words) {
-				// Simple: extract first identifier after WHERE
-				col := words[whereIdx+1]
-				columns = append(columns, sqlColumnRef{Column: strings.ToLower(col)})
-			}
-		}
-		return
-	}
-
-	// Handle DELETE
-	if strings.HasPrefix(upper, "DELETE") {
-		fromIdx := -1
-		for i, w := range upperWords {
-			if w == "FROM" {
-				fromIdx = i
-				break
-			}
-		}
-		if fromIdx >= 0 && fromIdx+1 < len(words) {
-			tableName = strings.ToLower(words[fromIdx+1])
-		}
-		return
-	}
-
-	return "", nil
-}
-
-func parseSQLTableReferences(words, upperWords []string) (string, map[string]string) {
-	aliasMap := map[string]string{}
-	defaultTable := ""
-	i := 0
-	for i < len(words) {
-		upper := upperWords[i]
-		if upper == "FROM" || upper == "JOIN" {
-			i++
-			if i >= len(words) {
-				break
-			}
-			tableToken := strings.Trim(words[i], ",")
-			if tableToken == "" {
-				continue
-			}
-			tableName := strings.ToLower(tableToken)
-			aliasMap[tableName] = tableName
-			if defaultTable == "" && upper == "FROM" {
-				defaultTable = tableName
-			}
-			nextIdx := i + 1
-			if nextIdx < len(words) {
-				nextUpper := upperWords[nextIdx]
-				if nextUpper == "AS" && nextIdx+1 < len(words) {
-					alias := strings.ToLower(strings.Trim(words[nextIdx+1], ","))
-					if alias != "" {
-						aliasMap[alias] = tableName
-					}
-					i = nextIdx + 1
-				} else if shouldTreatAsAlias(nextUpper) {
-					alias := strings.ToLower(strings.Trim(words[nextIdx], ","))
-					if alias != "" {
-						aliasMap[alias] = tableName
-					}
-					i = nextIdx
-				} else {
-					i = nextIdx - 1
-				}
-			}
-			i++
-			continue
-		}
-		i++
-	}
-	return defaultTable, aliasMap
-}
-
-func shouldTreatAsAlias(word string) bool {
-	switch word {
-	case "ON", "JOIN", "WHERE", "GROUP", "ORDER", "LIMIT", "INNER", "LEFT", "RIGHT", "FULL", "OUTER", "CROSS", "NATURAL", ",":
-		return false
-	default:
-		return word != ""
-	}
-}
-
-// inferSQLRowType determines the row type for a SQL expression based on SELECT columns
-func (c *Checker) inferSQLRowType(e *ast.SQLExpr) *Type {
-	query := strings.TrimSpace(e.Query)
-	upper := strings.ToUpper(query)
-
-	// Only SELECT queries return rows with column data
-	if !strings.HasPrefix(upper, "SELECT") {
-		// For non-SELECT queries, return empty object type for rows
-		return NewObject([]Prop{})
-	}
-
-	// Extract column names from SELECT query
-	columns := c.extractSelectColumns(query)
-	if len(columns) == 0 {
-		// Fallback to empty object if we can't parse columns
-		return NewObject([]Prop{})
-	}
-
-	// Build object type with column names as keys, all values are strings
-	props := make([]Prop, len(columns))
-	for i, col := range columns {
-		props[i] = Prop{Name: col, Type: String()}
-	}
-
-	return NewObject(props)
-}
-
-// extractSelectColumns extracts column names from a SELECT query
-func (c *Checker) extractSelectColumns(query string) []string {
-	upper := strings.ToUpper(query)
-	words := strings.Fields(query)
-	upperWords := strings.Fields(upper)
-
-	// Find FROM clause (may not exist for queries like "SELECT last_insert_rowid()")
-	fromIdx := -1
-	for i, w := range upperWords {
-		if w == "FROM" {
-			fromIdx = i
-			break
-		}
-	}
-
-	// Determine the end of the SELECT clause
-	selectEndIdx := fromIdx
-	if selectEndIdx <= 0 {
-		// No FROM clause - SELECT clause extends to the end
-		selectEndIdx = len(words)
-	}
-
-	if selectEndIdx <= 1 {
-		return nil
-	}
-
-	// Extract column part (between SELECT and FROM, or to end if no FROM)
-	selectPart := strings.Join(words[1:selectEndIdx], " ")
-
-	// Handle SELECT * case - need to expand from table definition
-	if strings.TrimSpace(selectPart) == "*" {
-		// Try to get table name and expand columns
-		tableName := ""
-		if fromIdx >= 0 && fromIdx+1 < len(words) {
-			tableName = strings.ToLower(words[fromIdx+1])
-		}
-		if tableInfo, exists := c.Tables[tableName]; exists {
-			var cols []string
-			for colName := range tableInfo.Columns {
-				cols = append(cols, colName)
-			}
-			// Sort for consistent ordering
-			sort.Strings(cols)
-			return cols
-		}
-		return nil
-	}
-
-	// Parse comma-separated column list
-	var columns []string
-	for _, part := range strings.Split(selectPart, ",") {
-		col := strings.TrimSpace(part)
-		if col == "" {
-			continue
-		}
-
-		// Handle aliases: "column AS alias" or "column alias"
-		upperCol := strings.ToUpper(col)
-		if asIdx := strings.Index(upperCol, " AS "); asIdx >= 0 {
-			col = strings.TrimSpace(col[asIdx+4:])
-		} else if spaceIdx := strings.LastIndex(col, " "); spaceIdx >= 0 {
-			// Check if it's not a function like "last_insert_rowid()"
-			if !strings.Contains(col, "(") {
-				col = strings.TrimSpace(col[spaceIdx+1:])
-			}
-		}
-
-		// Handle table.column format
-		if dotIdx := strings.Index(col, "."); dotIdx >= 0 {
-			col = col[dotIdx+1:]
-		}
-
-		// Handle function calls like "last_insert_rowid()" - use the function name
-		if parenIdx := strings.Index(col, "("); parenIdx >= 0 {
-			col = col[:parenIdx]
-		}
-
-		columns = append(columns, strings.ToLower(col))
-	}
-
-	return columns
-}
-
 var intrinsicFuncNames = map[string]bool{
 	"log":               true,
+	"log_error":         true,
 	"stringify":         true,
 	"toJSON":            true,
 	"parse":             true,
 	"decode":            true,
+	"decode_all":        true,
+	"decode_csv":        true,
 	"to_string":         true,
 	"range":             true,
 	"length":            true,
@@ -3222,11 +3062,13 @@ var intrinsicFuncNames = map[string]bool{
 }
 
 var intrinsicValueDenied = map[string]bool{
-	"add_route": true,
-	"parse":     true,
-	"decode":    true,
-	"range":     true,
-	"sqlQuery":  true,
+	"add_route":  true,
+	"parse":      true,
+	"decode":     true,
+	"decode_all": true,
+	"decode_csv": true,
+	"range":      true,
+	"sqlQuery":   true,
 }
 
 func isBuiltinModulePath(path string) bool {
@@ -3263,18 +3105,35 @@ func (c *Checker) isIntrinsicValueAllowed(sym *Symbol) bool {
 	return !intrinsicValueDenied[sym.Name]
 }
 
-func (c *Checker) isDecodeLikeSymbol(sym *Symbol) bool {
-	if sym == nil {
+// isQueryBuilderSymbol は sym が sqlite モジュールのクエリビルダー関数 name なら true を返す。
+func (c *Checker) isQueryBuilderSymbol(sym *Symbol, name string) bool {
+	if sym == nil || sym.Name != name {
 		return false
 	}
-	if sym.Name != "decode" && sym.Name != "parse" {
+	mod := c.symbolModule[sym]
+	return mod != nil && mod.AST.Path == "sqlite"
+}
+
+func (c *Checker) isDecodeLikeSymbol(sym *Symbol) bool {
+	if sym == nil {
 		return false
 	}
 	mod := c.symbolModule[sym]
 	if mod == nil {
 		return false
 	}
-	return mod.AST.Path == "json"
+	switch sym.Name {
+	case "decode", "decode_all", "parse":
+		return mod.AST.Path == "json"
+	case "decode_csv":
+		return mod.AST.Path == "csv"
+	}
+	return false
+}
+
+// ResultErrorType は error 値の型（{ message, stacktrace, type: "error" }）を返す。
+func ResultErrorType() *Type {
+	return resultErrorType()
 }
 
 func resultErrorType() *Type {
@@ -3304,6 +3163,24 @@ func isResultErrorType(t *Type) bool {
 	return trace.AssignableTo(NewArray(String()))
 }
 
+// errorPropagatesTo は ? で errType を retType の関数から返せるかどうかを返す。
+// json の DecodeErrors のように error のプロパティを含む型は error として返せる。
+func errorPropagatesTo(errType, retType *Type) bool {
+	if errType.AssignableTo(retType) {
+		return true
+	}
+	members := []*Type{errType}
+	if errType.Kind == KindUnion {
+		members = errType.Union
+	}
+	for _, m := range members {
+		if !m.AssignableTo(retType) && !(isResultErrorType(m) && resultErrorType().AssignableTo(retType)) {
+			return false
+		}
+	}
+	return true
+}
+
 func splitResultType(t *Type) (success *Type, err *Type) {
 	if t == nil || t.Kind != KindUnion {
 		return nil, nil
@@ -3331,16 +3208,19 @@ type Env struct {
 	retType *Type
 	// typeParams holds function type parameter bindings in scope.
 	typeParams map[string]*Type
+	// inTransaction は transaction { ... } の中であることを示す（return を禁止する）。
+	inTransaction bool
 }
 
 func (e *Env) child() *Env {
 	return &Env{
-		checker:    e.checker,
-		mod:        e.mod,
-		parent:     e,
-		vars:       map[string]*Symbol{},
-		retType:    e.retType,
-		typeParams: e.typeParams,
+		checker:       e.checker,
+		mod:           e.mod,
+		parent:        e,
+		vars:          map[string]*Symbol{},
+		retType:       e.retType,
+		typeParams:    e.typeParams,
+		inTransaction: e.inTransaction,
 	}
 }
 
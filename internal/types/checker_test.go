@@ -71,6 +71,21 @@ const labels: string[] = raw.map(label)
 	assertArrayElemKind(t, checker.ExprTypes[labels.Init], KindString, "labels")
 }
 
+func TestObjectLiteralArgumentInfersTypeParam(t *testing.T) {
+	const src = `
+function identity<T>(value: T): T {
+  return value
+}
+
+function origin(): { x: i64, y: f64 } {
+  return identity({ x: 0, y: 2.5 })
+}
+`
+
+	mod := mustParseModule(t, "object_literal_type_param.tuna", src)
+	runChecker(t, mod)
+}
+
 func TestReduceInferenceFromRange(t *testing.T) {
 	const src = `
 import { reduce, range } from "array"
@@ -322,6 +337,565 @@ const msg: string = switch (x) {
 	}
 }
 
+func TestSQLRowTypesFollowColumnAffinity(t *testing.T) {
+	const src = `
+create_table authors {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  rating REAL
+}
+
+create_table posts {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  author_id INTEGER NOT NULL,
+  title TEXT NOT NULL
+}
+
+function list(): void {
+  const rows = fetch_all {
+    SELECT p.id AS post_id, p.title, a.name AS author, a.rating, COUNT(*) AS total, CAST(a.rating AS TEXT) AS rating_text
+    FROM posts AS p LEFT JOIN authors AS a ON p.author_id = a.id
+  }
+}
+`
+
+	mod := mustParseModule(t, "sql_row_types.tuna", src)
+	checker := runChecker(t, mod)
+
+	if len(checker.SQLRowTypes) != 1 {
+		t.Fatalf("expected one recorded row type, got %d", len(checker.SQLRowTypes))
+	}
+	var rowType *Type
+	for _, typ := range checker.SQLRowTypes {
+		rowType = typ
+	}
+	nullable := func(t *Type) *Type { return NewUnion([]*Type{t, Null()}) }
+	want := map[string]*Type{
+		"post_id":     I64(),
+		"title":       String(),
+		"author":      nullable(String()),
+		"rating":      nullable(F64()),
+		"total":       I64(),
+		"rating_text": nullable(String()),
+	}
+	for name, expected := range want {
+		got := rowType.PropType(name)
+		if got == nil || !got.Equals(expected) {
+			t.Fatalf("%s: unexpected type %+v", name, got)
+		}
+	}
+}
+
+func TestSQLValidationUnderstandsSubqueriesAndCTEs(t *testing.T) {
+	const src = `
+create_table authors {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL
+}
+
+create_table posts {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  author_id INTEGER NOT NULL,
+  title TEXT NOT NULL
+}
+
+function run(name: string): void {
+  const recent = fetch_all {
+    WITH counts(author_id, total) AS (
+      SELECT author_id, COUNT(*) FROM posts GROUP BY author_id
+    )
+    SELECT "a".name, c.total AS total -- 投稿数
+    FROM authors AS "a"
+    JOIN counts c ON c.author_id = "a".id
+    WHERE EXISTS (SELECT 1 FROM posts p WHERE p.author_id = "a".id AND p.title LIKE {name})
+      /* 相関サブクエリ */
+      AND "a".id IN (SELECT author_id FROM posts)
+    ORDER BY total DESC
+  }
+  const inserted = fetch_one {
+    INSERT INTO authors (name) VALUES ({name})
+    ON CONFLICT (id) DO UPDATE SET name = excluded.name
+    RETURNING id
+  }
+}
+`
+
+	mod := mustParseModule(t, "sql_subqueries.tuna", src)
+	checker := runChecker(t, mod)
+
+	types := map[string]*Type{}
+	for e, typ := range checker.SQLRowTypes {
+		types[strings.Fields(e.Query)[0]] = typ
+	}
+	if got := types["WITH"]; got == nil || !got.PropType("total").Equals(I64()) || !got.PropType("name").Equals(String()) {
+		t.Fatalf("unexpected CTE row type: %+v", got)
+	}
+	if got := types["INSERT"]; got == nil || !got.PropType("id").Equals(I64()) {
+		t.Fatalf("unexpected RETURNING row type: %+v", got)
+	}
+}
+
+func TestSQLValidationReportsPositionInsideBlock(t *testing.T) {
+	const src = `create_table users {
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL
+}
+
+function run(id: i64, table: string): void {
+  const a = fetch_all {
+    SELECT id, nmae FROM users
+  }
+  const b = execute {
+    INSERT INTO users (id, name) VALUES ({id})
+  }
+  const c = fetch_all {
+    SELECT id FROM {table}
+  }
+  const d = fetch_all {
+    SELECT id FROM users WHERE id = ?
+  }
+  const e = fetch_all {
+    SELECT u.id FROM users u JOIN missing m ON m.user_id = u.id
+  }
+  const f = fetch_all {
+    SELECT id FROM users ORDER BY {table}
+  }
+  const g = fetch_all {
+    SELECT id, FROM users
+  }
+}
+`
+	mod := mustParseModule(t, "sql_positions.tuna", src)
+	checker := NewChecker()
+	checker.AddModule(mod)
+	if checker.Check() {
+		t.Fatalf("expected SQL errors, but check succeeded")
+	}
+	for _, want := range []string{
+		"8:16: column 'nmae' does not exist in table 'users'",
+		"11:41: 1 values for 2 columns",
+		"14:20: invalid SQL: parameters can only be used as values, not as table name",
+		"17:37: use {expr} to embed SQL parameters instead of '?'",
+		"20:35: table 'missing' is not defined",
+		"23:35: SQL parameters are values and cannot choose the ORDER BY column",
+		"26:16: invalid SQL: near \"FROM\": syntax error",
+	} {
+		if !hasErrorContaining(checker.Errors, want) {
+			t.Errorf("expected error %q, got: %v", want, checker.Errors)
+		}
+	}
+}
+
+func TestMigrationNamesMustBeUnique(t *testing.T) {
+	const src = `migration "001_init" {
+  CREATE TABLE notes (id INTEGER PRIMARY KEY)
+}
+
+migration "001_init" {
+  ALTER TABLE notes ADD COLUMN body TEXT
+}
+
+migration "002_broken" {
+  UPDATE notes SET = 1
+}
+`
+	mod := mustParseModule(t, "migrations.tuna", src)
+	checker := NewChecker()
+	checker.AddModule(mod)
+	if checker.Check() {
+		t.Fatalf("expected migration errors, but check succeeded")
+	}
+	for _, want := range []string{
+		"5:1: migration '001_init' is already defined",
+		"9:1: invalid SQL in migration '002_broken'",
+	} {
+		if !hasErrorContaining(checker.Errors, want) {
+			t.Errorf("expected error %q, got: %v", want, checker.Errors)
+		}
+	}
+}
+
+func TestSQLConnectionMustBeDb(t *testing.T) {
+	const src = `create_table logs {
+  id INTEGER PRIMARY KEY
+}
+
+create_table archive.logs {
+  id INTEGER PRIMARY KEY
+}
+
+function run(conn: { name: string }, other: string): void {
+  const a = fetch_all(conn) {
+    SELECT id FROM logs
+  }
+  const b = fetch_all(other) {
+    SELECT id FROM logs
+  }
+}
+`
+	mod := mustParseModule(t, "connections.tuna", src)
+	checker := NewChecker()
+	checker.AddModule(mod)
+	if checker.Check() {
+		t.Fatalf("expected connection errors, but check succeeded")
+	}
+	for _, want := range []string{
+		"5:1: table 'logs' is already defined for another connection",
+		"SQL connection must be a Db returned by db_connect",
+	} {
+		if !hasErrorContaining(checker.Errors, want) {
+			t.Errorf("expected error %q, got: %v", want, checker.Errors)
+		}
+	}
+	if len(checker.Errors) != 2 {
+		t.Errorf("expected exactly 2 errors, got: %v", checker.Errors)
+	}
+}
+
+func TestTransactionBlockType(t *testing.T) {
+	const src = `create_table logs {
+  id INTEGER PRIMARY KEY
+}
+
+function count(): i64 | error {
+  const n = transaction {
+    const row = fetch_one {
+      SELECT COUNT(*) AS n FROM logs
+    }?
+    row.n
+  }
+  return n
+}
+
+function early(): i64 | error {
+  const n = transaction {
+    return 1
+  }
+  return 0
+}
+`
+	mod := mustParseModule(t, "transaction.tuna", src)
+	checker := NewChecker()
+	checker.AddModule(mod)
+	if checker.Check() {
+		t.Fatalf("expected return inside transaction to be rejected")
+	}
+	if !hasErrorContaining(checker.Errors, "17:5: return is not allowed inside a transaction block") {
+		t.Errorf("expected return error, got: %v", checker.Errors)
+	}
+	if len(checker.Errors) != 1 {
+		t.Errorf("expected exactly 1 error, got: %v", checker.Errors)
+	}
+}
+
+func TestSQLTargetTypeMustMatchSelectedColumns(t *testing.T) {
+	const src = `create_table todos {
+  id INTEGER PRIMARY KEY,
+  title TEXT NOT NULL,
+  done INTEGER NOT NULL
+}
+
+type Todo = { id: i64, title: string, done: boolean }
+type Tagged = { id: i64, tags: string[] }
+
+function run(): void {
+  const ok = fetch_all<Todo> {
+    SELECT id, title, done FROM todos
+  }
+  const missing = fetch_all<Todo> {
+    SELECT id, title FROM todos
+  }
+  const extra = fetch_one<Todo> {
+    SELECT id, title, done, id AS other FROM todos
+  }
+  const nested = fetch_all<Tagged> {
+    SELECT id, title AS tags FROM todos
+  }
+  const exec = execute<Todo> {
+    DELETE FROM todos
+  }
+}
+`
+	mod := mustParseModule(t, "typed_rows.tuna", src)
+	checker := NewChecker()
+	checker.AddModule(mod)
+	if checker.Check() {
+		t.Fatalf("expected row type errors, but check succeeded")
+	}
+	for _, want := range []string{
+		"14:19: field 'done' of 'Todo' is not selected",
+		"17:17: selected column 'other' is not a field of 'Todo'",
+		"20:18: field 'tags' of 'Tagged' must be i64, f64, boolean, string or one of them | null",
+		"23:16: execute does not return rows, so it cannot take a row type",
+	} {
+		if !hasErrorContaining(checker.Errors, want) {
+			t.Errorf("expected error %q, got: %v", want, checker.Errors)
+		}
+	}
+	if len(checker.Errors) != 4 {
+		t.Errorf("expected exactly 4 errors, got: %v", checker.Errors)
+	}
+}
+
+func TestQueryBuilderChecksTablesAndColumns(t *testing.T) {
+	const src = `import { select, where, order_by, query_all, desc } from "sqlite"
+
+create_table todos {
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title TEXT NOT NULL,
+  done INTEGER
+}
+
+type Wrong = { id: string }
+
+function run(name: string, column: string): void | error {
+  const rows: todos[] = select("todos").where("done", "=", 0).order_by("id", desc).query_all()?
+  const dynamic = select<todos>(name).where(column, "=", 1).order_by(column, desc)
+  const untyped = select(name)
+  const unknown = select("todoz")
+  const wrong = select<Wrong>("todos")
+  const badColumn = select("todos").where("titel", "=", "x")
+  const badOrder = select("todos").order_by("done", desc).order_by("nope", desc)
+  const badOp = select("todos").where("id", "~", 1)
+}
+`
+	mod := mustParseModule(t, "query_builder.tuna", src)
+	checker := NewChecker()
+	if err := addLibModules(checker); err != nil {
+		t.Fatalf("failed to load lib modules: %v", err)
+	}
+	checker.AddModule(mod)
+	if checker.Check() {
+		t.Fatalf("expected query builder errors, but check succeeded")
+	}
+	for _, want := range []string{
+		"14:19: select needs a table name literal or a row type argument (select<T>(table))",
+		"15:26: table 'todoz' is not defined",
+		"16:17: rows of table 'todos' are not assignable to { id: string }",
+		"17:43: column 'titel' does not exist in the query's table",
+		"18:68: column 'nope' does not exist in the query's table",
+		"19:17: argument type mismatch",
+	} {
+		if !hasErrorContaining(checker.Errors, want) {
+			t.Errorf("expected error %q, got: %v", want, checker.Errors)
+		}
+	}
+	// 演算子の誤りは引数の型の不一致として報告され、続く "cannot infer type" も1つ出る
+	if len(checker.Errors) != 7 {
+		t.Errorf("expected exactly 7 errors, got: %v", checker.Errors)
+	}
+}
+
+func TestTableConstraintsAndIndexesAreChecked(t *testing.T) {
+	const src = `create_table users {
+  id INTEGER PRIMARY KEY,
+  email TEXT NOT NULL,
+  UNIQUE (email)
+}
+
+create_table posts {
+  id INTEGER PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  slug TEXT NOT NULL,
+  CONSTRAINT posts_user FOREIGN KEY (user_id) REFERENCES users (uid) ON DELETE CASCADE,
+  UNIQUE (user_id, slg),
+  CHECK (length(slug) > 0)
+}
+
+create_index posts_by_slug on posts (slug)
+create_index unique POSTS_BY_SLUG on posts (user_id)
+create_index users_by_name on users (name)
+create_index missing_table on comments (id)
+`
+	mod := mustParseModule(t, "constraints.tuna", src)
+	decl := mod.Decls[1].(*ast.TableDecl)
+	if len(decl.Columns) != 3 || len(decl.Constraints) != 3 {
+		t.Fatalf("table constraints must not be parsed as columns: %+v", decl)
+	}
+	if fk := decl.Constraints[0]; fk.Kind != "foreign_key" || fk.Name != "posts_user" || fk.RefTable != "users" {
+		t.Fatalf("unexpected foreign key: %+v", fk)
+	}
+	checker := NewChecker()
+	if err := addLibModules(checker); err != nil {
+		t.Fatalf("failed to load lib modules: %v", err)
+	}
+	checker.AddModule(mod)
+	if checker.Check() {
+		t.Fatalf("expected constraint errors, but check succeeded")
+	}
+	for _, want := range []string{
+		"7:1: foreign key references column 'uid' that does not exist in table 'users'",
+		"7:1: column 'slg' in constraint 'UNIQUE (user_id, slg)' does not exist in table 'posts'",
+		"17:1: index 'POSTS_BY_SLUG' is already defined",
+		"18:1: index 'users_by_name': column 'name' does not exist in table 'users'",
+		"19:1: index 'missing_table': table 'comments' is not defined",
+	} {
+		if !hasErrorContaining(checker.Errors, want) {
+			t.Errorf("expected error %q, got: %v", want, checker.Errors)
+		}
+	}
+	if len(checker.Errors) != 5 {
+		t.Errorf("expected exactly 5 errors, got: %v", checker.Errors)
+	}
+}
+
+func TestTableConstraintsWithNestedParentheses(t *testing.T) {
+	const src = `create_table users {
+  id INTEGER PRIMARY KEY,
+  display_name TEXT NOT NULL DEFAULT 'a, b'
+}
+
+create_table posts {
+  id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  status TEXT NOT NULL,
+  CONSTRAINT status_ok CHECK (status IN ('draft', 'published') AND length(status) > (1 + 1)),
+  PRIMARY KEY (id, user_id DESC) ON CONFLICT REPLACE,
+  FOREIGN KEY ("user_id") REFERENCES users (id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED
+}
+`
+	mod := mustParseModule(t, "nested_constraints.tuna", src)
+	users := mod.Decls[0].(*ast.TableDecl)
+	if len(users.Columns) != 2 || len(users.Constraints) != 0 {
+		t.Fatalf("comma in a DEFAULT string must not split the column: %+v", users)
+	}
+	decl := mod.Decls[1].(*ast.TableDecl)
+	if len(decl.Columns) != 3 || len(decl.Constraints) != 3 {
+		t.Fatalf("unexpected columns/constraints: %+v", decl)
+	}
+	if check := decl.Constraints[0]; check.Kind != "check" || check.Name != "status_ok" || len(check.Columns) != 0 {
+		t.Fatalf("unexpected check constraint: %+v", check)
+	}
+	if pk := decl.Constraints[1]; pk.Kind != "primary_key" || strings.Join(pk.Columns, ",") != "id,user_id" {
+		t.Fatalf("unexpected primary key: %+v", pk)
+	}
+	fk := decl.Constraints[2]
+	if fk.Kind != "foreign_key" || strings.Join(fk.Columns, ",") != "user_id" || fk.RefTable != "users" || strings.Join(fk.RefColumns, ",") != "id" {
+		t.Fatalf("unexpected foreign key: %+v", fk)
+	}
+	checker := runChecker(t, mod)
+	if len(checker.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", checker.Errors)
+	}
+
+	_, err := parser.New("broken.tuna", `create_table posts {
+  id INTEGER,
+  UNIQUE (id,
+}
+`).ParseModule()
+	if err == nil || !strings.Contains(err.Error(), "invalid create_table posts") {
+		t.Fatalf("expected a constraint syntax error, got %v", err)
+	}
+}
+
+func TestFetchIterAndQueryPageAreChecked(t *testing.T) {
+	const src = `import { select, query_page } from "sqlite"
+
+create_table items {
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL
+}
+
+function names(): string | error {
+  for (const item of fetch_iter { SELECT id, name FROM items }) {
+    return item.name
+  }
+  const page = query_page(select("items"), "id", null, 10)?
+  return "none"
+}
+
+function misuse(): undefined | error {
+  fetch_iter { SELECT id FROM items }
+  const page = query_page(select("items"), "nme", null, 10)?
+  return undefined
+}
+
+function no_error(): void {
+  for (const item of fetch_iter { SELECT id FROM items }) {
+  }
+}
+`
+	mod := mustParseModule(t, "fetch_iter.tuna", src)
+	checker := NewChecker()
+	if err := addLibModules(checker); err != nil {
+		t.Fatalf("failed to load lib modules: %v", err)
+	}
+	checker.AddModule(mod)
+	if checker.Check() {
+		t.Fatalf("expected fetch_iter errors, but check succeeded")
+	}
+	for _, want := range []string{
+		"17:3: fetch_iter can only be used as the iterable of for ... of",
+		"18:44: column 'nme' does not exist in the query's table",
+		"23:22: fetch_iter requires function return type to include error",
+	} {
+		if !hasErrorContaining(checker.Errors, want) {
+			t.Errorf("expected error %q, got: %v", want, checker.Errors)
+		}
+	}
+	if len(checker.Errors) != 3 {
+		t.Errorf("expected exactly 3 errors, got: %v", checker.Errors)
+	}
+}
+
+func TestTypeRefinementsAndDefaultsAreChecked(t *testing.T) {
+	const src = `import { decode_all, type DecodeErrors } from "json"
+
+type Name = string where { min_length: 1, max_length: 20, pattern: "^[A-Z][a-z]*$" }
+type Age = i64 where { min: 0, max: 150 }
+type Short = Name where { max_length: 5 }
+type User = { name: Short, age: Age = 20, tags: string[] = ["a"] }
+
+type A = string where { min: 1 }
+type B = i64 where { pattern: "x" }
+type C = string where { pattern: "(a|b)" }
+type D = string where { min_length: 5, max_length: 2 }
+type E = string where { foo: 1 }
+type F = { n: i64 = "x" }
+
+function load(value: json): User | error {
+  const user = decode_all<User>(value)?
+  return user
+}
+
+function issues(value: json): DecodeErrors | undefined {
+  return switch (decode_all<User>(value)) {
+    case e as DecodeErrors: e
+    case u as User: undefined
+  }
+}
+`
+	mod := mustParseModule(t, "refine.tuna", src)
+	checker := NewChecker()
+	if err := addLibModules(checker); err != nil {
+		t.Fatalf("failed to load lib modules: %v", err)
+	}
+	checker.AddModule(mod)
+	if checker.Check() {
+		t.Fatalf("expected refinement errors, but check succeeded")
+	}
+	for _, want := range []string{
+		"8:25: min can only refine i64 or f64 types",
+		"9:22: pattern can only refine string types",
+		"10:25: invalid pattern: ( is not supported",
+		"11:1: min_length is greater than max_length",
+		"12:25: unknown refinement foo",
+		"13:21: default value of n does not match type i64",
+	} {
+		if !hasErrorContaining(checker.Errors, want) {
+			t.Errorf("expected error %q, got: %v", want, checker.Errors)
+		}
+	}
+	if len(checker.Errors) != 6 {
+		t.Errorf("expected exactly 6 errors, got: %v", checker.Errors)
+	}
+
+	short := checker.Modules["refine.tuna"].TypeAliases["Short"].Template
+	if short.Refine == nil || short.Refine.Pattern != "^[A-Z][a-z]*$" || *short.Refine.MinLength != 1 || *short.Refine.MaxLength != 5 {
+		t.Fatalf("Short should extend Name's refinement, got %+v", short.Refine)
+	}
+}
+
 func mustParseModule(t *testing.T, path, src string) *ast.Module {
 	t.Helper()
 	p := parser.New(path, src)
@@ -0,0 +1,389 @@
+package types
+
+import (
+	"fmt"
+	"math"
+
+	"tuna/internal/ast"
+)
+
+// Refinement は type ... where { ... } の制約。型の互換性には影響せず、
+// json の decode / decode_all のときだけ lib/json.wat の decoder が検査する。
+type Refinement struct {
+	MinLength *int64   // string は文字数、配列は要素数
+	MaxLength *int64   // string は文字数、配列は要素数
+	Min       *float64 // i64 / f64 の下限（含む）
+	Max       *float64 // i64 / f64 の上限（含む）
+	Pattern   string   // string の正規表現（ValidatePattern のサブセット）
+}
+
+func (r *Refinement) clone() *Refinement {
+	if r == nil {
+		return &Refinement{}
+	}
+	cp := *r
+	return &cp
+}
+
+// withRefine は t のコピーに制約 r を付けて返す。
+func withRefine(t *Type, r *Refinement) *Type {
+	if t == nil || r == nil {
+		return t
+	}
+	cp := *t
+	cp.Refine = r
+	return &cp
+}
+
+// resolveRefinement は where 句を検査し、base の制約に重ねた Refinement を返す。
+func (c *Checker) resolveRefinement(decl *ast.TypeAliasDecl, base *Type) *Refinement {
+	if base == nil {
+		return nil
+	}
+	ref := base.Refine.clone()
+	seen := map[string]bool{}
+	isString := base.Kind == KindString && !base.Literal
+	isArray := base.Kind == KindArray
+	isNumber := (base.Kind == KindI64 || base.Kind == KindF64) && !base.Literal
+	for _, r := range decl.Where {
+		if seen[r.Name] {
+			c.errorf(r.Span, "%s is specified more than once", r.Name)
+			continue
+		}
+		seen[r.Name] = true
+		switch r.Name {
+		case "min_length", "max_length":
+			if !isString && !isArray {
+				c.errorf(r.Span, "%s can only refine string or array types", r.Name)
+				continue
+			}
+			lit, ok := r.Value.(*ast.IntLit)
+			if !ok || lit.Value < 0 {
+				c.errorf(r.Span, "%s must be a non-negative integer literal", r.Name)
+				continue
+			}
+			n := lit.Value
+			if r.Name == "min_length" {
+				ref.MinLength = &n
+			} else {
+				ref.MaxLength = &n
+			}
+		case "min", "max":
+			if !isNumber {
+				c.errorf(r.Span, "%s can only refine i64 or f64 types", r.Name)
+				continue
+			}
+			v, ok := constJSONValue(r.Value)
+			var f float64
+			switch n := v.(type) {
+			case int64:
+				f = float64(n)
+			case float64:
+				f = n
+			default:
+				ok = false
+			}
+			if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+				c.errorf(r.Span, "%s must be a number literal", r.Name)
+				continue
+			}
+			if r.Name == "min" {
+				ref.Min = &f
+			} else {
+				ref.Max = &f
+			}
+		case "pattern":
+			if !isString {
+				c.errorf(r.Span, "pattern can only refine string types")
+				continue
+			}
+			lit, ok := r.Value.(*ast.StringLit)
+			if !ok {
+				c.errorf(r.Span, "pattern must be a string literal")
+				continue
+			}
+			if err := ValidatePattern(lit.Value); err != nil {
+				c.errorf(r.Span, "invalid pattern: %v", err)
+				continue
+			}
+			ref.Pattern = lit.Value
+		default:
+			c.errorf(r.Span, "unknown refinement %s", r.Name)
+		}
+	}
+	if ref.MinLength != nil && ref.MaxLength != nil && *ref.MinLength > *ref.MaxLength {
+		c.errorf(decl.Span, "min_length is greater than max_length")
+	}
+	if ref.Min != nil && ref.Max != nil && *ref.Min > *ref.Max {
+		c.errorf(decl.Span, "min is greater than max")
+	}
+	return ref
+}
+
+// ValidatePattern は decoder が扱える正規表現のサブセットかどうかを検査する。
+// リテラル、.、[...] / [^...]（ASCII の文字と範囲）、\d \w \s \D \W \S とエスケープ、
+// 量指定子 * + ?、先頭の ^ と末尾の $ だけを受け付ける（グループ、|、{n,m} は使えない）。
+func ValidatePattern(pattern string) error {
+	atom := false
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch ch {
+		case '^':
+			if i != 0 {
+				return fmt.Errorf("^ is only allowed at the start")
+			}
+			atom = false
+		case '$':
+			if i != len(pattern)-1 {
+				return fmt.Errorf("$ is only allowed at the end")
+			}
+			atom = false
+		case '*', '+', '?':
+			if !atom {
+				return fmt.Errorf("%c must follow a character or class", ch)
+			}
+			atom = false
+		case '(', ')', '|', '{', '}':
+			return fmt.Errorf("%c is not supported", ch)
+		case '\\':
+			if i+1 >= len(pattern) {
+				return fmt.Errorf("trailing backslash")
+			}
+			i++
+			atom = true
+		case '[':
+			end, err := validatePatternClass(pattern, i)
+			if err != nil {
+				return err
+			}
+			i = end
+			atom = true
+		case ']':
+			return fmt.Errorf("unmatched ]")
+		default:
+			atom = true
+		}
+	}
+	return nil
+}
+
+// validatePatternClass は pattern[start] の [ から始まる文字クラスを検査し、閉じる ] の位置を返す。
+func validatePatternClass(pattern string, start int) (int, error) {
+	i := start + 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	first := i
+	for ; i < len(pattern); i++ {
+		ch := pattern[i]
+		if ch >= 0x80 {
+			return 0, fmt.Errorf("character classes only support ASCII")
+		}
+		switch ch {
+		case ']':
+			if i == first {
+				return 0, fmt.Errorf("empty character class")
+			}
+			return i, nil
+		case '\\':
+			if i+1 >= len(pattern) {
+				return 0, fmt.Errorf("trailing backslash")
+			}
+			i++
+		default:
+			if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+				lo, hi := ch, pattern[i+2]
+				if hi == '\\' || hi >= 0x80 {
+					return 0, fmt.Errorf("invalid range %c-%c", lo, hi)
+				}
+				if lo > hi {
+					return 0, fmt.Errorf("invalid range %c-%c", lo, hi)
+				}
+				i += 2
+			}
+		}
+	}
+	return 0, fmt.Errorf("missing ]")
+}
+
+// constJSONValue は定数式（リテラル、配列・オブジェクトリテラル）を JSON 値に変換する。
+func constJSONValue(expr ast.Expr) (interface{}, bool) {
+	switch e := expr.(type) {
+	case *ast.IntLit:
+		return e.Value, true
+	case *ast.FloatLit:
+		return e.Value, true
+	case *ast.StringLit:
+		return e.Value, true
+	case *ast.BoolLit:
+		return e.Value, true
+	case *ast.NullLit:
+		return nil, true
+	case *ast.UnaryExpr:
+		if e.Op != "-" {
+			return nil, false
+		}
+		switch v := e.Expr.(type) {
+		case *ast.IntLit:
+			return -v.Value, true
+		case *ast.FloatLit:
+			return -v.Value, true
+		}
+		return nil, false
+	case *ast.ArrayLit:
+		out := make([]interface{}, 0, len(e.Entries))
+		for _, entry := range e.Entries {
+			if entry.Kind != ast.ArrayValue {
+				return nil, false
+			}
+			v, ok := constJSONValue(entry.Value)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, v)
+		}
+		return out, true
+	case *ast.ObjectLit:
+		out := make(map[string]interface{}, len(e.Entries))
+		for _, entry := range e.Entries {
+			if entry.Kind != ast.ObjectProp {
+				return nil, false
+			}
+			v, ok := constJSONValue(entry.Value)
+			if !ok {
+				return nil, false
+			}
+			out[entry.Key] = v
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// valueMatchesType は JSON 値 v が型 t に decode できるかどうかを返す（制約は見ない）。
+func valueMatchesType(v interface{}, t *Type) bool {
+	if t == nil {
+		return false
+	}
+	if t.Literal {
+		switch t.Kind {
+		case KindI64:
+			n, ok := v.(int64)
+			return ok && n == t.LiteralValue
+		case KindF64:
+			switch n := v.(type) {
+			case int64:
+				return float64(n) == t.LiteralValue
+			case float64:
+				return n == t.LiteralValue
+			}
+			return false
+		default:
+			return v == t.LiteralValue
+		}
+	}
+	switch t.Kind {
+	case KindJSON, KindTypeParam:
+		return true
+	case KindI64:
+		_, ok := v.(int64)
+		return ok
+	case KindF64:
+		switch v.(type) {
+		case int64, float64:
+			return true
+		}
+		return false
+	case KindString:
+		_, ok := v.(string)
+		return ok
+	case KindBool:
+		_, ok := v.(bool)
+		return ok
+	case KindNull:
+		return v == nil
+	case KindArray:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, elem := range arr {
+			if !valueMatchesType(elem, t.Elem) {
+				return false
+			}
+		}
+		return true
+	case KindTuple:
+		arr, ok := v.([]interface{})
+		if !ok || len(arr) != len(t.Tuple) {
+			return false
+		}
+		for i, elem := range arr {
+			if !valueMatchesType(elem, t.Tuple[i]) {
+				return false
+			}
+		}
+		return true
+	case KindObject:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for _, p := range t.Props {
+			val, ok := obj[p.Name]
+			if !ok {
+				if !p.HasDefault && !allowsUndefined(p.Type) {
+					return false
+				}
+				continue
+			}
+			if !valueMatchesType(val, p.Type) {
+				return false
+			}
+		}
+		if t.Index == nil {
+			// 宣言されていないキーは decode で捨てられる
+			return true
+		}
+		for key, val := range obj {
+			if !propIsDeclared(t, key) && !valueMatchesType(val, t.Index) {
+				return false
+			}
+		}
+		return true
+	case KindUnion:
+		for _, m := range t.Union {
+			if valueMatchesType(v, m) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func propIsDeclared(t *Type, name string) bool {
+	for _, p := range t.Props {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func allowsUndefined(t *Type) bool {
+	if t == nil {
+		return false
+	}
+	if t.Kind == KindUndefined {
+		return true
+	}
+	if t.Kind == KindUnion {
+		for _, m := range t.Union {
+			if m.Kind == KindUndefined {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,1065 @@
+package types
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"tuna/internal/ast"
+	"tuna/internal/sqlparser"
+)
+
+// sqlValueType は SQL の式や列の型。known が false の式は従来どおり string
+// （NULL は空文字列）として扱い、ランタイムもそれに合わせて値を文字列化する。
+type sqlValueType struct {
+	t        *Type
+	nullable bool
+	known    bool
+}
+
+var sqlUnknownType = sqlValueType{t: String()}
+
+// sqlResultColumn は SELECT / RETURNING の結果の1列。
+type sqlResultColumn struct {
+	name string
+	typ  sqlValueType
+}
+
+// sqlSource は FROM 句などで参照できるテーブル1つ分。
+type sqlSource struct {
+	name     string // 参照名（エイリアスがあればエイリアス）
+	table    string // エラーメッセージ用のテーブル名
+	columns  map[string]sqlValueType
+	known    bool            // false なら列が分からない（未定義テーブルなど）ので何でも受け付ける
+	isTable  bool            // create_table で定義したテーブル（rowid を持つ）
+	nullable bool            // LEFT JOIN などで NULL になり得る
+	using    map[string]bool // USING / NATURAL で結合した列（曖昧さの判定から除く）
+}
+
+// sqlScope は SQL の名前解決のスコープ。相関サブクエリのために親をたどる。
+type sqlScope struct {
+	parent  *sqlScope
+	sources []*sqlSource
+	ctes    map[string]*sqlSource
+	aliases map[string]sqlValueType // WHERE / GROUP BY / ORDER BY から参照できる結果列の別名
+}
+
+// sqlChecker は1つの SQL ブロックを検証する。エラー位置は QueryPositions でソース上の位置に変換する。
+type sqlChecker struct {
+	c    *Checker
+	expr *ast.SQLExpr
+	// strict は create_table が1つでもあれば true。未定義のテーブルをエラーにする
+	// （create_table を使わないプログラムは従来どおりテーブル名を検証しない）。
+	strict bool
+	params map[int]bool
+}
+
+// sqlConnType は sqlite モジュールの Db 型（db_connect が返す接続ハンドル）。
+func sqlConnType() *Type {
+	return NewObject([]Prop{{Name: "name", Type: String()}})
+}
+
+// checkSQLQuery は SQL ブロックを構文解析し、テーブル・列の参照、INSERT の列数、
+// {param} の位置を検証して、結果の行の型を返す。
+func (c *Checker) checkSQLQuery(e *ast.SQLExpr) *Type {
+	s := &sqlChecker{c: c, expr: e, strict: len(c.Tables) > 0, params: map[int]bool{}}
+	for _, off := range e.ParamOffsets {
+		s.params[off] = true
+	}
+	stmts, err := sqlparser.Parse(e.Query)
+	if err != nil {
+		perr := err.(*sqlparser.Error)
+		s.errorf(sqlparser.Span{Pos: perr.Pos, End: perr.End}, "invalid SQL: %s", perr.Msg)
+		return NewObject([]Prop{})
+	}
+	var result []sqlResultColumn
+	returnsRows := false
+	for _, stmt := range stmts {
+		result, returnsRows = s.checkStmt(stmt)
+	}
+	if !returnsRows || result == nil {
+		// 行を返さない文や列の分からない SELECT * は空のオブジェクト型にする
+		return NewObject([]Prop{})
+	}
+	props := make([]Prop, 0, len(result))
+	index := map[string]int{}
+	untyped := map[string]bool{}
+	for _, col := range result {
+		t := col.typ.t
+		if col.typ.nullable {
+			t = NewUnion([]*Type{t, Null()})
+		}
+		untyped[col.name] = !col.typ.known
+		// 同名の列は実行時に後の列で上書きされる
+		if i, ok := index[col.name]; ok {
+			props[i].Type = t
+			continue
+		}
+		index[col.name] = len(props)
+		props = append(props, Prop{Name: col.name, Type: t})
+	}
+	rowType := NewObject(props)
+	c.SQLRowTypes[e] = rowType
+	c.SQLUntypedCols[e] = untyped
+	return rowType
+}
+
+// checkSQLTargetType は fetch_all<T> { ... } の T を解決し、SELECT の列の集合が T のプロパティと
+// 一致することを確認する。列が分からない SELECT（未定義テーブルの * など）は実行時に検証する。
+func (c *Checker) checkSQLTargetType(env *Env, e *ast.SQLExpr) *Type {
+	if e.Kind == ast.SQLQueryExecute {
+		c.errorf(e.Span, "execute does not return rows, so it cannot take a row type")
+		return nil
+	}
+	name := sqlTargetTypeName(e.RowType)
+	target := c.resolveTypeInEnv(e.RowType, env)
+	if target == nil {
+		return nil
+	}
+	if target.Kind != KindObject || target.Index != nil {
+		c.errorf(e.Span, "SQL row type %s must be an object type", name)
+		return nil
+	}
+	ok := true
+	for _, prop := range target.Props {
+		if !isSQLFieldType(prop.Type) {
+			c.errorf(e.Span, "field '%s' of %s must be i64, f64, boolean, string or one of them | null", prop.Name, name)
+			ok = false
+		}
+	}
+	if selected, known := c.SQLRowTypes[e]; known {
+		// 結果の列名は小文字になるので、フィールド名とは大文字小文字を区別せずに対応させる
+		fields := map[string]bool{}
+		for _, prop := range target.Props {
+			fields[strings.ToLower(prop.Name)] = true
+			if selected.PropType(strings.ToLower(prop.Name)) == nil {
+				c.errorf(e.Span, "field '%s' of %s is not selected", prop.Name, name)
+				ok = false
+			}
+		}
+		for _, prop := range selected.Props {
+			if !fields[prop.Name] {
+				c.errorf(e.Span, "selected column '%s' is not a field of %s", prop.Name, name)
+				ok = false
+			}
+		}
+	}
+	if ok {
+		c.SQLTargetTypes[e] = target
+	}
+	return target
+}
+
+// checkQuerySelect は型引数の無い select(table) を検査する。table は create_table で定義した
+// テーブル名の文字列リテラルでなければならず、Query<T> の T はそのテーブルの行型になる。
+func (c *Checker) checkQuerySelect(env *Env, sig *Type, call *ast.CallExpr) *Type {
+	// エラーでも Query<{}> を返し、後続の "cannot infer type" を出さない
+	row := NewObject(nil)
+	if lit, ok := call.Args[0].(*ast.StringLit); !ok {
+		c.checkExpr(env, call.Args[0], String())
+		c.errorf(call.Span, "select needs a table name literal or a row type argument (select<T>(table))")
+	} else if table := c.sqlTable(lit.Value); table == nil {
+		c.checkExpr(env, lit, String())
+		c.errorf(lit.Span, "table '%s' is not defined", lit.Value)
+	} else {
+		c.checkExpr(env, lit, String())
+		row = table.RowType()
+	}
+	placeholders := map[string]*Type{}
+	collectTypeParamPlaceholders(sig, placeholders)
+	retType := c.substituteTypeParams(sig.Ret, map[*Type]*Type{placeholders["T"]: row})
+	c.ExprTypes[call] = retType
+	return retType
+}
+
+// checkQuerySelectType は select<T>("table") の T にテーブルの行を代入できることを確認する。
+func (c *Checker) checkQuerySelectType(call *ast.CallExpr, queryType *Type) {
+	lit, ok := call.Args[0].(*ast.StringLit)
+	if !ok {
+		return
+	}
+	table := c.sqlTable(lit.Value)
+	if table == nil {
+		c.errorf(lit.Span, "table '%s' is not defined", lit.Value)
+		return
+	}
+	if row := queryRowType(queryType); row != nil && !table.RowType().AssignableTo(row) {
+		c.errorf(call.Span, "rows of table '%s' are not assignable to %s", table.Name, typeNameForError(row))
+	}
+}
+
+// checkQueryColumn は where / order_by の列名（query_page は key）が文字列リテラルなら、クエリの行型の列であることを確認する。
+// リテラル以外の列名は実行時に create_table の定義と照合する。
+func (c *Checker) checkQueryColumn(call *ast.CallExpr) {
+	if len(call.Args) < 2 {
+		return
+	}
+	lit, ok := call.Args[1].(*ast.StringLit)
+	if !ok {
+		return
+	}
+	row := queryRowType(c.ExprTypes[call.Args[0]])
+	if row == nil || row.Kind != KindObject || row.Index != nil {
+		return
+	}
+	for _, prop := range row.Props {
+		if strings.EqualFold(prop.Name, lit.Value) {
+			return
+		}
+	}
+	c.errorf(lit.Span, "column '%s' does not exist in the query's table", lit.Value)
+}
+
+// queryRowType は Query<T> の T（row プロパティの型から null を除いたもの）を返す。
+func queryRowType(queryType *Type) *Type {
+	if queryType == nil || queryType.Kind != KindObject {
+		return nil
+	}
+	row := queryType.PropType("row")
+	if row == nil || row.Kind != KindUnion {
+		return row
+	}
+	var members []*Type
+	for _, member := range row.Union {
+		if member.Kind != KindNull {
+			members = append(members, member)
+		}
+	}
+	if len(members) != 1 {
+		return nil
+	}
+	return members[0]
+}
+
+// isSQLFieldType は SQLite の値から変換できるフィールドの型か（T | null も可）。
+func isSQLFieldType(t *Type) bool {
+	if t == nil {
+		return false
+	}
+	if t.Kind == KindUnion {
+		hasNull := false
+		var base *Type
+		for _, member := range t.Union {
+			if member.Kind == KindNull {
+				hasNull = true
+				continue
+			}
+			if base != nil {
+				return false
+			}
+			base = member
+		}
+		return hasNull && isSQLFieldType(base)
+	}
+	if t.Literal {
+		return false
+	}
+	switch t.Kind {
+	case KindI64, KindF64, KindBool, KindString:
+		return true
+	}
+	return false
+}
+
+func sqlTargetTypeName(t ast.TypeExpr) string {
+	if named, ok := t.(*ast.NamedType); ok {
+		return "'" + named.Name + "'"
+	}
+	return "the row type"
+}
+
+func (s *sqlChecker) errorf(span sqlparser.Span, format string, args ...interface{}) {
+	s.c.errorf(s.sourceSpan(span), format, args...)
+}
+
+// sourceSpan はクエリ内のバイト範囲をソース上の位置に変換する。
+func (s *sqlChecker) sourceSpan(span sqlparser.Span) ast.Span {
+	positions := s.expr.QueryPositions
+	if len(positions) == 0 {
+		return s.expr.Span
+	}
+	start := span.Pos
+	if start >= len(positions) {
+		start = len(positions) - 1
+	}
+	end := span.End - 1
+	if end < start {
+		end = start
+	}
+	if end >= len(positions) {
+		end = len(positions) - 1
+	}
+	return ast.Span{Start: positions[start], End: positions[end]}
+}
+
+// checkStmt は1文を検証し、行を返す文なら結果列を返す。result が nil なら列が分からない。
+func (s *sqlChecker) checkStmt(stmt sqlparser.Stmt) (result []sqlResultColumn, returnsRows bool) {
+	switch st := stmt.(type) {
+	case *sqlparser.SelectStmt:
+		return s.checkSelect(st, nil), true
+	case *sqlparser.InsertStmt:
+		return s.checkInsert(st), len(st.Returning) > 0
+	case *sqlparser.UpdateStmt:
+		return s.checkUpdate(st), len(st.Returning) > 0
+	case *sqlparser.DeleteStmt:
+		return s.checkDelete(st), len(st.Returning) > 0
+	}
+	return nil, false
+}
+
+// withScope は WITH 句の CTE を登録したスコープを作る。
+func (s *sqlChecker) withScope(with *sqlparser.With, parent *sqlScope) *sqlScope {
+	if with == nil {
+		return parent
+	}
+	scope := &sqlScope{parent: parent, ctes: map[string]*sqlSource{}}
+	for _, cte := range with.CTEs {
+		name := strings.ToLower(cte.Name.Name)
+		source := &sqlSource{name: name, table: cte.Name.Name}
+		if with.Recursive {
+			// 再帰 CTE は自分自身を参照できる。列は宣言された名前か、最初の SELECT から決める
+			source.known = len(cte.Columns) > 0
+			source.columns = map[string]sqlValueType{}
+			for _, col := range cte.Columns {
+				source.columns[strings.ToLower(col.Name)] = sqlUnknownType
+			}
+			if !source.known && len(cte.Select.Cores) > 0 {
+				first := &sqlparser.SelectStmt{Cores: cte.Select.Cores[:1], Span: cte.Select.Span}
+				if cols := s.resultColumnsQuietly(first, scope); cols != nil {
+					source.known = true
+					for _, col := range cols {
+						source.columns[col.name] = sqlUnknownType
+					}
+				}
+			}
+			scope.ctes[name] = source
+		}
+		cols := s.checkSelect(cte.Select, scope)
+		source.columns = map[string]sqlValueType{}
+		source.known = cols != nil
+		if cols != nil && len(cte.Columns) > 0 && len(cte.Columns) != len(cols) {
+			s.errorf(cte.Span, "table '%s' has %d values for %d columns", cte.Name.Name, len(cols), len(cte.Columns))
+		}
+		for i, col := range cols {
+			name := col.name
+			if i < len(cte.Columns) {
+				name = strings.ToLower(cte.Columns[i].Name)
+			}
+			source.columns[name] = col.typ
+		}
+		if cols == nil && len(cte.Columns) > 0 {
+			source.known = true
+			for _, col := range cte.Columns {
+				source.columns[strings.ToLower(col.Name)] = sqlUnknownType
+			}
+		}
+		scope.ctes[name] = source
+	}
+	return scope
+}
+
+// resultColumnsQuietly はエラーを報告せずに結果列だけを求める（再帰 CTE の列の決定用）。
+func (s *sqlChecker) resultColumnsQuietly(sel *sqlparser.SelectStmt, scope *sqlScope) []sqlResultColumn {
+	errs := len(s.c.Errors)
+	cols := s.checkSelect(sel, scope)
+	s.c.Errors = s.c.Errors[:errs]
+	return cols
+}
+
+// checkSelect は SELECT を検証して結果列を返す。列が分からない（未定義テーブルの *）場合は nil。
+func (s *sqlChecker) checkSelect(sel *sqlparser.SelectStmt, parent *sqlScope) []sqlResultColumn {
+	scope := s.withScope(sel.With, parent)
+	var result []sqlResultColumn
+	var firstScope *sqlScope
+	unknown := false
+	for i, core := range sel.Cores {
+		cols, coreScope := s.checkCore(core, scope)
+		if i == 0 {
+			result, firstScope = cols, coreScope
+			unknown = cols == nil
+			continue
+		}
+		if cols == nil || unknown {
+			unknown = true
+			continue
+		}
+		if len(cols) != len(result) {
+			s.errorf(core.Span, "SELECTs to the left and right of %s do not have the same number of result columns", core.Compound)
+			continue
+		}
+		// 複合 SELECT の列の型は全ての SELECT で一致するときだけ確定させる
+		for j := range result {
+			merged := result[j].typ
+			other := cols[j].typ
+			if !merged.known || !other.known || !merged.t.Equals(other.t) {
+				result[j].typ = sqlUnknownType
+				continue
+			}
+			result[j].typ.nullable = merged.nullable || other.nullable
+		}
+	}
+	orderScope := firstScope
+	if len(sel.Cores) > 1 {
+		// 複合 SELECT の ORDER BY は結果列だけを参照できる
+		orderScope = &sqlScope{parent: scope, aliases: map[string]sqlValueType{}}
+		for _, col := range result {
+			orderScope.aliases[col.name] = col.typ
+		}
+		if unknown {
+			// 列の分からない SELECT * を含むときは何でも受け付ける
+			orderScope.sources = []*sqlSource{{}}
+		}
+	}
+	for _, term := range sel.OrderBy {
+		s.checkOrderTerm(term, orderScope)
+	}
+	s.checkOptionalExpr(sel.Limit, scope)
+	s.checkOptionalExpr(sel.Offset, scope)
+	if unknown {
+		return nil
+	}
+	return result
+}
+
+func (s *sqlChecker) checkOrderTerm(term *sqlparser.OrderingTerm, scope *sqlScope) {
+	if param, ok := term.Expr.(*sqlparser.Param); ok {
+		s.errorf(param.Span, "SQL parameters are values and cannot choose the ORDER BY column")
+		return
+	}
+	s.checkExpr(term.Expr, scope)
+}
+
+func (s *sqlChecker) checkCore(core *sqlparser.SelectCore, parent *sqlScope) ([]sqlResultColumn, *sqlScope) {
+	scope := &sqlScope{parent: parent}
+	if core.Values != nil {
+		var result []sqlResultColumn
+		for i, row := range core.Values {
+			types := make([]sqlValueType, len(row.Exprs))
+			for j, expr := range row.Exprs {
+				types[j] = s.checkExpr(expr, scope)
+			}
+			if i == 0 {
+				for j, t := range types {
+					result = append(result, sqlResultColumn{name: "column" + strconv.Itoa(j+1), typ: t})
+				}
+				continue
+			}
+			if len(row.Exprs) != len(result) {
+				s.errorf(row.Span, "all VALUES must have the same number of terms")
+			}
+		}
+		return result, scope
+	}
+
+	s.addFrom(scope, core.From, parent)
+
+	var result []sqlResultColumn
+	unknown := false
+	for _, col := range core.Columns {
+		switch {
+		case col.Star && col.Table != nil:
+			source := scope.findSource(col.Table.Name)
+			if source == nil {
+				s.errorf(col.Table.Span, "table '%s' is not in the FROM clause", col.Table.Name)
+				unknown = true
+				continue
+			}
+			cols, ok := source.resultColumns()
+			if !ok {
+				unknown = true
+			}
+			result = append(result, cols...)
+		case col.Star:
+			if len(scope.sources) == 0 {
+				s.errorf(col.Span, "SELECT * requires a FROM clause")
+				continue
+			}
+			for _, source := range scope.sources {
+				cols, ok := source.resultColumns()
+				if !ok {
+					unknown = true
+				}
+				result = append(result, cols...)
+			}
+		default:
+			typ := s.checkExpr(col.Expr, scope)
+			result = append(result, sqlResultColumn{name: s.resultColumnName(col), typ: typ})
+		}
+	}
+
+	scope.aliases = map[string]sqlValueType{}
+	for i, col := range core.Columns {
+		if col.Alias != nil && i < len(result) {
+			scope.aliases[strings.ToLower(col.Alias.Name)] = s.aliasType(col, scope)
+		}
+	}
+	s.checkOptionalExpr(core.Where, scope)
+	for _, expr := range core.GroupBy {
+		if param, ok := expr.(*sqlparser.Param); ok {
+			s.errorf(param.Span, "SQL parameters are values and cannot choose the GROUP BY column")
+			continue
+		}
+		s.checkExpr(expr, scope)
+	}
+	s.checkOptionalExpr(core.Having, scope)
+	if unknown {
+		return nil, scope
+	}
+	return result, scope
+}
+
+// aliasType は別名の付いた結果列の型（* を含む SELECT でも位置がずれないよう式から求め直す）。
+func (s *sqlChecker) aliasType(col *sqlparser.ResultColumn, scope *sqlScope) sqlValueType {
+	errs := len(s.c.Errors)
+	t := s.checkExpr(col.Expr, scope)
+	s.c.Errors = s.c.Errors[:errs]
+	return t
+}
+
+// resultColumnName は結果オブジェクトのキー。実行時は SQLite の列名を小文字にしたものになる。
+func (s *sqlChecker) resultColumnName(col *sqlparser.ResultColumn) string {
+	if col.Alias != nil {
+		return strings.ToLower(col.Alias.Name)
+	}
+	switch e := col.Expr.(type) {
+	case *sqlparser.ColumnRef:
+		return strings.ToLower(e.Column.Name)
+	}
+	// 式の列名は SQLite と同じく書いたとおりの式（count(*)、last_insert_rowid() など）
+	span := col.Expr.GetSpan()
+	return strings.ToLower(s.expr.Query[span.Pos:span.End])
+}
+
+// resultColumns は source の全列を * の展開順（列名順）で返す。
+func (source *sqlSource) resultColumns() ([]sqlResultColumn, bool) {
+	if !source.known {
+		return nil, false
+	}
+	names := make([]string, 0, len(source.columns))
+	for name := range source.columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cols := make([]sqlResultColumn, len(names))
+	for i, name := range names {
+		t := source.columns[name]
+		t.nullable = t.nullable || source.nullable
+		cols[i] = sqlResultColumn{name: name, typ: t}
+	}
+	return cols, true
+}
+
+// addFrom は FROM 句のテーブルをスコープに追加し、ON / USING を検証する。
+// サブクエリや関数の引数は outer（FROM 句の外側のスコープ）で解決する。
+func (s *sqlChecker) addFrom(scope *sqlScope, refs []*sqlparser.TableRef, outer *sqlScope) {
+	for _, ref := range refs {
+		start := len(scope.sources)
+		switch {
+		case ref.Group != nil:
+			s.addFrom(scope, ref.Group, outer)
+		case ref.Subquery != nil:
+			cols := s.checkSelect(ref.Subquery, outer)
+			source := &sqlSource{known: cols != nil, columns: map[string]sqlValueType{}}
+			for _, col := range cols {
+				source.columns[col.name] = col.typ
+			}
+			if ref.Alias != nil {
+				source.name = strings.ToLower(ref.Alias.Name)
+				source.table = ref.Alias.Name
+			}
+			scope.sources = append(scope.sources, source)
+		case ref.IsFunc:
+			// テーブル値関数（json_each, pragma_table_info など）の列は検証しない
+			for _, arg := range ref.Args {
+				s.checkExpr(arg, scope)
+			}
+			source := &sqlSource{name: strings.ToLower(ref.Name.Name), table: ref.Name.Name}
+			if ref.Alias != nil {
+				source.name = strings.ToLower(ref.Alias.Name)
+			}
+			scope.sources = append(scope.sources, source)
+		default:
+			source := s.lookupTable(ref.Name, outer)
+			if ref.Alias != nil {
+				source.name = strings.ToLower(ref.Alias.Name)
+			}
+			scope.sources = append(scope.sources, source)
+		}
+		join := ref.Join
+		if join == nil {
+			continue
+		}
+		added := scope.sources[start:]
+		switch join.Op {
+		case "LEFT JOIN":
+			for _, source := range added {
+				source.nullable = true
+			}
+		case "RIGHT JOIN":
+			for _, source := range scope.sources[:start] {
+				source.nullable = true
+			}
+		case "FULL JOIN":
+			for _, source := range scope.sources {
+				source.nullable = true
+			}
+		}
+		var usingCols []string
+		for _, col := range join.Using {
+			name := strings.ToLower(col.Name)
+			usingCols = append(usingCols, name)
+			if !scope.hasColumn(scope.sources[:start], name) || !scope.hasColumn(added, name) {
+				s.errorf(col.Span, "cannot join using column '%s' - column not present in both tables", col.Name)
+			}
+		}
+		if join.Natural {
+			for _, source := range added {
+				for name := range source.columns {
+					usingCols = append(usingCols, name)
+				}
+			}
+		}
+		for _, source := range scope.sources {
+			if source.using == nil {
+				source.using = map[string]bool{}
+			}
+			for _, name := range usingCols {
+				source.using[name] = true
+			}
+		}
+		s.checkOptionalExpr(join.On, scope)
+	}
+}
+
+func (scope *sqlScope) hasColumn(sources []*sqlSource, name string) bool {
+	for _, source := range sources {
+		if !source.known {
+			return true
+		}
+		if _, ok := source.columns[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupTable はテーブル名を CTE、create_table の定義の順に解決する。
+func (s *sqlChecker) lookupTable(name *sqlparser.Ident, scope *sqlScope) *sqlSource {
+	lower := strings.ToLower(name.Name)
+	for sc := scope; sc != nil; sc = sc.parent {
+		if cte, ok := sc.ctes[lower]; ok {
+			copied := *cte
+			return &copied
+		}
+	}
+	if info := s.c.sqlTable(name.Name); info != nil {
+		source := &sqlSource{name: lower, table: info.Name, known: true, isTable: true, columns: map[string]sqlValueType{}}
+		for colName, col := range info.Columns {
+			source.columns[strings.ToLower(colName)] = sqlValueType{t: sqliteAffinityType(col.Type), nullable: col.Nullable(), known: true}
+		}
+		return source
+	}
+	// sqlite_master などの内部テーブルは検証しない
+	if s.strict && !strings.HasPrefix(lower, "sqlite_") {
+		s.errorf(name.Span, "table '%s' is not defined", name.Name)
+	}
+	return &sqlSource{name: lower, table: name.Name}
+}
+
+// checkTableConstraints は create_table のテーブル制約が参照する列が定義されていることを確認する。
+// FOREIGN KEY の参照先が create_table で定義されていれば、参照先の列も確認する。
+func (c *Checker) checkTableConstraints(d *ast.TableDecl) {
+	table := c.sqlTable(d.Name)
+	if table == nil || table.Connection != d.Connection {
+		return
+	}
+	for _, con := range d.Constraints {
+		for _, name := range con.Columns {
+			if table.column(name) == nil {
+				c.errorf(d.Span, "column '%s' in constraint '%s' does not exist in table '%s'", name, con.SQL, d.Name)
+			}
+		}
+		if con.Kind != "foreign_key" {
+			continue
+		}
+		ref := c.sqlTable(con.RefTable)
+		if ref == nil {
+			continue
+		}
+		if ref.Connection != d.Connection {
+			c.errorf(d.Span, "foreign key references table '%s' of another connection", ref.Name)
+			continue
+		}
+		if len(con.RefColumns) > 0 && len(con.RefColumns) != len(con.Columns) {
+			c.errorf(d.Span, "foreign key '%s' has %d columns but references %d", con.SQL, len(con.Columns), len(con.RefColumns))
+		}
+		for _, name := range con.RefColumns {
+			if ref.column(name) == nil {
+				c.errorf(d.Span, "foreign key references column '%s' that does not exist in table '%s'", name, ref.Name)
+			}
+		}
+	}
+}
+
+// checkIndexDecl は create_index の対象テーブルと列が create_table で定義されていることを確認する。
+func (c *Checker) checkIndexDecl(d *ast.IndexDecl) {
+	table := c.sqlTable(d.Table)
+	if table == nil || table.Connection != d.Connection {
+		c.errorf(d.Span, "index '%s': table '%s' is not defined", d.Name, d.Table)
+		return
+	}
+	for _, name := range d.Columns {
+		if table.column(name) == nil {
+			c.errorf(d.Span, "index '%s': column '%s' does not exist in table '%s'", d.Name, name, table.Name)
+		}
+	}
+}
+
+// column は列を大文字小文字を区別せずに探す。
+func (t *TableInfo) column(name string) *ColumnInfo {
+	if col, ok := t.Columns[name]; ok {
+		return col
+	}
+	for colName, col := range t.Columns {
+		if strings.EqualFold(colName, name) {
+			return col
+		}
+	}
+	return nil
+}
+
+// sqlTable は create_table で定義したテーブルを大文字小文字を区別せずに探す。
+func (c *Checker) sqlTable(name string) *TableInfo {
+	if info, ok := c.Tables[name]; ok {
+		return info
+	}
+	for tableName, info := range c.Tables {
+		if strings.EqualFold(tableName, name) {
+			return info
+		}
+	}
+	return nil
+}
+
+func (scope *sqlScope) findSource(name string) *sqlSource {
+	lower := strings.ToLower(name)
+	for _, source := range scope.sources {
+		if source.name == lower {
+			return source
+		}
+	}
+	return nil
+}
+
+func (s *sqlChecker) checkOptionalExpr(expr sqlparser.Expr, scope *sqlScope) {
+	if expr != nil {
+		s.checkExpr(expr, scope)
+	}
+}
+
+// checkExpr は式の中の列参照とパラメータを検証し、式の型を返す。
+func (s *sqlChecker) checkExpr(expr sqlparser.Expr, scope *sqlScope) sqlValueType {
+	switch e := expr.(type) {
+	case *sqlparser.Literal:
+		if e.Kind == sqlparser.LiteralNull {
+			return sqlValueType{t: String(), nullable: true}
+		}
+	case *sqlparser.Param:
+		if !s.params[e.Pos] {
+			s.errorf(e.Span, "use {expr} to embed SQL parameters instead of '%s'", e.Text)
+		}
+	case *sqlparser.ColumnRef:
+		return s.resolveColumn(e, scope)
+	case *sqlparser.UnaryExpr:
+		s.checkExpr(e.X, scope)
+	case *sqlparser.BinaryExpr:
+		s.checkExpr(e.X, scope)
+		s.checkExpr(e.Y, scope)
+	case *sqlparser.PostfixExpr:
+		s.checkExpr(e.X, scope)
+	case *sqlparser.LikeExpr:
+		s.checkExpr(e.X, scope)
+		s.checkExpr(e.Pattern, scope)
+		s.checkOptionalExpr(e.Escape, scope)
+	case *sqlparser.BetweenExpr:
+		s.checkExpr(e.X, scope)
+		s.checkExpr(e.Lo, scope)
+		s.checkExpr(e.Hi, scope)
+	case *sqlparser.InExpr:
+		s.checkExpr(e.X, scope)
+		for _, item := range e.List {
+			s.checkExpr(item, scope)
+		}
+		if e.Select != nil {
+			s.checkSelect(e.Select, scope)
+		}
+		if e.Table != nil {
+			s.lookupTable(e.Table, scope)
+		}
+	case *sqlparser.CallExpr:
+		for _, arg := range e.Args {
+			s.checkExpr(arg, scope)
+		}
+		s.checkOptionalExpr(e.Filter, scope)
+		if e.Over != nil {
+			for _, part := range e.Over.PartitionBy {
+				s.checkExpr(part, scope)
+			}
+			for _, term := range e.Over.OrderBy {
+				s.checkExpr(term.Expr, scope)
+			}
+		}
+		switch strings.ToLower(e.Name.Name) {
+		case "count":
+			return sqlValueType{t: I64(), known: true}
+		case "last_insert_rowid":
+			if len(e.Args) == 0 {
+				return sqlValueType{t: I64(), known: true}
+			}
+		}
+	case *sqlparser.CastExpr:
+		inner := s.checkExpr(e.X, scope)
+		_, isLiteral := e.X.(*sqlparser.Literal)
+		// 型の分からない式は NULL になり得るものとして扱う
+		nullable := inner.nullable || (!inner.known && !isLiteral)
+		if lit, ok := e.X.(*sqlparser.Literal); ok && lit.Kind == sqlparser.LiteralNull {
+			nullable = true
+		}
+		return sqlValueType{t: sqliteAffinityType(e.Type), nullable: nullable, known: true}
+	case *sqlparser.CaseExpr:
+		s.checkOptionalExpr(e.Operand, scope)
+		for _, when := range e.Whens {
+			s.checkExpr(when.Cond, scope)
+			s.checkExpr(when.Result, scope)
+		}
+		s.checkOptionalExpr(e.Else, scope)
+	case *sqlparser.ExistsExpr:
+		s.checkSelect(e.Select, scope)
+	case *sqlparser.SubqueryExpr:
+		s.checkSelect(e.Select, scope)
+	case *sqlparser.ParenExpr:
+		if len(e.List) == 1 {
+			return s.checkExpr(e.List[0], scope)
+		}
+		for _, item := range e.List {
+			s.checkExpr(item, scope)
+		}
+	case *sqlparser.CollateExpr:
+		return s.checkExpr(e.X, scope)
+	}
+	return sqlUnknownType
+}
+
+// resolveColumn は列参照を内側のスコープから順に解決する。
+func (s *sqlChecker) resolveColumn(ref *sqlparser.ColumnRef, scope *sqlScope) sqlValueType {
+	name := strings.ToLower(ref.Column.Name)
+	if ref.Table != nil {
+		for sc := scope; sc != nil; sc = sc.parent {
+			source := sc.findSource(ref.Table.Name)
+			if source == nil {
+				continue
+			}
+			if t, ok := source.column(name); ok {
+				return t
+			}
+			s.errorf(ref.Column.Span, "column '%s' does not exist in table '%s'", ref.Column.Name, source.table)
+			return sqlUnknownType
+		}
+		if s.c.sqlTable(ref.Table.Name) != nil || !s.strict {
+			s.errorf(ref.Table.Span, "table '%s' is not in the FROM clause", ref.Table.Name)
+		} else {
+			s.errorf(ref.Table.Span, "table '%s' is not defined", ref.Table.Name)
+		}
+		return sqlUnknownType
+	}
+
+	for sc := scope; sc != nil; sc = sc.parent {
+		var found *sqlSource
+		var foundType sqlValueType
+		maybe := false
+		for _, source := range sc.sources {
+			if !source.known {
+				maybe = true
+				continue
+			}
+			t, ok := source.column(name)
+			if !ok {
+				continue
+			}
+			if found != nil && !(found.using[name] && source.using[name]) {
+				s.errorf(ref.Span, "column '%s' is ambiguous", ref.Column.Name)
+				return sqlUnknownType
+			}
+			if found == nil {
+				found, foundType = source, t
+			}
+		}
+		if found != nil {
+			return foundType
+		}
+		if maybe {
+			return sqlUnknownType
+		}
+		if t, ok := sc.aliases[name]; ok {
+			return t
+		}
+	}
+
+	// SQLite は解決できない "name" を文字列リテラルとして扱う
+	if ref.Column.Quote == '"' {
+		return sqlUnknownType
+	}
+	var tables []string
+	if scope != nil {
+		for _, source := range scope.sources {
+			if source.table != "" {
+				tables = append(tables, "'"+source.table+"'")
+			}
+		}
+	}
+	switch len(tables) {
+	case 0:
+		s.errorf(ref.Span, "column '%s' does not exist", ref.Column.Name)
+	case 1:
+		s.errorf(ref.Span, "column '%s' does not exist in table %s", ref.Column.Name, tables[0])
+	default:
+		s.errorf(ref.Span, "column '%s' does not exist in tables %s", ref.Column.Name, strings.Join(tables, ", "))
+	}
+	return sqlUnknownType
+}
+
+func (source *sqlSource) column(name string) (sqlValueType, bool) {
+	if !source.known {
+		return sqlUnknownType, true
+	}
+	t, ok := source.columns[name]
+	if !ok {
+		if source.isTable && (name == "rowid" || name == "oid" || name == "_rowid_") {
+			return sqlValueType{t: I64(), known: true}, true
+		}
+		return sqlValueType{}, false
+	}
+	t.nullable = t.nullable || source.nullable
+	return t, true
+}
+
+// targetScope は INSERT / UPDATE / DELETE の対象テーブルだけを持つスコープを作る。
+func (s *sqlChecker) targetScope(parent *sqlScope, table *sqlparser.Ident, alias *sqlparser.Ident) (*sqlScope, *sqlSource) {
+	source := s.lookupTable(table, parent)
+	if alias != nil {
+		source.name = strings.ToLower(alias.Name)
+	}
+	return &sqlScope{parent: parent, sources: []*sqlSource{source}}, source
+}
+
+func (s *sqlChecker) checkTargetColumn(source *sqlSource, col *sqlparser.Ident) {
+	if _, ok := source.column(strings.ToLower(col.Name)); !ok {
+		s.errorf(col.Span, "column '%s' does not exist in table '%s'", col.Name, source.table)
+	}
+}
+
+func (s *sqlChecker) checkReturning(cols []*sqlparser.ResultColumn, scope *sqlScope) []sqlResultColumn {
+	if len(cols) == 0 {
+		return nil
+	}
+	var result []sqlResultColumn
+	unknown := false
+	for _, col := range cols {
+		if col.Star {
+			for _, source := range scope.sources {
+				cols, ok := source.resultColumns()
+				if !ok {
+					unknown = true
+				}
+				result = append(result, cols...)
+			}
+			continue
+		}
+		result = append(result, sqlResultColumn{name: s.resultColumnName(col), typ: s.checkExpr(col.Expr, scope)})
+	}
+	if unknown {
+		return nil
+	}
+	return result
+}
+
+func (s *sqlChecker) checkInsert(st *sqlparser.InsertStmt) []sqlResultColumn {
+	outer := s.withScope(st.With, nil)
+	scope, source := s.targetScope(outer, st.Table, st.Alias)
+	for _, col := range st.Columns {
+		s.checkTargetColumn(source, col)
+	}
+
+	want := len(st.Columns)
+	if want == 0 && source.known {
+		want = len(source.columns)
+	}
+	if st.Select != nil {
+		if len(st.Select.Cores) == 1 && st.Select.Cores[0].Values != nil && st.Select.With == nil {
+			core := st.Select.Cores[0]
+			valuesScope := &sqlScope{parent: outer}
+			for _, row := range core.Values {
+				for _, expr := range row.Exprs {
+					s.checkExpr(expr, valuesScope)
+				}
+				if want > 0 && len(row.Exprs) != want {
+					s.errorf(row.Span, "%d values for %d columns", len(row.Exprs), want)
+				}
+			}
+		} else if cols := s.checkSelect(st.Select, outer); cols != nil && want > 0 && len(cols) != want {
+			s.errorf(st.Select.Span, "SELECT returns %d columns but INSERT expects %d", len(cols), want)
+		}
+	}
+
+	for _, up := range st.Upserts {
+		// DO UPDATE では excluded.col で挿入しようとした値を参照できる
+		excluded := *source
+		excluded.name = "excluded"
+		upScope := &sqlScope{parent: outer, sources: []*sqlSource{source, &excluded}}
+		for _, target := range up.Target {
+			s.checkExpr(target, scope)
+		}
+		s.checkOptionalExpr(up.TargetWhere, scope)
+		for _, assign := range up.Set {
+			for _, col := range assign.Columns {
+				s.checkTargetColumn(source, col)
+			}
+			s.checkExpr(assign.Value, upScope)
+		}
+		s.checkOptionalExpr(up.Where, upScope)
+	}
+	return s.checkReturning(st.Returning, scope)
+}
+
+func (s *sqlChecker) checkUpdate(st *sqlparser.UpdateStmt) []sqlResultColumn {
+	outer := s.withScope(st.With, nil)
+	scope, source := s.targetScope(outer, st.Table, st.Alias)
+	if len(st.From) > 0 {
+		s.addFrom(scope, st.From, outer)
+	}
+	for _, assign := range st.Set {
+		for _, col := range assign.Columns {
+			s.checkTargetColumn(source, col)
+		}
+		s.checkExpr(assign.Value, scope)
+	}
+	s.checkOptionalExpr(st.Where, scope)
+	for _, term := range st.OrderBy {
+		s.checkOrderTerm(term, scope)
+	}
+	s.checkOptionalExpr(st.Limit, scope)
+	s.checkOptionalExpr(st.Offset, scope)
+	return s.checkReturning(st.Returning, scope)
+}
+
+func (s *sqlChecker) checkDelete(st *sqlparser.DeleteStmt) []sqlResultColumn {
+	outer := s.withScope(st.With, nil)
+	scope, _ := s.targetScope(outer, st.Table, st.Alias)
+	s.checkOptionalExpr(st.Where, scope)
+	for _, term := range st.OrderBy {
+		s.checkOrderTerm(term, scope)
+	}
+	s.checkOptionalExpr(st.Limit, scope)
+	s.checkOptionalExpr(st.Offset, scope)
+	return s.checkReturning(st.Returning, scope)
+}
@@ -36,12 +36,15 @@ type Type struct {
 	Props        []Prop
 	Union        []*Type
 	Index        *Type
+	Refine       *Refinement // type ... where { ... } の制約（型の互換性には影響しない）
 	propIx       map[string]*Type
 }
 
 type Prop struct {
-	Name string
-	Type *Type
+	Name       string
+	Type       *Type
+	HasDefault bool        // key: T = value
+	Default    interface{} // JSON 値（decode でフィールドが無いときに使う）
 }
 
 func (t *Type) Equals(o *Type) bool {
@@ -0,0 +1,845 @@
+;; CSV module functions implemented in WAT (shared by gc and host backends).
+;; decode_csv は列をプロパティの型に合わせて変換したあと $json.decode で検査する。
+
+;; 列の組み立てと to_csv の出力に使うバッファ
+(global $csv_buf_ptr (mut i32) (i32.const 0))
+(global $csv_buf_len (mut i32) (i32.const 0))
+(global $csv_buf_cap (mut i32) (i32.const 0))
+
+;; 読み取り中の位置と行番号
+(global $csv_p (mut i32) (i32.const 0))
+(global $csv_end (mut i32) (i32.const 0))
+(global $csv_line (mut i32) (i32.const 0))
+
+(data $csv_d_delimiter "delimiter")
+(data $csv_d_header "header")
+(data $csv_d_line "line ")
+(data $csv_d_colon_space ": ")
+(data $csv_d_invalid_delimiter "delimiter must be one ASCII character other than \" and line breaks")
+(data $csv_d_bare_quote "bare \" in non-quoted field")
+(data $csv_d_extraneous_quote "extraneous \" in field")
+(data $csv_d_unterminated "unterminated quoted field")
+(data $csv_d_expected "expected ")
+(data $csv_d_fields_found " fields, found ")
+
+(func $csv._k_delimiter (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 9)))
+  (memory.init $csv_d_delimiter (local.get $ptr) (i32.const 0) (i32.const 9))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 9))
+)
+
+(func $csv._k_header (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 6)))
+  (memory.init $csv_d_header (local.get $ptr) (i32.const 0) (i32.const 6))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 6))
+)
+
+(func $csv._str_line (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 5)))
+  (memory.init $csv_d_line (local.get $ptr) (i32.const 0) (i32.const 5))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 5))
+)
+
+(func $csv._str_colon_space (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 2)))
+  (memory.init $csv_d_colon_space (local.get $ptr) (i32.const 0) (i32.const 2))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 2))
+)
+
+(func $csv._msg_invalid_delimiter (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 66)))
+  (memory.init $csv_d_invalid_delimiter (local.get $ptr) (i32.const 0) (i32.const 66))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 66))
+)
+
+(func $csv._msg_bare_quote (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 26)))
+  (memory.init $csv_d_bare_quote (local.get $ptr) (i32.const 0) (i32.const 26))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 26))
+)
+
+(func $csv._msg_extraneous_quote (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 21)))
+  (memory.init $csv_d_extraneous_quote (local.get $ptr) (i32.const 0) (i32.const 21))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 21))
+)
+
+(func $csv._msg_unterminated (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 25)))
+  (memory.init $csv_d_unterminated (local.get $ptr) (i32.const 0) (i32.const 25))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 25))
+)
+
+(func $csv._str_expected (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 9)))
+  (memory.init $csv_d_expected (local.get $ptr) (i32.const 0) (i32.const 9))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 9))
+)
+
+(func $csv._str_fields_found (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 15)))
+  (memory.init $csv_d_fields_found (local.get $ptr) (i32.const 0) (i32.const 15))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 15))
+)
+
+(func $csv._error_at (param $line i32) (param $msg anyref) (result anyref)
+  (call $prelude.error
+    (call $prelude.str_concat
+      (call $prelude.str_concat
+        (call $prelude.str_concat
+          (call $csv._str_line)
+          (call $prelude._i64_to_string (i64.extend_i32_u (local.get $line))))
+        (call $csv._str_colon_space))
+      (local.get $msg)))
+)
+
+(func $csv._buf_reserve (param $extra i32)
+  (local $need i32)
+  (local $cap i32)
+  (local $new_ptr i32)
+
+  (local.set $need (i32.add (global.get $csv_buf_len) (local.get $extra)))
+  (if (i32.le_u (local.get $need) (global.get $csv_buf_cap))
+    (then
+      (return)
+    )
+  )
+  (local.set $cap (global.get $csv_buf_cap))
+  (if (i32.eqz (local.get $cap))
+    (then
+      (local.set $cap (i32.const 64))
+    )
+  )
+  (block $cap_ok
+    (loop $grow
+      (br_if $cap_ok (i32.ge_u (local.get $cap) (local.get $need)))
+      (local.set $cap (i32.shl (local.get $cap) (i32.const 1)))
+      (br $grow)
+    )
+  )
+  (local.set $new_ptr (call $prelude._alloc (local.get $cap)))
+  (if (i32.gt_u (global.get $csv_buf_len) (i32.const 0))
+    (then
+      (memory.copy (local.get $new_ptr) (global.get $csv_buf_ptr) (global.get $csv_buf_len))
+    )
+  )
+  (global.set $csv_buf_ptr (local.get $new_ptr))
+  (global.set $csv_buf_cap (local.get $cap))
+)
+
+(func $csv._buf_byte (param $b i32)
+  (call $csv._buf_reserve (i32.const 1))
+  (i32.store8 (i32.add (global.get $csv_buf_ptr) (global.get $csv_buf_len)) (local.get $b))
+  (global.set $csv_buf_len (i32.add (global.get $csv_buf_len) (i32.const 1)))
+)
+
+(func $csv._buf_string (param $s anyref)
+  (local $len i32)
+  (local.set $len (call $prelude._string_bytelen (local.get $s)))
+  (if (i32.eqz (local.get $len))
+    (then
+      (return)
+    )
+  )
+  (call $csv._buf_reserve (local.get $len))
+  (memory.copy
+    (i32.add (global.get $csv_buf_ptr) (global.get $csv_buf_len))
+    (call $prelude._string_ptr (local.get $s))
+    (local.get $len))
+  (global.set $csv_buf_len (i32.add (global.get $csv_buf_len) (local.get $len)))
+)
+
+(func $csv._buf_take (result anyref)
+  (call $prelude._new_string_copy (global.get $csv_buf_ptr) (global.get $csv_buf_len))
+)
+
+;; arr[count] = value。足りなければ広げた配列を返す
+(func $csv._push (param $arr anyref) (param $count i32) (param $value anyref) (result anyref)
+  (local $cap i32)
+  (local $grown anyref)
+  (local $i i32)
+
+  (local.set $cap (call $prelude.arr_len (local.get $arr)))
+  (if (i32.ge_u (local.get $count) (local.get $cap))
+    (then
+      (local.set $grown
+        (call $prelude.arr_new (i32.add (i32.mul (local.get $cap) (i32.const 2)) (i32.const 4))))
+      (block $done
+        (loop $copy
+          (br_if $done (i32.ge_u (local.get $i) (local.get $count)))
+          (call $prelude.arr_set
+            (local.get $grown)
+            (local.get $i)
+            (call $prelude.arr_get (local.get $arr) (local.get $i)))
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $copy)
+        )
+      )
+      (local.set $arr (local.get $grown))
+    )
+  )
+  (call $prelude.arr_set (local.get $arr) (local.get $count) (local.get $value))
+  (local.get $arr)
+)
+
+;; 先頭 count 個だけの配列にする
+(func $csv._trim (param $arr anyref) (param $count i32) (result anyref)
+  (local $out anyref)
+  (local $i i32)
+
+  (if (i32.eq (call $prelude.arr_len (local.get $arr)) (local.get $count))
+    (then
+      (return (local.get $arr))
+    )
+  )
+  (local.set $out (call $prelude.arr_new (local.get $count)))
+  (block $done
+    (loop $copy
+      (br_if $done (i32.ge_u (local.get $i) (local.get $count)))
+      (call $prelude.arr_set
+        (local.get $out)
+        (local.get $i)
+        (call $prelude.arr_get (local.get $arr) (local.get $i)))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $copy)
+    )
+  )
+  (local.get $out)
+)
+
+;; 改行（\n / \r\n / \r）なら読み進めて 1 を返す
+(func $csv._skip_newline (result i32)
+  (local $c i32)
+  (if (i32.ge_u (global.get $csv_p) (global.get $csv_end))
+    (then
+      (return (i32.const 0))
+    )
+  )
+  (local.set $c (i32.load8_u (global.get $csv_p)))
+  (if (i32.eq (local.get $c) (i32.const 10))
+    (then
+      (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 1)))
+      (global.set $csv_line (i32.add (global.get $csv_line) (i32.const 1)))
+      (return (i32.const 1))
+    )
+  )
+  (if (i32.eq (local.get $c) (i32.const 13))
+    (then
+      (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 1)))
+      (if (i32.and
+            (i32.lt_u (global.get $csv_p) (global.get $csv_end))
+            (i32.eq (i32.load8_u (global.get $csv_p)) (i32.const 10)))
+        (then
+          (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 1)))
+        )
+      )
+      (global.set $csv_line (i32.add (global.get $csv_line) (i32.const 1)))
+      (return (i32.const 1))
+    )
+  )
+  (i32.const 0)
+)
+
+;; 列の後ろを読む。1: 次の列がある、2: 行の終わり、0: 区切り文字でも改行でもない
+(func $csv._field_end (param $delim i32) (result i32)
+  (if (i32.ge_u (global.get $csv_p) (global.get $csv_end))
+    (then
+      (return (i32.const 2))
+    )
+  )
+  (if (i32.eq (i32.load8_u (global.get $csv_p)) (local.get $delim))
+    (then
+      (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 1)))
+      (return (i32.const 1))
+    )
+  )
+  (if (call $csv._skip_newline)
+    (then
+      (return (i32.const 2))
+    )
+  )
+  (i32.const 0)
+)
+
+;; text を行ごとの列の配列（string[][]）にする。check なら列数が1行目と同じかを検査する
+(func $csv._parse (param $text anyref) (param $delim i32) (param $check i32) (result anyref)
+  (local $len i32)
+  (local $rows anyref)
+  (local $nrows i32)
+  (local $fields anyref)
+  (local $nfields i32)
+  (local $expected i32)
+  (local $rec_line i32)
+  (local $start i32)
+  (local $c i32)
+  (local $field anyref)
+  (local $next i32)
+
+  (local.set $len (call $prelude._string_bytelen (local.get $text)))
+  (global.set $csv_p (call $prelude._string_ptr (local.get $text)))
+  (global.set $csv_end (i32.add (global.get $csv_p) (local.get $len)))
+  (global.set $csv_line (i32.const 1))
+  ;; UTF-8 の BOM
+  (if (i32.and
+        (i32.ge_u (local.get $len) (i32.const 3))
+        (i32.and
+          (i32.eq (i32.load8_u (global.get $csv_p)) (i32.const 0xef))
+          (i32.and
+            (i32.eq (i32.load8_u offset=1 (global.get $csv_p)) (i32.const 0xbb))
+            (i32.eq (i32.load8_u offset=2 (global.get $csv_p)) (i32.const 0xbf)))))
+    (then
+      (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 3)))
+    )
+  )
+  (local.set $rows (call $prelude.arr_new (i32.const 8)))
+  (local.set $expected (i32.const -1))
+
+  (block $done
+    (loop $records
+      (br_if $done (i32.ge_u (global.get $csv_p) (global.get $csv_end)))
+      ;; 空行は読み飛ばす
+      (br_if $records (call $csv._skip_newline))
+
+      (local.set $rec_line (global.get $csv_line))
+      (local.set $fields (call $prelude.arr_new (i32.const 8)))
+      (local.set $nfields (i32.const 0))
+      (loop $fields_loop
+        (if (i32.and
+              (i32.lt_u (global.get $csv_p) (global.get $csv_end))
+              (i32.eq (i32.load8_u (global.get $csv_p)) (i32.const 34)))
+          (then
+            ;; "..." の列。"" は " 1文字、\r\n は \n にする
+            (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 1)))
+            (global.set $csv_buf_len (i32.const 0))
+            (block $quoted_done
+              (loop $quoted
+                (if (i32.ge_u (global.get $csv_p) (global.get $csv_end))
+                  (then
+                    (return (call $csv._error_at (local.get $rec_line) (call $csv._msg_unterminated)))
+                  )
+                )
+                (local.set $c (i32.load8_u (global.get $csv_p)))
+                (if (i32.eq (local.get $c) (i32.const 34))
+                  (then
+                    (if (i32.and
+                          (i32.lt_u (i32.add (global.get $csv_p) (i32.const 1)) (global.get $csv_end))
+                          (i32.eq (i32.load8_u offset=1 (global.get $csv_p)) (i32.const 34)))
+                      (then
+                        (call $csv._buf_byte (i32.const 34))
+                        (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 2)))
+                        (br $quoted)
+                      )
+                    )
+                    (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 1)))
+                    (br $quoted_done)
+                  )
+                )
+                (if (i32.and
+                      (i32.eq (local.get $c) (i32.const 13))
+                      (i32.and
+                        (i32.lt_u (i32.add (global.get $csv_p) (i32.const 1)) (global.get $csv_end))
+                        (i32.eq (i32.load8_u offset=1 (global.get $csv_p)) (i32.const 10))))
+                  (then
+                    (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 1)))
+                    (local.set $c (i32.const 10))
+                  )
+                )
+                (if (i32.eq (local.get $c) (i32.const 10))
+                  (then
+                    (global.set $csv_line (i32.add (global.get $csv_line) (i32.const 1)))
+                  )
+                )
+                (call $csv._buf_byte (local.get $c))
+                (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 1)))
+                (br $quoted)
+              )
+            )
+            (local.set $field (call $csv._buf_take))
+            (local.set $next (call $csv._field_end (local.get $delim)))
+            (if (i32.eqz (local.get $next))
+              (then
+                (return (call $csv._error_at (global.get $csv_line) (call $csv._msg_extraneous_quote)))
+              )
+            )
+          )
+          (else
+            (local.set $start (global.get $csv_p))
+            (block $plain_done
+              (loop $plain
+                (br_if $plain_done (i32.ge_u (global.get $csv_p) (global.get $csv_end)))
+                (local.set $c (i32.load8_u (global.get $csv_p)))
+                (br_if $plain_done
+                  (i32.or
+                    (i32.eq (local.get $c) (local.get $delim))
+                    (i32.or
+                      (i32.eq (local.get $c) (i32.const 10))
+                      (i32.eq (local.get $c) (i32.const 13)))))
+                (if (i32.eq (local.get $c) (i32.const 34))
+                  (then
+                    (return (call $csv._error_at (global.get $csv_line) (call $csv._msg_bare_quote)))
+                  )
+                )
+                (global.set $csv_p (i32.add (global.get $csv_p) (i32.const 1)))
+                (br $plain)
+              )
+            )
+            (local.set $field
+              (call $prelude._new_string_copy
+                (local.get $start)
+                (i32.sub (global.get $csv_p) (local.get $start))))
+            (local.set $next (call $csv._field_end (local.get $delim)))
+          )
+        )
+        (local.set $fields (call $csv._push (local.get $fields) (local.get $nfields) (local.get $field)))
+        (local.set $nfields (i32.add (local.get $nfields) (i32.const 1)))
+        (br_if $fields_loop (i32.eq (local.get $next) (i32.const 1)))
+      )
+
+      (if (local.get $check)
+        (then
+          (if (i32.lt_s (local.get $expected) (i32.const 0))
+            (then
+              (local.set $expected (local.get $nfields))
+            )
+          )
+          (if (i32.ne (local.get $nfields) (local.get $expected))
+            (then
+              (return
+                (call $csv._error_at
+                  (local.get $rec_line)
+                  (call $prelude.str_concat
+                    (call $prelude.str_concat
+                      (call $prelude.str_concat
+                        (call $csv._str_expected)
+                        (call $prelude._i64_to_string (i64.extend_i32_u (local.get $expected))))
+                      (call $csv._str_fields_found))
+                    (call $prelude._i64_to_string (i64.extend_i32_u (local.get $nfields))))))
+            )
+          )
+        )
+      )
+      (local.set $rows
+        (call $csv._push
+          (local.get $rows)
+          (local.get $nrows)
+          (call $csv._trim (local.get $fields) (local.get $nfields))))
+      (local.set $nrows (i32.add (local.get $nrows) (i32.const 1)))
+      (br $records)
+    )
+  )
+  (call $csv._trim (local.get $rows) (local.get $nrows))
+)
+
+(func $csv.parse_csv (param $text anyref) (param $options anyref) (result anyref)
+  (local $delim anyref)
+  (local $d i32)
+
+  (local.set $delim (call $prelude.obj_get (local.get $options) (call $csv._k_delimiter)))
+  (if (i32.ne (call $prelude._string_bytelen (local.get $delim)) (i32.const 1))
+    (then
+      (return (call $prelude.error (call $csv._msg_invalid_delimiter)))
+    )
+  )
+  (local.set $d (i32.load8_u (call $prelude._string_ptr (local.get $delim))))
+  (if (i32.or
+        (i32.ge_u (local.get $d) (i32.const 0x80))
+        (i32.or
+          (i32.eq (local.get $d) (i32.const 34))
+          (i32.or
+            (i32.eq (local.get $d) (i32.const 10))
+            (i32.eq (local.get $d) (i32.const 13)))))
+    (then
+      (return (call $prelude.error (call $csv._msg_invalid_delimiter)))
+    )
+  )
+  (call $csv._parse
+    (local.get $text)
+    (local.get $d)
+    (call $prelude.val_to_bool
+      (call $prelude.obj_get (local.get $options) (call $csv._k_header))))
+)
+
+(func $csv._schema_code (param $schema anyref) (result i32)
+  (if (i32.eqz (call $json._obj_has_key (local.get $schema) (call $json._k_kind)))
+    (then
+      (return (i32.const 0))
+    )
+  )
+  (call $json._schema_kind_code (call $prelude.obj_get (local.get $schema) (call $json._k_kind)))
+)
+
+;; schema が val_kind の k（0 i64、1 f64、2 boolean、3 string、6 null）の値を受け付けるか
+(func $csv._accepts (param $schema anyref) (param $k i32) (result i32)
+  (local $code i32)
+  (local $union anyref)
+  (local $len i32)
+  (local $i i32)
+
+  (local.set $code (call $csv._schema_code (local.get $schema)))
+  (if (i32.eq (local.get $code) (i32.const 1)) ;; json
+    (then
+      (return (i32.const 1))
+    )
+  )
+  (if (i32.eq (local.get $code) (i32.const 3)) ;; null
+    (then
+      (return (i32.eq (local.get $k) (i32.const 6)))
+    )
+  )
+  (if (i32.eq (local.get $code) (i32.const 4)) ;; string
+    (then
+      (return (i32.eq (local.get $k) (i32.const 3)))
+    )
+  )
+  (if (i32.eq (local.get $code) (i32.const 5)) ;; boolean
+    (then
+      (return (i32.eq (local.get $k) (i32.const 2)))
+    )
+  )
+  (if (i32.eq (local.get $code) (i32.const 6)) ;; i64
+    (then
+      (return (i32.eqz (local.get $k)))
+    )
+  )
+  (if (i32.eq (local.get $code) (i32.const 7)) ;; f64
+    (then
+      (return (i32.le_u (local.get $k) (i32.const 1)))
+    )
+  )
+  (if (i32.ne (local.get $code) (i32.const 11)) ;; union
+    (then
+      (return (i32.const 0))
+    )
+  )
+  (local.set $union (call $prelude.obj_get (local.get $schema) (call $json._k_union)))
+  (if (i32.ne (call $prelude.val_kind (local.get $union)) (i32.const 5))
+    (then
+      (return (i32.const 0))
+    )
+  )
+  (local.set $len (call $prelude.arr_len (local.get $union)))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+      (if (call $csv._accepts (call $prelude.arr_get (local.get $union) (local.get $i)) (local.get $k))
+        (then
+          (return (i32.const 1))
+        )
+      )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (i32.const 0)
+)
+
+;; 列の文字列をプロパティの型に合わせた値にする。undefined はプロパティを置かない
+(func $csv._coerce (param $cell anyref) (param $schema anyref) (param $has_default i32) (result anyref)
+  (local $v anyref)
+  (local $k i32)
+
+  (if (i32.eqz (call $prelude._string_bytelen (local.get $cell)))
+    (then
+      (if (call $json._schema_allows_undefined (local.get $schema))
+        (then
+          (return (call $prelude.val_undefined))
+        )
+      )
+      (if (call $csv._accepts (local.get $schema) (i32.const 3))
+        (then
+          (return (local.get $cell))
+        )
+      )
+      (if (local.get $has_default)
+        (then
+          (return (call $prelude.val_undefined))
+        )
+      )
+      (if (call $csv._accepts (local.get $schema) (i32.const 6))
+        (then
+          (return (call $prelude.val_null))
+        )
+      )
+      (return (local.get $cell))
+    )
+  )
+  (if (i32.eq (call $csv._schema_code (local.get $schema)) (i32.const 4))
+    (then
+      (return (local.get $cell))
+    )
+  )
+  (local.set $v (call $json.toJSON (local.get $cell)))
+  (local.set $k (call $prelude.val_kind (local.get $v)))
+  (if (i32.and
+        (i32.or (i32.le_u (local.get $k) (i32.const 2)) (i32.eq (local.get $k) (i32.const 6)))
+        (call $csv._accepts (local.get $schema) (local.get $k)))
+    (then
+      (return (local.get $v))
+    )
+  )
+  (local.get $cell)
+)
+
+;; schema は T[] の decode schema
+(func $csv.decode_csv (param $text anyref) (param $schema anyref) (result anyref)
+  (local $rows anyref)
+  (local $nrows i32)
+  (local $parsed anyref)
+  (local $props anyref)
+  (local $nprops i32)
+  (local $header anyref)
+  (local $items anyref)
+  (local $r i32)
+  (local $row anyref)
+  (local $obj anyref)
+  (local $i i32)
+  (local $prop anyref)
+  (local $name anyref)
+  (local $col i32)
+  (local $v anyref)
+
+  (local.set $rows (call $csv._parse (local.get $text) (i32.const 44) (i32.const 1)))
+  (if (i32.ne (call $prelude.val_kind (local.get $rows)) (i32.const 5))
+    (then
+      (return (local.get $rows))
+    )
+  )
+  (local.set $nrows (call $prelude.arr_len (local.get $rows)))
+  (if (i32.eqz (local.get $nrows))
+    (then
+      (return (call $json.decode (call $prelude.arr_new (i32.const 0)) (local.get $schema)))
+    )
+  )
+
+  (local.set $props (call $prelude.arr_new (i32.const 0)))
+  (local.set $parsed (call $json.toJSON (local.get $schema)))
+  (if (call $json._obj_has_key (local.get $parsed) (call $json._k_elem))
+    (then
+      (local.set $parsed (call $prelude.obj_get (local.get $parsed) (call $json._k_elem)))
+      (if (call $json._obj_has_key (local.get $parsed) (call $json._k_props))
+        (then
+          (local.set $props (call $prelude.obj_get (local.get $parsed) (call $json._k_props)))
+        )
+      )
+    )
+  )
+  (local.set $nprops (call $prelude.arr_len (local.get $props)))
+  (local.set $header (call $prelude.arr_get (local.get $rows) (i32.const 0)))
+  (local.set $items (call $prelude.arr_new (i32.sub (local.get $nrows) (i32.const 1))))
+
+  (local.set $r (i32.const 1))
+  (block $rows_done
+    (loop $rows_loop
+      (br_if $rows_done (i32.ge_u (local.get $r) (local.get $nrows)))
+      (local.set $row (call $prelude.arr_get (local.get $rows) (local.get $r)))
+      (local.set $obj (call $prelude.obj_new (local.get $nprops)))
+      (local.set $i (i32.const 0))
+      (block $props_done
+        (loop $props_loop
+          (br_if $props_done (i32.ge_u (local.get $i) (local.get $nprops)))
+          (local.set $prop (call $prelude.arr_get (local.get $props) (local.get $i)))
+          (local.set $name (call $prelude.obj_get (local.get $prop) (call $json._k_name)))
+          (local.set $col
+            (call $json._arr_find_string
+              (local.get $header)
+              (call $prelude.arr_len (local.get $header))
+              (local.get $name)))
+          (if (i32.ge_s (local.get $col) (i32.const 0))
+            (then
+              (local.set $v
+                (call $csv._coerce
+                  (call $prelude.arr_get (local.get $row) (local.get $col))
+                  (call $prelude.obj_get (local.get $prop) (call $json._str_type))
+                  (call $json._obj_has_key (local.get $prop) (call $json._k_default))))
+              (if (i32.ne (call $prelude.val_kind (local.get $v)) (i32.const 7))
+                (then
+                  (call $prelude.obj_set (local.get $obj) (local.get $name) (local.get $v))
+                )
+              )
+            )
+          )
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $props_loop)
+        )
+      )
+      (call $prelude.arr_set (local.get $items) (i32.sub (local.get $r) (i32.const 1)) (local.get $obj))
+      (local.set $r (i32.add (local.get $r) (i32.const 1)))
+      (br $rows_loop)
+    )
+  )
+  (call $json.decode (local.get $items) (local.get $schema))
+)
+
+;; 区切り文字・"・改行を含む列は "..." で囲む
+(func $csv._write_cell (param $s anyref)
+  (local $ptr i32)
+  (local $len i32)
+  (local $i i32)
+  (local $c i32)
+  (local $quote i32)
+
+  (local.set $ptr (call $prelude._string_ptr (local.get $s)))
+  (local.set $len (call $prelude._string_bytelen (local.get $s)))
+  (block $scan_done
+    (loop $scan
+      (br_if $scan_done (i32.ge_u (local.get $i) (local.get $len)))
+      (local.set $c (i32.load8_u (i32.add (local.get $ptr) (local.get $i))))
+      (if (i32.or
+            (i32.or (i32.eq (local.get $c) (i32.const 44)) (i32.eq (local.get $c) (i32.const 34)))
+            (i32.or (i32.eq (local.get $c) (i32.const 10)) (i32.eq (local.get $c) (i32.const 13))))
+        (then
+          (local.set $quote (i32.const 1))
+          (br $scan_done)
+        )
+      )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $scan)
+    )
+  )
+  (if (i32.eqz (local.get $quote))
+    (then
+      (call $csv._buf_string (local.get $s))
+      (return)
+    )
+  )
+  (call $csv._buf_byte (i32.const 34))
+  (local.set $i (i32.const 0))
+  (block $done
+    (loop $copy
+      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+      (local.set $c (i32.load8_u (i32.add (local.get $ptr) (local.get $i))))
+      (if (i32.eq (local.get $c) (i32.const 34))
+        (then
+          (call $csv._buf_byte (i32.const 34))
+        )
+      )
+      (call $csv._buf_byte (local.get $c))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $copy)
+    )
+  )
+  (call $csv._buf_byte (i32.const 34))
+)
+
+(func $csv.to_csv (param $rows anyref) (result anyref)
+  (local $nrows i32)
+  (local $keys anyref)
+  (local $nkeys i32)
+  (local $r i32)
+  (local $row anyref)
+  (local $row_keys anyref)
+  (local $i i32)
+  (local $key anyref)
+  (local $v anyref)
+  (local $k i32)
+
+  (global.set $csv_buf_len (i32.const 0))
+  (local.set $nrows (call $prelude.arr_len (local.get $rows)))
+  (if (i32.eqz (local.get $nrows))
+    (then
+      (return (call $csv._buf_take))
+    )
+  )
+
+  ;; 見出しは全行のキーの初出順
+  (local.set $keys (call $prelude.arr_new (i32.const 8)))
+  (block $keys_done
+    (loop $keys_rows
+      (br_if $keys_done (i32.ge_u (local.get $r) (local.get $nrows)))
+      (local.set $row_keys
+        (call $prelude.obj_keys (call $prelude.arr_get (local.get $rows) (local.get $r))))
+      (local.set $i (i32.const 0))
+      (block $row_done
+        (loop $row_loop
+          (br_if $row_done (i32.ge_u (local.get $i) (call $prelude.arr_len (local.get $row_keys))))
+          (local.set $key (call $prelude.arr_get (local.get $row_keys) (local.get $i)))
+          (if (i32.lt_s
+                (call $json._arr_find_string (local.get $keys) (local.get $nkeys) (local.get $key))
+                (i32.const 0))
+            (then
+              (local.set $keys (call $csv._push (local.get $keys) (local.get $nkeys) (local.get $key)))
+              (local.set $nkeys (i32.add (local.get $nkeys) (i32.const 1)))
+            )
+          )
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $row_loop)
+        )
+      )
+      (local.set $r (i32.add (local.get $r) (i32.const 1)))
+      (br $keys_rows)
+    )
+  )
+
+  (local.set $i (i32.const 0))
+  (block $header_done
+    (loop $header
+      (br_if $header_done (i32.ge_u (local.get $i) (local.get $nkeys)))
+      (if (local.get $i)
+        (then
+          (call $csv._buf_byte (i32.const 44))
+        )
+      )
+      (call $csv._write_cell (call $prelude.arr_get (local.get $keys) (local.get $i)))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $header)
+    )
+  )
+  (call $csv._buf_byte (i32.const 10))
+
+  (local.set $r (i32.const 0))
+  (block $rows_done
+    (loop $rows_loop
+      (br_if $rows_done (i32.ge_u (local.get $r) (local.get $nrows)))
+      (local.set $row (call $prelude.arr_get (local.get $rows) (local.get $r)))
+      (local.set $i (i32.const 0))
+      (block $cells_done
+        (loop $cells
+          (br_if $cells_done (i32.ge_u (local.get $i) (local.get $nkeys)))
+          (if (local.get $i)
+            (then
+              (call $csv._buf_byte (i32.const 44))
+            )
+          )
+          (local.set $key (call $prelude.arr_get (local.get $keys) (local.get $i)))
+          (if (call $json._obj_has_key (local.get $row) (local.get $key))
+            (then
+              (local.set $v (call $prelude.obj_get (local.get $row) (local.get $key)))
+              (local.set $k (call $prelude.val_kind (local.get $v)))
+              (if (i32.eq (local.get $k) (i32.const 3))
+                (then
+                  (call $csv._write_cell (local.get $v))
+                )
+                (else
+                  (if (i32.and
+                        (i32.ne (local.get $k) (i32.const 6))
+                        (i32.ne (local.get $k) (i32.const 7)))
+                    (then
+                      ;; $json.stringify は $json の出力バッファを使うので csv のバッファとは混ざらない
+                      (call $csv._write_cell (call $json.stringify (local.get $v)))
+                    )
+                  )
+                )
+              )
+            )
+          )
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $cells)
+        )
+      )
+      (call $csv._buf_byte (i32.const 10))
+      (local.set $r (i32.add (local.get $r) (i32.const 1)))
+      (br $rows_loop)
+    )
+  )
+  (call $csv._buf_take)
+)
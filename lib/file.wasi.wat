@@ -0,0 +1,595 @@
+;; File module functions for --target=wasi.
+;; Paths are resolved against the directories preopened by the runtime (e.g. `wasmtime run --dir .`).
+
+(import "wasi_snapshot_preview1" "fd_prestat_get"
+  (func $file.wasi_fd_prestat_get (param i32 i32) (result i32)))
+(import "wasi_snapshot_preview1" "fd_prestat_dir_name"
+  (func $file.wasi_fd_prestat_dir_name (param i32 i32 i32) (result i32)))
+(import "wasi_snapshot_preview1" "path_open"
+  (func $file.wasi_path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))
+(import "wasi_snapshot_preview1" "path_filestat_get"
+  (func $file.wasi_path_filestat_get (param i32 i32 i32 i32 i32) (result i32)))
+(import "wasi_snapshot_preview1" "fd_read"
+  (func $file.wasi_fd_read (param i32 i32 i32 i32) (result i32)))
+(import "wasi_snapshot_preview1" "fd_readdir"
+  (func $file.wasi_fd_readdir (param i32 i32 i32 i64 i32) (result i32)))
+(import "wasi_snapshot_preview1" "fd_close"
+  (func $file.wasi_fd_close (param i32) (result i32)))
+
+;; _resolve が求めた preopen からの相対パス
+(global $file_rel_ptr (mut i32) (i32.const 0))
+(global $file_rel_len (mut i32) (i32.const 0))
+
+(data $file_d_text
+  "open "
+  "read "
+  "write "
+  "readdir "
+  ": "
+  "read_text expects UTF-8 text"
+  "errno "
+  "permission denied"
+  "bad file descriptor"
+  "file exists"
+  "invalid argument"
+  "input/output error"
+  "is a directory"
+  "no such file or directory"
+  "not a directory"
+  "directory not empty"
+  "operation not permitted"
+  "capabilities insufficient")
+
+(func $file._lit (param $offset i32) (param $len i32) (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (local.get $len)))
+  (memory.init $file_d_text (local.get $ptr) (local.get $offset) (local.get $len))
+  (call $prelude._new_string_owned (local.get $ptr) (local.get $len))
+)
+
+;; WASI の errno を Go の os パッケージと同じ表記にする。
+(func $file._reason (param $errno i32) (result anyref)
+  (if (i32.eq (local.get $errno) (i32.const 2))
+    (then (return (call $file._lit (i32.const 60) (i32.const 17))))
+  )
+  (if (i32.eq (local.get $errno) (i32.const 8))
+    (then (return (call $file._lit (i32.const 77) (i32.const 19))))
+  )
+  (if (i32.eq (local.get $errno) (i32.const 20))
+    (then (return (call $file._lit (i32.const 96) (i32.const 11))))
+  )
+  (if (i32.eq (local.get $errno) (i32.const 28))
+    (then (return (call $file._lit (i32.const 107) (i32.const 16))))
+  )
+  (if (i32.eq (local.get $errno) (i32.const 29))
+    (then (return (call $file._lit (i32.const 123) (i32.const 18))))
+  )
+  (if (i32.eq (local.get $errno) (i32.const 31))
+    (then (return (call $file._lit (i32.const 141) (i32.const 14))))
+  )
+  (if (i32.eq (local.get $errno) (i32.const 44))
+    (then (return (call $file._lit (i32.const 155) (i32.const 25))))
+  )
+  (if (i32.eq (local.get $errno) (i32.const 54))
+    (then (return (call $file._lit (i32.const 180) (i32.const 15))))
+  )
+  (if (i32.eq (local.get $errno) (i32.const 55))
+    (then (return (call $file._lit (i32.const 195) (i32.const 19))))
+  )
+  (if (i32.eq (local.get $errno) (i32.const 63))
+    (then (return (call $file._lit (i32.const 214) (i32.const 23))))
+  )
+  (if (i32.eq (local.get $errno) (i32.const 76))
+    (then (return (call $file._lit (i32.const 237) (i32.const 25))))
+  )
+  (call $prelude.str_concat
+    (call $file._lit (i32.const 54) (i32.const 6))
+    (call $prelude._i64_to_string (i64.extend_i32_u (local.get $errno))))
+)
+
+;; "<op> <path>: <reason>" の error を返す。
+(func $file._fail (param $op anyref) (param $path anyref) (param $errno i32) (result anyref)
+  (call $prelude.error
+    (call $prelude.str_concat
+      (call $prelude.str_concat
+        (call $prelude.str_concat (local.get $op) (local.get $path))
+        (call $file._lit (i32.const 24) (i32.const 2)))
+      (call $file._reason (local.get $errno))))
+)
+
+;; path を preopen されたディレクトリの fd と相対パス（$file_rel_ptr / $file_rel_len）に分ける。
+;; 相対パスは "." の preopen、絶対パスは名前が最も長く一致する preopen を使う。
+;; 該当するものが無ければ -1 を返す。
+(func $file._resolve (param $path anyref) (result i32)
+  (local $ptr i32)
+  (local $len i32)
+  (local $stat i32)
+  (local $fd i32)
+  (local $name i32)
+  (local $name_len i32)
+  (local $matched i32)
+  (local $best_fd i32)
+  (local $best_len i32)
+  (local.set $ptr (call $prelude._string_ptr (local.get $path)))
+  (local.set $len (call $prelude._string_bytelen (local.get $path)))
+  (local.set $stat (call $prelude._alloc (i32.const 8)))
+  (local.set $best_fd (i32.const -1))
+  (local.set $best_len (i32.const -1))
+  (local.set $fd (i32.const 3))
+  (block $done
+    (loop $loop
+      (br_if $done (call $file.wasi_fd_prestat_get (local.get $fd) (local.get $stat)))
+      ;; tag 0 がディレクトリ
+      (if (i32.eqz (i32.load8_u (local.get $stat)))
+        (then
+          (local.set $name_len (i32.load (i32.add (local.get $stat) (i32.const 4))))
+          (local.set $name (call $prelude._alloc (local.get $name_len)))
+          (if (i32.eqz
+                (call $file.wasi_fd_prestat_dir_name
+                  (local.get $fd) (local.get $name) (local.get $name_len)))
+            (then
+              (local.set $matched
+                (call $file._prefix_len
+                  (local.get $name) (local.get $name_len)
+                  (local.get $ptr) (local.get $len)))
+              (if (i32.gt_s (local.get $matched) (local.get $best_len))
+                (then
+                  (local.set $best_fd (local.get $fd))
+                  (local.set $best_len (local.get $matched))
+                )
+              )
+            )
+          )
+        )
+      )
+      (local.set $fd (i32.add (local.get $fd) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (if (i32.lt_s (local.get $best_fd) (i32.const 0))
+    (then (return (i32.const -1)))
+  )
+  (local.set $ptr (i32.add (local.get $ptr) (local.get $best_len)))
+  (local.set $len (i32.sub (local.get $len) (local.get $best_len)))
+  (block $trimmed
+    (loop $trim
+      (br_if $trimmed (i32.eqz (local.get $len)))
+      (br_if $trimmed (i32.ne (i32.load8_u (local.get $ptr)) (i32.const 47)))
+      (local.set $ptr (i32.add (local.get $ptr) (i32.const 1)))
+      (local.set $len (i32.sub (local.get $len) (i32.const 1)))
+      (br $trim)
+    )
+  )
+  (if (i32.eqz (local.get $len))
+    (then
+      (local.set $ptr (call $prelude._alloc (i32.const 1)))
+      (i32.store8 (local.get $ptr) (i32.const 46))
+      (local.set $len (i32.const 1))
+    )
+  )
+  (global.set $file_rel_ptr (local.get $ptr))
+  (global.set $file_rel_len (local.get $len))
+  (local.get $best_fd)
+)
+
+;; preopen の名前 name が path の先頭に一致すれば一致したバイト数、しなければ -1。
+(func $file._prefix_len (param $name i32) (param $name_len i32) (param $path i32) (param $len i32) (result i32)
+  (local $i i32)
+  (local $relative i32)
+  ;; 末尾の / を落とす（"/" 自体は残す）
+  (block $trimmed
+    (loop $trim
+      (br_if $trimmed (i32.le_u (local.get $name_len) (i32.const 1)))
+      (br_if $trimmed
+        (i32.ne
+          (i32.load8_u (i32.add (local.get $name) (i32.sub (local.get $name_len) (i32.const 1))))
+          (i32.const 47)))
+      (local.set $name_len (i32.sub (local.get $name_len) (i32.const 1)))
+      (br $trim)
+    )
+  )
+  (local.set $relative
+    (i32.or
+      (i32.eqz (local.get $len))
+      (i32.ne (i32.load8_u (local.get $path)) (i32.const 47))))
+  ;; "." は相対パスすべてに一致する
+  (if (i32.and
+        (i32.eq (local.get $name_len) (i32.const 1))
+        (i32.eq (i32.load8_u (local.get $name)) (i32.const 46)))
+    (then
+      (if (local.get $relative)
+        (then (return (i32.const 0)))
+      )
+      (return (i32.const -1))
+    )
+  )
+  (if (i32.gt_u (local.get $name_len) (local.get $len))
+    (then (return (i32.const -1)))
+  )
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $name_len)))
+      (if (i32.ne
+            (i32.load8_u (i32.add (local.get $name) (local.get $i)))
+            (i32.load8_u (i32.add (local.get $path) (local.get $i))))
+        (then (return (i32.const -1)))
+      )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  ;; "/data" は "/data/x" に一致するが "/database" には一致しない
+  (if (i32.and
+        (i32.lt_u (local.get $name_len) (local.get $len))
+        (i32.ne (i32.load8_u (i32.add (local.get $path) (local.get $name_len))) (i32.const 47)))
+    (then
+      (if (i32.ne
+            (i32.load8_u (i32.add (local.get $name) (i32.sub (local.get $name_len) (i32.const 1))))
+            (i32.const 47))
+        (then (return (i32.const -1)))
+      )
+    )
+  )
+  (local.get $name_len)
+)
+
+;; path を開いて fd を返す。失敗したら errno を負にして返す。
+(func $file._open (param $path anyref) (param $oflags i32) (param $rights i64) (param $fdflags i32) (result i32)
+  (local $dir i32)
+  (local $out i32)
+  (local $errno i32)
+  (local.set $dir (call $file._resolve (local.get $path)))
+  (if (i32.lt_s (local.get $dir) (i32.const 0))
+    ;; ENOTCAPABLE
+    (then (return (i32.const -76)))
+  )
+  (local.set $out (call $prelude._alloc (i32.const 4)))
+  (local.set $errno
+    (call $file.wasi_path_open
+      (local.get $dir)
+      (i32.const 1)
+      (global.get $file_rel_ptr)
+      (global.get $file_rel_len)
+      (local.get $oflags)
+      (local.get $rights)
+      (i64.const 0)
+      (local.get $fdflags)
+      (local.get $out)))
+  (if (local.get $errno)
+    (then (return (i32.sub (i32.const 0) (local.get $errno))))
+  )
+  (i32.load (local.get $out))
+)
+
+(func $file.read_text (param $path anyref) (result anyref)
+  (local $fd i32)
+  (local $buf i32)
+  (local $cap i32)
+  (local $len i32)
+  (local $next i32)
+  (local $io i32)
+  (local $errno i32)
+  (local $n i32)
+  ;; rights: FD_READ | FD_SEEK
+  (local.set $fd (call $file._open (local.get $path) (i32.const 0) (i64.const 6) (i32.const 0)))
+  (if (i32.lt_s (local.get $fd) (i32.const 0))
+    (then
+      (return
+        (call $file._fail
+          (call $file._lit (i32.const 0) (i32.const 5))
+          (local.get $path)
+          (i32.sub (i32.const 0) (local.get $fd)))))
+  )
+  (local.set $io (call $prelude._alloc (i32.const 12)))
+  (local.set $cap (i32.const 4096))
+  (local.set $buf (call $prelude._alloc (local.get $cap)))
+  (block $done
+    (loop $loop
+      (if (i32.eq (local.get $len) (local.get $cap))
+        (then
+          (local.set $next (call $prelude._alloc (i32.shl (local.get $cap) (i32.const 1))))
+          (memory.copy (local.get $next) (local.get $buf) (local.get $len))
+          (local.set $buf (local.get $next))
+          (local.set $cap (i32.shl (local.get $cap) (i32.const 1)))
+        )
+      )
+      (i32.store (local.get $io) (i32.add (local.get $buf) (local.get $len)))
+      (i32.store (i32.add (local.get $io) (i32.const 4)) (i32.sub (local.get $cap) (local.get $len)))
+      (local.set $errno
+        (call $file.wasi_fd_read
+          (local.get $fd)
+          (local.get $io)
+          (i32.const 1)
+          (i32.add (local.get $io) (i32.const 8))))
+      (if (local.get $errno)
+        (then
+          (drop (call $file.wasi_fd_close (local.get $fd)))
+          (return
+            (call $file._fail (call $file._lit (i32.const 5) (i32.const 5)) (local.get $path) (local.get $errno))))
+      )
+      (local.set $n (i32.load (i32.add (local.get $io) (i32.const 8))))
+      (br_if $done (i32.eqz (local.get $n)))
+      (local.set $len (i32.add (local.get $len) (local.get $n)))
+      (br $loop)
+    )
+  )
+  (drop (call $file.wasi_fd_close (local.get $fd)))
+  (if (i32.eqz (call $prelude._valid_utf8 (local.get $buf) (local.get $len)))
+    (then (return (call $prelude.error (call $file._lit (i32.const 26) (i32.const 28)))))
+  )
+  ;; UTF-8 の BOM
+  (if (i32.ge_u (local.get $len) (i32.const 3))
+    (then
+      (if (i32.and
+            (i32.eq (i32.load16_u (local.get $buf)) (i32.const 0xBBEF))
+            (i32.eq (i32.load8_u (i32.add (local.get $buf) (i32.const 2))) (i32.const 0xBF)))
+        (then
+          (local.set $buf (i32.add (local.get $buf) (i32.const 3)))
+          (local.set $len (i32.sub (local.get $len) (i32.const 3)))
+        )
+      )
+    )
+  )
+  (call $prelude._new_string_owned (local.get $buf) (local.get $len))
+)
+
+(func $file._write (param $path anyref) (param $content anyref) (param $oflags i32) (param $fdflags i32) (result anyref)
+  (local $fd i32)
+  (local $ptr i32)
+  (local $len i32)
+  (local $io i32)
+  (local $errno i32)
+  (local $n i32)
+  ;; rights: FD_WRITE
+  (local.set $fd
+    (call $file._open (local.get $path) (local.get $oflags) (i64.const 64) (local.get $fdflags)))
+  (if (i32.lt_s (local.get $fd) (i32.const 0))
+    (then
+      (return
+        (call $file._fail
+          (call $file._lit (i32.const 0) (i32.const 5))
+          (local.get $path)
+          (i32.sub (i32.const 0) (local.get $fd)))))
+  )
+  (local.set $ptr (call $prelude._string_ptr (local.get $content)))
+  (local.set $len (call $prelude._string_bytelen (local.get $content)))
+  (local.set $io (call $prelude._alloc (i32.const 12)))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.eqz (local.get $len)))
+      (i32.store (local.get $io) (local.get $ptr))
+      (i32.store (i32.add (local.get $io) (i32.const 4)) (local.get $len))
+      (local.set $errno
+        (call $wasi.fd_write
+          (local.get $fd)
+          (local.get $io)
+          (i32.const 1)
+          (i32.add (local.get $io) (i32.const 8))))
+      (local.set $n (i32.load (i32.add (local.get $io) (i32.const 8))))
+      (if (i32.and (i32.eqz (local.get $errno)) (i32.eqz (local.get $n)))
+        ;; EIO
+        (then (local.set $errno (i32.const 29)))
+      )
+      (if (local.get $errno)
+        (then
+          (drop (call $file.wasi_fd_close (local.get $fd)))
+          (return
+            (call $file._fail (call $file._lit (i32.const 10) (i32.const 6)) (local.get $path) (local.get $errno))))
+      )
+      (local.set $ptr (i32.add (local.get $ptr) (local.get $n)))
+      (local.set $len (i32.sub (local.get $len) (local.get $n)))
+      (br $loop)
+    )
+  )
+  (drop (call $file.wasi_fd_close (local.get $fd)))
+  (call $prelude.val_undefined)
+)
+
+(func $file.write_text (param $path anyref) (param $content anyref) (result anyref)
+  ;; oflags: CREAT | TRUNC
+  (call $file._write (local.get $path) (local.get $content) (i32.const 9) (i32.const 0))
+)
+
+(func $file.append_text (param $path anyref) (param $content anyref) (result anyref)
+  ;; oflags: CREAT、fdflags: APPEND
+  (call $file._write (local.get $path) (local.get $content) (i32.const 1) (i32.const 1))
+)
+
+;; バイト列として a < b なら負、a > b なら正、等しければ 0。
+(func $file._str_cmp (param $a anyref) (param $b anyref) (result i32)
+  (local $ap i32)
+  (local $al i32)
+  (local $bp i32)
+  (local $bl i32)
+  (local $i i32)
+  (local $n i32)
+  (local $d i32)
+  (local.set $ap (call $prelude._string_ptr (local.get $a)))
+  (local.set $al (call $prelude._string_bytelen (local.get $a)))
+  (local.set $bp (call $prelude._string_ptr (local.get $b)))
+  (local.set $bl (call $prelude._string_bytelen (local.get $b)))
+  (local.set $n (select (local.get $al) (local.get $bl) (i32.lt_u (local.get $al) (local.get $bl))))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $n)))
+      (local.set $d
+        (i32.sub
+          (i32.load8_u (i32.add (local.get $ap) (local.get $i)))
+          (i32.load8_u (i32.add (local.get $bp) (local.get $i)))))
+      (if (local.get $d)
+        (then (return (local.get $d)))
+      )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (i32.sub (local.get $al) (local.get $bl))
+)
+
+(func $file.read_dir (param $path anyref) (result anyref)
+  (local $fd i32)
+  (local $buf i32)
+  (local $cap i32)
+  (local $used_ptr i32)
+  (local $used i32)
+  (local $cookie i64)
+  (local $off i32)
+  (local $namelen i32)
+  (local $name i32)
+  (local $progressed i32)
+  (local $errno i32)
+  (local $names anyref)
+  (local $count i32)
+  (local $next anyref)
+  (local $i i32)
+  (local $j i32)
+  (local $key anyref)
+  ;; oflags: DIRECTORY、rights: FD_READDIR
+  (local.set $fd (call $file._open (local.get $path) (i32.const 2) (i64.const 16384) (i32.const 0)))
+  (if (i32.lt_s (local.get $fd) (i32.const 0))
+    (then
+      (return
+        (call $file._fail
+          (call $file._lit (i32.const 0) (i32.const 5))
+          (local.get $path)
+          (i32.sub (i32.const 0) (local.get $fd)))))
+  )
+  (local.set $used_ptr (call $prelude._alloc (i32.const 4)))
+  (local.set $cap (i32.const 4096))
+  (local.set $buf (call $prelude._alloc (local.get $cap)))
+  (local.set $names (call $prelude.arr_new (i32.const 16)))
+  (block $done
+    (loop $fill
+      (local.set $errno
+        (call $file.wasi_fd_readdir
+          (local.get $fd)
+          (local.get $buf)
+          (local.get $cap)
+          (local.get $cookie)
+          (local.get $used_ptr)))
+      (if (local.get $errno)
+        (then
+          (drop (call $file.wasi_fd_close (local.get $fd)))
+          (return
+            (call $file._fail (call $file._lit (i32.const 16) (i32.const 8)) (local.get $path) (local.get $errno))))
+      )
+      (local.set $used (i32.load (local.get $used_ptr)))
+      (local.set $off (i32.const 0))
+      (local.set $progressed (i32.const 0))
+      ;; dirent: d_next u64, d_ino u64, d_namlen u32, d_type u8（24 バイト）の後に名前
+      (block $entries_done
+        (loop $entries
+          (br_if $entries_done
+            (i32.gt_u (i32.add (local.get $off) (i32.const 24)) (local.get $used)))
+          (local.set $namelen (i32.load (i32.add (local.get $buf) (i32.add (local.get $off) (i32.const 16)))))
+          (br_if $entries_done
+            (i32.gt_u
+              (i32.add (local.get $off) (i32.add (i32.const 24) (local.get $namelen)))
+              (local.get $used)))
+          (local.set $name (i32.add (local.get $buf) (i32.add (local.get $off) (i32.const 24))))
+          (if (i32.eqz (call $file._is_dot (local.get $name) (local.get $namelen)))
+            (then
+              (if (i32.eq (local.get $count) (call $prelude.arr_len (local.get $names)))
+                (then
+                  (local.set $next (call $prelude.arr_new (i32.shl (local.get $count) (i32.const 1))))
+                  (local.set $i (i32.const 0))
+                  (block $copied
+                    (loop $copy
+                      (br_if $copied (i32.ge_u (local.get $i) (local.get $count)))
+                      (call $prelude.arr_set
+                        (local.get $next)
+                        (local.get $i)
+                        (call $prelude.arr_get (local.get $names) (local.get $i)))
+                      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+                      (br $copy)
+                    )
+                  )
+                  (local.set $names (local.get $next))
+                )
+              )
+              (call $prelude.arr_set
+                (local.get $names)
+                (local.get $count)
+                (call $prelude.str_from_utf8 (local.get $name) (local.get $namelen)))
+              (local.set $count (i32.add (local.get $count) (i32.const 1)))
+            )
+          )
+          (local.set $cookie (i64.load (i32.add (local.get $buf) (local.get $off))))
+          (local.set $off (i32.add (local.get $off) (i32.add (i32.const 24) (local.get $namelen))))
+          (local.set $progressed (i32.const 1))
+          (br $entries)
+        )
+      )
+      ;; バッファが埋まらなければ最後まで読んだ
+      (br_if $done (i32.lt_u (local.get $used) (local.get $cap)))
+      (if (i32.eqz (local.get $progressed))
+        (then
+          (local.set $cap (i32.shl (local.get $cap) (i32.const 1)))
+          (local.set $buf (call $prelude._alloc (local.get $cap)))
+        )
+      )
+      (br $fill)
+    )
+  )
+  (drop (call $file.wasi_fd_close (local.get $fd)))
+  ;; 名前順（バイト順）に挿入ソートして詰める
+  (local.set $next (call $prelude.arr_new (local.get $count)))
+  (local.set $i (i32.const 0))
+  (block $sorted
+    (loop $sort
+      (br_if $sorted (i32.ge_u (local.get $i) (local.get $count)))
+      (local.set $key (call $prelude.arr_get (local.get $names) (local.get $i)))
+      (local.set $j (local.get $i))
+      (block $placed
+        (loop $shift
+          (br_if $placed (i32.eqz (local.get $j)))
+          (br_if $placed
+            (i32.le_s
+              (call $file._str_cmp
+                (call $prelude.arr_get (local.get $next) (i32.sub (local.get $j) (i32.const 1)))
+                (local.get $key))
+              (i32.const 0)))
+          (call $prelude.arr_set
+            (local.get $next)
+            (local.get $j)
+            (call $prelude.arr_get (local.get $next) (i32.sub (local.get $j) (i32.const 1))))
+          (local.set $j (i32.sub (local.get $j) (i32.const 1)))
+          (br $shift)
+        )
+      )
+      (call $prelude.arr_set (local.get $next) (local.get $j) (local.get $key))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $sort)
+    )
+  )
+  (local.get $next)
+)
+
+;; "." と ".." なら 1
+(func $file._is_dot (param $name i32) (param $len i32) (result i32)
+  (if (i32.or (i32.eqz (local.get $len)) (i32.gt_u (local.get $len) (i32.const 2)))
+    (then (return (i32.const 0)))
+  )
+  (if (i32.ne (i32.load8_u (local.get $name)) (i32.const 46))
+    (then (return (i32.const 0)))
+  )
+  (if (i32.eq (local.get $len) (i32.const 1))
+    (then (return (i32.const 1)))
+  )
+  (i32.eq (i32.load8_u (i32.add (local.get $name) (i32.const 1))) (i32.const 46))
+)
+
+(func $file.exists (param $path anyref) (result i32)
+  (local $dir i32)
+  (local.set $dir (call $file._resolve (local.get $path)))
+  (if (i32.lt_s (local.get $dir) (i32.const 0))
+    (then (return (i32.const 0)))
+  )
+  (i32.eqz
+    (call $file.wasi_path_filestat_get
+      (local.get $dir)
+      (i32.const 1)
+      (global.get $file_rel_ptr)
+      (global.get $file_rel_len)
+      (call $prelude._alloc (i32.const 64))))
+)
@@ -4,6 +4,8 @@
 (import "host" "http_create_server" (func $host.http_create_server (result externref)))
 (import "host" "http_add_route" (func $host.http_add_route (param externref externref i32 i32 externref)))
 (import "host" "http_listen" (func $host.http_listen (param externref externref)))
+(import "host" "http_listen_with" (func $host.http_listen_with (param externref externref)))
+(import "host" "http_serve_static" (func $host.http_serve_static (param externref externref externref)))
 (import "host" "http_response_text" (func $host.http_response_text (param i32 i32) (result externref)))
 (import "host" "http_response_text_str" (func $host.http_response_text_str (param externref) (result externref)))
 (import "host" "http_response_html" (func $host.http_response_html (param i32 i32) (result externref)))
@@ -11,6 +13,11 @@
 (import "host" "http_response_json" (func $host.http_response_json (param externref) (result externref)))
 (import "host" "http_response_redirect" (func $host.http_response_redirect (param i32 i32) (result externref)))
 (import "host" "http_response_redirect_str" (func $host.http_response_redirect_str (param externref) (result externref)))
+(import "host" "http_response_stream" (func $host.http_response_stream (param externref i32) (result externref)))
+(import "host" "http_stream_write" (func $host.http_stream_write (param externref externref) (result externref)))
+(import "host" "http_stream_flush" (func $host.http_stream_flush (param externref) (result externref)))
+(import "host" "http_add_websocket" (func $host.http_add_websocket (param externref externref externref)))
+(import "host" "http_websocket_send" (func $host.http_websocket_send (param externref externref) (result externref)))
 (import "host" "http_get_path" (func $host.http_get_path (param externref) (result externref)))
 (import "host" "http_get_method" (func $host.http_get_method (param externref) (result externref)))
 
@@ -43,6 +50,19 @@
     (call $interop.to_host (local.get $port)))
 )
 
+(func $http.http_listen_with (param $server anyref) (param $options anyref)
+  (call $host.http_listen_with
+    (call $interop.to_host (local.get $server))
+    (call $interop.to_host (local.get $options)))
+)
+
+(func $http.http_serve_static (param $server anyref) (param $prefix anyref) (param $source anyref)
+  (call $host.http_serve_static
+    (call $interop.to_host (local.get $server))
+    (call $interop.to_host (local.get $prefix))
+    (call $interop.to_host (local.get $source)))
+)
+
 (func $http.http_response_text (param $text_ptr i32) (param $text_len i32) (result anyref)
   (call $interop.to_gc
     (call $host.http_response_text (local.get $text_ptr) (local.get $text_len)))
@@ -94,6 +114,88 @@
       (call $interop.to_host (local.get $req))))
 )
 
+;; SSE frame: "event: <event>\n" (event が空なら省略) + 改行ごとの "data: <line>\n" + "\n"。
+;; data 内の '\r' は取り除く。
+(data $d_sse_event "event: ")
+(data $d_sse_data "data: ")
+
+(func $http._sse_frame (param $event anyref) (param $data anyref) (result anyref)
+  (local $ev_ptr i32)
+  (local $ev_len i32)
+  (local $d_ptr i32)
+  (local $d_len i32)
+  (local $lines i32)
+  (local $i i32)
+  (local $b i32)
+  (local $out i32)
+  (local $pos i32)
+
+  (local.set $ev_ptr (call $prelude._string_ptr (local.get $event)))
+  (local.set $ev_len (call $prelude._string_bytelen (local.get $event)))
+  (local.set $d_ptr (call $prelude._string_ptr (local.get $data)))
+  (local.set $d_len (call $prelude._string_bytelen (local.get $data)))
+
+  (local.set $lines (i32.const 1))
+  (local.set $i (i32.const 0))
+  (block $count_done
+    (loop $count
+      (br_if $count_done (i32.ge_u (local.get $i) (local.get $d_len)))
+      (if (i32.eq (i32.load8_u (i32.add (local.get $d_ptr) (local.get $i))) (i32.const 10))
+        (then (local.set $lines (i32.add (local.get $lines) (i32.const 1)))))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $count)
+    )
+  )
+
+  ;; 上限サイズで確保し、実際の長さは書き込み位置から求める。
+  (local.set $out
+    (call $prelude._alloc
+      (i32.add
+        (i32.add (local.get $ev_len) (i32.const 9))
+        (i32.add (local.get $d_len) (i32.mul (local.get $lines) (i32.const 7))))))
+  (local.set $pos (local.get $out))
+
+  (if (i32.gt_u (local.get $ev_len) (i32.const 0))
+    (then
+      (memory.init $d_sse_event (local.get $pos) (i32.const 0) (i32.const 7))
+      (local.set $pos (i32.add (local.get $pos) (i32.const 7)))
+      (memory.copy (local.get $pos) (local.get $ev_ptr) (local.get $ev_len))
+      (local.set $pos (i32.add (local.get $pos) (local.get $ev_len)))
+      (i32.store8 (local.get $pos) (i32.const 10))
+      (local.set $pos (i32.add (local.get $pos) (i32.const 1)))
+    )
+  )
+
+  (memory.init $d_sse_data (local.get $pos) (i32.const 0) (i32.const 6))
+  (local.set $pos (i32.add (local.get $pos) (i32.const 6)))
+  (local.set $i (i32.const 0))
+  (block $copy_done
+    (loop $copy
+      (br_if $copy_done (i32.ge_u (local.get $i) (local.get $d_len)))
+      (local.set $b (i32.load8_u (i32.add (local.get $d_ptr) (local.get $i))))
+      (if (i32.eq (local.get $b) (i32.const 10))
+        (then
+          (i32.store8 (local.get $pos) (i32.const 10))
+          (local.set $pos (i32.add (local.get $pos) (i32.const 1)))
+          (memory.init $d_sse_data (local.get $pos) (i32.const 0) (i32.const 6))
+          (local.set $pos (i32.add (local.get $pos) (i32.const 6))))
+        (else
+          (if (i32.ne (local.get $b) (i32.const 13))
+            (then
+              (i32.store8 (local.get $pos) (local.get $b))
+              (local.set $pos (i32.add (local.get $pos) (i32.const 1)))))))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $copy)
+    )
+  )
+  (i32.store16 (local.get $pos) (i32.const 0x0a0a))
+  (local.set $pos (i32.add (local.get $pos) (i32.const 2)))
+
+  (call $prelude._new_string_owned
+    (local.get $out)
+    (i32.sub (local.get $pos) (local.get $out)))
+)
+
 ;; Public extern wrappers so declarations in lib/http.tuna remain available.
 
 (func $http.create_server (result anyref)
@@ -113,6 +215,14 @@
   (call $http.http_listen (local.get $server) (local.get $port))
 )
 
+(func $http.listen_with (param $server anyref) (param $options anyref)
+  (call $http.http_listen_with (local.get $server) (local.get $options))
+)
+
+(func $http.serve_static (param $server anyref) (param $prefix anyref) (param $source anyref)
+  (call $http.http_serve_static (local.get $server) (local.get $prefix) (local.get $source))
+)
+
 (func $http.response_text (param $text anyref) (result anyref)
   (call $http.http_response_text_str (local.get $text))
 )
@@ -136,3 +246,53 @@
 (func $http.get_method (param $req anyref) (result anyref)
   (call $http.http_get_method (local.get $req))
 )
+
+(func $http.response_stream (param $fn anyref) (result anyref)
+  (call $interop.to_gc
+    (call $host.http_response_stream
+      (call $interop.to_host (local.get $fn))
+      (i32.const 0)))
+)
+
+(func $http.sse (param $req anyref) (param $fn anyref) (result anyref)
+  (call $interop.to_gc
+    (call $host.http_response_stream
+      (call $interop.to_host (local.get $fn))
+      (i32.const 1)))
+)
+
+(func $http.write (param $w anyref) (param $chunk anyref) (result anyref)
+  (call $interop.to_gc
+    (call $host.http_stream_write
+      (call $interop.to_host (local.get $w))
+      (call $interop.to_host (local.get $chunk))))
+)
+
+(func $http.flush (param $w anyref) (result anyref)
+  (call $interop.to_gc
+    (call $host.http_stream_flush
+      (call $interop.to_host (local.get $w))))
+)
+
+(func $http.send_event (param $w anyref) (param $event anyref) (param $data anyref) (result anyref)
+  ;; 切断後は write / flush とも同じエラーを返すため、flush の結果だけを返す。
+  (drop
+    (call $http.write
+      (local.get $w)
+      (call $http._sse_frame (local.get $event) (local.get $data))))
+  (call $http.flush (local.get $w))
+)
+
+(func $http.add_websocket (param $server anyref) (param $path anyref) (param $handlers anyref)
+  (call $host.http_add_websocket
+    (call $interop.to_host (local.get $server))
+    (call $interop.to_host (local.get $path))
+    (call $interop.to_host (local.get $handlers)))
+)
+
+(func $http.send (param $conn anyref) (param $text anyref) (result anyref)
+  (call $interop.to_gc
+    (call $host.http_websocket_send
+      (call $interop.to_host (local.get $conn))
+      (call $interop.to_host (local.get $text))))
+)
@@ -25,6 +25,7 @@
 (data $d_ct_html "text/html; charset=utf-8")
 (data $d_ct_json "application/json")
 (data $d_ct_redirect "redirect")
+(data $d_ct_event_stream "text/event-stream")
 
 (func $http._init
   (if (i32.eqz (global.get $http_inited))
@@ -146,6 +147,13 @@
   (call $prelude._new_string_owned (local.get $ptr) (i32.const 8))
 )
 
+(func $http._str_ct_event_stream (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 17)))
+  (memory.init $d_ct_event_stream (local.get $ptr) (i32.const 0) (i32.const 17))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 17))
+)
+
 (func $http._new_response (param $body anyref) (param $content_type anyref) (result anyref)
   (local $res anyref)
   (local.set $res (call $prelude.obj_new (i32.const 2)))
@@ -306,6 +314,15 @@
   (call $http._write_fd3 (local.get $body))
 )
 
+(func $http.http_listen_with (param $server anyref) (param $options anyref)
+  ;; GC backend ignores server options and behaves like listen.
+  (call $http.http_listen (local.get $server) (call $http._str_empty))
+)
+
+(func $http.http_serve_static (param $server anyref) (param $prefix anyref) (param $source anyref)
+  ;; GC backend has no socket server, so static files are never served.
+)
+
 (func $http.http_response_text (param $text_ptr i32) (param $text_len i32) (result anyref)
   (call $http._init)
   (call $http._new_response
@@ -362,6 +379,103 @@
   (call $prelude.obj_get (local.get $req) (call $http._str_key_method))
 )
 
+;; SSE frame: "event: <event>\n" (event が空なら省略) + 改行ごとの "data: <line>\n" + "\n"。
+;; data 内の '\r' は取り除く。
+(data $d_sse_event "event: ")
+(data $d_sse_data "data: ")
+
+(func $http._sse_frame (param $event anyref) (param $data anyref) (result anyref)
+  (local $ev_ptr i32)
+  (local $ev_len i32)
+  (local $d_ptr i32)
+  (local $d_len i32)
+  (local $lines i32)
+  (local $i i32)
+  (local $b i32)
+  (local $out i32)
+  (local $pos i32)
+
+  (local.set $ev_ptr (call $prelude._string_ptr (local.get $event)))
+  (local.set $ev_len (call $prelude._string_bytelen (local.get $event)))
+  (local.set $d_ptr (call $prelude._string_ptr (local.get $data)))
+  (local.set $d_len (call $prelude._string_bytelen (local.get $data)))
+
+  (local.set $lines (i32.const 1))
+  (local.set $i (i32.const 0))
+  (block $count_done
+    (loop $count
+      (br_if $count_done (i32.ge_u (local.get $i) (local.get $d_len)))
+      (if (i32.eq (i32.load8_u (i32.add (local.get $d_ptr) (local.get $i))) (i32.const 10))
+        (then (local.set $lines (i32.add (local.get $lines) (i32.const 1)))))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $count)
+    )
+  )
+
+  ;; 上限サイズで確保し、実際の長さは書き込み位置から求める。
+  (local.set $out
+    (call $prelude._alloc
+      (i32.add
+        (i32.add (local.get $ev_len) (i32.const 9))
+        (i32.add (local.get $d_len) (i32.mul (local.get $lines) (i32.const 7))))))
+  (local.set $pos (local.get $out))
+
+  (if (i32.gt_u (local.get $ev_len) (i32.const 0))
+    (then
+      (memory.init $d_sse_event (local.get $pos) (i32.const 0) (i32.const 7))
+      (local.set $pos (i32.add (local.get $pos) (i32.const 7)))
+      (memory.copy (local.get $pos) (local.get $ev_ptr) (local.get $ev_len))
+      (local.set $pos (i32.add (local.get $pos) (local.get $ev_len)))
+      (i32.store8 (local.get $pos) (i32.const 10))
+      (local.set $pos (i32.add (local.get $pos) (i32.const 1)))
+    )
+  )
+
+  (memory.init $d_sse_data (local.get $pos) (i32.const 0) (i32.const 6))
+  (local.set $pos (i32.add (local.get $pos) (i32.const 6)))
+  (local.set $i (i32.const 0))
+  (block $copy_done
+    (loop $copy
+      (br_if $copy_done (i32.ge_u (local.get $i) (local.get $d_len)))
+      (local.set $b (i32.load8_u (i32.add (local.get $d_ptr) (local.get $i))))
+      (if (i32.eq (local.get $b) (i32.const 10))
+        (then
+          (i32.store8 (local.get $pos) (i32.const 10))
+          (local.set $pos (i32.add (local.get $pos) (i32.const 1)))
+          (memory.init $d_sse_data (local.get $pos) (i32.const 0) (i32.const 6))
+          (local.set $pos (i32.add (local.get $pos) (i32.const 6))))
+        (else
+          (if (i32.ne (local.get $b) (i32.const 13))
+            (then
+              (i32.store8 (local.get $pos) (local.get $b))
+              (local.set $pos (i32.add (local.get $pos) (i32.const 1)))))))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $copy)
+    )
+  )
+  (i32.store16 (local.get $pos) (i32.const 0x0a0a))
+  (local.set $pos (i32.add (local.get $pos) (i32.const 2)))
+
+  (call $prelude._new_string_owned
+    (local.get $out)
+    (i32.sub (local.get $pos) (local.get $out)))
+)
+
+;; GC backend にはソケットが無いため、ストリーム関数をその場で実行して
+;; Writer（{ body } オブジェクト）に溜めた内容を通常のレスポンスとして返す。
+(func $http._run_stream (param $fn anyref) (param $content_type anyref) (result anyref)
+  (local $w anyref)
+  (local $args anyref)
+  (local.set $w (call $prelude.obj_new (i32.const 1)))
+  (call $prelude.obj_set (local.get $w) (call $http._str_key_body) (call $http._str_empty))
+  (local.set $args (call $prelude.arr_new (i32.const 1)))
+  (call $prelude.arr_set (local.get $args) (i32.const 0) (local.get $w))
+  (drop (call $prelude.call_fn (local.get $fn) (local.get $args)))
+  (call $http._new_response
+    (call $prelude.obj_get (local.get $w) (call $http._str_key_body))
+    (local.get $content_type))
+)
+
 ;; Public extern wrappers so declarations in lib/http.tuna remain available.
 
 (func $http.create_server (result anyref)
@@ -381,6 +495,14 @@
   (call $http.http_listen (local.get $server) (local.get $port))
 )
 
+(func $http.listen_with (param $server anyref) (param $options anyref)
+  (call $http.http_listen_with (local.get $server) (local.get $options))
+)
+
+(func $http.serve_static (param $server anyref) (param $prefix anyref) (param $source anyref)
+  (call $http.http_serve_static (local.get $server) (local.get $prefix) (local.get $source))
+)
+
 (func $http.response_text (param $text anyref) (result anyref)
   (call $http.http_response_text_str (local.get $text))
 )
@@ -404,3 +526,39 @@
 (func $http.get_method (param $req anyref) (result anyref)
   (call $http.http_get_method (local.get $req))
 )
+
+(func $http.response_stream (param $fn anyref) (result anyref)
+  (call $http._run_stream (local.get $fn) (call $http._str_ct_text))
+)
+
+(func $http.sse (param $req anyref) (param $fn anyref) (result anyref)
+  (call $http._run_stream (local.get $fn) (call $http._str_ct_event_stream))
+)
+
+(func $http.write (param $w anyref) (param $chunk anyref) (result anyref)
+  (call $prelude.obj_set
+    (local.get $w)
+    (call $http._str_key_body)
+    (call $prelude.str_concat
+      (call $prelude.obj_get (local.get $w) (call $http._str_key_body))
+      (local.get $chunk)))
+  (call $prelude.val_undefined)
+)
+
+(func $http.flush (param $w anyref) (result anyref)
+  (call $prelude.val_undefined)
+)
+
+(func $http.send_event (param $w anyref) (param $event anyref) (param $data anyref) (result anyref)
+  (call $http.write
+    (local.get $w)
+    (call $http._sse_frame (local.get $event) (local.get $data)))
+)
+
+;; GC backend には接続が無いため WebSocket ハンドラーは呼ばれない。
+(func $http.add_websocket (param $server anyref) (param $path anyref) (param $handlers anyref)
+)
+
+(func $http.send (param $conn anyref) (param $text anyref) (result anyref)
+  (call $prelude.val_undefined)
+)
@@ -0,0 +1,171 @@
+;; io module: 標準入力を wasi_snapshot_preview1.fd_read（fd=0）で読む。
+;; tuna ターゲットでは Runner が fd_read を定義し、wasi ターゲットでは WASI ランタイムのものを使う。
+
+(import "wasi_snapshot_preview1" "fd_read"
+  (func $io.wasi_fd_read (param i32 i32 i32 i32) (result i32)))
+
+;; 読み込んだ標準入力は [buf+start, buf+end) に残し、read_line の続きで使う。
+(global $io_stdin_buf (mut i32) (i32.const 0))
+(global $io_stdin_cap (mut i32) (i32.const 0))
+(global $io_stdin_start (mut i32) (i32.const 0))
+(global $io_stdin_end (mut i32) (i32.const 0))
+(global $io_stdin_eof (mut i32) (i32.const 0))
+(global $io_stdin_iov (mut i32) (i32.const 0))
+
+(data $io_d_text
+  "read_stdin expects UTF-8 text"
+  "read_line expects UTF-8 text"
+  "read stdin: errno ")
+
+(func $io._lit (param $offset i32) (param $len i32) (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (local.get $len)))
+  (memory.init $io_d_text (local.get $ptr) (local.get $offset) (local.get $len))
+  (call $prelude._new_string_owned (local.get $ptr) (local.get $len))
+)
+
+(func $io._fail (param $errno i32) (result anyref)
+  (call $prelude.error
+    (call $prelude.str_concat
+      (call $io._lit (i32.const 57) (i32.const 18))
+      (call $prelude._i64_to_string (i64.extend_i32_u (local.get $errno)))))
+)
+
+;; バッファの空きに fd_read で1回読み込み、errno を返す。0 バイトなら入力の終わり。
+(func $io._fill (result i32)
+  (local $len i32)
+  (local $next i32)
+  (local $errno i32)
+  (local $n i32)
+  (if (i32.eqz (global.get $io_stdin_buf))
+    (then
+      (global.set $io_stdin_cap (i32.const 4096))
+      (global.set $io_stdin_buf (call $prelude._alloc (global.get $io_stdin_cap)))
+      (global.set $io_stdin_iov (call $prelude._alloc (i32.const 12)))
+    )
+  )
+  ;; 読み終えた部分を詰め、それでも空きが無ければ倍に広げる
+  (local.set $len (i32.sub (global.get $io_stdin_end) (global.get $io_stdin_start)))
+  (if (global.get $io_stdin_start)
+    (then
+      (memory.copy
+        (global.get $io_stdin_buf)
+        (i32.add (global.get $io_stdin_buf) (global.get $io_stdin_start))
+        (local.get $len))
+      (global.set $io_stdin_start (i32.const 0))
+      (global.set $io_stdin_end (local.get $len))
+    )
+  )
+  (if (i32.eq (global.get $io_stdin_end) (global.get $io_stdin_cap))
+    (then
+      (local.set $next (call $prelude._alloc (i32.shl (global.get $io_stdin_cap) (i32.const 1))))
+      (memory.copy (local.get $next) (global.get $io_stdin_buf) (global.get $io_stdin_end))
+      (global.set $io_stdin_buf (local.get $next))
+      (global.set $io_stdin_cap (i32.shl (global.get $io_stdin_cap) (i32.const 1)))
+    )
+  )
+  (i32.store (global.get $io_stdin_iov) (i32.add (global.get $io_stdin_buf) (global.get $io_stdin_end)))
+  (i32.store
+    (i32.add (global.get $io_stdin_iov) (i32.const 4))
+    (i32.sub (global.get $io_stdin_cap) (global.get $io_stdin_end)))
+  (local.set $errno
+    (call $io.wasi_fd_read
+      (i32.const 0)
+      (global.get $io_stdin_iov)
+      (i32.const 1)
+      (i32.add (global.get $io_stdin_iov) (i32.const 8))))
+  (if (local.get $errno)
+    (then (return (local.get $errno)))
+  )
+  (local.set $n (i32.load (i32.add (global.get $io_stdin_iov) (i32.const 8))))
+  (if (i32.eqz (local.get $n))
+    (then (global.set $io_stdin_eof (i32.const 1)))
+  )
+  (global.set $io_stdin_end (i32.add (global.get $io_stdin_end) (local.get $n)))
+  (i32.const 0)
+)
+
+(func $io.read_stdin (result anyref)
+  (local $errno i32)
+  (local $ptr i32)
+  (local $len i32)
+  (block $done
+    (loop $loop
+      (br_if $done (global.get $io_stdin_eof))
+      (local.set $errno (call $io._fill))
+      (if (local.get $errno)
+        (then (return (call $io._fail (local.get $errno))))
+      )
+      (br $loop)
+    )
+  )
+  (local.set $ptr (i32.add (global.get $io_stdin_buf) (global.get $io_stdin_start)))
+  (local.set $len (i32.sub (global.get $io_stdin_end) (global.get $io_stdin_start)))
+  (global.set $io_stdin_start (global.get $io_stdin_end))
+  (if (i32.eqz (call $prelude._valid_utf8 (local.get $ptr) (local.get $len)))
+    (then (return (call $prelude.error (call $io._lit (i32.const 0) (i32.const 29)))))
+  )
+  (call $prelude.str_from_utf8 (local.get $ptr) (local.get $len))
+)
+
+(func $io.read_line (result anyref)
+  (local $scanned i32)
+  (local $errno i32)
+  (local $ptr i32)
+  (local $len i32)
+  (local $next i32)
+  (block $found
+    (loop $loop
+      ;; 前回までに見た部分は飛ばして \n を探す
+      (block $scan_done
+        (loop $scan
+          (br_if $scan_done
+            (i32.ge_u
+              (i32.add (global.get $io_stdin_start) (local.get $scanned))
+              (global.get $io_stdin_end)))
+          (br_if $found
+            (i32.eq
+              (i32.load8_u
+                (i32.add
+                  (global.get $io_stdin_buf)
+                  (i32.add (global.get $io_stdin_start) (local.get $scanned))))
+              (i32.const 10)))
+          (local.set $scanned (i32.add (local.get $scanned) (i32.const 1)))
+          (br $scan)
+        )
+      )
+      (if (global.get $io_stdin_eof)
+        (then
+          ;; 改行で終わらない最後の行
+          (if (i32.eqz (local.get $scanned))
+            (then (return (call $prelude.val_undefined)))
+          )
+          (br $found)
+        )
+      )
+      (local.set $errno (call $io._fill))
+      (if (local.get $errno)
+        (then (return (call $io._fail (local.get $errno))))
+      )
+      (br $loop)
+    )
+  )
+  (local.set $ptr (i32.add (global.get $io_stdin_buf) (global.get $io_stdin_start)))
+  (local.set $len (local.get $scanned))
+  (local.set $next (i32.add (global.get $io_stdin_start) (local.get $scanned)))
+  (if (i32.lt_u (local.get $next) (global.get $io_stdin_end))
+    (then (local.set $next (i32.add (local.get $next) (i32.const 1))))
+  )
+  (global.set $io_stdin_start (local.get $next))
+  (if (i32.and
+        (i32.gt_u (local.get $len) (i32.const 0))
+        (i32.eq
+          (i32.load8_u (i32.add (local.get $ptr) (i32.sub (local.get $len) (i32.const 1))))
+          (i32.const 13)))
+    (then (local.set $len (i32.sub (local.get $len) (i32.const 1))))
+  )
+  (if (i32.eqz (call $prelude._valid_utf8 (local.get $ptr) (local.get $len)))
+    (then (return (call $prelude.error (call $io._lit (i32.const 29) (i32.const 28)))))
+  )
+  (call $prelude.str_from_utf8 (local.get $ptr) (local.get $len))
+)
@@ -4,6 +4,12 @@
 (global $json_out_ptr (mut i32) (i32.const 0))
 (global $json_out_len (mut i32) (i32.const 0))
 (global $json_out_cap (mut i32) (i32.const 0))
+;; stringify_pretty のインデント幅（0 なら改行しない）と現在の深さ、stringify_canonical かどうか
+(global $json_out_indent (mut i32) (i32.const 0))
+(global $json_out_depth (mut i32) (i32.const 0))
+(global $json_out_canonical (mut i32) (i32.const 0))
+;; stringify_canonical の多倍長整数の作業領域
+(global $json_big (mut i32) (i32.const 0))
 
 (global $json_parse_ptr (mut i32) (i32.const 0))
 (global $json_parse_end (mut i32) (i32.const 0))
@@ -12,6 +18,19 @@
 
 (global $json_decode_err (mut i32) (i32.const 0))
 (global $json_decode_err_msg (mut anyref) (ref.null any))
+;; 最初のエラーの位置と理由（union で decode_all の issue を作り直すため）
+(global $json_decode_err_path (mut anyref) (ref.null any))
+(global $json_decode_err_reason (mut anyref) (ref.null any))
+;; decode_all: エラーで止まらずに { path, message } をすべて集める
+(global $json_decode_collect (mut i32) (i32.const 0))
+(global $json_decode_issues (mut anyref) (ref.null any))
+(global $json_decode_issue_count (mut i32) (i32.const 0))
+
+;; pattern の照合中のパターンと文字列
+(global $json_re_p (mut i32) (i32.const 0))
+(global $json_re_plen (mut i32) (i32.const 0))
+(global $json_re_s (mut i32) (i32.const 0))
+(global $json_re_slen (mut i32) (i32.const 0))
 
 (data $json_d_type "type")
 (data $json_d_error "error")
@@ -35,6 +54,14 @@
 (data $json_d_name "name")
 (data $json_d_index "index")
 (data $json_d_union "union")
+(data $json_d_min_length "min_length")
+(data $json_d_max_length "max_length")
+(data $json_d_min "min")
+(data $json_d_max "max")
+(data $json_d_pattern "pattern")
+(data $json_d_default "default")
+(data $json_d_path "path")
+(data $json_d_errors "errors")
 
 (data $json_d_undefined_expected "undefined expected")
 (data $json_d_null_expected "null expected")
@@ -54,6 +81,15 @@
 (data $json_d_union_expected "union expected")
 (data $json_d_unsupported_schema_kind "unsupported schema kind")
 (data $json_d_object_expected "object expected")
+(data $json_d_length_at_least "length must be >= ")
+(data $json_d_length_at_most "length must be <= ")
+(data $json_d_at_least "must be >= ")
+(data $json_d_at_most "must be <= ")
+(data $json_d_pattern_mismatch "must match pattern ")
+(data $json_d_semicolon_space "; ")
+
+;; json_kind の名前（"null" "boolean" "i64" "f64" "string" "array" "object" を連結したもの）
+(data $json_d_kind_names "nullbooleani64f64stringarrayobject")
 
 (func $json._str_type (result anyref)
   (local $ptr i32)
@@ -332,12 +368,133 @@
   (call $prelude._new_string_owned (local.get $ptr) (i32.const 5))
 )
 
+(func $json._k_min_length (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 10)))
+  (memory.init $json_d_min_length (local.get $ptr) (i32.const 0) (i32.const 10))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 10))
+)
+
+(func $json._k_max_length (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 10)))
+  (memory.init $json_d_max_length (local.get $ptr) (i32.const 0) (i32.const 10))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 10))
+)
+
+(func $json._k_min (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 3)))
+  (memory.init $json_d_min (local.get $ptr) (i32.const 0) (i32.const 3))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 3))
+)
+
+(func $json._k_max (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 3)))
+  (memory.init $json_d_max (local.get $ptr) (i32.const 0) (i32.const 3))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 3))
+)
+
+(func $json._k_pattern (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 7)))
+  (memory.init $json_d_pattern (local.get $ptr) (i32.const 0) (i32.const 7))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 7))
+)
+
+(func $json._k_default (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 7)))
+  (memory.init $json_d_default (local.get $ptr) (i32.const 0) (i32.const 7))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 7))
+)
+
+(func $json._k_path (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 4)))
+  (memory.init $json_d_path (local.get $ptr) (i32.const 0) (i32.const 4))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 4))
+)
+
+(func $json._k_errors (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 6)))
+  (memory.init $json_d_errors (local.get $ptr) (i32.const 0) (i32.const 6))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 6))
+)
+
+(func $json._msg_length_at_least (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 18)))
+  (memory.init $json_d_length_at_least (local.get $ptr) (i32.const 0) (i32.const 18))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 18))
+)
+
+(func $json._msg_length_at_most (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 18)))
+  (memory.init $json_d_length_at_most (local.get $ptr) (i32.const 0) (i32.const 18))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 18))
+)
+
+(func $json._msg_at_least (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 11)))
+  (memory.init $json_d_at_least (local.get $ptr) (i32.const 0) (i32.const 11))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 11))
+)
+
+(func $json._msg_at_most (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 11)))
+  (memory.init $json_d_at_most (local.get $ptr) (i32.const 0) (i32.const 11))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 11))
+)
+
+(func $json._msg_pattern_mismatch (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 19)))
+  (memory.init $json_d_pattern_mismatch (local.get $ptr) (i32.const 0) (i32.const 19))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 19))
+)
+
+(func $json._str_semicolon_space (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (i32.const 2)))
+  (memory.init $json_d_semicolon_space (local.get $ptr) (i32.const 0) (i32.const 2))
+  (call $prelude._new_string_owned (local.get $ptr) (i32.const 2))
+)
+
 (func $json._error_from_msg (param $msg anyref) (result anyref)
   (call $prelude.error (local.get $msg))
 )
 
 (func $json._out_reset
   (global.set $json_out_len (i32.const 0))
+  (global.set $json_out_indent (i32.const 0))
+  (global.set $json_out_depth (i32.const 0))
+  (global.set $json_out_canonical (i32.const 0))
+)
+
+;; 改行して現在の深さまでインデントする（stringify_pretty のときだけ）
+(func $json._append_newline_indent
+  (local $n i32)
+  (if (i32.eqz (global.get $json_out_indent))
+    (then
+      (return)
+    )
+  )
+  (call $json._append_byte (i32.const 10)) ;; \n
+  (local.set $n (i32.mul (global.get $json_out_indent) (global.get $json_out_depth)))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.le_s (local.get $n) (i32.const 0)))
+      (call $json._append_byte (i32.const 32))
+      (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+      (br $loop)
+    )
+  )
 )
 
 (func $json._out_reserve (param $extra i32)
@@ -733,7 +890,14 @@
   )
   (if (i32.eq (local.get $kind) (i32.const 1))
     (then
-      (call $json._write_f64 (call $prelude.val_to_f64 (local.get $value)))
+      (if (global.get $json_out_canonical)
+        (then
+          (call $json._write_f64_shortest (call $prelude.val_to_f64 (local.get $value)))
+        )
+        (else
+          (call $json._write_f64 (call $prelude.val_to_f64 (local.get $value)))
+        )
+      )
       (return)
     )
   )
@@ -786,6 +950,7 @@
   (call $json._append_byte (i32.const 91)) ;; [
   (local.set $len (call $prelude.arr_len (local.get $arr)))
   (local.set $i (i32.const 0))
+  (global.set $json_out_depth (i32.add (global.get $json_out_depth) (i32.const 1)))
 
   (block $done
     (loop $loop
@@ -796,6 +961,7 @@
           (call $json._append_byte (i32.const 44)) ;; ,
         )
       )
+      (call $json._append_newline_indent)
 
       (call $json._write_value
         (call $prelude.arr_get (local.get $arr) (local.get $i)))
@@ -805,6 +971,12 @@
     )
   )
 
+  (global.set $json_out_depth (i32.sub (global.get $json_out_depth) (i32.const 1)))
+  (if (i32.gt_u (local.get $len) (i32.const 0))
+    (then
+      (call $json._append_newline_indent)
+    )
+  )
   (call $json._append_byte (i32.const 93)) ;; ]
 )
 
@@ -818,9 +990,15 @@
 
   (call $json._append_byte (i32.const 123)) ;; {
   (local.set $keys (call $prelude.obj_keys (local.get $obj)))
+  (if (global.get $json_out_canonical)
+    (then
+      (call $json._sort_keys (local.get $keys))
+    )
+  )
   (local.set $len (call $prelude.arr_len (local.get $keys)))
   (local.set $i (i32.const 0))
   (local.set $wrote (i32.const 0))
+  (global.set $json_out_depth (i32.add (global.get $json_out_depth) (i32.const 1)))
 
   (block $done
     (loop $loop
@@ -842,9 +1020,15 @@
         )
       )
       (local.set $wrote (i32.add (local.get $wrote) (i32.const 1)))
+      (call $json._append_newline_indent)
 
       (call $json._write_quoted (local.get $key))
       (call $json._append_byte (i32.const 58)) ;; :
+      (if (global.get $json_out_indent)
+        (then
+          (call $json._append_byte (i32.const 32))
+        )
+      )
       (call $json._write_value (local.get $val))
 
       (local.set $i (i32.add (local.get $i) (i32.const 1)))
@@ -852,6 +1036,12 @@
     )
   )
 
+  (global.set $json_out_depth (i32.sub (global.get $json_out_depth) (i32.const 1)))
+  (if (i32.gt_u (local.get $wrote) (i32.const 0))
+    (then
+      (call $json._append_newline_indent)
+    )
+  )
   (call $json._append_byte (i32.const 125)) ;; }
 )
 
@@ -863,1937 +1053,3206 @@
     (global.get $json_out_len))
 )
 
-(func $json._parse_reset (param $ptr i32) (param $len i32)
-  (global.set $json_parse_ptr (local.get $ptr))
-  (global.set $json_parse_end (i32.add (local.get $ptr) (local.get $len)))
-  (global.set $json_parse_err (i32.const 0))
-  (global.set $json_parse_err_msg (ref.null any))
-)
-
-(func $json._parse_set_error (param $msg anyref)
-  (if (i32.eqz (global.get $json_parse_err))
+;; JSON.stringify(value, null, indent) と同じ形。indent は 0〜10 に丸め、0 なら stringify と同じ。
+(func $json.stringify_pretty (param $value anyref) (param $indent i64) (result anyref)
+  (call $json._out_reset)
+  (if (i64.gt_s (local.get $indent) (i64.const 10))
     (then
-      (global.set $json_parse_err (i32.const 1))
-      (global.set $json_parse_err_msg (local.get $msg))
+      (local.set $indent (i64.const 10))
     )
   )
+  (if (i64.gt_s (local.get $indent) (i64.const 0))
+    (then
+      (global.set $json_out_indent (i32.wrap_i64 (local.get $indent)))
+    )
+  )
+  (call $json._write_value (local.get $value))
+  (call $prelude.str_from_utf8
+    (global.get $json_out_ptr)
+    (global.get $json_out_len))
 )
 
-(func $json._parse_fail_invalid
-  (call $json._parse_set_error (call $json._msg_invalid_json))
+;; RFC 8785 (JCS) の形。キーを UTF-16 の順に並べ、f64 は最短の表現にする。i64 は整数のまま出力する。
+(func $json.stringify_canonical (param $value anyref) (result anyref)
+  (call $json._out_reset)
+  (global.set $json_out_canonical (i32.const 1))
+  (call $json._write_value (local.get $value))
+  (global.set $json_out_canonical (i32.const 0))
+  (call $prelude.str_from_utf8
+    (global.get $json_out_ptr)
+    (global.get $json_out_len))
 )
 
-(func $json._is_digit (param $c i32) (result i32)
-  (i32.and
-    (i32.ge_u (local.get $c) (i32.const 48))
-    (i32.le_u (local.get $c) (i32.const 57)))
+;; ---- stringify_canonical ----
+
+;; UTF-8 の s[i] から始まる文字のコードポイント（不正なバイトはそのまま）
+(func $json._utf8_char_at (param $ptr i32) (param $i i32) (param $len i32) (result i32)
+  (local $b i32)
+  (local $n i32)
+  (local $cp i32)
+  (local $j i32)
+  (local.set $b (i32.load8_u (i32.add (local.get $ptr) (local.get $i))))
+  (if (i32.lt_u (local.get $b) (i32.const 0x80))
+    (then
+      (return (local.get $b))
+    )
+  )
+  (if (i32.eq (i32.and (local.get $b) (i32.const 0xe0)) (i32.const 0xc0))
+    (then
+      (local.set $n (i32.const 1))
+      (local.set $cp (i32.and (local.get $b) (i32.const 0x1f)))
+    )
+    (else
+      (if (i32.eq (i32.and (local.get $b) (i32.const 0xf0)) (i32.const 0xe0))
+        (then
+          (local.set $n (i32.const 2))
+          (local.set $cp (i32.and (local.get $b) (i32.const 0x0f)))
+        )
+        (else
+          (if (i32.eq (i32.and (local.get $b) (i32.const 0xf8)) (i32.const 0xf0))
+            (then
+              (local.set $n (i32.const 3))
+              (local.set $cp (i32.and (local.get $b) (i32.const 0x07)))
+            )
+            (else
+              (return (local.get $b))
+            )
+          )
+        )
+      )
+    )
+  )
+  (local.set $j (i32.const 1))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.gt_u (local.get $j) (local.get $n)))
+      (br_if $done (i32.ge_u (i32.add (local.get $i) (local.get $j)) (local.get $len)))
+      (local.set $cp
+        (i32.or
+          (i32.shl (local.get $cp) (i32.const 6))
+          (i32.and
+            (i32.load8_u (i32.add (local.get $ptr) (i32.add (local.get $i) (local.get $j))))
+            (i32.const 0x3f))))
+      (local.set $j (i32.add (local.get $j) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (local.get $cp)
 )
 
-(func $json._hex_nibble (param $c i32) (result i32)
-  (if (i32.and
-        (i32.ge_u (local.get $c) (i32.const 48))
-        (i32.le_u (local.get $c) (i32.const 57)))
+(func $json._utf8_char_len (param $b i32) (result i32)
+  (if (i32.eq (i32.and (local.get $b) (i32.const 0xe0)) (i32.const 0xc0))
     (then
-      (return (i32.sub (local.get $c) (i32.const 48)))
+      (return (i32.const 2))
     )
   )
-  (if (i32.and
-        (i32.ge_u (local.get $c) (i32.const 65))
-        (i32.le_u (local.get $c) (i32.const 70)))
+  (if (i32.eq (i32.and (local.get $b) (i32.const 0xf0)) (i32.const 0xe0))
     (then
-      (return (i32.sub (local.get $c) (i32.const 55)))
+      (return (i32.const 3))
     )
   )
-  (if (i32.and
-        (i32.ge_u (local.get $c) (i32.const 97))
-        (i32.le_u (local.get $c) (i32.const 102)))
+  (if (i32.eq (i32.and (local.get $b) (i32.const 0xf8)) (i32.const 0xf0))
     (then
-      (return (i32.sub (local.get $c) (i32.const 87)))
+      (return (i32.const 4))
     )
   )
-  (i32.const -1)
+  (i32.const 1)
 )
 
-(func $json._parse_hex4 (result i32)
-  (local $n i32)
+;; コードポイントの UTF-16 での先頭のコードユニット
+(func $json._utf16_lead (param $cp i32) (result i32)
+  (if (i32.lt_u (local.get $cp) (i32.const 0x10000))
+    (then
+      (return (local.get $cp))
+    )
+  )
+  (i32.add
+    (i32.const 0xd800)
+    (i32.shr_u (i32.sub (local.get $cp) (i32.const 0x10000)) (i32.const 10)))
+)
+
+;; a と b を UTF-16 のコードユニットの順で比べる（-1 / 0 / 1）
+(func $json._str_cmp_utf16 (param $a anyref) (param $b anyref) (result i32)
+  (local $aptr i32)
+  (local $bptr i32)
+  (local $alen i32)
+  (local $blen i32)
   (local $i i32)
-  (local $c i32)
-  (local $d i32)
+  (local $j i32)
+  (local $ac i32)
+  (local $bc i32)
+  (local $au i32)
+  (local $bu i32)
 
-  (local.set $n (i32.const 0))
-  (local.set $i (i32.const 0))
+  (local.set $aptr (call $prelude._string_ptr (local.get $a)))
+  (local.set $bptr (call $prelude._string_ptr (local.get $b)))
+  (local.set $alen (call $prelude._string_bytelen (local.get $a)))
+  (local.set $blen (call $prelude._string_bytelen (local.get $b)))
 
   (block $done
     (loop $loop
-      (br_if $done (i32.ge_u (local.get $i) (i32.const 4)))
-      (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+      (br_if $done (i32.ge_u (local.get $i) (local.get $alen)))
+      (br_if $done (i32.ge_u (local.get $j) (local.get $blen)))
+      (local.set $ac (call $json._utf8_char_at (local.get $aptr) (local.get $i) (local.get $alen)))
+      (local.set $bc (call $json._utf8_char_at (local.get $bptr) (local.get $j) (local.get $blen)))
+      (if (i32.ne (local.get $ac) (local.get $bc))
         (then
-          (return (i32.const -1))
+          ;; U+10000 以上はサロゲートペアなので U+E000〜U+FFFF より前に並ぶ
+          (local.set $au (call $json._utf16_lead (local.get $ac)))
+          (local.set $bu (call $json._utf16_lead (local.get $bc)))
+          (if (i32.eq (local.get $au) (local.get $bu))
+            (then
+              (local.set $au (local.get $ac))
+              (local.set $bu (local.get $bc))
+            )
+          )
+          (if (i32.lt_u (local.get $au) (local.get $bu))
+            (then
+              (return (i32.const -1))
+            )
+          )
+          (return (i32.const 1))
         )
       )
+      (local.set $i
+        (i32.add
+          (local.get $i)
+          (call $json._utf8_char_len (i32.load8_u (i32.add (local.get $aptr) (local.get $i))))))
+      (local.set $j
+        (i32.add
+          (local.get $j)
+          (call $json._utf8_char_len (i32.load8_u (i32.add (local.get $bptr) (local.get $j))))))
+      (br $loop)
+    )
+  )
 
-      (local.set $c (i32.load8_u (global.get $json_parse_ptr)))
-      (global.set $json_parse_ptr
-        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-      (local.set $d (call $json._hex_nibble (local.get $c)))
-      (if (i32.lt_s (local.get $d) (i32.const 0))
-        (then
-          (return (i32.const -1))
+  (if (i32.lt_u (local.get $i) (local.get $alen))
+    (then
+      (return (i32.const 1))
+    )
+  )
+  (if (i32.lt_u (local.get $j) (local.get $blen))
+    (then
+      (return (i32.const -1))
+    )
+  )
+  (i32.const 0)
+)
+
+;; obj_keys が返した新しい配列をその場で並べ替える（挿入ソート）
+(func $json._sort_keys (param $keys anyref)
+  (local $len i32)
+  (local $i i32)
+  (local $j i32)
+  (local $key anyref)
+  (local $prev anyref)
+
+  (local.set $len (call $prelude.arr_len (local.get $keys)))
+  (local.set $i (i32.const 1))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+      (local.set $key (call $prelude.arr_get (local.get $keys) (local.get $i)))
+      (local.set $j (local.get $i))
+      (block $insert_done
+        (loop $insert
+          (br_if $insert_done (i32.eqz (local.get $j)))
+          (local.set $prev
+            (call $prelude.arr_get (local.get $keys) (i32.sub (local.get $j) (i32.const 1))))
+          (br_if $insert_done
+            (i32.le_s (call $json._str_cmp_utf16 (local.get $prev) (local.get $key)) (i32.const 0)))
+          (call $prelude.arr_set (local.get $keys) (local.get $j) (local.get $prev))
+          (local.set $j (i32.sub (local.get $j) (i32.const 1)))
+          (br $insert)
         )
       )
-
-      (local.set $n
-        (i32.add
-          (i32.shl (local.get $n) (i32.const 4))
-          (local.get $d)))
+      (call $prelude.arr_set (local.get $keys) (local.get $j) (local.get $key))
       (local.set $i (i32.add (local.get $i) (i32.const 1)))
       (br $loop)
     )
   )
-
-  (local.get $n)
 )
 
-(func $json._append_utf8 (param $buf i32) (param $len i32) (param $cp i32) (result i32)
-  (if (i32.or
-        (i32.lt_s (local.get $cp) (i32.const 0))
-        (i32.gt_u (local.get $cp) (i32.const 1114111)))
+;; ---- 多倍長整数（u32 × 40 桁のリトルエンディアン。f64 の最短表現の計算用） ----
+
+;; n 番目（0〜4）の作業領域
+(func $json._big (param $n i32) (result i32)
+  (if (i32.eqz (global.get $json_big))
     (then
-      (return (i32.const -1))
+      (global.set $json_big (call $prelude._alloc (i32.const 800)))
     )
   )
+  (i32.add (global.get $json_big) (i32.mul (local.get $n) (i32.const 160)))
+)
 
-  (if (i32.le_u (local.get $cp) (i32.const 127))
+(func $json._big_limb (param $a i32) (param $i i32) (result i64)
+  (if (i32.lt_s (local.get $i) (i32.const 0))
     (then
-      (i32.store8
-        (i32.add (local.get $buf) (local.get $len))
-        (local.get $cp))
-      (return (i32.add (local.get $len) (i32.const 1)))
+      (return (i64.const 0))
     )
   )
+  (i64.load32_u (i32.add (local.get $a) (i32.shl (local.get $i) (i32.const 2))))
+)
 
-  (if (i32.le_u (local.get $cp) (i32.const 2047))
-    (then
-      (i32.store8
-        (i32.add (local.get $buf) (local.get $len))
-        (i32.or
-          (i32.const 192)
-          (i32.shr_u (local.get $cp) (i32.const 6))))
-      (i32.store8
-        (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 1)))
-        (i32.or
-          (i32.const 128)
-          (i32.and (local.get $cp) (i32.const 63))))
-      (return (i32.add (local.get $len) (i32.const 2)))
+(func $json._big_set (param $a i32) (param $v i64) (param $shift i32)
+  (memory.fill (local.get $a) (i32.const 0) (i32.const 160))
+  (i64.store (local.get $a) (local.get $v))
+  (call $json._big_shl (local.get $a) (local.get $shift))
+)
+
+(func $json._big_shl (param $a i32) (param $bits i32)
+  (local $limbs i32)
+  (local $rest i64)
+  (local $i i32)
+  (local $src i32)
+  (local.set $limbs (i32.shr_u (local.get $bits) (i32.const 5)))
+  (local.set $rest (i64.extend_i32_u (i32.and (local.get $bits) (i32.const 31))))
+  (local.set $i (i32.const 39))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.lt_s (local.get $i) (i32.const 0)))
+      (local.set $src (i32.sub (local.get $i) (local.get $limbs)))
+      (i32.store
+        (i32.add (local.get $a) (i32.shl (local.get $i) (i32.const 2)))
+        (i32.wrap_i64
+          (i64.shr_u
+            (i64.or
+              (i64.shl (call $json._big_limb (local.get $a) (local.get $src)) (i64.const 32))
+              (call $json._big_limb (local.get $a) (i32.sub (local.get $src) (i32.const 1))))
+            (i64.sub (i64.const 32) (local.get $rest)))))
+      (local.set $i (i32.sub (local.get $i) (i32.const 1)))
+      (br $loop)
     )
   )
+)
 
-  (if (i32.le_u (local.get $cp) (i32.const 65535))
-    (then
-      (i32.store8
-        (i32.add (local.get $buf) (local.get $len))
-        (i32.or
-          (i32.const 224)
-          (i32.shr_u (local.get $cp) (i32.const 12))))
-      (i32.store8
-        (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 1)))
-        (i32.or
-          (i32.const 128)
-          (i32.and
-            (i32.shr_u (local.get $cp) (i32.const 6))
-            (i32.const 63))))
-      (i32.store8
-        (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 2)))
-        (i32.or
-          (i32.const 128)
-          (i32.and (local.get $cp) (i32.const 63))))
-      (return (i32.add (local.get $len) (i32.const 3)))
+(func $json._big_mul_small (param $a i32) (param $m i32)
+  (local $i i32)
+  (local $t i64)
+  (local $carry i64)
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (i32.const 40)))
+      (local.set $t
+        (i64.add
+          (i64.mul (call $json._big_limb (local.get $a) (local.get $i)) (i64.extend_i32_u (local.get $m)))
+          (local.get $carry)))
+      (i32.store (i32.add (local.get $a) (i32.shl (local.get $i) (i32.const 2))) (i32.wrap_i64 (local.get $t)))
+      (local.set $carry (i64.shr_u (local.get $t) (i64.const 32)))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
     )
   )
+)
 
-  (i32.store8
-    (i32.add (local.get $buf) (local.get $len))
-    (i32.or
-      (i32.const 240)
-      (i32.shr_u (local.get $cp) (i32.const 18))))
-  (i32.store8
-    (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 1)))
-    (i32.or
-      (i32.const 128)
-      (i32.and
-        (i32.shr_u (local.get $cp) (i32.const 12))
-        (i32.const 63))))
-  (i32.store8
-    (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 2)))
-    (i32.or
-      (i32.const 128)
-      (i32.and
-        (i32.shr_u (local.get $cp) (i32.const 6))
-        (i32.const 63))))
-  (i32.store8
-    (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 3)))
-    (i32.or
-      (i32.const 128)
-      (i32.and (local.get $cp) (i32.const 63))))
-  (i32.add (local.get $len) (i32.const 4))
+(func $json._big_mul_pow10 (param $a i32) (param $k i32)
+  (block $done
+    (loop $loop
+      (br_if $done (i32.le_s (local.get $k) (i32.const 0)))
+      (call $json._big_mul_small (local.get $a) (i32.const 10))
+      (local.set $k (i32.sub (local.get $k) (i32.const 1)))
+      (br $loop)
+    )
+  )
 )
 
-(func $json._parse_skip_ws
-  (local $c i32)
+;; dst = a + b（dst は a と同じでもよい）
+(func $json._big_add (param $dst i32) (param $a i32) (param $b i32)
+  (local $i i32)
+  (local $t i64)
+  (local $carry i64)
   (block $done
     (loop $loop
-      (br_if $done
-        (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end)))
-      (local.set $c (i32.load8_u (global.get $json_parse_ptr)))
-      (if (i32.or
-            (i32.or
-              (i32.eq (local.get $c) (i32.const 32))
-              (i32.eq (local.get $c) (i32.const 9)))
-            (i32.or
-              (i32.eq (local.get $c) (i32.const 10))
-              (i32.eq (local.get $c) (i32.const 13))))
+      (br_if $done (i32.ge_u (local.get $i) (i32.const 40)))
+      (local.set $t
+        (i64.add
+          (i64.add
+            (call $json._big_limb (local.get $a) (local.get $i))
+            (call $json._big_limb (local.get $b) (local.get $i)))
+          (local.get $carry)))
+      (i32.store (i32.add (local.get $dst) (i32.shl (local.get $i) (i32.const 2))) (i32.wrap_i64 (local.get $t)))
+      (local.set $carry (i64.shr_u (local.get $t) (i64.const 32)))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+)
+
+;; a -= b（a >= b のとき）
+(func $json._big_sub (param $a i32) (param $b i32)
+  (local $i i32)
+  (local $t i64)
+  (local $borrow i64)
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (i32.const 40)))
+      (local.set $t
+        (i64.sub
+          (i64.sub
+            (call $json._big_limb (local.get $a) (local.get $i))
+            (call $json._big_limb (local.get $b) (local.get $i)))
+          (local.get $borrow)))
+      (i32.store (i32.add (local.get $a) (i32.shl (local.get $i) (i32.const 2))) (i32.wrap_i64 (local.get $t)))
+      (local.set $borrow (i64.extend_i32_u (i64.lt_s (local.get $t) (i64.const 0))))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+)
+
+(func $json._big_cmp (param $a i32) (param $b i32) (result i32)
+  (local $i i32)
+  (local $x i64)
+  (local $y i64)
+  (local.set $i (i32.const 39))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.lt_s (local.get $i) (i32.const 0)))
+      (local.set $x (call $json._big_limb (local.get $a) (local.get $i)))
+      (local.set $y (call $json._big_limb (local.get $b) (local.get $i)))
+      (if (i64.lt_u (local.get $x) (local.get $y))
         (then
-          (global.set $json_parse_ptr
-            (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-          (br $loop)
+          (return (i32.const -1))
         )
       )
-      (br $done)
+      (if (i64.gt_u (local.get $x) (local.get $y))
+        (then
+          (return (i32.const 1))
+        )
+      )
+      (local.set $i (i32.sub (local.get $i) (i32.const 1)))
+      (br $loop)
     )
   )
+  (i32.const 0)
 )
 
-(func $json._parse_f64_token (param $start i32) (param $len i32) (result f64)
-  (local $idx i32)
-  (local $end i32)
-  (local $neg i32)
-  (local $digit i32)
-  (local $c i32)
-  (local $val f64)
-  (local $factor f64)
-  (local $exp i32)
-  (local $exp_neg i32)
+;; ECMAScript の Number.prototype.toString と同じ形で f64 を書く（RFC 8785 の数値）。
+;; 桁は Burger & Dybvig の方法で、元の値に戻る最短で最も近いもの（同じ近さなら偶数）を求める。
+(func $json._write_f64_shortest (param $v f64)
+  (local $x f64)
+  (local $bits i64)
+  (local $be i32)
+  (local $frac i64)
+  (local $f i64)
+  (local $e i32)
+  (local $even i32)
   (local $k i32)
+  (local $r i32)
+  (local $s i32)
+  (local $mp i32)
+  (local $mm i32)
+  (local $tmp i32)
+  (local $c i32)
+  (local $d i32)
+  (local $low i32)
+  (local $high i32)
+  (local $buf i32)
+  (local $len i32)
 
-  (local.set $idx (local.get $start))
-  (local.set $end (i32.add (local.get $start) (local.get $len)))
-  (local.set $neg (i32.const 0))
-  (local.set $val (f64.const 0))
-  (local.set $exp (i32.const 0))
-  (local.set $exp_neg (i32.const 0))
-
-  (if (i32.and
-        (i32.lt_u (local.get $idx) (local.get $end))
-        (i32.eq
-          (i32.load8_u (local.get $idx))
-          (i32.const 45)))
+  (if (f64.ne (local.get $v) (local.get $v))
     (then
-      (local.set $neg (i32.const 1))
-      (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
+      unreachable
     )
   )
-
-  (block $int_done
-    (loop $int_loop
-      (br_if $int_done (i32.ge_u (local.get $idx) (local.get $end)))
-      (local.set $c (i32.load8_u (local.get $idx)))
-      (br_if $int_done (i32.eqz (call $json._is_digit (local.get $c))))
-      (local.set $digit (i32.sub (local.get $c) (i32.const 48)))
-      (local.set $val
-        (f64.add
-          (f64.mul (local.get $val) (f64.const 10))
-          (f64.convert_i32_u (local.get $digit))))
-      (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
-      (br $int_loop)
+  (if (f64.eq (f64.abs (local.get $v)) (f64.const inf))
+    (then
+      unreachable
+    )
+  )
+  (local.set $x (local.get $v))
+  (if (f64.lt (local.get $x) (f64.const 0))
+    (then
+      (call $json._append_byte (i32.const 45)) ;; -
+      (local.set $x (f64.neg (local.get $x)))
+    )
+  )
+  (if (f64.eq (local.get $x) (f64.const 0))
+    (then
+      (call $json._append_byte (i32.const 48)) ;; 0
+      (return)
     )
   )
 
-  (if (i32.and
-        (i32.lt_u (local.get $idx) (local.get $end))
-        (i32.eq
-          (i32.load8_u (local.get $idx))
-          (i32.const 46)))
+  ;; x = f * 2^e
+  (local.set $bits (i64.reinterpret_f64 (local.get $x)))
+  (local.set $be (i32.wrap_i64 (i64.shr_u (local.get $bits) (i64.const 52))))
+  (local.set $frac (i64.and (local.get $bits) (i64.const 0xfffffffffffff)))
+  (if (i32.eqz (local.get $be))
     (then
-      (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
-      (local.set $factor (f64.const 0.1))
-      (block $frac_done
-        (loop $frac_loop
-          (br_if $frac_done (i32.ge_u (local.get $idx) (local.get $end)))
-          (local.set $c (i32.load8_u (local.get $idx)))
-          (br_if $frac_done (i32.eqz (call $json._is_digit (local.get $c))))
-          (local.set $digit (i32.sub (local.get $c) (i32.const 48)))
-          (local.set $val
-            (f64.add
-              (local.get $val)
-              (f64.mul
-                (f64.convert_i32_u (local.get $digit))
-                (local.get $factor))))
-          (local.set $factor
-            (f64.mul (local.get $factor) (f64.const 0.1)))
-          (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
-          (br $frac_loop)
-        )
-      )
+      (local.set $f (local.get $frac))
+      (local.set $e (i32.const -1074))
+    )
+    (else
+      (local.set $f (i64.or (local.get $frac) (i64.const 0x10000000000000)))
+      (local.set $e (i32.sub (local.get $be) (i32.const 1075)))
     )
   )
+  (local.set $even (i64.eqz (i64.and (local.get $f) (i64.const 1))))
 
-  (if (i32.and
-        (i32.lt_u (local.get $idx) (local.get $end))
-        (i32.or
-          (i32.eq
-            (i32.load8_u (local.get $idx))
-            (i32.const 101))
-          (i32.eq
-            (i32.load8_u (local.get $idx))
-            (i32.const 69))))
+  ;; x = r / s、上下の丸めの幅の半分が mp / s と mm / s
+  (local.set $r (call $json._big (i32.const 0)))
+  (local.set $s (call $json._big (i32.const 1)))
+  (local.set $mp (call $json._big (i32.const 2)))
+  (local.set $mm (call $json._big (i32.const 3)))
+  (local.set $tmp (call $json._big (i32.const 4)))
+  (if (i32.ge_s (local.get $e) (i32.const 0))
     (then
-      (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
-      (if (i32.and
-            (i32.lt_u (local.get $idx) (local.get $end))
-            (i32.eq
-              (i32.load8_u (local.get $idx))
-              (i32.const 43)))
-        (then
-          (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
-        )
-      )
-      (if (i32.and
-            (i32.lt_u (local.get $idx) (local.get $end))
-            (i32.eq
-              (i32.load8_u (local.get $idx))
-              (i32.const 45)))
+      (if (i32.and (i64.eqz (local.get $frac)) (i32.gt_u (local.get $be) (i32.const 1)))
         (then
-          (local.set $exp_neg (i32.const 1))
-          (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
+          ;; 2 の累乗は下側の幅が半分
+          (call $json._big_set (local.get $r) (local.get $f) (i32.add (local.get $e) (i32.const 2)))
+          (call $json._big_set (local.get $s) (i64.const 4) (i32.const 0))
+          (call $json._big_set (local.get $mp) (i64.const 1) (i32.add (local.get $e) (i32.const 1)))
+          (call $json._big_set (local.get $mm) (i64.const 1) (local.get $e))
         )
-      )
-      (local.set $exp (i32.const 0))
-      (block $exp_done
-        (loop $exp_loop
-          (br_if $exp_done (i32.ge_u (local.get $idx) (local.get $end)))
-          (local.set $c (i32.load8_u (local.get $idx)))
-          (br_if $exp_done (i32.eqz (call $json._is_digit (local.get $c))))
-          (local.set $exp
-            (i32.add
-              (i32.mul (local.get $exp) (i32.const 10))
-              (i32.sub (local.get $c) (i32.const 48))))
-          (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
-          (br $exp_loop)
+        (else
+          (call $json._big_set (local.get $r) (local.get $f) (i32.add (local.get $e) (i32.const 1)))
+          (call $json._big_set (local.get $s) (i64.const 2) (i32.const 0))
+          (call $json._big_set (local.get $mp) (i64.const 1) (local.get $e))
+          (call $json._big_set (local.get $mm) (i64.const 1) (local.get $e))
         )
       )
-      (if (local.get $exp_neg)
+    )
+    (else
+      (if (i32.and (i64.eqz (local.get $frac)) (i32.gt_u (local.get $be) (i32.const 1)))
         (then
-          (local.set $exp (i32.sub (i32.const 0) (local.get $exp)))
+          (call $json._big_set (local.get $r) (local.get $f) (i32.const 2))
+          (call $json._big_set (local.get $s) (i64.const 1) (i32.sub (i32.const 2) (local.get $e)))
+          (call $json._big_set (local.get $mp) (i64.const 2) (i32.const 0))
+          (call $json._big_set (local.get $mm) (i64.const 1) (i32.const 0))
+        )
+        (else
+          (call $json._big_set (local.get $r) (local.get $f) (i32.const 1))
+          (call $json._big_set (local.get $s) (i64.const 1) (i32.sub (i32.const 1) (local.get $e)))
+          (call $json._big_set (local.get $mp) (i64.const 1) (i32.const 0))
+          (call $json._big_set (local.get $mm) (i64.const 1) (i32.const 0))
         )
       )
     )
   )
 
-  (if (i32.gt_s (local.get $exp) (i32.const 308))
+  ;; 小数点の位置 k の見積もり（floor(log2 x) * log10(2) の切り上げは大きすぎることがない）
+  (local.set $k
+    (i32.trunc_f64_s
+      (f64.ceil
+        (f64.mul
+          (f64.convert_i32_s
+            (i32.sub
+              (i32.add (local.get $e) (i32.sub (i32.const 64) (i32.wrap_i64 (i64.clz (local.get $f)))))
+              (i32.const 1)))
+          (f64.const 0.30102999566398114)))))
+  (if (i32.ge_s (local.get $k) (i32.const 0))
     (then
-      (local.set $val (f64.const inf))
+      (call $json._big_mul_pow10 (local.get $s) (local.get $k))
     )
     (else
-      (if (i32.lt_s (local.get $exp) (i32.const -324))
+      (call $json._big_mul_pow10 (local.get $r) (i32.sub (i32.const 0) (local.get $k)))
+      (call $json._big_mul_pow10 (local.get $mp) (i32.sub (i32.const 0) (local.get $k)))
+      (call $json._big_mul_pow10 (local.get $mm) (i32.sub (i32.const 0) (local.get $k)))
+    )
+  )
+  (block $fix_done
+    (loop $fix
+      (call $json._big_add (local.get $tmp) (local.get $r) (local.get $mp))
+      (local.set $c (call $json._big_cmp (local.get $tmp) (local.get $s)))
+      (br_if $fix_done
+        (i32.eqz
+          (select
+            (i32.ge_s (local.get $c) (i32.const 0))
+            (i32.gt_s (local.get $c) (i32.const 0))
+            (local.get $even))))
+      (call $json._big_mul_small (local.get $s) (i32.const 10))
+      (local.set $k (i32.add (local.get $k) (i32.const 1)))
+      (br $fix)
+    )
+  )
+
+  (local.set $buf (call $prelude._alloc (i32.const 24)))
+  (block $gen_done
+    (loop $gen
+      (call $json._big_mul_small (local.get $r) (i32.const 10))
+      (call $json._big_mul_small (local.get $mp) (i32.const 10))
+      (call $json._big_mul_small (local.get $mm) (i32.const 10))
+      (local.set $d (i32.const 0))
+      (block $div_done
+        (loop $div
+          (br_if $div_done (i32.lt_s (call $json._big_cmp (local.get $r) (local.get $s)) (i32.const 0)))
+          (call $json._big_sub (local.get $r) (local.get $s))
+          (local.set $d (i32.add (local.get $d) (i32.const 1)))
+          (br $div)
+        )
+      )
+      (local.set $c (call $json._big_cmp (local.get $r) (local.get $mm)))
+      (local.set $low
+        (select
+          (i32.le_s (local.get $c) (i32.const 0))
+          (i32.lt_s (local.get $c) (i32.const 0))
+          (local.get $even)))
+      (call $json._big_add (local.get $tmp) (local.get $r) (local.get $mp))
+      (local.set $c (call $json._big_cmp (local.get $tmp) (local.get $s)))
+      (local.set $high
+        (select
+          (i32.ge_s (local.get $c) (i32.const 0))
+          (i32.gt_s (local.get $c) (i32.const 0))
+          (local.get $even)))
+      (if (i32.and (local.get $low) (local.get $high))
         (then
-          (local.set $val (f64.const 0))
+          ;; どちらに丸めても戻るときは近い方、同じ近さなら偶数
+          (call $json._big_add (local.get $tmp) (local.get $r) (local.get $r))
+          (local.set $c (call $json._big_cmp (local.get $tmp) (local.get $s)))
+          (if (i32.or
+                (i32.gt_s (local.get $c) (i32.const 0))
+                (i32.and (i32.eqz (local.get $c)) (i32.and (local.get $d) (i32.const 1))))
+            (then
+              (local.set $d (i32.add (local.get $d) (i32.const 1)))
+            )
+          )
         )
         (else
-          (if (i32.gt_s (local.get $exp) (i32.const 0))
+          (if (local.get $high)
             (then
-              (local.set $k (i32.const 0))
-              (block $pow_pos_done
-                (loop $pow_pos
-                  (br_if $pow_pos_done
-                    (i32.ge_u (local.get $k) (local.get $exp)))
-                  (local.set $val
-                    (f64.mul (local.get $val) (f64.const 10)))
-                  (local.set $k (i32.add (local.get $k) (i32.const 1)))
-                  (br $pow_pos)
-                )
-              )
-            )
-            (else
-              (if (i32.lt_s (local.get $exp) (i32.const 0))
-                (then
-                  (local.set $k (i32.const 0))
-                  (block $pow_neg_done
-                    (loop $pow_neg
-                      (br_if $pow_neg_done
-                        (i32.ge_u
-                          (local.get $k)
-                          (i32.sub (i32.const 0) (local.get $exp))))
-                      (local.set $val
-                        (f64.div (local.get $val) (f64.const 10)))
-                      (local.set $k (i32.add (local.get $k) (i32.const 1)))
-                      (br $pow_neg)
-                    )
-                  )
-                )
-              )
+              (local.set $d (i32.add (local.get $d) (i32.const 1)))
             )
           )
         )
       )
+      (i32.store8 (i32.add (local.get $buf) (local.get $len)) (i32.add (i32.const 48) (local.get $d)))
+      (local.set $len (i32.add (local.get $len) (i32.const 1)))
+      (br_if $gen_done (i32.or (local.get $low) (local.get $high)))
+      (br $gen)
     )
   )
 
-  (if (local.get $neg)
-    (then
-      (local.set $val (f64.neg (local.get $val)))
-    )
-  )
-  (local.get $val)
+  (call $json._write_digits_es (local.get $buf) (local.get $len) (local.get $k))
 )
 
-(func $json._str_cmp (param $a anyref) (param $b anyref) (result i32)
-  (local $aptr i32)
-  (local $bptr i32)
-  (local $alen i32)
-  (local $blen i32)
-  (local $min i32)
+;; 桁 d[0..len) と小数点の位置 point（値 = 0.d × 10^point）を ECMAScript の規則で書く
+(func $json._write_digits_es (param $buf i32) (param $len i32) (param $point i32)
   (local $i i32)
-  (local $ac i32)
-  (local $bc i32)
-
-  (local.set $aptr (call $prelude._string_ptr (local.get $a)))
-  (local.set $bptr (call $prelude._string_ptr (local.get $b)))
-  (local.set $alen (call $prelude._string_bytelen (local.get $a)))
-  (local.set $blen (call $prelude._string_bytelen (local.get $b)))
-  (local.set $min (local.get $alen))
-  (if (i32.gt_u (local.get $min) (local.get $blen))
-    (then
-      (local.set $min (local.get $blen))
-    )
-  )
-  (local.set $i (i32.const 0))
+  (local $exp i32)
 
-  (block $done
-    (loop $loop
-      (br_if $done (i32.ge_u (local.get $i) (local.get $min)))
-      (local.set $ac (i32.load8_u (i32.add (local.get $aptr) (local.get $i))))
-      (local.set $bc (i32.load8_u (i32.add (local.get $bptr) (local.get $i))))
-      (if (i32.lt_u (local.get $ac) (local.get $bc))
-        (then
-          (return (i32.const -1))
+  (if (i32.and
+        (i32.le_s (local.get $len) (local.get $point))
+        (i32.le_s (local.get $point) (i32.const 21)))
+    (then
+      (call $json._append_bytes (local.get $buf) (local.get $len))
+      (local.set $i (local.get $len))
+      (block $zeros_done
+        (loop $zeros
+          (br_if $zeros_done (i32.ge_s (local.get $i) (local.get $point)))
+          (call $json._append_byte (i32.const 48))
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $zeros)
         )
       )
-      (if (i32.gt_u (local.get $ac) (local.get $bc))
-        (then
-          (return (i32.const 1))
+      (return)
+    )
+  )
+  (if (i32.and
+        (i32.gt_s (local.get $point) (i32.const 0))
+        (i32.le_s (local.get $point) (i32.const 21)))
+    (then
+      (call $json._append_bytes (local.get $buf) (local.get $point))
+      (call $json._append_byte (i32.const 46)) ;; .
+      (call $json._append_bytes
+        (i32.add (local.get $buf) (local.get $point))
+        (i32.sub (local.get $len) (local.get $point)))
+      (return)
+    )
+  )
+  (if (i32.and
+        (i32.gt_s (local.get $point) (i32.const -6))
+        (i32.le_s (local.get $point) (i32.const 0)))
+    (then
+      (call $json._append_byte (i32.const 48))
+      (call $json._append_byte (i32.const 46))
+      (local.set $i (local.get $point))
+      (block $lead_done
+        (loop $lead
+          (br_if $lead_done (i32.ge_s (local.get $i) (i32.const 0)))
+          (call $json._append_byte (i32.const 48))
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $lead)
         )
       )
-      (local.set $i (i32.add (local.get $i) (i32.const 1)))
-      (br $loop)
+      (call $json._append_bytes (local.get $buf) (local.get $len))
+      (return)
     )
   )
-
-  (if (i32.lt_u (local.get $alen) (local.get $blen))
+  (call $json._append_byte (i32.load8_u (local.get $buf)))
+  (if (i32.gt_u (local.get $len) (i32.const 1))
     (then
-      (return (i32.const -1))
+      (call $json._append_byte (i32.const 46))
+      (call $json._append_bytes
+        (i32.add (local.get $buf) (i32.const 1))
+        (i32.sub (local.get $len) (i32.const 1)))
     )
   )
-  (if (i32.gt_u (local.get $alen) (local.get $blen))
+  (call $json._append_byte (i32.const 101)) ;; e
+  (local.set $exp (i32.sub (local.get $point) (i32.const 1)))
+  (if (i32.lt_s (local.get $exp) (i32.const 0))
     (then
-      (return (i32.const 1))
+      (call $json._append_byte (i32.const 45))
+      (local.set $exp (i32.sub (i32.const 0) (local.get $exp)))
+    )
+    (else
+      (call $json._append_byte (i32.const 43))
     )
   )
-  (i32.const 0)
+  (call $json._append_i64 (i64.extend_i32_s (local.get $exp)))
 )
 
-(func $json._obj_has_key (param $obj anyref) (param $key anyref) (result i32)
-  (local $keys anyref)
-  (local $len i32)
-  (local $i i32)
-  (local $k anyref)
+(func $json._parse_reset (param $ptr i32) (param $len i32)
+  (global.set $json_parse_ptr (local.get $ptr))
+  (global.set $json_parse_end (i32.add (local.get $ptr) (local.get $len)))
+  (global.set $json_parse_err (i32.const 0))
+  (global.set $json_parse_err_msg (ref.null any))
+)
 
-  (if (i32.ne (call $prelude.val_kind (local.get $obj)) (i32.const 4))
+(func $json._parse_set_error (param $msg anyref)
+  (if (i32.eqz (global.get $json_parse_err))
     (then
-      (return (i32.const 0))
+      (global.set $json_parse_err (i32.const 1))
+      (global.set $json_parse_err_msg (local.get $msg))
     )
   )
+)
 
-  (local.set $keys (call $prelude.obj_keys (local.get $obj)))
-  (local.set $len (call $prelude.arr_len (local.get $keys)))
-  (local.set $i (i32.const 0))
+(func $json._parse_fail_invalid
+  (call $json._parse_set_error (call $json._msg_invalid_json))
+)
 
-  (block $done
-    (loop $loop
-      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
-      (local.set $k (call $prelude.arr_get (local.get $keys) (local.get $i)))
-      (if (call $prelude.str_eq (local.get $k) (local.get $key))
-        (then
-          (return (i32.const 1))
-        )
-      )
-      (local.set $i (i32.add (local.get $i) (i32.const 1)))
-      (br $loop)
+(func $json._is_digit (param $c i32) (result i32)
+  (i32.and
+    (i32.ge_u (local.get $c) (i32.const 48))
+    (i32.le_u (local.get $c) (i32.const 57)))
+)
+
+(func $json._hex_nibble (param $c i32) (result i32)
+  (if (i32.and
+        (i32.ge_u (local.get $c) (i32.const 48))
+        (i32.le_u (local.get $c) (i32.const 57)))
+    (then
+      (return (i32.sub (local.get $c) (i32.const 48)))
     )
   )
-  (i32.const 0)
+  (if (i32.and
+        (i32.ge_u (local.get $c) (i32.const 65))
+        (i32.le_u (local.get $c) (i32.const 70)))
+    (then
+      (return (i32.sub (local.get $c) (i32.const 55)))
+    )
+  )
+  (if (i32.and
+        (i32.ge_u (local.get $c) (i32.const 97))
+        (i32.le_u (local.get $c) (i32.const 102)))
+    (then
+      (return (i32.sub (local.get $c) (i32.const 87)))
+    )
+  )
+  (i32.const -1)
 )
 
-(func $json._arr_find_string (param $arr anyref) (param $count i32) (param $key anyref) (result i32)
+(func $json._parse_hex4 (result i32)
+  (local $n i32)
   (local $i i32)
-  (local $k anyref)
+  (local $c i32)
+  (local $d i32)
+
+  (local.set $n (i32.const 0))
   (local.set $i (i32.const 0))
+
   (block $done
     (loop $loop
-      (br_if $done (i32.ge_u (local.get $i) (local.get $count)))
-      (local.set $k (call $prelude.arr_get (local.get $arr) (local.get $i)))
-      (if (call $prelude.str_eq (local.get $k) (local.get $key))
+      (br_if $done (i32.ge_u (local.get $i) (i32.const 4)))
+      (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
         (then
-          (return (local.get $i))
+          (return (i32.const -1))
+        )
+      )
+
+      (local.set $c (i32.load8_u (global.get $json_parse_ptr)))
+      (global.set $json_parse_ptr
+        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+      (local.set $d (call $json._hex_nibble (local.get $c)))
+      (if (i32.lt_s (local.get $d) (i32.const 0))
+        (then
+          (return (i32.const -1))
         )
       )
+
+      (local.set $n
+        (i32.add
+          (i32.shl (local.get $n) (i32.const 4))
+          (local.get $d)))
       (local.set $i (i32.add (local.get $i) (i32.const 1)))
       (br $loop)
     )
   )
-  (i32.const -1)
-)
 
-(func $json._decode_reset
-  (global.set $json_decode_err (i32.const 0))
-  (global.set $json_decode_err_msg (ref.null any))
+  (local.get $n)
 )
 
-(func $json._decode_set_error (param $msg anyref)
-  (if (i32.eqz (global.get $json_decode_err))
+(func $json._append_utf8 (param $buf i32) (param $len i32) (param $cp i32) (result i32)
+  (if (i32.or
+        (i32.lt_s (local.get $cp) (i32.const 0))
+        (i32.gt_u (local.get $cp) (i32.const 1114111)))
     (then
-      (global.set $json_decode_err (i32.const 1))
-      (global.set $json_decode_err_msg (local.get $msg))
+      (return (i32.const -1))
     )
   )
-)
-
-(func $json._decode_force_error (param $msg anyref)
-  (global.set $json_decode_err (i32.const 1))
-  (global.set $json_decode_err_msg (local.get $msg))
-)
-
-(func $json._decode_error_at (param $path anyref) (param $suffix anyref)
-  (local $head anyref)
-  (local.set $head
-    (call $prelude.str_concat
-      (local.get $path)
-      (call $json._str_colon_space)))
-  (call $json._decode_set_error
-    (call $prelude.str_concat (local.get $head) (local.get $suffix)))
-)
-
-(func $json._decode_invalid_schema_at (param $path anyref)
-  (call $json._decode_error_at (local.get $path) (call $json._msg_invalid_schema))
-)
-
-(func $json._path_field (param $path anyref) (param $name anyref) (result anyref)
-  (local $head anyref)
-  (local.set $head
-    (call $prelude.str_concat
-      (local.get $path)
-      (call $json._str_dot)))
-  (call $prelude.str_concat (local.get $head) (local.get $name))
-)
-
-(func $json._path_index (param $path anyref) (param $index i32) (result anyref)
-  (local $head anyref)
-  (local $mid anyref)
-  (local $idx anyref)
-  (local.set $head
-    (call $prelude.str_concat
-      (local.get $path)
-      (call $json._str_lbr)))
-  (local.set $idx
-    (call $prelude._i64_to_string
-      (i64.extend_i32_u (local.get $index))))
-  (local.set $mid (call $prelude.str_concat (local.get $head) (local.get $idx)))
-  (call $prelude.str_concat (local.get $mid) (call $json._str_rbr))
-)
 
-(func $json._is_error_object (param $value anyref) (result i32)
-  (local $t anyref)
-  (if (i32.ne (call $prelude.val_kind (local.get $value)) (i32.const 4))
+  (if (i32.le_u (local.get $cp) (i32.const 127))
     (then
-      (return (i32.const 0))
+      (i32.store8
+        (i32.add (local.get $buf) (local.get $len))
+        (local.get $cp))
+      (return (i32.add (local.get $len) (i32.const 1)))
     )
   )
-  (if (i32.eqz (call $json._obj_has_key (local.get $value) (call $json._str_type)))
+
+  (if (i32.le_u (local.get $cp) (i32.const 2047))
+    (then
+      (i32.store8
+        (i32.add (local.get $buf) (local.get $len))
+        (i32.or
+          (i32.const 192)
+          (i32.shr_u (local.get $cp) (i32.const 6))))
+      (i32.store8
+        (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 1)))
+        (i32.or
+          (i32.const 128)
+          (i32.and (local.get $cp) (i32.const 63))))
+      (return (i32.add (local.get $len) (i32.const 2)))
+    )
+  )
+
+  (if (i32.le_u (local.get $cp) (i32.const 65535))
+    (then
+      (i32.store8
+        (i32.add (local.get $buf) (local.get $len))
+        (i32.or
+          (i32.const 224)
+          (i32.shr_u (local.get $cp) (i32.const 12))))
+      (i32.store8
+        (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 1)))
+        (i32.or
+          (i32.const 128)
+          (i32.and
+            (i32.shr_u (local.get $cp) (i32.const 6))
+            (i32.const 63))))
+      (i32.store8
+        (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 2)))
+        (i32.or
+          (i32.const 128)
+          (i32.and (local.get $cp) (i32.const 63))))
+      (return (i32.add (local.get $len) (i32.const 3)))
+    )
+  )
+
+  (i32.store8
+    (i32.add (local.get $buf) (local.get $len))
+    (i32.or
+      (i32.const 240)
+      (i32.shr_u (local.get $cp) (i32.const 18))))
+  (i32.store8
+    (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 1)))
+    (i32.or
+      (i32.const 128)
+      (i32.and
+        (i32.shr_u (local.get $cp) (i32.const 12))
+        (i32.const 63))))
+  (i32.store8
+    (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 2)))
+    (i32.or
+      (i32.const 128)
+      (i32.and
+        (i32.shr_u (local.get $cp) (i32.const 6))
+        (i32.const 63))))
+  (i32.store8
+    (i32.add (local.get $buf) (i32.add (local.get $len) (i32.const 3)))
+    (i32.or
+      (i32.const 128)
+      (i32.and (local.get $cp) (i32.const 63))))
+  (i32.add (local.get $len) (i32.const 4))
+)
+
+(func $json._parse_skip_ws
+  (local $c i32)
+  (block $done
+    (loop $loop
+      (br_if $done
+        (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end)))
+      (local.set $c (i32.load8_u (global.get $json_parse_ptr)))
+      (if (i32.or
+            (i32.or
+              (i32.eq (local.get $c) (i32.const 32))
+              (i32.eq (local.get $c) (i32.const 9)))
+            (i32.or
+              (i32.eq (local.get $c) (i32.const 10))
+              (i32.eq (local.get $c) (i32.const 13))))
+        (then
+          (global.set $json_parse_ptr
+            (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+          (br $loop)
+        )
+      )
+      (br $done)
+    )
+  )
+)
+
+(func $json._parse_f64_token (param $start i32) (param $len i32) (result f64)
+  (local $idx i32)
+  (local $end i32)
+  (local $neg i32)
+  (local $digit i32)
+  (local $c i32)
+  (local $val f64)
+  (local $factor f64)
+  (local $exp i32)
+  (local $exp_neg i32)
+  (local $k i32)
+
+  (local.set $idx (local.get $start))
+  (local.set $end (i32.add (local.get $start) (local.get $len)))
+  (local.set $neg (i32.const 0))
+  (local.set $val (f64.const 0))
+  (local.set $exp (i32.const 0))
+  (local.set $exp_neg (i32.const 0))
+
+  (if (i32.and
+        (i32.lt_u (local.get $idx) (local.get $end))
+        (i32.eq
+          (i32.load8_u (local.get $idx))
+          (i32.const 45)))
+    (then
+      (local.set $neg (i32.const 1))
+      (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
+    )
+  )
+
+  (block $int_done
+    (loop $int_loop
+      (br_if $int_done (i32.ge_u (local.get $idx) (local.get $end)))
+      (local.set $c (i32.load8_u (local.get $idx)))
+      (br_if $int_done (i32.eqz (call $json._is_digit (local.get $c))))
+      (local.set $digit (i32.sub (local.get $c) (i32.const 48)))
+      (local.set $val
+        (f64.add
+          (f64.mul (local.get $val) (f64.const 10))
+          (f64.convert_i32_u (local.get $digit))))
+      (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
+      (br $int_loop)
+    )
+  )
+
+  (if (i32.and
+        (i32.lt_u (local.get $idx) (local.get $end))
+        (i32.eq
+          (i32.load8_u (local.get $idx))
+          (i32.const 46)))
+    (then
+      (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
+      (local.set $factor (f64.const 0.1))
+      (block $frac_done
+        (loop $frac_loop
+          (br_if $frac_done (i32.ge_u (local.get $idx) (local.get $end)))
+          (local.set $c (i32.load8_u (local.get $idx)))
+          (br_if $frac_done (i32.eqz (call $json._is_digit (local.get $c))))
+          (local.set $digit (i32.sub (local.get $c) (i32.const 48)))
+          (local.set $val
+            (f64.add
+              (local.get $val)
+              (f64.mul
+                (f64.convert_i32_u (local.get $digit))
+                (local.get $factor))))
+          (local.set $factor
+            (f64.mul (local.get $factor) (f64.const 0.1)))
+          (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
+          (br $frac_loop)
+        )
+      )
+    )
+  )
+
+  (if (i32.and
+        (i32.lt_u (local.get $idx) (local.get $end))
+        (i32.or
+          (i32.eq
+            (i32.load8_u (local.get $idx))
+            (i32.const 101))
+          (i32.eq
+            (i32.load8_u (local.get $idx))
+            (i32.const 69))))
+    (then
+      (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
+      (if (i32.and
+            (i32.lt_u (local.get $idx) (local.get $end))
+            (i32.eq
+              (i32.load8_u (local.get $idx))
+              (i32.const 43)))
+        (then
+          (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
+        )
+      )
+      (if (i32.and
+            (i32.lt_u (local.get $idx) (local.get $end))
+            (i32.eq
+              (i32.load8_u (local.get $idx))
+              (i32.const 45)))
+        (then
+          (local.set $exp_neg (i32.const 1))
+          (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
+        )
+      )
+      (local.set $exp (i32.const 0))
+      (block $exp_done
+        (loop $exp_loop
+          (br_if $exp_done (i32.ge_u (local.get $idx) (local.get $end)))
+          (local.set $c (i32.load8_u (local.get $idx)))
+          (br_if $exp_done (i32.eqz (call $json._is_digit (local.get $c))))
+          (local.set $exp
+            (i32.add
+              (i32.mul (local.get $exp) (i32.const 10))
+              (i32.sub (local.get $c) (i32.const 48))))
+          (local.set $idx (i32.add (local.get $idx) (i32.const 1)))
+          (br $exp_loop)
+        )
+      )
+      (if (local.get $exp_neg)
+        (then
+          (local.set $exp (i32.sub (i32.const 0) (local.get $exp)))
+        )
+      )
+    )
+  )
+
+  (if (i32.gt_s (local.get $exp) (i32.const 308))
+    (then
+      (local.set $val (f64.const inf))
+    )
+    (else
+      (if (i32.lt_s (local.get $exp) (i32.const -324))
+        (then
+          (local.set $val (f64.const 0))
+        )
+        (else
+          (if (i32.gt_s (local.get $exp) (i32.const 0))
+            (then
+              (local.set $k (i32.const 0))
+              (block $pow_pos_done
+                (loop $pow_pos
+                  (br_if $pow_pos_done
+                    (i32.ge_u (local.get $k) (local.get $exp)))
+                  (local.set $val
+                    (f64.mul (local.get $val) (f64.const 10)))
+                  (local.set $k (i32.add (local.get $k) (i32.const 1)))
+                  (br $pow_pos)
+                )
+              )
+            )
+            (else
+              (if (i32.lt_s (local.get $exp) (i32.const 0))
+                (then
+                  (local.set $k (i32.const 0))
+                  (block $pow_neg_done
+                    (loop $pow_neg
+                      (br_if $pow_neg_done
+                        (i32.ge_u
+                          (local.get $k)
+                          (i32.sub (i32.const 0) (local.get $exp))))
+                      (local.set $val
+                        (f64.div (local.get $val) (f64.const 10)))
+                      (local.set $k (i32.add (local.get $k) (i32.const 1)))
+                      (br $pow_neg)
+                    )
+                  )
+                )
+              )
+            )
+          )
+        )
+      )
+    )
+  )
+
+  (if (local.get $neg)
+    (then
+      (local.set $val (f64.neg (local.get $val)))
+    )
+  )
+  (local.get $val)
+)
+
+(func $json._str_cmp (param $a anyref) (param $b anyref) (result i32)
+  (local $aptr i32)
+  (local $bptr i32)
+  (local $alen i32)
+  (local $blen i32)
+  (local $min i32)
+  (local $i i32)
+  (local $ac i32)
+  (local $bc i32)
+
+  (local.set $aptr (call $prelude._string_ptr (local.get $a)))
+  (local.set $bptr (call $prelude._string_ptr (local.get $b)))
+  (local.set $alen (call $prelude._string_bytelen (local.get $a)))
+  (local.set $blen (call $prelude._string_bytelen (local.get $b)))
+  (local.set $min (local.get $alen))
+  (if (i32.gt_u (local.get $min) (local.get $blen))
+    (then
+      (local.set $min (local.get $blen))
+    )
+  )
+  (local.set $i (i32.const 0))
+
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $min)))
+      (local.set $ac (i32.load8_u (i32.add (local.get $aptr) (local.get $i))))
+      (local.set $bc (i32.load8_u (i32.add (local.get $bptr) (local.get $i))))
+      (if (i32.lt_u (local.get $ac) (local.get $bc))
+        (then
+          (return (i32.const -1))
+        )
+      )
+      (if (i32.gt_u (local.get $ac) (local.get $bc))
+        (then
+          (return (i32.const 1))
+        )
+      )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+
+  (if (i32.lt_u (local.get $alen) (local.get $blen))
+    (then
+      (return (i32.const -1))
+    )
+  )
+  (if (i32.gt_u (local.get $alen) (local.get $blen))
+    (then
+      (return (i32.const 1))
+    )
+  )
+  (i32.const 0)
+)
+
+(func $json._obj_has_key (param $obj anyref) (param $key anyref) (result i32)
+  (local $keys anyref)
+  (local $len i32)
+  (local $i i32)
+  (local $k anyref)
+
+  (if (i32.ne (call $prelude.val_kind (local.get $obj)) (i32.const 4))
+    (then
+      (return (i32.const 0))
+    )
+  )
+
+  (local.set $keys (call $prelude.obj_keys (local.get $obj)))
+  (local.set $len (call $prelude.arr_len (local.get $keys)))
+  (local.set $i (i32.const 0))
+
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+      (local.set $k (call $prelude.arr_get (local.get $keys) (local.get $i)))
+      (if (call $prelude.str_eq (local.get $k) (local.get $key))
+        (then
+          (return (i32.const 1))
+        )
+      )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (i32.const 0)
+)
+
+(func $json._arr_find_string (param $arr anyref) (param $count i32) (param $key anyref) (result i32)
+  (local $i i32)
+  (local $k anyref)
+  (local.set $i (i32.const 0))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $count)))
+      (local.set $k (call $prelude.arr_get (local.get $arr) (local.get $i)))
+      (if (call $prelude.str_eq (local.get $k) (local.get $key))
+        (then
+          (return (local.get $i))
+        )
+      )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (i32.const -1)
+)
+
+(func $json._decode_reset
+  (global.set $json_decode_err (i32.const 0))
+  (global.set $json_decode_err_msg (ref.null any))
+  (global.set $json_decode_err_path (ref.null any))
+  (global.set $json_decode_err_reason (ref.null any))
+)
+
+(func $json._decode_set_error (param $msg anyref)
+  (if (i32.eqz (global.get $json_decode_err))
+    (then
+      (global.set $json_decode_err (i32.const 1))
+      (global.set $json_decode_err_msg (local.get $msg))
+    )
+  )
+)
+
+(func $json._decode_force_error (param $msg anyref)
+  (global.set $json_decode_err (i32.const 1))
+  (global.set $json_decode_err_msg (local.get $msg))
+)
+
+(func $json._decode_error_at (param $path anyref) (param $suffix anyref)
+  (local $head anyref)
+  (if (global.get $json_decode_collect)
+    (then
+      (call $json._decode_push_issue (local.get $path) (local.get $suffix))
+      (global.set $json_decode_err (i32.const 1))
+      (return)
+    )
+  )
+  (if (i32.eqz (global.get $json_decode_err))
+    (then
+      (global.set $json_decode_err_path (local.get $path))
+      (global.set $json_decode_err_reason (local.get $suffix))
+    )
+  )
+  (local.set $head
+    (call $prelude.str_concat
+      (local.get $path)
+      (call $json._str_colon_space)))
+  (call $json._decode_set_error
+    (call $prelude.str_concat (local.get $head) (local.get $suffix)))
+)
+
+;; collect モードなら子のエラーを記録済みとして続行できるようにし、1 を返す。
+;; それ以外は 0 を返す（呼び出し側は最初のエラーで戻る）。
+(func $json._decode_continue (result i32)
+  (if (i32.eqz (global.get $json_decode_collect))
+    (then
+      (return (i32.const 0))
+    )
+  )
+  (global.set $json_decode_err (i32.const 0))
+  (i32.const 1)
+)
+
+(func $json._decode_push_issue (param $path anyref) (param $message anyref)
+  (local $issue anyref)
+  (local $issues anyref)
+  (local $cap i32)
+  (local $grown anyref)
+  (local $i i32)
+
+  (local.set $issue (call $prelude.obj_new (i32.const 2)))
+  (call $prelude.obj_set (local.get $issue) (call $json._str_message) (local.get $message))
+  (call $prelude.obj_set (local.get $issue) (call $json._k_path) (local.get $path))
+
+  (local.set $issues (global.get $json_decode_issues))
+  (local.set $cap (call $prelude.arr_len (local.get $issues)))
+  (if (i32.ge_u (global.get $json_decode_issue_count) (local.get $cap))
+    (then
+      (local.set $grown
+        (call $prelude.arr_new
+          (i32.add (i32.mul (local.get $cap) (i32.const 2)) (i32.const 4))))
+      (local.set $i (i32.const 0))
+      (block $done
+        (loop $copy
+          (br_if $done (i32.ge_u (local.get $i) (global.get $json_decode_issue_count)))
+          (call $prelude.arr_set
+            (local.get $grown)
+            (local.get $i)
+            (call $prelude.arr_get (local.get $issues) (local.get $i)))
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $copy)
+        )
+      )
+      (local.set $issues (local.get $grown))
+      (global.set $json_decode_issues (local.get $grown))
+    )
+  )
+  (call $prelude.arr_set
+    (local.get $issues)
+    (global.get $json_decode_issue_count)
+    (local.get $issue))
+  (global.set $json_decode_issue_count
+    (i32.add (global.get $json_decode_issue_count) (i32.const 1)))
+)
+
+;; 集めた issue から DecodeErrors（error に errors: { path, message }[] を足したもの）を作る。
+;; message は "path: message" を "; " でつないだもの。
+(func $json._decode_errors_value (result anyref)
+  (local $count i32)
+  (local $errors anyref)
+  (local $issue anyref)
+  (local $message anyref)
+  (local $line anyref)
+  (local $err anyref)
+  (local $i i32)
+
+  (local.set $count (global.get $json_decode_issue_count))
+  (local.set $errors (call $prelude.arr_new (local.get $count)))
+  (local.set $message (call $json._str_empty))
+  (local.set $i (i32.const 0))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $count)))
+      (local.set $issue
+        (call $prelude.arr_get (global.get $json_decode_issues) (local.get $i)))
+      (call $prelude.arr_set (local.get $errors) (local.get $i) (local.get $issue))
+      (if (i32.gt_u (local.get $i) (i32.const 0))
+        (then
+          (local.set $message
+            (call $prelude.str_concat (local.get $message) (call $json._str_semicolon_space)))
+        )
+      )
+      (local.set $line
+        (call $prelude.str_concat
+          (call $prelude.obj_get (local.get $issue) (call $json._k_path))
+          (call $json._str_colon_space)))
+      (local.set $line
+        (call $prelude.str_concat
+          (local.get $line)
+          (call $prelude.obj_get (local.get $issue) (call $json._str_message))))
+      (local.set $message (call $prelude.str_concat (local.get $message) (local.get $line)))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (global.set $json_decode_issues (ref.null any))
+  (global.set $json_decode_issue_count (i32.const 0))
+
+  (local.set $err (call $json._error_from_msg (local.get $message)))
+  (call $prelude.obj_set (local.get $err) (call $json._k_errors) (local.get $errors))
+  (local.get $err)
+)
+
+(func $json._decode_invalid_schema_at (param $path anyref)
+  (call $json._decode_error_at (local.get $path) (call $json._msg_invalid_schema))
+)
+
+(func $json._path_field (param $path anyref) (param $name anyref) (result anyref)
+  (local $head anyref)
+  (local.set $head
+    (call $prelude.str_concat
+      (local.get $path)
+      (call $json._str_dot)))
+  (call $prelude.str_concat (local.get $head) (local.get $name))
+)
+
+(func $json._path_index (param $path anyref) (param $index i32) (result anyref)
+  (local $head anyref)
+  (local $mid anyref)
+  (local $idx anyref)
+  (local.set $head
+    (call $prelude.str_concat
+      (local.get $path)
+      (call $json._str_lbr)))
+  (local.set $idx
+    (call $prelude._i64_to_string
+      (i64.extend_i32_u (local.get $index))))
+  (local.set $mid (call $prelude.str_concat (local.get $head) (local.get $idx)))
+  (call $prelude.str_concat (local.get $mid) (call $json._str_rbr))
+)
+
+(func $json._is_error_object (param $value anyref) (result i32)
+  (local $t anyref)
+  (if (i32.ne (call $prelude.val_kind (local.get $value)) (i32.const 4))
+    (then
+      (return (i32.const 0))
+    )
+  )
+  (if (i32.eqz (call $json._obj_has_key (local.get $value) (call $json._str_type)))
+    (then
+      (return (i32.const 0))
+    )
+  )
+  (local.set $t (call $prelude.obj_get (local.get $value) (call $json._str_type)))
+  (if (i32.ne (call $prelude.val_kind (local.get $t)) (i32.const 3))
+    (then
+      (return (i32.const 0))
+    )
+  )
+  (if (call $prelude.str_eq (local.get $t) (call $json._str_error))
+    (then
+      (return (i32.const 1))
+    )
+  )
+  (i32.const 0)
+)
+
+(func $json._schema_kind_code (param $kind anyref) (result i32)
+  (local $ptr i32)
+  (local $len i32)
+  (local $b0 i32)
+  (local $b1 i32)
+  (local $b2 i32)
+  (local $b3 i32)
+  (local $b4 i32)
+  (local $b5 i32)
+  (local $b6 i32)
+  (local $b7 i32)
+  (local $b8 i32)
+
+  (if (i32.ne (call $prelude.val_kind (local.get $kind)) (i32.const 3))
+    (then
+      (return (i32.const 0))
+    )
+  )
+
+  (local.set $ptr (call $prelude._string_ptr (local.get $kind)))
+  (local.set $len (call $prelude._string_bytelen (local.get $kind)))
+  (if (i32.eqz (local.get $len))
     (then
       (return (i32.const 0))
     )
   )
-  (local.set $t (call $prelude.obj_get (local.get $value) (call $json._str_type)))
-  (if (i32.ne (call $prelude.val_kind (local.get $t)) (i32.const 3))
+
+  (local.set $b0 (i32.load8_u (local.get $ptr)))
+  (if (i32.ge_u (local.get $len) (i32.const 2))
+    (then (local.set $b1 (i32.load8_u (i32.add (local.get $ptr) (i32.const 1)))))
+  )
+  (if (i32.ge_u (local.get $len) (i32.const 3))
+    (then (local.set $b2 (i32.load8_u (i32.add (local.get $ptr) (i32.const 2)))))
+  )
+  (if (i32.ge_u (local.get $len) (i32.const 4))
+    (then (local.set $b3 (i32.load8_u (i32.add (local.get $ptr) (i32.const 3)))))
+  )
+  (if (i32.ge_u (local.get $len) (i32.const 5))
+    (then (local.set $b4 (i32.load8_u (i32.add (local.get $ptr) (i32.const 4)))))
+  )
+  (if (i32.ge_u (local.get $len) (i32.const 6))
+    (then (local.set $b5 (i32.load8_u (i32.add (local.get $ptr) (i32.const 5)))))
+  )
+  (if (i32.ge_u (local.get $len) (i32.const 7))
+    (then (local.set $b6 (i32.load8_u (i32.add (local.get $ptr) (i32.const 6)))))
+  )
+  (if (i32.ge_u (local.get $len) (i32.const 8))
+    (then (local.set $b7 (i32.load8_u (i32.add (local.get $ptr) (i32.const 7)))))
+  )
+  (if (i32.ge_u (local.get $len) (i32.const 9))
+    (then (local.set $b8 (i32.load8_u (i32.add (local.get $ptr) (i32.const 8)))))
+  )
+
+  (if (i32.eq (local.get $len) (i32.const 3))
+    (then
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 105))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 54))
+              (i32.eq (local.get $b2) (i32.const 52))))
+        (then
+          (return (i32.const 6))
+        )
+      )
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 102))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 54))
+              (i32.eq (local.get $b2) (i32.const 52))))
+        (then
+          (return (i32.const 7))
+        )
+      )
+    )
+  )
+
+  (if (i32.eq (local.get $len) (i32.const 4))
+    (then
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 106))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 115))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 111))
+                (i32.eq (local.get $b3) (i32.const 110)))))
+        (then
+          (return (i32.const 1))
+        )
+      )
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 110))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 117))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 108))
+                (i32.eq (local.get $b3) (i32.const 108)))))
+        (then
+          (return (i32.const 3))
+        )
+      )
+    )
+  )
+
+  (if (i32.eq (local.get $len) (i32.const 5))
+    (then
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 97))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 114))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 114))
+                (i32.and
+                  (i32.eq (local.get $b3) (i32.const 97))
+                  (i32.eq (local.get $b4) (i32.const 121))))))
+        (then
+          (return (i32.const 8))
+        )
+      )
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 116))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 117))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 112))
+                (i32.and
+                  (i32.eq (local.get $b3) (i32.const 108))
+                  (i32.eq (local.get $b4) (i32.const 101))))))
+        (then
+          (return (i32.const 9))
+        )
+      )
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 117))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 110))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 105))
+                (i32.and
+                  (i32.eq (local.get $b3) (i32.const 111))
+                  (i32.eq (local.get $b4) (i32.const 110))))))
+        (then
+          (return (i32.const 11))
+        )
+      )
+    )
+  )
+
+  (if (i32.eq (local.get $len) (i32.const 6))
+    (then
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 115))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 116))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 114))
+                (i32.and
+                  (i32.eq (local.get $b3) (i32.const 105))
+                  (i32.and
+                    (i32.eq (local.get $b4) (i32.const 110))
+                    (i32.eq (local.get $b5) (i32.const 103)))))))
+        (then
+          (return (i32.const 4))
+        )
+      )
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 110))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 117))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 109))
+                (i32.and
+                  (i32.eq (local.get $b3) (i32.const 98))
+                  (i32.and
+                    (i32.eq (local.get $b4) (i32.const 101))
+                    (i32.eq (local.get $b5) (i32.const 114)))))))
+        (then
+          (return (i32.const 7))
+        )
+      )
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 111))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 98))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 106))
+                (i32.and
+                  (i32.eq (local.get $b3) (i32.const 101))
+                  (i32.and
+                    (i32.eq (local.get $b4) (i32.const 99))
+                    (i32.eq (local.get $b5) (i32.const 116)))))))
+        (then
+          (return (i32.const 10))
+        )
+      )
+    )
+  )
+
+  (if (i32.eq (local.get $len) (i32.const 7))
+    (then
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 98))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 111))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 111))
+                (i32.and
+                  (i32.eq (local.get $b3) (i32.const 108))
+                  (i32.and
+                    (i32.eq (local.get $b4) (i32.const 101))
+                    (i32.and
+                      (i32.eq (local.get $b5) (i32.const 97))
+                      (i32.eq (local.get $b6) (i32.const 110))))))))
+        (then
+          (return (i32.const 5))
+        )
+      )
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 105))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 110))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 116))
+                (i32.and
+                  (i32.eq (local.get $b3) (i32.const 101))
+                  (i32.and
+                    (i32.eq (local.get $b4) (i32.const 103))
+                    (i32.and
+                      (i32.eq (local.get $b5) (i32.const 101))
+                      (i32.eq (local.get $b6) (i32.const 114))))))))
+        (then
+          (return (i32.const 6))
+        )
+      )
+    )
+  )
+
+  (if (i32.eq (local.get $len) (i32.const 9))
+    (then
+      (if (i32.and
+            (i32.eq (local.get $b0) (i32.const 117))
+            (i32.and
+              (i32.eq (local.get $b1) (i32.const 110))
+              (i32.and
+                (i32.eq (local.get $b2) (i32.const 100))
+                (i32.and
+                  (i32.eq (local.get $b3) (i32.const 101))
+                  (i32.and
+                    (i32.eq (local.get $b4) (i32.const 102))
+                    (i32.and
+                      (i32.eq (local.get $b5) (i32.const 105))
+                      (i32.and
+                        (i32.eq (local.get $b6) (i32.const 110))
+                        (i32.and
+                          (i32.eq (local.get $b7) (i32.const 101))
+                          (i32.eq (local.get $b8) (i32.const 100))))))))))
+        (then
+          (return (i32.const 2))
+        )
+      )
+    )
+  )
+
+  (i32.const 0)
+)
+
+(func $json._literal_as_string (param $lit anyref) (result anyref)
+  (local $v anyref)
+  (if (i32.eqz (call $json._obj_has_key (local.get $lit) (call $json._k_value)))
+    (then
+      (return (call $json._str_empty))
+    )
+  )
+  (local.set $v (call $prelude.obj_get (local.get $lit) (call $json._k_value)))
+  (if (i32.eq (call $prelude.val_kind (local.get $v)) (i32.const 3))
+    (then
+      (return (local.get $v))
+    )
+  )
+  (call $json._str_empty)
+)
+
+(func $json._literal_as_bool (param $lit anyref) (result i32)
+  (local $v anyref)
+  (if (i32.eqz (call $json._obj_has_key (local.get $lit) (call $json._k_value)))
     (then
       (return (i32.const 0))
     )
   )
-  (if (call $prelude.str_eq (local.get $t) (call $json._str_error))
+  (local.set $v (call $prelude.obj_get (local.get $lit) (call $json._k_value)))
+  (if (i32.eq (call $prelude.val_kind (local.get $v)) (i32.const 2))
     (then
-      (return (i32.const 1))
+      (return (call $prelude.val_to_bool (local.get $v)))
     )
   )
   (i32.const 0)
 )
 
-(func $json._schema_kind_code (param $kind anyref) (result i32)
-  (local $ptr i32)
+(func $json._literal_as_f64 (param $lit anyref) (result f64)
+  (local $v anyref)
+  (local $k i32)
+  (if (i32.eqz (call $json._obj_has_key (local.get $lit) (call $json._k_value)))
+    (then
+      (return (f64.const 0))
+    )
+  )
+  (local.set $v (call $prelude.obj_get (local.get $lit) (call $json._k_value)))
+  (local.set $k (call $prelude.val_kind (local.get $v)))
+  (if (i32.eq (local.get $k) (i32.const 1))
+    (then
+      (return (call $prelude.val_to_f64 (local.get $v)))
+    )
+  )
+  (if (i32.eq (local.get $k) (i32.const 0))
+    (then
+      (return (f64.convert_i64_s (call $prelude.val_to_i64 (local.get $v))))
+    )
+  )
+  (f64.const 0)
+)
+
+(func $json._schema_allows_undefined (param $schema anyref) (result i32)
+  (local $kind anyref)
+  (local $code i32)
+  (local $union anyref)
   (local $len i32)
-  (local $b0 i32)
-  (local $b1 i32)
-  (local $b2 i32)
-  (local $b3 i32)
-  (local $b4 i32)
-  (local $b5 i32)
-  (local $b6 i32)
-  (local $b7 i32)
-  (local $b8 i32)
+  (local $i i32)
+  (local $member anyref)
 
-  (if (i32.ne (call $prelude.val_kind (local.get $kind)) (i32.const 3))
+  (if (i32.ne (call $prelude.val_kind (local.get $schema)) (i32.const 4))
     (then
       (return (i32.const 0))
     )
   )
-
-  (local.set $ptr (call $prelude._string_ptr (local.get $kind)))
-  (local.set $len (call $prelude._string_bytelen (local.get $kind)))
-  (if (i32.eqz (local.get $len))
+  (if (i32.eqz (call $json._obj_has_key (local.get $schema) (call $json._k_kind)))
     (then
       (return (i32.const 0))
     )
   )
-
-  (local.set $b0 (i32.load8_u (local.get $ptr)))
-  (if (i32.ge_u (local.get $len) (i32.const 2))
-    (then (local.set $b1 (i32.load8_u (i32.add (local.get $ptr) (i32.const 1)))))
+  (local.set $kind (call $prelude.obj_get (local.get $schema) (call $json._k_kind)))
+  (local.set $code (call $json._schema_kind_code (local.get $kind)))
+  (if (i32.eq (local.get $code) (i32.const 2))
+    (then
+      (return (i32.const 1))
+    )
   )
-  (if (i32.ge_u (local.get $len) (i32.const 3))
-    (then (local.set $b2 (i32.load8_u (i32.add (local.get $ptr) (i32.const 2)))))
+  (if (i32.ne (local.get $code) (i32.const 11))
+    (then
+      (return (i32.const 0))
+    )
   )
-  (if (i32.ge_u (local.get $len) (i32.const 4))
-    (then (local.set $b3 (i32.load8_u (i32.add (local.get $ptr) (i32.const 3)))))
+  (if (i32.eqz (call $json._obj_has_key (local.get $schema) (call $json._k_union)))
+    (then
+      (return (i32.const 0))
+    )
   )
-  (if (i32.ge_u (local.get $len) (i32.const 5))
-    (then (local.set $b4 (i32.load8_u (i32.add (local.get $ptr) (i32.const 4)))))
+  (local.set $union (call $prelude.obj_get (local.get $schema) (call $json._k_union)))
+  (if (i32.ne (call $prelude.val_kind (local.get $union)) (i32.const 5))
+    (then
+      (return (i32.const 0))
+    )
   )
-  (if (i32.ge_u (local.get $len) (i32.const 6))
-    (then (local.set $b5 (i32.load8_u (i32.add (local.get $ptr) (i32.const 5)))))
+
+  (local.set $len (call $prelude.arr_len (local.get $union)))
+  (local.set $i (i32.const 0))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+      (local.set $member (call $prelude.arr_get (local.get $union) (local.get $i)))
+      (if (call $json._schema_allows_undefined (local.get $member))
+        (then
+          (return (i32.const 1))
+        )
+      )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
   )
-  (if (i32.ge_u (local.get $len) (i32.const 7))
-    (then (local.set $b6 (i32.load8_u (i32.add (local.get $ptr) (i32.const 6)))))
+  (i32.const 0)
+)
+
+(func $json._parse_string (result anyref)
+  (local $raw_cap i32)
+  (local $out_ptr i32)
+  (local $out_len i32)
+  (local $b i32)
+  (local $esc i32)
+  (local $cp i32)
+  (local $cp2 i32)
+  (local $new_len i32)
+
+  (if (global.get $json_parse_err)
+    (then
+      (return (call $prelude.val_undefined))
+    )
   )
-  (if (i32.ge_u (local.get $len) (i32.const 8))
-    (then (local.set $b7 (i32.load8_u (i32.add (local.get $ptr) (i32.const 7)))))
+  (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+    (then
+      (call $json._parse_fail_invalid)
+      (return (call $prelude.val_undefined))
+    )
   )
-  (if (i32.ge_u (local.get $len) (i32.const 9))
-    (then (local.set $b8 (i32.load8_u (i32.add (local.get $ptr) (i32.const 8)))))
+  (if (i32.ne
+        (i32.load8_u (global.get $json_parse_ptr))
+        (i32.const 34))
+    (then
+      (call $json._parse_fail_invalid)
+      (return (call $prelude.val_undefined))
+    )
   )
 
-  (if (i32.eq (local.get $len) (i32.const 3))
+  (global.set $json_parse_ptr
+    (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+
+  (local.set $raw_cap
+    (i32.add
+      (i32.sub (global.get $json_parse_end) (global.get $json_parse_ptr))
+      (i32.const 1)))
+  (if (i32.lt_s (local.get $raw_cap) (i32.const 1))
     (then
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 105))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 54))
-              (i32.eq (local.get $b2) (i32.const 52))))
+      (local.set $raw_cap (i32.const 1))
+    )
+  )
+  (local.set $out_ptr (call $prelude._alloc (local.get $raw_cap)))
+  (local.set $out_len (i32.const 0))
+
+  (block $done
+    (loop $loop
+      (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
         (then
-          (return (i32.const 6))
+          (call $json._parse_fail_invalid)
+          (return (call $prelude.val_undefined))
         )
       )
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 102))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 54))
-              (i32.eq (local.get $b2) (i32.const 52))))
+      (local.set $b (i32.load8_u (global.get $json_parse_ptr)))
+      (global.set $json_parse_ptr
+        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+
+      (if (i32.eq (local.get $b) (i32.const 34))
         (then
-          (return (i32.const 7))
+          (return (call $prelude.str_from_utf8 (local.get $out_ptr) (local.get $out_len)))
+        )
+      )
+      (if (i32.lt_u (local.get $b) (i32.const 32))
+        (then
+          (call $json._parse_fail_invalid)
+          (return (call $prelude.val_undefined))
         )
       )
-    )
-  )
 
-  (if (i32.eq (local.get $len) (i32.const 4))
-    (then
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 106))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 115))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 111))
-                (i32.eq (local.get $b3) (i32.const 110)))))
+      (if (i32.ne (local.get $b) (i32.const 92))
         (then
-          (return (i32.const 1))
+          (i32.store8
+            (i32.add (local.get $out_ptr) (local.get $out_len))
+            (local.get $b))
+          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
+          (br $loop)
         )
       )
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 110))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 117))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 108))
-                (i32.eq (local.get $b3) (i32.const 108)))))
+
+      (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
         (then
-          (return (i32.const 3))
+          (call $json._parse_fail_invalid)
+          (return (call $prelude.val_undefined))
         )
       )
-    )
-  )
+      (local.set $esc (i32.load8_u (global.get $json_parse_ptr)))
+      (global.set $json_parse_ptr
+        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
 
-  (if (i32.eq (local.get $len) (i32.const 5))
-    (then
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 97))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 114))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 114))
-                (i32.and
-                  (i32.eq (local.get $b3) (i32.const 97))
-                  (i32.eq (local.get $b4) (i32.const 121))))))
+      (if (i32.or
+            (i32.or
+              (i32.eq (local.get $esc) (i32.const 34))
+              (i32.eq (local.get $esc) (i32.const 47)))
+            (i32.eq (local.get $esc) (i32.const 92)))
         (then
-          (return (i32.const 8))
+          (i32.store8
+            (i32.add (local.get $out_ptr) (local.get $out_len))
+            (local.get $esc))
+          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
+          (br $loop)
         )
       )
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 116))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 117))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 112))
-                (i32.and
-                  (i32.eq (local.get $b3) (i32.const 108))
-                  (i32.eq (local.get $b4) (i32.const 101))))))
+      (if (i32.eq (local.get $esc) (i32.const 98))
         (then
-          (return (i32.const 9))
+          (i32.store8
+            (i32.add (local.get $out_ptr) (local.get $out_len))
+            (i32.const 8))
+          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
+          (br $loop)
         )
       )
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 117))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 110))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 105))
-                (i32.and
-                  (i32.eq (local.get $b3) (i32.const 111))
-                  (i32.eq (local.get $b4) (i32.const 110))))))
+      (if (i32.eq (local.get $esc) (i32.const 102))
         (then
-          (return (i32.const 11))
+          (i32.store8
+            (i32.add (local.get $out_ptr) (local.get $out_len))
+            (i32.const 12))
+          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
+          (br $loop)
         )
       )
-    )
-  )
-
-  (if (i32.eq (local.get $len) (i32.const 6))
-    (then
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 115))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 116))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 114))
-                (i32.and
-                  (i32.eq (local.get $b3) (i32.const 105))
-                  (i32.and
-                    (i32.eq (local.get $b4) (i32.const 110))
-                    (i32.eq (local.get $b5) (i32.const 103)))))))
+      (if (i32.eq (local.get $esc) (i32.const 110))
         (then
-          (return (i32.const 4))
+          (i32.store8
+            (i32.add (local.get $out_ptr) (local.get $out_len))
+            (i32.const 10))
+          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
+          (br $loop)
         )
       )
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 110))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 117))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 109))
-                (i32.and
-                  (i32.eq (local.get $b3) (i32.const 98))
-                  (i32.and
-                    (i32.eq (local.get $b4) (i32.const 101))
-                    (i32.eq (local.get $b5) (i32.const 114)))))))
+      (if (i32.eq (local.get $esc) (i32.const 114))
         (then
-          (return (i32.const 7))
+          (i32.store8
+            (i32.add (local.get $out_ptr) (local.get $out_len))
+            (i32.const 13))
+          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
+          (br $loop)
         )
       )
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 111))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 98))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 106))
-                (i32.and
-                  (i32.eq (local.get $b3) (i32.const 101))
-                  (i32.and
-                    (i32.eq (local.get $b4) (i32.const 99))
-                    (i32.eq (local.get $b5) (i32.const 116)))))))
+      (if (i32.eq (local.get $esc) (i32.const 116))
         (then
-          (return (i32.const 10))
+          (i32.store8
+            (i32.add (local.get $out_ptr) (local.get $out_len))
+            (i32.const 9))
+          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
+          (br $loop)
         )
       )
-    )
-  )
 
-  (if (i32.eq (local.get $len) (i32.const 7))
-    (then
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 98))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 111))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 111))
-                (i32.and
-                  (i32.eq (local.get $b3) (i32.const 108))
-                  (i32.and
-                    (i32.eq (local.get $b4) (i32.const 101))
-                    (i32.and
-                      (i32.eq (local.get $b5) (i32.const 97))
-                      (i32.eq (local.get $b6) (i32.const 110))))))))
-        (then
-          (return (i32.const 5))
-        )
-      )
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 105))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 110))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 116))
-                (i32.and
-                  (i32.eq (local.get $b3) (i32.const 101))
-                  (i32.and
-                    (i32.eq (local.get $b4) (i32.const 103))
-                    (i32.and
-                      (i32.eq (local.get $b5) (i32.const 101))
-                      (i32.eq (local.get $b6) (i32.const 114))))))))
-        (then
-          (return (i32.const 6))
+      (if (i32.eq (local.get $esc) (i32.const 117))
+        (then
+          (local.set $cp (call $json._parse_hex4))
+          (if (i32.lt_s (local.get $cp) (i32.const 0))
+            (then
+              (call $json._parse_fail_invalid)
+              (return (call $prelude.val_undefined))
+            )
+          )
+
+          (if (i32.and
+                (i32.ge_u (local.get $cp) (i32.const 55296))
+                (i32.le_u (local.get $cp) (i32.const 56319)))
+            (then
+              (if (i32.ge_u
+                    (i32.add (global.get $json_parse_ptr) (i32.const 1))
+                    (global.get $json_parse_end))
+                (then
+                  (call $json._parse_fail_invalid)
+                  (return (call $prelude.val_undefined))
+                )
+              )
+              (if (i32.or
+                    (i32.ne
+                      (i32.load8_u (global.get $json_parse_ptr))
+                      (i32.const 92))
+                    (i32.ne
+                      (i32.load8_u
+                        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+                      (i32.const 117)))
+                (then
+                  (call $json._parse_fail_invalid)
+                  (return (call $prelude.val_undefined))
+                )
+              )
+              (global.set $json_parse_ptr
+                (i32.add (global.get $json_parse_ptr) (i32.const 2)))
+              (local.set $cp2 (call $json._parse_hex4))
+              (if (i32.or
+                    (i32.lt_u (local.get $cp2) (i32.const 56320))
+                    (i32.gt_u (local.get $cp2) (i32.const 57343)))
+                (then
+                  (call $json._parse_fail_invalid)
+                  (return (call $prelude.val_undefined))
+                )
+              )
+              (local.set $cp
+                (i32.add
+                  (i32.const 65536)
+                  (i32.add
+                    (i32.shl
+                      (i32.sub (local.get $cp) (i32.const 55296))
+                      (i32.const 10))
+                    (i32.sub (local.get $cp2) (i32.const 56320)))))
+            )
+            (else
+              (if (i32.and
+                    (i32.ge_u (local.get $cp) (i32.const 56320))
+                    (i32.le_u (local.get $cp) (i32.const 57343)))
+                (then
+                  (call $json._parse_fail_invalid)
+                  (return (call $prelude.val_undefined))
+                )
+              )
+            )
+          )
+
+          (local.set $new_len
+            (call $json._append_utf8 (local.get $out_ptr) (local.get $out_len) (local.get $cp)))
+          (if (i32.lt_s (local.get $new_len) (i32.const 0))
+            (then
+              (call $json._parse_fail_invalid)
+              (return (call $prelude.val_undefined))
+            )
+          )
+          (local.set $out_len (local.get $new_len))
+          (br $loop)
         )
       )
-    )
-  )
 
-  (if (i32.eq (local.get $len) (i32.const 9))
-    (then
-      (if (i32.and
-            (i32.eq (local.get $b0) (i32.const 117))
-            (i32.and
-              (i32.eq (local.get $b1) (i32.const 110))
-              (i32.and
-                (i32.eq (local.get $b2) (i32.const 100))
-                (i32.and
-                  (i32.eq (local.get $b3) (i32.const 101))
-                  (i32.and
-                    (i32.eq (local.get $b4) (i32.const 102))
-                    (i32.and
-                      (i32.eq (local.get $b5) (i32.const 105))
-                      (i32.and
-                        (i32.eq (local.get $b6) (i32.const 110))
-                        (i32.and
-                          (i32.eq (local.get $b7) (i32.const 101))
-                          (i32.eq (local.get $b8) (i32.const 100))))))))))
-        (then
-          (return (i32.const 2))
-        )
-      )
+      (call $json._parse_fail_invalid)
+      (return (call $prelude.val_undefined))
     )
   )
 
-  (i32.const 0)
+  (call $json._parse_fail_invalid)
+  (call $prelude.val_undefined)
 )
 
-(func $json._literal_as_string (param $lit anyref) (result anyref)
-  (local $v anyref)
-  (if (i32.eqz (call $json._obj_has_key (local.get $lit) (call $json._k_value)))
-    (then
-      (return (call $json._str_empty))
-    )
-  )
-  (local.set $v (call $prelude.obj_get (local.get $lit) (call $json._k_value)))
-  (if (i32.eq (call $prelude.val_kind (local.get $v)) (i32.const 3))
+(func $json._parse_true (result anyref)
+  (local $p i32)
+  (if (global.get $json_parse_err)
     (then
-      (return (local.get $v))
+      (return (call $prelude.val_undefined))
     )
   )
-  (call $json._str_empty)
-)
-
-(func $json._literal_as_bool (param $lit anyref) (result i32)
-  (local $v anyref)
-  (if (i32.eqz (call $json._obj_has_key (local.get $lit) (call $json._k_value)))
+  (local.set $p (global.get $json_parse_ptr))
+  (if (i32.gt_u
+        (i32.add (local.get $p) (i32.const 4))
+        (global.get $json_parse_end))
     (then
-      (return (i32.const 0))
+      (call $json._parse_fail_invalid)
+      (return (call $prelude.val_undefined))
     )
   )
-  (local.set $v (call $prelude.obj_get (local.get $lit) (call $json._k_value)))
-  (if (i32.eq (call $prelude.val_kind (local.get $v)) (i32.const 2))
+  (if (i32.or
+        (i32.or
+          (i32.ne (i32.load8_u (local.get $p)) (i32.const 116))
+          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 1))) (i32.const 114)))
+        (i32.or
+          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 2))) (i32.const 117))
+          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 3))) (i32.const 101))))
     (then
-      (return (call $prelude.val_to_bool (local.get $v)))
+      (call $json._parse_fail_invalid)
+      (return (call $prelude.val_undefined))
     )
   )
-  (i32.const 0)
+  (global.set $json_parse_ptr (i32.add (local.get $p) (i32.const 4)))
+  (call $prelude.val_from_bool (i32.const 1))
 )
 
-(func $json._literal_as_f64 (param $lit anyref) (result f64)
-  (local $v anyref)
-  (local $k i32)
-  (if (i32.eqz (call $json._obj_has_key (local.get $lit) (call $json._k_value)))
+(func $json._parse_false (result anyref)
+  (local $p i32)
+  (if (global.get $json_parse_err)
     (then
-      (return (f64.const 0))
+      (return (call $prelude.val_undefined))
     )
   )
-  (local.set $v (call $prelude.obj_get (local.get $lit) (call $json._k_value)))
-  (local.set $k (call $prelude.val_kind (local.get $v)))
-  (if (i32.eq (local.get $k) (i32.const 1))
+  (local.set $p (global.get $json_parse_ptr))
+  (if (i32.gt_u
+        (i32.add (local.get $p) (i32.const 5))
+        (global.get $json_parse_end))
     (then
-      (return (call $prelude.val_to_f64 (local.get $v)))
+      (call $json._parse_fail_invalid)
+      (return (call $prelude.val_undefined))
     )
   )
-  (if (i32.eq (local.get $k) (i32.const 0))
+  (if (i32.or
+        (i32.or
+          (i32.ne (i32.load8_u (local.get $p)) (i32.const 102))
+          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 1))) (i32.const 97)))
+        (i32.or
+          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 2))) (i32.const 108))
+          (i32.or
+            (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 3))) (i32.const 115))
+            (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 4))) (i32.const 101)))))
     (then
-      (return (f64.convert_i64_s (call $prelude.val_to_i64 (local.get $v))))
+      (call $json._parse_fail_invalid)
+      (return (call $prelude.val_undefined))
     )
   )
-  (f64.const 0)
+  (global.set $json_parse_ptr (i32.add (local.get $p) (i32.const 5)))
+  (call $prelude.val_from_bool (i32.const 0))
 )
 
-(func $json._schema_allows_undefined (param $schema anyref) (result i32)
-  (local $kind anyref)
-  (local $code i32)
-  (local $union anyref)
-  (local $len i32)
-  (local $i i32)
-  (local $member anyref)
-
-  (if (i32.ne (call $prelude.val_kind (local.get $schema)) (i32.const 4))
-    (then
-      (return (i32.const 0))
-    )
-  )
-  (if (i32.eqz (call $json._obj_has_key (local.get $schema) (call $json._k_kind)))
-    (then
-      (return (i32.const 0))
-    )
-  )
-  (local.set $kind (call $prelude.obj_get (local.get $schema) (call $json._k_kind)))
-  (local.set $code (call $json._schema_kind_code (local.get $kind)))
-  (if (i32.eq (local.get $code) (i32.const 2))
-    (then
-      (return (i32.const 1))
-    )
-  )
-  (if (i32.ne (local.get $code) (i32.const 11))
+(func $json._parse_null (result anyref)
+  (local $p i32)
+  (if (global.get $json_parse_err)
     (then
-      (return (i32.const 0))
+      (return (call $prelude.val_undefined))
     )
   )
-  (if (i32.eqz (call $json._obj_has_key (local.get $schema) (call $json._k_union)))
+  (local.set $p (global.get $json_parse_ptr))
+  (if (i32.gt_u
+        (i32.add (local.get $p) (i32.const 4))
+        (global.get $json_parse_end))
     (then
-      (return (i32.const 0))
+      (call $json._parse_fail_invalid)
+      (return (call $prelude.val_undefined))
     )
   )
-  (local.set $union (call $prelude.obj_get (local.get $schema) (call $json._k_union)))
-  (if (i32.ne (call $prelude.val_kind (local.get $union)) (i32.const 5))
+  (if (i32.or
+        (i32.or
+          (i32.ne (i32.load8_u (local.get $p)) (i32.const 110))
+          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 1))) (i32.const 117)))
+        (i32.or
+          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 2))) (i32.const 108))
+          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 3))) (i32.const 108))))
     (then
-      (return (i32.const 0))
+      (call $json._parse_fail_invalid)
+      (return (call $prelude.val_undefined))
     )
   )
+  (global.set $json_parse_ptr (i32.add (local.get $p) (i32.const 4)))
+  (call $prelude.val_null)
+)
 
-  (local.set $len (call $prelude.arr_len (local.get $union)))
-  (local.set $i (i32.const 0))
-  (block $done
-    (loop $loop
-      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
-      (local.set $member (call $prelude.arr_get (local.get $union) (local.get $i)))
-      (if (call $json._schema_allows_undefined (local.get $member))
-        (then
-          (return (i32.const 1))
-        )
-      )
-      (local.set $i (i32.add (local.get $i) (i32.const 1)))
-      (br $loop)
+(func $json._parse_number (result anyref)
+  (local $start i32)
+  (local $p i32)
+  (local $c i32)
+  (local $has_frac i32)
+  (local $has_exp i32)
+  (local $len i32)
+  (local $i i32)
+  (local $neg i32)
+  (local $mag i64)
+  (local $digit i64)
+  (local $overflow i32)
+  (local $f f64)
+
+  (if (global.get $json_parse_err)
+    (then
+      (return (call $prelude.val_undefined))
     )
   )
-  (i32.const 0)
-)
 
-(func $json._parse_string (result anyref)
-  (local $raw_cap i32)
-  (local $out_ptr i32)
-  (local $out_len i32)
-  (local $b i32)
-  (local $esc i32)
-  (local $cp i32)
-  (local $cp2 i32)
-  (local $new_len i32)
+  (local.set $start (global.get $json_parse_ptr))
+  (local.set $p (local.get $start))
 
-  (if (global.get $json_parse_err)
+  (if (i32.ge_u (local.get $p) (global.get $json_parse_end))
     (then
+      (call $json._parse_fail_invalid)
       (return (call $prelude.val_undefined))
     )
   )
-  (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+
+  (local.set $c (i32.load8_u (local.get $p)))
+  (if (i32.eq (local.get $c) (i32.const 45))
     (then
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
+      (local.set $p (i32.add (local.get $p) (i32.const 1)))
     )
   )
-  (if (i32.ne
-        (i32.load8_u (global.get $json_parse_ptr))
-        (i32.const 34))
+  (if (i32.ge_u (local.get $p) (global.get $json_parse_end))
     (then
       (call $json._parse_fail_invalid)
       (return (call $prelude.val_undefined))
     )
   )
 
-  (global.set $json_parse_ptr
-    (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-
-  (local.set $raw_cap
-    (i32.add
-      (i32.sub (global.get $json_parse_end) (global.get $json_parse_ptr))
-      (i32.const 1)))
-  (if (i32.lt_s (local.get $raw_cap) (i32.const 1))
+  (local.set $c (i32.load8_u (local.get $p)))
+  (if (i32.eq (local.get $c) (i32.const 48))
     (then
-      (local.set $raw_cap (i32.const 1))
-    )
-  )
-  (local.set $out_ptr (call $prelude._alloc (local.get $raw_cap)))
-  (local.set $out_len (i32.const 0))
-
-  (block $done
-    (loop $loop
-      (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+      (local.set $p (i32.add (local.get $p) (i32.const 1)))
+      (if (i32.and
+            (i32.lt_u (local.get $p) (global.get $json_parse_end))
+            (call $json._is_digit (i32.load8_u (local.get $p))))
         (then
           (call $json._parse_fail_invalid)
           (return (call $prelude.val_undefined))
         )
       )
-      (local.set $b (i32.load8_u (global.get $json_parse_ptr)))
-      (global.set $json_parse_ptr
-        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-
-      (if (i32.eq (local.get $b) (i32.const 34))
-        (then
-          (return (call $prelude.str_from_utf8 (local.get $out_ptr) (local.get $out_len)))
-        )
-      )
-      (if (i32.lt_u (local.get $b) (i32.const 32))
+    )
+    (else
+      (if (i32.or
+            (i32.lt_u (local.get $c) (i32.const 49))
+            (i32.gt_u (local.get $c) (i32.const 57)))
         (then
           (call $json._parse_fail_invalid)
           (return (call $prelude.val_undefined))
         )
       )
-
-      (if (i32.ne (local.get $b) (i32.const 92))
-        (then
-          (i32.store8
-            (i32.add (local.get $out_ptr) (local.get $out_len))
-            (local.get $b))
-          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
-          (br $loop)
+      (block $int_done
+        (loop $int_loop
+          (br_if $int_done
+            (i32.ge_u (local.get $p) (global.get $json_parse_end)))
+          (br_if $int_done
+            (i32.eqz (call $json._is_digit (i32.load8_u (local.get $p)))))
+          (local.set $p (i32.add (local.get $p) (i32.const 1)))
+          (br $int_loop)
         )
       )
+    )
+  )
 
-      (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
-        (then
-          (call $json._parse_fail_invalid)
-          (return (call $prelude.val_undefined))
-        )
-      )
-      (local.set $esc (i32.load8_u (global.get $json_parse_ptr)))
-      (global.set $json_parse_ptr
-        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+  (local.set $has_frac (i32.const 0))
+  (local.set $has_exp (i32.const 0))
 
+  (if (i32.and
+        (i32.lt_u (local.get $p) (global.get $json_parse_end))
+        (i32.eq (i32.load8_u (local.get $p)) (i32.const 46)))
+    (then
+      (local.set $has_frac (i32.const 1))
+      (local.set $p (i32.add (local.get $p) (i32.const 1)))
       (if (i32.or
-            (i32.or
-              (i32.eq (local.get $esc) (i32.const 34))
-              (i32.eq (local.get $esc) (i32.const 47)))
-            (i32.eq (local.get $esc) (i32.const 92)))
-        (then
-          (i32.store8
-            (i32.add (local.get $out_ptr) (local.get $out_len))
-            (local.get $esc))
-          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
-          (br $loop)
-        )
-      )
-      (if (i32.eq (local.get $esc) (i32.const 98))
+            (i32.ge_u (local.get $p) (global.get $json_parse_end))
+            (i32.eqz (call $json._is_digit (i32.load8_u (local.get $p)))))
         (then
-          (i32.store8
-            (i32.add (local.get $out_ptr) (local.get $out_len))
-            (i32.const 8))
-          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
-          (br $loop)
+          (call $json._parse_fail_invalid)
+          (return (call $prelude.val_undefined))
         )
       )
-      (if (i32.eq (local.get $esc) (i32.const 102))
-        (then
-          (i32.store8
-            (i32.add (local.get $out_ptr) (local.get $out_len))
-            (i32.const 12))
-          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
-          (br $loop)
+      (block $frac_done
+        (loop $frac_loop
+          (br_if $frac_done
+            (i32.ge_u (local.get $p) (global.get $json_parse_end)))
+          (br_if $frac_done
+            (i32.eqz (call $json._is_digit (i32.load8_u (local.get $p)))))
+          (local.set $p (i32.add (local.get $p) (i32.const 1)))
+          (br $frac_loop)
         )
       )
-      (if (i32.eq (local.get $esc) (i32.const 110))
+    )
+  )
+
+  (if (i32.and
+        (i32.lt_u (local.get $p) (global.get $json_parse_end))
+        (i32.or
+          (i32.eq (i32.load8_u (local.get $p)) (i32.const 101))
+          (i32.eq (i32.load8_u (local.get $p)) (i32.const 69))))
+    (then
+      (local.set $has_exp (i32.const 1))
+      (local.set $p (i32.add (local.get $p) (i32.const 1)))
+      (if (i32.and
+            (i32.lt_u (local.get $p) (global.get $json_parse_end))
+            (i32.or
+              (i32.eq (i32.load8_u (local.get $p)) (i32.const 43))
+              (i32.eq (i32.load8_u (local.get $p)) (i32.const 45))))
         (then
-          (i32.store8
-            (i32.add (local.get $out_ptr) (local.get $out_len))
-            (i32.const 10))
-          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
-          (br $loop)
+          (local.set $p (i32.add (local.get $p) (i32.const 1)))
         )
       )
-      (if (i32.eq (local.get $esc) (i32.const 114))
+      (if (i32.or
+            (i32.ge_u (local.get $p) (global.get $json_parse_end))
+            (i32.eqz (call $json._is_digit (i32.load8_u (local.get $p)))))
         (then
-          (i32.store8
-            (i32.add (local.get $out_ptr) (local.get $out_len))
-            (i32.const 13))
-          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
-          (br $loop)
+          (call $json._parse_fail_invalid)
+          (return (call $prelude.val_undefined))
         )
       )
-      (if (i32.eq (local.get $esc) (i32.const 116))
-        (then
-          (i32.store8
-            (i32.add (local.get $out_ptr) (local.get $out_len))
-            (i32.const 9))
-          (local.set $out_len (i32.add (local.get $out_len) (i32.const 1)))
-          (br $loop)
+      (block $exp_done
+        (loop $exp_loop
+          (br_if $exp_done
+            (i32.ge_u (local.get $p) (global.get $json_parse_end)))
+          (br_if $exp_done
+            (i32.eqz (call $json._is_digit (i32.load8_u (local.get $p)))))
+          (local.set $p (i32.add (local.get $p) (i32.const 1)))
+          (br $exp_loop)
         )
       )
+    )
+  )
 
-      (if (i32.eq (local.get $esc) (i32.const 117))
-        (then
-          (local.set $cp (call $json._parse_hex4))
-          (if (i32.lt_s (local.get $cp) (i32.const 0))
-            (then
-              (call $json._parse_fail_invalid)
-              (return (call $prelude.val_undefined))
-            )
-          )
+  (global.set $json_parse_ptr (local.get $p))
+  (local.set $len (i32.sub (local.get $p) (local.get $start)))
 
-          (if (i32.and
-                (i32.ge_u (local.get $cp) (i32.const 55296))
-                (i32.le_u (local.get $cp) (i32.const 56319)))
-            (then
-              (if (i32.ge_u
-                    (i32.add (global.get $json_parse_ptr) (i32.const 1))
-                    (global.get $json_parse_end))
-                (then
-                  (call $json._parse_fail_invalid)
-                  (return (call $prelude.val_undefined))
-                )
-              )
-              (if (i32.or
-                    (i32.ne
-                      (i32.load8_u (global.get $json_parse_ptr))
-                      (i32.const 92))
-                    (i32.ne
-                      (i32.load8_u
-                        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-                      (i32.const 117)))
-                (then
-                  (call $json._parse_fail_invalid)
-                  (return (call $prelude.val_undefined))
-                )
-              )
-              (global.set $json_parse_ptr
-                (i32.add (global.get $json_parse_ptr) (i32.const 2)))
-              (local.set $cp2 (call $json._parse_hex4))
-              (if (i32.or
-                    (i32.lt_u (local.get $cp2) (i32.const 56320))
-                    (i32.gt_u (local.get $cp2) (i32.const 57343)))
-                (then
-                  (call $json._parse_fail_invalid)
-                  (return (call $prelude.val_undefined))
-                )
-              )
-              (local.set $cp
-                (i32.add
-                  (i32.const 65536)
-                  (i32.add
-                    (i32.shl
-                      (i32.sub (local.get $cp) (i32.const 55296))
-                      (i32.const 10))
-                    (i32.sub (local.get $cp2) (i32.const 56320)))))
-            )
-            (else
-              (if (i32.and
-                    (i32.ge_u (local.get $cp) (i32.const 56320))
-                    (i32.le_u (local.get $cp) (i32.const 57343)))
-                (then
-                  (call $json._parse_fail_invalid)
-                  (return (call $prelude.val_undefined))
-                )
-              )
+  (if (i32.or (local.get $has_frac) (local.get $has_exp))
+    (then
+      (local.set $f (call $json._parse_f64_token (local.get $start) (local.get $len)))
+      (return (call $prelude.val_from_f64 (local.get $f)))
+    )
+  )
+
+  (local.set $i (local.get $start))
+  (local.set $neg (i32.const 0))
+  (if (i32.eq (i32.load8_u (local.get $i)) (i32.const 45))
+    (then
+      (local.set $neg (i32.const 1))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+    )
+  )
+  (local.set $mag (i64.const 0))
+  (local.set $overflow (i32.const 0))
+
+  (block $int_parse_done
+    (loop $int_parse
+      (br_if $int_parse_done (i32.ge_u (local.get $i) (local.get $p)))
+      (local.set $digit
+        (i64.extend_i32_u
+          (i32.sub (i32.load8_u (local.get $i)) (i32.const 48))))
+
+      (if (i64.gt_u (local.get $mag) (i64.const 922337203685477580))
+        (then
+          (local.set $overflow (i32.const 1))
+          (br $int_parse_done)
+        )
+      )
+      (if (i64.eq (local.get $mag) (i64.const 922337203685477580))
+        (then
+          (if (i32.and
+                (local.get $neg)
+                (i64.gt_u (local.get $digit) (i64.const 8)))
+            (then
+              (local.set $overflow (i32.const 1))
+              (br $int_parse_done)
             )
           )
-
-          (local.set $new_len
-            (call $json._append_utf8 (local.get $out_ptr) (local.get $out_len) (local.get $cp)))
-          (if (i32.lt_s (local.get $new_len) (i32.const 0))
+          (if (i32.and
+                (i32.eqz (local.get $neg))
+                (i64.gt_u (local.get $digit) (i64.const 7)))
             (then
-              (call $json._parse_fail_invalid)
-              (return (call $prelude.val_undefined))
+              (local.set $overflow (i32.const 1))
+              (br $int_parse_done)
             )
           )
-          (local.set $out_len (local.get $new_len))
-          (br $loop)
         )
       )
 
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
+      (local.set $mag
+        (i64.add
+          (i64.mul (local.get $mag) (i64.const 10))
+          (local.get $digit)))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $int_parse)
     )
   )
 
-  (call $json._parse_fail_invalid)
-  (call $prelude.val_undefined)
+  (if (local.get $overflow)
+    (then
+      (local.set $f (call $json._parse_f64_token (local.get $start) (local.get $len)))
+      (return (call $prelude.val_from_f64 (local.get $f)))
+    )
+  )
+
+  (if (local.get $neg)
+    (then
+      (if (i64.eq (local.get $mag) (i64.const -9223372036854775808))
+        (then
+          (return (call $prelude.val_from_i64 (i64.const -9223372036854775808)))
+        )
+      )
+      (return
+        (call $prelude.val_from_i64
+          (i64.sub (i64.const 0) (local.get $mag))))
+    )
+  )
+
+  (call $prelude.val_from_i64 (local.get $mag))
 )
 
-(func $json._parse_true (result anyref)
-  (local $p i32)
+(func $json._parse_value (result anyref)
+  (local $c i32)
   (if (global.get $json_parse_err)
     (then
       (return (call $prelude.val_undefined))
     )
   )
-  (local.set $p (global.get $json_parse_ptr))
-  (if (i32.gt_u
-        (i32.add (local.get $p) (i32.const 4))
-        (global.get $json_parse_end))
+
+  (call $json._parse_skip_ws)
+  (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
     (then
       (call $json._parse_fail_invalid)
       (return (call $prelude.val_undefined))
     )
   )
-  (if (i32.or
-        (i32.or
-          (i32.ne (i32.load8_u (local.get $p)) (i32.const 116))
-          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 1))) (i32.const 114)))
-        (i32.or
-          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 2))) (i32.const 117))
-          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 3))) (i32.const 101))))
+
+  (local.set $c (i32.load8_u (global.get $json_parse_ptr)))
+  (if (i32.eq (local.get $c) (i32.const 34))
     (then
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
+      (return (call $json._parse_string))
     )
   )
-  (global.set $json_parse_ptr (i32.add (local.get $p) (i32.const 4)))
-  (call $prelude.val_from_bool (i32.const 1))
-)
-
-(func $json._parse_false (result anyref)
-  (local $p i32)
-  (if (global.get $json_parse_err)
+  (if (i32.eq (local.get $c) (i32.const 123))
     (then
-      (return (call $prelude.val_undefined))
+      (return (call $json._parse_object))
     )
   )
-  (local.set $p (global.get $json_parse_ptr))
-  (if (i32.gt_u
-        (i32.add (local.get $p) (i32.const 5))
-        (global.get $json_parse_end))
+  (if (i32.eq (local.get $c) (i32.const 91))
     (then
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
+      (return (call $json._parse_array))
+    )
+  )
+  (if (i32.eq (local.get $c) (i32.const 116))
+    (then
+      (return (call $json._parse_true))
+    )
+  )
+  (if (i32.eq (local.get $c) (i32.const 102))
+    (then
+      (return (call $json._parse_false))
+    )
+  )
+  (if (i32.eq (local.get $c) (i32.const 110))
+    (then
+      (return (call $json._parse_null))
     )
   )
   (if (i32.or
-        (i32.or
-          (i32.ne (i32.load8_u (local.get $p)) (i32.const 102))
-          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 1))) (i32.const 97)))
-        (i32.or
-          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 2))) (i32.const 108))
-          (i32.or
-            (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 3))) (i32.const 115))
-            (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 4))) (i32.const 101)))))
+        (i32.eq (local.get $c) (i32.const 45))
+        (call $json._is_digit (local.get $c)))
     (then
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
+      (return (call $json._parse_number))
     )
   )
-  (global.set $json_parse_ptr (i32.add (local.get $p) (i32.const 5)))
-  (call $prelude.val_from_bool (i32.const 0))
+
+  (call $json._parse_fail_invalid)
+  (call $prelude.val_undefined)
 )
 
-(func $json._parse_null (result anyref)
-  (local $p i32)
+(func $json._parse_array (result anyref)
+  (local $cap i32)
+  (local $tmp anyref)
+  (local $out anyref)
+  (local $count i32)
+  (local $i i32)
+  (local $c i32)
+  (local $val anyref)
+
   (if (global.get $json_parse_err)
     (then
       (return (call $prelude.val_undefined))
     )
   )
-  (local.set $p (global.get $json_parse_ptr))
-  (if (i32.gt_u
-        (i32.add (local.get $p) (i32.const 4))
-        (global.get $json_parse_end))
+  (if (i32.or
+        (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+        (i32.ne
+          (i32.load8_u (global.get $json_parse_ptr))
+          (i32.const 91)))
     (then
       (call $json._parse_fail_invalid)
       (return (call $prelude.val_undefined))
     )
   )
-  (if (i32.or
-        (i32.or
-          (i32.ne (i32.load8_u (local.get $p)) (i32.const 110))
-          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 1))) (i32.const 117)))
-        (i32.or
-          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 2))) (i32.const 108))
-          (i32.ne (i32.load8_u (i32.add (local.get $p) (i32.const 3))) (i32.const 108))))
+
+  (global.set $json_parse_ptr
+    (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+  (call $json._parse_skip_ws)
+
+  (if (i32.and
+        (i32.lt_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+        (i32.eq
+          (i32.load8_u (global.get $json_parse_ptr))
+          (i32.const 93)))
+    (then
+      (global.set $json_parse_ptr
+        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+      (return (call $prelude.arr_new (i32.const 0)))
+    )
+  )
+
+  (local.set $cap
+    (i32.sub (global.get $json_parse_end) (global.get $json_parse_ptr)))
+  (if (i32.lt_s (local.get $cap) (i32.const 1))
     (then
+      (local.set $cap (i32.const 1))
+    )
+  )
+  (local.set $tmp (call $prelude.arr_new (local.get $cap)))
+  (local.set $count (i32.const 0))
+
+  (block $done
+    (loop $loop
+      (local.set $val (call $json._parse_value))
+      (if (global.get $json_parse_err)
+        (then
+          (return (call $prelude.val_undefined))
+        )
+      )
+      (call $prelude.arr_set (local.get $tmp) (local.get $count) (local.get $val))
+      (local.set $count (i32.add (local.get $count) (i32.const 1)))
+
+      (call $json._parse_skip_ws)
+      (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+        (then
+          (call $json._parse_fail_invalid)
+          (return (call $prelude.val_undefined))
+        )
+      )
+      (local.set $c (i32.load8_u (global.get $json_parse_ptr)))
+      (if (i32.eq (local.get $c) (i32.const 44))
+        (then
+          (global.set $json_parse_ptr
+            (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+          (call $json._parse_skip_ws)
+          (br $loop)
+        )
+      )
+      (if (i32.eq (local.get $c) (i32.const 93))
+        (then
+          (global.set $json_parse_ptr
+            (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+          (br $done)
+        )
+      )
+
       (call $json._parse_fail_invalid)
       (return (call $prelude.val_undefined))
     )
   )
-  (global.set $json_parse_ptr (i32.add (local.get $p) (i32.const 4)))
-  (call $prelude.val_null)
+
+  (local.set $out (call $prelude.arr_new (local.get $count)))
+  (local.set $i (i32.const 0))
+  (block $copy_done
+    (loop $copy
+      (br_if $copy_done (i32.ge_u (local.get $i) (local.get $count)))
+      (call $prelude.arr_set
+        (local.get $out)
+        (local.get $i)
+        (call $prelude.arr_get (local.get $tmp) (local.get $i)))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $copy)
+    )
+  )
+
+  (local.get $out)
 )
 
-(func $json._parse_number (result anyref)
-  (local $start i32)
-  (local $p i32)
-  (local $c i32)
-  (local $has_frac i32)
-  (local $has_exp i32)
-  (local $len i32)
+(func $json._parse_object (result anyref)
+  (local $cap i32)
+  (local $keys anyref)
+  (local $vals anyref)
+  (local $obj anyref)
+  (local $count i32)
   (local $i i32)
-  (local $neg i32)
-  (local $mag i64)
-  (local $digit i64)
-  (local $overflow i32)
-  (local $f f64)
+  (local $j i32)
+  (local $c i32)
+  (local $key_i anyref)
+  (local $key_j anyref)
+  (local $tmp anyref)
+  (local $val_i anyref)
+  (local $val_j anyref)
+  (local $key anyref)
+  (local $val anyref)
 
   (if (global.get $json_parse_err)
     (then
       (return (call $prelude.val_undefined))
     )
   )
-
-  (local.set $start (global.get $json_parse_ptr))
-  (local.set $p (local.get $start))
-
-  (if (i32.ge_u (local.get $p) (global.get $json_parse_end))
+  (if (i32.or
+        (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+        (i32.ne
+          (i32.load8_u (global.get $json_parse_ptr))
+          (i32.const 123)))
     (then
       (call $json._parse_fail_invalid)
       (return (call $prelude.val_undefined))
     )
   )
 
-  (local.set $c (i32.load8_u (local.get $p)))
-  (if (i32.eq (local.get $c) (i32.const 45))
+  (global.set $json_parse_ptr
+    (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+  (call $json._parse_skip_ws)
+
+  (if (i32.and
+        (i32.lt_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+        (i32.eq
+          (i32.load8_u (global.get $json_parse_ptr))
+          (i32.const 125)))
     (then
-      (local.set $p (i32.add (local.get $p) (i32.const 1)))
+      (global.set $json_parse_ptr
+        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+      (return (call $prelude.obj_new (i32.const 0)))
     )
   )
-  (if (i32.ge_u (local.get $p) (global.get $json_parse_end))
+
+  (local.set $cap
+    (i32.sub (global.get $json_parse_end) (global.get $json_parse_ptr)))
+  (if (i32.lt_s (local.get $cap) (i32.const 1))
     (then
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
+      (local.set $cap (i32.const 1))
     )
   )
+  (local.set $keys (call $prelude.arr_new (local.get $cap)))
+  (local.set $vals (call $prelude.arr_new (local.get $cap)))
+  (local.set $count (i32.const 0))
 
-  (local.set $c (i32.load8_u (local.get $p)))
-  (if (i32.eq (local.get $c) (i32.const 48))
-    (then
-      (local.set $p (i32.add (local.get $p) (i32.const 1)))
-      (if (i32.and
-            (i32.lt_u (local.get $p) (global.get $json_parse_end))
-            (call $json._is_digit (i32.load8_u (local.get $p))))
+  (block $done
+    (loop $loop
+      (if (i32.or
+            (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+            (i32.ne
+              (i32.load8_u (global.get $json_parse_ptr))
+              (i32.const 34)))
         (then
           (call $json._parse_fail_invalid)
           (return (call $prelude.val_undefined))
         )
       )
-    )
-    (else
-      (if (i32.or
-            (i32.lt_u (local.get $c) (i32.const 49))
-            (i32.gt_u (local.get $c) (i32.const 57)))
+
+      (local.set $key (call $json._parse_string))
+      (if (global.get $json_parse_err)
         (then
-          (call $json._parse_fail_invalid)
           (return (call $prelude.val_undefined))
         )
       )
-      (block $int_done
-        (loop $int_loop
-          (br_if $int_done
-            (i32.ge_u (local.get $p) (global.get $json_parse_end)))
-          (br_if $int_done
-            (i32.eqz (call $json._is_digit (i32.load8_u (local.get $p)))))
-          (local.set $p (i32.add (local.get $p) (i32.const 1)))
-          (br $int_loop)
-        )
-      )
-    )
-  )
-
-  (local.set $has_frac (i32.const 0))
-  (local.set $has_exp (i32.const 0))
 
-  (if (i32.and
-        (i32.lt_u (local.get $p) (global.get $json_parse_end))
-        (i32.eq (i32.load8_u (local.get $p)) (i32.const 46)))
-    (then
-      (local.set $has_frac (i32.const 1))
-      (local.set $p (i32.add (local.get $p) (i32.const 1)))
+      (call $json._parse_skip_ws)
       (if (i32.or
-            (i32.ge_u (local.get $p) (global.get $json_parse_end))
-            (i32.eqz (call $json._is_digit (i32.load8_u (local.get $p)))))
+            (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+            (i32.ne
+              (i32.load8_u (global.get $json_parse_ptr))
+              (i32.const 58)))
         (then
           (call $json._parse_fail_invalid)
           (return (call $prelude.val_undefined))
         )
       )
-      (block $frac_done
-        (loop $frac_loop
-          (br_if $frac_done
-            (i32.ge_u (local.get $p) (global.get $json_parse_end)))
-          (br_if $frac_done
-            (i32.eqz (call $json._is_digit (i32.load8_u (local.get $p)))))
-          (local.set $p (i32.add (local.get $p) (i32.const 1)))
-          (br $frac_loop)
-        )
-      )
-    )
-  )
+      (global.set $json_parse_ptr
+        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
 
-  (if (i32.and
-        (i32.lt_u (local.get $p) (global.get $json_parse_end))
-        (i32.or
-          (i32.eq (i32.load8_u (local.get $p)) (i32.const 101))
-          (i32.eq (i32.load8_u (local.get $p)) (i32.const 69))))
-    (then
-      (local.set $has_exp (i32.const 1))
-      (local.set $p (i32.add (local.get $p) (i32.const 1)))
-      (if (i32.and
-            (i32.lt_u (local.get $p) (global.get $json_parse_end))
-            (i32.or
-              (i32.eq (i32.load8_u (local.get $p)) (i32.const 43))
-              (i32.eq (i32.load8_u (local.get $p)) (i32.const 45))))
+      (local.set $val (call $json._parse_value))
+      (if (global.get $json_parse_err)
         (then
-          (local.set $p (i32.add (local.get $p) (i32.const 1)))
+          (return (call $prelude.val_undefined))
         )
       )
-      (if (i32.or
-            (i32.ge_u (local.get $p) (global.get $json_parse_end))
-            (i32.eqz (call $json._is_digit (i32.load8_u (local.get $p)))))
+
+      (call $prelude.arr_set (local.get $keys) (local.get $count) (local.get $key))
+      (call $prelude.arr_set (local.get $vals) (local.get $count) (local.get $val))
+      (local.set $count (i32.add (local.get $count) (i32.const 1)))
+
+      (call $json._parse_skip_ws)
+      (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
         (then
           (call $json._parse_fail_invalid)
           (return (call $prelude.val_undefined))
         )
       )
-      (block $exp_done
-        (loop $exp_loop
-          (br_if $exp_done
-            (i32.ge_u (local.get $p) (global.get $json_parse_end)))
-          (br_if $exp_done
-            (i32.eqz (call $json._is_digit (i32.load8_u (local.get $p)))))
-          (local.set $p (i32.add (local.get $p) (i32.const 1)))
-          (br $exp_loop)
+      (local.set $c (i32.load8_u (global.get $json_parse_ptr)))
+      (if (i32.eq (local.get $c) (i32.const 44))
+        (then
+          (global.set $json_parse_ptr
+            (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+          (call $json._parse_skip_ws)
+          (br $loop)
+        )
+      )
+      (if (i32.eq (local.get $c) (i32.const 125))
+        (then
+          (global.set $json_parse_ptr
+            (i32.add (global.get $json_parse_ptr) (i32.const 1)))
+          (br $done)
         )
       )
-    )
-  )
-
-  (global.set $json_parse_ptr (local.get $p))
-  (local.set $len (i32.sub (local.get $p) (local.get $start)))
-
-  (if (i32.or (local.get $has_frac) (local.get $has_exp))
-    (then
-      (local.set $f (call $json._parse_f64_token (local.get $start) (local.get $len)))
-      (return (call $prelude.val_from_f64 (local.get $f)))
-    )
-  )
 
-  (local.set $i (local.get $start))
-  (local.set $neg (i32.const 0))
-  (if (i32.eq (i32.load8_u (local.get $i)) (i32.const 45))
-    (then
-      (local.set $neg (i32.const 1))
-      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (call $json._parse_fail_invalid)
+      (return (call $prelude.val_undefined))
     )
   )
-  (local.set $mag (i64.const 0))
-  (local.set $overflow (i32.const 0))
-
-  (block $int_parse_done
-    (loop $int_parse
-      (br_if $int_parse_done (i32.ge_u (local.get $i) (local.get $p)))
-      (local.set $digit
-        (i64.extend_i32_u
-          (i32.sub (i32.load8_u (local.get $i)) (i32.const 48))))
 
-      (if (i64.gt_u (local.get $mag) (i64.const 922337203685477580))
-        (then
-          (local.set $overflow (i32.const 1))
-          (br $int_parse_done)
-        )
-      )
-      (if (i64.eq (local.get $mag) (i64.const 922337203685477580))
-        (then
-          (if (i32.and
-                (local.get $neg)
-                (i64.gt_u (local.get $digit) (i64.const 8)))
-            (then
-              (local.set $overflow (i32.const 1))
-              (br $int_parse_done)
-            )
-          )
-          (if (i32.and
-                (i32.eqz (local.get $neg))
-                (i64.gt_u (local.get $digit) (i64.const 7)))
+  ;; Keep toJSON output deterministic: object keys are sorted.
+  (local.set $i (i32.const 0))
+  (block $sort_i_done
+    (loop $sort_i
+      (br_if $sort_i_done (i32.ge_u (local.get $i) (local.get $count)))
+      (local.set $j (i32.add (local.get $i) (i32.const 1)))
+      (block $sort_j_done
+        (loop $sort_j
+          (br_if $sort_j_done (i32.ge_u (local.get $j) (local.get $count)))
+          (local.set $key_i (call $prelude.arr_get (local.get $keys) (local.get $i)))
+          (local.set $key_j (call $prelude.arr_get (local.get $keys) (local.get $j)))
+          (if (i32.gt_s (call $json._str_cmp (local.get $key_i) (local.get $key_j)) (i32.const 0))
             (then
-              (local.set $overflow (i32.const 1))
-              (br $int_parse_done)
+              (local.set $tmp (local.get $key_i))
+              (call $prelude.arr_set (local.get $keys) (local.get $i) (local.get $key_j))
+              (call $prelude.arr_set (local.get $keys) (local.get $j) (local.get $tmp))
+
+              (local.set $val_i (call $prelude.arr_get (local.get $vals) (local.get $i)))
+              (local.set $val_j (call $prelude.arr_get (local.get $vals) (local.get $j)))
+              (call $prelude.arr_set (local.get $vals) (local.get $i) (local.get $val_j))
+              (call $prelude.arr_set (local.get $vals) (local.get $j) (local.get $val_i))
             )
           )
+          (local.set $j (i32.add (local.get $j) (i32.const 1)))
+          (br $sort_j)
         )
       )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $sort_i)
+    )
+  )
 
-      (local.set $mag
-        (i64.add
-          (i64.mul (local.get $mag) (i64.const 10))
-          (local.get $digit)))
+  (local.set $obj (call $prelude.obj_new (local.get $count)))
+  (local.set $i (i32.const 0))
+  (block $build_done
+    (loop $build
+      (br_if $build_done (i32.ge_u (local.get $i) (local.get $count)))
+      (call $prelude.obj_set
+        (local.get $obj)
+        (call $prelude.arr_get (local.get $keys) (local.get $i))
+        (call $prelude.arr_get (local.get $vals) (local.get $i)))
       (local.set $i (i32.add (local.get $i) (i32.const 1)))
-      (br $int_parse)
+      (br $build)
     )
   )
 
-  (if (local.get $overflow)
+  (local.get $obj)
+)
+
+(func $json.toJSON (param $text anyref) (result anyref)
+  (local $kind i32)
+  (local $ptr i32)
+  (local $len i32)
+  (local $value anyref)
+  (local $msg anyref)
+
+  (local.set $kind (call $prelude.val_kind (local.get $text)))
+  (if (i32.ne (local.get $kind) (i32.const 3))
     (then
-      (local.set $f (call $json._parse_f64_token (local.get $start) (local.get $len)))
-      (return (call $prelude.val_from_f64 (local.get $f)))
+      (return (call $json._error_from_msg (call $json._msg_toJSON_expects_string)))
     )
   )
 
-  (if (local.get $neg)
+  (local.set $ptr (call $prelude._string_ptr (local.get $text)))
+  (local.set $len (call $prelude._string_bytelen (local.get $text)))
+  (call $json._parse_reset (local.get $ptr) (local.get $len))
+  (call $json._parse_skip_ws)
+  (local.set $value (call $json._parse_value))
+
+  (if (i32.eqz (global.get $json_parse_err))
     (then
-      (if (i64.eq (local.get $mag) (i64.const -9223372036854775808))
+      (call $json._parse_skip_ws)
+      (if (i32.ne (global.get $json_parse_ptr) (global.get $json_parse_end))
         (then
-          (return (call $prelude.val_from_i64 (i64.const -9223372036854775808)))
+          (call $json._parse_fail_invalid)
         )
       )
-      (return
-        (call $prelude.val_from_i64
-          (i64.sub (i64.const 0) (local.get $mag))))
     )
   )
 
-  (call $prelude.val_from_i64 (local.get $mag))
+  (if (global.get $json_parse_err)
+    (then
+      (local.set $msg (global.get $json_parse_err_msg))
+      (if (ref.is_null (local.get $msg))
+        (then
+          (local.set $msg (call $json._msg_invalid_json))
+        )
+      )
+      (return (call $json._error_from_msg (local.get $msg)))
+    )
+  )
+
+  (local.get $value)
 )
 
-(func $json._parse_value (result anyref)
-  (local $c i32)
-  (if (global.get $json_parse_err)
+(func $json.parse (param $text anyref) (param $schema anyref) (result anyref)
+  (local $parsed anyref)
+
+  (local.set $parsed (call $json.toJSON (local.get $text)))
+  (if (call $json._is_error_object (local.get $parsed))
     (then
-      (return (call $prelude.val_undefined))
+      (return (local.get $parsed))
     )
   )
 
-  (call $json._parse_skip_ws)
-  (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+  (call $json.decode (local.get $parsed) (local.get $schema))
+)
+
+;; ---- where { ... } の制約 ----
+
+(func $json._num_as_f64 (param $value anyref) (result f64)
+  (if (i32.eqz (call $prelude.val_kind (local.get $value)))
     (then
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
+      (return (f64.convert_i64_s (call $prelude.val_to_i64 (local.get $value))))
     )
   )
+  (call $prelude.val_to_f64 (local.get $value))
+)
 
-  (local.set $c (i32.load8_u (global.get $json_parse_ptr)))
-  (if (i32.eq (local.get $c) (i32.const 34))
+(func $json._num_lt (param $a anyref) (param $b anyref) (result i32)
+  (if (i32.and
+        (i32.eqz (call $prelude.val_kind (local.get $a)))
+        (i32.eqz (call $prelude.val_kind (local.get $b))))
     (then
-      (return (call $json._parse_string))
+      (return
+        (i64.lt_s
+          (call $prelude.val_to_i64 (local.get $a))
+          (call $prelude.val_to_i64 (local.get $b))))
     )
   )
-  (if (i32.eq (local.get $c) (i32.const 123))
+  (f64.lt (call $json._num_as_f64 (local.get $a)) (call $json._num_as_f64 (local.get $b)))
+)
+
+(func $json._decode_check_length (param $len i64) (param $schema anyref) (param $path anyref)
+  (local $limit anyref)
+  (if (call $json._obj_has_key (local.get $schema) (call $json._k_min_length))
     (then
-      (return (call $json._parse_object))
+      (local.set $limit (call $prelude.obj_get (local.get $schema) (call $json._k_min_length)))
+      (if (i64.lt_s (local.get $len) (call $prelude.val_to_i64 (local.get $limit)))
+        (then
+          (call $json._decode_error_at
+            (local.get $path)
+            (call $prelude.str_concat
+              (call $json._msg_length_at_least)
+              (call $json.stringify (local.get $limit))))
+        )
+      )
     )
   )
-  (if (i32.eq (local.get $c) (i32.const 91))
+  (if (call $json._obj_has_key (local.get $schema) (call $json._k_max_length))
     (then
-      (return (call $json._parse_array))
+      (local.set $limit (call $prelude.obj_get (local.get $schema) (call $json._k_max_length)))
+      (if (i64.gt_s (local.get $len) (call $prelude.val_to_i64 (local.get $limit)))
+        (then
+          (call $json._decode_error_at
+            (local.get $path)
+            (call $prelude.str_concat
+              (call $json._msg_length_at_most)
+              (call $json.stringify (local.get $limit))))
+        )
+      )
     )
   )
-  (if (i32.eq (local.get $c) (i32.const 116))
+)
+
+(func $json._decode_check_range (param $value anyref) (param $schema anyref) (param $path anyref)
+  (local $limit anyref)
+  (if (call $json._obj_has_key (local.get $schema) (call $json._k_min))
     (then
-      (return (call $json._parse_true))
+      (local.set $limit (call $prelude.obj_get (local.get $schema) (call $json._k_min)))
+      (if (call $json._num_lt (local.get $value) (local.get $limit))
+        (then
+          (call $json._decode_error_at
+            (local.get $path)
+            (call $prelude.str_concat
+              (call $json._msg_at_least)
+              (call $json.stringify (local.get $limit))))
+        )
+      )
     )
   )
-  (if (i32.eq (local.get $c) (i32.const 102))
+  (if (call $json._obj_has_key (local.get $schema) (call $json._k_max))
     (then
-      (return (call $json._parse_false))
+      (local.set $limit (call $prelude.obj_get (local.get $schema) (call $json._k_max)))
+      (if (call $json._num_lt (local.get $limit) (local.get $value))
+        (then
+          (call $json._decode_error_at
+            (local.get $path)
+            (call $prelude.str_concat
+              (call $json._msg_at_most)
+              (call $json.stringify (local.get $limit))))
+        )
+      )
     )
   )
-  (if (i32.eq (local.get $c) (i32.const 110))
+)
+
+(func $json._decode_check_pattern (param $value anyref) (param $schema anyref) (param $path anyref)
+  (local $pattern anyref)
+  (if (i32.eqz (call $json._obj_has_key (local.get $schema) (call $json._k_pattern)))
     (then
-      (return (call $json._parse_null))
+      (return)
     )
   )
-  (if (i32.or
-        (i32.eq (local.get $c) (i32.const 45))
-        (call $json._is_digit (local.get $c)))
+  (local.set $pattern (call $prelude.obj_get (local.get $schema) (call $json._k_pattern)))
+  (if (i32.ne (call $prelude.val_kind (local.get $pattern)) (i32.const 3))
     (then
-      (return (call $json._parse_number))
+      (call $json._decode_invalid_schema_at (local.get $path))
+      (return)
+    )
+  )
+  (if (i32.eqz (call $json._re_match (local.get $pattern) (local.get $value)))
+    (then
+      (call $json._decode_error_at
+        (local.get $path)
+        (call $prelude.str_concat
+          (call $json._msg_pattern_mismatch)
+          (call $json.stringify (local.get $pattern))))
     )
   )
+)
 
-  (call $json._parse_fail_invalid)
-  (call $prelude.val_undefined)
+;; ---- pattern の照合 ----
+;; コンパイラの validatePattern が受け付けるサブセットだけを扱うバックトラッキング照合。
+;; 文字列は UTF-8 のバイト列のまま扱い、. と否定クラスは1文字（コードポイント）を読み進める。
+
+(func $json._re_pb (param $i i32) (result i32)
+  (i32.load8_u (i32.add (global.get $json_re_p) (local.get $i)))
 )
 
-(func $json._parse_array (result anyref)
-  (local $cap i32)
-  (local $tmp anyref)
-  (local $out anyref)
-  (local $count i32)
-  (local $i i32)
-  (local $c i32)
-  (local $val anyref)
+(func $json._re_sb (param $i i32) (result i32)
+  (i32.load8_u (i32.add (global.get $json_re_s) (local.get $i)))
+)
 
-  (if (global.get $json_parse_err)
-    (then
-      (return (call $prelude.val_undefined))
-    )
-  )
-  (if (i32.or
-        (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+(func $json._re_next_char (param $i i32) (result i32)
+  (local.set $i (i32.add (local.get $i) (i32.const 1)))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (global.get $json_re_slen)))
+      (br_if $done
         (i32.ne
-          (i32.load8_u (global.get $json_parse_ptr))
-          (i32.const 91)))
-    (then
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
+          (i32.and (call $json._re_sb (local.get $i)) (i32.const 192))
+          (i32.const 128)))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
     )
   )
+  (local.get $i)
+)
 
-  (global.set $json_parse_ptr
-    (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-  (call $json._parse_skip_ws)
+(func $json._re_is_digit (param $b i32) (result i32)
+  (i32.and
+    (i32.ge_u (local.get $b) (i32.const 48))
+    (i32.le_u (local.get $b) (i32.const 57)))
+)
 
-  (if (i32.and
-        (i32.lt_u (global.get $json_parse_ptr) (global.get $json_parse_end))
-        (i32.eq
-          (i32.load8_u (global.get $json_parse_ptr))
-          (i32.const 93)))
-    (then
-      (global.set $json_parse_ptr
-        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-      (return (call $prelude.arr_new (i32.const 0)))
-    )
+(func $json._re_is_word (param $b i32) (result i32)
+  (i32.or
+    (i32.or
+      (call $json._re_is_digit (local.get $b))
+      (i32.eq (local.get $b) (i32.const 95)))
+    (i32.or
+      (i32.and
+        (i32.ge_u (local.get $b) (i32.const 65))
+        (i32.le_u (local.get $b) (i32.const 90)))
+      (i32.and
+        (i32.ge_u (local.get $b) (i32.const 97))
+        (i32.le_u (local.get $b) (i32.const 122)))))
+)
+
+(func $json._re_is_space (param $b i32) (result i32)
+  (i32.or
+    (i32.eq (local.get $b) (i32.const 32))
+    (i32.and
+      (i32.ge_u (local.get $b) (i32.const 9))
+      (i32.le_u (local.get $b) (i32.const 13))))
+)
+
+;; \d \w \s \D \W \S なら 0/1、それ以外のエスケープなら -1
+(func $json._re_class_escape (param $e i32) (param $b i32) (result i32)
+  (if (i32.eq (local.get $e) (i32.const 100)) ;; d
+    (then (return (call $json._re_is_digit (local.get $b))))
+  )
+  (if (i32.eq (local.get $e) (i32.const 68)) ;; D
+    (then (return (i32.eqz (call $json._re_is_digit (local.get $b)))))
+  )
+  (if (i32.eq (local.get $e) (i32.const 119)) ;; w
+    (then (return (call $json._re_is_word (local.get $b))))
   )
+  (if (i32.eq (local.get $e) (i32.const 87)) ;; W
+    (then (return (i32.eqz (call $json._re_is_word (local.get $b)))))
+  )
+  (if (i32.eq (local.get $e) (i32.const 115)) ;; s
+    (then (return (call $json._re_is_space (local.get $b))))
+  )
+  (if (i32.eq (local.get $e) (i32.const 83)) ;; S
+    (then (return (i32.eqz (call $json._re_is_space (local.get $b)))))
+  )
+  (i32.const -1)
+)
 
-  (local.set $cap
-    (i32.sub (global.get $json_parse_end) (global.get $json_parse_ptr)))
-  (if (i32.lt_s (local.get $cap) (i32.const 1))
+;; パターンの $pi から始まる1つのアトムの終わりの位置
+(func $json._re_atom_end (param $pi i32) (result i32)
+  (local $c i32)
+  (local.set $c (call $json._re_pb (local.get $pi)))
+  (if (i32.eq (local.get $c) (i32.const 92)) ;; \
     (then
-      (local.set $cap (i32.const 1))
+      (return (i32.add (local.get $pi) (i32.const 2)))
     )
   )
-  (local.set $tmp (call $prelude.arr_new (local.get $cap)))
-  (local.set $count (i32.const 0))
-
-  (block $done
-    (loop $loop
-      (local.set $val (call $json._parse_value))
-      (if (global.get $json_parse_err)
-        (then
-          (return (call $prelude.val_undefined))
-        )
-      )
-      (call $prelude.arr_set (local.get $tmp) (local.get $count) (local.get $val))
-      (local.set $count (i32.add (local.get $count) (i32.const 1)))
-
-      (call $json._parse_skip_ws)
-      (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+  (if (i32.eq (local.get $c) (i32.const 91)) ;; [
+    (then
+      (local.set $pi (i32.add (local.get $pi) (i32.const 1)))
+      (if (i32.eq (call $json._re_pb (local.get $pi)) (i32.const 94)) ;; ^
         (then
-          (call $json._parse_fail_invalid)
-          (return (call $prelude.val_undefined))
+          (local.set $pi (i32.add (local.get $pi) (i32.const 1)))
         )
       )
-      (local.set $c (i32.load8_u (global.get $json_parse_ptr)))
-      (if (i32.eq (local.get $c) (i32.const 44))
-        (then
-          (global.set $json_parse_ptr
-            (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-          (call $json._parse_skip_ws)
+      (block $done
+        (loop $loop
+          (br_if $done (i32.ge_u (local.get $pi) (global.get $json_re_plen)))
+          (local.set $c (call $json._re_pb (local.get $pi)))
+          (br_if $done (i32.eq (local.get $c) (i32.const 93))) ;; ]
+          (if (i32.eq (local.get $c) (i32.const 92))
+            (then
+              (local.set $pi (i32.add (local.get $pi) (i32.const 1)))
+            )
+          )
+          (local.set $pi (i32.add (local.get $pi) (i32.const 1)))
           (br $loop)
         )
       )
-      (if (i32.eq (local.get $c) (i32.const 93))
-        (then
-          (global.set $json_parse_ptr
-            (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-          (br $done)
-        )
-      )
-
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
-    )
-  )
-
-  (local.set $out (call $prelude.arr_new (local.get $count)))
-  (local.set $i (i32.const 0))
-  (block $copy_done
-    (loop $copy
-      (br_if $copy_done (i32.ge_u (local.get $i) (local.get $count)))
-      (call $prelude.arr_set
-        (local.get $out)
-        (local.get $i)
-        (call $prelude.arr_get (local.get $tmp) (local.get $i)))
-      (local.set $i (i32.add (local.get $i) (i32.const 1)))
-      (br $copy)
-    )
-  )
-
-  (local.get $out)
-)
-
-(func $json._parse_object (result anyref)
-  (local $cap i32)
-  (local $keys anyref)
-  (local $vals anyref)
-  (local $obj anyref)
-  (local $count i32)
-  (local $i i32)
-  (local $j i32)
-  (local $c i32)
-  (local $key_i anyref)
-  (local $key_j anyref)
-  (local $tmp anyref)
-  (local $val_i anyref)
-  (local $val_j anyref)
-  (local $key anyref)
-  (local $val anyref)
-
-  (if (global.get $json_parse_err)
-    (then
-      (return (call $prelude.val_undefined))
+      (return (i32.add (local.get $pi) (i32.const 1)))
     )
   )
-  (if (i32.or
-        (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+  ;; リテラルは UTF-8 の1文字
+  (local.set $pi (i32.add (local.get $pi) (i32.const 1)))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $pi) (global.get $json_re_plen)))
+      (br_if $done
         (i32.ne
-          (i32.load8_u (global.get $json_parse_ptr))
-          (i32.const 123)))
-    (then
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
+          (i32.and (call $json._re_pb (local.get $pi)) (i32.const 192))
+          (i32.const 128)))
+      (local.set $pi (i32.add (local.get $pi) (i32.const 1)))
+      (br $loop)
     )
   )
+  (local.get $pi)
+)
 
-  (global.set $json_parse_ptr
-    (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-  (call $json._parse_skip_ws)
+;; アトム [$pi, $ae) を文字列の $si に照合し、読み進めた位置を返す（合わなければ -1）
+(func $json._re_atom (param $pi i32) (param $ae i32) (param $si i32) (result i32)
+  (local $c i32)
+  (local $b i32)
+  (local $e i32)
+  (local $r i32)
+  (local $k i32)
+  (local $neg i32)
+  (local $hit i32)
+  (local $close i32)
 
-  (if (i32.and
-        (i32.lt_u (global.get $json_parse_ptr) (global.get $json_parse_end))
-        (i32.eq
-          (i32.load8_u (global.get $json_parse_ptr))
-          (i32.const 125)))
+  (if (i32.ge_u (local.get $si) (global.get $json_re_slen))
     (then
-      (global.set $json_parse_ptr
-        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-      (return (call $prelude.obj_new (i32.const 0)))
+      (return (i32.const -1))
     )
   )
+  (local.set $c (call $json._re_pb (local.get $pi)))
+  (local.set $b (call $json._re_sb (local.get $si)))
 
-  (local.set $cap
-    (i32.sub (global.get $json_parse_end) (global.get $json_parse_ptr)))
-  (if (i32.lt_s (local.get $cap) (i32.const 1))
+  (if (i32.eq (local.get $c) (i32.const 46)) ;; .
     (then
-      (local.set $cap (i32.const 1))
+      (return (call $json._re_next_char (local.get $si)))
     )
   )
-  (local.set $keys (call $prelude.arr_new (local.get $cap)))
-  (local.set $vals (call $prelude.arr_new (local.get $cap)))
-  (local.set $count (i32.const 0))
 
-  (block $done
-    (loop $loop
-      (if (i32.or
-            (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
-            (i32.ne
-              (i32.load8_u (global.get $json_parse_ptr))
-              (i32.const 34)))
+  (if (i32.eq (local.get $c) (i32.const 92)) ;; \
+    (then
+      (local.set $e (call $json._re_pb (i32.add (local.get $pi) (i32.const 1))))
+      (local.set $r (call $json._re_class_escape (local.get $e) (local.get $b)))
+      (if (i32.ge_s (local.get $r) (i32.const 0))
         (then
-          (call $json._parse_fail_invalid)
-          (return (call $prelude.val_undefined))
+          (if (local.get $r)
+            (then
+              (return (call $json._re_next_char (local.get $si)))
+            )
+          )
+          (return (i32.const -1))
         )
       )
-
-      (local.set $key (call $json._parse_string))
-      (if (global.get $json_parse_err)
+      (if (i32.eq (local.get $b) (local.get $e))
         (then
-          (return (call $prelude.val_undefined))
+          (return (i32.add (local.get $si) (i32.const 1)))
         )
       )
+      (return (i32.const -1))
+    )
+  )
 
-      (call $json._parse_skip_ws)
-      (if (i32.or
-            (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
-            (i32.ne
-              (i32.load8_u (global.get $json_parse_ptr))
-              (i32.const 58)))
+  (if (i32.eq (local.get $c) (i32.const 91)) ;; [
+    (then
+      (local.set $k (i32.add (local.get $pi) (i32.const 1)))
+      (local.set $close (i32.sub (local.get $ae) (i32.const 1)))
+      (local.set $neg (i32.const 0))
+      (if (i32.eq (call $json._re_pb (local.get $k)) (i32.const 94)) ;; ^
         (then
-          (call $json._parse_fail_invalid)
-          (return (call $prelude.val_undefined))
+          (local.set $neg (i32.const 1))
+          (local.set $k (i32.add (local.get $k) (i32.const 1)))
         )
       )
-      (global.set $json_parse_ptr
-        (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-
-      (local.set $val (call $json._parse_value))
-      (if (global.get $json_parse_err)
-        (then
-          (return (call $prelude.val_undefined))
+      (local.set $hit (i32.const 0))
+      (block $done
+        (loop $loop
+          (br_if $done (i32.ge_u (local.get $k) (local.get $close)))
+          (local.set $c (call $json._re_pb (local.get $k)))
+          (if (i32.eq (local.get $c) (i32.const 92))
+            (then
+              (local.set $e (call $json._re_pb (i32.add (local.get $k) (i32.const 1))))
+              (local.set $r (call $json._re_class_escape (local.get $e) (local.get $b)))
+              (if (i32.lt_s (local.get $r) (i32.const 0))
+                (then
+                  (local.set $r (i32.eq (local.get $b) (local.get $e)))
+                )
+              )
+              (if (local.get $r)
+                (then
+                  (local.set $hit (i32.const 1))
+                )
+              )
+              (local.set $k (i32.add (local.get $k) (i32.const 2)))
+              (br $loop)
+            )
+          )
+          (if (i32.and
+                (i32.lt_u (i32.add (local.get $k) (i32.const 2)) (local.get $close))
+                (i32.eq (call $json._re_pb (i32.add (local.get $k) (i32.const 1))) (i32.const 45))) ;; -
+            (then
+              (if (i32.and
+                    (i32.ge_u (local.get $b) (local.get $c))
+                    (i32.le_u (local.get $b) (call $json._re_pb (i32.add (local.get $k) (i32.const 2)))))
+                (then
+                  (local.set $hit (i32.const 1))
+                )
+              )
+              (local.set $k (i32.add (local.get $k) (i32.const 3)))
+              (br $loop)
+            )
+          )
+          (if (i32.eq (local.get $b) (local.get $c))
+            (then
+              (local.set $hit (i32.const 1))
+            )
+          )
+          (local.set $k (i32.add (local.get $k) (i32.const 1)))
+          (br $loop)
         )
       )
-
-      (call $prelude.arr_set (local.get $keys) (local.get $count) (local.get $key))
-      (call $prelude.arr_set (local.get $vals) (local.get $count) (local.get $val))
-      (local.set $count (i32.add (local.get $count) (i32.const 1)))
-
-      (call $json._parse_skip_ws)
-      (if (i32.ge_u (global.get $json_parse_ptr) (global.get $json_parse_end))
+      (if (i32.eq (local.get $hit) (local.get $neg))
         (then
-          (call $json._parse_fail_invalid)
-          (return (call $prelude.val_undefined))
+          (return (i32.const -1))
         )
       )
-      (local.set $c (i32.load8_u (global.get $json_parse_ptr)))
-      (if (i32.eq (local.get $c) (i32.const 44))
+      (return (call $json._re_next_char (local.get $si)))
+    )
+  )
+
+  ;; リテラル
+  (local.set $k (local.get $pi))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $k) (local.get $ae)))
+      (local.set $e (i32.add (local.get $si) (i32.sub (local.get $k) (local.get $pi))))
+      (if (i32.ge_u (local.get $e) (global.get $json_re_slen))
         (then
-          (global.set $json_parse_ptr
-            (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-          (call $json._parse_skip_ws)
-          (br $loop)
+          (return (i32.const -1))
         )
       )
-      (if (i32.eq (local.get $c) (i32.const 125))
+      (if (i32.ne (call $json._re_sb (local.get $e)) (call $json._re_pb (local.get $k)))
         (then
-          (global.set $json_parse_ptr
-            (i32.add (global.get $json_parse_ptr) (i32.const 1)))
-          (br $done)
+          (return (i32.const -1))
         )
       )
-
-      (call $json._parse_fail_invalid)
-      (return (call $prelude.val_undefined))
+      (local.set $k (i32.add (local.get $k) (i32.const 1)))
+      (br $loop)
     )
   )
+  (i32.add (local.get $si) (i32.sub (local.get $ae) (local.get $pi)))
+)
 
-  ;; Keep toJSON output deterministic: object keys are sorted.
-  (local.set $i (i32.const 0))
-  (block $sort_i_done
-    (loop $sort_i
-      (br_if $sort_i_done (i32.ge_u (local.get $i) (local.get $count)))
-      (local.set $j (i32.add (local.get $i) (i32.const 1)))
-      (block $sort_j_done
-        (loop $sort_j
-          (br_if $sort_j_done (i32.ge_u (local.get $j) (local.get $count)))
-          (local.set $key_i (call $prelude.arr_get (local.get $keys) (local.get $i)))
-          (local.set $key_j (call $prelude.arr_get (local.get $keys) (local.get $j)))
-          (if (i32.gt_s (call $json._str_cmp (local.get $key_i) (local.get $key_j)) (i32.const 0))
-            (then
-              (local.set $tmp (local.get $key_i))
-              (call $prelude.arr_set (local.get $keys) (local.get $i) (local.get $key_j))
-              (call $prelude.arr_set (local.get $keys) (local.get $j) (local.get $tmp))
-
-              (local.set $val_i (call $prelude.arr_get (local.get $vals) (local.get $i)))
-              (local.set $val_j (call $prelude.arr_get (local.get $vals) (local.get $j)))
-              (call $prelude.arr_set (local.get $vals) (local.get $i) (local.get $val_j))
-              (call $prelude.arr_set (local.get $vals) (local.get $j) (local.get $val_i))
-            )
-          )
-          (local.set $j (i32.add (local.get $j) (i32.const 1)))
-          (br $sort_j)
+;; アトム [$pi, $ae) の最長一致の繰り返し（$min 回以上）の後に、パターンの残りを照合する
+(func $json._re_star (param $pi i32) (param $ae i32) (param $si i32) (param $min i32) (result i32)
+  (local $next i32)
+  (local $rest i32)
+  (local.set $next (call $json._re_atom (local.get $pi) (local.get $ae) (local.get $si)))
+  (if (i32.ge_s (local.get $next) (i32.const 0))
+    (then
+      (local.set $rest (i32.sub (local.get $min) (i32.const 1)))
+      (if (i32.lt_s (local.get $rest) (i32.const 0))
+        (then
+          (local.set $rest (i32.const 0))
+        )
+      )
+      (if (call $json._re_star (local.get $pi) (local.get $ae) (local.get $next) (local.get $rest))
+        (then
+          (return (i32.const 1))
         )
       )
-      (local.set $i (i32.add (local.get $i) (i32.const 1)))
-      (br $sort_i)
     )
   )
-
-  (local.set $obj (call $prelude.obj_new (local.get $count)))
-  (local.set $i (i32.const 0))
-  (block $build_done
-    (loop $build
-      (br_if $build_done (i32.ge_u (local.get $i) (local.get $count)))
-      (call $prelude.obj_set
-        (local.get $obj)
-        (call $prelude.arr_get (local.get $keys) (local.get $i))
-        (call $prelude.arr_get (local.get $vals) (local.get $i)))
-      (local.set $i (i32.add (local.get $i) (i32.const 1)))
-      (br $build)
+  (if (i32.gt_s (local.get $min) (i32.const 0))
+    (then
+      (return (i32.const 0))
     )
   )
-
-  (local.get $obj)
+  (call $json._re_here (i32.add (local.get $ae) (i32.const 1)) (local.get $si))
 )
 
-(func $json.toJSON (param $text anyref) (result anyref)
-  (local $kind i32)
-  (local $ptr i32)
-  (local $len i32)
-  (local $value anyref)
-  (local $msg anyref)
+;; パターンの $pi 以降が文字列の $si から一致するか
+(func $json._re_here (param $pi i32) (param $si i32) (result i32)
+  (local $ae i32)
+  (local $q i32)
+  (local $next i32)
 
-  (local.set $kind (call $prelude.val_kind (local.get $text)))
-  (if (i32.ne (local.get $kind) (i32.const 3))
+  (if (i32.ge_u (local.get $pi) (global.get $json_re_plen))
     (then
-      (return (call $json._error_from_msg (call $json._msg_toJSON_expects_string)))
+      (return (i32.const 1))
     )
   )
-
-  (local.set $ptr (call $prelude._string_ptr (local.get $text)))
-  (local.set $len (call $prelude._string_bytelen (local.get $text)))
-  (call $json._parse_reset (local.get $ptr) (local.get $len))
-  (call $json._parse_skip_ws)
-  (local.set $value (call $json._parse_value))
-
-  (if (i32.eqz (global.get $json_parse_err))
+  (if (i32.and
+        (i32.eq (call $json._re_pb (local.get $pi)) (i32.const 36)) ;; $
+        (i32.eq (i32.add (local.get $pi) (i32.const 1)) (global.get $json_re_plen)))
     (then
-      (call $json._parse_skip_ws)
-      (if (i32.ne (global.get $json_parse_ptr) (global.get $json_parse_end))
-        (then
-          (call $json._parse_fail_invalid)
-        )
-      )
+      (return (i32.eq (local.get $si) (global.get $json_re_slen)))
     )
   )
 
-  (if (global.get $json_parse_err)
+  (local.set $ae (call $json._re_atom_end (local.get $pi)))
+  (local.set $q (i32.const 0))
+  (if (i32.lt_u (local.get $ae) (global.get $json_re_plen))
     (then
-      (local.set $msg (global.get $json_parse_err_msg))
-      (if (ref.is_null (local.get $msg))
+      (local.set $q (call $json._re_pb (local.get $ae)))
+    )
+  )
+  (if (i32.eq (local.get $q) (i32.const 42)) ;; *
+    (then
+      (return (call $json._re_star (local.get $pi) (local.get $ae) (local.get $si) (i32.const 0)))
+    )
+  )
+  (if (i32.eq (local.get $q) (i32.const 43)) ;; +
+    (then
+      (return (call $json._re_star (local.get $pi) (local.get $ae) (local.get $si) (i32.const 1)))
+    )
+  )
+  (if (i32.eq (local.get $q) (i32.const 63)) ;; ?
+    (then
+      (local.set $next (call $json._re_atom (local.get $pi) (local.get $ae) (local.get $si)))
+      (if (i32.ge_s (local.get $next) (i32.const 0))
         (then
-          (local.set $msg (call $json._msg_invalid_json))
+          (if (call $json._re_here (i32.add (local.get $ae) (i32.const 1)) (local.get $next))
+            (then
+              (return (i32.const 1))
+            )
+          )
         )
       )
-      (return (call $json._error_from_msg (local.get $msg)))
+      (return (call $json._re_here (i32.add (local.get $ae) (i32.const 1)) (local.get $si)))
     )
   )
 
-  (local.get $value)
+  (local.set $next (call $json._re_atom (local.get $pi) (local.get $ae) (local.get $si)))
+  (if (i32.lt_s (local.get $next) (i32.const 0))
+    (then
+      (return (i32.const 0))
+    )
+  )
+  (call $json._re_here (local.get $ae) (local.get $next))
 )
 
-(func $json.parse (param $text anyref) (param $schema anyref) (result anyref)
-  (local $parsed anyref)
+;; 文字列 $value のどこかが $pattern に一致するか（^ / $ で位置を固定できる）
+(func $json._re_match (param $pattern anyref) (param $value anyref) (result i32)
+  (local $si i32)
+  (global.set $json_re_p (call $prelude._string_ptr (local.get $pattern)))
+  (global.set $json_re_plen (call $prelude._string_bytelen (local.get $pattern)))
+  (global.set $json_re_s (call $prelude._string_ptr (local.get $value)))
+  (global.set $json_re_slen (call $prelude._string_bytelen (local.get $value)))
 
-  (local.set $parsed (call $json.toJSON (local.get $text)))
-  (if (call $json._is_error_object (local.get $parsed))
+  (if (i32.and
+        (i32.gt_u (global.get $json_re_plen) (i32.const 0))
+        (i32.eq (call $json._re_pb (i32.const 0)) (i32.const 94))) ;; ^
     (then
-      (return (local.get $parsed))
+      (return (call $json._re_here (i32.const 1) (i32.const 0)))
     )
   )
-
-  (call $json.decode (local.get $parsed) (local.get $schema))
+  (local.set $si (i32.const 0))
+  (block $done
+    (loop $loop
+      (if (call $json._re_here (i32.const 0) (local.get $si))
+        (then
+          (return (i32.const 1))
+        )
+      )
+      (br_if $done (i32.ge_u (local.get $si) (global.get $json_re_slen)))
+      (local.set $si (call $json._re_next_char (local.get $si)))
+      (br $loop)
+    )
+  )
+  (i32.const 0)
 )
 
 (func $json._decode_array (param $value anyref) (param $schema anyref) (param $path anyref) (result anyref)
+  (local $failed i32)
   (local $elem_schema anyref)
   (local $len i32)
   (local $i i32)
@@ -2838,7 +4297,12 @@
           (local.get $child_path)))
       (if (global.get $json_decode_err)
         (then
-          (return (call $prelude.val_undefined))
+          (if (i32.eqz (call $json._decode_continue))
+            (then
+              (return (call $prelude.val_undefined))
+            )
+          )
+          (local.set $failed (i32.const 1))
         )
       )
       (call $prelude.arr_set (local.get $out) (local.get $i) (local.get $decoded))
@@ -2846,10 +4310,17 @@
       (br $loop)
     )
   )
+  (if (local.get $failed)
+    (then
+      (global.set $json_decode_err (i32.const 1))
+      (return (call $prelude.val_undefined))
+    )
+  )
   (local.get $out)
 )
 
 (func $json._decode_tuple (param $value anyref) (param $schema anyref) (param $path anyref) (result anyref)
+  (local $failed i32)
   (local $tuple_schema anyref)
   (local $len i32)
   (local $out anyref)
@@ -2915,7 +4386,12 @@
           (local.get $child_path)))
       (if (global.get $json_decode_err)
         (then
-          (return (call $prelude.val_undefined))
+          (if (i32.eqz (call $json._decode_continue))
+            (then
+              (return (call $prelude.val_undefined))
+            )
+          )
+          (local.set $failed (i32.const 1))
         )
       )
       (call $prelude.arr_set (local.get $out) (local.get $i) (local.get $decoded))
@@ -2923,10 +4399,17 @@
       (br $loop)
     )
   )
+  (if (local.get $failed)
+    (then
+      (global.set $json_decode_err (i32.const 1))
+      (return (call $prelude.val_undefined))
+    )
+  )
   (local.get $out)
 )
 
 (func $json._decode_object (param $value anyref) (param $schema anyref) (param $path anyref) (result anyref)
+  (local $failed i32)
   (local $props anyref)
   (local $index_schema anyref)
   (local $has_index i32)
@@ -3073,22 +4556,39 @@
               (local.get $child)
               (local.get $typ)
               (local.get $name_path)))
-          (if (global.get $json_decode_err)
-            (then
-              (return (call $prelude.val_undefined))
-            )
-          )
         )
         (else
-          (if (call $json._schema_allows_undefined (local.get $typ))
+          (if (call $json._obj_has_key (local.get $prop) (call $json._k_default))
             (then
-              (local.set $decoded (call $prelude.val_undefined))
+              ;; key: T = value のデフォルト値も型に合わせて decode する（i64 / f64 の区別など）
+              (local.set $decoded
+                (call $json._decode_with_schema
+                  (call $prelude.obj_get (local.get $prop) (call $json._k_default))
+                  (local.get $typ)
+                  (local.get $name_path)))
             )
             (else
-              (call $json._decode_error_at (local.get $name_path) (call $json._msg_missing_field))
+              (if (call $json._schema_allows_undefined (local.get $typ))
+                (then
+                  (local.set $decoded (call $prelude.val_undefined))
+                )
+                (else
+                  (call $json._decode_error_at (local.get $name_path) (call $json._msg_missing_field))
+                  (local.set $decoded (call $prelude.val_undefined))
+                )
+              )
+            )
+          )
+        )
+      )
+      (if (global.get $json_decode_err)
+        (then
+          (if (i32.eqz (call $json._decode_continue))
+            (then
               (return (call $prelude.val_undefined))
             )
           )
+          (local.set $failed (i32.const 1))
         )
       )
 
@@ -3137,7 +4637,12 @@
                   (local.get $name_path)))
               (if (global.get $json_decode_err)
                 (then
-                  (return (call $prelude.val_undefined))
+                  (if (i32.eqz (call $json._decode_continue))
+                    (then
+                      (return (call $prelude.val_undefined))
+                    )
+                  )
+                  (local.set $failed (i32.const 1))
                 )
               )
               (call $prelude.arr_set (local.get $out_keys) (local.get $out_count) (local.get $key))
@@ -3152,6 +4657,13 @@
     )
   )
 
+  (if (local.get $failed)
+    (then
+      (global.set $json_decode_err (i32.const 1))
+      (return (call $prelude.val_undefined))
+    )
+  )
+
   ;; Sort output keys for deterministic order
   (local.set $i (i32.const 0))
   (block $sort_i_done
@@ -3218,6 +4730,9 @@
   (local $member anyref)
   (local $decoded anyref)
   (local $last_msg anyref)
+  (local $last_path anyref)
+  (local $last_reason anyref)
+  (local $collect i32)
   (local $k i32)
 
   (if (global.get $json_decode_err)
@@ -3326,6 +4841,11 @@
           )
         )
       )
+      (call $json._decode_check_length
+        (call $prelude.str_len (local.get $value))
+        (local.get $schema)
+        (local.get $path))
+      (call $json._decode_check_pattern (local.get $value) (local.get $schema) (local.get $path))
       (return (local.get $value))
     )
   )
@@ -3413,6 +4933,7 @@
           )
         )
       )
+      (call $json._decode_check_range (local.get $out) (local.get $schema) (local.get $path))
       (return (local.get $out))
     )
   )
@@ -3462,6 +4983,7 @@
           )
         )
       )
+      (call $json._decode_check_range (local.get $out) (local.get $schema) (local.get $path))
       (return (local.get $out))
     )
   )
@@ -3469,7 +4991,16 @@
   ;; array
   (if (i32.eq (local.get $kind) (i32.const 8))
     (then
-      (return (call $json._decode_array (local.get $value) (local.get $schema) (local.get $path)))
+      (local.set $out (call $json._decode_array (local.get $value) (local.get $schema) (local.get $path)))
+      (if (i32.eq (call $prelude.val_kind (local.get $value)) (i32.const 5))
+        (then
+          (call $json._decode_check_length
+            (i64.extend_i32_u (call $prelude.arr_len (local.get $value)))
+            (local.get $schema)
+            (local.get $path))
+        )
+      )
+      (return (local.get $out))
     )
   )
 
@@ -3514,6 +5045,9 @@
         )
       )
 
+      ;; メンバーごとの試行は最初のエラーで止め、すべて失敗したら最後のエラーだけを報告する
+      (local.set $collect (global.get $json_decode_collect))
+      (global.set $json_decode_collect (i32.const 0))
       (local.set $last_msg (ref.null any))
       (local.set $union_len (call $prelude.arr_len (local.get $union)))
       (local.set $i (i32.const 0))
@@ -3521,8 +5055,7 @@
         (loop $union_loop
           (br_if $union_done (i32.ge_u (local.get $i) (local.get $union_len)))
           (local.set $member (call $prelude.arr_get (local.get $union) (local.get $i)))
-          (global.set $json_decode_err (i32.const 0))
-          (global.set $json_decode_err_msg (ref.null any))
+          (call $json._decode_reset)
           (local.set $decoded
             (call $json._decode_with_schema
               (local.get $value)
@@ -3530,21 +5063,35 @@
               (local.get $path)))
           (if (i32.eqz (global.get $json_decode_err))
             (then
+              (global.set $json_decode_collect (local.get $collect))
               (return (local.get $decoded))
             )
           )
           (local.set $last_msg (global.get $json_decode_err_msg))
+          (local.set $last_path (global.get $json_decode_err_path))
+          (local.set $last_reason (global.get $json_decode_err_reason))
           (local.set $i (i32.add (local.get $i) (i32.const 1)))
           (br $union_loop)
         )
       )
 
+      (global.set $json_decode_collect (local.get $collect))
       (if (ref.is_null (local.get $last_msg))
         (then
           (call $json._decode_error_at (local.get $path) (call $json._msg_union_expected))
         )
         (else
-          (call $json._decode_force_error (local.get $last_msg))
+          (if (local.get $collect)
+            (then
+              (global.set $json_decode_err (i32.const 0))
+              (call $json._decode_error_at (local.get $last_path) (local.get $last_reason))
+            )
+            (else
+              (call $json._decode_force_error (local.get $last_msg))
+              (global.set $json_decode_err_path (local.get $last_path))
+              (global.set $json_decode_err_reason (local.get $last_reason))
+            )
+          )
         )
       )
       (return (call $prelude.val_undefined))
@@ -3601,3 +5148,388 @@
 
   (local.get $decoded)
 )
+
+;; decode_all は decode と同じ schema を使い、最初のエラーで止まらずにすべての { path, message } を
+;; errors に集めた DecodeErrors を返す。
+(func $json.decode_all (param $json anyref) (param $schema anyref) (result anyref)
+  (local $schema_parsed anyref)
+  (local $decoded anyref)
+
+  (call $json._decode_reset)
+  (global.set $json_decode_collect (i32.const 1))
+  (global.set $json_decode_issues (call $prelude.arr_new (i32.const 4)))
+  (global.set $json_decode_issue_count (i32.const 0))
+
+  (block $run
+    (if (i32.ne (call $prelude.val_kind (local.get $schema)) (i32.const 3))
+      (then
+        (call $json._decode_error_at
+          (call $json._str_dollar)
+          (call $json._msg_decode_expects_schema_string))
+        (br $run)
+      )
+    )
+    (local.set $schema_parsed (call $json.toJSON (local.get $schema)))
+    (if (i32.or
+          (call $json._is_error_object (local.get $schema_parsed))
+          (i32.ne (call $prelude.val_kind (local.get $schema_parsed)) (i32.const 4)))
+      (then
+        (call $json._decode_invalid_schema_at (call $json._str_dollar))
+        (br $run)
+      )
+    )
+    (local.set $decoded
+      (call $json._decode_with_schema
+        (local.get $json)
+        (local.get $schema_parsed)
+        (call $json._str_dollar)))
+  )
+
+  (global.set $json_decode_collect (i32.const 0))
+  (if (global.get $json_decode_err)
+    (then
+      (return (call $json._decode_errors_value))
+    )
+  )
+  (global.set $json_decode_issues (ref.null any))
+  (local.get $decoded)
+)
+
+;; ---- json の探索と組み立て ----
+
+(func $json._kind_name (param $offset i32) (param $len i32) (result anyref)
+  (local $ptr i32)
+  (local.set $ptr (call $prelude._alloc (local.get $len)))
+  (memory.init $json_d_kind_names (local.get $ptr) (local.get $offset) (local.get $len))
+  (call $prelude._new_string_owned (local.get $ptr) (local.get $len))
+)
+
+(func $json.json_kind (param $j anyref) (result anyref)
+  (local $kind i32)
+  (local.set $kind (call $prelude.val_kind (local.get $j)))
+  (if (i32.eq (local.get $kind) (i32.const 0))
+    (then
+      (return (call $json._kind_name (i32.const 11) (i32.const 3)))
+    )
+  )
+  (if (i32.eq (local.get $kind) (i32.const 1))
+    (then
+      (return (call $json._kind_name (i32.const 14) (i32.const 3)))
+    )
+  )
+  (if (i32.eq (local.get $kind) (i32.const 2))
+    (then
+      (return (call $json._kind_name (i32.const 4) (i32.const 7)))
+    )
+  )
+  (if (i32.eq (local.get $kind) (i32.const 3))
+    (then
+      (return (call $json._kind_name (i32.const 17) (i32.const 6)))
+    )
+  )
+  (if (i32.eq (local.get $kind) (i32.const 4))
+    (then
+      (return (call $json._kind_name (i32.const 28) (i32.const 6)))
+    )
+  )
+  (if (i32.eq (local.get $kind) (i32.const 5))
+    (then
+      (return (call $json._kind_name (i32.const 23) (i32.const 5)))
+    )
+  )
+  (call $json._kind_name (i32.const 0) (i32.const 4))
+)
+
+;; path をたどって値を返す。キーは "." / "[" までの文字列、添字は "[n]"。
+(func $json.json_get (param $j anyref) (param $path anyref) (result anyref)
+  (local $ptr i32)
+  (local $len i32)
+  (local $i i32)
+  (local $start i32)
+  (local $c i32)
+  (local $index i32)
+  (local $cur anyref)
+  (local $key anyref)
+
+  (local.set $ptr (call $prelude._string_ptr (local.get $path)))
+  (local.set $len (call $prelude._string_bytelen (local.get $path)))
+  (local.set $cur (local.get $j))
+  (local.set $i (i32.const 0))
+  (if
+    (i32.and
+      (i32.gt_u (local.get $len) (i32.const 0))
+      (i32.eq (i32.load8_u (local.get $ptr)) (i32.const 36))) ;; $
+    (then
+      (local.set $i (i32.const 1))
+    )
+  )
+
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+      (local.set $c (i32.load8_u (i32.add (local.get $ptr) (local.get $i))))
+
+      (if (i32.eq (local.get $c) (i32.const 91)) ;; [
+        (then
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (local.set $start (local.get $i))
+          (local.set $index (i32.const 0))
+          (block $digits_done
+            (loop $digits
+              (br_if $digits_done (i32.ge_u (local.get $i) (local.get $len)))
+              (local.set $c (i32.load8_u (i32.add (local.get $ptr) (local.get $i))))
+              (br_if $digits_done (i32.eqz (call $json._is_digit (local.get $c))))
+              ;; 配列の長さを超える添字はどのみち見つからない
+              (if (i32.gt_u (local.get $index) (i32.const 100000000))
+                (then
+                  (return (call $prelude.val_undefined))
+                )
+              )
+              (local.set $index
+                (i32.add
+                  (i32.mul (local.get $index) (i32.const 10))
+                  (i32.sub (local.get $c) (i32.const 48))))
+              (local.set $i (i32.add (local.get $i) (i32.const 1)))
+              (br $digits)
+            )
+          )
+          (if
+            (i32.or
+              (i32.eq (local.get $i) (local.get $start))
+              (i32.or
+                (i32.ge_u (local.get $i) (local.get $len))
+                (i32.ne (i32.load8_u (i32.add (local.get $ptr) (local.get $i))) (i32.const 93)))) ;; ]
+            (then
+              (return (call $prelude.val_undefined))
+            )
+          )
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (if
+            (i32.or
+              (i32.ne (call $prelude.val_kind (local.get $cur)) (i32.const 5))
+              (i32.ge_u (local.get $index) (call $prelude.arr_len (local.get $cur))))
+            (then
+              (return (call $prelude.val_undefined))
+            )
+          )
+          (local.set $cur (call $prelude.arr_get (local.get $cur) (local.get $index)))
+          (br $loop)
+        )
+      )
+
+      (if (i32.eq (local.get $c) (i32.const 46)) ;; .
+        (then
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        )
+      )
+      (local.set $start (local.get $i))
+      (block $key_done
+        (loop $key_loop
+          (br_if $key_done (i32.ge_u (local.get $i) (local.get $len)))
+          (local.set $c (i32.load8_u (i32.add (local.get $ptr) (local.get $i))))
+          (br_if $key_done
+            (i32.or
+              (i32.eq (local.get $c) (i32.const 46))
+              (i32.eq (local.get $c) (i32.const 91))))
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $key_loop)
+        )
+      )
+      (if (i32.eq (local.get $i) (local.get $start))
+        (then
+          (return (call $prelude.val_undefined))
+        )
+      )
+      (local.set $key
+        (call $prelude._new_string_copy
+          (i32.add (local.get $ptr) (local.get $start))
+          (i32.sub (local.get $i) (local.get $start))))
+      (if (i32.eqz (call $json._obj_has_key (local.get $cur) (local.get $key)))
+        (then
+          (return (call $prelude.val_undefined))
+        )
+      )
+      (local.set $cur (call $prelude.obj_get (local.get $cur) (local.get $key)))
+      (br $loop)
+    )
+  )
+
+  (if (i32.eq (call $prelude.val_kind (local.get $cur)) (i32.const 7))
+    (then
+      (return (call $prelude.val_undefined))
+    )
+  )
+  (local.get $cur)
+)
+
+(func $json.json_keys (param $j anyref) (result anyref)
+  (call $prelude.obj_keys (local.get $j))
+)
+
+(func $json.as_string (param $j anyref) (result anyref)
+  (if (i32.eq (call $prelude.val_kind (local.get $j)) (i32.const 3))
+    (then
+      (return (local.get $j))
+    )
+  )
+  (call $json._error_from_msg (call $json._msg_string_expected))
+)
+
+(func $json.as_i64 (param $j anyref) (result anyref)
+  (if (i32.eqz (call $prelude.val_kind (local.get $j)))
+    (then
+      (return (local.get $j))
+    )
+  )
+  (call $json._error_from_msg (call $json._msg_integer_expected))
+)
+
+(func $json.as_f64 (param $j anyref) (result anyref)
+  (local $kind i32)
+  (local.set $kind (call $prelude.val_kind (local.get $j)))
+  (if (i32.eq (local.get $kind) (i32.const 1))
+    (then
+      (return (local.get $j))
+    )
+  )
+  (if (i32.eqz (local.get $kind))
+    (then
+      (return
+        (call $prelude.val_from_f64
+          (f64.convert_i64_s (call $prelude.val_to_i64 (local.get $j)))))
+    )
+  )
+  (call $json._error_from_msg (call $json._msg_number_expected))
+)
+
+(func $json.as_bool (param $j anyref) (result anyref)
+  (if (i32.eq (call $prelude.val_kind (local.get $j)) (i32.const 2))
+    (then
+      (return (local.get $j))
+    )
+  )
+  (call $json._error_from_msg (call $json._msg_boolean_expected))
+)
+
+(func $json.as_array (param $j anyref) (result anyref)
+  (if (i32.eq (call $prelude.val_kind (local.get $j)) (i32.const 5))
+    (then
+      (return (local.get $j))
+    )
+  )
+  (call $json._error_from_msg (call $json._msg_array_expected))
+)
+
+(func $json.as_object (param $j anyref) (result anyref)
+  (if (i32.eq (call $prelude.val_kind (local.get $j)) (i32.const 4))
+    (then
+      (return (local.get $j))
+    )
+  )
+  (call $json._error_from_msg (call $json._msg_object_expected))
+)
+
+(func $json.json_from (param $value anyref) (result anyref)
+  (local $kind i32)
+  (local $len i32)
+  (local $i i32)
+  (local $out anyref)
+  (local $keys anyref)
+  (local $key anyref)
+  (local $v anyref)
+
+  (local.set $kind (call $prelude.val_kind (local.get $value)))
+  (if (i32.eq (local.get $kind) (i32.const 7))
+    (then
+      (return (call $prelude.val_null))
+    )
+  )
+  (if (i32.eq (local.get $kind) (i32.const 5))
+    (then
+      (local.set $len (call $prelude.arr_len (local.get $value)))
+      (local.set $out (call $prelude.arr_new (local.get $len)))
+      (local.set $i (i32.const 0))
+      (block $arr_done
+        (loop $arr_loop
+          (br_if $arr_done (i32.ge_u (local.get $i) (local.get $len)))
+          (call $prelude.arr_set
+            (local.get $out)
+            (local.get $i)
+            (call $json.json_from (call $prelude.arr_get (local.get $value) (local.get $i))))
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $arr_loop)
+        )
+      )
+      (return (local.get $out))
+    )
+  )
+  (if (i32.eq (local.get $kind) (i32.const 4))
+    (then
+      (local.set $keys (call $prelude.obj_keys (local.get $value)))
+      (local.set $len (call $prelude.arr_len (local.get $keys)))
+      (local.set $out (call $prelude.obj_new (local.get $len)))
+      (local.set $i (i32.const 0))
+      (block $obj_done
+        (loop $obj_loop
+          (br_if $obj_done (i32.ge_u (local.get $i) (local.get $len)))
+          (local.set $key (call $prelude.arr_get (local.get $keys) (local.get $i)))
+          (local.set $v (call $prelude.obj_get (local.get $value) (local.get $key)))
+          ;; stringify と同じく undefined のプロパティは除く
+          (if (i32.ne (call $prelude.val_kind (local.get $v)) (i32.const 7))
+            (then
+              (call $prelude.obj_set
+                (local.get $out)
+                (local.get $key)
+                (call $json.json_from (local.get $v)))
+            )
+          )
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $obj_loop)
+        )
+      )
+      (return (local.get $out))
+    )
+  )
+  (local.get $value)
+)
+
+(func $json.json_object (param $entries anyref) (result anyref)
+  (local.get $entries)
+)
+
+(func $json.json_array (param $items anyref) (result anyref)
+  (local.get $items)
+)
+
+(func $json.json_set (param $target anyref) (param $key anyref) (param $value anyref) (result anyref)
+  (local $keys anyref)
+  (local $len i32)
+  (local $i i32)
+  (local $k anyref)
+  (local $out anyref)
+
+  (if (i32.ne (call $prelude.val_kind (local.get $target)) (i32.const 4))
+    (then
+      (return (call $json._error_from_msg (call $json._msg_object_expected)))
+    )
+  )
+  (local.set $keys (call $prelude.obj_keys (local.get $target)))
+  (local.set $len (call $prelude.arr_len (local.get $keys)))
+  (local.set $out (call $prelude.obj_new (i32.add (local.get $len) (i32.const 1))))
+  (local.set $i (i32.const 0))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+      (local.set $k (call $prelude.arr_get (local.get $keys) (local.get $i)))
+      (call $prelude.obj_set
+        (local.get $out)
+        (local.get $k)
+        (call $prelude.obj_get (local.get $target) (local.get $k)))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  ;; 既存のキーは位置を保ったまま上書きする
+  (call $prelude.obj_set (local.get $out) (local.get $key) (local.get $value))
+  (local.get $out)
+)
@@ -65,6 +65,8 @@
 (data $d_message_key "message")
 (data $d_stacktrace_key "stacktrace")
 (data $d_index_out_of_range "index out of range")
+(data $d_report_error "error: ")
+(data $d_report_frame "    at ")
 (data $d_html_amp "&amp;")
 (data $d_html_lt "&lt;")
 (data $d_html_gt "&gt;")
@@ -318,6 +320,10 @@
 )
 
 (func $prelude._write_bytes (param $ptr i32) (param $len i32)
+  (call $prelude._write_fd (i32.const 1) (local.get $ptr) (local.get $len))
+)
+
+(func $prelude._write_fd (param $fd i32) (param $ptr i32) (param $len i32)
   (call $prelude._ensure_runtime)
   (i32.store (global.get $io_buf) (local.get $ptr))
   (i32.store
@@ -325,12 +331,65 @@
     (local.get $len))
   (drop
     (call $wasi.fd_write
-      (i32.const 1)
+      (local.get $fd)
       (global.get $io_buf)
       (i32.const 1)
       (i32.add (global.get $io_buf) (i32.const 8))))
 )
 
+(func $prelude._write_fd_string (param $fd i32) (param $text anyref)
+  (call $prelude._write_fd
+    (local.get $fd)
+    (call $prelude._string_ptr (local.get $text))
+    (call $prelude._string_bytelen (local.get $text)))
+)
+
+;; error の message と stacktrace を fd 2（stderr）に書く。
+;;   error: <message>
+;;       at <frame>
+(func $prelude._report_error (param $err anyref)
+  (local $buf i32)
+  (local $frames anyref)
+  (local $count i32)
+  (local $i i32)
+  (call $prelude._ensure_runtime)
+  (local.set $buf (call $prelude._alloc (i32.const 14)))
+  (memory.init $d_report_error (local.get $buf) (i32.const 0) (i32.const 7))
+  (memory.init $d_report_frame (i32.add (local.get $buf) (i32.const 7)) (i32.const 0) (i32.const 7))
+  (call $prelude._write_fd (i32.const 2) (local.get $buf) (i32.const 7))
+  (call $prelude._write_fd_string
+    (i32.const 2)
+    (call $prelude.to_string
+      (call $prelude.obj_get (local.get $err) (global.get $const_message_key))))
+  (call $prelude._write_fd_string (i32.const 2) (global.get $const_newline))
+  (local.set $frames (call $prelude.obj_get (local.get $err) (global.get $const_stacktrace_key)))
+  (if (i32.eqz (ref.test (ref $Arr) (local.get $frames)))
+    (then (return))
+  )
+  (local.set $count (call $prelude.arr_len (local.get $frames)))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $count)))
+      (call $prelude._write_fd (i32.const 2) (i32.add (local.get $buf) (i32.const 7)) (i32.const 7))
+      (call $prelude._write_fd_string
+        (i32.const 2)
+        (call $prelude.to_string (call $prelude.arr_get (local.get $frames) (local.get $i))))
+      (call $prelude._write_fd_string (i32.const 2) (global.get $const_newline))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+)
+
+;; main の (void | error) の結果が error なら stderr に書いて 1 を返す。
+(func $prelude._report_main_result (param $result anyref) (result i32)
+  (if (i32.eqz (ref.test (ref $Obj) (local.get $result)))
+    (then (return (i32.const 0)))
+  )
+  (call $prelude._report_error (local.get $result))
+  (i32.const 1)
+)
+
 (func $prelude._i64_to_string (param $v i64) (result anyref)
   (local $buf i32)
   (local $len i32)
@@ -510,6 +569,15 @@
     (call $prelude._string_bytelen (global.get $const_newline)))
 )
 
+(func $prelude.log_error (param $value anyref)
+  (call $prelude._write_fd_string (i32.const 2) (call $prelude.to_string (local.get $value)))
+  (call $prelude._write_fd_string (i32.const 2) (global.get $const_newline))
+)
+
+(func $prelude.eprint (param $text anyref)
+  (call $prelude._write_fd_string (i32.const 2) (local.get $text))
+)
+
 (func $prelude.string_length (param $str anyref) (result i64)
   (call $prelude.str_len (local.get $str))
 )
@@ -518,6 +586,90 @@
   (call $prelude._new_string_copy (local.get $ptr) (local.get $length))
 )
 
+;; [ptr, ptr+len) が正しい UTF-8 なら 1 を返す。Go の utf8.Valid と同じ規則で検査する。
+(func $prelude._valid_utf8 (param $ptr i32) (param $len i32) (result i32)
+  (local $i i32)
+  (local $b i32)
+  (local $need i32)
+  (local $lo i32)
+  (local $hi i32)
+  (local $c i32)
+  (local $k i32)
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+      (local.set $b (i32.load8_u (i32.add (local.get $ptr) (local.get $i))))
+      (if (i32.lt_u (local.get $b) (i32.const 0x80))
+        (then
+          (local.set $i (i32.add (local.get $i) (i32.const 1)))
+          (br $loop)
+        )
+      )
+      (local.set $lo (i32.const 0x80))
+      (local.set $hi (i32.const 0xBF))
+      (if (i32.lt_u (local.get $b) (i32.const 0xC2))
+        (then (return (i32.const 0)))
+      )
+      (if (i32.lt_u (local.get $b) (i32.const 0xE0))
+        (then (local.set $need (i32.const 1)))
+        (else
+          (if (i32.lt_u (local.get $b) (i32.const 0xF0))
+            (then
+              (local.set $need (i32.const 2))
+              ;; overlong と サロゲート
+              (if (i32.eq (local.get $b) (i32.const 0xE0))
+                (then (local.set $lo (i32.const 0xA0)))
+              )
+              (if (i32.eq (local.get $b) (i32.const 0xED))
+                (then (local.set $hi (i32.const 0x9F)))
+              )
+            )
+            (else
+              (if (i32.ge_u (local.get $b) (i32.const 0xF5))
+                (then (return (i32.const 0)))
+              )
+              (local.set $need (i32.const 3))
+              ;; overlong と U+10FFFF 超え
+              (if (i32.eq (local.get $b) (i32.const 0xF0))
+                (then (local.set $lo (i32.const 0x90)))
+              )
+              (if (i32.eq (local.get $b) (i32.const 0xF4))
+                (then (local.set $hi (i32.const 0x8F)))
+              )
+            )
+          )
+        )
+      )
+      (if (i32.ge_u (i32.add (local.get $i) (local.get $need)) (local.get $len))
+        (then (return (i32.const 0)))
+      )
+      (local.set $c (i32.load8_u (i32.add (local.get $ptr) (i32.add (local.get $i) (i32.const 1)))))
+      (if (i32.or
+            (i32.lt_u (local.get $c) (local.get $lo))
+            (i32.gt_u (local.get $c) (local.get $hi)))
+        (then (return (i32.const 0)))
+      )
+      (local.set $k (i32.const 2))
+      (block $cont_done
+        (loop $cont
+          (br_if $cont_done (i32.gt_u (local.get $k) (local.get $need)))
+          (local.set $c (i32.load8_u (i32.add (local.get $ptr) (i32.add (local.get $i) (local.get $k)))))
+          (if (i32.or
+                (i32.lt_u (local.get $c) (i32.const 0x80))
+                (i32.gt_u (local.get $c) (i32.const 0xBF)))
+            (then (return (i32.const 0)))
+          )
+          (local.set $k (i32.add (local.get $k) (i32.const 1)))
+          (br $cont)
+        )
+      )
+      (local.set $i (i32.add (local.get $i) (i32.add (local.get $need) (i32.const 1))))
+      (br $loop)
+    )
+  )
+  (i32.const 1)
+)
+
 (func $prelude.intern_string (param $ptr i32) (param $length i32) (result anyref)
   (call $prelude._new_string_copy (local.get $ptr) (local.get $length))
 )
@@ -2,6 +2,7 @@
 
 (import "runtime" "run_formatter" (func $runtime._host_run_formatter (param externref) (result externref)))
 (import "runtime" "run_sandbox" (func $runtime._host_run_sandbox (param externref) (result externref)))
+(import "runtime" "run_sandbox_with" (func $runtime._host_run_sandbox_with (param externref externref) (result externref)))
 
 (func $runtime.run_formatter (param $source anyref) (result anyref)
   (call $interop.to_gc
@@ -14,3 +15,10 @@
     (call $runtime._host_run_sandbox
       (call $interop.to_host (local.get $source))))
 )
+
+(func $runtime.run_sandbox_with (param $source anyref) (param $options anyref) (result anyref)
+  (call $interop.to_gc
+    (call $runtime._host_run_sandbox_with
+      (call $interop.to_host (local.get $source))
+      (call $interop.to_host (local.get $options))))
+)
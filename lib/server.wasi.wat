@@ -0,0 +1,152 @@
+;; Server module functions for --target=wasi.
+;; get_args / get_env read argv and environ, exit calls proc_exit through wasi_snapshot_preview1.
+
+(import "wasi_snapshot_preview1" "args_sizes_get"
+  (func $server.wasi_args_sizes_get (param i32 i32) (result i32)))
+(import "wasi_snapshot_preview1" "args_get"
+  (func $server.wasi_args_get (param i32 i32) (result i32)))
+(import "wasi_snapshot_preview1" "environ_sizes_get"
+  (func $server.wasi_environ_sizes_get (param i32 i32) (result i32)))
+(import "wasi_snapshot_preview1" "environ_get"
+  (func $server.wasi_environ_get (param i32 i32) (result i32)))
+(import "wasi_snapshot_preview1" "proc_exit"
+  (func $server.wasi_proc_exit (param i32)))
+
+;; proc_exit はそのまま実行を終える。
+(func $server.exit (param $code i64)
+  (call $server.wasi_proc_exit (i32.wrap_i64 (local.get $code)))
+)
+
+;; WASI のモジュールにはホスト側の GC が無いので何もしない。
+(func $server.gc
+)
+
+(func $server._cstr_len (param $ptr i32) (result i32)
+  (local $len i32)
+  (block $done
+    (loop $loop
+      (br_if $done
+        (i32.eqz (i32.load8_u (i32.add (local.get $ptr) (local.get $len)))))
+      (local.set $len (i32.add (local.get $len) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (local.get $len)
+)
+
+;; argv[0]（プログラム名）を除いた引数を返す。
+(func $server.get_args (result anyref)
+  (local $sizes i32)
+  (local $count i32)
+  (local $ptrs i32)
+  (local $buf i32)
+  (local $out anyref)
+  (local $i i32)
+  (local $arg i32)
+  (local.set $sizes (call $prelude._alloc (i32.const 8)))
+  (if (call $server.wasi_args_sizes_get
+        (local.get $sizes)
+        (i32.add (local.get $sizes) (i32.const 4)))
+    (then (return (call $prelude.arr_new (i32.const 0))))
+  )
+  (local.set $count (i32.load (local.get $sizes)))
+  (if (i32.le_u (local.get $count) (i32.const 1))
+    (then (return (call $prelude.arr_new (i32.const 0))))
+  )
+  (local.set $ptrs (call $prelude._alloc (i32.shl (local.get $count) (i32.const 2))))
+  (local.set $buf (call $prelude._alloc (i32.load (i32.add (local.get $sizes) (i32.const 4)))))
+  (if (call $server.wasi_args_get (local.get $ptrs) (local.get $buf))
+    (then (return (call $prelude.arr_new (i32.const 0))))
+  )
+  (local.set $out (call $prelude.arr_new (i32.sub (local.get $count) (i32.const 1))))
+  (local.set $i (i32.const 1))
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $count)))
+      (local.set $arg
+        (i32.load (i32.add (local.get $ptrs) (i32.shl (local.get $i) (i32.const 2)))))
+      (call $prelude.arr_set
+        (local.get $out)
+        (i32.sub (local.get $i) (i32.const 1))
+        (call $prelude._new_string_owned
+          (local.get $arg)
+          (call $server._cstr_len (local.get $arg))))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (local.get $out)
+)
+
+;; environ の "NAME=value" から name の値を返す。無ければ空文字列。
+(func $server.get_env (param $name anyref) (result anyref)
+  (local $name_ptr i32)
+  (local $name_len i32)
+  (local $sizes i32)
+  (local $count i32)
+  (local $ptrs i32)
+  (local $buf i32)
+  (local $i i32)
+  (local $entry i32)
+  (local $entry_len i32)
+  (local.set $name_ptr (call $prelude._string_ptr (local.get $name)))
+  (local.set $name_len (call $prelude._string_bytelen (local.get $name)))
+  (local.set $sizes (call $prelude._alloc (i32.const 8)))
+  (if (call $server.wasi_environ_sizes_get
+        (local.get $sizes)
+        (i32.add (local.get $sizes) (i32.const 4)))
+    (then (return (call $prelude.str_from_utf8 (i32.const 0) (i32.const 0))))
+  )
+  (local.set $count (i32.load (local.get $sizes)))
+  (local.set $ptrs (call $prelude._alloc (i32.shl (local.get $count) (i32.const 2))))
+  (local.set $buf (call $prelude._alloc (i32.load (i32.add (local.get $sizes) (i32.const 4)))))
+  (if (call $server.wasi_environ_get (local.get $ptrs) (local.get $buf))
+    (then (return (call $prelude.str_from_utf8 (i32.const 0) (i32.const 0))))
+  )
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $count)))
+      (local.set $entry
+        (i32.load (i32.add (local.get $ptrs) (i32.shl (local.get $i) (i32.const 2)))))
+      (local.set $entry_len (call $server._cstr_len (local.get $entry)))
+      (if (i32.and
+            (i32.gt_u (local.get $entry_len) (local.get $name_len))
+            (i32.eq
+              (i32.load8_u (i32.add (local.get $entry) (local.get $name_len)))
+              (i32.const 61)))
+        (then
+          (if (call $server._bytes_eq
+                (local.get $entry)
+                (local.get $name_ptr)
+                (local.get $name_len))
+            (then
+              (return
+                (call $prelude._new_string_owned
+                  (i32.add (local.get $entry) (i32.add (local.get $name_len) (i32.const 1)))
+                  (i32.sub (local.get $entry_len) (i32.add (local.get $name_len) (i32.const 1))))))
+          )
+        )
+      )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (call $prelude.str_from_utf8 (i32.const 0) (i32.const 0))
+)
+
+(func $server._bytes_eq (param $a i32) (param $b i32) (param $len i32) (result i32)
+  (local $i i32)
+  (block $done
+    (loop $loop
+      (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+      (if (i32.ne
+            (i32.load8_u (i32.add (local.get $a) (local.get $i)))
+            (i32.load8_u (i32.add (local.get $b) (local.get $i))))
+        (then (return (i32.const 0)))
+      )
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br $loop)
+    )
+  )
+  (i32.const 1)
+)
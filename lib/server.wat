@@ -3,11 +3,16 @@
 (import "server" "get_args" (func $server._host_get_args (result externref)))
 (import "server" "get_env" (func $server._host_get_env (param externref) (result externref)))
 (import "server" "gc" (func $server._host_gc))
+(import "server" "exit" (func $server._host_exit (param i64)))
 
 (func $server.gc
   (call $server._host_gc)
 )
 
+(func $server.exit (param $code i64)
+  (call $server._host_exit (local.get $code))
+)
+
 (func $server.get_args (result anyref)
   (call $interop.to_gc (call $server._host_get_args))
 )
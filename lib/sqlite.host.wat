@@ -4,10 +4,19 @@
 (import "host" "sqlite_db_open" (func $host.sqlite_db_open (param externref) (result externref)))
 (import "server" "sql_exec" (func $sqlite._host_sql_exec (param i32 i32) (result externref)))
 (import "server" "register_tables" (func $sqlite._host_register_tables (param i32 i32)))
-(import "server" "sql_query" (func $sqlite._host_sql_query (param i32 i32 externref) (result externref)))
-(import "server" "sql_fetch_one" (func $sqlite._host_sql_fetch_one (param i32 i32 externref) (result externref)))
-(import "server" "sql_fetch_optional" (func $sqlite._host_sql_fetch_optional (param i32 i32 externref) (result externref)))
-(import "server" "sql_execute" (func $sqlite._host_sql_execute (param i32 i32 externref) (result externref)))
+(import "server" "sql_query" (func $sqlite._host_sql_query (param i32 i32 externref i32 i32 externref) (result externref)))
+(import "server" "sql_fetch_one" (func $sqlite._host_sql_fetch_one (param i32 i32 externref i32 i32 externref) (result externref)))
+(import "server" "sql_fetch_optional" (func $sqlite._host_sql_fetch_optional (param i32 i32 externref i32 i32 externref) (result externref)))
+(import "server" "sql_execute" (func $sqlite._host_sql_execute (param i32 i32 externref externref) (result externref)))
+(import "server" "sql_connect" (func $sqlite._host_sql_connect (param externref externref i32) (result externref)))
+(import "server" "sql_tx_begin" (func $sqlite._host_sql_tx_begin (result externref)))
+(import "server" "sql_tx_commit" (func $sqlite._host_sql_tx_commit (result externref)))
+(import "server" "sql_tx_rollback" (func $sqlite._host_sql_tx_rollback))
+(import "server" "sql_select" (func $sqlite._host_sql_select (param externref i32) (result externref)))
+(import "server" "sql_cursor_open" (func $sqlite._host_sql_cursor_open (param i32 i32 externref i32 i32 externref) (result externref)))
+(import "server" "sql_cursor_next" (func $sqlite._host_sql_cursor_next (param externref) (result externref)))
+(import "server" "sql_cursor_close" (func $sqlite._host_sql_cursor_close (param externref)))
+(import "server" "sql_select_page" (func $sqlite._host_sql_select_page (param externref externref externref i64) (result externref)))
 
 (func $sqlite.sql_exec (param $ptr i32) (param $len i32) (result anyref)
   (call $interop.to_gc
@@ -19,53 +28,123 @@
   (call $sqlite._host_register_tables (local.get $ptr) (local.get $len))
 )
 
-(func $sqlite.sql_query (param $ptr i32) (param $len i32) (param $params anyref) (result anyref)
+(func $sqlite.sql_query (param $ptr i32) (param $len i32) (param $params anyref) (param $types_ptr i32) (param $types_len i32) (param $conn anyref) (result anyref)
   (call $interop.to_gc
     (call $sqlite._host_sql_query
       (local.get $ptr)
       (local.get $len)
       (call $interop.to_host (local.get $params))
+      (local.get $types_ptr)
+      (local.get $types_len)
+      (call $interop.to_host (local.get $conn))
     )
   )
 )
 
-(func $sqlite.sql_fetch_one (param $ptr i32) (param $len i32) (param $params anyref) (result anyref)
+(func $sqlite.sql_fetch_one (param $ptr i32) (param $len i32) (param $params anyref) (param $types_ptr i32) (param $types_len i32) (param $conn anyref) (result anyref)
   (call $interop.to_gc
     (call $sqlite._host_sql_fetch_one
       (local.get $ptr)
       (local.get $len)
       (call $interop.to_host (local.get $params))
+      (local.get $types_ptr)
+      (local.get $types_len)
+      (call $interop.to_host (local.get $conn))
     )
   )
 )
 
-(func $sqlite.sql_fetch_optional (param $ptr i32) (param $len i32) (param $params anyref) (result anyref)
+(func $sqlite.sql_fetch_optional (param $ptr i32) (param $len i32) (param $params anyref) (param $types_ptr i32) (param $types_len i32) (param $conn anyref) (result anyref)
   (call $interop.to_gc
     (call $sqlite._host_sql_fetch_optional
       (local.get $ptr)
       (local.get $len)
       (call $interop.to_host (local.get $params))
+      (local.get $types_ptr)
+      (local.get $types_len)
+      (call $interop.to_host (local.get $conn))
     )
   )
 )
 
-(func $sqlite.sql_execute (param $ptr i32) (param $len i32) (param $params anyref) (result anyref)
+(func $sqlite.sql_execute (param $ptr i32) (param $len i32) (param $params anyref) (param $conn anyref) (result anyref)
   (call $interop.to_gc
     (call $sqlite._host_sql_execute
       (local.get $ptr)
       (local.get $len)
       (call $interop.to_host (local.get $params))
+      (call $interop.to_host (local.get $conn))
     )
   )
 )
 
+;; transaction { ... } の開始・コミット・ロールバック（入れ子はセーブポイント）
+(func $sqlite.sql_tx_begin (result anyref)
+  (call $interop.to_gc (call $sqlite._host_sql_tx_begin))
+)
+
+(func $sqlite.sql_tx_commit (result anyref)
+  (call $interop.to_gc (call $sqlite._host_sql_tx_commit))
+)
+
+(func $sqlite.sql_tx_rollback
+  (call $sqlite._host_sql_tx_rollback)
+)
+
+;; クエリビルダーの実行（mode: 0 = query_all, 1 = query_one, 2 = query_optional）
+(func $sqlite.query_all (param $q anyref) (result anyref)
+  (call $interop.to_gc (call $sqlite._host_sql_select (call $interop.to_host (local.get $q)) (i32.const 0)))
+)
+
+(func $sqlite.query_one (param $q anyref) (result anyref)
+  (call $interop.to_gc (call $sqlite._host_sql_select (call $interop.to_host (local.get $q)) (i32.const 1)))
+)
+
+(func $sqlite.query_optional (param $q anyref) (result anyref)
+  (call $interop.to_gc (call $sqlite._host_sql_select (call $interop.to_host (local.get $q)) (i32.const 2)))
+)
+
+;; キーセットページング（query_page）
+(func $sqlite.query_page (param $q anyref) (param $key anyref) (param $after anyref) (param $size i64) (result anyref)
+  (call $interop.to_gc
+    (call $sqlite._host_sql_select_page
+      (call $interop.to_host (local.get $q))
+      (call $interop.to_host (local.get $key))
+      (call $interop.to_host (local.get $after))
+      (local.get $size)
+    )
+  )
+)
+
+;; fetch_iter のカーソル（open はカーソルか error、next は行・null（終端）・error を返す）
+(func $sqlite.sql_cursor_open (param $ptr i32) (param $len i32) (param $params anyref) (param $types_ptr i32) (param $types_len i32) (param $conn anyref) (result anyref)
+  (call $interop.to_gc
+    (call $sqlite._host_sql_cursor_open
+      (local.get $ptr)
+      (local.get $len)
+      (call $interop.to_host (local.get $params))
+      (local.get $types_ptr)
+      (local.get $types_len)
+      (call $interop.to_host (local.get $conn))
+    )
+  )
+)
+
+(func $sqlite.sql_cursor_next (param $cursor anyref) (result anyref)
+  (call $interop.to_gc (call $sqlite._host_sql_cursor_next (call $interop.to_host (local.get $cursor))))
+)
+
+(func $sqlite.sql_cursor_close (param $cursor anyref)
+  (call $sqlite._host_sql_cursor_close (call $interop.to_host (local.get $cursor)))
+)
+
 ;; sqlQuery wrapper (intrinsic fallback)
 (func $sqlite.sqlQuery (param $query anyref) (param $params anyref) (result anyref)
   (local $ptr i32)
   (local $len i32)
   (local.set $ptr (call $prelude._string_ptr (local.get $query)))
   (local.set $len (call $prelude._string_bytelen (local.get $query)))
-  (call $sqlite.sql_query (local.get $ptr) (local.get $len) (local.get $params))
+  (call $sqlite.sql_query (local.get $ptr) (local.get $len) (local.get $params) (i32.const 0) (i32.const 0) (call $prelude.val_undefined))
 )
 
 (func $sqlite.db_open (param $filename anyref) (result anyref)
@@ -73,3 +152,12 @@
     (call $host.sqlite_db_open
       (call $interop.to_host (local.get $filename))))
 )
+
+;; db_connect は filename のSQLiteファイルを名前付き接続として開く。
+(func $sqlite.db_connect (param $name anyref) (param $filename anyref) (result anyref)
+  (call $interop.to_gc
+    (call $sqlite._host_sql_connect
+      (call $interop.to_host (local.get $name))
+      (call $interop.to_host (local.get $filename))
+      (i32.const 0)))
+)
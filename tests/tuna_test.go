@@ -17,6 +17,11 @@ import (
 //	// expect: line2
 //
 // If no expect comments are found, the test only verifies that the file compiles and runs without error.
+//
+// Files are run on the gc backend. A file can list the backends to run on, which
+// all have to produce the same output:
+//
+//	// backends: gc host
 func TestTunaFiles(t *testing.T) {
 	if !runtimeAvailable() {
 		t.Skip("CGO が無効なためテストをスキップします")
@@ -35,27 +40,34 @@ func TestTunaFiles(t *testing.T) {
 		}
 
 		name := entry.Name()
-		t.Run(name, func(t *testing.T) {
-			path := filepath.Join(testsDir, name)
-			runTunaTest(t, path)
-		})
+		path := filepath.Join(testsDir, name)
+		src, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		for _, backend := range extractBackends(string(src)) {
+			testName := name
+			if backend != compiler.BackendGC {
+				testName += "@" + string(backend)
+			}
+			t.Run(testName, func(t *testing.T) {
+				runTunaTest(t, path, string(src), backend)
+			})
+		}
 	}
 }
 
-func runTunaTest(t *testing.T, path string) {
+func runTunaTest(t *testing.T, path string, src string, backend compiler.Backend) {
 	t.Helper()
 
-	// Read the source file
-	src, err := os.ReadFile(path)
-	if err != nil {
-		t.Fatalf("failed to read file: %v", err)
-	}
-
 	// Extract expected output from comments
-	expected := extractExpectedOutput(string(src))
+	expected := extractExpectedOutput(src)
 
 	// Compile
 	comp := compiler.New()
+	if err := comp.SetBackend(backend); err != nil {
+		t.Fatalf("set backend failed: %v", err)
+	}
 	res, err := comp.Compile(path)
 	if err != nil {
 		t.Fatalf("compilation failed: %v", err)
@@ -93,3 +105,19 @@ func extractExpectedOutput(src string) string {
 	}
 	return strings.Join(lines, "\n") + "\n"
 }
+
+// extractBackends returns the backends listed in a "// backends: " comment (gc if none).
+func extractBackends(src string) []compiler.Backend {
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "// backends:") {
+			continue
+		}
+		var backends []compiler.Backend
+		for _, name := range strings.Fields(strings.TrimPrefix(trimmed, "// backends:")) {
+			backends = append(backends, compiler.Backend(name))
+		}
+		return backends
+	}
+	return []compiler.Backend{compiler.BackendGC}
+}
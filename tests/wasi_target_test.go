@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tuna/internal/compiler"
+	"tuna/internal/runtime"
+)
+
+func compileAndRunWASI(t *testing.T, dir, src string, args []string) (string, error) {
+	t.Helper()
+
+	path := filepath.Join(dir, "main.tuna")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	comp := compiler.New()
+	if err := comp.SetTarget(compiler.TargetWASI); err != nil {
+		t.Fatal(err)
+	}
+	res, err := comp.Compile(path)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	runner := runtime.NewRunner()
+	return runner.RunWithArgs(res.Wasm, args)
+}
+
+func TestTargetWASIArgsEnvAndFiles(t *testing.T) {
+	if !runtimeAvailable() {
+		t.Skip("CGO が無効なためテストをスキップします")
+	}
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("TUNA_WASI_TEST", "from-env")
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b.txt", "a.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, "data", name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bom.txt"), []byte("\xef\xbb\xbfbom"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := `
+import { log } from "prelude"
+import { stringify } from "json"
+import { get_args, get_env } from "server"
+import { read_text, write_text, append_text, read_dir, exists } from "file"
+
+export function main(): void | error {
+  log(stringify(get_args()))
+  log(get_env("TUNA_WASI_TEST"))
+  log(stringify(get_env("TUNA_WASI_MISSING")))
+  const written: undefined = write_text("out.txt", "hello")?
+  const appended: undefined = append_text("out.txt", " world")?
+  const text: string = read_text("out.txt")?
+  log(text)
+  const bom: string = read_text("bom.txt")?
+  log(bom)
+  const names: string[] = read_dir("data")?
+  log(stringify(names))
+  log(exists("out.txt"))
+  log(exists("missing.txt"))
+  const missing: string | error = read_text("missing.txt")
+  switch (missing) {
+    case s as string: log(s)
+    case e as error: log(e.message)
+  }
+  return undefined
+}
+`
+	out, err := compileAndRunWASI(t, dir, src, []string{"one", "two words"})
+	if err != nil {
+		t.Fatalf("runtime error: %v", err)
+	}
+	want := `["one","two words"]
+from-env
+""
+hello world
+bom
+["a.txt","b.txt"]
+true
+false
+open missing.txt: no such file or directory
+`
+	if out != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out, want)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello world" {
+		t.Fatalf("unexpected file content: %q", content)
+	}
+}
+
+func TestTargetWASIMainErrorExitsWithStatus1(t *testing.T) {
+	if !runtimeAvailable() {
+		t.Skip("CGO が無効なためテストをスキップします")
+	}
+
+	src := `
+import { log } from "prelude"
+
+export function main(): void | error {
+  log("before")
+  return error("boom")
+}
+`
+	out, err := compileAndRunWASI(t, t.TempDir(), src, nil)
+	var exitErr *runtime.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected exit status 1, got %v", err)
+	}
+	if out != "before\n" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}